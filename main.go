@@ -6,16 +6,22 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/blobstore"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/config"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/database"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/handlers"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/keyhash"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/logging"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/middleware"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/oidc"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
 	"github.com/rs/zerolog/log"
 )
@@ -30,6 +36,11 @@ func main() {
 		Format: cfg.LogFormat,
 	})
 
+	// Schema management subcommand: webhooks-server migrate up|down|status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, os.Args[2:]))
+	}
+
 	log.Info().
 		Int("port", cfg.Port).
 		Str("log_level", cfg.LogLevel).
@@ -51,22 +62,123 @@ func main() {
 		log.Fatal().Err(err).Msg("failed to initialize JWT secret")
 	}
 
-	// Initialize encryption (optional — empty key disables)
-	encryptor, err := services.NewEncryptor(cfg.EncryptionKey)
+	// Initialize encryption (optional — no keys disables)
+	encryptionKeys, err := services.LoadEncryptionKeys(services.EncryptionKeySources{
+		Key:      cfg.EncryptionKey,
+		KeyFile:  cfg.EncryptionKeyFile,
+		Keys:     cfg.EncryptionKeys,
+		KeysFile: cfg.EncryptionKeysFile,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid encryption keys")
+	}
+	encryptor, err := services.NewEncryptorWithKeyring(services.EncryptorConfig{
+		Keys:        encryptionKeys,
+		ActiveKeyID: cfg.EncryptionActiveKeyID,
+		Strict:      cfg.EncryptionStrict,
+	})
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to initialize encryption")
 	}
 	if encryptor != nil {
-		log.Info().Msg("event data encryption enabled (AES-256-GCM)")
+		log.Info().
+			Str("active_key", encryptor.ActiveKeyID()).
+			Int("keys", len(encryptionKeys)).
+			Bool("strict", cfg.EncryptionStrict).
+			Msg("event data encryption enabled (AES-256-GCM, per-pair data keys)")
 	} else {
 		log.Info().Msg("event data encryption disabled (ENCRYPTION_KEY not set)")
 	}
 
-	// Initialize services
-	keyService := services.NewKeyService(db.GetPool())
-	eventService := services.NewEventServiceWithEncryption(db.GetPool(), encryptor)
-	adminService := services.NewAdminService(db.GetPool())
-	cleanupService := services.NewCleanupService(db.GetPool(), cfg.EnableAutoCleanup)
+	// Keys are stored as HMACs under this pepper; changing it invalidates every key
+	keyHasher, err := keyhash.New(cfg.KeyHashPepper)
+	if err != nil {
+		log.Fatal().Err(err).Msg("KEY_HASH_PEPPER is missing or too short, generate one with: openssl rand -hex 32")
+	}
+
+	// Initialize repositories and services
+	repos := db.Repositories()
+	keyService := services.NewKeyService(repos.Keys, repos.Users, repos.Events, repos.WebhookLogs, keyHasher)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Minute)
+	hashed, err := keyService.HashLegacyKeys(ctx)
+	cancel()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to hash stored keys")
+	}
+	if hashed > 0 {
+		log.Info().Int("keys", hashed).Msg("hashed keys stored in the clear")
+	}
+	keyService.SetRetentionDefaults(services.RetentionPolicy{
+		Unprocessed: cfg.EventTTL,
+		Processed:   cfg.ProcessedEventTTL,
+	})
+	eventService := services.NewEventServiceWithEncryption(repos.Events, encryptor)
+	if cfg.E2EEncryptionEnabled {
+		// Experimental: the published plugin does not register keys or open sealed events yet
+		log.Warn().Msg("end-to-end encryption is enabled; only clients that can open sealed events should register a key")
+		eventService.SetE2EKeyLookup(keyService)
+	}
+	dataKeyService := services.NewDataKeyService(repos.DataKeys, encryptor) // nil when encryption is off
+	eventService.SetDataKeys(dataKeyService)
+	eventService.SetCompression(cfg.CompressPayloads)
+	adminService := services.NewAdminService(repos.Admins)
+	adminService.SetEncryptor(encryptor) // TOTP secrets are encrypted like event payloads
+	adminService.RequireTOTP(cfg.AdminRequire2FA)
+	apiTokenService := services.NewAPITokenService(repos.APITokens, repos.Users)
+	sessionService := services.NewSessionService(repos.Sessions, repos.Users)
+	adminService.SetSessions(sessionService) // role changes sign the admin out
+	middleware.SetAdminSessions(sessionService)
+	auditService := services.NewAuditService(repos.Audit)
+	accountService := services.NewAccountService(repos.Users, keyService, eventService)
+	accountService.SetAPITokens(apiTokenService)
+	accountService.SetSessions(sessionService)
+	accountService.SetAudit(auditService)
+	cleanupSchedule, err := services.ParseSchedule(cfg.CleanupSchedule)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid CLEANUP_SCHEDULE")
+	}
+	cleanupService := services.NewCleanupService(services.CleanupConfig{
+		Enabled:    cfg.EnableAutoCleanup,
+		Schedule:   cleanupSchedule,
+		BatchSize:  cfg.CleanupBatchSize,
+		RunOnStart: cfg.CleanupRunOnStart,
+	})
+	cleanupService.Register(services.ExpiredEventsTask(repos.Events))
+	cleanupService.Register(services.OrphanedWebhookLogsTask(repos.WebhookLogs))
+	cleanupService.Register(services.ExpiredMagicLinksTask(repos.AuthTokens))
+	cleanupService.Register(services.ExpiredKeyRotationsTask(repos.Keys))
+	cleanupService.Register(services.ExpiredAPITokensTask(repos.APITokens, services.APITokenKeepFor))
+	cleanupService.Register(services.ExpiredSessionsTask(repos.Sessions))
+	if cfg.InactiveKeyTTL > 0 {
+		cleanupService.Register(services.InactiveKeysTask(repos.Keys, cfg.InactiveKeyTTL))
+	}
+	blobStore, err := blobstore.New(blobstore.Config{
+		Backend: cfg.BlobStore,
+		Path:    cfg.BlobStorePath,
+		S3: blobstore.S3Config{
+			Endpoint:        cfg.BlobS3Endpoint,
+			Region:          cfg.BlobS3Region,
+			Bucket:          cfg.BlobS3Bucket,
+			Prefix:          cfg.BlobS3Prefix,
+			AccessKeyID:     cfg.BlobS3AccessKeyID,
+			SecretAccessKey: cfg.BlobS3SecretAccessKey,
+		},
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to initialize blob store")
+	}
+	if blobStore != nil {
+		eventService.SetBlobStore(blobStore, cfg.BlobThresholdKB*1024)
+		cleanupService.Register(services.OrphanedBlobsTask(repos.Events, blobStore, services.DefaultBlobGCGrace))
+		log.Info().
+			Str("backend", cfg.BlobStore).
+			Int("threshold_kb", cfg.BlobThresholdKB).
+			Msg("large payloads offloaded to blob store")
+	} else if cfg.MaxUploadMB > 10 {
+		log.Warn().Int("max_upload_mb", cfg.MaxUploadMB).Msg("MAX_UPLOAD_MB above 10 without BLOB_STORE keeps large payloads in the database")
+	}
+	reencryptionService := services.NewReencryptionService(repos.Events, encryptor, cfg.CleanupBatchSize)
+	reencryptionService.SetDataKeys(dataKeyService)
 
 	// Auto-seed admin user on first run (if ADMIN_USERNAME and ADMIN_PASSWORD are set)
 	if cfg.AdminUsername != "" && cfg.AdminPassword != "" {
@@ -87,16 +199,30 @@ func main() {
 	var mailerliteService *services.MailerLiteService
 	var authService *services.AuthService
 
-	if cfg.MailgunAPIKey != "" && cfg.MailgunDomain != "" {
-		emailService = services.NewEmailService(
-			cfg.MailgunDomain,
-			cfg.MailgunAPIKey,
-			cfg.MailgunFromEmail,
-			cfg.MailgunFromName,
-		)
-		log.Info().Str("domain", cfg.MailgunDomain).Msg("Mailgun email service initialized")
+	emailSender, err := services.NewEmailSender(services.EmailSenderConfig{
+		Transport:     cfg.EmailTransport,
+		MailgunDomain: cfg.MailgunDomain,
+		MailgunAPIKey: cfg.MailgunAPIKey,
+		SMTP: services.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			TLS:      cfg.SMTPTLS,
+		},
+		Dir: cfg.EmailFileDir,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to initialize email transport")
+	}
+	if emailSender != nil {
+		emailService = services.NewEmailService(emailSender, cfg.EmailFromAddress, cfg.EmailFromName)
+		log.Info().Str("transport", cfg.EmailTransport).Msg("email service initialized")
+		if cfg.EmailTransport == services.EmailTransportLog {
+			log.Warn().Msg("EMAIL_TRANSPORT=log writes magic links to the log - do not use in production")
+		}
 	} else {
-		log.Warn().Msg("Mailgun credentials not configured - email authentication disabled")
+		log.Warn().Msg("EMAIL_TRANSPORT not configured - email authentication disabled")
 	}
 
 	if cfg.MailerLiteAPIKey != "" {
@@ -111,13 +237,62 @@ func main() {
 	}
 
 	authService = services.NewAuthService(
-		db.GetPool(),
+		repos.Users,
+		repos.Keys,
+		repos.AuthTokens,
+		keyHasher,
 		cfg.JWTSecret,
 		cfg.MagicLinkExpiry,
 		cfg.MagicLinkBaseURL,
 	)
 	log.Info().Int("expiry_seconds", cfg.MagicLinkExpiry).Msg("Auth service initialized")
 
+	// Single sign-on through an OpenID Connect provider
+	var oidcService *services.OIDCService
+	if cfg.OIDCIssuer != "" {
+		if cfg.OIDCClientID == "" {
+			log.Fatal().Msg("OIDC_ISSUER is set but OIDC_CLIENT_ID is not")
+		}
+		provider := oidc.NewProvider(oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       splitList(cfg.OIDCScopes),
+		})
+		// An unreachable provider is retried on the first sign-in
+		discoverCtx, discoverCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := provider.Discover(discoverCtx); err != nil {
+			log.Warn().Err(err).Str("issuer", cfg.OIDCIssuer).Msg("OIDC discovery failed - will retry on sign-in")
+		}
+		discoverCancel()
+
+		oidcService = services.NewOIDCService(provider, authService, cfg.JWTSecret)
+		oidcService.SetAutoProvision(cfg.OIDCAutoProvision)
+		oidcService.SetAllowedDomains(splitList(cfg.OIDCAllowedDomains))
+		if cfg.OIDCAdminLogin {
+			oidcService.SetAdmins(adminService)
+		}
+		log.Info().Str("issuer", cfg.OIDCIssuer).Bool("admins", cfg.OIDCAdminLogin).Msg("OIDC single sign-on enabled")
+	}
+
+	// Stuck delivery alerts for key owners and admins
+	deliveryAlertService := services.NewDeliveryAlertService(repos.Events, repos.Keys, repos.Users, services.DeliveryAlertConfig{
+		Enabled:     cfg.DeliveryAlertsEnabled,
+		Interval:    cfg.DeliveryAlertInterval,
+		MinAge:      cfg.DeliveryAlertAfter,
+		MinCount:    cfg.DeliveryAlertMinEvents,
+		Cooldown:    cfg.DeliveryAlertCooldown,
+		BaseURL:     cfg.MagicLinkBaseURL,
+		AdminEmails: splitList(cfg.DeliveryAlertAdminEmails),
+		WebhookURL:  cfg.DeliveryAlertWebhookURL,
+	})
+	if emailService != nil {
+		deliveryAlertService.SetEmailService(emailService)
+	} else if cfg.DeliveryAlertsEnabled && cfg.DeliveryAlertWebhookURL == "" {
+		log.Warn().Msg("DELIVERY_ALERTS_ENABLED is set without EMAIL_TRANSPORT or DELIVERY_ALERT_WEBHOOK_URL - nobody will be alerted")
+	}
+
 	// Initialize Analytics Service
 	analyticsService, err := services.NewAnalyticsService(services.AnalyticsConfig{
 		PostHogAPIKey: cfg.PostHogAPIKey,
@@ -136,7 +311,11 @@ func main() {
 	}
 
 	// Start background services
-	go cleanupService.Start(context.Background())
+	cleanupService.Start(context.Background())
+	if cfg.EncryptionReencrypt {
+		reencryptionService.Start(context.Background())
+	}
+	deliveryAlertService.Start(context.Background())
 
 	// Create Gin router
 	router := gin.New()
@@ -172,7 +351,7 @@ func main() {
 	router.Use(cors.New(corsConfig))
 
 	// Setup routes
-	setupRoutes(router, db, keyService, eventService, adminService, analyticsService, emailService, mailerliteService, authService, cfg)
+	setupRoutes(router, db, keyService, eventService, adminService, cleanupService, deliveryAlertService, analyticsService, emailService, mailerliteService, authService, oidcService, apiTokenService, sessionService, auditService, accountService, cfg)
 
 	// Create HTTP server with timeouts (G112: protect from Slowloris attack)
 	srv := &http.Server{
@@ -200,6 +379,8 @@ func main() {
 
 	// Stop cleanup service
 	cleanupService.Stop()
+	reencryptionService.Stop()
+	deliveryAlertService.Stop()
 
 	// Graceful shutdown with timeout
 	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
@@ -212,25 +393,55 @@ func main() {
 	log.Info().Msg("server shut down successfully")
 }
 
-func setupRoutes(router *gin.Engine, db *database.Database, keyService *services.KeyService, eventService *services.EventService, adminService *services.AdminService, analyticsService *services.AnalyticsService, emailService *services.EmailService, mailerliteService *services.MailerLiteService, authService *services.AuthService, cfg *config.Config) {
+func setupRoutes(router *gin.Engine, db *database.Database, keyService *services.KeyService, eventService *services.EventService, adminService *services.AdminService, cleanupService *services.CleanupService, deliveryAlertService *services.DeliveryAlertService, analyticsService *services.AnalyticsService, emailService *services.EmailService, mailerliteService *services.MailerLiteService, authService *services.AuthService, oidcService *services.OIDCService, apiTokenService *services.APITokenService, sessionService *services.SessionService, auditService *services.AuditService, accountService *services.AccountService, cfg *config.Config) {
 	// Initialize handlers
 	healthHandler := handlers.NewHealthHandler(db)
 	webhookHandler := handlers.NewWebhookHandler(keyService, eventService, analyticsService)
+	webhookHandler.SetMaxBodySize(int64(cfg.MaxUploadMB) << 20)
 	sseHandler := handlers.NewSSEHandler(keyService, eventService, cfg.AllowedOrigins)
 	ackHandler := handlers.NewACKHandler(keyService, eventService)
+	mcpHandler := handlers.NewMCPHandler(keyService, eventService)
+	e2eHandler := handlers.NewE2EHandler(keyService)
 
 	// Wire up SSE broadcaster for real-time event delivery
 	webhookHandler.SetBroadcaster(sseHandler)
+	mcpHandler.SetBroadcaster(sseHandler)
+
+	// Push scheduled events to connected clients once they become due
+	go sseHandler.StartScheduledDelivery(context.Background(), 15*time.Second)
 	dashboardHandler := handlers.NewDashboardHandler(keyService, eventService)
+	dashboardHandler.SetAudit(auditService)
 	adminHandler := handlers.NewAdminHandler(keyService, adminService, eventService)
-	// Email authentication handlers (only if services are configured)
+	adminHandler.SetCleanupService(cleanupService)
+	adminHandler.SetDeliveryAlerts(deliveryAlertService)
+	adminHandler.SetSessions(sessionService)
+	adminHandler.SetAudit(auditService)
+	// User sign-in handlers: magic links need email, single sign-on a provider
 	var authHandler *handlers.AuthHandler
+	var oidcHandler *handlers.OIDCHandler
 	var dashboardHandlerNew *handlers.DashboardHandler
 	if emailService != nil && authService != nil {
 		authHandler = handlers.NewAuthHandler(authService, emailService, mailerliteService, analyticsService)
-		dashboardHandlerNew = handlers.NewDashboardHandlerWithAuth(db.GetPool(), authService, keyService)
+		authHandler.SetSessions(sessionService)
+		authHandler.SetAudit(auditService)
 		log.Info().Msg("Email authentication handlers initialized")
 	}
+	if oidcService != nil {
+		oidcHandler = handlers.NewOIDCHandler(oidcService, authService, cfg.OIDCProviderName)
+		oidcHandler.SetAdminHandler(adminHandler)
+		oidcHandler.SetSessions(sessionService)
+		oidcHandler.SetAudit(auditService)
+		oidcHandler.SetMagicLinkEnabled(authHandler != nil)
+	}
+	if (authHandler != nil || oidcHandler != nil) && authService != nil {
+		dashboardHandlerNew = handlers.NewDashboardHandlerWithAuth(authService, keyService)
+		dashboardHandlerNew.SetEventService(eventService)
+		dashboardHandlerNew.SetAPITokens(apiTokenService)
+		dashboardHandlerNew.SetSessions(sessionService)
+		dashboardHandlerNew.SetAudit(auditService)
+		dashboardHandlerNew.SetAccounts(accountService)
+		dashboardHandlerNew.SetMailerLite(mailerliteService)
+	}
 
 	// Landing pages (English)
 	router.GET("/", func(c *gin.Context) {
@@ -311,10 +522,21 @@ func setupRoutes(router *gin.Engine, db *database.Database, keyService *services
 		middleware.NewRateLimitingMiddleware(middleware.RateLimitConfig{
 			RequestsPerMinute: 100,
 			Burst:             20,
+			KeyLimit:          middleware.WebhookKeyRateLimit,
 		}),
 		middleware.WebhookSignatureMiddleware(cfg.WebhookSecret, cfg.EnableWebhookSignatureVerification),
 		webhookHandler.HandleWebhook)
 
+	// MCP endpoint (Model Context Protocol, streamable HTTP transport) for AI agents
+	mcpRateLimiter := middleware.NewRateLimitingMiddleware(middleware.RateLimitConfig{
+		RequestsPerMinute: 100,
+		Burst:             20,
+		KeyLimit:          middleware.WebhookKeyRateLimit,
+	})
+	// Tool calls can add notes, so a key with its own signing secret needs a signed request here too
+	router.POST("/mcp/:webhook_key", middleware.ValidateWebhookKey(keyService), mcpRateLimiter, middleware.KeySignatureMiddleware(), mcpHandler.HandleMCP)
+	router.GET("/mcp/:webhook_key", middleware.ValidateWebhookKey(keyService), mcpHandler.HandleMCPStream)
+
 	// SSE endpoint (for streaming and polling)
 	router.GET("/events/:client_key", middleware.ValidateClientKey(keyService), sseHandler.HandleSSE)
 
@@ -324,21 +546,65 @@ func setupRoutes(router *gin.Engine, db *database.Database, keyService *services
 	// ACK endpoint
 	router.POST("/ack/:client_key/:event_id", middleware.ValidateClientKey(keyService), ackHandler.HandleACK)
 
+	// End-to-end encryption: plugin registers the public key events are sealed to
+	if cfg.E2EEncryptionEnabled {
+		router.GET("/e2e/:client_key", middleware.ValidateClientKey(keyService), e2eHandler.HandleGetE2E)
+		router.PUT("/e2e/:client_key", middleware.ValidateClientKey(keyService), e2eHandler.HandleSetE2EKey)
+		router.DELETE("/e2e/:client_key", middleware.ValidateClientKey(keyService), e2eHandler.HandleDeleteE2EKey)
+	}
+
+	// Admin permissions by role: owner has all, operator all but managing
+	// admins and the audit log, support only reads (see models.AdminRole)
+	adminAuth := middleware.AdminAuthMiddleware()
+	canRead := middleware.RequireAdminPermission(models.AdminPermissionRead)
+	canManageKeys := middleware.RequireAdminPermission(models.AdminPermissionManageKeys)
+	canManageEvents := middleware.RequireAdminPermission(models.AdminPermissionManageEvents)
+	canManageAdmins := middleware.RequireAdminPermission(models.AdminPermissionManageAdmins)
+	canViewAudit := middleware.RequireAdminPermission(models.AdminPermissionViewAudit)
+
 	// Dashboard endpoints (require admin authentication)
-	router.GET("/dashboard/events", middleware.AdminAuthMiddleware(), dashboardHandler.HandleGetEvents)
-	router.DELETE("/dashboard/events/:event_id", middleware.AdminAuthMiddleware(), dashboardHandler.HandleDeleteEvent)
+	router.GET("/dashboard/events", adminAuth, canRead, dashboardHandler.HandleGetEvents)
+	router.DELETE("/dashboard/events/:event_id", adminAuth, canManageEvents, dashboardHandler.HandleDeleteEvent)
 
 	// Admin authentication endpoints
 	router.POST("/admin/login", adminHandler.HandleAdminLogin)
-	router.POST("/admin/logout", middleware.AdminAuthMiddleware(), adminHandler.HandleAdminLogout)
-	router.GET("/admin/status", middleware.AdminAuthMiddleware(), adminHandler.HandleAdminStatus)
-
-	// Admin endpoints (all require authentication)
-	router.POST("/admin/activate", middleware.AdminAuthMiddleware(), adminHandler.HandleActivateLicense)
-	router.POST("/admin/deactivate", middleware.AdminAuthMiddleware(), adminHandler.HandleDeactivateLicense)
-	router.GET("/admin/keys", middleware.AdminAuthMiddleware(), adminHandler.HandleListKeys)
-	router.GET("/admin/users", middleware.AdminAuthMiddleware(), adminHandler.HandleListUsers)
-	router.GET("/admin/alerts/undelivered", middleware.AdminAuthMiddleware(), adminHandler.HandleUndeliveredAlerts)
+	router.POST("/admin/login/totp", middleware.TwoFactorRateLimitMiddleware(), adminHandler.HandleAdminLoginTOTP)
+	router.POST("/admin/login/totp/enroll", middleware.TwoFactorRateLimitMiddleware(), adminHandler.HandleAdminLoginTOTPEnroll)
+	router.POST("/admin/invite/accept", middleware.AuthRateLimitMiddleware(), adminHandler.HandleAcceptAdminInvite)
+	// Every role manages its own sign-in
+	router.POST("/admin/logout", adminAuth, adminHandler.HandleAdminLogout)
+	router.GET("/admin/status", adminAuth, adminHandler.HandleAdminStatus)
+	totpLimit := middleware.TwoFactorRateLimitMiddleware()
+	router.GET("/admin/totp", adminAuth, adminHandler.HandleTOTPStatus)
+	router.POST("/admin/totp/enroll", adminAuth, adminHandler.HandleTOTPEnroll)
+	router.POST("/admin/totp/confirm", totpLimit, adminAuth, adminHandler.HandleTOTPConfirm)
+	router.POST("/admin/totp/recovery-codes", totpLimit, adminAuth, adminHandler.HandleTOTPRecoveryCodes)
+	router.POST("/admin/totp/disable", totpLimit, adminAuth, adminHandler.HandleTOTPDisable)
+
+	// Admin endpoints (all require authentication and a permission)
+	router.POST("/admin/activate", adminAuth, canManageKeys, adminHandler.HandleActivateLicense)
+	router.POST("/admin/deactivate", adminAuth, canManageKeys, adminHandler.HandleDeactivateLicense)
+	router.GET("/admin/keys", adminAuth, canRead, adminHandler.HandleListKeys)
+	router.PUT("/admin/keys/webhook/:key_id/paths", adminAuth, canManageKeys, adminHandler.HandleSetWebhookKeyPaths)
+	router.GET("/admin/users", adminAuth, canRead, adminHandler.HandleListUsers)
+	router.GET("/admin/alerts/undelivered", adminAuth, canRead, adminHandler.HandleUndeliveredAlerts)
+	router.GET("/admin/alerts/stuck", adminAuth, canRead, adminHandler.HandleStuckDeliveries)
+	router.GET("/admin/stats/storage", adminAuth, canRead, adminHandler.HandleStorageStats)
+	router.GET("/admin/cleanup", adminAuth, canRead, adminHandler.HandleCleanupStatus)
+	router.POST("/admin/cleanup/run", adminAuth, canManageEvents, adminHandler.HandleRunCleanup)
+
+	// Admin management (owners only)
+	router.GET("/admin/admins", adminAuth, canManageAdmins, adminHandler.HandleListAdmins)
+	router.POST("/admin/admins", adminAuth, canManageAdmins, adminHandler.HandleInviteAdmin)
+	router.PUT("/admin/admins/:admin_id/role", adminAuth, canManageAdmins, adminHandler.HandleChangeAdminRole)
+	router.POST("/admin/admins/:admin_id/deactivate", adminAuth, canManageAdmins, adminHandler.HandleDeactivateAdmin)
+	router.POST("/admin/admins/:admin_id/activate", adminAuth, canManageAdmins, adminHandler.HandleActivateAdmin)
+
+	// Audit log (owners only)
+	router.GET("/admin/audit", adminAuth, canViewAudit, adminHandler.HandleListAudit)
+	router.GET("/admin/audit/export", adminAuth, canViewAudit, adminHandler.HandleExportAudit)
+	router.GET("/admin/audit/verify", adminAuth, canViewAudit, adminHandler.HandleVerifyAudit)
+
 	// Admin dashboard (serve static files and admin panel HTML)
 	router.Static("/static", "./static")
 	router.Static("/assets", "./src/templates/assets")
@@ -349,7 +615,7 @@ func setupRoutes(router *gin.Engine, db *database.Database, keyService *services
 	router.StaticFile("/sitemap.xml", "./static/sitemap.xml")
 
 	// Email authentication routes (only if handlers are configured)
-	if authHandler != nil && dashboardHandlerNew != nil {
+	if authHandler != nil {
 		// Auth endpoints with rate limiting (3 requests per minute per IP)
 		authGroup := router.Group("/auth")
 		authGroup.Use(middleware.AuthRateLimitMiddleware())
@@ -360,6 +626,18 @@ func setupRoutes(router *gin.Engine, db *database.Database, keyService *services
 
 		// Magic link verification (no rate limit - single-use tokens)
 		router.GET("/auth/verify", authHandler.HandleVerifyMagicLink)
+	}
+
+	// Single sign-on routes (no rate limit - the provider's codes are single-use)
+	if oidcHandler != nil {
+		router.GET("/auth/methods", oidcHandler.HandleAuthMethods)
+		router.GET("/auth/oidc/login", oidcHandler.HandleLogin)
+		router.GET("/auth/oidc/callback", oidcHandler.HandleCallback)
+		router.GET("/admin/login/oidc", oidcHandler.HandleAdminLogin)
+	}
+
+	// Dashboard routes (with either sign-in method)
+	if dashboardHandlerNew != nil {
 
 		// Logout endpoint
 		router.POST("/auth/logout", dashboardHandlerNew.HandleLogout)
@@ -369,12 +647,45 @@ func setupRoutes(router *gin.Engine, db *database.Database, keyService *services
 		router.GET("/dashboard/api/me", dashboardHandlerNew.HandleGetUserData)
 		router.GET("/dashboard/api/logs", dashboardHandlerNew.HandleGetLogs)
 		router.POST("/dashboard/api/revoke", dashboardHandlerNew.HandleRevokeKeys)
+		router.POST("/dashboard/api/retention", dashboardHandlerNew.HandleUpdateRetention)
 		router.POST("/dashboard/api/keys/new", dashboardHandlerNew.HandleCreateNewKeyPair)
-
-		log.Info().Msg("Email authentication routes registered")
+		router.POST("/dashboard/api/keys/rotate", dashboardHandlerNew.HandleRotateKey)
+		router.POST("/dashboard/api/keys/webhook", dashboardHandlerNew.HandleCreateWebhookKey)
+		router.PUT("/dashboard/api/keys/webhook/:key_id", dashboardHandlerNew.HandleUpdateWebhookKey)
+		router.DELETE("/dashboard/api/keys/webhook/:key_id", dashboardHandlerNew.HandleRevokeWebhookKey)
+		router.GET("/dashboard/api/events", dashboardHandlerNew.HandleGetUserEvents)
+		router.DELETE("/dashboard/api/events/:event_id", dashboardHandlerNew.HandleDeleteUserEvent)
+
+		// Personal access tokens; managed with the session cookie only
+		router.GET("/dashboard/api/tokens", dashboardHandlerNew.HandleListTokens)
+		router.POST("/dashboard/api/tokens", dashboardHandlerNew.HandleCreateToken)
+		router.DELETE("/dashboard/api/tokens/:token_id", dashboardHandlerNew.HandleRevokeToken)
+
+		// Signed-in browsers; also managed with the session cookie only
+		router.GET("/dashboard/api/sessions", dashboardHandlerNew.HandleListSessions)
+		router.DELETE("/dashboard/api/sessions/:session_id", dashboardHandlerNew.HandleRevokeSession)
+		router.POST("/dashboard/api/sessions/revoke-all", dashboardHandlerNew.HandleRevokeAllSessions)
+
+		// The user's own audit trail
+		router.GET("/dashboard/api/audit", dashboardHandlerNew.HandleListUserAudit)
+		router.GET("/dashboard/api/audit/export", dashboardHandlerNew.HandleExportUserAudit)
+
+		// Data export and account deletion; session cookie only
+		router.POST("/dashboard/api/export", dashboardHandlerNew.HandleExportAccount)
+		router.DELETE("/dashboard/api/account", dashboardHandlerNew.HandleDeleteAccount)
+
+		// Notification settings; session cookie only
+		router.POST("/dashboard/api/alerts", dashboardHandlerNew.HandleUpdateAlerts)
+
+		log.Info().Msg("Dashboard routes registered")
 	}
 }
 
+// splitList splits a space or comma separated setting
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+}
+
 func formatPort(port int) string {
 	return fmt.Sprintf("%d", port)
 }
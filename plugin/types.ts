@@ -22,6 +22,9 @@ export interface WebhookEvent {
 	/** ISO 8601 timestamp when the event was created on the server */
 	created_at: string;
 
+	/** Write mode chosen by the sender (e.g. MCP append_to_note); overrides defaultMode */
+	mode?: "append" | "overwrite";
+
 	/** Whether the event has been acknowledged (processed) by the client */
 	processed?: boolean;
 }
@@ -8,9 +8,13 @@
 
 -- Drop existing tables for clean state (safe for parallel execution)
 -- Note: CASCADE automatically drops dependent views
+DROP TABLE IF EXISTS audit_events CASCADE;
+DROP TABLE IF EXISTS admin_recovery_codes CASCADE;
+DROP TABLE IF EXISTS sessions CASCADE;
 DROP TABLE IF EXISTS webhook_logs CASCADE;
 DROP TABLE IF EXISTS events CASCADE;
 DROP TABLE IF EXISTS api_keys CASCADE;
+DROP TABLE IF EXISTS users CASCADE;
 DROP TABLE IF EXISTS admin_users CASCADE;
 
 -- admin_users table
@@ -20,28 +24,51 @@ CREATE TABLE admin_users (
     password_hash VARCHAR(255) NOT NULL,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
     last_login TIMESTAMP,
-    is_active BOOLEAN NOT NULL DEFAULT true
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    totp_secret BYTEA,
+    totp_enabled_at TIMESTAMP,
+    role VARCHAR(20) NOT NULL DEFAULT 'owner',
+    invite_token_hash VARCHAR(64) UNIQUE,
+    invite_expires_at TIMESTAMP
+);
+
+-- admin_recovery_codes table
+CREATE TABLE admin_recovery_codes (
+    admin_id UUID NOT NULL REFERENCES admin_users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    PRIMARY KEY (admin_id, code_hash)
+);
+
+-- users table
+CREATE TABLE users (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email VARCHAR(255) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    preferred_language VARCHAR(10) NOT NULL DEFAULT 'en',
+    email_verified BOOLEAN NOT NULL DEFAULT false,
+    magic_link_token VARCHAR(255),
+    magic_link_expires_at TIMESTAMP,
+    magic_link_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
 -- api_keys table (unified webhook + client keys)
 CREATE TABLE api_keys (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-    key_value VARCHAR(255) UNIQUE NOT NULL,
+    key_hash VARCHAR(255) UNIQUE NOT NULL,
+    key_prefix VARCHAR(16),
     key_type VARCHAR(20) NOT NULL,
     pair_id UUID,
     is_active BOOLEAN NOT NULL DEFAULT true,
     activated_at TIMESTAMP NOT NULL DEFAULT NOW(),
 
-    -- User information (email authentication)
-    user_email VARCHAR(255),
-    user_name VARCHAR(255),
-    email_verified BOOLEAN NOT NULL DEFAULT false,
-    magic_link_token VARCHAR(255),
-    magic_link_expires_at TIMESTAMP,
-    magic_link_used_at TIMESTAMP,
+    -- Owning user (email authentication)
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
 
     -- Event retention settings
-    event_ttl_days INTEGER NOT NULL DEFAULT 30,
+    event_ttl_days INTEGER,
+    processed_ttl_days INTEGER,
 
     -- Audit and usage tracking
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
@@ -55,7 +82,8 @@ CREATE TABLE api_keys (
 CREATE VIEW webhook_keys AS
 SELECT
     id,
-    key_value,
+    key_hash,
+    key_prefix,
     CASE WHEN is_active THEN 'active' ELSE 'inactive' END as status,
     created_at,
     last_used,
@@ -66,7 +94,8 @@ WHERE key_type = 'webhook';
 CREATE VIEW client_keys AS
 SELECT
     id,
-    key_value,
+    key_hash,
+    key_prefix,
     pair_id as webhook_key_id,
     CASE WHEN is_active THEN 'active' ELSE 'inactive' END as status,
     created_at,
@@ -84,7 +113,8 @@ CREATE TABLE events (
     processed BOOLEAN NOT NULL DEFAULT false,
     processed_at TIMESTAMP,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-    expires_at TIMESTAMP NOT NULL
+    expires_at TIMESTAMP NOT NULL,
+    deliver_after TIMESTAMP
 );
 
 -- webhook_logs table
@@ -104,18 +134,54 @@ CREATE TABLE webhook_logs (
     CONSTRAINT delivery_status_check CHECK (delivery_status IN ('pending', 'delivered', 'failed', 'acked'))
 );
 
+-- sessions table
+CREATE TABLE sessions (
+    id UUID PRIMARY KEY,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    admin_id UUID REFERENCES admin_users(id) ON DELETE CASCADE,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    CONSTRAINT session_owner_check CHECK ((user_id IS NULL) <> (admin_id IS NULL))
+);
+
+-- audit_events table
+CREATE TABLE audit_events (
+    seq BIGINT PRIMARY KEY,
+    id UUID NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    actor_type VARCHAR(20) NOT NULL,
+    actor_id VARCHAR(255) NOT NULL DEFAULT '',
+    actor_name VARCHAR(255) NOT NULL DEFAULT '',
+    action VARCHAR(64) NOT NULL,
+    target_type VARCHAR(32) NOT NULL DEFAULT '',
+    target_id VARCHAR(255) NOT NULL DEFAULT '',
+    user_email VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    request_id VARCHAR(128) NOT NULL DEFAULT '',
+    before_state TEXT,
+    after_state TEXT,
+    prev_hash VARCHAR(64) NOT NULL,
+    hash VARCHAR(64) NOT NULL
+);
+
 -- Indexes for performance
-CREATE INDEX idx_api_keys_key_value ON api_keys(key_value);
+CREATE INDEX idx_api_keys_key_hash ON api_keys(key_hash);
 CREATE INDEX idx_api_keys_type ON api_keys(key_type);
 CREATE INDEX idx_api_keys_pair_id ON api_keys(pair_id);
-CREATE INDEX idx_api_keys_user_email ON api_keys(user_email);
-CREATE INDEX idx_api_keys_magic_link_token ON api_keys(magic_link_token);
-CREATE INDEX idx_api_keys_email_verified ON api_keys(email_verified);
+CREATE INDEX idx_api_keys_user_id ON api_keys(user_id);
+CREATE INDEX idx_users_magic_link_token ON users(magic_link_token);
 CREATE INDEX idx_events_webhook_key_id ON events(webhook_key_id);
 CREATE INDEX idx_events_processed ON events(processed);
 CREATE INDEX idx_events_expires_at ON events(expires_at);
 CREATE INDEX idx_webhook_logs_event_id ON webhook_logs(event_id);
 CREATE INDEX idx_webhook_logs_webhook_key_id ON webhook_logs(webhook_key_id);
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
+CREATE INDEX idx_audit_events_user_email ON audit_events(user_email);
 
 -- ============================================================================
 -- Test Helper Functions
@@ -127,41 +193,43 @@ BEGIN
     TRUNCATE webhook_logs CASCADE;
     TRUNCATE events CASCADE;
     TRUNCATE api_keys CASCADE;
+    TRUNCATE users CASCADE;
     TRUNCATE admin_users CASCADE;
+    TRUNCATE audit_events;
 END;
 $$ LANGUAGE plpgsql;
 
--- Create a test key pair (returns webhook_key_id, client_key_id, webhook_key_value, client_key_value)
+-- Create a test key pair from keys hashed by the caller (returns webhook_key_id, client_key_id)
 -- Note: pair_id for webhook key equals its own id (matching production behavior)
 -- Note: pair_id for client key equals the webhook key's id (for proper linking)
 -- Parameters kept for backward compatibility but ignored (legacy from Telegram auth)
 CREATE OR REPLACE FUNCTION create_test_key_pair(
+    p_webhook_key_hash VARCHAR,
+    p_webhook_key_prefix VARCHAR,
+    p_client_key_hash VARCHAR,
+    p_client_key_prefix VARCHAR,
     p_legacy_id BIGINT DEFAULT NULL,
     p_legacy_name VARCHAR DEFAULT NULL
 )
 RETURNS TABLE(
     webhook_key_id UUID,
     client_key_id UUID,
-    webhook_key_value VARCHAR,
-    client_key_value VARCHAR,
     pair_id UUID
 ) AS $$
 DECLARE
     v_webhook_key_id UUID := gen_random_uuid();
     v_client_key_id UUID;
-    v_webhook_key_value VARCHAR := 'wh_test_' || substr(md5(random()::text), 1, 24);
-    v_client_key_value VARCHAR := 'ck_test_' || substr(md5(random()::text), 1, 24);
 BEGIN
     -- Create webhook key with pair_id = id (matching production behavior)
-    INSERT INTO api_keys (id, key_value, key_type, pair_id)
-    VALUES (v_webhook_key_id, v_webhook_key_value, 'webhook', v_webhook_key_id);
+    INSERT INTO api_keys (id, key_hash, key_prefix, key_type, pair_id)
+    VALUES (v_webhook_key_id, p_webhook_key_hash, p_webhook_key_prefix, 'webhook', v_webhook_key_id);
 
     -- Create client key with pair_id = webhook_key_id (for proper linking)
-    INSERT INTO api_keys (key_value, key_type, pair_id)
-    VALUES (v_client_key_value, 'client', v_webhook_key_id)
+    INSERT INTO api_keys (key_hash, key_prefix, key_type, pair_id)
+    VALUES (p_client_key_hash, p_client_key_prefix, 'client', v_webhook_key_id)
     RETURNING id INTO v_client_key_id;
 
-    RETURN QUERY SELECT v_webhook_key_id, v_client_key_id, v_webhook_key_value, v_client_key_value, v_webhook_key_id;
+    RETURN QUERY SELECT v_webhook_key_id, v_client_key_id, v_webhook_key_id;
 END;
 $$ LANGUAGE plpgsql;
 
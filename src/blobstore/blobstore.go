@@ -0,0 +1,75 @@
+// Package blobstore keeps large event payloads outside the database.
+//
+// A Store holds opaque objects under short keys. The server names objects by
+// the SHA-256 of their content and encrypts them before they reach the store,
+// so backends only need to move bytes.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("blob not found")
+
+// Object describes a stored object
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Store is a blob storage backend
+type Store interface {
+	// Put stores size bytes read from r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Open returns a reader for an object, or ErrNotFound
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes an object; deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+	// Walk calls fn for every stored object until fn returns an error
+	Walk(ctx context.Context, fn func(Object) error) error
+}
+
+// Config selects and configures a backend
+type Config struct {
+	Backend string // "fs" or "s3"; empty disables the blob store
+	Path    string // root directory for the fs backend
+	S3      S3Config
+}
+
+// New creates the configured store. Returns nil if no backend is configured.
+func New(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case "fs":
+		return NewFSStore(cfg.Path)
+	case "s3":
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown blob store backend %q (use fs or s3)", cfg.Backend)
+	}
+}
+
+// keyRe restricts keys to names that are safe as file names and URL paths
+var keyRe = regexp.MustCompile(`^[A-Za-z0-9_-]{4,128}$`)
+
+// validateKey rejects keys that could escape the store's namespace
+func validateKey(key string) error {
+	if !keyRe.MatchString(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
+
+// shard returns the directory an object is grouped under, so no single
+// directory or listing prefix grows too large
+func shard(key string) string {
+	return key[:2]
+}
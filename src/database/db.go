@@ -2,23 +2,61 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/postgres"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/sqlite"
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
 )
 
-// Database holds the PostgreSQL connection pool
+// sqliteScheme selects the SQLite backend, e.g. sqlite:///data/webhooks.db
+const sqliteScheme = "sqlite://"
+
+// Database holds the connection for the configured storage backend.
+// Exactly one of pool (PostgreSQL) and sqlDB (SQLite) is set.
 type Database struct {
-	pool *pgxpool.Pool
+	pool  *pgxpool.Pool
+	sqlDB *sql.DB
+	repos *repositories.Repositories
 }
 
-// New creates a new database connection
+// New connects to the database and applies all pending migrations
 func New(ctx context.Context, databaseURL string) (*Database, error) {
+	db, err := Open(ctx, databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := db.MigrateUp(ctx)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+	if len(applied) > 0 {
+		log.Printf("Applied %d database migration(s)", len(applied))
+	}
+
+	return db, nil
+}
+
+// Open connects to the database without touching the schema,
+// choosing the backend by URL scheme
+func Open(ctx context.Context, databaseURL string) (*Database, error) {
+	if strings.HasPrefix(databaseURL, sqliteScheme) {
+		return openSQLite(strings.TrimPrefix(databaseURL, sqliteScheme))
+	}
+	return openPostgres(ctx, databaseURL)
+}
+
+// openPostgres creates the PostgreSQL connection pool
+func openPostgres(ctx context.Context, databaseURL string) (*Database, error) {
 	config, err := pgxpool.ParseConfig(databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse database URL: %w", err)
@@ -35,15 +73,25 @@ func New(ctx context.Context, databaseURL string) (*Database, error) {
 		return nil, fmt.Errorf("failed to create connection pool: %w", err)
 	}
 
-	db := &Database{pool: pool}
+	return &Database{pool: pool, repos: postgres.New(pool)}, nil
+}
+
+// openSQLite opens (or creates) a SQLite database file
+func openSQLite(path string) (*Database, error) {
+	if path == "" {
+		return nil, fmt.Errorf("failed to parse database URL: missing SQLite file path")
+	}
 
-	// Initialize schema
-	if err := db.initializeSchema(ctx); err != nil {
-		pool.Close()
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	dsn := "file:" + path + "?_time_format=sqlite&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	sqlDB, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
 	}
 
-	return db, nil
+	// SQLite allows a single writer; one connection also keeps :memory: databases shared
+	sqlDB.SetMaxOpenConns(1)
+
+	return &Database{sqlDB: sqlDB, repos: sqlite.New(sqlDB)}, nil
 }
 
 // Close closes the database connection pool
@@ -51,101 +99,47 @@ func (db *Database) Close() {
 	if db.pool != nil {
 		db.pool.Close()
 	}
+	if db.sqlDB != nil {
+		_ = db.sqlDB.Close()
+	}
 }
 
-// GetPool returns the connection pool
+// GetPool returns the PostgreSQL connection pool (nil for SQLite)
 func (db *Database) GetPool() *pgxpool.Pool {
 	return db.pool
 }
 
-// initializeSchema reads and executes schema.sql
-func (db *Database) initializeSchema(ctx context.Context) error {
-	// Try to read schema.sql from multiple locations
-	schemaPath := "schema.sql"
-
-	content, err := os.ReadFile(schemaPath)
-	if err != nil {
-		// Try from current working directory
-		content, err = os.ReadFile(filepath.Join(".", schemaPath))
-		if err != nil {
-			// Try from root directory
-			content, err = os.ReadFile(filepath.Join("/", schemaPath))
-			if err != nil {
-				return fmt.Errorf("failed to read schema.sql: %w", err)
-			}
-		}
-	}
-
-	// Execute schema
-	_, err = db.pool.Exec(ctx, string(content))
-	if err != nil {
-		return fmt.Errorf("failed to execute schema: %w", err)
-	}
-
-	// Run migrations
-	if err := db.runMigrations(ctx); err != nil {
-		return fmt.Errorf("failed to run migrations: %w", err)
-	}
-
-	log.Println("Database schema initialized successfully")
-	return nil
-}
-
-// runMigrations runs database migrations
-func (db *Database) runMigrations(ctx context.Context) error {
-	// Migration 1: Add event_ttl_days column if it doesn't exist
-	_, err := db.pool.Exec(ctx, `
-		ALTER TABLE api_keys
-		ADD COLUMN IF NOT EXISTS event_ttl_days INTEGER DEFAULT 30;
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to add event_ttl_days column: %w", err)
-	}
-
-	// Migration 2: Update ALL records to ensure key_type and is_active are set correctly
-	result, err := db.pool.Exec(ctx, `
-		UPDATE api_keys
-		SET
-			key_type = CASE
-				WHEN key_value LIKE 'wh_%' THEN 'webhook'
-				WHEN key_value LIKE 'ck_%' THEN 'client'
-				ELSE 'webhook'
-			END,
-			is_active = COALESCE(is_active, true)
-	`)
-	if err != nil {
-		log.Printf("Migration WARNING: failed to update api_keys: %v", err)
-	} else if result.RowsAffected() > 0 {
-		log.Printf("Migration: Updated %d api_keys records", result.RowsAffected())
-	}
-
-	log.Println("Migrations completed successfully")
-	return nil
+// Repositories returns the repository implementations for the active backend
+func (db *Database) Repositories() *repositories.Repositories {
+	return db.repos
 }
 
 // Health checks if the database is healthy
 func (db *Database) Health(ctx context.Context) error {
-	if db == nil || db.pool == nil {
+	if db == nil || (db.pool == nil && db.sqlDB == nil) {
 		return fmt.Errorf("database connection not initialized")
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	if db.sqlDB != nil {
+		return db.sqlDB.PingContext(ctx)
+	}
 	return db.pool.Ping(ctx)
 }
 
-// QueryRow executes a query and returns a single row
+// QueryRow executes a query and returns a single row (PostgreSQL only)
 func (db *Database) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
 	return db.pool.QueryRow(ctx, sql, args...)
 }
 
-// Query executes a query and returns rows
+// Query executes a query and returns rows (PostgreSQL only)
 func (db *Database) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
 	return db.pool.Query(ctx, sql, args...)
 }
 
-// Exec executes a query without returning rows
+// Exec executes a query without returning rows (PostgreSQL only)
 func (db *Database) Exec(ctx context.Context, sql string, args ...interface{}) error {
 	_, err := db.pool.Exec(ctx, sql, args...)
 	return err
@@ -0,0 +1,370 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrationFiles holds the numbered migrations for each backend, in
+// migrations/<backend>/NNNN_name.{up,down}.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationLockID is the pg_advisory_lock key that keeps replicas from
+// applying migrations concurrently
+const migrationLockID int64 = 7_240_611_029
+
+// migrationFileRe matches migration file names and captures version, name and direction
+var migrationFileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time // nil = pending
+}
+
+// migrator applies migrations for one backend while holding its lock
+type migrator interface {
+	// lock blocks until no other process is migrating and creates schema_migrations
+	lock(ctx context.Context) (unlock func(), err error)
+	applied(ctx context.Context) (map[int]time.Time, error)
+	// run executes a migration script and records (up) or removes (down) its version
+	// in one transaction
+	run(ctx context.Context, m Migration, up bool) error
+}
+
+// loadMigrations reads and validates the migrations in dir, ordered by version
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileRe.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be sequential from 1: found %d at position %d", m.Version, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// migrations returns the migrator and migration list for the active backend
+func (db *Database) migrations() (migrator, []Migration, error) {
+	var (
+		mg  migrator
+		dir string
+	)
+	switch {
+	case db.sqlDB != nil:
+		mg, dir = &sqliteMigrator{db: db.sqlDB}, "migrations/sqlite"
+	case db.pool != nil:
+		mg, dir = &postgresMigrator{pool: db.pool}, "migrations/postgres"
+	default:
+		return nil, nil, fmt.Errorf("database connection not initialized")
+	}
+
+	migrations, err := loadMigrations(migrationFiles, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mg, migrations, nil
+}
+
+// MigrateUp applies every pending migration in order and returns the ones applied
+func (db *Database) MigrateUp(ctx context.Context) ([]Migration, error) {
+	mg, migrations, err := db.migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := mg.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := mg.run(ctx, m, true); err != nil {
+			return done, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Migration %04d_%s applied", m.Version, m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown rolls back the given number of most recently applied migrations
+func (db *Database) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	mg, migrations, err := db.migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := mg.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := mg.run(ctx, m, false); err != nil {
+			return done, fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Migration %04d_%s rolled back", m.Version, m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrationStatus lists every known migration and when it was applied
+func (db *Database) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	mg, migrations, err := db.migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := mg.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// postgresMigrator runs migrations on a dedicated connection holding a session advisory lock
+type postgresMigrator struct {
+	pool *pgxpool.Pool
+	conn *pgxpool.Conn
+}
+
+func (pm *postgresMigrator) lock(ctx context.Context) (func(), error) {
+	conn, err := pm.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	pm.conn = conn
+
+	unlock := func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			log.Printf("Failed to release migration lock: %v", err)
+		}
+		conn.Release()
+		pm.conn = nil
+	}
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`); err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return unlock, nil
+}
+
+func (pm *postgresMigrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := pm.conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func (pm *postgresMigrator) run(ctx context.Context, m Migration, up bool) error {
+	tx, err := pm.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	script := m.Down
+	if up {
+		script = m.Up
+	}
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return err
+	}
+
+	if up {
+		_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+	} else {
+		_, err = tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// sqliteMigrator runs migrations on the single SQLite connection; the database
+// file is owned by one process, so no cross-process lock is taken
+type sqliteMigrator struct {
+	db *sql.DB
+}
+
+func (sm *sqliteMigrator) lock(ctx context.Context) (func(), error) {
+	if _, err := sm.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return func() {}, nil
+}
+
+func (sm *sqliteMigrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := sm.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func (sm *sqliteMigrator) run(ctx context.Context, m Migration, up bool) error {
+	tx, err := sm.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	script := m.Down
+	if up {
+		script = m.Up
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,313 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/keyhash"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// openTestSQLite opens an empty SQLite database in a temp directory
+func openTestSQLite(t *testing.T) *Database {
+	t.Helper()
+	db, err := Open(context.Background(), "sqlite://"+filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// TestLoadMigrations_Embedded verifies every backend's migrations are well formed
+func TestLoadMigrations_Embedded(t *testing.T) {
+	for _, dir := range []string{"migrations/postgres", "migrations/sqlite"} {
+		migrations, err := loadMigrations(migrationFiles, dir)
+		if err != nil {
+			t.Fatalf("loadMigrations(%s) failed: %v", dir, err)
+		}
+		if len(migrations) == 0 {
+			t.Errorf("Expected migrations in %s", dir)
+		}
+	}
+}
+
+// TestLoadMigrations_Invalid verifies malformed migration sets are rejected
+func TestLoadMigrations_Invalid(t *testing.T) {
+	sql := &fstest.MapFile{Data: []byte("SELECT 1;")}
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{"missing down", fstest.MapFS{"m/0001_init.up.sql": sql}},
+		{"bad file name", fstest.MapFS{"m/init.sql": sql}},
+		{"version gap", fstest.MapFS{
+			"m/0001_init.up.sql": sql, "m/0001_init.down.sql": sql,
+			"m/0003_next.up.sql": sql, "m/0003_next.down.sql": sql,
+		}},
+		{"conflicting names", fstest.MapFS{"m/0001_init.up.sql": sql, "m/0001_other.down.sql": sql}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadMigrations(tt.files, "m"); err == nil {
+				t.Error("Expected an error, got nil")
+			}
+		})
+	}
+}
+
+// TestMigrate_UpDownStatus runs the full lifecycle against SQLite
+func TestMigrate_UpDownStatus(t *testing.T) {
+	db := openTestSQLite(t)
+	ctx := context.Background()
+
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			t.Errorf("Expected %04d to be pending on a fresh database", s.Version)
+		}
+	}
+
+	applied, err := db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+	if len(applied) != len(statuses) {
+		t.Errorf("Expected %d migrations applied, got %d", len(statuses), len(applied))
+	}
+
+	// Re-running is a no-op
+	applied, err = db.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("Second MigrateUp failed: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected no migrations on second run, got %d", len(applied))
+	}
+
+	rolledBack, err := db.MigrateDown(ctx, 1)
+	if err != nil {
+		t.Fatalf("MigrateDown failed: %v", err)
+	}
+	latest := statuses[len(statuses)-1].Version
+	if len(rolledBack) != 1 || rolledBack[0].Version != latest {
+		t.Fatalf("Expected migration %d rolled back, got %+v", latest, rolledBack)
+	}
+
+	statuses, err = db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	if statuses[len(statuses)-1].AppliedAt != nil {
+		t.Error("Expected the rolled back migration to be pending")
+	}
+
+	if _, err := db.MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp after rollback failed: %v", err)
+	}
+}
+
+// TestMigrate_AdoptsExistingSchema verifies a database created before
+// schema_migrations existed is brought under version control without errors
+func TestMigrate_AdoptsExistingSchema(t *testing.T) {
+	db := openTestSQLite(t)
+	ctx := context.Background()
+
+	migrations, err := loadMigrations(migrationFiles, "migrations/sqlite")
+	if err != nil {
+		t.Fatalf("loadMigrations failed: %v", err)
+	}
+	if _, err := db.sqlDB.ExecContext(ctx, migrations[0].Up); err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+
+	if _, err := db.MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp on existing schema failed: %v", err)
+	}
+}
+
+// TestMigrate_UsersFromKeys verifies the users migration collects the user
+// columns of api_keys into one users row per email and links the keys to it
+func TestMigrate_UsersFromKeys(t *testing.T) {
+	db := openTestSQLite(t)
+	ctx := context.Background()
+
+	if _, err := db.MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	var usersVersion int
+	for _, s := range statuses {
+		if s.Name == "users" {
+			usersVersion = s.Version
+		}
+	}
+	if _, err := db.MigrateDown(ctx, statuses[len(statuses)-1].Version-usersVersion+1); err != nil {
+		t.Fatalf("MigrateDown failed: %v", err)
+	}
+
+	// Two pairs of one user, written the way keys stored users before the migration
+	if _, err := db.sqlDB.ExecContext(ctx, `
+		INSERT INTO api_keys (id, key_value, key_type, pair_id, user_email, user_name, email_verified, preferred_language, magic_link_token, created_at) VALUES
+			('11111111-1111-1111-1111-111111111111', 'wh_old', 'webhook', '11111111-1111-1111-1111-111111111111', 'ann@example.com', 'Ann', 1, 'ru', 'tok', '2024-01-01 00:00:00+00:00'),
+			('22222222-2222-2222-2222-222222222222', 'ck_old', 'client', '11111111-1111-1111-1111-111111111111', 'ann@example.com', 'Ann', 1, 'ru', NULL, '2024-01-01 00:00:00+00:00'),
+			('33333333-3333-3333-3333-333333333333', 'wh_new', 'webhook', '33333333-3333-3333-3333-333333333333', 'ann@example.com', 'Ann B', 1, 'ru', 'tok', '2024-02-01 00:00:00+00:00'),
+			('44444444-4444-4444-4444-444444444444', 'wh_anon', 'webhook', '44444444-4444-4444-4444-444444444444', NULL, NULL, 0, 'en', NULL, '2024-03-01 00:00:00+00:00')
+	`); err != nil {
+		t.Fatalf("Failed to insert legacy keys: %v", err)
+	}
+
+	if _, err := db.MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+
+	var userID, name, language, token string
+	var verified bool
+	if err := db.sqlDB.QueryRowContext(ctx,
+		"SELECT id, name, preferred_language, email_verified, magic_link_token FROM users WHERE email = 'ann@example.com'",
+	).Scan(&userID, &name, &language, &verified, &token); err != nil {
+		t.Fatalf("Failed to read migrated user: %v", err)
+	}
+	if name != "Ann B" || language != "ru" || !verified || token != "tok" {
+		t.Errorf("Unexpected migrated user: name=%q language=%q verified=%v token=%q", name, language, verified, token)
+	}
+
+	var users, linked, unlinked int
+	if err := db.sqlDB.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM users),
+		       (SELECT COUNT(*) FROM api_keys WHERE user_id = ?),
+		       (SELECT COUNT(*) FROM api_keys WHERE user_id IS NULL)
+	`, userID).Scan(&users, &linked, &unlinked); err != nil {
+		t.Fatalf("Failed to count migrated rows: %v", err)
+	}
+	if users != 1 || linked != 3 || unlinked != 1 {
+		t.Errorf("Expected 1 user owning 3 keys and 1 key without a user, got %d users, %d linked, %d unlinked", users, linked, unlinked)
+	}
+}
+
+// TestMigrate_HashesLegacyKeys verifies keys stored in the clear keep working
+// once the hashing migration has run and HashLegacyKeys has replaced them
+func TestMigrate_HashesLegacyKeys(t *testing.T) {
+	db := openTestSQLite(t)
+	ctx := context.Background()
+
+	if _, err := db.MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	var hashVersion int
+	for _, s := range statuses {
+		if s.Name == "key_hashes" {
+			hashVersion = s.Version
+		}
+	}
+	if _, err := db.MigrateDown(ctx, statuses[len(statuses)-1].Version-hashVersion+1); err != nil {
+		t.Fatalf("MigrateDown failed: %v", err)
+	}
+
+	// A pair mid-rotation, its old webhook value still accepted
+	if _, err := db.sqlDB.ExecContext(ctx, `
+		INSERT INTO api_keys (id, key_value, previous_key_value, previous_key_expires_at, key_type, pair_id) VALUES
+			('11111111-1111-1111-1111-111111111111', 'wh_plain_new', 'wh_plain_old', '2999-01-01 00:00:00+00:00', 'webhook', '11111111-1111-1111-1111-111111111111'),
+			('22222222-2222-2222-2222-222222222222', 'ck_plain', NULL, NULL, 'client', '11111111-1111-1111-1111-111111111111')
+	`); err != nil {
+		t.Fatalf("Failed to insert legacy keys: %v", err)
+	}
+	if _, err := db.MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+
+	hasher, err := keyhash.New(TestKeyPepper)
+	if err != nil {
+		t.Fatalf("keyhash.New failed: %v", err)
+	}
+	repos := db.Repositories()
+	ks := services.NewKeyService(repos.Keys, repos.Users, repos.Events, repos.WebhookLogs, hasher)
+	hashed, err := ks.HashLegacyKeys(ctx)
+	if err != nil || hashed != 2 {
+		t.Fatalf("Expected 2 keys hashed, got %d (%v)", hashed, err)
+	}
+	if hashed, err := ks.HashLegacyKeys(ctx); err != nil || hashed != 0 {
+		t.Errorf("Expected nothing left to hash, got %d (%v)", hashed, err)
+	}
+
+	for _, value := range []string{"wh_plain_new", "wh_plain_old"} {
+		if active, err := ks.ValidateWebhookKey(ctx, value); err != nil || !active {
+			t.Errorf("ValidateWebhookKey(%q): %v, %v", value, active, err)
+		}
+	}
+	ck, err := ks.GetClientKeyByValue(ctx, "ck_plain")
+	if err != nil || ck.KeyPrefix != "ck_plain" {
+		t.Fatalf("GetClientKeyByValue: %+v, %v", ck, err)
+	}
+
+	var plain int
+	if err := db.sqlDB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM api_keys WHERE key_hash LIKE '%plain%' OR previous_key_hash LIKE '%plain%'",
+	).Scan(&plain); err != nil {
+		t.Fatalf("Failed to count plain keys: %v", err)
+	}
+	if plain != 0 {
+		t.Errorf("Expected no key values left in the clear, got %d", plain)
+	}
+}
+
+// TestMigrate_APITokenOwners verifies the api_tokens migration links each
+// token to its owner's users row, drops tokens without one and lets deleting
+// the user remove its tokens
+func TestMigrate_APITokenOwners(t *testing.T) {
+	db := openTestSQLite(t)
+	ctx := context.Background()
+
+	if _, err := db.MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+	if _, err := db.MigrateDown(ctx, 1); err != nil {
+		t.Fatalf("MigrateDown failed: %v", err)
+	}
+
+	if _, err := db.sqlDB.ExecContext(ctx, `
+		INSERT INTO users (id, email) VALUES ('11111111-1111-1111-1111-111111111111', 'ann@example.com');
+		INSERT INTO api_tokens (id, user_email, name, prefix, token_hash, scopes) VALUES
+			('22222222-2222-2222-2222-222222222222', 'ann@example.com', 'ci', 'owt_ann', 'hash_ann', 'logs:read'),
+			('33333333-3333-3333-3333-333333333333', 'gone@example.com', 'ci', 'owt_gone', 'hash_gone', 'logs:read');
+	`); err != nil {
+		t.Fatalf("Failed to insert legacy tokens: %v", err)
+	}
+	if _, err := db.MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+
+	var tokens, owned int
+	if err := db.sqlDB.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM api_tokens),
+		       (SELECT COUNT(*) FROM api_tokens WHERE user_id = '11111111-1111-1111-1111-111111111111')
+	`).Scan(&tokens, &owned); err != nil {
+		t.Fatalf("Failed to count migrated tokens: %v", err)
+	}
+	if tokens != 1 || owned != 1 {
+		t.Errorf("Expected only Ann's token to be kept, got %d tokens, %d owned", tokens, owned)
+	}
+
+	if _, err := db.sqlDB.ExecContext(ctx, "DELETE FROM users WHERE email = 'ann@example.com'"); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if err := db.sqlDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM api_tokens").Scan(&tokens); err != nil {
+		t.Fatalf("Failed to count tokens: %v", err)
+	}
+	if tokens != 0 {
+		t.Errorf("Expected deleting the user to delete its tokens, got %d left", tokens)
+	}
+}
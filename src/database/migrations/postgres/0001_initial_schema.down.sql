@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS webhook_logs;
+DROP TABLE IF EXISTS events;
+DROP VIEW IF EXISTS client_keys;
+DROP VIEW IF EXISTS webhook_keys;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS admin_users;
@@ -1,22 +1,6 @@
--- ============================================================================
--- Obsidian Webhooks Selfhosted - Complete Database Schema
--- ============================================================================
--- This file contains the complete, final schema for production deployment.
--- To reset: DROP all tables in Supabase, then run this entire file.
--- ============================================================================
-
--- MIGRATION STEP: Drop old indexes and views on webhook_keys and client_keys if they exist
--- This prevents errors when converting tables to views
-DROP INDEX IF EXISTS idx_webhook_keys_key_value;
-DROP INDEX IF EXISTS idx_webhook_keys_status;
-DROP INDEX IF EXISTS idx_webhook_keys_created_at;
-DROP INDEX IF EXISTS idx_client_keys_key_value;
-DROP INDEX IF EXISTS idx_client_keys_webhook_key_id;
-DROP INDEX IF EXISTS idx_client_keys_status;
-DROP VIEW IF EXISTS webhook_keys CASCADE;
-DROP VIEW IF EXISTS client_keys CASCADE;
-
--- admin_users table - Admin authentication and system management
+-- Baseline schema. Every statement is idempotent so that databases created by
+-- the old schema.sql bootstrap can adopt versioned migrations without changes.
+
 CREATE TABLE IF NOT EXISTS admin_users (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     username VARCHAR(255) UNIQUE NOT NULL,
@@ -26,27 +10,7 @@ CREATE TABLE IF NOT EXISTS admin_users (
     is_active BOOLEAN NOT NULL DEFAULT true
 );
 
--- MIGRATION STEP: Drop old api_keys table if it exists without the new columns
--- This ensures a clean slate for the new schema
-DO $$
-BEGIN
-    IF EXISTS (SELECT FROM pg_tables WHERE schemaname = 'public' AND tablename = 'api_keys') THEN
-        -- Check if the table has the user_email column (new schema)
-        IF NOT EXISTS (
-            SELECT 1 FROM information_schema.columns
-            WHERE table_schema = 'public'
-            AND table_name = 'api_keys'
-            AND column_name = 'user_email'
-        ) THEN
-            -- Old schema, drop and recreate
-            DROP TABLE api_keys CASCADE;
-        END IF;
-    END IF;
-END
-$$;
-
 -- api_keys table - Unified keys for webhooks and SSE clients with user tracking
--- Consolidates webhook_keys and client_keys with user information
 CREATE TABLE IF NOT EXISTS api_keys (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     key_value VARCHAR(255) UNIQUE NOT NULL,
@@ -74,20 +38,10 @@ CREATE TABLE IF NOT EXISTS api_keys (
     CONSTRAINT key_type_check CHECK (key_type IN ('webhook', 'client'))
 );
 
--- MIGRATION STEP: Drop old tables if they exist
-DO $$
-BEGIN
-    DROP TABLE IF EXISTS webhook_keys CASCADE;
-    DROP TABLE IF EXISTS client_keys CASCADE;
-    -- Drop legacy tables from payment/subscription system
-    DROP TABLE IF EXISTS broadcast_responses CASCADE;
-    DROP TABLE IF EXISTS broadcast_recipients CASCADE;
-    DROP TABLE IF EXISTS broadcast_messages CASCADE;
-    DROP TABLE IF EXISTS payments CASCADE;
-END
-$$;
-
--- Keep webhook_keys as a view for backward compatibility
+-- Columns previously added at boot by runMigrations
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS event_ttl_days INTEGER DEFAULT 30;
+
+-- Keep webhook_keys and client_keys as views for backward compatibility
 -- security_invoker = true: view runs with caller's privileges, not creator's (fixes SECURITY DEFINER lint)
 CREATE OR REPLACE VIEW webhook_keys WITH (security_invoker = true) AS
 SELECT
@@ -100,7 +54,6 @@ SELECT
 FROM api_keys
 WHERE key_type = 'webhook';
 
--- Keep client_keys as a view for backward compatibility
 CREATE OR REPLACE VIEW client_keys WITH (security_invoker = true) AS
 SELECT
     id,
@@ -123,13 +76,16 @@ CREATE TABLE IF NOT EXISTS events (
     processed_at TIMESTAMP,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
     expires_at TIMESTAMP NOT NULL,
+    deliver_after TIMESTAMP, -- scheduled delivery (NULL = immediately)
     CONSTRAINT processed_implies_timestamp CHECK (
         (processed = false AND processed_at IS NULL) OR
         (processed = true AND processed_at IS NOT NULL)
     )
 );
 
--- webhook_logs table - Comprehensive webhook delivery and processing logs
+ALTER TABLE events ADD COLUMN IF NOT EXISTS deliver_after TIMESTAMP;
+
+-- webhook_logs table - Webhook delivery and processing logs
 CREATE TABLE IF NOT EXISTS webhook_logs (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
@@ -153,7 +109,7 @@ CREATE TABLE IF NOT EXISTS webhook_logs (
     CONSTRAINT delivery_status_check CHECK (delivery_status IN ('pending', 'delivered', 'failed', 'acked'))
 );
 
--- Create indexes for optimization on api_keys table
+-- Indexes for api_keys
 CREATE INDEX IF NOT EXISTS idx_api_keys_key_value ON api_keys(key_value);
 CREATE INDEX IF NOT EXISTS idx_api_keys_type ON api_keys(key_type);
 CREATE INDEX IF NOT EXISTS idx_api_keys_is_active ON api_keys(is_active);
@@ -163,13 +119,16 @@ CREATE INDEX IF NOT EXISTS idx_api_keys_magic_link_token ON api_keys(magic_link_
 CREATE INDEX IF NOT EXISTS idx_api_keys_email_verified ON api_keys(email_verified);
 CREATE INDEX IF NOT EXISTS idx_api_keys_type_active ON api_keys(key_type, is_active);
 
--- Indexes for events table
+-- Indexes for events
 CREATE INDEX IF NOT EXISTS idx_events_webhook_key_id ON events(webhook_key_id);
 CREATE INDEX IF NOT EXISTS idx_events_processed ON events(processed);
 CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at);
 CREATE INDEX IF NOT EXISTS idx_events_expires_at ON events(expires_at);
+CREATE INDEX IF NOT EXISTS idx_events_deliver_after ON events(deliver_after) WHERE deliver_after IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_events_webhook_key_processed ON events(webhook_key_id, processed);
+CREATE INDEX IF NOT EXISTS idx_events_created_expires ON events(created_at, expires_at);
 
--- Indexes for webhook_logs table (comprehensive webhook tracking)
+-- Indexes for webhook_logs
 CREATE INDEX IF NOT EXISTS idx_webhook_logs_event_id ON webhook_logs(event_id);
 CREATE INDEX IF NOT EXISTS idx_webhook_logs_webhook_key_id ON webhook_logs(webhook_key_id);
 CREATE INDEX IF NOT EXISTS idx_webhook_logs_client_key_id ON webhook_logs(client_key_id);
@@ -178,8 +137,3 @@ CREATE INDEX IF NOT EXISTS idx_webhook_logs_attempted_at ON webhook_logs(attempt
 CREATE INDEX IF NOT EXISTS idx_webhook_logs_delivered_at ON webhook_logs(delivered_at);
 CREATE INDEX IF NOT EXISTS idx_webhook_logs_acked_at ON webhook_logs(acked_at);
 CREATE INDEX IF NOT EXISTS idx_webhook_logs_webhook_status ON webhook_logs(webhook_key_id, delivery_status);
-
--- Composite indexes for common queries
-CREATE INDEX IF NOT EXISTS idx_events_webhook_key_processed ON events(webhook_key_id, processed);
-CREATE INDEX IF NOT EXISTS idx_events_created_expires ON events(created_at, expires_at);
-
@@ -0,0 +1,3 @@
+-- The language of a user's emails and dashboard; the code has read and written
+-- this column since localisation was added, but schema.sql never created it.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS preferred_language VARCHAR(10) DEFAULT 'en';
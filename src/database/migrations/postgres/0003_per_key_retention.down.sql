@@ -0,0 +1,3 @@
+ALTER TABLE api_keys DROP COLUMN IF EXISTS processed_ttl_days;
+UPDATE api_keys SET event_ttl_days = 30 WHERE event_ttl_days IS NULL;
+ALTER TABLE api_keys ALTER COLUMN event_ttl_days SET DEFAULT 30;
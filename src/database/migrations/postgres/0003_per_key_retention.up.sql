@@ -0,0 +1,6 @@
+-- Per-key retention overrides; NULL falls back to EVENT_TTL_DAYS /
+-- PROCESSED_EVENT_TTL_DAYS. event_ttl_days was never user-settable, so every
+-- stored value is the old column default rather than a choice worth keeping.
+ALTER TABLE api_keys ALTER COLUMN event_ttl_days DROP DEFAULT, ALTER COLUMN event_ttl_days DROP NOT NULL;
+UPDATE api_keys SET event_ttl_days = NULL WHERE event_ttl_days IS NOT NULL;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS processed_ttl_days INTEGER;
@@ -0,0 +1,2 @@
+ALTER TABLE events DROP COLUMN IF EXISTS sealed;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS e2e_public_key;
@@ -0,0 +1,4 @@
+-- End-to-end encryption: the plugin registers an X25519 public key on its
+-- client key, and events for that pair are sealed to it before storage.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS e2e_public_key TEXT;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS sealed BOOLEAN NOT NULL DEFAULT false;
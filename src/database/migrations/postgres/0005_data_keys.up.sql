@@ -0,0 +1,10 @@
+-- Per key pair data encryption keys for envelope encryption. Each DEK is
+-- stored wrapped by the master key; rotating the master key rewraps these
+-- rows only. Deleting a row crypto-shreds the pair's remaining events.
+CREATE TABLE IF NOT EXISTS data_keys (
+    webhook_key_id UUID PRIMARY KEY REFERENCES api_keys(id) ON DELETE CASCADE,
+    key_id VARCHAR(32) UNIQUE NOT NULL,
+    wrapped_key BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,4 @@
+-- Large payloads live in the blob store; blob_ref is the SHA-256 of the stored
+-- object and data is left empty for those events.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS blob_ref VARCHAR(64);
+CREATE INDEX IF NOT EXISTS idx_events_blob_ref ON events(blob_ref) WHERE blob_ref IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE events DROP COLUMN IF EXISTS compressed;
+ALTER TABLE events DROP COLUMN IF EXISTS stored_size;
+ALTER TABLE events DROP COLUMN IF EXISTS payload_size;
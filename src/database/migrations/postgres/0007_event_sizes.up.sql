@@ -0,0 +1,5 @@
+-- Storage accounting for admin stats. Sizes are NULL for events stored
+-- before this migration.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS payload_size BIGINT;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS stored_size BIGINT;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS compressed BOOLEAN NOT NULL DEFAULT FALSE;
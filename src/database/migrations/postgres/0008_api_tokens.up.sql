@@ -0,0 +1,17 @@
+-- Personal access tokens for the dashboard API. Only the SHA-256 of each
+-- token is stored; prefix is its first characters, shown so users can tell
+-- tokens apart. Scopes are a comma-separated list.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id UUID PRIMARY KEY,
+    user_email VARCHAR(255) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(32) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    scopes TEXT NOT NULL,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_email ON api_tokens(user_email);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_api_keys_previous_key_value;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS previous_key_expires_at;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS previous_key_value;
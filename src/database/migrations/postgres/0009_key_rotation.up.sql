@@ -0,0 +1,6 @@
+-- Single-side key rotation: the replaced key value keeps working until
+-- previous_key_expires_at, so senders or the plugin can be moved over gradually.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS previous_key_value VARCHAR(255);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS previous_key_expires_at TIMESTAMP;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_previous_key_value
+    ON api_keys(previous_key_value) WHERE previous_key_value IS NOT NULL;
@@ -0,0 +1,6 @@
+-- Named keys would otherwise turn into separate pairs without a client key
+DELETE FROM api_keys WHERE key_type = 'webhook' AND pair_id IS NOT NULL AND pair_id <> id;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS path_prefix;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS signing_secret;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS rate_limit_per_minute;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS name;
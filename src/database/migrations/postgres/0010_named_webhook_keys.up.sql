@@ -0,0 +1,7 @@
+-- Named webhook keys: extra webhook keys share their pair's pair_id and feed
+-- the same client key. Each can carry its own rate limit, signing secret and
+-- allowed path prefix; NULL keeps the server-wide behaviour.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS name VARCHAR(100);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rate_limit_per_minute INTEGER;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS signing_secret VARCHAR(255);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS path_prefix VARCHAR(512);
@@ -0,0 +1,6 @@
+-- Only a single "<prefix>**" glob can be turned back into a path prefix
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS path_prefix VARCHAR(512);
+UPDATE api_keys SET path_prefix = LEFT(allowed_paths, LENGTH(allowed_paths) - 2)
+    WHERE allowed_paths LIKE '%**' AND POSITION(E'\n' IN allowed_paths) = 0;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS forced_path_prefix;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS allowed_paths;
@@ -0,0 +1,7 @@
+-- Per-key path rules: allowed_paths holds newline-separated path globs
+-- (NULL allows any path) and forced_path_prefix a folder every event is
+-- written under. An allowed path prefix becomes the glob "<prefix>**".
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_paths TEXT;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS forced_path_prefix VARCHAR(512);
+UPDATE api_keys SET allowed_paths = path_prefix || '**' WHERE path_prefix IS NOT NULL;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS path_prefix;
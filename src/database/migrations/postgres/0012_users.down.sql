@@ -0,0 +1,26 @@
+-- Copy each user back onto their keys; users without keys are lost
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS user_email VARCHAR(255);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS user_name VARCHAR(255);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS preferred_language VARCHAR(10) DEFAULT 'en';
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS magic_link_token VARCHAR(255);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS magic_link_expires_at TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS magic_link_used_at TIMESTAMP;
+
+UPDATE api_keys k
+SET user_email = u.email,
+    user_name = u.name,
+    email_verified = u.email_verified,
+    preferred_language = u.preferred_language,
+    magic_link_token = CASE WHEN k.key_type = 'webhook' THEN u.magic_link_token END,
+    magic_link_expires_at = CASE WHEN k.key_type = 'webhook' THEN u.magic_link_expires_at END,
+    magic_link_used_at = CASE WHEN k.key_type = 'webhook' THEN u.magic_link_used_at END
+FROM users u
+WHERE k.user_id = u.id;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_email ON api_keys(user_email);
+CREATE INDEX IF NOT EXISTS idx_api_keys_magic_link_token ON api_keys(magic_link_token);
+CREATE INDEX IF NOT EXISTS idx_api_keys_email_verified ON api_keys(email_verified);
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS user_id;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,50 @@
+-- Users get their own table. api_keys rows reference their owner through
+-- user_id instead of each carrying a copy of the email, name, language and
+-- magic link, so a profile can be edited in one place.
+CREATE TABLE IF NOT EXISTS users (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email VARCHAR(255) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    preferred_language VARCHAR(10) NOT NULL DEFAULT 'en',
+    email_verified BOOLEAN NOT NULL DEFAULT false,
+    magic_link_token VARCHAR(255),
+    magic_link_expires_at TIMESTAMP,
+    magic_link_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_magic_link_token ON users(magic_link_token);
+
+-- One user per email. The profile and magic link were written to every
+-- webhook key of the user, so the newest webhook key holds the current ones.
+INSERT INTO users (email, name, preferred_language, email_verified,
+                   magic_link_token, magic_link_expires_at, magic_link_used_at, created_at)
+SELECT DISTINCT ON (user_email)
+    user_email,
+    COALESCE(user_name, ''),
+    COALESCE(preferred_language, 'en'),
+    BOOL_OR(email_verified) OVER (PARTITION BY user_email),
+    magic_link_token,
+    magic_link_expires_at,
+    magic_link_used_at,
+    MIN(created_at) OVER (PARTITION BY user_email)
+FROM api_keys
+WHERE user_email IS NOT NULL AND user_email <> ''
+ORDER BY user_email, key_type = 'webhook' DESC, created_at DESC
+ON CONFLICT (email) DO NOTHING;
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS user_id UUID REFERENCES users(id) ON DELETE CASCADE;
+UPDATE api_keys k SET user_id = u.id FROM users u WHERE k.user_email = u.email;
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+
+DROP INDEX IF EXISTS idx_api_keys_user_email;
+DROP INDEX IF EXISTS idx_api_keys_magic_link_token;
+DROP INDEX IF EXISTS idx_api_keys_email_verified;
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS user_email,
+    DROP COLUMN IF EXISTS user_name,
+    DROP COLUMN IF EXISTS email_verified,
+    DROP COLUMN IF EXISTS preferred_language,
+    DROP COLUMN IF EXISTS magic_link_token,
+    DROP COLUMN IF EXISTS magic_link_expires_at,
+    DROP COLUMN IF EXISTS magic_link_used_at;
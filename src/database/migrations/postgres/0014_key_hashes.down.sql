@@ -0,0 +1,35 @@
+-- Hashed keys cannot be turned back into values: keys created or hashed since
+-- the up migration stop working and have to be reissued.
+DROP VIEW IF EXISTS webhook_keys;
+DROP VIEW IF EXISTS client_keys;
+
+DROP INDEX IF EXISTS idx_api_keys_unhashed;
+ALTER INDEX IF EXISTS idx_api_keys_previous_key_hash RENAME TO idx_api_keys_previous_key_value;
+ALTER INDEX IF EXISTS idx_api_keys_key_hash RENAME TO idx_api_keys_key_value;
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS key_prefix;
+ALTER TABLE api_keys RENAME COLUMN previous_key_hash TO previous_key_value;
+ALTER TABLE api_keys RENAME COLUMN key_hash TO key_value;
+
+CREATE VIEW webhook_keys WITH (security_invoker = true) AS
+SELECT
+    id,
+    key_value,
+    CASE WHEN is_active THEN 'active' ELSE 'inactive' END as status,
+    created_at,
+    last_used,
+    usage_count as events_count
+FROM api_keys
+WHERE key_type = 'webhook';
+
+CREATE VIEW client_keys WITH (security_invoker = true) AS
+SELECT
+    id,
+    key_value,
+    pair_id as webhook_key_id,
+    CASE WHEN is_active THEN 'active' ELSE 'inactive' END as status,
+    created_at,
+    last_used as last_connected,
+    usage_count as events_delivered
+FROM api_keys
+WHERE key_type = 'client';
@@ -0,0 +1,39 @@
+-- Keys are stored as an HMAC under the server's KEY_HASH_PEPPER instead of in
+-- the clear, with a few leading characters kept for display. Existing values
+-- move into the renamed columns as they are; rows with no key_prefix still
+-- hold a plaintext key and are hashed by the server at startup.
+DROP VIEW IF EXISTS webhook_keys;
+DROP VIEW IF EXISTS client_keys;
+
+ALTER TABLE api_keys RENAME COLUMN key_value TO key_hash;
+ALTER TABLE api_keys RENAME COLUMN previous_key_value TO previous_key_hash;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS key_prefix VARCHAR(16);
+
+ALTER INDEX IF EXISTS idx_api_keys_key_value RENAME TO idx_api_keys_key_hash;
+ALTER INDEX IF EXISTS idx_api_keys_previous_key_value RENAME TO idx_api_keys_previous_key_hash;
+CREATE INDEX IF NOT EXISTS idx_api_keys_unhashed ON api_keys(id) WHERE key_prefix IS NULL;
+
+CREATE VIEW webhook_keys WITH (security_invoker = true) AS
+SELECT
+    id,
+    key_hash,
+    key_prefix,
+    CASE WHEN is_active THEN 'active' ELSE 'inactive' END as status,
+    created_at,
+    last_used,
+    usage_count as events_count
+FROM api_keys
+WHERE key_type = 'webhook';
+
+CREATE VIEW client_keys WITH (security_invoker = true) AS
+SELECT
+    id,
+    key_hash,
+    key_prefix,
+    pair_id as webhook_key_id,
+    CASE WHEN is_active THEN 'active' ELSE 'inactive' END as status,
+    created_at,
+    last_used as last_connected,
+    usage_count as events_delivered
+FROM api_keys
+WHERE key_type = 'client';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS admin_recovery_codes;
+ALTER TABLE admin_users DROP COLUMN IF EXISTS totp_enabled_at;
+ALTER TABLE admin_users DROP COLUMN IF EXISTS totp_secret;
@@ -0,0 +1,13 @@
+-- Optional TOTP second factor for admin users. totp_secret is encrypted with
+-- the server's ENCRYPTION_KEY when one is set; totp_enabled_at stays NULL
+-- until enrollment is confirmed with a first code. Recovery codes are stored
+-- as SHA-256 hashes and marked used instead of deleted.
+ALTER TABLE admin_users ADD COLUMN IF NOT EXISTS totp_secret BYTEA;
+ALTER TABLE admin_users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS admin_recovery_codes (
+    admin_id UUID NOT NULL REFERENCES admin_users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    PRIMARY KEY (admin_id, code_hash)
+);
@@ -0,0 +1,9 @@
+-- Admin roles: owner (everything, including managing admins), operator
+-- (keys, events, cleanup) and support (read-only). Existing admins keep
+-- their unlimited access as owners. An invited admin has an invite token
+-- hash and no usable password until the invite is accepted.
+ALTER TABLE admin_users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'owner';
+ALTER TABLE admin_users ADD COLUMN IF NOT EXISTS invite_token_hash VARCHAR(64);
+ALTER TABLE admin_users ADD COLUMN IF NOT EXISTS invite_expires_at TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_admin_users_invite_token_hash ON admin_users(invite_token_hash);
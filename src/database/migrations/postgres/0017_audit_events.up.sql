@@ -0,0 +1,27 @@
+-- Append-only log of security-relevant actions. Each row's hash covers its
+-- fields and the hash of the row before it (seq - 1), so rewriting or deleting
+-- a row breaks the chain from that point on. Actors and targets are plain
+-- text rather than foreign keys: entries outlive the rows they describe.
+CREATE TABLE IF NOT EXISTS audit_events (
+    seq BIGINT PRIMARY KEY,
+    id UUID NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    actor_type VARCHAR(20) NOT NULL,
+    actor_id VARCHAR(255) NOT NULL DEFAULT '',
+    actor_name VARCHAR(255) NOT NULL DEFAULT '',
+    action VARCHAR(64) NOT NULL,
+    target_type VARCHAR(32) NOT NULL DEFAULT '',
+    target_id VARCHAR(255) NOT NULL DEFAULT '',
+    user_email VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    request_id VARCHAR(128) NOT NULL DEFAULT '',
+    before_state TEXT,
+    after_state TEXT,
+    prev_hash VARCHAR(64) NOT NULL,
+    hash VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_email ON audit_events(user_email);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target_id ON audit_events(target_id);
@@ -0,0 +1,2 @@
+ALTER TABLE api_keys DROP COLUMN IF EXISTS delivery_alerted_at;
+ALTER TABLE users DROP COLUMN IF EXISTS delivery_alerts;
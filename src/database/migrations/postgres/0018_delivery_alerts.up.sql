@@ -0,0 +1,5 @@
+-- Stuck delivery alerts: users can opt out of the emails about their own
+-- keys, and each webhook key remembers when it was last alerted on so a
+-- backlog that stays stuck is reported once per cooldown, not on every check.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS delivery_alerts BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS delivery_alerted_at TIMESTAMP;
@@ -0,0 +1,4 @@
+-- The last TOTP time step (Unix time / 30s) an admin signed in with. A code
+-- is accepted only for a later step, so one code can't be used twice while it
+-- is still valid (RFC 6238 section 5.2).
+ALTER TABLE admin_users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
@@ -0,0 +1,4 @@
+-- How the plugin writes an event's data: 'append' or 'overwrite', or '' for
+-- the write mode configured in the plugin. Set by MCP's append_to_note so an
+-- append never replaces a note, whatever the plugin's default.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS write_mode VARCHAR(16) NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS user_email VARCHAR(255);
+UPDATE api_tokens t SET user_email = u.email FROM users u WHERE t.user_id = u.id;
+ALTER TABLE api_tokens ALTER COLUMN user_email SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_email ON api_tokens(user_email);
+
+DROP INDEX IF EXISTS idx_api_tokens_user_id;
+ALTER TABLE api_tokens DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,12 @@
+-- api_tokens references its owner by users(id) like the other per-user
+-- tables, so tokens follow an email change and go with a deleted user.
+-- Tokens whose email no longer belongs to a user cannot sign anyone in and
+-- are dropped.
+ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS user_id UUID REFERENCES users(id) ON DELETE CASCADE;
+UPDATE api_tokens t SET user_id = u.id FROM users u WHERE t.user_email = u.email;
+DELETE FROM api_tokens WHERE user_id IS NULL;
+ALTER TABLE api_tokens ALTER COLUMN user_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+
+DROP INDEX IF EXISTS idx_api_tokens_user_email;
+ALTER TABLE api_tokens DROP COLUMN IF EXISTS user_email;
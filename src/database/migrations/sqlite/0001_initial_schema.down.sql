@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS webhook_logs;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS admin_users;
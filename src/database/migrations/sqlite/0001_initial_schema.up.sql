@@ -0,0 +1,97 @@
+-- SQLite equivalent of the PostgreSQL baseline (postgres/0001 + 0002).
+-- UUIDs are stored as TEXT and timestamps as UTC TEXT
+-- ("YYYY-MM-DD HH:MM:SS.fffffffff+00:00"), which sorts chronologically.
+-- Foreign keys require PRAGMA foreign_keys = ON.
+
+-- admin_users table - Admin authentication and system management
+CREATE TABLE IF NOT EXISTS admin_users (
+    id TEXT PRIMARY KEY,
+    username TEXT UNIQUE NOT NULL,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_login TIMESTAMP,
+    is_active BOOLEAN NOT NULL DEFAULT 1
+);
+
+-- api_keys table - Unified keys for webhooks and SSE clients with user tracking
+CREATE TABLE IF NOT EXISTS api_keys (
+    id TEXT PRIMARY KEY,
+    key_value TEXT UNIQUE NOT NULL,
+    key_type TEXT NOT NULL, -- 'webhook' or 'client'
+    pair_id TEXT, -- links webhook key to its paired client key(s)
+    is_active BOOLEAN NOT NULL DEFAULT 1, -- for revocation only
+    activated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+    -- User information (email authentication)
+    user_email TEXT,
+    user_name TEXT,
+    email_verified BOOLEAN NOT NULL DEFAULT 0,
+    preferred_language TEXT DEFAULT 'en',
+    magic_link_token TEXT,
+    magic_link_expires_at TIMESTAMP,
+    magic_link_used_at TIMESTAMP,
+
+    -- Event retention settings
+    event_ttl_days INTEGER DEFAULT 30,
+
+    -- Audit and usage tracking
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_used TIMESTAMP,
+    usage_count INTEGER NOT NULL DEFAULT 0,
+
+    CONSTRAINT key_type_check CHECK (key_type IN ('webhook', 'client'))
+);
+
+-- events table - Webhook events storage
+CREATE TABLE IF NOT EXISTS events (
+    id TEXT PRIMARY KEY,
+    webhook_key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    path TEXT NOT NULL,
+    data BLOB NOT NULL,
+    processed BOOLEAN NOT NULL DEFAULT 0,
+    processed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    expires_at TIMESTAMP NOT NULL,
+    deliver_after TIMESTAMP, -- scheduled delivery (NULL = immediately)
+    CONSTRAINT processed_implies_timestamp CHECK (
+        (processed = 0 AND processed_at IS NULL) OR
+        (processed = 1 AND processed_at IS NOT NULL)
+    )
+);
+
+-- webhook_logs table - Webhook delivery and processing logs
+CREATE TABLE IF NOT EXISTS webhook_logs (
+    id TEXT PRIMARY KEY,
+    event_id TEXT NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    webhook_key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    client_key_id TEXT REFERENCES api_keys(id) ON DELETE SET NULL,
+
+    delivery_status TEXT NOT NULL DEFAULT 'pending', -- pending, delivered, failed, acked
+    status_code INTEGER,
+    error_message TEXT,
+
+    attempted_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    delivered_at TIMESTAMP,
+    acked_at TIMESTAMP,
+    client_ip TEXT,
+
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+    CONSTRAINT delivery_status_check CHECK (delivery_status IN ('pending', 'delivered', 'failed', 'acked'))
+);
+
+-- Indexes for api_keys
+CREATE INDEX IF NOT EXISTS idx_api_keys_type ON api_keys(key_type);
+CREATE INDEX IF NOT EXISTS idx_api_keys_pair_id ON api_keys(pair_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_email ON api_keys(user_email);
+CREATE INDEX IF NOT EXISTS idx_api_keys_magic_link_token ON api_keys(magic_link_token);
+
+-- Indexes for events
+CREATE INDEX IF NOT EXISTS idx_events_webhook_key_processed ON events(webhook_key_id, processed);
+CREATE INDEX IF NOT EXISTS idx_events_expires_at ON events(expires_at);
+CREATE INDEX IF NOT EXISTS idx_events_deliver_after ON events(deliver_after) WHERE deliver_after IS NOT NULL;
+
+-- Indexes for webhook_logs
+CREATE INDEX IF NOT EXISTS idx_webhook_logs_event_id ON webhook_logs(event_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_logs_webhook_key_id ON webhook_logs(webhook_key_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_logs_attempted_at ON webhook_logs(attempted_at);
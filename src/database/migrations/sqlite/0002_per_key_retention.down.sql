@@ -0,0 +1,2 @@
+ALTER TABLE api_keys DROP COLUMN processed_ttl_days;
+UPDATE api_keys SET event_ttl_days = 30 WHERE event_ttl_days IS NULL;
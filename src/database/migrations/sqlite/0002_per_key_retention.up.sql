@@ -0,0 +1,4 @@
+-- Per-key retention overrides; NULL falls back to the server defaults.
+-- SQLite cannot drop the old DEFAULT 30, so inserts write NULL explicitly.
+UPDATE api_keys SET event_ttl_days = NULL;
+ALTER TABLE api_keys ADD COLUMN processed_ttl_days INTEGER;
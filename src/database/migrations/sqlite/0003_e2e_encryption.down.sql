@@ -0,0 +1,2 @@
+ALTER TABLE events DROP COLUMN sealed;
+ALTER TABLE api_keys DROP COLUMN e2e_public_key;
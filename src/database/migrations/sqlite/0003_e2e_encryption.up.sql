@@ -0,0 +1,3 @@
+-- End-to-end encryption: client public key and per-event sealed flag
+ALTER TABLE api_keys ADD COLUMN e2e_public_key TEXT;
+ALTER TABLE events ADD COLUMN sealed BOOLEAN NOT NULL DEFAULT 0;
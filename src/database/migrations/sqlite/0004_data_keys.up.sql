@@ -0,0 +1,8 @@
+-- Per key pair data encryption keys, wrapped by the master key
+CREATE TABLE IF NOT EXISTS data_keys (
+    webhook_key_id TEXT PRIMARY KEY REFERENCES api_keys(id) ON DELETE CASCADE,
+    key_id TEXT UNIQUE NOT NULL,
+    wrapped_key BLOB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_events_blob_ref;
+ALTER TABLE events DROP COLUMN blob_ref;
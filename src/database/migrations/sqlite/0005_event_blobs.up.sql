@@ -0,0 +1,3 @@
+-- Large payloads offloaded to the blob store, referenced by content hash
+ALTER TABLE events ADD COLUMN blob_ref TEXT;
+CREATE INDEX IF NOT EXISTS idx_events_blob_ref ON events(blob_ref) WHERE blob_ref IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE events DROP COLUMN compressed;
+ALTER TABLE events DROP COLUMN stored_size;
+ALTER TABLE events DROP COLUMN payload_size;
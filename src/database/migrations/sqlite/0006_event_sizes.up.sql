@@ -0,0 +1,4 @@
+-- Storage accounting for admin stats; sizes are NULL for older events
+ALTER TABLE events ADD COLUMN payload_size INTEGER;
+ALTER TABLE events ADD COLUMN stored_size INTEGER;
+ALTER TABLE events ADD COLUMN compressed BOOLEAN NOT NULL DEFAULT 0;
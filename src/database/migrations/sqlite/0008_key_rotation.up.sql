@@ -0,0 +1,5 @@
+-- Single-side key rotation: replaced key value and when it stops working
+ALTER TABLE api_keys ADD COLUMN previous_key_value TEXT;
+ALTER TABLE api_keys ADD COLUMN previous_key_expires_at TIMESTAMP;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_previous_key_value
+    ON api_keys(previous_key_value) WHERE previous_key_value IS NOT NULL;
@@ -0,0 +1,5 @@
+DELETE FROM api_keys WHERE key_type = 'webhook' AND pair_id IS NOT NULL AND pair_id <> id;
+ALTER TABLE api_keys DROP COLUMN path_prefix;
+ALTER TABLE api_keys DROP COLUMN signing_secret;
+ALTER TABLE api_keys DROP COLUMN rate_limit_per_minute;
+ALTER TABLE api_keys DROP COLUMN name;
@@ -0,0 +1,5 @@
+-- Named webhook keys sharing a pair_id, with per-key overrides (NULL = server default)
+ALTER TABLE api_keys ADD COLUMN name TEXT;
+ALTER TABLE api_keys ADD COLUMN rate_limit_per_minute INTEGER;
+ALTER TABLE api_keys ADD COLUMN signing_secret TEXT;
+ALTER TABLE api_keys ADD COLUMN path_prefix TEXT;
@@ -0,0 +1,5 @@
+ALTER TABLE api_keys ADD COLUMN path_prefix TEXT;
+UPDATE api_keys SET path_prefix = substr(allowed_paths, 1, length(allowed_paths) - 2)
+    WHERE allowed_paths LIKE '%**' AND instr(allowed_paths, char(10)) = 0;
+ALTER TABLE api_keys DROP COLUMN forced_path_prefix;
+ALTER TABLE api_keys DROP COLUMN allowed_paths;
@@ -0,0 +1,6 @@
+-- Per-key path rules: newline-separated path globs (NULL = any path) and a
+-- forced folder; an allowed path prefix becomes the glob "<prefix>**"
+ALTER TABLE api_keys ADD COLUMN allowed_paths TEXT;
+ALTER TABLE api_keys ADD COLUMN forced_path_prefix TEXT;
+UPDATE api_keys SET allowed_paths = path_prefix || '**' WHERE path_prefix IS NOT NULL;
+ALTER TABLE api_keys DROP COLUMN path_prefix;
@@ -0,0 +1,28 @@
+-- Copy each user back onto their keys; users without keys are lost
+ALTER TABLE api_keys ADD COLUMN user_email TEXT;
+ALTER TABLE api_keys ADD COLUMN user_name TEXT;
+ALTER TABLE api_keys ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE api_keys ADD COLUMN preferred_language TEXT DEFAULT 'en';
+ALTER TABLE api_keys ADD COLUMN magic_link_token TEXT;
+ALTER TABLE api_keys ADD COLUMN magic_link_expires_at TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN magic_link_used_at TIMESTAMP;
+
+UPDATE api_keys
+SET user_email = (SELECT u.email FROM users u WHERE u.id = api_keys.user_id),
+    user_name = (SELECT u.name FROM users u WHERE u.id = api_keys.user_id),
+    email_verified = COALESCE((SELECT u.email_verified FROM users u WHERE u.id = api_keys.user_id), 0),
+    preferred_language = COALESCE((SELECT u.preferred_language FROM users u WHERE u.id = api_keys.user_id), 'en')
+WHERE user_id IS NOT NULL;
+
+UPDATE api_keys
+SET magic_link_token = (SELECT u.magic_link_token FROM users u WHERE u.id = api_keys.user_id),
+    magic_link_expires_at = (SELECT u.magic_link_expires_at FROM users u WHERE u.id = api_keys.user_id),
+    magic_link_used_at = (SELECT u.magic_link_used_at FROM users u WHERE u.id = api_keys.user_id)
+WHERE user_id IS NOT NULL AND key_type = 'webhook';
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_email ON api_keys(user_email);
+CREATE INDEX IF NOT EXISTS idx_api_keys_magic_link_token ON api_keys(magic_link_token);
+
+DROP INDEX IF EXISTS idx_api_keys_user_id;
+ALTER TABLE api_keys DROP COLUMN user_id;
+DROP TABLE IF EXISTS users;
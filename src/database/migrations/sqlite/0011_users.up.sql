@@ -0,0 +1,53 @@
+-- Users get their own table; api_keys rows reference their owner by user_id
+CREATE TABLE IF NOT EXISTS users (
+    id TEXT PRIMARY KEY,
+    email TEXT UNIQUE NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    preferred_language TEXT NOT NULL DEFAULT 'en',
+    email_verified BOOLEAN NOT NULL DEFAULT 0,
+    magic_link_token TEXT,
+    magic_link_expires_at TIMESTAMP,
+    magic_link_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_magic_link_token ON users(magic_link_token);
+
+-- One user per email, taken from their newest webhook key. SQLite cannot
+-- generate UUIDs, so each user reuses the ID of that key.
+INSERT INTO users (id, email, name, preferred_language, email_verified,
+                   magic_link_token, magic_link_expires_at, magic_link_used_at, created_at)
+SELECT
+    k.id,
+    k.user_email,
+    COALESCE(k.user_name, ''),
+    COALESCE(k.preferred_language, 'en'),
+    (SELECT MAX(v.email_verified) FROM api_keys v WHERE v.user_email = k.user_email),
+    k.magic_link_token,
+    k.magic_link_expires_at,
+    k.magic_link_used_at,
+    (SELECT MIN(c.created_at) FROM api_keys c WHERE c.user_email = k.user_email)
+FROM api_keys k
+WHERE k.user_email IS NOT NULL AND k.user_email <> ''
+  AND k.id = (
+      SELECT n.id FROM api_keys n
+      WHERE n.user_email = k.user_email
+      ORDER BY n.key_type = 'webhook' DESC, n.created_at DESC, n.id
+      LIMIT 1
+  );
+
+-- Without a REFERENCES clause: SQLite cannot drop a foreign key column, which
+-- the down migration needs, so the repositories keep user_id consistent
+ALTER TABLE api_keys ADD COLUMN user_id TEXT;
+UPDATE api_keys SET user_id = (SELECT u.id FROM users u WHERE u.email = api_keys.user_email);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+
+DROP INDEX IF EXISTS idx_api_keys_user_email;
+DROP INDEX IF EXISTS idx_api_keys_magic_link_token;
+ALTER TABLE api_keys DROP COLUMN user_email;
+ALTER TABLE api_keys DROP COLUMN user_name;
+ALTER TABLE api_keys DROP COLUMN email_verified;
+ALTER TABLE api_keys DROP COLUMN preferred_language;
+ALTER TABLE api_keys DROP COLUMN magic_link_token;
+ALTER TABLE api_keys DROP COLUMN magic_link_expires_at;
+ALTER TABLE api_keys DROP COLUMN magic_link_used_at;
@@ -0,0 +1,4 @@
+-- Hashed keys cannot be turned back into values and have to be reissued
+ALTER TABLE api_keys DROP COLUMN key_prefix;
+ALTER TABLE api_keys RENAME COLUMN previous_key_hash TO previous_key_value;
+ALTER TABLE api_keys RENAME COLUMN key_hash TO key_value;
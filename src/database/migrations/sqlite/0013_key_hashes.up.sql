@@ -0,0 +1,6 @@
+-- Keys are stored as an HMAC under the server's KEY_HASH_PEPPER, with a few
+-- leading characters kept for display. Rows with no key_prefix still hold a
+-- plaintext key and are hashed by the server at startup.
+ALTER TABLE api_keys RENAME COLUMN key_value TO key_hash;
+ALTER TABLE api_keys RENAME COLUMN previous_key_value TO previous_key_hash;
+ALTER TABLE api_keys ADD COLUMN key_prefix TEXT;
@@ -0,0 +1,10 @@
+-- Optional TOTP second factor for admin users; see postgres/0015_admin_totp
+ALTER TABLE admin_users ADD COLUMN totp_secret BLOB;
+ALTER TABLE admin_users ADD COLUMN totp_enabled_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS admin_recovery_codes (
+    admin_id TEXT NOT NULL REFERENCES admin_users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    PRIMARY KEY (admin_id, code_hash)
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_admin_users_invite_token_hash;
+ALTER TABLE admin_users DROP COLUMN invite_expires_at;
+ALTER TABLE admin_users DROP COLUMN invite_token_hash;
+ALTER TABLE admin_users DROP COLUMN role;
@@ -0,0 +1,6 @@
+-- Admin roles and invites; see postgres/0016_admin_roles
+ALTER TABLE admin_users ADD COLUMN role TEXT NOT NULL DEFAULT 'owner';
+ALTER TABLE admin_users ADD COLUMN invite_token_hash TEXT;
+ALTER TABLE admin_users ADD COLUMN invite_expires_at TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_admin_users_invite_token_hash ON admin_users(invite_token_hash);
@@ -0,0 +1,2 @@
+ALTER TABLE api_keys DROP COLUMN delivery_alerted_at;
+ALTER TABLE users DROP COLUMN delivery_alerts;
@@ -0,0 +1,3 @@
+-- Stuck delivery alert settings; see postgres/0018_delivery_alerts
+ALTER TABLE users ADD COLUMN delivery_alerts BOOLEAN NOT NULL DEFAULT 1;
+ALTER TABLE api_keys ADD COLUMN delivery_alerted_at TIMESTAMP;
@@ -0,0 +1 @@
+ALTER TABLE admin_users DROP COLUMN totp_last_step;
@@ -0,0 +1,2 @@
+-- Last accepted TOTP time step; see postgres/0019_admin_totp_last_step
+ALTER TABLE admin_users ADD COLUMN totp_last_step INTEGER;
@@ -0,0 +1,2 @@
+-- Per-event write mode; see postgres/0020_event_write_mode
+ALTER TABLE events ADD COLUMN write_mode TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,22 @@
+CREATE TABLE api_tokens_old (
+    id TEXT PRIMARY KEY,
+    user_email TEXT NOT NULL,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    scopes TEXT NOT NULL,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+INSERT INTO api_tokens_old (id, user_email, name, prefix, token_hash, scopes, expires_at, last_used_at, revoked_at, created_at)
+SELECT t.id, u.email, t.name, t.prefix, t.token_hash, t.scopes, t.expires_at, t.last_used_at, t.revoked_at, t.created_at
+FROM api_tokens t
+JOIN users u ON u.id = t.user_id
+ORDER BY t.rowid;
+
+DROP TABLE api_tokens;
+ALTER TABLE api_tokens_old RENAME TO api_tokens;
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_email ON api_tokens(user_email);
@@ -0,0 +1,25 @@
+-- api_tokens references its owner by users(id); see
+-- postgres/0021_api_tokens_user_id. SQLite cannot add a foreign key to an
+-- existing table, so the table is rebuilt.
+CREATE TABLE api_tokens_new (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    scopes TEXT NOT NULL,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+INSERT INTO api_tokens_new (id, user_id, name, prefix, token_hash, scopes, expires_at, last_used_at, revoked_at, created_at)
+SELECT t.id, u.id, t.name, t.prefix, t.token_hash, t.scopes, t.expires_at, t.last_used_at, t.revoked_at, t.created_at
+FROM api_tokens t
+JOIN users u ON u.email = t.user_email
+ORDER BY t.rowid;
+
+DROP TABLE api_tokens;
+ALTER TABLE api_tokens_new RENAME TO api_tokens;
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
@@ -62,8 +62,9 @@ func (ah *ACKHandler) HandleACK(c *gin.Context) {
 		return
 	}
 
-	// Mark event as processed (idempotent)
-	err = ah.eventService.MarkEventAsProcessed(c.Request.Context(), eventID)
+	// Mark event as processed (idempotent); processed events follow the key's retention
+	processedTTL := ah.keyService.GetRetentionPolicy(c.Request.Context(), ck.WebhookKeyID).Processed
+	err = ah.eventService.MarkEventAsProcessed(c.Request.Context(), eventID, processedTTL)
 	if err != nil {
 		// If event already processed, return 200 anyway (idempotent)
 		c.JSON(http.StatusOK, gin.H{
@@ -9,15 +9,14 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/database"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
 )
 
 func TestHandleACK_Success(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		// Setup
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		// Create test user with key pair
 		webhookKeyID, clientKeyID, webhookKey, clientKey, err := tdb.CreateTestKeyPair(123456, "testuser")
@@ -32,8 +31,8 @@ func TestHandleACK_Success(t *testing.T) {
 		}
 
 		// Create services and handler
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewACKHandler(keyService, eventService)
 
 		// Setup HTTP request
@@ -83,18 +82,17 @@ func TestHandleACK_Success(t *testing.T) {
 }
 
 func TestHandleACK_InvalidEventIDFormat(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		// Setup
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		_, _, _, clientKey, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
 			t.Fatalf("failed to create test key pair: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewACKHandler(keyService, eventService)
 
 		w := httptest.NewRecorder()
@@ -125,13 +123,12 @@ func TestHandleACK_InvalidEventIDFormat(t *testing.T) {
 }
 
 func TestHandleACK_InvalidClientKey(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		// Setup
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewACKHandler(keyService, eventService)
 
 		w := httptest.NewRecorder()
@@ -163,18 +160,17 @@ func TestHandleACK_InvalidClientKey(t *testing.T) {
 }
 
 func TestHandleACK_EventNotFound(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		// Setup
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		_, _, _, clientKey, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
 			t.Fatalf("failed to create test key pair: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewACKHandler(keyService, eventService)
 
 		w := httptest.NewRecorder()
@@ -206,10 +202,9 @@ func TestHandleACK_EventNotFound(t *testing.T) {
 }
 
 func TestHandleACK_Forbidden(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		// Setup
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		// Create first user with event
 		webhookKeyID1, _, _, _, err := tdb.CreateTestKeyPair(111111, "user1")
@@ -228,8 +223,8 @@ func TestHandleACK_Forbidden(t *testing.T) {
 			t.Fatalf("failed to create test key pair 2: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewACKHandler(keyService, eventService)
 
 		w := httptest.NewRecorder()
@@ -260,10 +255,9 @@ func TestHandleACK_Forbidden(t *testing.T) {
 }
 
 func TestHandleACK_Idempotent(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		// Setup
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		webhookKeyID, _, _, clientKey, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
@@ -275,8 +269,8 @@ func TestHandleACK_Idempotent(t *testing.T) {
 			t.Fatalf("failed to create test event: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewACKHandler(keyService, eventService)
 
 		// First ACK
@@ -2,20 +2,27 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/middleware"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
 )
 
 // AdminHandler handles admin operations
 type AdminHandler struct {
-	keyService   *services.KeyService
-	adminService *services.AdminService
-	eventService *services.EventService
+	keyService     *services.KeyService
+	adminService   *services.AdminService
+	eventService   *services.EventService
+	cleanupService *services.CleanupService
+	deliveryAlerts *services.DeliveryAlertService
+	sessions       *services.SessionService
+	audit          *services.AuditService
 }
 
 // NewAdminHandler creates a new admin handler
@@ -27,26 +34,62 @@ func NewAdminHandler(keyService *services.KeyService, adminService *services.Adm
 	}
 }
 
-// ActivateLicenseRequest represents the request body for activation
+// SetCleanupService enables the cleanup report and run-now endpoints
+func (ah *AdminHandler) SetCleanupService(cleanupService *services.CleanupService) {
+	ah.cleanupService = cleanupService
+}
+
+// SetDeliveryAlerts enables the stuck delivery endpoint
+func (ah *AdminHandler) SetDeliveryAlerts(deliveryAlerts *services.DeliveryAlertService) {
+	ah.deliveryAlerts = deliveryAlerts
+}
+
+// SetSessions records each admin sign-in as a server-side session so that
+// logging out revokes the token
+func (ah *AdminHandler) SetSessions(sessions *services.SessionService) {
+	ah.sessions = sessions
+}
+
+// SetAudit records sign-ins and admin changes in the audit log and enables
+// the audit log endpoints
+func (ah *AdminHandler) SetAudit(audit *services.AuditService) {
+	ah.audit = audit
+}
+
+// ActivateLicenseRequest represents the request body for activation. Keys are
+// given either by value or, as the admin panel does, by ID.
 type ActivateLicenseRequest struct {
-	WebhookKey string `json:"webhook_key"`
-	ClientKey  string `json:"client_key"`
+	WebhookKey   string `json:"webhook_key"`
+	ClientKey    string `json:"client_key"`
+	WebhookKeyID string `json:"webhook_key_id"`
+	ClientKeyID  string `json:"client_key_id"`
 }
 
 // DeactivateLicenseRequest represents the request body for deactivation
 type DeactivateLicenseRequest struct {
-	WebhookKey string `json:"webhook_key"`
-	ClientKey  string `json:"client_key"`
+	WebhookKey   string `json:"webhook_key"`
+	ClientKey    string `json:"client_key"`
+	WebhookKeyID string `json:"webhook_key_id"`
+	ClientKeyID  string `json:"client_key_id"`
 }
 
-// keyOperationFunc defines a function type for key activation/deactivation
-type keyOperationFunc func(ctx context.Context, keyValue string, isWebhookKey bool) error
+// keyOperationFunc defines a function type for key activation/deactivation;
+// key is a key value or ID depending on the function
+type keyOperationFunc func(ctx context.Context, key string, isWebhookKey bool) error
+
+// keyLookupFunc finds a key given the same way as to the matching keyOperationFunc
+type keyLookupFunc func(ctx context.Context, key string, isWebhookKey bool) (*models.KeyInfo, error)
+
+// lookupKeyByID is the keyLookupFunc for keys given by ID
+func (ah *AdminHandler) lookupKeyByID(ctx context.Context, keyID string, _ bool) (*models.KeyInfo, error) {
+	return ah.keyService.GetKeyByID(ctx, keyID)
+}
 
 // handleKeyOperation is a helper that reduces duplication between activate/deactivate
-func (ah *AdminHandler) handleKeyOperation(c *gin.Context, webhookKey, clientKey string, operation keyOperationFunc, action string) {
+func (ah *AdminHandler) handleKeyOperation(c *gin.Context, webhookKey, clientKey string, operation keyOperationFunc, lookup keyLookupFunc, action string) {
 	// Process webhook key if provided
 	if webhookKey != "" {
-		if err := operation(c.Request.Context(), webhookKey, true); err != nil {
+		if err := ah.runKeyOperation(c, webhookKey, true, operation, lookup, action); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": fmt.Sprintf("failed to %s webhook key", action),
 			})
@@ -56,7 +99,7 @@ func (ah *AdminHandler) handleKeyOperation(c *gin.Context, webhookKey, clientKey
 
 	// Process client key if provided
 	if clientKey != "" {
-		if err := operation(c.Request.Context(), clientKey, false); err != nil {
+		if err := ah.runKeyOperation(c, clientKey, false, operation, lookup, action); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": fmt.Sprintf("failed to %s client key", action),
 			})
@@ -69,6 +112,35 @@ func (ah *AdminHandler) handleKeyOperation(c *gin.Context, webhookKey, clientKey
 	})
 }
 
+// runKeyOperation applies operation to one key and records it in the audit
+// log by ID, never by value
+func (ah *AdminHandler) runKeyOperation(c *gin.Context, key string, isWebhookKey bool, operation keyOperationFunc, lookup keyLookupFunc, action string) error {
+	var before *models.KeyInfo
+	if ah.audit != nil {
+		before, _ = lookup(c.Request.Context(), key, isWebhookKey)
+	}
+	if err := operation(c.Request.Context(), key, isWebhookKey); err != nil {
+		return err
+	}
+
+	active := action == "activate"
+	event := adminAuditEvent(c, models.AuditActionKeyDeactivate)
+	if active {
+		event.Action = models.AuditActionKeyActivate
+	}
+	event.TargetType = models.AuditTargetClientKey
+	if isWebhookKey {
+		event.TargetType = models.AuditTargetWebhookKey
+	}
+	if before != nil {
+		event.TargetID, event.UserEmail = before.ID, before.UserEmail
+		event.Before = auditState(gin.H{"active": before.IsActive})
+	}
+	event.After = auditState(gin.H{"active": active})
+	recordAudit(c, ah.audit, event)
+	return nil
+}
+
 // HandleActivateLicense activates a webhook and client key
 func (ah *AdminHandler) HandleActivateLicense(c *gin.Context) {
 	var req ActivateLicenseRequest
@@ -79,7 +151,11 @@ func (ah *AdminHandler) HandleActivateLicense(c *gin.Context) {
 		return
 	}
 
-	ah.handleKeyOperation(c, req.WebhookKey, req.ClientKey, ah.keyService.ActivateKey, "activate")
+	if req.WebhookKeyID != "" || req.ClientKeyID != "" {
+		ah.handleKeyOperation(c, req.WebhookKeyID, req.ClientKeyID, ah.keyService.ActivateKeyWithID, ah.lookupKeyByID, "activate")
+		return
+	}
+	ah.handleKeyOperation(c, req.WebhookKey, req.ClientKey, ah.keyService.ActivateKey, ah.keyService.GetKeyInfoByValue, "activate")
 }
 
 // HandleDeactivateLicense deactivates a webhook and client key
@@ -92,7 +168,11 @@ func (ah *AdminHandler) HandleDeactivateLicense(c *gin.Context) {
 		return
 	}
 
-	ah.handleKeyOperation(c, req.WebhookKey, req.ClientKey, ah.keyService.DeactivateKey, "deactivate")
+	if req.WebhookKeyID != "" || req.ClientKeyID != "" {
+		ah.handleKeyOperation(c, req.WebhookKeyID, req.ClientKeyID, ah.keyService.DeactivateKeyWithID, ah.lookupKeyByID, "deactivate")
+		return
+	}
+	ah.handleKeyOperation(c, req.WebhookKey, req.ClientKey, ah.keyService.DeactivateKey, ah.keyService.GetKeyInfoByValue, "deactivate")
 }
 
 // HandleListKeys lists all key pairs (webhook + client together)
@@ -110,6 +190,53 @@ func (ah *AdminHandler) HandleListKeys(c *gin.Context) {
 	})
 }
 
+// HandleSetWebhookKeyPaths replaces the path allowlist and forced prefix of any
+// webhook key (PUT /admin/keys/webhook/:key_id/paths)
+func (ah *AdminHandler) HandleSetWebhookKeyPaths(c *gin.Context) {
+	keyID, err := uuid.Parse(c.Param("key_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid key id",
+		})
+		return
+	}
+
+	var req services.PathRules
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	rules, err := ah.keyService.SetWebhookKeyPathRules(c.Request.Context(), keyID, req)
+	switch {
+	case errors.Is(err, services.ErrInvalidWebhookKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook key not found"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update path rules"})
+		return
+	}
+
+	event := adminAuditEvent(c, models.AuditActionKeyPathRules)
+	event.TargetType, event.TargetID = models.AuditTargetWebhookKey, keyID.String()
+	if info, err := ah.keyService.GetKeyByID(c.Request.Context(), keyID.String()); err == nil {
+		event.UserEmail = info.UserEmail
+	}
+	event.After = auditState(rules)
+	recordAudit(c, ah.audit, event)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":             "updated",
+		"allowed_paths":      rules.AllowedPaths,
+		"forced_path_prefix": rules.ForcedPathPrefix,
+	})
+}
+
 // AdminLoginRequest represents the request body for admin login
 type AdminLoginRequest struct {
 	Username string `json:"username" binding:"required"`
@@ -120,9 +247,21 @@ type AdminLoginRequest struct {
 type AdminLoginResponse struct {
 	Token     string `json:"token"`
 	ExpiresAt int64  `json:"expires_at"`
+	// RecoveryCodes is set when the login finished a two-factor enrollment
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
 }
 
-// HandleAdminLogin authenticates admin user and returns JWT token
+// AdminChallengeResponse asks for a second step after a correct password
+type AdminChallengeResponse struct {
+	TOTPRequired           bool   `json:"totp_required,omitempty"`
+	TOTPEnrollmentRequired bool   `json:"totp_enrollment_required,omitempty"`
+	Challenge              string `json:"challenge"`
+	ExpiresAt              int64  `json:"expires_at"`
+}
+
+// HandleAdminLogin authenticates admin user and returns JWT token. Admins with
+// two-factor authentication, or who must enroll in it, get a challenge for
+// /admin/login/totp instead.
 func (ah *AdminHandler) HandleAdminLogin(c *gin.Context) {
 	var req AdminLoginRequest
 	if err := c.BindJSON(&req); err != nil {
@@ -133,23 +272,93 @@ func (ah *AdminHandler) HandleAdminLogin(c *gin.Context) {
 	}
 
 	// Authenticate admin
-	admin, err := ah.adminService.AuthenticateAdmin(c.Request.Context(), req.Username, req.Password)
+	admin, err := ah.adminService.VerifyPassword(c.Request.Context(), req.Username, req.Password)
 	if err != nil {
+		ah.recordLoginFailure(c, req.Username, "password")
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error": "invalid username or password",
 		})
 		return
 	}
 
-	// Generate JWT token
-	token, err := middleware.GenerateAdminToken(admin.ID, admin.Username)
+	if ah.needsSecondStep(admin) {
+		if challenge, ok := ah.challenge(c, admin); ok {
+			c.JSON(http.StatusOK, challenge)
+		}
+		return
+	}
+
+	ah.completeLogin(c, admin, nil)
+}
+
+// needsSecondStep reports whether admin must pass a two-factor step, or
+// enroll in one, before getting a token
+func (ah *AdminHandler) needsSecondStep(admin *models.AdminUser) bool {
+	return admin.TOTPEnabled() || ah.adminService.TOTPRequired()
+}
+
+// challenge issues the token for admin's two-factor step. On failure it
+// writes a 500 and returns false.
+func (ah *AdminHandler) challenge(c *gin.Context, admin *models.AdminUser) (*AdminChallengeResponse, bool) {
+	challenge, err := middleware.GenerateAdminChallengeToken(admin.ID, admin.Username)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "failed to generate token",
 		})
+		return nil, false
+	}
+	return &AdminChallengeResponse{
+		TOTPRequired:           admin.TOTPEnabled(),
+		TOTPEnrollmentRequired: !admin.TOTPEnabled(),
+		Challenge:              challenge,
+		ExpiresAt:              time.Now().Add(middleware.AdminChallengeTTL).Unix(),
+	}, true
+}
+
+// completeLogin starts a session for an authenticated admin and issues their token
+func (ah *AdminHandler) completeLogin(c *gin.Context, admin *models.AdminUser, recoveryCodes []string) {
+	token, expiresAt, ok := ah.startSession(c, admin, gin.H{"two_factor": admin.TOTPEnabled() || len(recoveryCodes) > 0})
+	if !ok {
 		return
 	}
 
+	c.JSON(http.StatusOK, AdminLoginResponse{
+		Token:         token,
+		ExpiresAt:     expiresAt.Unix(),
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// startSession starts a session for an authenticated admin, audits the
+// login with details, and sets the admin token cookie. On failure it writes
+// a 500 and returns false.
+func (ah *AdminHandler) startSession(c *gin.Context, admin *models.AdminUser, details gin.H) (string, time.Time, bool) {
+	sessionID := uuid.Nil
+	if ah.sessions != nil {
+		session, err := ah.sessions.StartAdminSession(c.Request.Context(), admin.ID, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "failed to create session",
+			})
+			return "", time.Time{}, false
+		}
+		sessionID = session.ID
+	}
+
+	// Generate JWT token
+	token, err := middleware.GenerateAdminToken(admin.ID, admin.Username, admin.Role, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to generate token",
+		})
+		return "", time.Time{}, false
+	}
+	ah.adminService.RecordLogin(c.Request.Context(), admin)
+	event := signInAuditEvent(admin, models.AuditActionAdminLogin)
+	event.TargetType, event.TargetID = models.AuditTargetSession, sessionID.String()
+	event.After = auditState(details)
+	recordAudit(c, ah.audit, event)
+
 	// Set cookie
 	expiresAt := time.Now().Add(24 * time.Hour)
 	c.SetCookie(
@@ -161,15 +370,33 @@ func (ah *AdminHandler) HandleAdminLogin(c *gin.Context) {
 		true, // Secure
 		true, // HttpOnly
 	)
+	return token, expiresAt, true
+}
 
-	c.JSON(http.StatusOK, AdminLoginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt.Unix(),
+// recordLoginFailure records a failed admin sign-in at step ("password",
+// "totp" or "oidc"); username is as typed or from the identity provider, and
+// may not exist
+func (ah *AdminHandler) recordLoginFailure(c *gin.Context, username, step string) {
+	recordAudit(c, ah.audit, models.AuditEvent{
+		ActorType: models.AuditActorAdmin,
+		ActorName: username,
+		Action:    models.AuditActionAdminLoginFailed,
+		After:     auditState(gin.H{"step": step}),
 	})
 }
 
-// HandleAdminLogout clears the admin token cookie
+// HandleAdminLogout revokes the admin session and clears the admin token cookie
 func (ah *AdminHandler) HandleAdminLogout(c *gin.Context) {
+	var endErr error
+	if sessionID, ok := c.Get("session_id"); ok && ah.sessions != nil {
+		endErr = ah.sessions.End(c.Request.Context(), sessionID.(uuid.UUID))
+		if endErr == nil {
+			event := adminAuditEvent(c, models.AuditActionAdminLogout)
+			event.TargetType, event.TargetID = models.AuditTargetSession, sessionID.(uuid.UUID).String()
+			recordAudit(c, ah.audit, event)
+		}
+	}
+
 	c.SetCookie(
 		"admin_token",
 		"",
@@ -180,6 +407,13 @@ func (ah *AdminHandler) HandleAdminLogout(c *gin.Context) {
 		true, // HttpOnly
 	)
 
+	if endErr != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to end session",
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"status": "logged out",
 	})
@@ -187,27 +421,32 @@ func (ah *AdminHandler) HandleAdminLogout(c *gin.Context) {
 
 // AdminStatusResponse represents the response for admin status check
 type AdminStatusResponse struct {
-	Authenticated bool   `json:"authenticated"`
-	AdminID       string `json:"admin_id"`
-	Username      string `json:"username"`
+	Authenticated bool             `json:"authenticated"`
+	AdminID       string           `json:"admin_id"`
+	Username      string           `json:"username"`
+	Role          models.AdminRole `json:"role"`
+	Permissions   []string         `json:"permissions"`
 }
 
 // HandleAdminStatus returns the current admin authentication status
 func (ah *AdminHandler) HandleAdminStatus(c *gin.Context) {
 	adminID, _ := c.Get("admin_id")
 	username, _ := c.Get("username")
+	role := middleware.AdminRoleFromContext(c)
 
 	c.JSON(http.StatusOK, AdminStatusResponse{
 		Authenticated: true,
 		AdminID:       adminID.(string),
 		Username:      username.(string),
+		Role:          role,
+		Permissions:   role.Permissions(),
 	})
 }
 
 // UserListResponse represents a list of users with total count
 type UserListResponse struct {
-	Users []services.User `json:"users"`
-	Total int             `json:"total"`
+	Users []models.User `json:"users"`
+	Total int           `json:"total"`
 }
 
 // HandleListUsers returns all users with their key information
@@ -241,3 +480,85 @@ func (ah *AdminHandler) HandleUndeliveredAlerts(c *gin.Context) {
 		"threshold_hours":   1,
 	})
 }
+
+// HandleStuckDeliveries returns the webhook keys over the delivery alert
+// thresholds and the alert monitor's status (GET /admin/alerts/stuck)
+func (ah *AdminHandler) HandleStuckDeliveries(c *gin.Context) {
+	if ah.deliveryAlerts == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "delivery alerts not configured",
+		})
+		return
+	}
+
+	stuck, err := ah.deliveryAlerts.Stuck(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to list stuck deliveries",
+		})
+		return
+	}
+	if stuck == nil {
+		stuck = []models.StuckDelivery{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stuck":   stuck,
+		"monitor": ah.deliveryAlerts.Status(),
+	})
+}
+
+// HandleStorageStats returns event storage totals and the compression ratio (GET /admin/stats/storage)
+func (ah *AdminHandler) HandleStorageStats(c *gin.Context) {
+	stats, err := ah.eventService.StorageStats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to get storage stats",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// HandleCleanupStatus returns the cleanup schedule and the last run report (GET /admin/cleanup)
+func (ah *AdminHandler) HandleCleanupStatus(c *gin.Context) {
+	if ah.cleanupService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "cleanup service not configured",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ah.cleanupService.Status())
+}
+
+// HandleRunCleanup starts a cleanup run in the background (POST /admin/cleanup/run).
+// Progress and results are read from GET /admin/cleanup.
+func (ah *AdminHandler) HandleRunCleanup(c *gin.Context) {
+	if ah.cleanupService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "cleanup service not configured",
+		})
+		return
+	}
+
+	if err := ah.cleanupService.Trigger(); err != nil {
+		if errors.Is(err, services.ErrCleanupRunning) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "cleanup already running",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to start cleanup",
+		})
+		return
+	}
+
+	recordAudit(c, ah.audit, adminAuditEvent(c, models.AuditActionCleanupRun))
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": "started",
+	})
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/middleware"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// AdminTOTPLoginRequest is the second step of an admin login
+type AdminTOTPLoginRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+}
+
+// AdminChallengeRequest starts a two-factor enrollment during login
+type AdminChallengeRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+}
+
+// AdminTOTPCodeRequest carries a code from the admin's authenticator app
+type AdminTOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// AdminTOTPStatusResponse is the response of GET /admin/totp
+type AdminTOTPStatusResponse struct {
+	Enabled           bool  `json:"enabled"`
+	EnabledAt         int64 `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int   `json:"recovery_codes_left"`
+	Required          bool  `json:"required"`
+}
+
+// writeTOTPError maps two-factor service errors to responses
+func writeTOTPError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidTOTPCode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+	case errors.Is(err, services.ErrTOTPAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+	case errors.Is(err, services.ErrTOTPNotEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is not enrolled"})
+	case errors.Is(err, services.ErrTOTPRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is required on this server"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "two-factor operation failed"})
+	}
+}
+
+// challengeAdmin resolves the admin behind a login challenge, writing a 401 if
+// the challenge is invalid, expired, or the admin was deactivated since
+func (ah *AdminHandler) challengeAdmin(c *gin.Context, challenge string) (*models.AdminUser, bool) {
+	claims, err := middleware.ValidateAdminChallengeToken(challenge)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login expired, sign in again"})
+		return nil, false
+	}
+	adminID, err := uuid.Parse(claims.AdminID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login expired, sign in again"})
+		return nil, false
+	}
+	admin, err := ah.adminService.GetAdminByID(c.Request.Context(), adminID)
+	if err != nil || !admin.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login expired, sign in again"})
+		return nil, false
+	}
+	return admin, true
+}
+
+// currentAdminID returns the admin that authenticated the request
+func currentAdminID(c *gin.Context) uuid.UUID {
+	id, _ := c.Get("admin_id")
+	s, _ := id.(string)
+	adminID, _ := uuid.Parse(s)
+	return adminID
+}
+
+// recordTOTPChange records event, a change an admin made to their own
+// two-factor settings
+func (ah *AdminHandler) recordTOTPChange(c *gin.Context, event models.AuditEvent) {
+	event.TargetType, event.TargetID = models.AuditTargetAdmin, event.ActorID
+	recordAudit(c, ah.audit, event)
+}
+
+// HandleAdminLoginTOTP finishes a login with a two-factor code
+// (POST /admin/login/totp). For an admin enrolling during login, the code
+// confirms the enrollment and the response carries their recovery codes.
+func (ah *AdminHandler) HandleAdminLoginTOTP(c *gin.Context) {
+	var req AdminTOTPLoginRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	admin, ok := ah.challengeAdmin(c, req.Challenge)
+	if !ok {
+		return
+	}
+
+	var recoveryCodes []string
+	var err error
+	if admin.TOTPEnabled() {
+		err = ah.adminService.VerifyTOTP(c.Request.Context(), admin.ID, req.Code)
+	} else {
+		recoveryCodes, err = ah.adminService.ConfirmTOTPEnrollment(c.Request.Context(), admin.ID, req.Code)
+	}
+	if errors.Is(err, services.ErrInvalidTOTPCode) {
+		ah.recordLoginFailure(c, admin.Username, "totp")
+	}
+	if err != nil {
+		writeTOTPError(c, err)
+		return
+	}
+	if len(recoveryCodes) > 0 {
+		ah.recordTOTPChange(c, signInAuditEvent(admin, models.AuditActionTOTPEnable))
+	}
+
+	ah.completeLogin(c, admin, recoveryCodes)
+}
+
+// HandleAdminLoginTOTPEnroll starts enrollment for an admin who must use
+// two-factor authentication but has not set it up (POST /admin/login/totp/enroll)
+func (ah *AdminHandler) HandleAdminLoginTOTPEnroll(c *gin.Context) {
+	var req AdminChallengeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	admin, ok := ah.challengeAdmin(c, req.Challenge)
+	if !ok {
+		return
+	}
+
+	enrollment, err := ah.adminService.BeginTOTPEnrollment(c.Request.Context(), admin.ID)
+	if err != nil {
+		writeTOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// HandleTOTPStatus reports the signed-in admin's two-factor status (GET /admin/totp)
+func (ah *AdminHandler) HandleTOTPStatus(c *gin.Context) {
+	admin, err := ah.adminService.GetAdminByID(c.Request.Context(), currentAdminID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "admin user not found"})
+		return
+	}
+
+	resp := AdminTOTPStatusResponse{
+		Enabled:           admin.TOTPEnabled(),
+		RecoveryCodesLeft: admin.RecoveryCodesLeft,
+		Required:          ah.adminService.TOTPRequired(),
+	}
+	if admin.TOTPEnabledAt != nil {
+		resp.EnabledAt = admin.TOTPEnabledAt.Unix()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleTOTPEnroll starts two-factor enrollment for the signed-in admin (POST /admin/totp/enroll)
+func (ah *AdminHandler) HandleTOTPEnroll(c *gin.Context) {
+	enrollment, err := ah.adminService.BeginTOTPEnrollment(c.Request.Context(), currentAdminID(c))
+	if err != nil {
+		writeTOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// HandleTOTPConfirm turns two-factor authentication on with a first code
+// (POST /admin/totp/confirm) and returns the recovery codes
+func (ah *AdminHandler) HandleTOTPConfirm(c *gin.Context) {
+	var req AdminTOTPCodeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	codes, err := ah.adminService.ConfirmTOTPEnrollment(c.Request.Context(), currentAdminID(c), req.Code)
+	if err != nil {
+		writeTOTPError(c, err)
+		return
+	}
+	ah.recordTOTPChange(c, adminAuditEvent(c, models.AuditActionTOTPEnable))
+	c.JSON(http.StatusOK, gin.H{"status": "enabled", "recovery_codes": codes})
+}
+
+// HandleTOTPRecoveryCodes replaces the signed-in admin's recovery codes
+// (POST /admin/totp/recovery-codes)
+func (ah *AdminHandler) HandleTOTPRecoveryCodes(c *gin.Context) {
+	var req AdminTOTPCodeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	codes, err := ah.adminService.RegenerateRecoveryCodes(c.Request.Context(), currentAdminID(c), req.Code)
+	if err != nil {
+		writeTOTPError(c, err)
+		return
+	}
+	ah.recordTOTPChange(c, adminAuditEvent(c, models.AuditActionTOTPRecoveryCodes))
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// HandleTOTPDisable turns two-factor authentication off (POST /admin/totp/disable)
+func (ah *AdminHandler) HandleTOTPDisable(c *gin.Context) {
+	var req AdminTOTPCodeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if err := ah.adminService.DisableTOTP(c.Request.Context(), currentAdminID(c), req.Code); err != nil {
+		writeTOTPError(c, err)
+		return
+	}
+	ah.recordTOTPChange(c, adminAuditEvent(c, models.AuditActionTOTPDisable))
+	c.JSON(http.StatusOK, gin.H{"status": "disabled"})
+}
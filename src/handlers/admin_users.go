@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// InviteAdminRequest is the body of POST /admin/admins
+type InviteAdminRequest struct {
+	Username string           `json:"username" binding:"required"`
+	Role     models.AdminRole `json:"role" binding:"required"`
+}
+
+// ChangeAdminRoleRequest is the body of PUT /admin/admins/:admin_id/role
+type ChangeAdminRoleRequest struct {
+	Role models.AdminRole `json:"role" binding:"required"`
+}
+
+// AcceptAdminInviteRequest is the body of POST /admin/invite/accept
+type AcceptAdminInviteRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// adminResponse is one admin in the admin-management endpoints
+type adminResponse struct {
+	ID            uuid.UUID        `json:"id"`
+	Username      string           `json:"username"`
+	Role          models.AdminRole `json:"role"`
+	IsActive      bool             `json:"is_active"`
+	InvitePending bool             `json:"invite_pending"`
+	TOTPEnabled   bool             `json:"totp_enabled"`
+	CreatedAt     int64            `json:"created_at"`
+	LastLogin     *int64           `json:"last_login,omitempty"`
+}
+
+// newAdminResponse leaves out secrets and hashes
+func newAdminResponse(a *models.AdminUser) adminResponse {
+	resp := adminResponse{
+		ID:            a.ID,
+		Username:      a.Username,
+		Role:          a.Role,
+		IsActive:      a.IsActive,
+		InvitePending: a.InvitePending(),
+		TOTPEnabled:   a.TOTPEnabled(),
+		CreatedAt:     a.CreatedAt.Unix(),
+	}
+	if a.LastLogin != nil {
+		lastLogin := a.LastLogin.Unix()
+		resp.LastLogin = &lastLogin
+	}
+	return resp
+}
+
+// writeAdminError maps admin-management service errors to responses
+func writeAdminError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidAdminUser):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAdminNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "admin user not found"})
+	case errors.Is(err, services.ErrAdminExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "username already taken"})
+	case errors.Is(err, services.ErrInvalidInvite):
+		c.JSON(http.StatusGone, gin.H{"error": "invite is invalid or expired"})
+	case errors.Is(err, services.ErrLastOwner), errors.Is(err, services.ErrAdminSelfChange):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update admin user"})
+	}
+}
+
+// parseAdminID reads the :admin_id path parameter, writing a 400 if it is not a UUID
+func parseAdminID(c *gin.Context) (uuid.UUID, bool) {
+	adminID, err := uuid.Parse(c.Param("admin_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid admin_id"})
+		return uuid.Nil, false
+	}
+	return adminID, true
+}
+
+// HandleListAdmins lists admin users and their roles (GET /admin/admins)
+func (ah *AdminHandler) HandleListAdmins(c *gin.Context) {
+	admins, err := ah.adminService.ListAdmins(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list admin users"})
+		return
+	}
+
+	resp := make([]adminResponse, 0, len(admins))
+	for i := range admins {
+		resp = append(resp, newAdminResponse(&admins[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"admins": resp})
+}
+
+// HandleInviteAdmin creates an admin who sets their own password through the
+// returned invite link (POST /admin/admins). The token is shown only once.
+func (ah *AdminHandler) HandleInviteAdmin(c *gin.Context) {
+	var req InviteAdminRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	admin, token, err := ah.adminService.InviteAdmin(c.Request.Context(), req.Username, req.Role)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	event := adminAuditEvent(c, models.AuditActionAdminInvite)
+	event.TargetType, event.TargetID = models.AuditTargetAdmin, admin.ID.String()
+	event.After = auditState(gin.H{"username": admin.Username, "role": admin.Role})
+	recordAudit(c, ah.audit, event)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"admin":             newAdminResponse(admin),
+		"invite_token":      token,
+		"invite_path":       "/admin#invite=" + token,
+		"invite_expires_at": admin.InviteExpiresAt.Unix(),
+	})
+}
+
+// HandleChangeAdminRole changes another admin's role and signs them out
+// (PUT /admin/admins/:admin_id/role)
+func (ah *AdminHandler) HandleChangeAdminRole(c *gin.Context) {
+	adminID, ok := parseAdminID(c)
+	if !ok {
+		return
+	}
+	var req ChangeAdminRoleRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	before, _ := ah.adminService.GetAdminByID(c.Request.Context(), adminID)
+	admin, err := ah.adminService.ChangeAdminRole(c.Request.Context(), currentAdminID(c), adminID, req.Role)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	if before != nil && before.Role != admin.Role {
+		event := adminAuditEvent(c, models.AuditActionAdminRoleChange)
+		event.TargetType, event.TargetID = models.AuditTargetAdmin, admin.ID.String()
+		event.Before = auditState(gin.H{"role": before.Role})
+		event.After = auditState(gin.H{"role": admin.Role})
+		recordAudit(c, ah.audit, event)
+	}
+	c.JSON(http.StatusOK, newAdminResponse(admin))
+}
+
+// HandleDeactivateAdmin blocks another admin from signing in and ends their
+// sessions (POST /admin/admins/:admin_id/deactivate)
+func (ah *AdminHandler) HandleDeactivateAdmin(c *gin.Context) {
+	ah.setAdminActive(c, false)
+}
+
+// HandleActivateAdmin lets a deactivated admin sign in again (POST /admin/admins/:admin_id/activate)
+func (ah *AdminHandler) HandleActivateAdmin(c *gin.Context) {
+	ah.setAdminActive(c, true)
+}
+
+// setAdminActive implements HandleDeactivateAdmin and HandleActivateAdmin
+func (ah *AdminHandler) setAdminActive(c *gin.Context, active bool) {
+	adminID, ok := parseAdminID(c)
+	if !ok {
+		return
+	}
+
+	before, _ := ah.adminService.GetAdminByID(c.Request.Context(), adminID)
+	admin, err := ah.adminService.SetAdminActive(c.Request.Context(), currentAdminID(c), adminID, active)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	if before != nil && before.IsActive != active {
+		event := adminAuditEvent(c, models.AuditActionAdminDeactivate)
+		if active {
+			event.Action = models.AuditActionAdminActivate
+		}
+		event.TargetType, event.TargetID = models.AuditTargetAdmin, admin.ID.String()
+		event.Before = auditState(gin.H{"active": before.IsActive})
+		event.After = auditState(gin.H{"active": active})
+		recordAudit(c, ah.audit, event)
+	}
+	c.JSON(http.StatusOK, newAdminResponse(admin))
+}
+
+// HandleAcceptAdminInvite sets an invited admin's password (POST /admin/invite/accept).
+// The admin then signs in normally, enrolling in two-factor authentication if required.
+func (ah *AdminHandler) HandleAcceptAdminInvite(c *gin.Context) {
+	var req AcceptAdminInviteRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	admin, err := ah.adminService.AcceptAdminInvite(c.Request.Context(), req.Token, req.Password)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	event := signInAuditEvent(admin, models.AuditActionAdminInviteAccept)
+	event.TargetType, event.TargetID = models.AuditTargetAdmin, admin.ID.String()
+	recordAudit(c, ah.audit, event)
+	c.JSON(http.StatusOK, gin.H{"status": "accepted", "username": admin.Username})
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/middleware"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// adminAuditEvent starts an audit event for an action by the signed-in admin
+func adminAuditEvent(c *gin.Context, action string) models.AuditEvent {
+	adminID, _ := c.Get("admin_id")
+	username, _ := c.Get("username")
+	id, _ := adminID.(string)
+	name, _ := username.(string)
+	return models.AuditEvent{ActorType: models.AuditActorAdmin, ActorID: id, ActorName: name, Action: action}
+}
+
+// signInAuditEvent starts an audit event for an action by admin during sign-in,
+// before the request carries their identity
+func signInAuditEvent(admin *models.AdminUser, action string) models.AuditEvent {
+	return models.AuditEvent{ActorType: models.AuditActorAdmin, ActorID: admin.ID.String(), ActorName: admin.Username, Action: action}
+}
+
+// userAuditEvent starts an audit event for an action a user took on their own account
+func userAuditEvent(email, action string) models.AuditEvent {
+	return models.AuditEvent{ActorType: models.AuditActorUser, ActorID: email, ActorName: email, Action: action, UserEmail: email}
+}
+
+// auditState encodes the before or after state of an audited change
+func auditState(state any) json.RawMessage {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// recordAudit stamps event with the request's client IP and request ID and
+// appends it to the audit log. The action has already happened, so a failure
+// is only logged (by the service); a nil audit service records nothing.
+func recordAudit(c *gin.Context, audit *services.AuditService, event models.AuditEvent) {
+	if audit == nil {
+		return
+	}
+	event.IP = c.ClientIP()
+	event.RequestID = middleware.GetRequestID(c)
+	_ = audit.Record(c.Request.Context(), &event)
+}
+
+// parseAuditFilter reads the audit query parameters shared by the list and
+// export endpoints: action, target, since, until (RFC 3339), before (a seq
+// from the previous page) and limit, plus actor and user where allowed.
+// On failure it writes a 400 and returns false.
+func parseAuditFilter(c *gin.Context, withActorAndUser bool) (models.AuditFilter, bool) {
+	filter := models.AuditFilter{
+		Action:   c.Query("action"),
+		TargetID: c.Query("target"),
+	}
+	if withActorAndUser {
+		filter.ActorID = c.Query("actor")
+		filter.UserEmail = c.Query("user")
+	}
+
+	var ok bool
+	if filter.Since, ok = parseAuditTime(c, "since"); !ok {
+		return filter, false
+	}
+	if filter.Until, ok = parseAuditTime(c, "until"); !ok {
+		return filter, false
+	}
+	if v := c.Query("before"); v != "" {
+		seq, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || seq <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before"})
+			return filter, false
+		}
+		filter.BeforeSeq = seq
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return filter, false
+		}
+		filter.Limit = limit
+	}
+	return filter, true
+}
+
+// parseAuditTime reads an optional RFC 3339 query parameter, writing a 400 if it is malformed
+func parseAuditTime(c *gin.Context, param string) (*time.Time, bool) {
+	v := c.Query(param)
+	if v == "" {
+		return nil, true
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC 3339 time"})
+		return nil, false
+	}
+	return &t, true
+}
+
+// writeAuditPage writes one page of events with the cursor for the next one
+func writeAuditPage(c *gin.Context, events []models.AuditEvent, limit int) {
+	if events == nil {
+		events = []models.AuditEvent{}
+	}
+	resp := gin.H{"events": events, "count": len(events)}
+	if limit <= 0 {
+		limit = services.DefaultAuditPageSize
+	}
+	if len(events) == min(limit, services.MaxAuditPageSize) {
+		resp["next_before"] = events[len(events)-1].Seq
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// auditCSVHeader is the first row of a CSV export
+var auditCSVHeader = []string{
+	"seq", "id", "created_at", "actor_type", "actor_id", "actor_name", "action", "target_type", "target_id",
+	"user_email", "ip", "request_id", "before", "after", "prev_hash", "hash",
+}
+
+// csvCell keeps a value from being read as a formula by spreadsheet apps;
+// request IDs, for one, come from a client header
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+// writeAuditExport streams the events export yields as an attachment, as
+// JSON lines or, with ?format=csv, CSV
+func writeAuditExport(c *gin.Context, name string, export func(fn func(*models.AuditEvent) error) error) {
+	format := c.DefaultQuery("format", "jsonl")
+	if format != "jsonl" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be jsonl or csv"})
+		return
+	}
+
+	filename := name + "-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	var write func(*models.AuditEvent) error
+	var flush func() error
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		if err := w.Write(auditCSVHeader); err != nil {
+			return
+		}
+		write = func(e *models.AuditEvent) error {
+			row := []string{
+				strconv.FormatInt(e.Seq, 10), e.ID.String(), e.CreatedAt.UTC().Format(time.RFC3339Nano),
+				string(e.ActorType), e.ActorID, e.ActorName, e.Action, e.TargetType, e.TargetID,
+				e.UserEmail, e.IP, e.RequestID, string(e.Before), string(e.After), e.PrevHash, e.Hash,
+			}
+			for i := range row {
+				row[i] = csvCell(row[i])
+			}
+			return w.Write(row)
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(c.Writer)
+		write = func(e *models.AuditEvent) error { return enc.Encode(e) }
+		flush = func() error { return nil }
+	}
+
+	err := export(write)
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		if !c.Writer.Written() {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export audit log"})
+			return
+		}
+		// The status is already sent; the truncated download is all we can do
+		log.Printf("Audit log export failed: %v", err)
+	}
+}
+
+// HandleListAudit returns a page of the audit log, newest first (GET /admin/audit).
+// Filters: actor, action (exact or a prefix ending in "."), target, user,
+// since, until; page with before=<next_before of the previous page>.
+func (ah *AdminHandler) HandleListAudit(c *gin.Context) {
+	filter, ok := parseAuditFilter(c, true)
+	if !ok {
+		return
+	}
+	events, err := ah.audit.List(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list audit events"})
+		return
+	}
+	writeAuditPage(c, events, filter.Limit)
+}
+
+// HandleExportAudit downloads every audit event matching the list filters
+// (GET /admin/audit/export?format=jsonl|csv)
+func (ah *AdminHandler) HandleExportAudit(c *gin.Context) {
+	filter, ok := parseAuditFilter(c, true)
+	if !ok {
+		return
+	}
+	writeAuditExport(c, "audit", func(fn func(*models.AuditEvent) error) error {
+		return ah.audit.Export(c.Request.Context(), filter, fn)
+	})
+}
+
+// HandleVerifyAudit checks the audit log's hash chain (GET /admin/audit/verify)
+func (ah *AdminHandler) HandleVerifyAudit(c *gin.Context) {
+	result, err := ah.audit.Verify(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// HandleListUserAudit returns a page of the audit events concerning the user,
+// newest first (GET /dashboard/api/audit); filters as for /admin/audit,
+// without actor and user
+func (dh *DashboardHandler) HandleListUserAudit(c *gin.Context) {
+	email, ok := dh.authenticate(c, models.ScopeAuditRead)
+	if !ok {
+		return
+	}
+	filter, ok := parseAuditFilter(c, false)
+	if !ok {
+		return
+	}
+	filter.UserEmail = email
+
+	events, err := dh.audit.List(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list audit events"})
+		return
+	}
+	for i := range events {
+		events[i] = events[i].ForUser()
+	}
+	writeAuditPage(c, events, filter.Limit)
+}
+
+// HandleExportUserAudit downloads every audit event concerning the user
+// (GET /dashboard/api/audit/export?format=jsonl|csv)
+func (dh *DashboardHandler) HandleExportUserAudit(c *gin.Context) {
+	email, ok := dh.authenticate(c, models.ScopeAuditRead)
+	if !ok {
+		return
+	}
+	filter, ok := parseAuditFilter(c, false)
+	if !ok {
+		return
+	}
+	filter.UserEmail = email
+
+	writeAuditExport(c, "my-audit", func(fn func(*models.AuditEvent) error) error {
+		return dh.audit.Export(c.Request.Context(), filter, func(e *models.AuditEvent) error {
+			shown := e.ForUser()
+			return fn(&shown)
+		})
+	})
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+func TestUserAudit_ListsOwnEventsWithoutAdminDetails(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, dh := setupUserDashboard(t, tdb, "alice@example.com", "bob@example.com")
+		alice, _ := signInUser(t, dh, "alice@example.com")
+		_, phoneID := signInUser(t, dh, "alice@example.com")
+		bob, _ := signInUser(t, dh, "bob@example.com")
+
+		assertStatusCode(t, serveRequest(router, http.MethodDelete, "/dashboard/api/sessions/"+phoneID.String(), alice, ""), http.StatusOK)
+		adminEvent := models.AuditEvent{
+			ActorType: models.AuditActorAdmin, ActorID: "admin-id", ActorName: "root",
+			Action: models.AuditActionKeyDeactivate, UserEmail: "alice@example.com", IP: "198.51.100.7",
+		}
+		if err := dh.audit.Record(context.Background(), &adminEvent); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+
+		w := serveRequest(router, http.MethodGet, "/dashboard/api/audit", alice, "")
+		assertStatusCode(t, w, http.StatusOK)
+		var resp struct {
+			Events []models.AuditEvent `json:"events"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if len(resp.Events) != 2 {
+			t.Fatalf("expected alice's 2 events, got %+v", resp.Events)
+		}
+		admin, revoke := resp.Events[0], resp.Events[1]
+		if admin.ActorType != models.AuditActorAdmin || admin.ActorID != "" || admin.ActorName != "" || admin.IP != "" {
+			t.Errorf("expected the admin's identity hidden, got %+v", admin)
+		}
+		if revoke.Action != models.AuditActionSessionRevoke || revoke.TargetID != phoneID.String() || revoke.ActorName != "alice@example.com" {
+			t.Errorf("unexpected session revoke event: %+v", revoke)
+		}
+		for _, e := range resp.Events {
+			if e.Hash != "" || e.PrevHash != "" {
+				t.Errorf("expected no chain hashes in the user view, got %+v", e)
+			}
+		}
+
+		w = serveRequest(router, http.MethodGet, "/dashboard/api/audit", bob, "")
+		assertStatusCode(t, w, http.StatusOK)
+		if !strings.Contains(w.Body.String(), `"count":0`) {
+			t.Errorf("expected bob to see no events, got %s", w.Body.String())
+		}
+
+		w = serveRequest(router, http.MethodGet, "/dashboard/api/audit/export?format=csv", alice, "")
+		assertStatusCode(t, w, http.StatusOK)
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if len(lines) != 3 || !strings.HasPrefix(lines[0], "seq,id,created_at") || strings.Contains(w.Body.String(), "198.51.100.7") {
+			t.Errorf("unexpected CSV export:\n%s", w.Body.String())
+		}
+		assertStatusCode(t, serveRequest(router, http.MethodGet, "/dashboard/api/audit/export?format=xml", alice, ""), http.StatusBadRequest)
+		assertStatusCode(t, serveRequest(router, http.MethodGet, "/dashboard/api/audit?since=yesterday", alice, ""), http.StatusBadRequest)
+	})
+}
+
+func TestCSVCell(t *testing.T) {
+	for in, want := range map[string]string{"": "", "req-1": "req-1", "=HYPERLINK(1)": "'=HYPERLINK(1)", "@cmd": "'@cmd", "-1": "'-1"} {
+		if got := csvCell(in); got != want {
+			t.Errorf("csvCell(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
 )
 
@@ -16,6 +18,8 @@ type AuthHandler struct {
 	emailService      *services.EmailService
 	mailerliteService *services.MailerLiteService
 	analyticsService  *services.AnalyticsService
+	sessions          *services.SessionService
+	audit             *services.AuditService
 }
 
 // NewAuthHandler creates a new authentication handler
@@ -33,6 +37,17 @@ func NewAuthHandler(
 	}
 }
 
+// SetSessions records each sign-in as a server-side session that the
+// dashboard can list and revoke
+func (h *AuthHandler) SetSessions(sessions *services.SessionService) {
+	h.sessions = sessions
+}
+
+// SetAudit records each magic link sign-in in the audit log
+func (h *AuthHandler) SetAudit(audit *services.AuditService) {
+	h.audit = audit
+}
+
 // RegisterRequest represents a user registration request
 type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
@@ -265,27 +280,10 @@ func (h *AuthHandler) HandleVerifyMagicLink(c *gin.Context) {
 		return
 	}
 
-	// Generate session token (JWT)
-	sessionToken, err := h.authService.GenerateSessionToken(email, 24*30) // 30 days
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "server_error",
-			"message": "Failed to create session",
-		})
+	if !startUserSession(c, h.authService, h.sessions, h.audit, email, "magic_link") {
 		return
 	}
 
-	// Set HTTP-only cookie
-	c.SetCookie(
-		"session_token",
-		sessionToken,
-		30*24*3600, // 30 days
-		"/",
-		"",
-		true,  // Secure (HTTPS only)
-		true,  // HttpOnly
-	)
-
 	// Track account_activated
 	if h.analyticsService != nil {
 		h.analyticsService.TrackAccountActivated(ctx, services.HashEmail(email))
@@ -318,3 +316,49 @@ func (h *AuthHandler) HandleVerifyMagicLink(c *gin.Context) {
 	// Redirect to dashboard
 	c.Redirect(http.StatusFound, "/dashboard")
 }
+
+// startUserSession signs email in: it records a server-side session so it can
+// be listed and revoked from the dashboard, audits the login with method, and
+// sets the session cookie. On failure it writes a 500 and returns false.
+func startUserSession(c *gin.Context, auth *services.AuthService, sessions *services.SessionService, audit *services.AuditService, email, method string) bool {
+	// Record the sign-in so it can be listed and revoked from the dashboard
+	sessionID := uuid.Nil
+	if sessions != nil {
+		session, err := sessions.StartUserSession(c.Request.Context(), email, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "server_error",
+				"message": "Failed to create session",
+			})
+			return false
+		}
+		sessionID = session.ID
+	}
+
+	// Generate session token (JWT)
+	sessionToken, err := auth.GenerateSessionToken(email, sessionID, 24*30) // 30 days
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Failed to create session",
+		})
+		return false
+	}
+
+	event := userAuditEvent(email, models.AuditActionUserLogin)
+	event.TargetType, event.TargetID = models.AuditTargetSession, sessionID.String()
+	event.After = auditState(gin.H{"method": method})
+	recordAudit(c, audit, event)
+
+	// Set HTTP-only cookie
+	c.SetCookie(
+		"session_token",
+		sessionToken,
+		30*24*3600, // 30 days
+		"/",
+		"",
+		true,  // Secure (HTTPS only)
+		true,  // HttpOnly
+	)
+	return true
+}
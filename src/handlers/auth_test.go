@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// recordingSender collects the emails the handlers send
+type recordingSender struct {
+	sent chan *services.EmailMessage
+	err  error
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg *services.EmailMessage) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent <- msg
+	return nil
+}
+
+// next returns the next sent email, waiting for ones sent in the background
+func (s *recordingSender) next(t *testing.T) *services.EmailMessage {
+	t.Helper()
+	select {
+	case msg := <-s.sent:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected an email to be sent")
+		return nil
+	}
+}
+
+func newAuthTestRouter(t *testing.T, sender services.EmailSender) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	ts := memory.NewTestStore(t)
+	authService := services.NewAuthService(ts.Repos.Users, ts.Repos.Keys, ts.Repos.AuthTokens, ts.KeyHasher, "test-secret-that-is-long-enough-32", 3600, "http://localhost")
+	emailService := services.NewEmailService(sender, "noreply@example.com", "Obsidian Webhooks")
+	h := NewAuthHandler(authService, emailService, nil, nil)
+	h.SetSessions(services.NewSessionService(ts.Repos.Sessions, ts.Repos.Users))
+
+	router := gin.New()
+	router.POST("/auth/register", h.HandleRegister)
+	router.POST("/auth/request-login", h.HandleRequestLogin)
+	router.GET("/auth/verify", h.HandleVerifyMagicLink)
+	return router
+}
+
+var magicLinkPattern = regexp.MustCompile(`http://localhost/auth/verify\?token=[A-Za-z0-9_=-]+`)
+
+// magicLinkPath returns the path of the magic link in msg
+func magicLinkPath(t *testing.T, msg *services.EmailMessage) string {
+	t.Helper()
+	link := magicLinkPattern.FindString(msg.Text)
+	if link == "" || !strings.Contains(msg.HTML, link) {
+		t.Fatalf("expected a magic link in both parts of the email, got %q", msg.Text)
+	}
+	return strings.TrimPrefix(link, "http://localhost")
+}
+
+func postJSON(router *gin.Engine, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuth_MagicLinkEmails(t *testing.T) {
+	sender := &recordingSender{sent: make(chan *services.EmailMessage, 4)}
+	router := newAuthTestRouter(t, sender)
+
+	w := postJSON(router, "/auth/register", `{"email":"alice@example.com","name":"Alice"}`)
+	assertStatusCode(t, w, http.StatusOK)
+	msg := sender.next(t)
+	if msg.To != "alice@example.com" || msg.From != "Obsidian Webhooks <noreply@example.com>" {
+		t.Errorf("unexpected addresses: from %q to %q", msg.From, msg.To)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, magicLinkPath(t, msg), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assertStatusCode(t, w, http.StatusFound)
+	if welcome := sender.next(t); welcome.To != "alice@example.com" || magicLinkPattern.MatchString(welcome.Text) {
+		t.Errorf("expected a welcome email without a magic link, got %+v", welcome)
+	}
+
+	w = postJSON(router, "/auth/request-login", `{"email":"alice@example.com"}`)
+	assertStatusCode(t, w, http.StatusOK)
+	if path := magicLinkPath(t, sender.next(t)); path == magicLinkPath(t, msg) {
+		t.Error("expected a new magic link for the login request")
+	}
+}
+
+func TestAuth_SendFailure(t *testing.T) {
+	router := newAuthTestRouter(t, &recordingSender{err: errors.New("connection refused")})
+
+	w := postJSON(router, "/auth/register", `{"email":"alice@example.com","name":"Alice"}`)
+	assertStatusCode(t, w, http.StatusInternalServerError)
+}
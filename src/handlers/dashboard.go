@@ -2,13 +2,15 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
 )
@@ -17,8 +19,12 @@ import (
 type DashboardHandler struct {
 	keyService   *services.KeyService
 	eventService *services.EventService
-	db           *pgxpool.Pool
 	authService  *services.AuthService
+	apiTokens    *services.APITokenService
+	sessions     *services.SessionService
+	audit        *services.AuditService
+	accounts     *services.AccountService
+	mailerlite   *services.MailerLiteService
 }
 
 // NewDashboardHandler creates a new dashboard handler
@@ -30,49 +36,137 @@ func NewDashboardHandler(keyService *services.KeyService, eventService *services
 }
 
 // NewDashboardHandlerWithAuth creates a new dashboard handler with auth service
-func NewDashboardHandlerWithAuth(db *pgxpool.Pool, authService *services.AuthService, keyService *services.KeyService) *DashboardHandler {
+func NewDashboardHandlerWithAuth(authService *services.AuthService, keyService *services.KeyService) *DashboardHandler {
 	return &DashboardHandler{
-		db:          db,
 		authService: authService,
 		keyService:  keyService,
 	}
 }
 
-// UserDashboardData represents the user's dashboard data
-type UserDashboardData struct {
-	Email string             `json:"email"`
-	Name  string             `json:"name"`
-	Keys  []services.KeyPair `json:"keys"`
+// SetEventService enables the user event routes
+func (dh *DashboardHandler) SetEventService(eventService *services.EventService) {
+	dh.eventService = eventService
 }
 
-// HandleDashboardPage serves the dashboard HTML page
-func (dh *DashboardHandler) HandleDashboardPage(c *gin.Context) {
-	c.File("./src/templates/dashboard.html")
+// SetAPITokens accepts personal access tokens on the dashboard API alongside
+// the session cookie
+func (dh *DashboardHandler) SetAPITokens(apiTokens *services.APITokenService) {
+	dh.apiTokens = apiTokens
 }
 
-// HandleGetUserData returns the authenticated user's data (GET /dashboard/api/me)
-func (dh *DashboardHandler) HandleGetUserData(c *gin.Context) {
+// SetSessions checks the server-side session behind every session cookie
+// and enables the session list and sign-out-everywhere routes
+func (dh *DashboardHandler) SetSessions(sessions *services.SessionService) {
+	dh.sessions = sessions
+}
+
+// SetAudit records key, event, token and session changes in the audit log
+// and enables the user's audit log routes
+func (dh *DashboardHandler) SetAudit(audit *services.AuditService) {
+	dh.audit = audit
+}
+
+// SetAccounts enables the account export and deletion routes
+func (dh *DashboardHandler) SetAccounts(accounts *services.AccountService) {
+	dh.accounts = accounts
+}
+
+// SetMailerLite unsubscribes deleted accounts from the mailing list
+func (dh *DashboardHandler) SetMailerLite(mailerlite *services.MailerLiteService) {
+	dh.mailerlite = mailerlite
+}
+
+// sessionIDKey holds the ID of the session that authenticated the request
+const sessionIDKey = "session_id"
+
+// authenticate resolves the user from an "Authorization: Bearer" personal
+// access token granted scope, or else from the session cookie, which carries
+// every scope. On failure it writes the error response and returns false.
+func (dh *DashboardHandler) authenticate(c *gin.Context, scope string) (string, bool) {
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		if dh.apiTokens == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return "", false
+		}
+		token, err := dh.apiTokens.Authenticate(c.Request.Context(), strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+			return "", false
+		}
+		if !token.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "token lacks scope " + scope})
+			return "", false
+		}
+		return token.UserEmail, true
+	}
+	return dh.authenticateSession(c)
+}
+
+// authenticateSession resolves the user from the session cookie only; token
+// management is not available to tokens themselves
+func (dh *DashboardHandler) authenticateSession(c *gin.Context) (string, bool) {
 	cookie, err := c.Cookie("session_token")
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
+		return "", false
 	}
 
-	email, err := dh.authService.VerifySessionToken(cookie)
+	email, sessionID, err := dh.authService.VerifySessionToken(cookie)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return "", false
+	}
+	if dh.sessions != nil {
+		_, err := dh.sessions.Validate(c.Request.Context(), sessionID)
+		if errors.Is(err, services.ErrSessionInvalid) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session expired, please sign in again"})
+			return "", false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check session"})
+			return "", false
+		}
+		c.Set(sessionIDKey, sessionID)
+	}
+	return email, true
+}
+
+// UserDashboardData represents the user's dashboard data
+type UserDashboardData struct {
+	Email             string           `json:"email"`
+	Name              string           `json:"name"`
+	Keys              []models.KeyPair `json:"keys"`
+	RetentionDefaults RetentionDays    `json:"retention_defaults"`
+	DeliveryAlerts    bool             `json:"delivery_alerts"`
+}
+
+// RetentionDays is the server-wide retention shown for keys without overrides
+type RetentionDays struct {
+	EventTTLDays     int `json:"event_ttl_days"`
+	ProcessedTTLDays int `json:"processed_ttl_days"`
+}
+
+// HandleDashboardPage serves the dashboard HTML page
+func (dh *DashboardHandler) HandleDashboardPage(c *gin.Context) {
+	c.File("./src/templates/dashboard.html")
+}
+
+// HandleGetUserData returns the authenticated user's data (GET /dashboard/api/me)
+func (dh *DashboardHandler) HandleGetUserData(c *gin.Context) {
+	email, ok := dh.authenticate(c, models.ScopeKeysRead)
+	if !ok {
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 	defer cancel()
 
-	// Get user name
+	// Get user name and settings
 	var name string
-	_ = dh.db.QueryRow(ctx, `
-		SELECT COALESCE(user_name, '') FROM api_keys
-		WHERE user_email = $1 AND key_type = 'webhook' LIMIT 1
-	`, email).Scan(&name)
+	deliveryAlerts := true
+	if profile, err := dh.keyService.GetUserProfile(ctx, email); err == nil {
+		name, deliveryAlerts = profile.Name, profile.DeliveryAlerts
+	}
 
 	// Get all key pairs
 	keys, err := dh.keyService.GetUserKeyPairs(ctx, email)
@@ -81,19 +175,45 @@ func (dh *DashboardHandler) HandleGetUserData(c *gin.Context) {
 		return
 	}
 	if keys == nil {
-		keys = []services.KeyPair{}
+		keys = []models.KeyPair{}
 	}
 
+	defaults := dh.keyService.RetentionDefaults()
 	c.JSON(http.StatusOK, UserDashboardData{
 		Email: email,
 		Name:  name,
 		Keys:  keys,
+		RetentionDefaults: RetentionDays{
+			EventTTLDays:     int(defaults.Unprocessed / (24 * time.Hour)),
+			ProcessedTTLDays: int(defaults.Processed / (24 * time.Hour)),
+		},
+		DeliveryAlerts: deliveryAlerts,
 	})
 }
 
-// HandleLogout logs out the user by clearing the session cookie
+// HandleLogout logs out the user by revoking their session and clearing the
+// session cookie
 func (dh *DashboardHandler) HandleLogout(c *gin.Context) {
-	// Clear session cookie
+	var endErr error
+	if cookie, err := c.Cookie("session_token"); err == nil && dh.sessions != nil {
+		if _, sessionID, err := dh.authService.VerifySessionToken(cookie); err == nil && sessionID != uuid.Nil {
+			endErr = dh.sessions.End(c.Request.Context(), sessionID)
+		}
+	}
+
+	clearSessionCookie(c)
+
+	if endErr != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to end session"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Logged out successfully",
+	})
+}
+
+// clearSessionCookie expires the dashboard session cookie
+func clearSessionCookie(c *gin.Context) {
 	c.SetCookie(
 		"session_token",
 		"",
@@ -103,10 +223,6 @@ func (dh *DashboardHandler) HandleLogout(c *gin.Context) {
 		true, // Secure (HTTPS only)
 		true, // HttpOnly
 	)
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Logged out successfully",
-	})
 }
 
 // HandleGetEvents returns paginated events
@@ -202,23 +318,34 @@ func (dh *DashboardHandler) HandleDeleteEvent(c *gin.Context) {
 		return
 	}
 
+	auditEvent := adminAuditEvent(c, models.AuditActionEventDelete)
+	auditEvent.TargetType, auditEvent.TargetID = models.AuditTargetEvent, eventID.String()
+	if info, err := dh.keyService.GetKeyByID(c.Request.Context(), event.WebhookKeyID.String()); err == nil {
+		auditEvent.UserEmail = info.UserEmail
+	}
+	auditEvent.Before = eventAuditState(event)
+	recordAudit(c, dh.audit, auditEvent)
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":   "deleted",
 		"event_id": eventID,
 	})
 }
 
+// eventAuditState describes a deleted event without its payload
+func eventAuditState(event *models.Event) json.RawMessage {
+	return auditState(gin.H{
+		"webhook_key_id": event.WebhookKeyID,
+		"path":           event.Path,
+		"processed":      event.Processed,
+		"created_at":     event.CreatedAt,
+	})
+}
+
 // HandleGetLogs returns webhook logs for the authenticated user (GET /dashboard/api/logs)
 func (dh *DashboardHandler) HandleGetLogs(c *gin.Context) {
-	cookie, err := c.Cookie("session_token")
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-
-	email, err := dh.authService.VerifySessionToken(cookie)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	email, ok := dh.authenticate(c, models.ScopeLogsRead)
+	if !ok {
 		return
 	}
 
@@ -245,7 +372,7 @@ func (dh *DashboardHandler) HandleGetLogs(c *gin.Context) {
 	}
 
 	if logs == nil {
-		logs = []services.WebhookLogEntry{}
+		logs = []models.WebhookLogEntry{}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -256,15 +383,8 @@ func (dh *DashboardHandler) HandleGetLogs(c *gin.Context) {
 
 // HandleRevokeKeys deactivates a specific key pair (POST /dashboard/api/revoke)
 func (dh *DashboardHandler) HandleRevokeKeys(c *gin.Context) {
-	cookie, err := c.Cookie("session_token")
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-
-	email, err := dh.authService.VerifySessionToken(cookie)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	email, ok := dh.authenticate(c, models.ScopeKeysWrite)
+	if !ok {
 		return
 	}
 
@@ -290,20 +410,132 @@ func (dh *DashboardHandler) HandleRevokeKeys(c *gin.Context) {
 		return
 	}
 
+	event := userAuditEvent(email, models.AuditActionPairRevoke)
+	event.TargetType, event.TargetID = models.AuditTargetKeyPair, pairID.String()
+	event.After = auditState(gin.H{"active": false})
+	recordAudit(c, dh.audit, event)
+
 	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
 }
 
-// HandleCreateNewKeyPair creates a new key pair for the user (POST /dashboard/api/keys/new)
-func (dh *DashboardHandler) HandleCreateNewKeyPair(c *gin.Context) {
-	cookie, err := c.Cookie("session_token")
+// HandleRotateKey gives one side of a key pair a new value (POST /dashboard/api/keys/rotate).
+// The old value keeps working for overlap_hours (default 24, 0 = stop at once).
+func (dh *DashboardHandler) HandleRotateKey(c *gin.Context) {
+	email, ok := dh.authenticate(c, models.ScopeKeysWrite)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		PairID       string `json:"pair_id" binding:"required"`
+		Key          string `json:"key" binding:"required"`
+		OverlapHours *int   `json:"overlap_hours"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pair_id and key are required"})
+		return
+	}
+
+	pairID, err := uuid.Parse(req.PairID)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pair_id"})
 		return
 	}
 
-	email, err := dh.authService.VerifySessionToken(cookie)
+	overlap := services.DefaultRotationOverlap
+	if req.OverlapHours != nil {
+		overlap = time.Duration(*req.OverlapHours) * time.Hour
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	keyValue, previousExpiresAt, err := dh.keyService.RotateUserKey(ctx, email, pairID, models.KeyType(req.Key), overlap)
+	switch {
+	case errors.Is(err, services.ErrInvalidKeyType):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key must be webhook or client"})
+		return
+	case errors.Is(err, services.ErrInvalidOverlap):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "key pair not found"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate key"})
+		return
+	}
+
+	event := userAuditEvent(email, models.AuditActionKeyRotate)
+	event.TargetType, event.TargetID = models.AuditTargetKeyPair, pairID.String()
+	event.After = auditState(gin.H{"key": req.Key, "previous_key_expires_at": previousExpiresAt})
+	recordAudit(c, dh.audit, event)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":                  "rotated",
+		"key":                     req.Key,
+		"key_value":               keyValue,
+		"previous_key_expires_at": previousExpiresAt,
+	})
+}
+
+// HandleUpdateRetention changes how long a key pair's events are kept (POST /dashboard/api/retention).
+// A null period resets it to the server default.
+func (dh *DashboardHandler) HandleUpdateRetention(c *gin.Context) {
+	email, ok := dh.authenticate(c, models.ScopeKeysWrite)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		PairID           string `json:"pair_id" binding:"required"`
+		EventTTLDays     *int   `json:"event_ttl_days"`
+		ProcessedTTLDays *int   `json:"processed_ttl_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pair_id is required"})
+		return
+	}
+
+	pairID, err := uuid.Parse(req.PairID)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pair_id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	settings := models.RetentionSettings{EventTTLDays: req.EventTTLDays, ProcessedTTLDays: req.ProcessedTTLDays}
+	deleted, err := dh.keyService.UpdateKeyRetention(ctx, email, pairID, settings)
+	switch {
+	case errors.Is(err, services.ErrInvalidRetention):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "key pair not found"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update retention"})
+		return
+	}
+
+	event := userAuditEvent(email, models.AuditActionRetentionUpdate)
+	event.TargetType, event.TargetID = models.AuditTargetKeyPair, pairID.String()
+	event.After = auditState(gin.H{"retention": settings, "events_deleted": deleted})
+	recordAudit(c, dh.audit, event)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":         "updated",
+		"retention":      settings,
+		"events_deleted": deleted,
+	})
+}
+
+// HandleCreateNewKeyPair creates a new key pair for the user (POST /dashboard/api/keys/new)
+func (dh *DashboardHandler) HandleCreateNewKeyPair(c *gin.Context) {
+	email, ok := dh.authenticate(c, models.ScopeKeysWrite)
+	if !ok {
 		return
 	}
 
@@ -311,18 +543,14 @@ func (dh *DashboardHandler) HandleCreateNewKeyPair(c *gin.Context) {
 	defer cancel()
 
 	// Get user info from existing keys
-	var name, language string
-	err = dh.db.QueryRow(ctx, `
-		SELECT COALESCE(user_name, ''), COALESCE(preferred_language, 'en')
-		FROM api_keys WHERE user_email = $1 AND key_type = 'webhook' LIMIT 1
-	`, email).Scan(&name, &language)
+	profile, err := dh.keyService.GetUserProfile(ctx, email)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user info"})
 		return
 	}
 
 	// Create new pair (old keys stay as-is)
-	webhookKey, clientKey, err := dh.authService.CreateUserKeyPair(ctx, email, name, language)
+	webhookKey, clientKey, err := dh.authService.CreateUserKeyPair(ctx, email, profile.Name, profile.Language)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create new keys"})
 		return
@@ -333,3 +561,100 @@ func (dh *DashboardHandler) HandleCreateNewKeyPair(c *gin.Context) {
 		"client_key":  clientKey,
 	})
 }
+
+// HandleGetUserEvents returns the newest events of one of the user's key
+// pairs (GET /dashboard/api/events?pair_id=...)
+func (dh *DashboardHandler) HandleGetUserEvents(c *gin.Context) {
+	email, ok := dh.authenticate(c, models.ScopeEventsRead)
+	if !ok {
+		return
+	}
+
+	pairID, err := uuid.Parse(c.Query("pair_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pair_id"})
+		return
+	}
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	owned, err := dh.keyService.UserOwnsKeyPair(ctx, email, pairID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get events"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key pair not found"})
+		return
+	}
+
+	events, err := dh.eventService.GetEventsByWebhookKey(ctx, pairID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get events"})
+		return
+	}
+	if events == nil {
+		events = []models.Event{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"count":  len(events),
+	})
+}
+
+// HandleDeleteUserEvent deletes an event from one of the user's key pairs
+// (DELETE /dashboard/api/events/:event_id)
+func (dh *DashboardHandler) HandleDeleteUserEvent(c *gin.Context) {
+	email, ok := dh.authenticate(c, models.ScopeEventsWrite)
+	if !ok {
+		return
+	}
+
+	eventID, err := uuid.Parse(c.Param("event_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid event_id format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	// Events of other users' pairs are reported as missing, not forbidden
+	event, err := dh.eventService.GetEventByID(ctx, eventID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "event not found"})
+		return
+	}
+	owned, err := dh.keyService.UserOwnsKeyPair(ctx, email, event.WebhookKeyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete event"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "event not found"})
+		return
+	}
+
+	if err := dh.eventService.DeleteEvent(ctx, eventID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete event"})
+		return
+	}
+
+	auditEvent := userAuditEvent(email, models.AuditActionEventDelete)
+	auditEvent.TargetType, auditEvent.TargetID = models.AuditTargetEvent, eventID.String()
+	auditEvent.Before = eventAuditState(event)
+	recordAudit(c, dh.audit, auditEvent)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "deleted",
+		"event_id": eventID,
+	})
+}
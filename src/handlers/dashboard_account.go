@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// HandleExportAccount downloads a zip archive of everything stored about the
+// user: profile, key pairs, decrypted events, webhook logs, API tokens,
+// sessions and audit events (POST /dashboard/api/export)
+func (dh *DashboardHandler) HandleExportAccount(c *gin.Context) {
+	email, ok := dh.authenticateSession(c)
+	if !ok {
+		return
+	}
+
+	filename := "obsidian-webhooks-export-" + time.Now().UTC().Format("20060102-150405") + ".zip"
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	err := dh.accounts.Export(c.Request.Context(), email, c.Writer)
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Header("Content-Disposition", "")
+			if errors.Is(err, services.ErrUserNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export account"})
+			return
+		}
+		// The archive is cut short without its central directory, so it won't open
+		log.Printf("Account export for %s failed: %v", email, err)
+		return
+	}
+
+	recordAudit(c, dh.audit, userAuditEvent(email, models.AuditActionAccountExport))
+}
+
+// AlertSettingsRequest changes the user's notification settings
+type AlertSettingsRequest struct {
+	// DeliveryAlerts turns emails about events stuck on the user's keys on or off
+	DeliveryAlerts *bool `json:"delivery_alerts" binding:"required"`
+}
+
+// HandleUpdateAlerts turns the user's stuck delivery emails on or off (POST /dashboard/api/alerts)
+func (dh *DashboardHandler) HandleUpdateAlerts(c *gin.Context) {
+	email, ok := dh.authenticateSession(c)
+	if !ok {
+		return
+	}
+
+	var req AlertSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "delivery_alerts is required"})
+		return
+	}
+
+	err := dh.keyService.SetDeliveryAlerts(c.Request.Context(), email, *req.DeliveryAlerts)
+	if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update alert settings"})
+		return
+	}
+
+	event := userAuditEvent(email, models.AuditActionAlertsUpdate)
+	event.TargetType, event.TargetID = models.AuditTargetUser, email
+	event.After = auditState(gin.H{"delivery_alerts": *req.DeliveryAlerts})
+	recordAudit(c, dh.audit, event)
+
+	c.JSON(http.StatusOK, gin.H{"delivery_alerts": *req.DeliveryAlerts})
+}
+
+// DeleteAccountRequest confirms an account deletion
+type DeleteAccountRequest struct {
+	// ConfirmEmail must repeat the account's email address
+	ConfirmEmail string `json:"confirm_email"`
+}
+
+// HandleDeleteAccount deletes the user's account with all their keys, events,
+// webhook logs, API tokens and sessions, and unsubscribes them from the
+// mailing list (DELETE /dashboard/api/account). The body must repeat the
+// account's email address.
+func (dh *DashboardHandler) HandleDeleteAccount(c *gin.Context) {
+	email, ok := dh.authenticateSession(c)
+	if !ok {
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !strings.EqualFold(strings.TrimSpace(req.ConfirmEmail), email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "confirm_email must match your email address"})
+		return
+	}
+
+	deleted, err := dh.accounts.Delete(c.Request.Context(), email)
+	if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+		return
+	}
+
+	event := userAuditEvent(email, models.AuditActionAccountDelete)
+	event.TargetType, event.TargetID = models.AuditTargetUser, email
+	event.Before = auditState(deleted)
+	recordAudit(c, dh.audit, event)
+
+	// Unsubscribe from MailerLite (async, non-blocking)
+	if dh.mailerlite != nil {
+		go func() {
+			bgCtx, bgCancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer bgCancel()
+			if err := dh.mailerlite.UpdateSubscriberStatus(bgCtx, email, services.SubscriberStatusUnsubscribed); err != nil {
+				log.Printf("Failed to unsubscribe deleted account %s from MailerLite: %v", email, err)
+			}
+		}()
+	}
+
+	clearSessionCookie(c)
+	c.JSON(http.StatusOK, gin.H{"status": "deleted", "key_pairs": deleted.KeyPairs, "events": deleted.Events})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+func TestDashboardAccount_DeleteNeedsConfirmation(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, dh := setupUserDashboard(t, tdb, "alice@example.com", "bob@example.com")
+		alice, _ := signInUser(t, dh, "alice@example.com")
+		bob, _ := signInUser(t, dh, "bob@example.com")
+
+		assertStatusCode(t, serveRequest(router, http.MethodDelete, "/dashboard/api/account", alice, ""), http.StatusBadRequest)
+		assertStatusCode(t, serveRequest(router, http.MethodDelete, "/dashboard/api/account", alice, `{"confirm_email":"bob@example.com"}`), http.StatusBadRequest)
+		assertStatusCode(t, serveRequest(router, http.MethodGet, "/dashboard/api/sessions", alice, ""), http.StatusOK)
+
+		w := serveRequest(router, http.MethodDelete, "/dashboard/api/account", alice, `{"confirm_email":" Alice@Example.com "}`)
+		assertStatusCode(t, w, http.StatusOK)
+		if cookie := w.Header().Get("Set-Cookie"); !strings.Contains(cookie, "session_token=;") {
+			t.Errorf("expected the session cookie to be cleared, got %q", cookie)
+		}
+		assertStatusCode(t, serveRequest(router, http.MethodGet, "/dashboard/api/sessions", alice, ""), http.StatusUnauthorized)
+		assertStatusCode(t, serveRequest(router, http.MethodGet, "/dashboard/api/sessions", bob, ""), http.StatusOK)
+
+		events, err := dh.audit.List(context.Background(), models.AuditFilter{UserEmail: "alice@example.com", Action: models.AuditActionAccountDelete})
+		if err != nil || len(events) != 1 {
+			t.Errorf("expected the deletion in the audit log, got %+v (%v)", events, err)
+		}
+	})
+}
+
+func TestDashboardAccount_Export(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, dh := setupUserDashboard(t, tdb, "alice@example.com")
+		alice, _ := signInUser(t, dh, "alice@example.com")
+
+		w := serveRequest(router, http.MethodPost, "/dashboard/api/export", alice, "")
+		assertStatusCode(t, w, http.StatusOK)
+		if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+			t.Errorf("expected a zip archive, got %q", ct)
+		}
+		if _, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len())); err != nil {
+			t.Errorf("failed to open the archive: %v", err)
+		}
+		assertStatusCode(t, serveRequest(router, http.MethodPost, "/dashboard/api/export", "not-a-token", ""), http.StatusUnauthorized)
+	})
+}
+
+func TestDashboardAccount_DeliveryAlerts(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, dh := setupUserDashboard(t, tdb, "alice@example.com")
+		alice, _ := signInUser(t, dh, "alice@example.com")
+
+		w := serveRequest(router, http.MethodGet, "/dashboard/api/me", alice, "")
+		assertStatusCode(t, w, http.StatusOK)
+		if !strings.Contains(w.Body.String(), `"delivery_alerts":true`) {
+			t.Errorf("expected delivery alerts on by default, got %s", w.Body.String())
+		}
+
+		assertStatusCode(t, serveRequest(router, http.MethodPost, "/dashboard/api/alerts", alice, `{}`), http.StatusBadRequest)
+		assertStatusCode(t, serveRequest(router, http.MethodPost, "/dashboard/api/alerts", "not-a-token", `{"delivery_alerts":false}`), http.StatusUnauthorized)
+		assertStatusCode(t, serveRequest(router, http.MethodPost, "/dashboard/api/alerts", alice, `{"delivery_alerts":false}`), http.StatusOK)
+
+		w = serveRequest(router, http.MethodGet, "/dashboard/api/me", alice, "")
+		if !strings.Contains(w.Body.String(), `"delivery_alerts":false`) {
+			t.Errorf("expected delivery alerts off, got %s", w.Body.String())
+		}
+		events, err := dh.audit.List(context.Background(), models.AuditFilter{UserEmail: "alice@example.com", Action: models.AuditActionAlertsUpdate})
+		if err != nil || len(events) != 1 || !strings.Contains(string(events[0].After), `"delivery_alerts":false`) {
+			t.Errorf("expected the change in the audit log, got %+v (%v)", events, err)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// sessionResponse is one entry of GET /dashboard/api/sessions
+type sessionResponse struct {
+	models.Session
+	Current bool `json:"current"` // the session making this request
+}
+
+// currentSessionID returns the session that authenticated the request
+func currentSessionID(c *gin.Context) uuid.UUID {
+	id, _ := c.Get(sessionIDKey)
+	sessionID, _ := id.(uuid.UUID)
+	return sessionID
+}
+
+// HandleListSessions lists the user's signed-in browsers (GET /dashboard/api/sessions)
+func (dh *DashboardHandler) HandleListSessions(c *gin.Context) {
+	email, ok := dh.authenticateSession(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := dh.sessions.ListUserSessions(c.Request.Context(), email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list sessions"})
+		return
+	}
+
+	current := currentSessionID(c)
+	resp := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, sessionResponse{Session: s, Current: s.ID == current})
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": resp})
+}
+
+// HandleRevokeSession signs one browser out (DELETE /dashboard/api/sessions/:session_id).
+// Revoking the current session also clears its cookie.
+func (dh *DashboardHandler) HandleRevokeSession(c *gin.Context) {
+	email, ok := dh.authenticateSession(c)
+	if !ok {
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session_id"})
+		return
+	}
+
+	err = dh.sessions.RevokeUserSession(c.Request.Context(), email, sessionID)
+	switch {
+	case errors.Is(err, services.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+
+	event := userAuditEvent(email, models.AuditActionSessionRevoke)
+	event.TargetType, event.TargetID = models.AuditTargetSession, sessionID.String()
+	recordAudit(c, dh.audit, event)
+
+	if sessionID == currentSessionID(c) {
+		clearSessionCookie(c)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
+
+// HandleRevokeAllSessions signs the user out everywhere, this browser included
+// (POST /dashboard/api/sessions/revoke-all)
+func (dh *DashboardHandler) HandleRevokeAllSessions(c *gin.Context) {
+	email, ok := dh.authenticateSession(c)
+	if !ok {
+		return
+	}
+
+	revoked, err := dh.sessions.RevokeAllUserSessions(c.Request.Context(), email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+
+	event := userAuditEvent(email, models.AuditActionSessionsRevokeAll)
+	event.After = auditState(gin.H{"revoked": revoked})
+	recordAudit(c, dh.audit, event)
+
+	clearSessionCookie(c)
+	c.JSON(http.StatusOK, gin.H{"status": "revoked", "revoked": revoked})
+}
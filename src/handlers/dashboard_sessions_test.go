@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+func TestDashboardSessions_ListAndRevoke(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, dh := setupUserDashboard(t, tdb, "alice@example.com", "bob@example.com")
+		laptop, laptopID := signInUser(t, dh, "alice@example.com")
+		phone, phoneID := signInUser(t, dh, "alice@example.com")
+		bob, bobID := signInUser(t, dh, "bob@example.com")
+
+		w := serveRequest(router, http.MethodGet, "/dashboard/api/sessions", laptop, "")
+		assertStatusCode(t, w, http.StatusOK)
+		var resp struct {
+			Sessions []struct {
+				ID      uuid.UUID `json:"id"`
+				Current bool      `json:"current"`
+			} `json:"sessions"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if len(resp.Sessions) != 2 {
+			t.Fatalf("expected alice's 2 sessions, got %+v", resp.Sessions)
+		}
+		for _, s := range resp.Sessions {
+			if s.Current != (s.ID == laptopID) {
+				t.Errorf("expected only the laptop to be current, got %+v", resp.Sessions)
+			}
+		}
+
+		assertStatusCode(t, serveRequest(router, http.MethodDelete, "/dashboard/api/sessions/"+bobID.String(), laptop, ""), http.StatusNotFound)
+		assertStatusCode(t, serveRequest(router, http.MethodDelete, "/dashboard/api/sessions/not-a-uuid", laptop, ""), http.StatusBadRequest)
+		assertStatusCode(t, serveRequest(router, http.MethodDelete, "/dashboard/api/sessions/"+phoneID.String(), laptop, ""), http.StatusOK)
+		assertStatusCode(t, serveRequest(router, http.MethodGet, "/dashboard/api/me", phone, ""), http.StatusUnauthorized)
+
+		// Signing out everywhere ends the current session too, but not bob's
+		assertStatusCode(t, serveRequest(router, http.MethodPost, "/dashboard/api/sessions/revoke-all", laptop, ""), http.StatusOK)
+		assertStatusCode(t, serveRequest(router, http.MethodGet, "/dashboard/api/sessions", laptop, ""), http.StatusUnauthorized)
+		assertStatusCode(t, serveRequest(router, http.MethodGet, "/dashboard/api/sessions", bob, ""), http.StatusOK)
+	})
+}
+
+func TestDashboardSessions_LogoutRevokesToken(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, dh := setupUserDashboard(t, tdb, "alice@example.com")
+		token, _ := signInUser(t, dh, "alice@example.com")
+
+		assertStatusCode(t, serveRequest(router, http.MethodGet, "/dashboard/api/sessions", token, ""), http.StatusOK)
+		assertStatusCode(t, serveRequest(router, http.MethodPost, "/auth/logout", token, ""), http.StatusOK)
+		assertStatusCode(t, serveRequest(router, http.MethodGet, "/dashboard/api/sessions", token, ""), http.StatusUnauthorized)
+
+		// Tokens issued before sessions existed carry no session ID
+		legacy, _ := dh.authService.GenerateSessionToken("alice@example.com", uuid.Nil, 1)
+		assertStatusCode(t, serveRequest(router, http.MethodGet, "/dashboard/api/sessions", legacy, ""), http.StatusUnauthorized)
+	})
+}
@@ -9,24 +9,22 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/database"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
 )
 
 // Test helpers for dashboard_test.go
-func setupDashboardHandler(tdb *database.TestDB) *DashboardHandler {
+func setupDashboardHandler(tdb *memory.TestStore) *DashboardHandler {
 	gin.SetMode(gin.TestMode)
-	db := database.NewDatabaseFromPool(tdb.Pool)
-	keyService := services.NewKeyService(db.GetPool())
-	eventService := services.NewEventService(db.GetPool())
+	keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+	eventService := services.NewEventService(tdb.Repos.Events)
 	return NewDashboardHandler(keyService, eventService)
 }
 
 func TestHandleGetEvents_Success(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		webhookKeyID, _, _, clientKey, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
@@ -44,8 +42,8 @@ func TestHandleGetEvents_Success(t *testing.T) {
 			t.Fatalf("failed to create test event 2: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewDashboardHandler(keyService, eventService)
 
 		w := httptest.NewRecorder()
@@ -80,7 +78,7 @@ func TestHandleGetEvents_Success(t *testing.T) {
 }
 
 func TestHandleGetEvents_MissingClientKey(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		handler := setupDashboardHandler(tdb)
 		w, c := createTestContext()
 		c.Request = httptest.NewRequest(http.MethodGet, "/events", nil)
@@ -93,7 +91,7 @@ func TestHandleGetEvents_MissingClientKey(t *testing.T) {
 }
 
 func TestHandleGetEvents_InvalidClientKey(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		handler := setupDashboardHandler(tdb)
 		w, c := createTestContext()
 		c.Request = httptest.NewRequest(http.MethodGet, "/events?client_key=invalid-key", nil)
@@ -106,17 +104,16 @@ func TestHandleGetEvents_InvalidClientKey(t *testing.T) {
 }
 
 func TestHandleGetEvents_EmptyList(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		_, _, _, clientKey, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
 			t.Fatalf("failed to create test key pair: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewDashboardHandler(keyService, eventService)
 
 		w := httptest.NewRecorder()
@@ -151,9 +148,8 @@ func TestHandleGetEvents_EmptyList(t *testing.T) {
 }
 
 func TestHandleDeleteEvent_Success(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		webhookKeyID, _, _, clientKey, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
@@ -165,8 +161,8 @@ func TestHandleDeleteEvent_Success(t *testing.T) {
 			t.Fatalf("failed to create test event: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewDashboardHandler(keyService, eventService)
 
 		w := httptest.NewRecorder()
@@ -200,12 +196,11 @@ func TestHandleDeleteEvent_Success(t *testing.T) {
 }
 
 func TestHandleDeleteEvent_MissingClientKey(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewDashboardHandler(keyService, eventService)
 
 		eventID := uuid.New().String()
@@ -234,17 +229,16 @@ func TestHandleDeleteEvent_MissingClientKey(t *testing.T) {
 }
 
 func TestHandleDeleteEvent_InvalidEventIDFormat(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		_, _, _, clientKey, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
 			t.Fatalf("failed to create test key pair: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewDashboardHandler(keyService, eventService)
 
 		w := httptest.NewRecorder()
@@ -272,12 +266,11 @@ func TestHandleDeleteEvent_InvalidEventIDFormat(t *testing.T) {
 }
 
 func TestHandleDeleteEvent_InvalidClientKey(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewDashboardHandler(keyService, eventService)
 
 		eventID := uuid.New().String()
@@ -306,17 +299,16 @@ func TestHandleDeleteEvent_InvalidClientKey(t *testing.T) {
 }
 
 func TestHandleDeleteEvent_EventNotFound(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		_, _, _, clientKey, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
 			t.Fatalf("failed to create test key pair: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewDashboardHandler(keyService, eventService)
 
 		nonExistentEventID := uuid.New().String()
@@ -345,9 +337,8 @@ func TestHandleDeleteEvent_EventNotFound(t *testing.T) {
 }
 
 func TestHandleDeleteEvent_Forbidden(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		// Create first user with event
 		webhookKeyID1, _, _, _, err := tdb.CreateTestKeyPair(111111, "user1")
@@ -366,8 +357,8 @@ func TestHandleDeleteEvent_Forbidden(t *testing.T) {
 			t.Fatalf("failed to create test key pair 2: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewDashboardHandler(keyService, eventService)
 
 		w := httptest.NewRecorder()
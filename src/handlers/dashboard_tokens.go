@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// createTokenRequest is the body of POST /dashboard/api/tokens
+type createTokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 = default (90 days)
+}
+
+// HandleListTokens lists the user's personal access tokens (GET /dashboard/api/tokens)
+func (dh *DashboardHandler) HandleListTokens(c *gin.Context) {
+	email, ok := dh.authenticateSession(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := dh.apiTokens.List(c.Request.Context(), email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tokens"})
+		return
+	}
+	if tokens == nil {
+		tokens = []models.APIToken{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens":           tokens,
+		"available_scopes": models.AllScopes,
+	})
+}
+
+// HandleCreateToken issues a personal access token (POST /dashboard/api/tokens).
+// The token is returned once and cannot be retrieved again.
+func (dh *DashboardHandler) HandleCreateToken(c *gin.Context) {
+	email, ok := dh.authenticateSession(c)
+	if !ok {
+		return
+	}
+
+	var req createTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and scopes are required"})
+		return
+	}
+
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	raw, token, err := dh.apiTokens.Create(c.Request.Context(), email, req.Name, req.Scopes, ttl)
+	switch {
+	case errors.Is(err, services.ErrInvalidScope), errors.Is(err, services.ErrInvalidTokenRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+
+	event := userAuditEvent(email, models.AuditActionAPITokenCreate)
+	event.TargetType, event.TargetID = models.AuditTargetAPIToken, token.ID.String()
+	event.After = auditState(gin.H{"name": token.Name, "scopes": token.Scopes, "expires_at": token.ExpiresAt})
+	recordAudit(c, dh.audit, event)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":     raw,
+		"api_token": token,
+	})
+}
+
+// HandleRevokeToken revokes a personal access token (DELETE /dashboard/api/tokens/:token_id)
+func (dh *DashboardHandler) HandleRevokeToken(c *gin.Context) {
+	email, ok := dh.authenticateSession(c)
+	if !ok {
+		return
+	}
+
+	tokenID, err := uuid.Parse(c.Param("token_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token_id"})
+		return
+	}
+
+	err = dh.apiTokens.Revoke(c.Request.Context(), email, tokenID)
+	switch {
+	case errors.Is(err, services.ErrAPITokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+		return
+	}
+
+	event := userAuditEvent(email, models.AuditActionAPITokenRevoke)
+	event.TargetType, event.TargetID = models.AuditTargetAPIToken, tokenID.String()
+	recordAudit(c, dh.audit, event)
+
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
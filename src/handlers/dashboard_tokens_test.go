@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+// setupTokenPairs gives each of emails a key pair holding one event and
+// returns the pair IDs by email
+func setupTokenPairs(t *testing.T, tdb *memory.TestStore, dh *DashboardHandler, emails ...string) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+	pairIDs := map[string]string{}
+	for _, email := range emails {
+		if _, _, err := dh.authService.CreateUserKeyPair(ctx, email, email, "en"); err != nil {
+			t.Fatalf("CreateUserKeyPair failed: %v", err)
+		}
+		pairs, _ := dh.keyService.GetUserKeyPairs(ctx, email)
+		pairIDs[email] = pairs[0].PairID
+		if _, err := tdb.CreateTestEvent(pairs[0].PairID, "/"+email, []byte(`{"ok":true}`)); err != nil {
+			t.Fatalf("CreateTestEvent failed: %v", err)
+		}
+	}
+	return pairIDs
+}
+
+// serveBearer sends a request authenticated with an API token
+func serveBearer(router *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// createToken issues a token through the session-authenticated API
+func createToken(t *testing.T, router *gin.Engine, session string, scopes ...string) (string, string) {
+	t.Helper()
+	body, _ := json.Marshal(gin.H{"name": "ci", "scopes": scopes})
+	w := serveRequest(router, http.MethodPost, "/dashboard/api/tokens", session, string(body))
+	assertStatusCode(t, w, http.StatusCreated)
+
+	var resp struct {
+		Token    string          `json:"token"`
+		APIToken models.APIToken `json:"api_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return resp.Token, resp.APIToken.ID.String()
+}
+
+func TestAPITokens_ScopesOnDashboardAPI(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, dh := setupUserDashboard(t, tdb)
+		pairIDs := setupTokenPairs(t, tdb, dh, "alice@example.com", "bob@example.com")
+		session, _ := signInUser(t, dh, "alice@example.com")
+		token, _ := createToken(t, router, session, models.ScopeKeysRead, models.ScopeEventsRead)
+
+		w := serveBearer(router, http.MethodGet, "/dashboard/api/me", token)
+		assertStatusCode(t, w, http.StatusOK)
+		var me UserDashboardData
+		_ = json.Unmarshal(w.Body.Bytes(), &me)
+		if me.Email != "alice@example.com" || len(me.Keys) != 1 {
+			t.Errorf("unexpected user data: %+v", me)
+		}
+
+		w = serveBearer(router, http.MethodGet, "/dashboard/api/logs", token)
+		assertStatusCode(t, w, http.StatusForbidden)
+		assertJSONError(t, w, "token lacks scope logs:read")
+
+		w = serveBearer(router, http.MethodGet, "/dashboard/api/events?pair_id="+pairIDs["alice@example.com"], token)
+		assertStatusCode(t, w, http.StatusOK)
+
+		// Another user's pair looks missing
+		w = serveBearer(router, http.MethodGet, "/dashboard/api/events?pair_id="+pairIDs["bob@example.com"], token)
+		assertStatusCode(t, w, http.StatusNotFound)
+
+		// Tokens cannot manage tokens
+		w = serveBearer(router, http.MethodGet, "/dashboard/api/tokens", token)
+		assertStatusCode(t, w, http.StatusUnauthorized)
+
+		w = serveBearer(router, http.MethodGet, "/dashboard/api/me", "owpat_"+"0000")
+		assertStatusCode(t, w, http.StatusUnauthorized)
+	})
+}
+
+func TestAPITokens_Revoke(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, dh := setupUserDashboard(t, tdb, "alice@example.com", "bob@example.com")
+		aliceSession, _ := signInUser(t, dh, "alice@example.com")
+		bobSession, _ := signInUser(t, dh, "bob@example.com")
+		token, tokenID := createToken(t, router, aliceSession, models.ScopeKeysRead)
+
+		// Only the owner can revoke
+		w := serveRequest(router, http.MethodDelete, "/dashboard/api/tokens/"+tokenID, bobSession, "")
+		assertStatusCode(t, w, http.StatusNotFound)
+
+		w = serveRequest(router, http.MethodDelete, "/dashboard/api/tokens/"+tokenID, aliceSession, "")
+		assertStatusCode(t, w, http.StatusOK)
+
+		w = serveBearer(router, http.MethodGet, "/dashboard/api/me", token)
+		assertStatusCode(t, w, http.StatusUnauthorized)
+
+		w = serveRequest(router, http.MethodGet, "/dashboard/api/tokens", aliceSession, "")
+		assertStatusCode(t, w, http.StatusOK)
+		var list struct {
+			Tokens []models.APIToken `json:"tokens"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &list)
+		if len(list.Tokens) != 1 || list.Tokens[0].RevokedAt == nil {
+			t.Errorf("expected one revoked token, got %+v", list.Tokens)
+		}
+		if bytes.Contains(w.Body.Bytes(), []byte(token)) {
+			t.Error("the token must not be listed")
+		}
+	})
+}
+
+func TestAPITokens_CreateValidation(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, dh := setupUserDashboard(t, tdb, "alice@example.com")
+		session, _ := signInUser(t, dh, "alice@example.com")
+
+		tests := []struct {
+			name string
+			body string
+		}{
+			{"unknown scope", `{"name":"ci","scopes":["admin"]}`},
+			{"no scopes", `{"name":"ci","scopes":[]}`},
+			{"expiry too long", `{"name":"ci","scopes":["keys:read"],"expires_in_days":1000}`},
+			{"missing name", `{"scopes":["keys:read"]}`},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := serveRequest(router, http.MethodPost, "/dashboard/api/tokens", session, tt.body)
+				assertStatusCode(t, w, http.StatusBadRequest)
+			})
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// createWebhookKeyRequest is the body of POST /dashboard/api/keys/webhook
+type createWebhookKeyRequest struct {
+	PairID             string   `json:"pair_id" binding:"required"`
+	Name               string   `json:"name" binding:"required"`
+	RateLimitPerMinute *int     `json:"rate_limit_per_minute"`
+	AllowedPaths       []string `json:"allowed_paths"`
+	ForcedPathPrefix   *string  `json:"forced_path_prefix"`
+	SigningSecret      bool     `json:"signing_secret"` // generate a secret the key's requests must be signed with
+}
+
+// updateWebhookKeyRequest is the body of PUT /dashboard/api/keys/webhook/:key_id.
+// Null limits and an empty allowed_paths are removed; signing_secret is keep
+// (default), new or none.
+type updateWebhookKeyRequest struct {
+	Name               string   `json:"name"`
+	RateLimitPerMinute *int     `json:"rate_limit_per_minute"`
+	AllowedPaths       []string `json:"allowed_paths"`
+	ForcedPathPrefix   *string  `json:"forced_path_prefix"`
+	SigningSecret      string   `json:"signing_secret"`
+}
+
+// HandleCreateWebhookKey adds a named webhook key to a key pair (POST /dashboard/api/keys/webhook).
+// The key value and signing secret are only shown in this response.
+func (dh *DashboardHandler) HandleCreateWebhookKey(c *gin.Context) {
+	email, ok := dh.authenticate(c, models.ScopeKeysWrite)
+	if !ok {
+		return
+	}
+
+	var req createWebhookKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pair_id and name are required"})
+		return
+	}
+
+	pairID, err := uuid.Parse(req.PairID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pair_id"})
+		return
+	}
+
+	opts := services.WebhookKeyOptions{
+		Name:               req.Name,
+		RateLimitPerMinute: req.RateLimitPerMinute,
+		PathRules:          services.PathRules{AllowedPaths: req.AllowedPaths, ForcedPathPrefix: req.ForcedPathPrefix},
+		SigningSecret:      services.SigningSecretNone,
+	}
+	if req.SigningSecret {
+		opts.SigningSecret = services.SigningSecretNew
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	wk, err := dh.keyService.CreateUserWebhookKey(ctx, email, pairID, opts)
+	if !dh.webhookKeyError(c, err, "failed to create webhook key") {
+		return
+	}
+
+	c.JSON(http.StatusCreated, webhookKeyResponse("created", wk))
+}
+
+// HandleUpdateWebhookKey changes a webhook key's name, limits and path rules (PUT /dashboard/api/keys/webhook/:key_id).
+// The pair's original webhook key can be restricted this way too.
+func (dh *DashboardHandler) HandleUpdateWebhookKey(c *gin.Context) {
+	email, ok := dh.authenticate(c, models.ScopeKeysWrite)
+	if !ok {
+		return
+	}
+
+	keyID, err := uuid.Parse(c.Param("key_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return
+	}
+
+	var req updateWebhookKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	wk, err := dh.keyService.UpdateUserWebhookKey(ctx, email, keyID, services.WebhookKeyOptions{
+		Name:               req.Name,
+		RateLimitPerMinute: req.RateLimitPerMinute,
+		PathRules:          services.PathRules{AllowedPaths: req.AllowedPaths, ForcedPathPrefix: req.ForcedPathPrefix},
+		SigningSecret:      services.SigningSecretAction(req.SigningSecret),
+	})
+	if !dh.webhookKeyError(c, err, "failed to update webhook key") {
+		return
+	}
+
+	resp := webhookKeyResponse("updated", wk)
+	delete(resp, "key_value")
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleRevokeWebhookKey revokes a named webhook key (DELETE /dashboard/api/keys/webhook/:key_id).
+// The pair's original webhook key is revoked with the pair via /dashboard/api/revoke.
+func (dh *DashboardHandler) HandleRevokeWebhookKey(c *gin.Context) {
+	email, ok := dh.authenticate(c, models.ScopeKeysWrite)
+	if !ok {
+		return
+	}
+
+	keyID, err := uuid.Parse(c.Param("key_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return
+	}
+
+	if err := dh.keyService.RevokeUserWebhookKey(c.Request.Context(), email, keyID); !dh.webhookKeyError(c, err, "failed to revoke webhook key") {
+		return
+	}
+
+	event := userAuditEvent(email, models.AuditActionWebhookKeyRevoke)
+	event.TargetType, event.TargetID = models.AuditTargetWebhookKey, keyID.String()
+	event.After = auditState(gin.H{"active": false})
+	recordAudit(c, dh.audit, event)
+
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
+
+// webhookKeyError writes the response for a failed webhook key operation and
+// reports whether err was nil
+func (dh *DashboardHandler) webhookKeyError(c *gin.Context, err error, failure string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrInvalidWebhookKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook key not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+	}
+	return false
+}
+
+// webhookKeyResponse describes a webhook key, including a key value or signing secret that was just generated
+func webhookKeyResponse(status string, wk *models.WebhookKey) gin.H {
+	resp := gin.H{
+		"status":   status,
+		"id":       wk.ID,
+		"pair_id":  wk.PairID,
+		"name":     wk.Name,
+		"settings": wk.Settings,
+	}
+	if wk.KeyValue != "" {
+		resp["key_value"] = wk.KeyValue
+	}
+	if wk.Settings.SigningSecret != nil {
+		resp["signing_secret"] = *wk.Settings.SigningSecret
+	}
+	return resp
+}
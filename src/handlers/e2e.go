@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// E2EHandler lets the plugin register the public key its events are sealed to
+type E2EHandler struct {
+	keyService *services.KeyService
+}
+
+// NewE2EHandler creates a new end-to-end encryption handler
+func NewE2EHandler(keyService *services.KeyService) *E2EHandler {
+	return &E2EHandler{keyService: keyService}
+}
+
+// e2eKeyRequest is the body of PUT /e2e/:client_key
+type e2eKeyRequest struct {
+	PublicKey string `json:"public_key" binding:"required"`
+}
+
+// HandleGetE2E reports whether the pair's events are sealed (GET /e2e/:client_key)
+func (eh *E2EHandler) HandleGetE2E(c *gin.Context) {
+	ck, err := eh.keyService.GetClientKeyByValue(c.Request.Context(), c.Param("client_key"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid client key"})
+		return
+	}
+
+	status, err := eh.keyService.GetE2EStatus(c.Request.Context(), ck.WebhookKeyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get encryption status"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// HandleSetE2EKey registers a base64 X25519 public key; events received from
+// now on are sealed to it (PUT /e2e/:client_key)
+func (eh *E2EHandler) HandleSetE2EKey(c *gin.Context) {
+	var req e2eKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "public_key is required"})
+		return
+	}
+	eh.setKey(c, req.PublicKey)
+}
+
+// HandleDeleteE2EKey turns end-to-end encryption off; events already sealed
+// stay sealed (DELETE /e2e/:client_key)
+func (eh *E2EHandler) HandleDeleteE2EKey(c *gin.Context) {
+	eh.setKey(c, "")
+}
+
+// setKey stores or clears the public key and responds with the new status
+func (eh *E2EHandler) setKey(c *gin.Context, publicKey string) {
+	status, err := eh.keyService.SetE2EPublicKey(c.Request.Context(), c.Param("client_key"), publicKey)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, status)
+	case errors.Is(err, services.ErrKeyNotFound):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid client key"})
+	case errors.Is(err, services.ErrInvalidPublicKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update public key"})
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestE2E_WebhookToPolling registers a public key, sends a webhook and checks
+// the plugin receives a sealed, base64-encoded payload it can open
+func TestE2E_WebhookToPolling(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		gin.SetMode(gin.TestMode)
+
+		_, _, webhookKey, clientKey, err := tdb.CreateTestKeyPair(0, "")
+		if err != nil {
+			t.Fatalf("failed to create test key pair: %v", err)
+		}
+
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
+		eventService.SetE2EKeyLookup(keyService)
+
+		router := gin.New()
+		e2eHandler := NewE2EHandler(keyService)
+		router.PUT("/e2e/:client_key", e2eHandler.HandleSetE2EKey)
+		router.GET("/e2e/:client_key", e2eHandler.HandleGetE2E)
+		router.POST("/webhook/:webhook_key", NewWebhookHandler(keyService, eventService, nil).HandleWebhook)
+		router.GET("/events/:client_key", NewSSEHandler(keyService, eventService, "").HandleSSE)
+
+		// Invalid keys are rejected
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/e2e/"+clientKey, bytes.NewReader([]byte(`{"public_key":"abc"}`))))
+		assertStatusCode(t, w, http.StatusBadRequest)
+
+		publicKey, privateKey, _ := box.GenerateKey(rand.Reader)
+		body, _ := json.Marshal(map[string]string{"public_key": base64.StdEncoding.EncodeToString(publicKey[:])})
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/e2e/"+clientKey, bytes.NewReader(body)))
+		assertStatusCode(t, w, http.StatusOK)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/e2e/"+clientKey, nil))
+		var status services.E2EStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("failed to parse status: %v", err)
+		}
+		if !status.Enabled || len(status.UnavailableFeatures) == 0 {
+			t.Errorf("expected E2E enabled with unavailable features listed, got %+v", status)
+		}
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook/"+webhookKey+"?path=secret.md", bytes.NewReader([]byte("top secret"))))
+		assertStatusCode(t, w, http.StatusOK)
+		var created map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &created)
+		if created["sealed"] != true {
+			t.Errorf("expected webhook response to report the event as sealed, got %v", created)
+		}
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events/"+clientKey+"?poll=true", nil))
+		assertStatusCode(t, w, http.StatusOK)
+		var events []map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil || len(events) != 1 {
+			t.Fatalf("expected one event, got %s", w.Body.String())
+		}
+		if events[0]["sealed"] != true {
+			t.Fatalf("expected sealed flag on delivered event, got %v", events[0])
+		}
+
+		sealed, err := base64.StdEncoding.DecodeString(events[0]["data"].(string))
+		if err != nil {
+			t.Fatalf("expected base64 data: %v", err)
+		}
+		opened, ok := box.OpenAnonymous(nil, sealed, publicKey, privateKey)
+		if !ok || string(opened) != "top secret" {
+			t.Errorf("failed to open delivered event: %q (ok=%v)", opened, ok)
+		}
+	})
+}
@@ -0,0 +1,544 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// MCP (Model Context Protocol) server over the streamable HTTP transport.
+// Each POST carries one JSON-RPC 2.0 message; responses are returned as
+// application/json. The server never initiates messages, so GET (the optional
+// server-to-client SSE stream) is answered with 405.
+
+const (
+	mcpServerName    = "obsidian-webhooks"
+	mcpServerVersion = "2.0.0"
+
+	// mcpLatestProtocolVersion is returned when the client asks for an unknown version
+	mcpLatestProtocolVersion = "2025-06-18"
+
+	// mcpMaxScheduleAhead limits how far in the future a note can be scheduled
+	mcpMaxScheduleAhead = 365 * 24 * time.Hour
+
+	// mcpMaxMessageSize caps the JSON-RPC message read from the request body
+	mcpMaxMessageSize = maxBodySize + 64*1024
+)
+
+// mcpSupportedProtocolVersions lists protocol revisions this server can speak
+var mcpSupportedProtocolVersions = map[string]bool{
+	"2025-06-18": true,
+	"2025-03-26": true,
+}
+
+// JSON-RPC 2.0 error codes
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCInternalError  = -32603
+)
+
+// jsonRPCRequest is an incoming JSON-RPC request or notification (no ID)
+type jsonRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// jsonRPCError is the error member of a JSON-RPC response
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// jsonRPCResponse is an outgoing JSON-RPC response
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+// mcpTool describes a tool in the tools/list response
+type mcpTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+// mcpContent is a single content block of a tool result
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// mcpToolResult is the result of tools/call. Tool failures are reported
+// in-band with IsError so the model can see and correct them.
+type mcpToolResult struct {
+	Content           []mcpContent `json:"content"`
+	StructuredContent interface{}  `json:"structuredContent,omitempty"`
+	IsError           bool         `json:"isError,omitempty"`
+}
+
+// mcpToolError is a tool failure that is reported to the model, not as a protocol error
+type mcpToolError struct {
+	message string
+}
+
+func (e *mcpToolError) Error() string {
+	return e.message
+}
+
+func newToolError(format string, args ...interface{}) error {
+	return &mcpToolError{message: fmt.Sprintf(format, args...)}
+}
+
+// pathSchema is the JSON schema shared by all tools that write to the vault
+var pathSchema = map[string]interface{}{
+	"type":        "string",
+	"description": "Vault-relative file path, e.g. inbox/meeting.md. Must not contain '..'.",
+	"minLength":   1,
+	"maxLength":   maxPathLength,
+}
+
+var contentSchema = map[string]interface{}{
+	"type":        "string",
+	"description": "Markdown content to write",
+}
+
+// mcpTools is the static tool catalogue
+var mcpTools = []mcpTool{
+	{
+		Name: "create_note",
+		Description: "Create a note in the user's Obsidian vault. Optional title and tags become YAML frontmatter. " +
+			"The note is queued and written when the vault's plugin is online, using the plugin's configured write mode.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"path":    pathSchema,
+				"content": contentSchema,
+				"title":   map[string]interface{}{"type": "string", "description": "Note title (frontmatter)"},
+				"tags": map[string]interface{}{
+					"type":        "array",
+					"items":       map[string]interface{}{"type": "string"},
+					"description": "Tags (frontmatter)",
+				},
+			},
+			"required": []string{"path", "content"},
+		},
+	},
+	{
+		Name:        "append_to_note",
+		Description: "Append Markdown content to a note in the user's Obsidian vault, creating it if missing.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"path":    pathSchema,
+				"content": contentSchema,
+			},
+			"required": []string{"path", "content"},
+		},
+	},
+	{
+		Name:        "schedule_note",
+		Description: "Queue content for a note that is delivered to the vault at a future time.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"path":    pathSchema,
+				"content": contentSchema,
+				"deliver_at": map[string]interface{}{
+					"type":        "string",
+					"format":      "date-time",
+					"description": "RFC 3339 timestamp, e.g. 2025-01-31T09:00:00Z (at most one year ahead)",
+				},
+			},
+			"required": []string{"path", "content", "deliver_at"},
+		},
+	},
+	{
+		Name:        "get_event_status",
+		Description: "Get the delivery status of a previously created note event: scheduled, pending, delivered or acked.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"event_id": map[string]interface{}{
+					"type":        "string",
+					"format":      "uuid",
+					"description": "event_id returned by create_note, append_to_note or schedule_note",
+				},
+			},
+			"required": []string{"event_id"},
+		},
+	},
+}
+
+// MCPHandler exposes note creation and delivery status as MCP tools
+type MCPHandler struct {
+	keyService   *services.KeyService
+	eventService *services.EventService
+	broadcaster  EventBroadcaster
+}
+
+// NewMCPHandler creates a new MCP handler
+func NewMCPHandler(keyService *services.KeyService, eventService *services.EventService) *MCPHandler {
+	return &MCPHandler{
+		keyService:   keyService,
+		eventService: eventService,
+	}
+}
+
+// SetBroadcaster sets the event broadcaster for real-time delivery
+func (mh *MCPHandler) SetBroadcaster(broadcaster EventBroadcaster) {
+	mh.broadcaster = broadcaster
+}
+
+// HandleMCPStream rejects GET: this server does not push server-initiated messages
+func (mh *MCPHandler) HandleMCPStream(c *gin.Context) {
+	c.Header("Allow", http.MethodPost)
+	c.AbortWithStatus(http.StatusMethodNotAllowed)
+}
+
+// HandleMCP processes a single JSON-RPC message (POST /mcp/:webhook_key)
+func (mh *MCPHandler) HandleMCP(c *gin.Context) {
+	// Leave room for the JSON-RPC envelope around a max-size note
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, mcpMaxMessageSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, newJSONRPCError(nil, jsonRPCParseError, "failed to read request body"))
+		return
+	}
+
+	var req jsonRPCRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		c.JSON(http.StatusBadRequest, newJSONRPCError(nil, jsonRPCParseError, "invalid JSON"))
+		return
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		c.JSON(http.StatusBadRequest, newJSONRPCError(req.ID, jsonRPCInvalidRequest, "invalid JSON-RPC 2.0 request"))
+		return
+	}
+
+	// Notifications (no id) get no response body
+	if len(req.ID) == 0 {
+		c.AbortWithStatus(http.StatusAccepted)
+		return
+	}
+
+	result, rpcErr := mh.dispatch(c, &req)
+	if rpcErr != nil {
+		c.JSON(http.StatusOK, jsonRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr})
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result})
+}
+
+// newJSONRPCError builds an error response
+func newJSONRPCError(id json.RawMessage, code int, message string) jsonRPCResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return jsonRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &jsonRPCError{Code: code, Message: message},
+	}
+}
+
+// dispatch routes a JSON-RPC request to its method implementation
+func (mh *MCPHandler) dispatch(c *gin.Context, req *jsonRPCRequest) (interface{}, *jsonRPCError) {
+	switch req.Method {
+	case "initialize":
+		return mh.handleInitialize(req.Params)
+	case "ping":
+		return gin.H{}, nil
+	case "tools/list":
+		return gin.H{"tools": mcpTools}, nil
+	case "tools/call":
+		return mh.handleToolsCall(c, req.Params)
+	default:
+		return nil, &jsonRPCError{Code: jsonRPCMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+	}
+}
+
+// handleInitialize negotiates the protocol version and advertises capabilities
+func (mh *MCPHandler) handleInitialize(params json.RawMessage) (interface{}, *jsonRPCError) {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: "invalid initialize params"}
+		}
+	}
+
+	version := mcpLatestProtocolVersion
+	if mcpSupportedProtocolVersions[p.ProtocolVersion] {
+		version = p.ProtocolVersion
+	}
+
+	return gin.H{
+		"protocolVersion": version,
+		"capabilities": gin.H{
+			"tools": gin.H{"listChanged": false},
+		},
+		"serverInfo": gin.H{
+			"name":    mcpServerName,
+			"version": mcpServerVersion,
+		},
+		"instructions": "Write notes into the user's Obsidian vault. Notes are queued and delivered by the Obsidian Webhooks plugin.",
+	}, nil
+}
+
+// mcpToolArgs holds the union of all tool arguments
+type mcpToolArgs struct {
+	Path      string   `json:"path"`
+	Content   string   `json:"content"`
+	Title     string   `json:"title"`
+	Tags      []string `json:"tags"`
+	DeliverAt string   `json:"deliver_at"`
+	EventID   string   `json:"event_id"`
+}
+
+// handleToolsCall executes a tool and wraps the outcome as an MCP tool result
+func (mh *MCPHandler) handleToolsCall(c *gin.Context, params json.RawMessage) (interface{}, *jsonRPCError) {
+	var p struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil || p.Name == "" {
+		return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: "invalid tools/call params"}
+	}
+
+	var args mcpToolArgs
+	if len(p.Arguments) > 0 {
+		if err := json.Unmarshal(p.Arguments, &args); err != nil {
+			return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: "invalid tool arguments"}
+		}
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+	switch p.Name {
+	case "create_note":
+		result, err = mh.createNote(c, &args)
+	case "append_to_note":
+		result, err = mh.appendToNote(c, &args)
+	case "schedule_note":
+		result, err = mh.scheduleNote(c, &args)
+	case "get_event_status":
+		result, err = mh.getEventStatus(c, &args)
+	default:
+		return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: fmt.Sprintf("unknown tool: %s", p.Name)}
+	}
+
+	if err != nil {
+		var toolErr *mcpToolError
+		if !errors.As(err, &toolErr) {
+			return nil, &jsonRPCError{Code: jsonRPCInternalError, Message: "internal error"}
+		}
+		return mcpToolResult{
+			Content: []mcpContent{{Type: "text", Text: toolErr.message}},
+			IsError: true,
+		}, nil
+	}
+
+	text, _ := json.Marshal(result)
+	return mcpToolResult{
+		Content:           []mcpContent{{Type: "text", Text: string(text)}},
+		StructuredContent: result,
+	}, nil
+}
+
+// validateNoteArgs applies the same path and size rules as HandleWebhook and
+// cleans args.Path
+func validateNoteArgs(args *mcpToolArgs) error {
+	if args.Path == "" {
+		return newToolError("path is required")
+	}
+	path, err := cleanEventPath(args.Path)
+	if err != nil {
+		return newToolError("%s", err.Error())
+	}
+	args.Path = path
+	if args.Content == "" {
+		return newToolError("content is required")
+	}
+	if len(args.Content) > maxBodySize {
+		return newToolError("payload too large (max 10MB)")
+	}
+	return nil
+}
+
+// createNote queues a note; title/tags are sent as JSON so the plugin renders frontmatter
+func (mh *MCPHandler) createNote(c *gin.Context, args *mcpToolArgs) (interface{}, error) {
+	if err := validateNoteArgs(args); err != nil {
+		return nil, err
+	}
+
+	body := []byte(args.Content)
+	if args.Title != "" || len(args.Tags) > 0 {
+		payload := map[string]interface{}{"content": args.Content}
+		if args.Title != "" {
+			payload["title"] = args.Title
+		}
+		if len(args.Tags) > 0 {
+			payload["tags"] = args.Tags
+		}
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode note: %w", err)
+		}
+		body = encoded
+	}
+
+	return mh.queueNote(c, args.Path, body, nil, "")
+}
+
+// appendToNote queues plain content that the plugin appends to the given
+// path, whatever its configured write mode
+func (mh *MCPHandler) appendToNote(c *gin.Context, args *mcpToolArgs) (interface{}, error) {
+	if err := validateNoteArgs(args); err != nil {
+		return nil, err
+	}
+	return mh.queueNote(c, args.Path, []byte(args.Content), nil, models.WriteModeAppend)
+}
+
+// scheduleNote queues content that is held back until deliver_at
+func (mh *MCPHandler) scheduleNote(c *gin.Context, args *mcpToolArgs) (interface{}, error) {
+	if err := validateNoteArgs(args); err != nil {
+		return nil, err
+	}
+	if args.DeliverAt == "" {
+		return nil, newToolError("deliver_at is required")
+	}
+
+	deliverAt, err := time.Parse(time.RFC3339, args.DeliverAt)
+	if err != nil {
+		return nil, newToolError("deliver_at must be an RFC 3339 timestamp")
+	}
+	if deliverAt.After(time.Now().Add(mcpMaxScheduleAhead)) {
+		return nil, newToolError("deliver_at must be within one year")
+	}
+
+	return mh.queueNote(c, args.Path, []byte(args.Content), &deliverAt, "")
+}
+
+// queueNote creates the event and runs the same bookkeeping as HandleWebhook
+func (mh *MCPHandler) queueNote(c *gin.Context, path string, body []byte, deliverAt *time.Time, mode models.WriteMode) (interface{}, error) {
+	ctx := c.Request.Context()
+
+	wk, err := mh.webhookKey(c)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err = webhookKeyPath(wk, path)
+	if err != nil {
+		return nil, newToolError("%s", err)
+	}
+
+	ttl := mh.keyService.GetRetentionPolicy(ctx, wk.PairID).Unprocessed
+	var event *models.Event
+	switch {
+	case deliverAt != nil:
+		event, err = mh.eventService.CreateScheduledEvent(ctx, wk.PairID, path, body, ttl, *deliverAt)
+	case mode == models.WriteModeAppend:
+		event, err = mh.eventService.CreateAppendEvent(ctx, wk.PairID, path, body, ttl)
+	default:
+		event, err = mh.eventService.CreateEvent(ctx, wk.PairID, path, body, ttl)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event: %w", err)
+	}
+
+	recordEventCreated(ctx, mh.keyService, event.ID, wk)
+	broadcastEvent(mh.broadcaster, event)
+
+	return mcpEventStatus(event, eventStatusFor(event, "pending")), nil
+}
+
+// getEventStatus reports delivery progress for an event owned by this webhook key
+func (mh *MCPHandler) getEventStatus(c *gin.Context, args *mcpToolArgs) (interface{}, error) {
+	ctx := c.Request.Context()
+
+	eventID, err := uuid.Parse(args.EventID)
+	if err != nil {
+		return nil, newToolError("event_id must be a UUID")
+	}
+
+	wk, err := mh.webhookKey(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Named keys share their pair's events, so a key also only sees events
+	// on paths it could have written itself
+	event, err := mh.eventService.GetEventByID(ctx, eventID)
+	if err != nil || event.WebhookKeyID != wk.PairID || wk.EventPath(event.Path) != event.Path || !wk.AllowsPath(event.Path) {
+		// Same message for foreign events: don't reveal that they exist
+		return nil, newToolError("event not found")
+	}
+
+	logStatus, err := mh.keyService.GetWebhookLogStatus(ctx, eventID)
+	if err != nil {
+		logStatus = "pending"
+	}
+
+	return mcpEventStatus(event, eventStatusFor(event, logStatus)), nil
+}
+
+// webhookKey resolves the webhook key validated by ValidateWebhookKey middleware
+func (mh *MCPHandler) webhookKey(c *gin.Context) (*models.WebhookKey, error) {
+	wk, err := mh.keyService.GetWebhookKeyByValue(c.Request.Context(), c.Param("webhook_key"))
+	if err != nil {
+		return nil, newToolError("invalid webhook key")
+	}
+	return wk, nil
+}
+
+// eventStatusFor combines event state with the delivery log status
+func eventStatusFor(event *models.Event, logStatus string) string {
+	switch {
+	case event.Processed:
+		return "acked"
+	case event.IsScheduled():
+		return "scheduled"
+	default:
+		return logStatus
+	}
+}
+
+// mcpEventStatus is the structured tool result describing an event
+func mcpEventStatus(event *models.Event, status string) gin.H {
+	result := gin.H{
+		"event_id":   event.ID,
+		"path":       event.Path,
+		"status":     status,
+		"created_at": event.CreatedAt.Format(time.RFC3339),
+	}
+	if event.DeliverAfter != nil {
+		result["deliver_at"] = event.DeliverAfter.Format(time.RFC3339)
+	}
+	if event.ProcessedAt != nil {
+		result["processed_at"] = event.ProcessedAt.Format(time.RFC3339)
+	}
+	if event.Sealed {
+		result["sealed"] = true
+	}
+	return result
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/middleware"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// callMCP posts a raw JSON-RPC message to a handler without services;
+// only paths that never touch the database can be exercised this way.
+func callMCP(t *testing.T, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	handler := NewMCPHandler(nil, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/mcp/wh_test", bytes.NewReader([]byte(body)))
+	c.Params = gin.Params{{Key: "webhook_key", Value: "wh_test"}}
+
+	handler.HandleMCP(c)
+
+	var response map[string]interface{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+	}
+	return w, response
+}
+
+func TestHandleMCP_Initialize(t *testing.T) {
+	w, response := callMCP(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected result, got %v", response)
+	}
+	if result["protocolVersion"] != "2025-03-26" {
+		t.Errorf("expected negotiated version 2025-03-26, got %v", result["protocolVersion"])
+	}
+	capabilities, _ := result["capabilities"].(map[string]interface{})
+	if _, ok := capabilities["tools"]; !ok {
+		t.Error("expected tools capability")
+	}
+}
+
+func TestHandleMCP_InitializeUnknownVersion(t *testing.T) {
+	_, response := callMCP(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}`)
+
+	result, _ := response["result"].(map[string]interface{})
+	if result["protocolVersion"] != mcpLatestProtocolVersion {
+		t.Errorf("expected %s, got %v", mcpLatestProtocolVersion, result["protocolVersion"])
+	}
+}
+
+func TestHandleMCP_ToolsList(t *testing.T) {
+	_, response := callMCP(t, `{"jsonrpc":"2.0","id":"a","method":"tools/list"}`)
+
+	if response["id"] != "a" {
+		t.Errorf("expected id to be echoed, got %v", response["id"])
+	}
+
+	result, _ := response["result"].(map[string]interface{})
+	tools, _ := result["tools"].([]interface{})
+
+	names := make(map[string]bool)
+	for _, tool := range tools {
+		m := tool.(map[string]interface{})
+		names[m["name"].(string)] = true
+		if _, ok := m["inputSchema"]; !ok {
+			t.Errorf("tool %v has no inputSchema", m["name"])
+		}
+	}
+	for _, name := range []string{"create_note", "append_to_note", "schedule_note", "get_event_status"} {
+		if !names[name] {
+			t.Errorf("expected tool %s in tools/list", name)
+		}
+	}
+}
+
+func TestHandleMCP_Notification(t *testing.T) {
+	w, _ := callMCP(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("expected status 202, got %d", w.Code)
+	}
+}
+
+func TestHandleMCP_MethodNotFound(t *testing.T) {
+	_, response := callMCP(t, `{"jsonrpc":"2.0","id":2,"method":"resources/list"}`)
+
+	rpcErr, ok := response["error"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected error, got %v", response)
+	}
+	if rpcErr["code"] != float64(jsonRPCMethodNotFound) {
+		t.Errorf("expected code %d, got %v", jsonRPCMethodNotFound, rpcErr["code"])
+	}
+}
+
+func TestHandleMCP_ParseError(t *testing.T) {
+	w, response := callMCP(t, `{not json`)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+	rpcErr, _ := response["error"].(map[string]interface{})
+	if rpcErr["code"] != float64(jsonRPCParseError) {
+		t.Errorf("expected code %d, got %v", jsonRPCParseError, rpcErr["code"])
+	}
+}
+
+func TestHandleMCP_ToolArgumentErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments string
+		tool      string
+		wantError string
+	}{
+		{"missing path", `{"content":"x"}`, "create_note", "path is required"},
+		{"path traversal", `{"path":"../../etc/passwd","content":"x"}`, "append_to_note", "invalid path (path traversal not allowed)"},
+		{"path too long", `{"path":"` + strings.Repeat("a", 513) + `","content":"x"}`, "create_note", "path too long (max 512 characters)"},
+		{"missing content", `{"path":"inbox/a.md"}`, "create_note", "content is required"},
+		{"bad deliver_at", `{"path":"inbox/a.md","content":"x","deliver_at":"tomorrow"}`, "schedule_note", "deliver_at must be an RFC 3339 timestamp"},
+		{"bad event_id", `{"event_id":"nope"}`, "get_event_status", "event_id must be a UUID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, response := callMCP(t, `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"`+tt.tool+`","arguments":`+tt.arguments+`}}`)
+
+			result, ok := response["result"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("expected tool result, got %v", response)
+			}
+			if result["isError"] != true {
+				t.Errorf("expected isError=true, got %v", result["isError"])
+			}
+			content, _ := result["content"].([]interface{})
+			if len(content) != 1 || content[0].(map[string]interface{})["text"] != tt.wantError {
+				t.Errorf("expected error %q, got %v", tt.wantError, content)
+			}
+		})
+	}
+}
+
+// setupMCP creates a user's key pair plus a named webhook key with opts and
+// returns a router serving /mcp as main.go does
+func setupMCP(t *testing.T, tdb *memory.TestStore, opts services.WebhookKeyOptions) (*gin.Engine, *models.WebhookKey, uuid.UUID) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+	auth := services.NewAuthService(tdb.Repos.Users, tdb.Repos.Keys, tdb.Repos.AuthTokens, tdb.KeyHasher, "secret", 900, "http://localhost")
+	if _, _, err := auth.CreateUserKeyPair(ctx, "user@example.com", "User", "en"); err != nil {
+		t.Fatalf("CreateUserKeyPair failed: %v", err)
+	}
+	pairs, _ := keyService.GetUserKeyPairs(ctx, "user@example.com")
+	pairID := uuid.MustParse(pairs[0].PairID)
+	wk, err := keyService.CreateUserWebhookKey(ctx, "user@example.com", pairID, opts)
+	if err != nil {
+		t.Fatalf("CreateUserWebhookKey failed: %v", err)
+	}
+
+	router := gin.New()
+	router.POST("/mcp/:webhook_key", middleware.ValidateWebhookKey(keyService), middleware.KeySignatureMiddleware(),
+		NewMCPHandler(keyService, services.NewEventService(tdb.Repos.Events)).HandleMCP)
+	return router, wk, pairID
+}
+
+// postMCP posts a JSON-RPC message to /mcp with an optional signature
+func postMCP(router *gin.Engine, key, body, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/mcp/"+key, strings.NewReader(body))
+	if signature != "" {
+		req.Header.Set("X-Webhook-Signature", signature)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleMCP_KeySigningSecret(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, wk, pairID := setupMCP(t, tdb, services.WebhookKeyOptions{Name: "agent", SigningSecret: services.SigningSecretNew})
+		body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"create_note","arguments":{"path":"inbox/a.md","content":"x"}}}`
+
+		w := postMCP(router, wk.KeyValue, body, "")
+		assertStatusCode(t, w, http.StatusUnauthorized)
+		assertJSONError(t, w, "missing X-Webhook-Signature header")
+
+		mac := hmac.New(sha256.New, []byte(*wk.Settings.SigningSecret))
+		mac.Write([]byte(body))
+		w = postMCP(router, wk.KeyValue, body, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		assertStatusCode(t, w, http.StatusOK)
+
+		events, _ := tdb.Repos.Events.GetUnprocessed(context.Background(), pairID)
+		if len(events) != 1 || events[0].Path != "inbox/a.md" {
+			t.Errorf("expected only the signed note queued, got %+v", events)
+		}
+	})
+}
+
+func TestHandleMCP_AppendToNote(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, wk, pairID := setupMCP(t, tdb, services.WebhookKeyOptions{Name: "agent"})
+		for _, tool := range []string{"append_to_note", "create_note"} {
+			w := postMCP(router, wk.KeyValue, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"`+tool+`","arguments":{"path":"inbox/a.md","content":"x"}}}`, "")
+			assertStatusCode(t, w, http.StatusOK)
+		}
+
+		// Appends never depend on the plugin's default write mode
+		events, _ := tdb.Repos.Events.GetUnprocessed(context.Background(), pairID)
+		if len(events) != 2 || events[0].Mode != models.WriteModeAppend || events[1].Mode != "" {
+			t.Fatalf("expected an append and a default-mode event, got %+v", events)
+		}
+		if got := formatEventToJSON(&events[0]); !strings.HasSuffix(got, `,"mode":"append"}`) {
+			t.Errorf("expected the mode delivered to the plugin, got %s", got)
+		}
+	})
+}
+
+func TestHandleMCP_EventStatusScopedToKeyPaths(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, wk, pairID := setupMCP(t, tdb, services.WebhookKeyOptions{
+			Name:      "agent",
+			PathRules: services.PathRules{AllowedPaths: []string{"Inbox/**"}},
+		})
+		events := services.NewEventService(tdb.Repos.Events)
+		status := func(eventID uuid.UUID) string {
+			w := postMCP(router, wk.KeyValue, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"get_event_status","arguments":{"event_id":"`+eventID.String()+`"}}}`, "")
+			assertStatusCode(t, w, http.StatusOK)
+			return w.Body.String()
+		}
+
+		// An event a sibling key wrote outside this key's globs stays hidden
+		private, err := events.CreateEvent(context.Background(), pairID, "Private/secret.md", []byte("x"), time.Hour)
+		if err != nil {
+			t.Fatalf("CreateEvent failed: %v", err)
+		}
+		if body := status(private.ID); !strings.Contains(body, "event not found") || strings.Contains(body, "Private") {
+			t.Errorf("expected the sibling's event hidden, got %s", body)
+		}
+
+		inbox, err := events.CreateEvent(context.Background(), pairID, "Inbox/note.md", []byte("x"), time.Hour)
+		if err != nil {
+			t.Fatalf("CreateEvent failed: %v", err)
+		}
+		if body := status(inbox.ID); !strings.Contains(body, `"status":"pending"`) {
+			t.Errorf("expected the status of an event on an allowed path, got %s", body)
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/oidc"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// oidcStateCookie carries the signed sign-in state from the login redirect
+// to the provider's callback
+const oidcStateCookie = "oidc_state"
+
+// OIDCHandler handles single sign-on through an OpenID Connect provider
+type OIDCHandler struct {
+	oidc         *services.OIDCService
+	authService  *services.AuthService
+	admin        *AdminHandler // nil = admins sign in with their password only
+	sessions     *services.SessionService
+	audit        *services.AuditService
+	providerName string
+	magicLink    bool
+}
+
+// NewOIDCHandler creates a single sign-on handler; providerName labels the
+// sign-in button
+func NewOIDCHandler(oidcService *services.OIDCService, authService *services.AuthService, providerName string) *OIDCHandler {
+	return &OIDCHandler{oidc: oidcService, authService: authService, providerName: providerName}
+}
+
+// SetAdminHandler lets admins sign in through the provider, with the same
+// two-factor step and sessions as a password sign-in. The service must have
+// admins enabled too.
+func (h *OIDCHandler) SetAdminHandler(admin *AdminHandler) {
+	h.admin = admin
+}
+
+// SetSessions records each user sign-in as a server-side session
+func (h *OIDCHandler) SetSessions(sessions *services.SessionService) {
+	h.sessions = sessions
+}
+
+// SetAudit records each sign-in, and each account it creates, in the audit log
+func (h *OIDCHandler) SetAudit(audit *services.AuditService) {
+	h.audit = audit
+}
+
+// SetMagicLinkEnabled reports magic link sign-in as available next to single sign-on
+func (h *OIDCHandler) SetMagicLinkEnabled(enabled bool) {
+	h.magicLink = enabled
+}
+
+// HandleAuthMethods handles GET /auth/methods - which sign-in buttons the login pages show
+func (h *OIDCHandler) HandleAuthMethods(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"magic_link":    h.magicLink,
+		"sso":           true,
+		"admin_sso":     h.adminEnabled(),
+		"provider_name": h.providerName,
+	})
+}
+
+// HandleLogin handles GET /auth/oidc/login - starts a user sign-in at the provider
+func (h *OIDCHandler) HandleLogin(c *gin.Context) {
+	h.begin(c, services.SSOFlowUser)
+}
+
+// HandleAdminLogin handles GET /admin/login/oidc - starts an admin sign-in at the provider
+func (h *OIDCHandler) HandleAdminLogin(c *gin.Context) {
+	if !h.adminEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "single sign-on for admins is not enabled",
+		})
+		return
+	}
+	h.begin(c, services.SSOFlowAdmin)
+}
+
+func (h *OIDCHandler) adminEnabled() bool {
+	return h.admin != nil && h.oidc.AdminsEnabled()
+}
+
+// begin sends the browser to the provider, with the state to check its
+// answer against in a short-lived cookie
+func (h *OIDCHandler) begin(c *gin.Context, flow string) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	authURL, stateToken, err := h.oidc.Begin(ctx, flow)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":   "provider_error",
+			"message": "Single sign-on is unavailable. Please try again later.",
+		})
+		return
+	}
+
+	h.setStateCookie(c, stateToken, int(services.SSOStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// HandleCallback handles GET /auth/oidc/callback?code=...&state=... - the
+// provider's answer for both user and admin sign-ins
+func (h *OIDCHandler) HandleCallback(c *gin.Context) {
+	stateToken, _ := c.Cookie(oidcStateCookie)
+	h.setStateCookie(c, "", -1) // single use
+
+	if c.Query("error") != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":   "sso_denied",
+			"message": "Sign-in was cancelled or refused by the identity provider.",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	identity, err := h.oidc.Finish(ctx, stateToken, c.Query("state"), c.Query("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSSOStateInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "state_invalid",
+				"message": "This sign-in has expired or was started elsewhere. Please sign in again.",
+			})
+		case errors.Is(err, services.ErrSSOEmailNotAllowed):
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "email_not_allowed",
+				"message": "Your identity provider account cannot be used to sign in here.",
+			})
+		case errors.Is(err, oidc.ErrInvalidIDToken):
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "sso_failed",
+				"message": "The identity provider's answer could not be verified.",
+			})
+		default:
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":   "provider_error",
+				"message": "Failed to complete single sign-on. Please try again later.",
+			})
+		}
+		return
+	}
+
+	if identity.Flow == services.SSOFlowAdmin {
+		h.finishAdmin(ctx, c, identity)
+		return
+	}
+	h.finishUser(ctx, c, identity)
+}
+
+// finishUser signs a user in and opens the dashboard. An account created by
+// this sign-in shows its new key pair once, passed in the URL fragment so it
+// never reaches a server log.
+func (h *OIDCHandler) finishUser(ctx context.Context, c *gin.Context, identity *services.SSOIdentity) {
+	keys, err := h.oidc.SignInUser(ctx, identity)
+	if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "account_not_found",
+			"message": "No account uses this email address. Please register first.",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Failed to sign in",
+		})
+		return
+	}
+
+	if keys != nil {
+		event := userAuditEvent(identity.Email, models.AuditActionAccountCreate)
+		event.TargetType, event.TargetID = models.AuditTargetUser, identity.Email
+		event.After = auditState(gin.H{"method": "oidc", "subject": identity.Subject})
+		recordAudit(c, h.audit, event)
+	}
+
+	if !startUserSession(c, h.authService, h.sessions, h.audit, identity.Email, "oidc") {
+		return
+	}
+
+	if keys == nil {
+		c.Redirect(http.StatusFound, "/dashboard")
+		return
+	}
+	fragment := url.Values{"webhook_key": {keys.WebhookKey}, "client_key": {keys.ClientKey}}
+	c.Redirect(http.StatusFound, "/dashboard#"+fragment.Encode())
+}
+
+// finishAdmin signs an admin in and opens the admin panel with their token,
+// or with a two-factor challenge when they need one, in the URL fragment
+func (h *OIDCHandler) finishAdmin(ctx context.Context, c *gin.Context, identity *services.SSOIdentity) {
+	admin, err := h.oidc.SignInAdmin(ctx, identity)
+	if err != nil {
+		h.admin.recordLoginFailure(c, identity.Email, "oidc")
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "no active admin uses this email address",
+		})
+		return
+	}
+
+	fragment := url.Values{}
+	if h.admin.needsSecondStep(admin) {
+		challenge, ok := h.admin.challenge(c, admin)
+		if !ok {
+			return
+		}
+		fragment.Set("challenge", challenge.Challenge)
+		fragment.Set("totp_required", strconv.FormatBool(challenge.TOTPRequired))
+		fragment.Set("totp_enrollment_required", strconv.FormatBool(challenge.TOTPEnrollmentRequired))
+	} else {
+		token, _, ok := h.admin.startSession(c, admin, gin.H{"two_factor": false, "method": "oidc"})
+		if !ok {
+			return
+		}
+		fragment.Set("token", token)
+	}
+	c.Redirect(http.StatusFound, "/admin#"+fragment.Encode())
+}
+
+func (h *OIDCHandler) setStateCookie(c *gin.Context, value string, maxAge int) {
+	// Lax, not Strict: the callback is a cross-site redirect from the provider
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, "/auth/oidc", "", true, true)
+}
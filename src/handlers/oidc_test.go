@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/middleware"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/oidc"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/oidc/oidctest"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
+)
+
+// setupOIDC creates a single sign-on handler backed by a test provider,
+// able to sign in both users and admins, and a router serving it
+func setupOIDC(t *testing.T, tdb *memory.TestStore) (*gin.Engine, *OIDCHandler, *oidctest.Provider) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	if err := middleware.SetJWTSecret(testJWTSecret); err != nil {
+		t.Fatalf("SetJWTSecret failed: %v", err)
+	}
+	idp := oidctest.NewProvider(t, "webhooks", "secret")
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "webhooks",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/oidc/callback",
+	})
+
+	keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+	authService := setupAuthService(tdb)
+	sessions := services.NewSessionService(tdb.Repos.Sessions, tdb.Repos.Users)
+	audit := services.NewAuditService(tdb.Repos.Audit)
+	adminService := services.NewAdminService(tdb.Repos.Admins)
+	ah := NewAdminHandler(keyService, adminService, services.NewEventService(tdb.Repos.Events))
+	ah.SetSessions(sessions)
+	ah.SetAudit(audit)
+
+	sso := services.NewOIDCService(provider, authService, testJWTSecret)
+	sso.SetAdmins(adminService)
+	h := NewOIDCHandler(sso, authService, "Keycloak")
+	h.SetAdminHandler(ah)
+	h.SetSessions(sessions)
+	h.SetAudit(audit)
+
+	router := gin.New()
+	router.GET("/auth/methods", h.HandleAuthMethods)
+	router.GET("/auth/oidc/login", h.HandleLogin)
+	router.GET("/auth/oidc/callback", h.HandleCallback)
+	router.GET("/admin/login/oidc", h.HandleAdminLogin)
+	return router, h, idp
+}
+
+// oidcSignIn starts a sign-in at path, lets the provider sign the current
+// user in, and returns the response to the callback
+func oidcSignIn(t *testing.T, router *gin.Engine, idp *oidctest.Provider, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := serveRequest(router, http.MethodGet, path, "", "")
+	assertStatusCode(t, w, http.StatusFound)
+	if !strings.HasPrefix(w.Header().Get("Location"), idp.Issuer()+"/authorize?") {
+		t.Fatalf("expected a redirect to the provider, got %q", w.Header().Get("Location"))
+	}
+	state := responseCookie(w, oidcStateCookie)
+	if state == nil || !state.HttpOnly || !state.Secure || state.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("expected a secure state cookie, got %+v", state)
+	}
+
+	callback := idp.Authorize(t, w.Header().Get("Location"))
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	req.AddCookie(state)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// fragment returns the redirect target's path and its fragment parameters
+func fragment(t *testing.T, w *httptest.ResponseRecorder) (string, url.Values) {
+	t.Helper()
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect %q", w.Header().Get("Location"))
+	}
+	values, _ := url.ParseQuery(location.Fragment)
+	return location.Path, values
+}
+
+func TestOIDC_UserSignIn(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, h, idp := setupOIDC(t, tdb)
+		h.oidc.SetAutoProvision(true)
+
+		w := oidcSignIn(t, router, idp, "/auth/oidc/login")
+		assertStatusCode(t, w, http.StatusFound)
+		path, keys := fragment(t, w)
+		if path != "/dashboard" || !strings.HasPrefix(keys.Get("webhook_key"), "wh_") || !strings.HasPrefix(keys.Get("client_key"), "ck_") {
+			t.Fatalf("expected the dashboard with the new key pair, got %q", w.Header().Get("Location"))
+		}
+		if responseCookie(w, "session_token") == nil {
+			t.Error("expected a session cookie")
+		}
+		events, err := h.audit.List(context.Background(), models.AuditFilter{UserEmail: "alice@example.com", Action: models.AuditActionAccountCreate})
+		if err != nil || len(events) != 1 {
+			t.Errorf("expected the new account to be audited, got %d events (%v)", len(events), err)
+		}
+
+		w = oidcSignIn(t, router, idp, "/auth/oidc/login")
+		assertStatusCode(t, w, http.StatusFound)
+		if w.Header().Get("Location") != "/dashboard" {
+			t.Errorf("expected no keys for an existing account, got %q", w.Header().Get("Location"))
+		}
+		events, _ = h.audit.List(context.Background(), models.AuditFilter{UserEmail: "alice@example.com", Action: models.AuditActionUserLogin})
+		if len(events) != 2 || !strings.Contains(string(events[0].After), `"oidc"`) {
+			t.Errorf("expected both sign-ins audited as oidc, got %+v", events)
+		}
+	})
+}
+
+func TestOIDC_UserWithoutAccount(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, _, idp := setupOIDC(t, tdb)
+		w := oidcSignIn(t, router, idp, "/auth/oidc/login")
+		assertStatusCode(t, w, http.StatusForbidden)
+		if responseCookie(w, "session_token") != nil {
+			t.Error("expected no session without an account")
+		}
+	})
+}
+
+func TestOIDC_CallbackRequiresState(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, _, _ := setupOIDC(t, tdb)
+		w := serveRequest(router, http.MethodGet, "/auth/oidc/callback?code=abc&state=xyz", "", "")
+		assertStatusCode(t, w, http.StatusBadRequest)
+
+		w = serveRequest(router, http.MethodGet, "/auth/oidc/callback?error=access_denied&state=xyz", "", "")
+		assertStatusCode(t, w, http.StatusUnauthorized)
+	})
+}
+
+func TestOIDC_AdminSignIn(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, h, idp := setupOIDC(t, tdb)
+		if _, err := h.admin.adminService.CreateAdminUser(context.Background(), "alice@example.com", "correct horse battery"); err != nil {
+			t.Fatalf("CreateAdminUser failed: %v", err)
+		}
+
+		w := oidcSignIn(t, router, idp, "/admin/login/oidc")
+		assertStatusCode(t, w, http.StatusFound)
+		path, values := fragment(t, w)
+		if path != "/admin" || values.Get("token") == "" {
+			t.Fatalf("expected the admin panel with a token, got %q", w.Header().Get("Location"))
+		}
+
+		h.admin.adminService.RequireTOTP(true)
+		w = oidcSignIn(t, router, idp, "/admin/login/oidc")
+		assertStatusCode(t, w, http.StatusFound)
+		_, values = fragment(t, w)
+		if values.Get("token") != "" || values.Get("challenge") == "" || values.Get("totp_enrollment_required") != "true" {
+			t.Errorf("expected a two-factor challenge instead of a token, got %q", w.Header().Get("Location"))
+		}
+
+		idp.SetUser(oidctest.User{Subject: "2", Email: "mallory@example.com", EmailVerified: true})
+		w = oidcSignIn(t, router, idp, "/admin/login/oidc")
+		assertStatusCode(t, w, http.StatusUnauthorized)
+		events, _ := h.audit.List(context.Background(), models.AuditFilter{Action: models.AuditActionAdminLoginFailed})
+		if len(events) != 1 || events[0].ActorName != "mallory@example.com" {
+			t.Errorf("expected the failed admin sign-in audited, got %+v", events)
+		}
+	})
+}
+
+func TestOIDC_AdminLoginDisabled(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		router, h, _ := setupOIDC(t, tdb)
+		h.oidc.SetAdmins(nil)
+
+		w := serveRequest(router, http.MethodGet, "/admin/login/oidc", "", "")
+		assertStatusCode(t, w, http.StatusNotFound)
+
+		w = serveRequest(router, http.MethodGet, "/auth/methods", "", "")
+		assertStatusCode(t, w, http.StatusOK)
+		if !strings.Contains(w.Body.String(), `"admin_sso":false`) || !strings.Contains(w.Body.String(), `"provider_name":"Keycloak"`) {
+			t.Errorf("unexpected sign-in methods %s", w.Body.String())
+		}
+	})
+}
@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	"bufio"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,11 +27,78 @@ type sseClient struct {
 
 // formatEventToJSON formats an event to JSON string including data field
 func formatEventToJSON(event *models.Event) string {
-	dataStr := string(event.Data)
-	dataJSON, _ := json.Marshal(dataStr)
+	dataJSON, _ := json.Marshal(deliveryData(event))
+	return fmt.Sprintf(`{"id":"%s","path":"%s","data":%s,"created_at":"%s"%s}`,
+		event.ID, event.Path, string(dataJSON), event.CreatedAt.Format(time.RFC3339), deliveryFlags(event))
+}
+
+// deliveryFlags returns the optional fields that follow created_at in a
+// delivered event: the sealed flag and an explicit write mode
+func deliveryFlags(event *models.Event) string {
+	flags := ""
+	if event.Sealed {
+		flags += `,"sealed":true`
+	}
+	if event.Mode != "" {
+		flags += fmt.Sprintf(`,"mode":"%s"`, event.Mode)
+	}
+	return flags
+}
+
+// writeSSEEvent writes an event as an SSE message, streaming its data from r.
+// The output matches formatEventToJSON, so large payloads never have to be
+// held in memory as a single string.
+func writeSSEEvent(w io.Writer, event *models.Event, r io.Reader) error {
+	if _, err := fmt.Fprintf(w, `data: {"id":"%s","path":"%s","data":"`, event.ID, event.Path); err != nil {
+		return err
+	}
+	if err := writeJSONStringContent(w, r, event.Sealed); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, `","created_at":"%s"%s}`+"\n\n", event.CreatedAt.Format(time.RFC3339), deliveryFlags(event))
+	return err
+}
+
+// writeJSONStringContent writes the escaped contents of a JSON string holding
+// the data read from r, as deliveryData would produce it: base64 for sealed
+// events, otherwise text escaped exactly like encoding/json
+func writeJSONStringContent(w io.Writer, r io.Reader, sealed bool) error {
+	if sealed {
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		if _, err := io.Copy(enc, r); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
 
-	return fmt.Sprintf(`{"id":"%s","path":"%s","data":%s,"created_at":"%s"}`,
-		event.ID, event.Path, string(dataJSON), event.CreatedAt.Format(time.RFC3339))
+	br := bufio.NewReader(r)
+	var piece strings.Builder
+	flush := func() error {
+		if piece.Len() == 0 {
+			return nil
+		}
+		escaped, _ := json.Marshal(piece.String())
+		piece.Reset()
+		_, err := w.Write(escaped[1 : len(escaped)-1])
+		return err
+	}
+	for {
+		ch, _, err := br.ReadRune()
+		if err == io.EOF {
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+		// Invalid bytes come back as U+FFFD, which is what encoding/json
+		// substitutes for them too
+		piece.WriteRune(ch)
+		if piece.Len() >= 32*1024 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
 }
 
 // SSEHandler handles Server-Sent Events connections
@@ -100,21 +172,25 @@ func (sh *SSEHandler) handleSSEStream(c *gin.Context, clientKey string) {
 	_, _ = c.Writer.WriteString(": connected\n\n") // Ignore error, connection will fail anyway
 	c.Writer.Flush()
 
-	// Get unprocessed events
-	events, err := sh.eventService.GetUnprocessedEvents(c.Request.Context(), ck.WebhookKeyID)
-	if err != nil {
-		log.Printf("Error getting unprocessed events: %v", err)
-	}
-
-	// Send existing unprocessed events
-	for _, event := range events {
-		// Marshal event data properly
-		eventJSON := formatEventToJSON(&event)
-		data := fmt.Sprintf("data: %s\n\n", eventJSON)
-		_, _ = c.Writer.WriteString(data) // Ignore error, connection will fail anyway
+	// Send existing unprocessed events, streaming large payloads from the blob store
+	midEvent := false
+	err = sh.eventService.StreamUnprocessedEvents(c.Request.Context(), ck.WebhookKeyID, func(event *models.Event, data io.Reader) error {
+		midEvent = true
+		if err := writeSSEEvent(c.Writer, event, data); err != nil {
+			return err
+		}
+		midEvent = false
 		c.Writer.Flush()
 		// Update webhook log to delivered
 		_ = sh.keyService.UpdateWebhookLogDelivered(c.Request.Context(), event.ID, event.WebhookKeyID, ck.ID)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error sending unprocessed events: %v", err)
+		if midEvent {
+			// The message is cut short; drop the connection so the client reconnects
+			return
+		}
 	}
 
 	// Create channel for new events
@@ -179,13 +255,18 @@ func (sh *SSEHandler) handlePolling(c *gin.Context, clientKey string) {
 	// Format events with proper data field for plugin consumption
 	formattedEvents := make([]map[string]interface{}, 0, len(events))
 	for _, event := range events {
-		dataStr := string(event.Data)
 		formattedEvent := map[string]interface{}{
 			"id":         event.ID,
 			"path":       event.Path,
-			"data":       dataStr,
+			"data":       deliveryData(&event),
 			"created_at": event.CreatedAt.Format(time.RFC3339),
 		}
+		if event.Sealed {
+			formattedEvent["sealed"] = true
+		}
+		if event.Mode != "" {
+			formattedEvent["mode"] = event.Mode
+		}
 		formattedEvents = append(formattedEvents, formattedEvent)
 	}
 
@@ -216,3 +297,34 @@ func (sh *SSEHandler) BroadcastEvent(event interface{}) {
 		}
 	}
 }
+
+// StartScheduledDelivery periodically pushes scheduled events that have become due
+// to connected SSE clients. Clients that are offline pick them up on reconnect,
+// since GetUnprocessedEvents includes every due event.
+func (sh *SSEHandler) StartScheduledDelivery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastCheck := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			events, err := sh.eventService.GetScheduledEventsDue(ctx, lastCheck, now)
+			if err != nil {
+				log.Printf("Error getting scheduled events: %v", err)
+				continue
+			}
+			lastCheck = now
+
+			for i := range events {
+				sh.BroadcastEvent(SSEEvent{
+					EventID:      events[i].ID,
+					WebhookKeyID: events[i].WebhookKeyID,
+					Data:         formatEventToJSON(&events[i]),
+				})
+			}
+		}
+	}
+}
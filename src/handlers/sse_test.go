@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
 )
 
 func TestBroadcastEvent_IsolatesByWebhookKeyID(t *testing.T) {
@@ -77,3 +80,33 @@ func TestBroadcastEvent_BothReceiveOwnEvents(t *testing.T) {
 		t.Errorf("user B: expected 1 event, got %d", len(chB))
 	}
 }
+
+func TestWriteSSEEvent_MatchesFormatEventToJSON(t *testing.T) {
+	// A multi-byte rune straddles the 32 KB flush boundary
+	long := strings.Repeat("a", 32*1024-1) + "✓" + strings.Repeat("b", 100)
+	payloads := map[string][]byte{
+		"plain":        []byte(`{"title":"note"}`),
+		"escapes":      []byte("line\n\t\"quoted\" <b>&</b> \u2028 \x01"),
+		"invalid utf8": []byte("ok \xff\xfe end \xe2\x9c"),
+		"long":         []byte(long),
+		"empty":        {},
+	}
+	for name, data := range payloads {
+		for _, sealed := range []bool{false, true} {
+			event := &models.Event{
+				ID:        uuid.New(),
+				Path:      "notes/a.md",
+				Data:      data,
+				CreatedAt: time.Now(),
+				Sealed:    sealed,
+			}
+			var buf bytes.Buffer
+			if err := writeSSEEvent(&buf, event, bytes.NewReader(data)); err != nil {
+				t.Fatalf("%s: writeSSEEvent failed: %v", name, err)
+			}
+			if want := "data: " + formatEventToJSON(event) + "\n\n"; buf.String() != want {
+				t.Errorf("%s (sealed=%v): streamed output differs from formatEventToJSON", name, sealed)
+			}
+		}
+	}
+}
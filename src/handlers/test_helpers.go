@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
 )
 
 // Test helpers for handler tests
@@ -36,3 +43,82 @@ func assertJSONError(t *testing.T, w *httptest.ResponseRecorder, expectedError s
 		t.Errorf("expected error '%s', got '%v'", expectedError, response["error"])
 	}
 }
+
+// testJWTSecret signs the session tokens handler tests use
+const testJWTSecret = "test-secret-that-is-long-enough-32"
+
+// setupAuthService creates the auth service that issues session tokens
+func setupAuthService(tdb *memory.TestStore) *services.AuthService {
+	return services.NewAuthService(tdb.Repos.Users, tdb.Repos.Keys, tdb.Repos.AuthTokens, tdb.KeyHasher, testJWTSecret, 3600, "http://localhost")
+}
+
+// setupUserDashboard creates a signed-in dashboard wired as main.go does,
+// with a user for each of emails, and a router serving its API
+func setupUserDashboard(t *testing.T, tdb *memory.TestStore, emails ...string) (*gin.Engine, *DashboardHandler) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	for _, email := range emails {
+		if err := tdb.Repos.Users.Upsert(context.Background(), &models.UserProfile{Email: email}); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+
+	keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+	eventService := services.NewEventService(tdb.Repos.Events)
+	sessions := services.NewSessionService(tdb.Repos.Sessions, tdb.Repos.Users)
+	dh := NewDashboardHandlerWithAuth(setupAuthService(tdb), keyService)
+	dh.SetEventService(eventService)
+	dh.SetAPITokens(services.NewAPITokenService(tdb.Repos.APITokens, tdb.Repos.Users))
+	dh.SetSessions(sessions)
+	dh.SetAudit(services.NewAuditService(tdb.Repos.Audit))
+	accounts := services.NewAccountService(tdb.Repos.Users, keyService, eventService)
+	accounts.SetSessions(sessions)
+	dh.SetAccounts(accounts)
+
+	router := gin.New()
+	router.POST("/auth/logout", dh.HandleLogout)
+	router.GET("/dashboard/api/me", dh.HandleGetUserData)
+	router.GET("/dashboard/api/logs", dh.HandleGetLogs)
+	router.GET("/dashboard/api/events", dh.HandleGetUserEvents)
+	router.GET("/dashboard/api/tokens", dh.HandleListTokens)
+	router.POST("/dashboard/api/tokens", dh.HandleCreateToken)
+	router.DELETE("/dashboard/api/tokens/:token_id", dh.HandleRevokeToken)
+	router.GET("/dashboard/api/sessions", dh.HandleListSessions)
+	router.DELETE("/dashboard/api/sessions/:session_id", dh.HandleRevokeSession)
+	router.POST("/dashboard/api/sessions/revoke-all", dh.HandleRevokeAllSessions)
+	router.GET("/dashboard/api/audit", dh.HandleListUserAudit)
+	router.GET("/dashboard/api/audit/export", dh.HandleExportUserAudit)
+	router.POST("/dashboard/api/export", dh.HandleExportAccount)
+	router.DELETE("/dashboard/api/account", dh.HandleDeleteAccount)
+	router.POST("/dashboard/api/alerts", dh.HandleUpdateAlerts)
+	return router, dh
+}
+
+// signInUser starts a session for email and returns its cookie value and ID
+func signInUser(t *testing.T, dh *DashboardHandler, email string) (string, uuid.UUID) {
+	t.Helper()
+	session, err := dh.sessions.StartUserSession(context.Background(), email, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("StartUserSession failed: %v", err)
+	}
+	token, err := dh.authService.GenerateSessionToken(email, session.ID, 1)
+	if err != nil {
+		t.Fatalf("GenerateSessionToken failed: %v", err)
+	}
+	return token, session.ID
+}
+
+// serveRequest sends a request with an optional JSON body to router, signed
+// in with the session cookie when one is given
+func serveRequest(router http.Handler, method, path, session, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if session != "" {
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: session})
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
@@ -24,9 +27,54 @@ type SSEEvent struct {
 
 const (
 	maxPathLength = 512
-	maxBodySize   = 10 * 1024 * 1024 // 10MB
+	maxBodySize   = 10 * 1024 * 1024 // 10MB default; see SetMaxBodySize
 )
 
+// Path validation errors shared by all ingestion endpoints (webhook, MCP)
+var (
+	errPathTooLong    = errors.New("path too long (max 512 characters)")
+	errPathTraversal  = errors.New("invalid path (path traversal not allowed)")
+	errPathInvalid    = errors.New("invalid path (must name a file in the vault)")
+	errPathNotAllowed = errors.New("path not allowed for this webhook key")
+)
+
+// cleanEventPath checks a vault path against the ingestion rules and returns
+// it in the form the key's path rules are matched against: "/" between
+// folders, no empty or "." folders and no leading slash. An empty path is
+// rejected by callers, since each reports it differently.
+func cleanEventPath(p string) (string, error) {
+	// Validate path length
+	if len(p) > maxPathLength {
+		return "", errPathTooLong
+	}
+
+	// Validate path - no traversal attacks, whichever separator is used
+	p = strings.ReplaceAll(p, `\`, "/")
+	if strings.Contains(p, "..") {
+		return "", errPathTraversal
+	}
+
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "", errPathInvalid
+	}
+	return p, nil
+}
+
+// webhookKeyPath applies a webhook key's path rules to an already cleaned
+// path: the forced prefix is prepended, then the result must match one of the
+// allowed globs. Failing the allowlist wraps errPathNotAllowed.
+func webhookKeyPath(wk *models.WebhookKey, p string) (string, error) {
+	p, err := cleanEventPath(wk.EventPath(p))
+	if err != nil {
+		return "", err
+	}
+	if !wk.AllowsPath(p) {
+		return "", fmt.Errorf("%w: %q matches none of %s", errPathNotAllowed, p, strings.Join(wk.Settings.AllowedPaths, ", "))
+	}
+	return p, nil
+}
+
 // EventBroadcaster is an interface for broadcasting events to connected clients
 type EventBroadcaster interface {
 	BroadcastEvent(event interface{})
@@ -38,6 +86,7 @@ type WebhookHandler struct {
 	eventService     *services.EventService
 	broadcaster      EventBroadcaster
 	analyticsService *services.AnalyticsService
+	maxBodySize      int64
 }
 
 // NewWebhookHandler creates a new webhook handler
@@ -46,6 +95,15 @@ func NewWebhookHandler(keyService *services.KeyService, eventService *services.E
 		keyService:       keyService,
 		eventService:     eventService,
 		analyticsService: analyticsService,
+		maxBodySize:      maxBodySize,
+	}
+}
+
+// SetMaxBodySize changes the largest accepted payload. Raise it only with a
+// blob store configured, so large payloads stay out of the events table.
+func (wh *WebhookHandler) SetMaxBodySize(n int64) {
+	if n > 0 {
+		wh.maxBodySize = n
 	}
 }
 
@@ -92,13 +150,16 @@ func (wh *WebhookHandler) HandleTestWebhook(c *gin.Context) {
 
 // formatEventForSSE formats an event for SSE delivery
 func formatEventForSSE(event *models.Event) string {
-	// Escape data for JSON - need to properly encode bytes to string
-	dataStr := string(event.Data)
-	// Use json.Marshal to properly escape special characters
-	dataJSON, _ := json.Marshal(dataStr)
+	return formatEventToJSON(event)
+}
 
-	return fmt.Sprintf(`{"id":"%s","path":"%s","data":%s,"created_at":"%s"}`,
-		event.ID, event.Path, string(dataJSON), event.CreatedAt.Format(time.RFC3339))
+// deliveryData returns an event's data as delivered to the plugin. Sealed boxes
+// are binary, so they travel base64-encoded and flagged with "sealed": true.
+func deliveryData(event *models.Event) string {
+	if event.Sealed {
+		return base64.StdEncoding.EncodeToString(event.Data)
+	}
+	return string(event.Data)
 }
 
 // HandleWebhook processes incoming webhook requests
@@ -114,18 +175,10 @@ func (wh *WebhookHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 
-	// Validate path length
-	if len(path) > maxPathLength {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "path too long (max 512 characters)",
-		})
-		return
-	}
-
-	// Validate path - no traversal attacks
-	if strings.Contains(path, "..") {
+	path, err := cleanEventPath(path)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid path (path traversal not allowed)",
+			"error": err.Error(),
 		})
 		return
 	}
@@ -138,9 +191,20 @@ func (wh *WebhookHandler) HandleWebhook(c *gin.Context) {
 		})
 		return
 	}
+	path, err = webhookKeyPath(wk, path)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errPathNotAllowed) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
 	// Read request body with size limit (regardless of Content-Length header)
-	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodySize+1))
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, wh.maxBodySize+1))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "failed to read request body",
@@ -149,20 +213,20 @@ func (wh *WebhookHandler) HandleWebhook(c *gin.Context) {
 	}
 
 	// Check if body exceeded limit
-	if len(body) > maxBodySize {
+	if int64(len(body)) > wh.maxBodySize {
 		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
-			"error": "payload too large (max 10MB)",
+			"error": fmt.Sprintf("payload too large (max %dMB)", wh.maxBodySize>>20),
 		})
 		return
 	}
 
-	// Create event
+	// Create event; named webhook keys queue it for the pair's client key
 	event, err := wh.eventService.CreateEvent(
 		c.Request.Context(),
-		wk.ID,
+		wk.PairID,
 		path,
 		body,
-		24*365*time.Hour, // Default TTL
+		wh.keyService.GetRetentionPolicy(c.Request.Context(), wk.PairID).Unprocessed,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -171,15 +235,7 @@ func (wh *WebhookHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 
-	// Create webhook log entry (pending)
-	if err := wh.keyService.CreateWebhookLog(c.Request.Context(), event.ID, wk.ID, http.StatusOK); err != nil {
-		log.Warn().Err(err).Str("event_id", event.ID.String()).Msg("failed to create webhook log")
-	}
-
-	// Update usage stats (last_used + usage_count)
-	if err := wh.keyService.UpdateKeyUsageStats(c.Request.Context(), wk.ID); err != nil {
-		log.Warn().Err(err).Str("webhook_key_id", wk.ID.String()).Msg("failed to update usage stats")
-	}
+	recordEventCreated(c.Request.Context(), wh.keyService, event.ID, wk)
 
 	// Track webhook received event with email hash
 	if wh.analyticsService != nil {
@@ -194,16 +250,43 @@ func (wh *WebhookHandler) HandleWebhook(c *gin.Context) {
 	}
 
 	// Broadcast event to connected SSE clients for real-time delivery
-	if wh.broadcaster != nil {
-		wh.broadcaster.BroadcastEvent(SSEEvent{
-			EventID:      event.ID,
-			WebhookKeyID: wk.ID,
-			Data:         formatEventForSSE(event),
-		})
-	}
+	broadcastEvent(wh.broadcaster, event)
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"status":   "ok",
 		"event_id": event.ID,
+	}
+	if event.Sealed {
+		// The server cannot read sealed payloads; say so instead of silently skipping features
+		response["sealed"] = true
+		response["unavailable_features"] = services.E2EUnavailableFeatures
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// recordEventCreated writes the pending delivery log for the pair and bumps the
+// usage stats of the webhook key that received the event.
+// Failures are logged, never returned: the event itself is already stored.
+func recordEventCreated(ctx context.Context, keyService *services.KeyService, eventID uuid.UUID, wk *models.WebhookKey) {
+	// Create webhook log entry (pending)
+	if err := keyService.CreateWebhookLog(ctx, eventID, wk.PairID, http.StatusOK); err != nil {
+		log.Warn().Err(err).Str("event_id", eventID.String()).Msg("failed to create webhook log")
+	}
+
+	// Update usage stats (last_used + usage_count)
+	if err := keyService.UpdateKeyUsageStats(ctx, wk.ID); err != nil {
+		log.Warn().Err(err).Str("webhook_key_id", wk.ID.String()).Msg("failed to update usage stats")
+	}
+}
+
+// broadcastEvent pushes an event to connected SSE clients unless it is scheduled for later
+func broadcastEvent(broadcaster EventBroadcaster, event *models.Event) {
+	if broadcaster == nil || event.IsScheduled() {
+		return
+	}
+	broadcaster.BroadcastEvent(SSEEvent{
+		EventID:      event.ID,
+		WebhookKeyID: event.WebhookKeyID,
+		Data:         formatEventForSSE(event),
 	})
 }
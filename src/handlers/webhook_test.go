@@ -2,21 +2,24 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
-	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/database"
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
 )
 
 func TestHandleWebhook_Success(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		// Create test webhook key
 		_, _, webhookKey, _, err := tdb.CreateTestKeyPair(123456, "testuser")
@@ -24,8 +27,8 @@ func TestHandleWebhook_Success(t *testing.T) {
 			t.Fatalf("failed to create test key pair: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewWebhookHandler(keyService, eventService, nil)
 
 		reqBody := []byte(`{"test": "data"}`)
@@ -59,17 +62,16 @@ func TestHandleWebhook_Success(t *testing.T) {
 }
 
 func TestHandleWebhook_MissingPath(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		_, _, webhookKey, _, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
 			t.Fatalf("failed to create test key pair: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewWebhookHandler(keyService, eventService, nil)
 
 		w := httptest.NewRecorder()
@@ -97,17 +99,16 @@ func TestHandleWebhook_MissingPath(t *testing.T) {
 }
 
 func TestHandleWebhook_PathTooLong(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		_, _, webhookKey, _, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
 			t.Fatalf("failed to create test key pair: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewWebhookHandler(keyService, eventService, nil)
 
 		// Path longer than 512 characters
@@ -138,17 +139,16 @@ func TestHandleWebhook_PathTooLong(t *testing.T) {
 }
 
 func TestHandleWebhook_PathTraversal(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		_, _, webhookKey, _, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
 			t.Fatalf("failed to create test key pair: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewWebhookHandler(keyService, eventService, nil)
 
 		w := httptest.NewRecorder()
@@ -176,12 +176,11 @@ func TestHandleWebhook_PathTraversal(t *testing.T) {
 }
 
 func TestHandleWebhook_InvalidWebhookKey(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewWebhookHandler(keyService, eventService, nil)
 
 		w := httptest.NewRecorder()
@@ -209,17 +208,16 @@ func TestHandleWebhook_InvalidWebhookKey(t *testing.T) {
 }
 
 func TestHandleWebhook_PayloadTooLarge(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
 		_, _, webhookKey, _, err := tdb.CreateTestKeyPair(123456, "testuser")
 		if err != nil {
 			t.Fatalf("failed to create test key pair: %v", err)
 		}
 
-		keyService := services.NewKeyService(db.GetPool())
-		eventService := services.NewEventService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		eventService := services.NewEventService(tdb.Repos.Events)
 		handler := NewWebhookHandler(keyService, eventService, nil)
 
 		// Create payload larger than 10MB
@@ -248,3 +246,119 @@ func TestHandleWebhook_PayloadTooLarge(t *testing.T) {
 		}
 	})
 }
+
+func TestHandleWebhook_NamedWebhookKey(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		gin.SetMode(gin.TestMode)
+		ctx := context.Background()
+
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		auth := services.NewAuthService(tdb.Repos.Users, tdb.Repos.Keys, tdb.Repos.AuthTokens, tdb.KeyHasher, "secret", 900, "http://localhost")
+		if _, _, err := auth.CreateUserKeyPair(ctx, "user@example.com", "User", "en"); err != nil {
+			t.Fatalf("CreateUserKeyPair failed: %v", err)
+		}
+		pairs, _ := keyService.GetUserKeyPairs(ctx, "user@example.com")
+		pairID := uuid.MustParse(pairs[0].PairID)
+
+		wk, err := keyService.CreateUserWebhookKey(ctx, "user@example.com", pairID, services.WebhookKeyOptions{
+			Name:      "zapier",
+			PathRules: services.PathRules{AllowedPaths: []string{"Inbox/**"}},
+		})
+		if err != nil {
+			t.Fatalf("CreateUserWebhookKey failed: %v", err)
+		}
+		handler := NewWebhookHandler(keyService, services.NewEventService(tdb.Repos.Events), nil)
+
+		send := func(path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/webhook/"+wk.KeyValue+"?path="+path, strings.NewReader("data"))
+			c.Params = gin.Params{{Key: "webhook_key", Value: wk.KeyValue}}
+			handler.HandleWebhook(c)
+			return w
+		}
+
+		w := send("Private/note.md")
+		assertStatusCode(t, w, http.StatusForbidden)
+		assertJSONError(t, w, `path not allowed for this webhook key: "Private/note.md" matches none of Inbox/**`)
+
+		w = send("Inbox/note.md")
+		assertStatusCode(t, w, http.StatusOK)
+
+		// The event reaches the pair's client key; usage is counted on the named key
+		events, _ := tdb.Repos.Events.GetUnprocessed(ctx, pairID)
+		if len(events) != 1 || events[0].Path != "Inbox/note.md" {
+			t.Fatalf("expected the event to be queued for the pair, got %+v", events)
+		}
+		pairs, _ = keyService.GetUserKeyPairs(ctx, "user@example.com")
+		keys := pairs[0].WebhookKeys
+		if len(keys) != 2 || keys[0].EventsCount != 0 || keys[1].EventsCount != 1 {
+			t.Errorf("expected usage on the named key only, got %+v", keys)
+		}
+	})
+}
+
+// TestHandleWebhook_PathNormalized verifies paths are cleaned before a key's
+// path rules see them, so backslashes, doubled slashes and a leading slash
+// can't slip a note past its allowlist
+func TestHandleWebhook_PathNormalized(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		gin.SetMode(gin.TestMode)
+		ctx := context.Background()
+
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
+		auth := services.NewAuthService(tdb.Repos.Users, tdb.Repos.Keys, tdb.Repos.AuthTokens, tdb.KeyHasher, "secret", 900, "http://localhost")
+		if _, _, err := auth.CreateUserKeyPair(ctx, "user@example.com", "User", "en"); err != nil {
+			t.Fatalf("CreateUserKeyPair failed: %v", err)
+		}
+		pairs, _ := keyService.GetUserKeyPairs(ctx, "user@example.com")
+		pairID := uuid.MustParse(pairs[0].PairID)
+
+		wk, err := keyService.CreateUserWebhookKey(ctx, "user@example.com", pairID, services.WebhookKeyOptions{
+			Name:      "zapier",
+			PathRules: services.PathRules{AllowedPaths: []string{"Inbox/*.md"}},
+		})
+		if err != nil {
+			t.Fatalf("CreateUserWebhookKey failed: %v", err)
+		}
+		handler := NewWebhookHandler(keyService, services.NewEventService(tdb.Repos.Events), nil)
+
+		tests := []struct {
+			path   string
+			status int
+			stored string
+		}{
+			{`\Inbox\a.md`, http.StatusOK, "Inbox/a.md"},
+			{"//Inbox//b.md", http.StatusOK, "Inbox/b.md"},
+			{"/Inbox/./c.md", http.StatusOK, "Inbox/c.md"},
+			{`Inbox\sub\d.md`, http.StatusForbidden, ""},
+			{"Inbox//sub/e.md", http.StatusForbidden, ""},
+			{`Inbox\..\Private\f.md`, http.StatusBadRequest, ""},
+			{"//", http.StatusBadRequest, ""},
+		}
+		for _, tt := range tests {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/webhook/"+wk.KeyValue+"?path="+url.QueryEscape(tt.path), strings.NewReader("data"))
+			c.Params = gin.Params{{Key: "webhook_key", Value: wk.KeyValue}}
+			handler.HandleWebhook(c)
+			if w.Code != tt.status {
+				t.Errorf("path %q: expected status %d, got %d: %s", tt.path, tt.status, w.Code, w.Body.String())
+			}
+		}
+
+		events, _ := tdb.Repos.Events.GetUnprocessed(ctx, pairID)
+		var stored []string
+		for _, e := range events {
+			stored = append(stored, e.Path)
+		}
+		for _, tt := range tests {
+			if tt.stored != "" && !slices.Contains(stored, tt.stored) {
+				t.Errorf("expected %q to be stored as %q, got %v", tt.path, tt.stored, stored)
+			}
+		}
+		if len(stored) != 3 {
+			t.Errorf("expected 3 events, got %v", stored)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package keyhash
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHasher(t *testing.T) {
+	if _, err := New("short"); err == nil {
+		t.Error("expected a short pepper to be rejected")
+	}
+
+	a, _ := New(strings.Repeat("a", MinPepperLen))
+	b, _ := New(strings.Repeat("b", MinPepperLen))
+	key := "wh_0123456789abcdef"
+
+	if got := a.Hash(key); got != a.Hash(key) || len(got) != 64 {
+		t.Errorf("expected a stable 64-character hash, got %q", got)
+	}
+	if a.Hash(key) == b.Hash(key) {
+		t.Error("expected different peppers to give different hashes")
+	}
+	if a.Hash(key) == a.Hash(key+"0") {
+		t.Error("expected different keys to give different hashes")
+	}
+	if strings.Contains(a.Hash(key), "0123456789") {
+		t.Error("expected the hash not to contain the key")
+	}
+
+	if got := Prefix(key); got != "wh_012345" {
+		t.Errorf("Prefix = %q", got)
+	}
+	if got := Prefix("ck_1"); got != "ck_1" {
+		t.Errorf("Prefix of a short key = %q", got)
+	}
+}
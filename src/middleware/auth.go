@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
 )
 
@@ -53,15 +54,43 @@ func validateKey(c *gin.Context, paramName string, contextKey string, keyType st
 	c.Next()
 }
 
-// ValidateWebhookKey validates webhook key from URL parameter
+// webhookKeyModelKey holds the *models.WebhookKey resolved by ValidateWebhookKey
+const webhookKeyModelKey = "webhook_key_model"
+
+// ValidateWebhookKey validates webhook key from URL parameter and keeps the
+// resolved key for WebhookKeyFromContext
 func ValidateWebhookKey(keyService *services.KeyService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		validateKey(c, "webhook_key", "webhook_key", "webhook key", func(ctx *gin.Context, key string) (bool, error) {
-			return keyService.ValidateWebhookKey(ctx.Request.Context(), key)
+			wk, err := keyService.ResolveWebhookKey(ctx.Request.Context(), key)
+			if err != nil {
+				return false, err
+			}
+			ctx.Set(webhookKeyModelKey, wk)
+			return wk.IsActive(), nil
 		})
 	}
 }
 
+// WebhookKeyFromContext returns the webhook key resolved by ValidateWebhookKey
+func WebhookKeyFromContext(c *gin.Context) (*models.WebhookKey, bool) {
+	v, ok := c.Get(webhookKeyModelKey)
+	if !ok {
+		return nil, false
+	}
+	wk, ok := v.(*models.WebhookKey)
+	return wk, ok
+}
+
+// WebhookKeyRateLimit returns the per-minute limit set on the request's webhook
+// key, 0 when the key has none; for RateLimitConfig.KeyLimit
+func WebhookKeyRateLimit(c *gin.Context) int {
+	if wk, ok := WebhookKeyFromContext(c); ok && wk.Settings.RateLimitPerMinute != nil {
+		return *wk.Settings.RateLimitPerMinute
+	}
+	return 0
+}
+
 // ValidateClientKey validates client key from URL parameter
 func ValidateClientKey(keyService *services.KeyService) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -114,24 +143,51 @@ func SetJWTSecret(secret string) error {
 	return nil
 }
 
+// adminSessions checks admin tokens against server-side sessions; nil = JWT only
+var adminSessions *services.SessionService
+
+// SetAdminSessions makes AdminAuthMiddleware require a live session for
+// every admin token, so signing out revokes the token
+func SetAdminSessions(sessions *services.SessionService) {
+	adminSessions = sessions
+}
+
 // AdminClaims represents JWT claims for admin users
 type AdminClaims struct {
-	AdminID  string `json:"admin_id"`
-	Username string `json:"username"`
+	AdminID   string `json:"admin_id"`
+	Username  string `json:"username"`
+	SessionID string `json:"sid,omitempty"`
+	// Role decides which admin endpoints the token opens; changing an admin's
+	// role ends their sessions, so it cannot go stale
+	Role models.AdminRole `json:"role,omitempty"`
+	// Purpose marks a restricted token such as a two-factor challenge;
+	// AdminAuthMiddleware only accepts tokens without one
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateAdminToken creates a JWT token for admin user
-func GenerateAdminToken(adminID uuid.UUID, username string) (string, error) {
+// adminChallengePurpose marks tokens issued between the password and two-factor steps
+const adminChallengePurpose = "2fa"
+
+// AdminChallengeTTL is how long an admin has to enter a two-factor code after their password
+const AdminChallengeTTL = 5 * time.Minute
+
+// GenerateAdminToken creates a JWT token for admin user; sessionID ties it to
+// a server-side session and uuid.Nil leaves it out
+func GenerateAdminToken(adminID uuid.UUID, username string, role models.AdminRole, sessionID uuid.UUID) (string, error) {
 	claims := AdminClaims{
 		AdminID:  adminID.String(),
 		Username: username,
+		Role:     role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "obsidian-webhooks",
 		},
 	}
+	if sessionID != uuid.Nil {
+		claims.SessionID = sessionID.String()
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(JWTSecret))
@@ -154,6 +210,36 @@ func ValidateAdminToken(tokenString string) (*AdminClaims, error) {
 	return claims, nil
 }
 
+// GenerateAdminChallengeToken creates a short-lived token proving the admin
+// passed the password step; it only works for the two-factor step
+func GenerateAdminChallengeToken(adminID uuid.UUID, username string) (string, error) {
+	claims := AdminClaims{
+		AdminID:  adminID.String(),
+		Username: username,
+		Purpose:  adminChallengePurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AdminChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "obsidian-webhooks",
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(JWTSecret))
+}
+
+// ValidateAdminChallengeToken verifies a token from GenerateAdminChallengeToken
+func ValidateAdminChallengeToken(tokenString string) (*AdminClaims, error) {
+	claims, err := ValidateAdminToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != adminChallengePurpose {
+		return nil, fmt.Errorf("invalid token: not a two-factor challenge")
+	}
+	return claims, nil
+}
+
 // AdminAuthMiddleware checks for valid JWT token in Cookie or Authorization header
 func AdminAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -183,15 +269,56 @@ func AdminAuthMiddleware() gin.HandlerFunc {
 		}
 
 		claims, err := ValidateAdminToken(token)
-		if err != nil {
+		if err != nil || claims.Purpose != "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
 
+		if adminSessions != nil {
+			sessionID, _ := uuid.Parse(claims.SessionID)
+			session, err := adminSessions.Validate(c.Request.Context(), sessionID)
+			if errors.Is(err, services.ErrSessionInvalid) || (err == nil && (session.AdminID == nil || session.AdminID.String() != claims.AdminID)) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "session expired"})
+				c.Abort()
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check session"})
+				c.Abort()
+				return
+			}
+			c.Set("session_id", sessionID)
+		}
+
 		// Store admin info in context
 		c.Set("admin_id", claims.AdminID)
 		c.Set("username", claims.Username)
+		c.Set(adminRoleKey, claims.Role)
+		c.Next()
+	}
+}
+
+// adminRoleKey is the context key AdminAuthMiddleware stores the admin's role under
+const adminRoleKey = "admin_role"
+
+// AdminRoleFromContext returns the role of the admin AdminAuthMiddleware authenticated
+func AdminRoleFromContext(c *gin.Context) models.AdminRole {
+	role, _ := c.Get(adminRoleKey)
+	r, _ := role.(models.AdminRole)
+	return r
+}
+
+// RequireAdminPermission rejects admins whose role lacks permission; it runs
+// after AdminAuthMiddleware. Tokens issued before roles existed carry none and
+// are rejected until the admin signs in again.
+func RequireAdminPermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !AdminRoleFromContext(c).Can(permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin role lacks permission " + permission})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -1,20 +1,22 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/database"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/services"
 )
 
 // Test helpers to reduce duplication
 
 // testValidateKeySuccess tests successful key validation
-func testValidateKeySuccess(t *testing.T, tdb *database.TestDB, paramName string, middlewareFunc gin.HandlerFunc, getKey func() (string, error)) {
+func testValidateKeySuccess(t *testing.T, tdb *memory.TestStore, paramName string, middlewareFunc gin.HandlerFunc, getKey func() (string, error)) {
 	gin.SetMode(gin.TestMode)
 	w := httptest.NewRecorder()
 	c, router := gin.CreateTestContext(w)
@@ -37,7 +39,7 @@ func testValidateKeySuccess(t *testing.T, tdb *database.TestDB, paramName string
 }
 
 // testValidateKeyInvalid tests validation with invalid key
-func testValidateKeyInvalid(t *testing.T, tdb *database.TestDB, paramName string, invalidKey string, middlewareFunc gin.HandlerFunc) {
+func testValidateKeyInvalid(t *testing.T, tdb *memory.TestStore, paramName string, invalidKey string, middlewareFunc gin.HandlerFunc) {
 	gin.SetMode(gin.TestMode)
 	w := httptest.NewRecorder()
 	c, router := gin.CreateTestContext(w)
@@ -113,9 +115,8 @@ func TestGenerateUserToken(t *testing.T) {
 }
 
 func TestValidateWebhookKey_Success(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
-		db := database.NewDatabaseFromPool(tdb.Pool)
-		keyService := services.NewKeyService(db.GetPool())
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
 		middleware := ValidateWebhookKey(keyService)
 
 		testValidateKeySuccess(t, tdb, "webhook_key", middleware, func() (string, error) {
@@ -126,11 +127,10 @@ func TestValidateWebhookKey_Success(t *testing.T) {
 }
 
 func TestValidateWebhookKey_MissingKey(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
-		db := database.NewDatabaseFromPool(tdb.Pool)
 
-		keyService := services.NewKeyService(db.GetPool())
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
 		middleware := ValidateWebhookKey(keyService)
 
 		w := httptest.NewRecorder()
@@ -148,9 +148,8 @@ func TestValidateWebhookKey_MissingKey(t *testing.T) {
 
 // TODO: Fix bug - ErrKeyNotFound should return 401, not 500
 func TestValidateWebhookKey_InvalidKey(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
-		db := database.NewDatabaseFromPool(tdb.Pool)
-		keyService := services.NewKeyService(db.GetPool())
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
 		middleware := ValidateWebhookKey(keyService)
 
 		testValidateKeyInvalid(t, tdb, "webhook_key", "wh_invalid", middleware)
@@ -158,9 +157,8 @@ func TestValidateWebhookKey_InvalidKey(t *testing.T) {
 }
 
 func TestValidateClientKey_Success(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
-		db := database.NewDatabaseFromPool(tdb.Pool)
-		keyService := services.NewKeyService(db.GetPool())
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
 		middleware := ValidateClientKey(keyService)
 
 		testValidateKeySuccess(t, tdb, "client_key", middleware, func() (string, error) {
@@ -172,9 +170,8 @@ func TestValidateClientKey_Success(t *testing.T) {
 
 // TODO: Fix bug - ErrKeyNotFound should return 401, not 500
 func TestValidateClientKey_InvalidKey(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
-		db := database.NewDatabaseFromPool(tdb.Pool)
-		keyService := services.NewKeyService(db.GetPool())
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		keyService := services.NewKeyService(tdb.Repos.Keys, tdb.Repos.Users, tdb.Repos.Events, tdb.Repos.WebhookLogs, tdb.KeyHasher)
 		middleware := ValidateClientKey(keyService)
 
 		testValidateKeyInvalid(t, tdb, "client_key", "ck_invalid", middleware)
@@ -182,7 +179,7 @@ func TestValidateClientKey_InvalidKey(t *testing.T) {
 }
 
 func TestAdminAuthMiddleware_WithValidCookie(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		// Initialize JWT secret
@@ -194,7 +191,7 @@ func TestAdminAuthMiddleware_WithValidCookie(t *testing.T) {
 
 		// Generate admin token
 		adminID := uuid.New()
-		token, err := GenerateAdminToken(adminID, "testadmin")
+		token, err := GenerateAdminToken(adminID, "testadmin", models.AdminRoleOwner, uuid.Nil)
 		if err != nil {
 			t.Fatalf("GenerateAdminToken failed: %v", err)
 		}
@@ -224,7 +221,7 @@ func TestAdminAuthMiddleware_WithValidCookie(t *testing.T) {
 }
 
 func TestAdminAuthMiddleware_WithValidHeader(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		// Initialize JWT secret
@@ -236,7 +233,7 @@ func TestAdminAuthMiddleware_WithValidHeader(t *testing.T) {
 
 		// Generate admin token
 		adminID := uuid.New()
-		token, err := GenerateAdminToken(adminID, "testadmin")
+		token, err := GenerateAdminToken(adminID, "testadmin", models.AdminRoleOwner, uuid.Nil)
 		if err != nil {
 			t.Fatalf("GenerateAdminToken failed: %v", err)
 		}
@@ -261,7 +258,7 @@ func TestAdminAuthMiddleware_WithValidHeader(t *testing.T) {
 }
 
 func TestAdminAuthMiddleware_MissingToken(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		middleware := AdminAuthMiddleware()
@@ -283,14 +280,67 @@ func TestAdminAuthMiddleware_MissingToken(t *testing.T) {
 }
 
 func TestAdminAuthMiddleware_InvalidToken(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		middleware := AdminAuthMiddleware()
 		testAuthMiddlewareInvalidToken(t, middleware)
 	})
 }
 
+func TestAdminAuthMiddleware_RevokedSession(t *testing.T) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
+		gin.SetMode(gin.TestMode)
+
+		originalSecret := JWTSecret
+		if err := SetJWTSecret("test-secret-for-unit-tests-32ch!"); err != nil {
+			t.Fatalf("SetJWTSecret failed: %v", err)
+		}
+		defer func() { JWTSecret = originalSecret }()
+
+		sessions := services.NewSessionService(tdb.Repos.Sessions, tdb.Repos.Users)
+		SetAdminSessions(sessions)
+		defer SetAdminSessions(nil)
+
+		adminID := uuid.New()
+		session, err := sessions.StartAdminSession(context.Background(), adminID, "192.0.2.1", "test")
+		if err != nil {
+			t.Fatalf("StartAdminSession failed: %v", err)
+		}
+		token, err := GenerateAdminToken(adminID, "testadmin", models.AdminRoleOwner, session.ID)
+		if err != nil {
+			t.Fatalf("GenerateAdminToken failed: %v", err)
+		}
+		legacy, _ := GenerateAdminToken(adminID, "testadmin", models.AdminRoleOwner, uuid.Nil)
+
+		router := gin.New()
+		router.Use(AdminAuthMiddleware())
+		router.GET("/test", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		})
+		get := func(token string) int {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.AddCookie(&http.Cookie{Name: "admin_token", Value: token})
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		if code := get(token); code != http.StatusOK {
+			t.Errorf("expected status 200 for a live session, got %d", code)
+		}
+		if code := get(legacy); code != http.StatusUnauthorized {
+			t.Errorf("expected status 401 for a token without a session, got %d", code)
+		}
+		if err := sessions.End(context.Background(), session.ID); err != nil {
+			t.Fatalf("End failed: %v", err)
+		}
+		if code := get(token); code != http.StatusUnauthorized {
+			t.Errorf("expected status 401 after logout, got %d", code)
+		}
+	})
+}
+
 func TestUserAuthMiddleware_WithValidCookie(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		// Initialize JWT secret
@@ -328,7 +378,7 @@ func TestUserAuthMiddleware_WithValidCookie(t *testing.T) {
 }
 
 func TestUserAuthMiddleware_WithValidHeader(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		// Initialize JWT secret
@@ -365,7 +415,7 @@ func TestUserAuthMiddleware_WithValidHeader(t *testing.T) {
 }
 
 func TestUserAuthMiddleware_MissingToken(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		middleware := UserAuthMiddleware()
@@ -387,14 +437,14 @@ func TestUserAuthMiddleware_MissingToken(t *testing.T) {
 }
 
 func TestUserAuthMiddleware_InvalidToken(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		middleware := UserAuthMiddleware()
 		testAuthMiddlewareInvalidToken(t, middleware)
 	})
 }
 
 func TestValidateBearerToken_ValidToken(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		middleware := ValidateBearerToken("test-secret")
@@ -418,7 +468,7 @@ func TestValidateBearerToken_ValidToken(t *testing.T) {
 }
 
 func TestValidateBearerToken_MissingHeader(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		middleware := ValidateBearerToken("test-secret")
@@ -440,7 +490,7 @@ func TestValidateBearerToken_MissingHeader(t *testing.T) {
 }
 
 func TestValidateBearerToken_InvalidFormat(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		middleware := ValidateBearerToken("test-secret")
@@ -461,3 +511,91 @@ func TestValidateBearerToken_InvalidFormat(t *testing.T) {
 		}
 	})
 }
+
+func TestAdminChallengeToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	originalSecret := JWTSecret
+	if err := SetJWTSecret("test-secret-for-unit-tests-32ch!"); err != nil {
+		t.Fatalf("SetJWTSecret failed: %v", err)
+	}
+	defer func() { JWTSecret = originalSecret }()
+
+	adminID := uuid.New()
+	challenge, err := GenerateAdminChallengeToken(adminID, "testadmin")
+	if err != nil {
+		t.Fatalf("GenerateAdminChallengeToken failed: %v", err)
+	}
+	claims, err := ValidateAdminChallengeToken(challenge)
+	if err != nil {
+		t.Fatalf("ValidateAdminChallengeToken failed: %v", err)
+	}
+	if claims.AdminID != adminID.String() {
+		t.Errorf("expected admin ID %s, got %s", adminID, claims.AdminID)
+	}
+
+	// A full admin token is not a challenge, and a challenge does not sign in
+	token, err := GenerateAdminToken(adminID, "testadmin", models.AdminRoleOwner, uuid.Nil)
+	if err != nil {
+		t.Fatalf("GenerateAdminToken failed: %v", err)
+	}
+	if _, err := ValidateAdminChallengeToken(token); err == nil {
+		t.Error("expected an admin token to be rejected as a challenge")
+	}
+
+	router := gin.New()
+	router.GET("/test", AdminAuthMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+challenge)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for a challenge token, got %d", w.Code)
+	}
+}
+
+func TestRequireAdminPermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	originalSecret := JWTSecret
+	if err := SetJWTSecret("test-secret-for-unit-tests-32ch!"); err != nil {
+		t.Fatalf("SetJWTSecret failed: %v", err)
+	}
+	defer func() { JWTSecret = originalSecret }()
+
+	router := gin.New()
+	router.GET("/alerts", AdminAuthMiddleware(), RequireAdminPermission(models.AdminPermissionRead), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	router.POST("/deactivate", AdminAuthMiddleware(), RequireAdminPermission(models.AdminPermissionManageKeys), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	tests := []struct {
+		role   models.AdminRole
+		method string
+		path   string
+		want   int
+	}{
+		{models.AdminRoleSupport, http.MethodGet, "/alerts", http.StatusOK},
+		{models.AdminRoleSupport, http.MethodPost, "/deactivate", http.StatusForbidden},
+		{models.AdminRoleOperator, http.MethodPost, "/deactivate", http.StatusOK},
+		{models.AdminRoleOwner, http.MethodPost, "/deactivate", http.StatusOK},
+		{"", http.MethodGet, "/alerts", http.StatusForbidden}, // token from before roles existed
+	}
+	for _, tt := range tests {
+		token, err := GenerateAdminToken(uuid.New(), "testadmin", tt.role, uuid.Nil)
+		if err != nil {
+			t.Fatalf("GenerateAdminToken failed: %v", err)
+		}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%q %s %s: expected status %d, got %d", tt.role, tt.method, tt.path, tt.want, w.Code)
+		}
+	}
+}
@@ -37,6 +37,21 @@ func newKeyRateLimiter(limit rate.Limit, burst int) *keyRateLimiter {
 }
 
 func (k *keyRateLimiter) getLimiter(key string) *rate.Limiter {
+	return k.getLimiterWith(key, k.limit, k.burst)
+}
+
+// getLimiterWith is getLimiter for a key with its own limit; an existing
+// limiter is adjusted when the key's limit has changed
+func (k *keyRateLimiter) getLimiterWith(key string, limit rate.Limit, burst int) *rate.Limiter {
+	l := k.lookup(key, limit, burst)
+	if l.Limit() != limit || l.Burst() != burst {
+		l.SetLimit(limit)
+		l.SetBurst(burst)
+	}
+	return l
+}
+
+func (k *keyRateLimiter) lookup(key string, limit rate.Limit, burst int) *rate.Limiter {
 	k.mu.RLock()
 	entry, ok := k.limiters[key]
 	k.mu.RUnlock()
@@ -55,7 +70,7 @@ func (k *keyRateLimiter) getLimiter(key string) *rate.Limiter {
 		entry.lastUsed = time.Now()
 		return entry.limiter
 	}
-	limiter := rate.NewLimiter(k.limit, k.burst)
+	limiter := rate.NewLimiter(limit, burst)
 	k.limiters[key] = &limiterEntry{
 		limiter:  limiter,
 		lastUsed: time.Now(),
@@ -100,6 +115,9 @@ func (k *keyRateLimiter) Stop() {
 type RateLimitConfig struct {
 	RequestsPerMinute int
 	Burst             int
+	// KeyLimit, if set, returns a per-minute limit that replaces
+	// RequestsPerMinute for the request's key; 0 keeps the default
+	KeyLimit func(c *gin.Context) int
 }
 
 // NewRateLimitingMiddleware creates a Gin middleware that enforces per-webhook_key limits
@@ -122,7 +140,18 @@ func NewRateLimitingMiddleware(cfg RateLimitConfig) gin.HandlerFunc {
 			webhookKey = "__global__"
 		}
 
-		l := limiter.getLimiter(webhookKey)
+		keyLimit, keyBurst := limit, cfg.Burst
+		if cfg.KeyLimit != nil {
+			if perMinute := cfg.KeyLimit(c); perMinute > 0 {
+				keyLimit = rate.Every(time.Minute / time.Duration(perMinute))
+				// A limit below the default burst also caps the burst
+				if perMinute < keyBurst {
+					keyBurst = perMinute
+				}
+			}
+		}
+
+		l := limiter.getLimiterWith(webhookKey, keyLimit, keyBurst)
 		if !l.Allow() {
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error":  "rate limit exceeded",
@@ -176,3 +205,12 @@ func AuthRateLimitMiddleware() gin.HandlerFunc {
 		Burst:             1,
 	})
 }
+
+// TwoFactorRateLimitMiddleware limits two-factor code attempts
+// Allows 10 requests per minute per IP address, enough for typos but not for guessing
+func TwoFactorRateLimitMiddleware() gin.HandlerFunc {
+	return NewIPRateLimitingMiddleware(RateLimitConfig{
+		RequestsPerMinute: 10,
+		Burst:             5,
+	})
+}
@@ -6,11 +6,11 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
-	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/database"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
 )
 
 func TestRequestIDMiddleware_GeneratesID(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		middleware := RequestIDMiddleware()
@@ -47,7 +47,7 @@ func TestRequestIDMiddleware_GeneratesID(t *testing.T) {
 }
 
 func TestRequestIDMiddleware_UsesExistingID(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		middleware := RequestIDMiddleware()
@@ -77,7 +77,7 @@ func TestRequestIDMiddleware_UsesExistingID(t *testing.T) {
 }
 
 func TestGetRequestID_ReturnsEmpty(t *testing.T) {
-	database.WithTestDB(t, func(tdb *database.TestDB) {
+	memory.WithTestStore(t, func(tdb *memory.TestStore) {
 		gin.SetMode(gin.TestMode)
 
 		w := httptest.NewRecorder()
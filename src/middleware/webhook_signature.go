@@ -13,9 +13,16 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// WebhookSignatureMiddleware validates HMAC-SHA256 webhook signatures
+// WebhookSignatureMiddleware validates HMAC-SHA256 webhook signatures. A
+// webhook key with its own signing secret always requires a signature made
+// with that secret, whatever the server-wide setting.
 func WebhookSignatureMiddleware(secret string, enabled bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		secret, enabled := secret, enabled
+		if wk, ok := WebhookKeyFromContext(c); ok && wk.Settings.SigningSecret != nil {
+			secret, enabled = *wk.Settings.SigningSecret, true
+		}
+
 		// If signature verification is disabled, just log a warning and continue
 		if !enabled {
 			log.Printf("[WARNING] Webhook signature verification is disabled. Enable it in production.")
@@ -23,39 +30,62 @@ func WebhookSignatureMiddleware(secret string, enabled bool) gin.HandlerFunc {
 			return
 		}
 
-		// Get signature from header
-		signature := c.GetHeader("X-Webhook-Signature")
-		if signature == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "missing X-Webhook-Signature header",
-			})
-			c.Abort()
+		if !requireSignature(c, secret) {
 			return
 		}
+		c.Next()
+	}
+}
 
-		// Get request body
-		body, err := c.GetRawData()
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "failed to read request body",
-			})
-			c.Abort()
-			return
+// KeySignatureMiddleware requires a signature only for webhook keys that have
+// their own signing secret, so MCP can't be used to bypass that secret.
+func KeySignatureMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if wk, ok := WebhookKeyFromContext(c); ok && wk.Settings.SigningSecret != nil {
+			if !requireSignature(c, *wk.Settings.SigningSecret) {
+				return
+			}
 		}
+		c.Next()
+	}
+}
 
-		// Verify signature
-		if !verifyWebhookSignature(body, signature, secret) {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid webhook signature",
-			})
-			c.Abort()
-			return
-		}
+// requireSignature checks the request body against X-Webhook-Signature and
+// restores the body for the next handlers. It aborts the request and returns
+// false when the signature is missing or wrong.
+func requireSignature(c *gin.Context, secret string) bool {
+	// Get signature from header
+	signature := c.GetHeader("X-Webhook-Signature")
+	if signature == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "missing X-Webhook-Signature header",
+		})
+		c.Abort()
+		return false
+	}
 
-		// Restore body for next handlers
-		c.Request.Body = io.NopCloser(bytes.NewReader(body))
-		c.Next()
+	// Get request body
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "failed to read request body",
+		})
+		c.Abort()
+		return false
+	}
+
+	// Verify signature
+	if !verifyWebhookSignature(body, signature, secret) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid webhook signature",
+		})
+		c.Abort()
+		return false
 	}
+
+	// Restore body for next handlers
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return true
 }
 
 // verifyWebhookSignature verifies HMAC-SHA256 signature
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,4 +15,71 @@ type AdminUser struct {
 	CreatedAt    time.Time  `json:"created_at"`
 	LastLogin    *time.Time `json:"last_login"`
 	IsActive     bool       `json:"is_active"`
+	Role         AdminRole  `json:"role"`
+
+	// An invited admin has no password until they accept the invite; only
+	// the invite token's hash is stored
+	InviteTokenHash string     `json:"-"`
+	InviteExpiresAt *time.Time `json:"invite_expires_at,omitempty"`
+
+	// TOTP second factor: the secret as stored (encrypted when encryption is
+	// on), and when enrollment was confirmed; a secret without TOTPEnabledAt
+	// is an enrollment in progress
+	TOTPSecret        []byte     `json:"-"`
+	TOTPEnabledAt     *time.Time `json:"totp_enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+// TOTPEnabled reports whether the admin signs in with a second factor
+func (a *AdminUser) TOTPEnabled() bool {
+	return a.TOTPEnabledAt != nil
+}
+
+// InvitePending reports whether the admin has yet to accept their invite
+func (a *AdminUser) InvitePending() bool {
+	return a.InviteTokenHash != ""
+}
+
+// AdminRole decides what an admin user may do
+type AdminRole string
+
+// Admin roles, from most to least powerful
+const (
+	AdminRoleOwner    AdminRole = "owner"    // everything, including managing other admins
+	AdminRoleOperator AdminRole = "operator" // day-to-day operations on keys, events and cleanup
+	AdminRoleSupport  AdminRole = "support"  // read-only: users, keys and delivery status
+)
+
+// AllAdminRoles lists every valid role
+var AllAdminRoles = []AdminRole{AdminRoleOwner, AdminRoleOperator, AdminRoleSupport}
+
+// Permissions gating admin endpoints
+const (
+	AdminPermissionRead         = "admin:read"   // users, keys, events, delivery alerts, storage and cleanup status
+	AdminPermissionManageKeys   = "keys:write"   // activate and deactivate keys, set path rules
+	AdminPermissionManageEvents = "events:write" // delete events, run cleanup
+	AdminPermissionManageAdmins = "admins:write" // invite admins, change roles, deactivate admins
+	AdminPermissionViewAudit    = "audit:read"   // read, export and verify the audit log
+)
+
+// adminRolePermissions maps each role to what it is allowed
+var adminRolePermissions = map[AdminRole][]string{
+	AdminRoleOwner:    {AdminPermissionRead, AdminPermissionManageKeys, AdminPermissionManageEvents, AdminPermissionManageAdmins, AdminPermissionViewAudit},
+	AdminRoleOperator: {AdminPermissionRead, AdminPermissionManageKeys, AdminPermissionManageEvents},
+	AdminRoleSupport:  {AdminPermissionRead},
+}
+
+// Valid reports whether r is a known role
+func (r AdminRole) Valid() bool {
+	return slices.Contains(AllAdminRoles, r)
+}
+
+// Can reports whether the role grants permission
+func (r AdminRole) Can(permission string) bool {
+	return slices.Contains(adminRolePermissions[r], permission)
+}
+
+// Permissions lists what the role grants
+func (r AdminRole) Permissions() []string {
+	return slices.Clone(adminRolePermissions[r])
 }
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes a personal access token can be granted
+const (
+	ScopeKeysRead    = "keys:read"
+	ScopeKeysWrite   = "keys:write"
+	ScopeEventsRead  = "events:read"
+	ScopeEventsWrite = "events:write"
+	ScopeLogsRead    = "logs:read"
+	ScopeAuditRead   = "audit:read"
+)
+
+// AllScopes lists every valid scope
+var AllScopes = []string{ScopeKeysRead, ScopeKeysWrite, ScopeEventsRead, ScopeEventsWrite, ScopeLogsRead, ScopeAuditRead}
+
+// APIToken is a personal access token for the dashboard API. The token
+// itself is shown once on creation; only its hash is stored.
+type APIToken struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	UserEmail  string     `json:"-"` // the owner's current email, read with the token
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // first characters of the token, for recognising it
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // nil = never expires
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the token was granted scope
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// IsUsable reports whether the token is neither revoked nor expired at now
+func (t *APIToken) IsUsable(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditActorType says who performed an audited action
+type AuditActorType string
+
+// Audit actor types
+const (
+	AuditActorAdmin AuditActorType = "admin"
+	AuditActorUser  AuditActorType = "user"
+)
+
+// Audited actions
+const (
+	AuditActionAdminLogin       = "admin.login"
+	AuditActionAdminLoginFailed = "admin.login_failed"
+	AuditActionAdminLogout      = "admin.logout"
+
+	AuditActionAdminInvite       = "admin.invite"
+	AuditActionAdminInviteAccept = "admin.invite_accept"
+	AuditActionAdminRoleChange   = "admin.role_change"
+	AuditActionAdminActivate     = "admin.activate"
+	AuditActionAdminDeactivate   = "admin.deactivate"
+
+	AuditActionTOTPEnable        = "admin.totp_enable"
+	AuditActionTOTPDisable       = "admin.totp_disable"
+	AuditActionTOTPRecoveryCodes = "admin.totp_recovery_codes"
+
+	AuditActionKeyActivate      = "key.activate"
+	AuditActionKeyDeactivate    = "key.deactivate"
+	AuditActionKeyPathRules     = "key.path_rules"
+	AuditActionKeyRotate        = "key.rotate"
+	AuditActionPairRevoke       = "pair.revoke"
+	AuditActionWebhookKeyRevoke = "webhook_key.revoke"
+	AuditActionEventDelete      = "event.delete"
+	AuditActionCleanupRun       = "cleanup.run"
+
+	AuditActionUserLogin         = "user.login"
+	AuditActionSessionRevoke     = "session.revoke"
+	AuditActionSessionsRevokeAll = "session.revoke_all"
+	AuditActionAPITokenCreate    = "api_token.create"
+	AuditActionAPITokenRevoke    = "api_token.revoke"
+	AuditActionRetentionUpdate   = "retention.update"
+	AuditActionAlertsUpdate      = "alerts.update"
+	AuditActionAccountCreate     = "account.create"
+	AuditActionAccountExport     = "account.export"
+	AuditActionAccountDelete     = "account.delete"
+)
+
+// Kinds of audit event targets
+const (
+	AuditTargetAdmin      = "admin"
+	AuditTargetWebhookKey = "webhook_key"
+	AuditTargetClientKey  = "client_key"
+	AuditTargetKeyPair    = "key_pair"
+	AuditTargetEvent      = "event"
+	AuditTargetSession    = "session"
+	AuditTargetAPIToken   = "api_token"
+	AuditTargetUser       = "user"
+)
+
+// AuditEvent is one entry of the append-only audit log. Entries form a hash
+// chain: Hash covers every other field, including the previous entry's hash,
+// so editing or removing an entry breaks every link after it.
+type AuditEvent struct {
+	ID        uuid.UUID `json:"id"`
+	Seq       int64     `json:"seq"` // position in the chain, starting at 1
+	CreatedAt time.Time `json:"created_at"`
+
+	ActorType AuditActorType `json:"actor_type"`
+	ActorID   string         `json:"actor_id"`   // admin ID, or the user's email
+	ActorName string         `json:"actor_name"` // admin username, or the user's email
+
+	Action     string `json:"action"`
+	TargetType string `json:"target_type,omitempty"`
+	TargetID   string `json:"target_id,omitempty"`
+	// UserEmail is the dashboard user the event concerns, which makes it
+	// visible in that user's own audit log
+	UserEmail string `json:"user_email,omitempty"`
+
+	IP        string `json:"ip"`
+	RequestID string `json:"request_id,omitempty"`
+
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// ForUser returns the event as shown in a user's own audit log: the user
+// learns that an admin acted, but not which admin or from where. The chain
+// hashes are dropped too, since with the other fields known they would let
+// the hidden ones be guessed.
+func (e AuditEvent) ForUser() AuditEvent {
+	if e.ActorType == AuditActorAdmin {
+		e.ActorID, e.ActorName, e.IP = "", "", ""
+	}
+	e.PrevHash, e.Hash = "", ""
+	return e
+}
+
+// AuditFilter selects audit events; zero fields match everything
+type AuditFilter struct {
+	ActorID   string
+	Action    string // an exact action, or a prefix ending in "." such as "key."
+	TargetID  string
+	UserEmail string
+	Since     *time.Time
+	Until     *time.Time
+	BeforeSeq int64 // only events older than this position, for paging
+	Limit     int
+}
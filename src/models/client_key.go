@@ -6,10 +6,13 @@ import (
 	"github.com/google/uuid"
 )
 
-// ClientKey represents a client API key for SSE connections
+// ClientKey represents a client API key for SSE connections. Like a
+// WebhookKey it carries KeyValue only when just created.
 type ClientKey struct {
 	ID              uuid.UUID  `json:"id"`
-	KeyValue        string     `json:"key_value"`
+	KeyHash         string     `json:"-"`
+	KeyPrefix       string     `json:"key_prefix"`
+	KeyValue        string     `json:"key_value,omitempty"`
 	WebhookKeyID    uuid.UUID  `json:"webhook_key_id"`
 	Status          string     `json:"status"` // active or inactive
 	CreatedAt       time.Time  `json:"created_at"`
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DataKey is a key pair's data encryption key, stored wrapped by the master key.
+// Deleting it leaves the pair's encrypted events unreadable.
+type DataKey struct {
+	WebhookKeyID uuid.UUID `json:"webhook_key_id"`
+	KeyID        string    `json:"key_id"` // names the key in event ciphertext headers
+	WrappedKey   []byte    `json:"-"`      // the DEK encrypted with the master keyring
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"` // last rewrap
+}
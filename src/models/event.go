@@ -16,6 +16,47 @@ type Event struct {
 	ProcessedAt  *time.Time `json:"processed_at,omitempty"`
 	CreatedAt    time.Time  `json:"created_at"`
 	ExpiresAt    time.Time  `json:"expires_at"`
+	DeliverAfter *time.Time `json:"deliver_after,omitempty"` // nil = deliver immediately
+	Sealed       bool       `json:"sealed"`                  // Data is a sealed box only the client can open
+	Mode         WriteMode  `json:"mode,omitempty"`          // "" = the plugin's configured write mode
+	BlobRef      string     `json:"-"`                       // content hash of the payload in the blob store; "" = stored inline
+
+	// Storage accounting, written on create and only read back as totals
+	PayloadSize int64 `json:"-"` // bytes received
+	StoredSize  int64 `json:"-"` // bytes stored after compression and encryption, inline or in the blob store
+	Compressed  bool  `json:"-"`
+}
+
+// WriteMode is how the plugin writes an event's data to its note
+type WriteMode string
+
+const (
+	WriteModeAppend    WriteMode = "append"
+	WriteModeOverwrite WriteMode = "overwrite"
+)
+
+// EventStorageStats summarises how much space stored events take. Events
+// created before sizes were recorded are counted in Events only.
+type EventStorageStats struct {
+	Events           int64   `json:"events"`
+	MeasuredEvents   int64   `json:"measured_events"`
+	CompressedEvents int64   `json:"compressed_events"`
+	PayloadBytes     int64   `json:"payload_bytes"`
+	StoredBytes      int64   `json:"stored_bytes"`
+	CompressionRatio float64 `json:"compression_ratio"` // payload_bytes / stored_bytes; 0 when nothing is measured
+}
+
+// StuckDelivery is an active webhook key with due events no client has
+// fetched, as found by the stuck delivery monitor
+type StuckDelivery struct {
+	WebhookKeyID  uuid.UUID  `json:"webhook_key_id"`
+	KeyPrefix     string     `json:"key_prefix"`
+	KeyName       string     `json:"key_name,omitempty"`
+	UserEmail     string     `json:"user_email,omitempty"` // empty for keys without a user
+	AlertsEnabled bool       `json:"alerts_enabled"`       // the owner has not opted out of delivery alerts
+	Undelivered   int        `json:"undelivered"`
+	OldestDueAt   time.Time  `json:"oldest_due_at"` // COALESCE(deliver_after, created_at) of the oldest undelivered event
+	AlertedAt     *time.Time `json:"alerted_at,omitempty"`
 }
 
 // IsProcessed returns true if the event has been processed
@@ -23,6 +64,11 @@ func (e *Event) IsProcessed() bool {
 	return e.Processed
 }
 
+// IsScheduled returns true if the event is held back until a future delivery time
+func (e *Event) IsScheduled() bool {
+	return e.DeliverAfter != nil && e.DeliverAfter.After(time.Now())
+}
+
 // MarkProcessed marks the event as processed
 func (e *Event) MarkProcessed() {
 	e.Processed = true
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidPathGlob is returned for a glob that can't be matched against
+// cleaned vault paths
+var ErrInvalidPathGlob = errors.New("invalid path glob")
+
+// maxCachedPathGlobs bounds the compiled glob cache; it is emptied when full
+const maxCachedPathGlobs = 10000
+
+// pathGlobs caches compiled globs by pattern, so a glob is translated once
+// rather than on every event
+var pathGlobs = struct {
+	sync.RWMutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// MatchPathGlob reports whether a vault path matches a glob. "*" matches
+// within one folder, "**" across folders ("Inbox/**" covers everything under
+// Inbox/, "**/*.md" every note at any depth) and "?" one character other than
+// "/". Leading slashes on path are ignored. An invalid glob matches nothing.
+func MatchPathGlob(pattern, path string) bool {
+	re, err := CompilePathGlob(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(strings.TrimLeft(path, "/"))
+}
+
+// CompilePathGlob checks a glob and returns its compiled form, caching it for
+// later matches. Globs are relative to the vault and use "/" between folders.
+func CompilePathGlob(pattern string) (*regexp.Regexp, error) {
+	pathGlobs.RLock()
+	re, ok := pathGlobs.m[pattern]
+	pathGlobs.RUnlock()
+	if ok {
+		return re, nil
+	}
+
+	if err := checkPathGlob(pattern); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pathGlobExpr(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidPathGlob, pattern, err)
+	}
+
+	pathGlobs.Lock()
+	if len(pathGlobs.m) >= maxCachedPathGlobs {
+		pathGlobs.m = make(map[string]*regexp.Regexp)
+	}
+	pathGlobs.m[pattern] = re
+	pathGlobs.Unlock()
+	return re, nil
+}
+
+// checkPathGlob rejects globs no cleaned path could match: event paths are
+// relative, use "/" and have no empty, "." or ".." folders
+func checkPathGlob(pattern string) error {
+	switch {
+	case pattern == "":
+		return fmt.Errorf("%w: empty", ErrInvalidPathGlob)
+	case strings.HasPrefix(pattern, "/"):
+		return fmt.Errorf("%w: %q must be relative to the vault", ErrInvalidPathGlob, pattern)
+	case strings.Contains(pattern, `\`):
+		return fmt.Errorf("%w: %q must use \"/\" between folders", ErrInvalidPathGlob, pattern)
+	case strings.Contains(pattern, "***"):
+		return fmt.Errorf("%w: %q has more than two \"*\" in a row", ErrInvalidPathGlob, pattern)
+	}
+	for _, folder := range strings.Split(strings.TrimSuffix(pattern, "/"), "/") {
+		if folder == "" || folder == "." || folder == ".." {
+			return fmt.Errorf("%w: %q has an empty, \".\" or \"..\" folder", ErrInvalidPathGlob, pattern)
+		}
+	}
+	return nil
+}
+
+// pathGlobExpr translates a glob into an anchored regular expression
+func pathGlobExpr(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			// Zero or more whole folders, so "a/**/b" also matches "a/b"
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			b.WriteString("[^/]*")
+		case pattern[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
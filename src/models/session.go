@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is one signed-in browser, either a dashboard user's or an admin's.
+// The session JWT carries the ID, so revoking the row ends the session
+// before the token itself expires.
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     *uuid.UUID `json:"-"` // set for dashboard sessions
+	AdminID    *uuid.UUID `json:"-"` // set for admin sessions
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// IsActive reports whether the session is neither revoked nor expired at now
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// User represents a user with their key information
+type User struct {
+	ID               uuid.UUID  `json:"id"`
+	UserEmail        string     `json:"user_email"`
+	UserName         string     `json:"user_name"`
+	IsActive         bool       `json:"is_active"`
+	WebhookKeyCount  int        `json:"webhook_key_count"`
+	ClientKeyCount   int        `json:"client_key_count"`
+	WebhookKeyPrefix string     `json:"webhook_key_prefix"`
+	ClientKeyPrefix  string     `json:"client_key_prefix"`
+	WebhookKeyID     *uuid.UUID `json:"webhook_key_id,omitempty"` // set by List: the keys the prefixes belong to
+	ClientKeyID      *uuid.UUID `json:"client_key_id,omitempty"`
+	UsageCount       int        `json:"usage_count"`
+	CreatedAt        time.Time  `json:"created_at"`
+	LastUsed         *time.Time `json:"last_used"`
+}
+
+// UserProfile is a row of the users table
+type UserProfile struct {
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	Name          string    `json:"name"`
+	Language      string    `json:"language"`
+	EmailVerified bool      `json:"email_verified"`
+	// DeliveryAlerts is false once the user opts out of stuck delivery emails
+	DeliveryAlerts bool `json:"delivery_alerts"`
+}
+
+// KeyPair represents a webhook+client key pair for the dashboard
+type KeyPair struct {
+	PairID           string     `json:"pair_id"`
+	WebhookKeyPrefix string     `json:"webhook_key_prefix"`
+	ClientKeyPrefix  string     `json:"client_key_prefix"`
+	IsActive         bool       `json:"is_active"`
+	CreatedAt        time.Time  `json:"created_at"`
+	LastUsed         *time.Time `json:"last_used,omitempty"`
+	UsageCount       int        `json:"usage_count"`
+
+	Retention RetentionSettings `json:"retention"`
+
+	// Every webhook key feeding the pair's client key, the original first
+	WebhookKeys []WebhookKey `json:"webhook_keys"`
+
+	// Set while a rotated key's old value is still accepted
+	PreviousWebhookKeyExpiresAt *time.Time `json:"previous_webhook_key_expires_at,omitempty"`
+	PreviousClientKeyExpiresAt  *time.Time `json:"previous_client_key_expires_at,omitempty"`
+}
+
+// KeyInfo represents basic key information for responses
+type KeyInfo struct {
+	ID        string
+	KeyPrefix string
+	KeyType   string
+	IsActive  bool
+	UserEmail string // owner's email, empty for keys without a user
+}
+
+// LegacyKey is a key stored in the clear before keys were hashed, with the
+// value a rotation replaced if that is still accepted
+type LegacyKey struct {
+	ID               uuid.UUID
+	KeyValue         string
+	PreviousKeyValue *string
+}
+
+// MagicLinkToken is a stored magic link token and its lifecycle timestamps
+type MagicLinkToken struct {
+	Token     string
+	Email     string
+	ExpiresAt *time.Time
+	UsedAt    *time.Time
+}
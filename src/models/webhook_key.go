@@ -1,23 +1,98 @@
 package models
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// WebhookKey represents a webhook API key
+// WebhookKey represents a webhook API key. A pair starts with one webhook key
+// whose ID is the pair ID; named webhook keys added later share its PairID and
+// feed the same client key.
+//
+// Only the key's hash and prefix are stored; KeyValue is set on a key that was
+// just created so it can be shown once.
 type WebhookKey struct {
-	ID             uuid.UUID  `json:"id"`
-	KeyValue       string     `json:"key_value"`
-	Status         string     `json:"status"` // active or inactive
-	CreatedAt      time.Time  `json:"created_at"`
-	LastUsed       *time.Time `json:"last_used,omitempty"`
-	EventsCount    int        `json:"events_count"`
-	ClientKeyValue string     `json:"client_key,omitempty"`
+	ID              uuid.UUID          `json:"id"`
+	PairID          uuid.UUID          `json:"pair_id"`
+	Name            string             `json:"name,omitempty"`
+	KeyHash         string             `json:"-"`
+	KeyPrefix       string             `json:"key_prefix"`
+	KeyValue        string             `json:"key_value,omitempty"`
+	Status          string             `json:"status"` // active or inactive
+	CreatedAt       time.Time          `json:"created_at"`
+	LastUsed        *time.Time         `json:"last_used,omitempty"`
+	EventsCount     int                `json:"events_count"`
+	ClientKeyPrefix string             `json:"client_key_prefix,omitempty"`
+	Settings        WebhookKeySettings `json:"settings"`
 }
 
 // IsActive returns true if the webhook key is active
 func (wk *WebhookKey) IsActive() bool {
 	return wk.Status == "active"
 }
+
+// IsPrimary reports whether this is the webhook key the pair was created with
+func (wk *WebhookKey) IsPrimary() bool {
+	return wk.PairID == uuid.Nil || wk.ID == wk.PairID
+}
+
+// EventPath returns the vault path an event sent to path is written to: under
+// the key's forced prefix when it has one, otherwise path unchanged
+func (wk *WebhookKey) EventPath(path string) string {
+	if wk.Settings.ForcedPathPrefix == nil {
+		return path
+	}
+	prefix := *wk.Settings.ForcedPathPrefix + "/"
+	path = strings.TrimLeft(path, "/")
+	if strings.HasPrefix(path, prefix) {
+		return path
+	}
+	return prefix + path
+}
+
+// AllowsPath reports whether events may be written to path with this key. A
+// key without an allowlist may write anywhere.
+func (wk *WebhookKey) AllowsPath(path string) bool {
+	if len(wk.Settings.AllowedPaths) == 0 {
+		return true
+	}
+	for _, pattern := range wk.Settings.AllowedPaths {
+		if MatchPathGlob(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookKeySettings are a webhook key's own limits. A nil field means the
+// server-wide behaviour applies.
+type WebhookKeySettings struct {
+	RateLimitPerMinute *int     `json:"rate_limit_per_minute"`
+	SigningSecret      *string  `json:"-"`                  // requests must carry an X-Webhook-Signature made with it
+	AllowedPaths       []string `json:"allowed_paths"`      // path globs, see MatchPathGlob; empty allows any path
+	ForcedPathPrefix   *string  `json:"forced_path_prefix"` // folder every event is written under, without slashes at either end
+}
+
+// MarshalJSON reports whether a signing secret is set without revealing it
+func (s WebhookKeySettings) MarshalJSON() ([]byte, error) {
+	allowed := s.AllowedPaths
+	if allowed == nil {
+		allowed = []string{}
+	}
+	return json.Marshal(struct {
+		RateLimitPerMinute *int     `json:"rate_limit_per_minute"`
+		AllowedPaths       []string `json:"allowed_paths"`
+		ForcedPathPrefix   *string  `json:"forced_path_prefix"`
+		SigningSecretSet   bool     `json:"signing_secret_set"`
+	}{s.RateLimitPerMinute, allowed, s.ForcedPathPrefix, s.SigningSecret != nil})
+}
+
+// RetentionSettings holds a webhook key's retention overrides in days.
+// A nil field means the server default applies.
+type RetentionSettings struct {
+	EventTTLDays     *int `json:"event_ttl_days"`     // unprocessed events, from creation or scheduled delivery
+	ProcessedTTLDays *int `json:"processed_ttl_days"` // processed events, from acknowledgement
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Webhook log delivery statuses
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+	DeliveryStatusAcked     = "acked"
+)
+
+// WebhookLog represents a webhook delivery log entry
+type WebhookLog struct {
+	ID             string
+	EventID        string
+	WebhookKeyID   string
+	ClientKeyID    *string
+	DeliveryStatus string
+	StatusCode     *int
+	ErrorMessage   *string
+	AttemptedAt    time.Time
+	DeliveredAt    *time.Time
+	AckedAt        *time.Time
+	ClientIP       *string
+	CreatedAt      time.Time
+}
+
+// WebhookLogEntry is a simplified webhook log for dashboard API (no path — privacy)
+type WebhookLogEntry struct {
+	ID             string     `json:"id"`
+	EventID        string     `json:"event_id"`
+	DeliveryStatus string     `json:"delivery_status"`
+	StatusCode     *int       `json:"status_code,omitempty"`
+	ErrorMessage   *string    `json:"error_message,omitempty"`
+	AttemptedAt    time.Time  `json:"attempted_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	AckedAt        *time.Time `json:"acked_at,omitempty"`
+}
+
+// Entry returns the dashboard view of the log entry
+func (wl *WebhookLog) Entry() WebhookLogEntry {
+	return WebhookLogEntry{
+		ID:             wl.ID,
+		EventID:        wl.EventID,
+		DeliveryStatus: wl.DeliveryStatus,
+		StatusCode:     wl.StatusCode,
+		ErrorMessage:   wl.ErrorMessage,
+		AttemptedAt:    wl.AttemptedAt,
+		DeliveredAt:    wl.DeliveredAt,
+		AckedAt:        wl.AckedAt,
+	}
+}
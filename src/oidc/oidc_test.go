@@ -0,0 +1,151 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/oidc/oidctest"
+)
+
+const redirectURL = "http://localhost:8080/auth/oidc/callback"
+
+func newProvider(t *testing.T, secret string) (*oidctest.Provider, *Provider) {
+	t.Helper()
+	idp := oidctest.NewProvider(t, "webhooks", secret)
+	return idp, NewProvider(Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "webhooks",
+		ClientSecret: secret,
+		RedirectURL:  redirectURL,
+	})
+}
+
+// signIn runs the authorization code flow and verifies the ID token with nonce
+func signIn(t *testing.T, idp *oidctest.Provider, p *Provider, nonce string) (*Claims, error) {
+	t.Helper()
+	ctx := context.Background()
+	verifier, _ := NewRandomString()
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	callback := idp.Authorize(t, authURL)
+	if callback.Query().Get("state") != "state-1" {
+		t.Fatalf("expected the state back, got %q", callback.Query().Get("state"))
+	}
+	idToken, err := p.Exchange(ctx, callback.Query().Get("code"), verifier)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	return p.VerifyIDToken(ctx, idToken, nonce)
+}
+
+func TestProvider_SignIn(t *testing.T) {
+	for name, secret := range map[string]string{"confidential client": "s3cret/+", "public client": ""} {
+		t.Run(name, func(t *testing.T) {
+			idp, p := newProvider(t, secret)
+			idp.SetUser(oidctest.User{Subject: "42", Email: "bob@example.com", EmailVerified: true, Name: "Bob"})
+
+			claims, err := signIn(t, idp, p, "nonce-1")
+			if err != nil {
+				t.Fatalf("VerifyIDToken failed: %v", err)
+			}
+			if claims.Subject != "42" || claims.Email != "bob@example.com" || !claims.EmailVerified || claims.Name != "Bob" {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestProvider_EmailVerifiedAsString(t *testing.T) {
+	idp, p := newProvider(t, "secret")
+	idp.ModifyClaims = func(claims jwt.MapClaims) { claims["email_verified"] = "true" }
+
+	claims, err := signIn(t, idp, p, "nonce-1")
+	if err != nil || !claims.EmailVerified {
+		t.Errorf("expected \"true\" to count as verified, got %+v (%v)", claims, err)
+	}
+}
+
+func TestProvider_RejectsBadIDTokens(t *testing.T) {
+	for name, modify := range map[string]func(jwt.MapClaims){
+		"wrong audience":       func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+		"wrong issuer":         func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expired":              func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no expiry":            func(c jwt.MapClaims) { delete(c, "exp") },
+		"other party":          func(c jwt.MapClaims) { c["azp"] = "someone-else" },
+		"no subject":           func(c jwt.MapClaims) { delete(c, "sub") },
+		"issued in the future": func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			idp, p := newProvider(t, "secret")
+			idp.ModifyClaims = modify
+			if _, err := signIn(t, idp, p, "nonce-1"); !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("expected ErrInvalidIDToken, got %v", err)
+			}
+		})
+	}
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		idp, p := newProvider(t, "secret")
+		if _, err := signIn(t, idp, p, "another-nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("expected ErrInvalidIDToken, got %v", err)
+		}
+	})
+}
+
+func TestProvider_ExchangeChecksVerifierAndSecret(t *testing.T) {
+	ctx := context.Background()
+	idp, p := newProvider(t, "secret")
+	verifier, _ := NewRandomString()
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	code := idp.Authorize(t, authURL).Query().Get("code")
+	other, _ := NewRandomString()
+	if _, err := p.Exchange(ctx, code, other); !errors.Is(err, ErrTokenExchange) {
+		t.Errorf("expected a wrong verifier to be refused, got %v", err)
+	}
+
+	wrongSecret := NewProvider(Config{Issuer: idp.Issuer(), ClientID: "webhooks", ClientSecret: "guess", RedirectURL: redirectURL})
+	authURL, _ = wrongSecret.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if _, err := wrongSecret.Exchange(ctx, idp.Authorize(t, authURL).Query().Get("code"), verifier); !errors.Is(err, ErrTokenExchange) {
+		t.Errorf("expected a wrong client secret to be refused, got %v", err)
+	}
+}
+
+func TestProvider_RefetchesKeysAfterRotation(t *testing.T) {
+	idp, p := newProvider(t, "secret")
+	if _, err := signIn(t, idp, p, "nonce-1"); err != nil {
+		t.Fatalf("first sign-in failed: %v", err)
+	}
+	idp.RotateKey(t)
+	if _, err := signIn(t, idp, p, "nonce-1"); err != nil {
+		t.Errorf("expected the new signing key to be fetched, got %v", err)
+	}
+}
+
+func TestProvider_DiscoveryChecksIssuer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"issuer":"https://idp.example.com","authorization_endpoint":"a","token_endpoint":"t","jwks_uri":"j"}`))
+	}))
+	defer server.Close()
+
+	p := NewProvider(Config{Issuer: server.URL, ClientID: "webhooks"})
+	if err := p.Discover(context.Background()); !errors.Is(err, ErrDiscovery) {
+		t.Errorf("expected an issuer mismatch to fail discovery, got %v", err)
+	}
+
+	idp, _ := newProvider(t, "")
+	p = NewProvider(Config{Issuer: idp.Issuer() + "/", ClientID: "webhooks"})
+	if err := p.Discover(context.Background()); err != nil {
+		t.Errorf("expected a trailing slash to be tolerated, got %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+package repositories
+
+import "errors"
+
+// Sentinel errors shared by all repository implementations
+
+var (
+	// ErrNotFound indicates the requested row does not exist (or no row was affected)
+	ErrNotFound = errors.New("not found")
+)
@@ -2,27 +2,35 @@ package repositories
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
 )
 
 // KeyRepository defines the interface for key data access
+//
+// Keys are looked up by their hash (see package keyhash); the repository never
+// sees a key's value, and models it returns carry only KeyHash and KeyPrefix.
 type KeyRepository interface {
 	// Validation
-	ValidateWebhookKey(ctx context.Context, keyValue string) (bool, error)
-	ValidateClientKey(ctx context.Context, keyValue string) (bool, error)
+	ValidateWebhookKey(ctx context.Context, keyHash string) (bool, error)
+	ValidateClientKey(ctx context.Context, keyHash string) (bool, error)
 
-	// Get by value
-	GetWebhookKeyByValue(ctx context.Context, keyValue string) (*models.WebhookKey, error)
-	GetClientKeyByValue(ctx context.Context, keyValue string) (*models.ClientKey, error)
-	GetKeyByID(ctx context.Context, keyID string) (*KeyInfo, error)
+	// Get by hash
+	GetWebhookKeyByHash(ctx context.Context, keyHash string) (*models.WebhookKey, error)
+	GetClientKeyByHash(ctx context.Context, keyHash string) (*models.ClientKey, error)
+	GetKeyByID(ctx context.Context, keyID uuid.UUID) (*models.KeyInfo, error)
+	GetEmailByWebhookKeyHash(ctx context.Context, keyHash string) (string, error)
 
-	// CRUD operations
+	// CRUD operations. Created keys are stored with their KeyHash and KeyPrefix.
 	CreateWebhookKey(ctx context.Context, key *models.WebhookKey) error
 	CreateClientKey(ctx context.Context, key *models.ClientKey) error
-	UpdateKeyStatus(ctx context.Context, keyValue string, isActive bool) error
-	DeleteKeyPair(ctx context.Context, webhookKeyValue string) error
+	UpdateKeyStatus(ctx context.Context, keyHash string, keyType models.KeyType, isActive bool) error
+	UpdateKeyStatusByID(ctx context.Context, keyID uuid.UUID, keyType models.KeyType, isActive bool) error
+	DeactivateKeyByID(ctx context.Context, keyID uuid.UUID) error
+	DeleteKeyPair(ctx context.Context, webhookKeyHash string) error
+	IncrementUsage(ctx context.Context, keyID uuid.UUID) error
 
 	// Listing
 	GetWebhookKeys(ctx context.Context) ([]*models.WebhookKey, error)
@@ -30,30 +38,250 @@ type KeyRepository interface {
 
 	// Key pair creation (transactional)
 	CreateKeyPair(ctx context.Context, webhookKey *models.WebhookKey, clientKey *models.ClientKey) error
+	CreateUserKeyPair(ctx context.Context, userID uuid.UUID, webhookKey *models.WebhookKey, clientKey *models.ClientKey) error
+
+	// A user's keys, looked up through the users table by email
+	CountUserKeys(ctx context.Context, userEmail string, keyType models.KeyType) (int, error)
+	ListUserKeyPairs(ctx context.Context, userEmail string) ([]models.KeyPair, error)
+	DeactivateUserKeyPair(ctx context.Context, pairID uuid.UUID, userEmail string) error
+
+	// Retention overrides stored on the webhook key
+	GetRetention(ctx context.Context, webhookKeyID uuid.UUID) (*models.RetentionSettings, error)
+	SetRetention(ctx context.Context, webhookKeyID uuid.UUID, settings models.RetentionSettings) error
+
+	// SetDeliveryAlertedAt records when the stuck delivery monitor last alerted
+	// about a webhook key; ErrNotFound if there is no such key
+	SetDeliveryAlertedAt(ctx context.Context, webhookKeyID uuid.UUID, at time.Time) error
+
+	// End-to-end encryption public key stored on the client key
+	SetE2EPublicKey(ctx context.Context, clientKeyID uuid.UUID, publicKey *string) error
+	// GetE2EPublicKey returns the public key registered by the active client key
+	// paired with a webhook key, or ErrNotFound if none is registered
+	GetE2EPublicKey(ctx context.Context, webhookKeyID uuid.UUID) (string, error)
+
+	// Named webhook keys. CreatePairWebhookKey adds a webhook key to one of the
+	// user's active pairs, owned by the same user as the pair's original key;
+	// ErrNotFound if the pair is missing, inactive or not theirs.
+	CreatePairWebhookKey(ctx context.Context, pairID uuid.UUID, userEmail string, key *models.WebhookKey) error
+	// UpdateWebhookKeySettings replaces the name and settings of one of the user's webhook keys
+	UpdateWebhookKeySettings(ctx context.Context, keyID uuid.UUID, userEmail, name string, settings models.WebhookKeySettings) error
+	// SetWebhookKeyPathRules replaces the path allowlist and forced prefix of
+	// any webhook key, for admins; ErrNotFound if there is no such key
+	SetWebhookKeyPathRules(ctx context.Context, keyID uuid.UUID, allowedPaths []string, forcedPrefix *string) error
+	// DeactivateUserWebhookKey revokes one of the user's named webhook keys. A
+	// pair's original webhook key is only revoked together with the pair.
+	DeactivateUserWebhookKey(ctx context.Context, keyID uuid.UUID, userEmail string) error
+
+	// RotateKey replaces the hash and prefix of the pair's original webhook key
+	// or newest active client key. The old hash keeps resolving in lookups
+	// until previousExpiresAt, or stops at once when it is nil. ErrNotFound if
+	// the pair has no such active key.
+	RotateKey(ctx context.Context, pairID uuid.UUID, keyType models.KeyType, newHash, newPrefix string, previousExpiresAt *time.Time) error
+	// ClearExpiredRotations forgets up to limit previous key hashes that expired before cutoff
+	ClearExpiredRotations(ctx context.Context, cutoff time.Time, limit int) (int64, error)
+
+	// ListUnhashedKeys returns up to limit keys still stored in the clear from
+	// before keys were hashed. StoreKeyHash replaces such a key's stored value
+	// with its hash and prefix, and its previous value with previousKeyHash;
+	// ErrNotFound if the key is missing or already hashed.
+	ListUnhashedKeys(ctx context.Context, limit int) ([]models.LegacyKey, error)
+	StoreKeyHash(ctx context.Context, keyID uuid.UUID, keyHash, keyPrefix string, previousKeyHash *string) error
+
+	// DeleteInactiveKeyPairs deletes up to limit key pairs whose keys are all
+	// inactive and unused since idleSince, returning the number of keys deleted
+	DeleteInactiveKeyPairs(ctx context.Context, idleSince time.Time, limit int) (int64, error)
+}
+
+// UserRepository stores user accounts; keys reference their owner by user_id
+type UserRepository interface {
+	// Upsert creates the user, or updates the name and language of the user
+	// with the same email. email_verified is never cleared. profile.ID is set.
+	Upsert(ctx context.Context, profile *models.UserProfile) error
+	GetByEmail(ctx context.Context, email string) (*models.UserProfile, error)
+	// List returns every user with totals over their keys and their newest
+	// original webhook key and client key, newest user first; users without
+	// keys are included
+	List(ctx context.Context) ([]models.User, error)
+	// Get returns a user with their newest active original webhook key and client key
+	Get(ctx context.Context, email string) (*models.User, error)
+	// Delete removes a user and everything they own: keys with their events,
+	// webhook logs and data keys, API tokens and sessions. ErrNotFound if
+	// there is no such user.
+	Delete(ctx context.Context, email string) error
+	// SetDeliveryAlerts turns a user's stuck delivery emails on or off (on
+	// for new users); ErrNotFound if there is no such user
+	SetDeliveryAlerts(ctx context.Context, email string, enabled bool) error
 }
 
-// EventRepository defines the interface for event data access
+// EventRepository defines the interface for event data access.
+// Event data is stored and returned as-is; encryption is the caller's concern.
 type EventRepository interface {
 	Create(ctx context.Context, event *models.Event) error
 	GetByID(ctx context.Context, eventID uuid.UUID) (*models.Event, error)
 	GetUnprocessed(ctx context.Context, webhookKeyID uuid.UUID) ([]models.Event, error)
 	GetByWebhookKey(ctx context.Context, webhookKeyID uuid.UUID, limit int) ([]models.Event, error)
-	MarkAsProcessed(ctx context.Context, eventID uuid.UUID) error
+	GetScheduledDue(ctx context.Context, from, to time.Time) ([]models.Event, error)
+	// ListAfter returns up to limit events with an ID greater than afterID in
+	// ID order, so background jobs can walk the table in pages
+	ListAfter(ctx context.Context, afterID uuid.UUID, limit int) ([]models.Event, error)
+	// UpdateData replaces an event's stored data (used for re-encryption)
+	UpdateData(ctx context.Context, eventID uuid.UUID, data []byte) error
+	// ReferencedBlobs reports which of refs are still the blob_ref of some event
+	ReferencedBlobs(ctx context.Context, refs []string) (map[string]bool, error)
+	// MarkAsProcessed sets processed_at to now and expires_at to now + processedTTL
+	MarkAsProcessed(ctx context.Context, eventID uuid.UUID, processedTTL time.Duration) error
 	Delete(ctx context.Context, eventID uuid.UUID) error
 	DeleteExpired(ctx context.Context) (int64, error)
+	// DeleteExpiredBatch deletes at most limit expired events so large backlogs
+	// can be removed without holding long locks
+	DeleteExpiredBatch(ctx context.Context, limit int) (int64, error)
+	// DeleteExpiredByKeyBatch is DeleteExpiredBatch limited to one key's events
+	DeleteExpiredByKeyBatch(ctx context.Context, webhookKeyID uuid.UUID, limit int) (int64, error)
+	// ApplyRetention recomputes expires_at for all of a key's events: unprocessed
+	// events from COALESCE(deliver_after, created_at), processed ones from processed_at
+	ApplyRetention(ctx context.Context, webhookKeyID uuid.UUID, unprocessedTTL, processedTTL time.Duration) (int64, error)
+
+	// Counters
+	CountUndelivered(ctx context.Context, olderThan time.Duration) (int, error)
+	CountByWebhookKey(ctx context.Context, webhookKeyID uuid.UUID) (int, error)
+	CountByUserEmail(ctx context.Context, userEmail string) (int, error)
+	// ListStuckDeliveries returns the active webhook keys with unprocessed
+	// events already due, either the oldest due before dueBefore or at least
+	// minCount of them (0 = age only), oldest first
+	ListStuckDeliveries(ctx context.Context, dueBefore time.Time, minCount int) ([]models.StuckDelivery, error)
+	// StorageStats sums recorded payload and stored sizes over all events;
+	// CompressionRatio is left for the caller
+	StorageStats(ctx context.Context) (*models.EventStorageStats, error)
+}
+
+// WebhookLogRepository defines the interface for webhook delivery log access
+type WebhookLogRepository interface {
+	Create(ctx context.Context, eventID, webhookKeyID uuid.UUID, statusCode int) error
+	MarkDelivered(ctx context.Context, eventID, webhookKeyID, clientKeyID uuid.UUID) error
+	MarkAcked(ctx context.Context, eventID uuid.UUID) error
+	GetLatestStatus(ctx context.Context, eventID uuid.UUID) (string, error)
+	CountByUserEmail(ctx context.Context, userEmail string) (int, error)
+	ListByUserEmail(ctx context.Context, userEmail string, limit, offset int) ([]models.WebhookLog, error)
+	// DeleteOrphaned deletes up to limit entries whose event or webhook key no longer exists
+	DeleteOrphaned(ctx context.Context, limit int) (int64, error)
 }
 
 // AdminRepository defines the interface for admin data access
 type AdminRepository interface {
 	Create(ctx context.Context, admin *models.AdminUser) error
 	GetByUsername(ctx context.Context, username string) (*models.AdminUser, error)
+	GetByID(ctx context.Context, adminID uuid.UUID) (*models.AdminUser, error)
 	UpdateLastLogin(ctx context.Context, adminID uuid.UUID) error
+	Count(ctx context.Context) (int, error)
+	// List returns every admin user, oldest first
+	List(ctx context.Context) ([]models.AdminUser, error)
+
+	// GetByInviteTokenHash returns the admin whose pending invite has tokenHash, expired or not
+	GetByInviteTokenHash(ctx context.Context, tokenHash string) (*models.AdminUser, error)
+	// AcceptInvite sets an invited admin's password and clears the invite;
+	// ErrNotFound if no invite is pending
+	AcceptInvite(ctx context.Context, adminID uuid.UUID, passwordHash string) error
+	// UpdateRole changes an admin's role; ErrNotFound if the admin is missing
+	UpdateRole(ctx context.Context, adminID uuid.UUID, role models.AdminRole) error
+	// SetActive activates or deactivates an admin; ErrNotFound if the admin is missing
+	SetActive(ctx context.Context, adminID uuid.UUID, active bool) error
+
+	// SetTOTPSecret starts a TOTP enrollment: it stores a secret not yet
+	// enabled and drops any recovery codes
+	SetTOTPSecret(ctx context.Context, adminID uuid.UUID, secret []byte) error
+	// EnableTOTP confirms the stored secret, keeping an earlier confirmation
+	// time, and replaces the recovery codes; ErrNotFound without a secret
+	EnableTOTP(ctx context.Context, adminID uuid.UUID, recoveryCodeHashes []string) error
+	// DisableTOTP removes the secret and recovery codes
+	DisableTOTP(ctx context.Context, adminID uuid.UUID) error
+	// UseRecoveryCode marks an unused recovery code used; ErrNotFound if there
+	// is no such unused code
+	UseRecoveryCode(ctx context.Context, adminID uuid.UUID, codeHash string) error
+	// UseTOTPStep records step as the last TOTP time step the admin used;
+	// ErrNotFound if it is not later than the one recorded before
+	UseTOTPStep(ctx context.Context, adminID uuid.UUID, step int64) error
+}
+
+// AuthTokenRepository defines the interface for magic link token storage.
+// A user has at most one magic link token at a time.
+type AuthTokenRepository interface {
+	StoreMagicLinkToken(ctx context.Context, userEmail, token string, expiresAt time.Time) error
+	GetMagicLinkToken(ctx context.Context, token string) (*models.MagicLinkToken, error)
+	MarkMagicLinkTokenUsed(ctx context.Context, token string) error
+	// ClearExpiredMagicLinkTokens clears up to limit expired tokens
+	ClearExpiredMagicLinkTokens(ctx context.Context, limit int) (int64, error)
+}
+
+// DataKeyRepository stores each key pair's wrapped data encryption key
+type DataKeyRepository interface {
+	Get(ctx context.Context, webhookKeyID uuid.UUID) (*models.DataKey, error)
+	// Create stores a data key unless the pair already has one; concurrent
+	// callers should re-read with Get to use whichever key won
+	Create(ctx context.Context, key *models.DataKey) error
+	Delete(ctx context.Context, webhookKeyID uuid.UUID) error
+	// ListAfter returns up to limit keys with a webhook key ID greater than afterID, in ID order
+	ListAfter(ctx context.Context, afterID uuid.UUID, limit int) ([]models.DataKey, error)
+	// UpdateWrappedKey replaces the wrapping of the pair's key if it is still keyID
+	UpdateWrappedKey(ctx context.Context, webhookKeyID uuid.UUID, keyID string, wrappedKey []byte) error
+}
+
+// APITokenRepository stores personal access tokens by hash
+type APITokenRepository interface {
+	Create(ctx context.Context, token *models.APIToken) error
+	// GetByHash returns the token with tokenHash, including revoked and expired ones
+	GetByHash(ctx context.Context, tokenHash string) (*models.APIToken, error)
+	// ListByUser returns a user's tokens, newest first
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]models.APIToken, error)
+	// Revoke marks a user's token revoked; ErrNotFound if it is not theirs or already revoked
+	Revoke(ctx context.Context, tokenID, userID uuid.UUID) error
+	TouchLastUsed(ctx context.Context, tokenID uuid.UUID, at time.Time) error
+	// DeleteExpired deletes up to limit tokens that expired or were revoked before cutoff
+	DeleteExpired(ctx context.Context, cutoff time.Time, limit int) (int64, error)
+}
+
+// SessionRepository stores server-side sessions for dashboard users and admins
+type SessionRepository interface {
+	Create(ctx context.Context, session *models.Session) error
+	// Get returns a session, including revoked and expired ones
+	Get(ctx context.Context, id uuid.UUID) (*models.Session, error)
+	// ListActiveByUser returns a user's unrevoked, unexpired sessions, most recently seen first
+	ListActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.Session, error)
+	TouchLastSeen(ctx context.Context, id uuid.UUID, at time.Time) error
+	// Revoke marks a session revoked; ErrNotFound if it is missing or already revoked
+	Revoke(ctx context.Context, id uuid.UUID) error
+	// RevokeUserSession revokes one of a user's sessions; ErrNotFound if it is not theirs or already revoked
+	RevokeUserSession(ctx context.Context, id, userID uuid.UUID) error
+	// RevokeAllByUser revokes every active session of a user and returns how many there were
+	RevokeAllByUser(ctx context.Context, userID uuid.UUID) (int64, error)
+	// RevokeAllByAdmin revokes every active session of an admin and returns how many there were
+	RevokeAllByAdmin(ctx context.Context, adminID uuid.UUID) (int64, error)
+	// DeleteExpired deletes up to limit sessions that expired or were revoked before cutoff
+	DeleteExpired(ctx context.Context, cutoff time.Time, limit int) (int64, error)
+}
+
+// AuditRepository stores the hash-chained audit log; events are never
+// updated or deleted
+type AuditRepository interface {
+	// Append adds event to the end of the chain. It sets Seq and PrevHash from
+	// the last event, then calls seal to compute Hash before inserting, holding
+	// the chain throughout so that concurrent appends cannot fork it.
+	Append(ctx context.Context, event *models.AuditEvent, seal func(*models.AuditEvent)) error
+	// List returns events matching filter, newest first
+	List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+	// ListAfter returns up to limit events with a Seq greater than afterSeq, in chain order
+	ListAfter(ctx context.Context, afterSeq int64, limit int) ([]models.AuditEvent, error)
 }
 
-// KeyInfo represents basic key information for responses
-type KeyInfo struct {
-	ID       string
-	KeyValue string
-	KeyType  string
-	IsActive bool
+// Repositories groups one implementation of every repository for a storage backend
+type Repositories struct {
+	Keys        KeyRepository
+	Users       UserRepository
+	Events      EventRepository
+	WebhookLogs WebhookLogRepository
+	Admins      AdminRepository
+	AuthTokens  AuthTokenRepository
+	DataKeys    DataKeyRepository
+	APITokens   APITokenRepository
+	Sessions    SessionRepository
+	Audit       AuditRepository
 }
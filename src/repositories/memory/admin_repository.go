@@ -0,0 +1,246 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// AdminRepository is an in-memory repositories.AdminRepository
+type AdminRepository struct {
+	store *Store
+}
+
+// Create inserts a new admin user
+func (r *AdminRepository) Create(ctx context.Context, admin *models.AdminUser) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, a := range r.store.admins {
+		if a.Username == admin.Username {
+			return fmt.Errorf("duplicate username: %s", admin.Username)
+		}
+	}
+	if admin.ID == uuid.Nil {
+		admin.ID = uuid.New()
+	}
+	stored := *admin
+	r.store.admins[admin.ID] = &stored
+	return nil
+}
+
+// GetByUsername retrieves an admin user by username, active or not
+func (r *AdminRepository) GetByUsername(ctx context.Context, username string) (*models.AdminUser, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	for _, a := range r.store.admins {
+		if a.Username == username {
+			return r.project(a), nil
+		}
+	}
+	return nil, repositories.ErrNotFound
+}
+
+// GetByID retrieves an admin user by ID, active or not
+func (r *AdminRepository) GetByID(ctx context.Context, adminID uuid.UUID) (*models.AdminUser, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	a, ok := r.store.admins[adminID]
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+	return r.project(a), nil
+}
+
+// project copies a stored admin and counts its unused recovery codes; the
+// caller holds the lock
+func (r *AdminRepository) project(a *models.AdminUser) *models.AdminUser {
+	admin := *a
+	admin.TOTPSecret = append([]byte(nil), a.TOTPSecret...)
+	admin.RecoveryCodesLeft = 0
+	for _, used := range r.store.recoveryCodes[a.ID] {
+		if !used {
+			admin.RecoveryCodesLeft++
+		}
+	}
+	return &admin
+}
+
+// UpdateLastLogin sets last_login to now
+func (r *AdminRepository) UpdateLastLogin(ctx context.Context, adminID uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if a, ok := r.store.admins[adminID]; ok {
+		a.LastLogin = timePtr(time.Now())
+	}
+	return nil
+}
+
+// Count returns the number of admin users
+func (r *AdminRepository) Count(ctx context.Context) (int, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	return len(r.store.admins), nil
+}
+
+// List returns every admin user, oldest first
+func (r *AdminRepository) List(ctx context.Context) ([]models.AdminUser, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	admins := make([]models.AdminUser, 0, len(r.store.admins))
+	for _, a := range r.store.admins {
+		admins = append(admins, *r.project(a))
+	}
+	sort.Slice(admins, func(i, j int) bool {
+		if !admins[i].CreatedAt.Equal(admins[j].CreatedAt) {
+			return admins[i].CreatedAt.Before(admins[j].CreatedAt)
+		}
+		return admins[i].Username < admins[j].Username
+	})
+	return admins, nil
+}
+
+// GetByInviteTokenHash returns the admin whose pending invite has tokenHash
+func (r *AdminRepository) GetByInviteTokenHash(ctx context.Context, tokenHash string) (*models.AdminUser, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	for _, a := range r.store.admins {
+		if tokenHash != "" && a.InviteTokenHash == tokenHash {
+			return r.project(a), nil
+		}
+	}
+	return nil, repositories.ErrNotFound
+}
+
+// AcceptInvite sets an invited admin's password and clears the invite
+func (r *AdminRepository) AcceptInvite(ctx context.Context, adminID uuid.UUID, passwordHash string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	a, ok := r.store.admins[adminID]
+	if !ok || !a.InvitePending() {
+		return repositories.ErrNotFound
+	}
+	a.PasswordHash = passwordHash
+	a.InviteTokenHash = ""
+	a.InviteExpiresAt = nil
+	return nil
+}
+
+// UpdateRole changes an admin's role
+func (r *AdminRepository) UpdateRole(ctx context.Context, adminID uuid.UUID, role models.AdminRole) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	a, ok := r.store.admins[adminID]
+	if !ok {
+		return repositories.ErrNotFound
+	}
+	a.Role = role
+	return nil
+}
+
+// SetActive activates or deactivates an admin
+func (r *AdminRepository) SetActive(ctx context.Context, adminID uuid.UUID, active bool) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	a, ok := r.store.admins[adminID]
+	if !ok {
+		return repositories.ErrNotFound
+	}
+	a.IsActive = active
+	return nil
+}
+
+// SetTOTPSecret stores a secret awaiting confirmation and drops recovery codes
+func (r *AdminRepository) SetTOTPSecret(ctx context.Context, adminID uuid.UUID, secret []byte) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	a, ok := r.store.admins[adminID]
+	if !ok {
+		return repositories.ErrNotFound
+	}
+	a.TOTPSecret = append([]byte(nil), secret...)
+	a.TOTPEnabledAt = nil
+	delete(r.store.recoveryCodes, adminID)
+	delete(r.store.totpSteps, adminID)
+	return nil
+}
+
+// EnableTOTP confirms the stored secret and replaces the recovery codes
+func (r *AdminRepository) EnableTOTP(ctx context.Context, adminID uuid.UUID, recoveryCodeHashes []string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	a, ok := r.store.admins[adminID]
+	if !ok || a.TOTPSecret == nil {
+		return repositories.ErrNotFound
+	}
+	if a.TOTPEnabledAt == nil {
+		a.TOTPEnabledAt = timePtr(time.Now())
+	}
+	codes := make(map[string]bool, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		codes[hash] = false
+	}
+	r.store.recoveryCodes[adminID] = codes
+	return nil
+}
+
+// DisableTOTP removes the secret and recovery codes
+func (r *AdminRepository) DisableTOTP(ctx context.Context, adminID uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	a, ok := r.store.admins[adminID]
+	if !ok {
+		return repositories.ErrNotFound
+	}
+	a.TOTPSecret = nil
+	a.TOTPEnabledAt = nil
+	delete(r.store.recoveryCodes, adminID)
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code used
+func (r *AdminRepository) UseRecoveryCode(ctx context.Context, adminID uuid.UUID, codeHash string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	used, ok := r.store.recoveryCodes[adminID][codeHash]
+	if !ok || used {
+		return repositories.ErrNotFound
+	}
+	r.store.recoveryCodes[adminID][codeHash] = true
+	return nil
+}
+
+// UseTOTPStep records the last TOTP time step the admin used
+func (r *AdminRepository) UseTOTPStep(ctx context.Context, adminID uuid.UUID, step int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.admins[adminID]; !ok {
+		return repositories.ErrNotFound
+	}
+	if last, ok := r.store.totpSteps[adminID]; ok && step <= last {
+		return repositories.ErrNotFound
+	}
+	r.store.totpSteps[adminID] = step
+	return nil
+}
+
+var _ repositories.AdminRepository = (*AdminRepository)(nil)
@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// apiTokenRow is a stored token and its insertion order
+type apiTokenRow struct {
+	seq   int64
+	token models.APIToken
+}
+
+// APITokenRepository is an in-memory repositories.APITokenRepository
+type APITokenRepository struct {
+	store *Store
+}
+
+// copyAPIToken returns a deep copy so callers never share the stored scopes
+func copyAPIToken(t *models.APIToken) models.APIToken {
+	c := *t
+	c.Scopes = append([]string(nil), t.Scopes...)
+	return c
+}
+
+// withOwner returns a copy of t carrying its owner's current email, and false
+// if the owner is gone, as the SQL backends' join on users does
+func (r *APITokenRepository) withOwner(t *models.APIToken) (models.APIToken, bool) {
+	u, ok := r.store.users[t.UserID]
+	if !ok {
+		return models.APIToken{}, false
+	}
+	c := copyAPIToken(t)
+	c.UserEmail = u.profile.Email
+	return c, true
+}
+
+// Create stores a new token
+func (r *APITokenRepository) Create(ctx context.Context, token *models.APIToken) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, t := range r.store.apiTokens {
+		if t.token.ID == token.ID || t.token.TokenHash == token.TokenHash {
+			return fmt.Errorf("duplicate api token: %s", token.ID)
+		}
+	}
+	r.store.apiTokens[token.ID] = &apiTokenRow{seq: r.store.nextSeq(), token: copyAPIToken(token)}
+	return nil
+}
+
+// GetByHash looks up a token by the hash of its value
+func (r *APITokenRepository) GetByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	for _, t := range r.store.apiTokens {
+		if t.token.TokenHash == tokenHash {
+			c, ok := r.withOwner(&t.token)
+			if !ok {
+				break
+			}
+			return &c, nil
+		}
+	}
+	return nil, repositories.ErrNotFound
+}
+
+// ListByUser returns a user's tokens, newest first
+func (r *APITokenRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.APIToken, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var rows []*apiTokenRow
+	for _, t := range r.store.apiTokens {
+		if t.token.UserID == userID {
+			rows = append(rows, t)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].seq > rows[j].seq })
+
+	tokens := make([]models.APIToken, 0, len(rows))
+	for _, t := range rows {
+		if c, ok := r.withOwner(&t.token); ok {
+			tokens = append(tokens, c)
+		}
+	}
+	return tokens, nil
+}
+
+// Revoke marks one of a user's tokens revoked
+func (r *APITokenRepository) Revoke(ctx context.Context, tokenID, userID uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t, ok := r.store.apiTokens[tokenID]
+	if !ok || t.token.UserID != userID || t.token.RevokedAt != nil {
+		return repositories.ErrNotFound
+	}
+	t.token.RevokedAt = timePtr(time.Now())
+	return nil
+}
+
+// TouchLastUsed records when a token was last used
+func (r *APITokenRepository) TouchLastUsed(ctx context.Context, tokenID uuid.UUID, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t, ok := r.store.apiTokens[tokenID]
+	if !ok {
+		return repositories.ErrNotFound
+	}
+	t.token.LastUsedAt = timePtr(at)
+	return nil
+}
+
+// DeleteExpired deletes up to limit tokens that expired or were revoked before cutoff
+func (r *APITokenRepository) DeleteExpired(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var deleted int64
+	for id, t := range r.store.apiTokens {
+		if deleted >= int64(limit) {
+			break
+		}
+		expired := t.token.ExpiresAt != nil && t.token.ExpiresAt.Before(cutoff)
+		revoked := t.token.RevokedAt != nil && t.token.RevokedAt.Before(cutoff)
+		if expired || revoked {
+			delete(r.store.apiTokens, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
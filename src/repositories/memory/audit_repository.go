@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"strings"
+
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// AuditRepository is an in-memory repositories.AuditRepository
+type AuditRepository struct {
+	store *Store
+}
+
+// Append adds an event to the end of the chain under the store lock
+func (r *AuditRepository) Append(ctx context.Context, event *models.AuditEvent, seal func(*models.AuditEvent)) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	event.Seq = int64(len(r.store.audit)) + 1
+	event.PrevHash = ""
+	if n := len(r.store.audit); n > 0 {
+		event.PrevHash = r.store.audit[n-1].Hash
+	}
+	seal(event)
+	r.store.audit = append(r.store.audit, copyAuditEvent(event))
+	return nil
+}
+
+// List returns events matching filter, newest first
+func (r *AuditRepository) List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var events []models.AuditEvent
+	for i := len(r.store.audit) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		if e := &r.store.audit[i]; auditMatches(e, filter) {
+			events = append(events, copyAuditEvent(e))
+		}
+	}
+	return events, nil
+}
+
+// ListAfter returns up to limit events after afterSeq, in chain order
+func (r *AuditRepository) ListAfter(ctx context.Context, afterSeq int64, limit int) ([]models.AuditEvent, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var events []models.AuditEvent
+	for i := range r.store.audit {
+		if len(events) >= limit {
+			break
+		}
+		if e := &r.store.audit[i]; e.Seq > afterSeq {
+			events = append(events, copyAuditEvent(e))
+		}
+	}
+	return events, nil
+}
+
+// auditMatches reports whether an event passes every set field of filter
+func auditMatches(e *models.AuditEvent, filter models.AuditFilter) bool {
+	switch {
+	case filter.ActorID != "" && e.ActorID != filter.ActorID:
+		return false
+	case strings.HasSuffix(filter.Action, "."):
+		if !strings.HasPrefix(e.Action, filter.Action) {
+			return false
+		}
+	case filter.Action != "" && e.Action != filter.Action:
+		return false
+	}
+	switch {
+	case filter.TargetID != "" && e.TargetID != filter.TargetID:
+		return false
+	case filter.UserEmail != "" && e.UserEmail != filter.UserEmail:
+		return false
+	case filter.Since != nil && e.CreatedAt.Before(*filter.Since):
+		return false
+	case filter.Until != nil && !e.CreatedAt.Before(*filter.Until):
+		return false
+	case filter.BeforeSeq > 0 && e.Seq >= filter.BeforeSeq:
+		return false
+	}
+	return true
+}
+
+// copyAuditEvent returns a copy of the event that does not alias stored data
+func copyAuditEvent(e *models.AuditEvent) models.AuditEvent {
+	c := *e
+	c.Before = append([]byte(nil), e.Before...)
+	c.After = append([]byte(nil), e.After...)
+	return c
+}
+
+var _ repositories.AuditRepository = (*AuditRepository)(nil)
@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// AuthTokenRepository is an in-memory repositories.AuthTokenRepository
+type AuthTokenRepository struct {
+	store *Store
+}
+
+// StoreMagicLinkToken replaces the user's magic link token
+func (r *AuthTokenRepository) StoreMagicLinkToken(ctx context.Context, userEmail, token string, expiresAt time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	u := r.store.userByEmail(userEmail)
+	if u == nil {
+		return repositories.ErrNotFound
+	}
+	u.MagicLinkToken = token
+	u.MagicLinkExpiresAt = timePtr(expiresAt)
+	u.MagicLinkUsedAt = nil
+	return nil
+}
+
+// GetMagicLinkToken looks up a magic link token
+func (r *AuthTokenRepository) GetMagicLinkToken(ctx context.Context, token string) (*models.MagicLinkToken, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	for _, u := range r.store.users {
+		if token != "" && u.MagicLinkToken == token {
+			return &models.MagicLinkToken{
+				Token:     token,
+				Email:     u.profile.Email,
+				ExpiresAt: u.MagicLinkExpiresAt,
+				UsedAt:    u.MagicLinkUsedAt,
+			}, nil
+		}
+	}
+	return nil, repositories.ErrNotFound
+}
+
+// MarkMagicLinkTokenUsed records that a token has been consumed
+func (r *AuthTokenRepository) MarkMagicLinkTokenUsed(ctx context.Context, token string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	now := time.Now()
+	for _, u := range r.store.users {
+		if token != "" && u.MagicLinkToken == token {
+			u.MagicLinkUsedAt = &now
+		}
+	}
+	return nil
+}
+
+// ClearExpiredMagicLinkTokens clears up to limit expired magic link tokens
+func (r *AuthTokenRepository) ClearExpiredMagicLinkTokens(ctx context.Context, limit int) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	now := time.Now()
+	var cleared int64
+	for _, u := range r.store.sortedUsers() {
+		if cleared >= int64(limit) {
+			break
+		}
+		if u.MagicLinkToken == "" || u.MagicLinkExpiresAt == nil || !u.MagicLinkExpiresAt.Before(now) {
+			continue
+		}
+		u.MagicLinkToken = ""
+		u.MagicLinkExpiresAt = nil
+		u.MagicLinkUsedAt = nil
+		cleared++
+	}
+	return cleared, nil
+}
+
+var _ repositories.AuthTokenRepository = (*AuthTokenRepository)(nil)
@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// DataKeyRepository is an in-memory repositories.DataKeyRepository
+type DataKeyRepository struct {
+	store *Store
+}
+
+// copyDataKey returns a copy that does not alias the stored wrapped key
+func copyDataKey(k *models.DataKey) models.DataKey {
+	c := *k
+	c.WrappedKey = append([]byte(nil), k.WrappedKey...)
+	return c
+}
+
+// Get retrieves a key pair's data key
+func (r *DataKeyRepository) Get(ctx context.Context, webhookKeyID uuid.UUID) (*models.DataKey, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	k, ok := r.store.dataKeys[webhookKeyID]
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+	key := copyDataKey(k)
+	return &key, nil
+}
+
+// Create stores a data key unless the pair already has one
+func (r *DataKeyRepository) Create(ctx context.Context, key *models.DataKey) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.keys[key.WebhookKeyID]; !ok {
+		return fmt.Errorf("webhook key %s does not exist", key.WebhookKeyID)
+	}
+	if _, ok := r.store.dataKeys[key.WebhookKeyID]; ok {
+		return nil
+	}
+	for _, k := range r.store.dataKeys {
+		if k.KeyID == key.KeyID {
+			return fmt.Errorf("duplicate data key id: %s", key.KeyID)
+		}
+	}
+	stored := copyDataKey(key)
+	stored.UpdatedAt = stored.CreatedAt
+	r.store.dataKeys[key.WebhookKeyID] = &stored
+	return nil
+}
+
+// Delete removes a key pair's data key
+func (r *DataKeyRepository) Delete(ctx context.Context, webhookKeyID uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.dataKeys[webhookKeyID]; !ok {
+		return repositories.ErrNotFound
+	}
+	delete(r.store.dataKeys, webhookKeyID)
+	return nil
+}
+
+// ListAfter returns up to limit data keys after afterID in webhook key ID order
+func (r *DataKeyRepository) ListAfter(ctx context.Context, afterID uuid.UUID, limit int) ([]models.DataKey, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	after := afterID.String()
+	var keys []models.DataKey
+	for id, k := range r.store.dataKeys {
+		if id.String() > after {
+			keys = append(keys, copyDataKey(k))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].WebhookKeyID.String() < keys[j].WebhookKeyID.String() })
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// UpdateWrappedKey replaces the wrapping of a data key that is still keyID
+func (r *DataKeyRepository) UpdateWrappedKey(ctx context.Context, webhookKeyID uuid.UUID, keyID string, wrappedKey []byte) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.dataKeys[webhookKeyID]
+	if !ok || k.KeyID != keyID {
+		return repositories.ErrNotFound
+	}
+	k.WrappedKey = append([]byte(nil), wrappedKey...)
+	k.UpdatedAt = time.Now()
+	return nil
+}
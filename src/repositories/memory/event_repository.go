@@ -0,0 +1,384 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// EventRepository is an in-memory repositories.EventRepository
+type EventRepository struct {
+	store *Store
+}
+
+// collect returns copies of matching events ordered by less (caller holds the lock)
+func (r *EventRepository) collect(match func(*models.Event) bool, less func(a, b *eventRow) bool) []models.Event {
+	var rows []*eventRow
+	for _, e := range r.store.events {
+		if match(&e.event) {
+			rows = append(rows, e)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+
+	var events []models.Event
+	for _, e := range rows {
+		events = append(events, copyEvent(&e.event))
+	}
+	return events
+}
+
+// Create inserts a new event
+func (r *EventRepository) Create(ctx context.Context, event *models.Event) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.keys[event.WebhookKeyID]; !ok {
+		return fmt.Errorf("webhook key %s does not exist", event.WebhookKeyID)
+	}
+	if _, ok := r.store.events[event.ID]; ok {
+		return fmt.Errorf("duplicate event id: %s", event.ID)
+	}
+	r.store.events[event.ID] = &eventRow{seq: r.store.nextSeq(), event: copyEvent(event)}
+	return nil
+}
+
+// GetByID retrieves an event by ID
+func (r *EventRepository) GetByID(ctx context.Context, eventID uuid.UUID) (*models.Event, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	e, ok := r.store.events[eventID]
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+	event := copyEvent(&e.event)
+	return &event, nil
+}
+
+// deliveryTime is COALESCE(deliver_after, created_at)
+func deliveryTime(e *models.Event) time.Time {
+	if e.DeliverAfter != nil {
+		return *e.DeliverAfter
+	}
+	return e.CreatedAt
+}
+
+// GetUnprocessed returns due, unprocessed events for a webhook key in delivery order
+func (r *EventRepository) GetUnprocessed(ctx context.Context, webhookKeyID uuid.UUID) ([]models.Event, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	now := time.Now()
+	return r.collect(
+		func(e *models.Event) bool {
+			return e.WebhookKeyID == webhookKeyID && !e.Processed && (e.DeliverAfter == nil || !e.DeliverAfter.After(now))
+		},
+		func(a, b *eventRow) bool {
+			ta, tb := deliveryTime(&a.event), deliveryTime(&b.event)
+			if !ta.Equal(tb) {
+				return ta.Before(tb)
+			}
+			return a.seq < b.seq
+		},
+	), nil
+}
+
+// GetByWebhookKey returns the newest events for a webhook key
+func (r *EventRepository) GetByWebhookKey(ctx context.Context, webhookKeyID uuid.UUID, limit int) ([]models.Event, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	events := r.collect(
+		func(e *models.Event) bool { return e.WebhookKeyID == webhookKeyID },
+		func(a, b *eventRow) bool {
+			if !a.event.CreatedAt.Equal(b.event.CreatedAt) {
+				return a.event.CreatedAt.After(b.event.CreatedAt)
+			}
+			return a.seq > b.seq
+		},
+	)
+	if limit >= 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// GetScheduledDue returns unprocessed events whose deliver_after falls in (from, to]
+func (r *EventRepository) GetScheduledDue(ctx context.Context, from, to time.Time) ([]models.Event, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	return r.collect(
+		func(e *models.Event) bool {
+			return !e.Processed && e.DeliverAfter != nil && e.DeliverAfter.After(from) && !e.DeliverAfter.After(to)
+		},
+		func(a, b *eventRow) bool { return a.event.DeliverAfter.Before(*b.event.DeliverAfter) },
+	), nil
+}
+
+// ListAfter returns up to limit events with an ID greater than afterID in ID order
+func (r *EventRepository) ListAfter(ctx context.Context, afterID uuid.UUID, limit int) ([]models.Event, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	after := afterID.String()
+	events := r.collect(
+		func(e *models.Event) bool { return e.ID.String() > after },
+		func(a, b *eventRow) bool { return a.event.ID.String() < b.event.ID.String() },
+	)
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// UpdateData replaces an event's stored data
+func (r *EventRepository) UpdateData(ctx context.Context, eventID uuid.UUID, data []byte) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	e, ok := r.store.events[eventID]
+	if !ok {
+		return repositories.ErrNotFound
+	}
+	e.event.Data = append([]byte(nil), data...)
+	e.event.StoredSize = int64(len(data))
+	return nil
+}
+
+// ReferencedBlobs reports which of refs are referenced by an event
+func (r *EventRepository) ReferencedBlobs(ctx context.Context, refs []string) (map[string]bool, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		wanted[ref] = true
+	}
+	referenced := make(map[string]bool)
+	for _, e := range r.store.events {
+		if e.event.BlobRef != "" && wanted[e.event.BlobRef] {
+			referenced[e.event.BlobRef] = true
+		}
+	}
+	return referenced, nil
+}
+
+// MarkAsProcessed marks an event as processed and restarts its expiry from now
+func (r *EventRepository) MarkAsProcessed(ctx context.Context, eventID uuid.UUID, processedTTL time.Duration) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	e, ok := r.store.events[eventID]
+	if !ok {
+		return repositories.ErrNotFound
+	}
+	e.event.MarkProcessed()
+	e.event.ExpiresAt = e.event.ProcessedAt.Add(processedTTL)
+	return nil
+}
+
+// ApplyRetention recomputes expires_at for all of a webhook key's events
+func (r *EventRepository) ApplyRetention(ctx context.Context, webhookKeyID uuid.UUID, unprocessedTTL, processedTTL time.Duration) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var updated int64
+	for _, e := range r.store.events {
+		ev := &e.event
+		if ev.WebhookKeyID != webhookKeyID {
+			continue
+		}
+		switch {
+		case ev.Processed && ev.ProcessedAt != nil:
+			ev.ExpiresAt = ev.ProcessedAt.Add(processedTTL)
+		case ev.DeliverAfter != nil:
+			ev.ExpiresAt = ev.DeliverAfter.Add(unprocessedTTL)
+		default:
+			ev.ExpiresAt = ev.CreatedAt.Add(unprocessedTTL)
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// Delete deletes an event
+func (r *EventRepository) Delete(ctx context.Context, eventID uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.events[eventID]; !ok {
+		return repositories.ErrNotFound
+	}
+	r.store.deleteEvent(eventID)
+	return nil
+}
+
+// DeleteExpired deletes events past their expires_at
+func (r *EventRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	now := time.Now()
+	var deleted int64
+	for id, e := range r.store.events {
+		if e.event.ExpiresAt.Before(now) {
+			r.store.deleteEvent(id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteExpiredBatch deletes at most limit events past their expires_at
+func (r *EventRepository) DeleteExpiredBatch(ctx context.Context, limit int) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	now := time.Now()
+	var deleted int64
+	for id, e := range r.store.events {
+		if deleted >= int64(limit) {
+			break
+		}
+		if e.event.ExpiresAt.Before(now) {
+			r.store.deleteEvent(id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteExpiredByKeyBatch deletes at most limit of a webhook key's expired events
+func (r *EventRepository) DeleteExpiredByKeyBatch(ctx context.Context, webhookKeyID uuid.UUID, limit int) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	now := time.Now()
+	var deleted int64
+	for id, e := range r.store.events {
+		if deleted >= int64(limit) {
+			break
+		}
+		if e.event.WebhookKeyID == webhookKeyID && e.event.ExpiresAt.Before(now) {
+			r.store.deleteEvent(id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// count returns the number of events matching filter
+func (r *EventRepository) count(match func(*models.Event) bool) int {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	count := 0
+	for _, e := range r.store.events {
+		if match(&e.event) {
+			count++
+		}
+	}
+	return count
+}
+
+// CountUndelivered counts unprocessed events older than the given duration
+func (r *EventRepository) CountUndelivered(ctx context.Context, olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+	return r.count(func(e *models.Event) bool { return !e.Processed && e.CreatedAt.Before(cutoff) }), nil
+}
+
+// ListStuckDeliveries returns active webhook keys whose due, unprocessed
+// events are old or many enough, oldest first
+func (r *EventRepository) ListStuckDeliveries(ctx context.Context, dueBefore time.Time, minCount int) ([]models.StuckDelivery, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	now := time.Now()
+	byKey := make(map[uuid.UUID]*models.StuckDelivery)
+	for _, row := range r.store.events {
+		e := &row.event
+		due := e.CreatedAt
+		if e.DeliverAfter != nil {
+			due = *e.DeliverAfter
+		}
+		if e.Processed || due.After(now) {
+			continue
+		}
+		k, ok := r.store.keys[e.WebhookKeyID]
+		if !ok || !k.IsActive || k.KeyType != models.KeyTypeWebhook {
+			continue
+		}
+		stuck := byKey[k.ID]
+		if stuck == nil {
+			stuck = &models.StuckDelivery{WebhookKeyID: k.ID, KeyPrefix: k.KeyPrefix, KeyName: k.Name, OldestDueAt: due}
+			if k.DeliveryAlertedAt != nil {
+				stuck.AlertedAt = timePtr(*k.DeliveryAlertedAt)
+			}
+			if k.UserID != nil {
+				if u, ok := r.store.users[*k.UserID]; ok {
+					stuck.UserEmail, stuck.AlertsEnabled = u.profile.Email, u.profile.DeliveryAlerts
+				}
+			}
+			byKey[k.ID] = stuck
+		}
+		stuck.Undelivered++
+		if due.Before(stuck.OldestDueAt) {
+			stuck.OldestDueAt = due
+		}
+	}
+
+	var stuck []models.StuckDelivery
+	for _, s := range byKey {
+		if !s.OldestDueAt.After(dueBefore) || (minCount > 0 && s.Undelivered >= minCount) {
+			stuck = append(stuck, *s)
+		}
+	}
+	sort.Slice(stuck, func(i, j int) bool {
+		if !stuck[i].OldestDueAt.Equal(stuck[j].OldestDueAt) {
+			return stuck[i].OldestDueAt.Before(stuck[j].OldestDueAt)
+		}
+		return stuck[i].WebhookKeyID.String() < stuck[j].WebhookKeyID.String()
+	})
+	return stuck, nil
+}
+
+// CountByWebhookKey counts all events for a webhook key
+func (r *EventRepository) CountByWebhookKey(ctx context.Context, webhookKeyID uuid.UUID) (int, error) {
+	return r.count(func(e *models.Event) bool { return e.WebhookKeyID == webhookKeyID }), nil
+}
+
+// CountByUserEmail counts events across all of a user's webhook keys
+func (r *EventRepository) CountByUserEmail(ctx context.Context, userEmail string) (int, error) {
+	r.store.mu.RLock()
+	ids := r.store.userWebhookKeyIDs(userEmail)
+	r.store.mu.RUnlock()
+
+	return r.count(func(e *models.Event) bool { return ids[e.WebhookKeyID] }), nil
+}
+
+var _ repositories.EventRepository = (*EventRepository)(nil)
+
+// StorageStats sums recorded sizes over all events
+func (r *EventRepository) StorageStats(ctx context.Context) (*models.EventStorageStats, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	stats := &models.EventStorageStats{}
+	for _, e := range r.store.events {
+		stats.Events++
+		stats.MeasuredEvents++
+		if e.event.Compressed {
+			stats.CompressedEvents++
+		}
+		stats.PayloadBytes += e.event.PayloadSize
+		stats.StoredBytes += e.event.StoredSize
+	}
+	return stats, nil
+}
@@ -0,0 +1,736 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// KeyRepository is an in-memory repositories.KeyRepository
+type KeyRepository struct {
+	store *Store
+}
+
+// validateKey returns the is_active flag of a key of the given type
+func (r *KeyRepository) validateKey(keyHash string, keyType models.KeyType) (bool, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	k := r.store.resolveKey(keyHash, keyType)
+	if k == nil {
+		return false, repositories.ErrNotFound
+	}
+	return k.IsActive, nil
+}
+
+// ValidateWebhookKey returns whether the webhook key is active
+func (r *KeyRepository) ValidateWebhookKey(ctx context.Context, keyHash string) (bool, error) {
+	return r.validateKey(keyHash, models.KeyTypeWebhook)
+}
+
+// ValidateClientKey returns whether the client key is active
+func (r *KeyRepository) ValidateClientKey(ctx context.Context, keyHash string) (bool, error) {
+	return r.validateKey(keyHash, models.KeyTypeClient)
+}
+
+// toWebhookKey builds the webhook key projection of a row
+func toWebhookKey(k *keyRow) *models.WebhookKey {
+	wk := &models.WebhookKey{
+		ID:          k.ID,
+		PairID:      k.ID,
+		Name:        k.Name,
+		KeyHash:     k.KeyHash,
+		KeyPrefix:   k.KeyPrefix,
+		Status:      statusFromBool(k.IsActive),
+		CreatedAt:   k.CreatedAt,
+		LastUsed:    k.LastUsed,
+		EventsCount: k.UsageCount,
+		Settings:    copyWebhookKeySettings(k.Settings),
+	}
+	if k.PairID != nil {
+		wk.PairID = *k.PairID
+	}
+	return wk
+}
+
+// copyWebhookKeySettings returns settings that share no pointers with s
+func copyWebhookKeySettings(s models.WebhookKeySettings) models.WebhookKeySettings {
+	c := models.WebhookKeySettings{RateLimitPerMinute: copyInt(s.RateLimitPerMinute)}
+	if s.SigningSecret != nil {
+		secret := *s.SigningSecret
+		c.SigningSecret = &secret
+	}
+	if len(s.AllowedPaths) > 0 {
+		c.AllowedPaths = append([]string(nil), s.AllowedPaths...)
+	}
+	if s.ForcedPathPrefix != nil {
+		prefix := *s.ForcedPathPrefix
+		c.ForcedPathPrefix = &prefix
+	}
+	return c
+}
+
+// toClientKey builds the client_keys view projection of a row
+func toClientKey(k *keyRow) *models.ClientKey {
+	ck := &models.ClientKey{
+		ID:              k.ID,
+		KeyHash:         k.KeyHash,
+		KeyPrefix:       k.KeyPrefix,
+		Status:          statusFromBool(k.IsActive),
+		CreatedAt:       k.CreatedAt,
+		LastConnected:   k.LastUsed,
+		EventsDelivered: k.UsageCount,
+	}
+	if k.PairID != nil {
+		ck.WebhookKeyID = *k.PairID
+	}
+	return ck
+}
+
+// GetWebhookKeyByHash retrieves a webhook key by its hash
+func (r *KeyRepository) GetWebhookKeyByHash(ctx context.Context, keyHash string) (*models.WebhookKey, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	k := r.store.resolveKey(keyHash, models.KeyTypeWebhook)
+	if k == nil {
+		return nil, repositories.ErrNotFound
+	}
+	return toWebhookKey(k), nil
+}
+
+// GetClientKeyByHash retrieves a client key by its hash
+func (r *KeyRepository) GetClientKeyByHash(ctx context.Context, keyHash string) (*models.ClientKey, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	k := r.store.resolveKey(keyHash, models.KeyTypeClient)
+	if k == nil {
+		return nil, repositories.ErrNotFound
+	}
+	return toClientKey(k), nil
+}
+
+// GetKeyByID retrieves basic key information by ID
+func (r *KeyRepository) GetKeyByID(ctx context.Context, keyID uuid.UUID) (*models.KeyInfo, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	k, ok := r.store.keys[keyID]
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+	info := &models.KeyInfo{
+		ID:        k.ID.String(),
+		KeyPrefix: k.KeyPrefix,
+		KeyType:   string(k.KeyType),
+		IsActive:  k.IsActive,
+	}
+	if k.UserID != nil {
+		if u, ok := r.store.users[*k.UserID]; ok {
+			info.UserEmail = u.profile.Email
+		}
+	}
+	return info, nil
+}
+
+// GetEmailByWebhookKeyHash returns the user email associated with a webhook key hash
+func (r *KeyRepository) GetEmailByWebhookKeyHash(ctx context.Context, keyHash string) (string, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	k := r.store.resolveKey(keyHash, models.KeyTypeWebhook)
+	if k == nil {
+		return "", repositories.ErrNotFound
+	}
+	if k.UserID != nil {
+		if u, ok := r.store.users[*k.UserID]; ok {
+			return u.profile.Email, nil
+		}
+	}
+	return "", nil
+}
+
+// insertKey adds a row after checking key_hash uniqueness (caller holds the lock)
+func (r *KeyRepository) insertKey(k *keyRow) error {
+	if r.store.keyHashTaken(k.KeyHash) {
+		return fmt.Errorf("duplicate key hash: %s", k.KeyHash)
+	}
+	k.seq = r.store.nextSeq()
+	r.store.keys[k.ID] = k
+	return nil
+}
+
+// CreateWebhookKey inserts a standalone webhook key and fills in generated fields
+func (r *KeyRepository) CreateWebhookKey(ctx context.Context, key *models.WebhookKey) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	id := key.ID
+	row := &keyRow{ID: id, KeyHash: key.KeyHash, KeyPrefix: key.KeyPrefix, KeyType: models.KeyTypeWebhook, PairID: &id, IsActive: true, CreatedAt: time.Now()}
+	if err := r.insertKey(row); err != nil {
+		return err
+	}
+	value := key.KeyValue
+	*key = *toWebhookKey(row)
+	key.KeyValue = value
+	return nil
+}
+
+// CreateClientKey inserts a client key paired with key.WebhookKeyID
+func (r *KeyRepository) CreateClientKey(ctx context.Context, key *models.ClientKey) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	pairID := key.WebhookKeyID
+	row := &keyRow{ID: key.ID, KeyHash: key.KeyHash, KeyPrefix: key.KeyPrefix, KeyType: models.KeyTypeClient, PairID: &pairID, IsActive: true, CreatedAt: time.Now()}
+	if err := r.insertKey(row); err != nil {
+		return err
+	}
+	value := key.KeyValue
+	*key = *toClientKey(row)
+	key.KeyValue = value
+	return nil
+}
+
+// UpdateKeyStatus sets is_active for a key of the given type
+func (r *KeyRepository) UpdateKeyStatus(ctx context.Context, keyHash string, keyType models.KeyType, isActive bool) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k := r.store.findKey(keyHash, keyType)
+	if k == nil {
+		return repositories.ErrNotFound
+	}
+	k.IsActive = isActive
+	return nil
+}
+
+// UpdateKeyStatusByID sets is_active for the key of the given type with this ID
+func (r *KeyRepository) UpdateKeyStatusByID(ctx context.Context, keyID uuid.UUID, keyType models.KeyType, isActive bool) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.keys[keyID]
+	if !ok || k.KeyType != keyType {
+		return repositories.ErrNotFound
+	}
+	k.IsActive = isActive
+	return nil
+}
+
+// DeactivateKeyByID deactivates a single key by ID
+func (r *KeyRepository) DeactivateKeyByID(ctx context.Context, keyID uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.keys[keyID]
+	if !ok {
+		return repositories.ErrNotFound
+	}
+	k.IsActive = false
+	return nil
+}
+
+// DeleteKeyPair deletes a webhook key and every key sharing its pair_id
+func (r *KeyRepository) DeleteKeyPair(ctx context.Context, webhookKeyHash string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	wk := r.store.findKey(webhookKeyHash, models.KeyTypeWebhook)
+	if wk == nil {
+		return repositories.ErrNotFound
+	}
+
+	ids := map[uuid.UUID]bool{wk.ID: true}
+	if wk.PairID != nil {
+		for _, k := range r.store.keys {
+			if k.PairID != nil && *k.PairID == *wk.PairID {
+				ids[k.ID] = true
+			}
+		}
+	}
+	r.store.deleteKeys(ids)
+	return nil
+}
+
+// IncrementUsage updates last_used and increments usage_count for a key
+func (r *KeyRepository) IncrementUsage(ctx context.Context, keyID uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if k, ok := r.store.keys[keyID]; ok {
+		k.LastUsed = timePtr(time.Now())
+		k.UsageCount++
+	}
+	return nil
+}
+
+// pairedClient returns the client key paired with a webhook key (caller holds the lock)
+func (r *KeyRepository) pairedClient(webhookKeyID uuid.UUID) *keyRow {
+	for _, k := range r.store.keys {
+		if k.KeyType == models.KeyTypeClient && k.PairID != nil && *k.PairID == webhookKeyID {
+			return k
+		}
+	}
+	return nil
+}
+
+// GetWebhookKeys returns all webhook keys with their paired client keys
+func (r *KeyRepository) GetWebhookKeys(ctx context.Context) ([]*models.WebhookKey, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var keys []*models.WebhookKey
+	for _, k := range r.store.sortedKeys(func(k *keyRow) bool { return k.KeyType == models.KeyTypeWebhook }) {
+		wk := toWebhookKey(k)
+		wk.EventsCount = 0
+		for _, e := range r.store.events {
+			if e.event.WebhookKeyID == k.ID {
+				wk.EventsCount++
+			}
+		}
+		if ck := r.pairedClient(wk.PairID); ck != nil {
+			wk.ClientKeyPrefix = ck.KeyPrefix
+		}
+		keys = append(keys, wk)
+	}
+	return keys, nil
+}
+
+// GetClientKeys returns all client keys
+func (r *KeyRepository) GetClientKeys(ctx context.Context) ([]*models.ClientKey, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var keys []*models.ClientKey
+	for _, k := range r.store.sortedKeys(func(k *keyRow) bool { return k.KeyType == models.KeyTypeClient }) {
+		keys = append(keys, toClientKey(k))
+	}
+	return keys, nil
+}
+
+// CreateKeyPair inserts a webhook key and its client key atomically
+func (r *KeyRepository) CreateKeyPair(ctx context.Context, webhookKey *models.WebhookKey, clientKey *models.ClientKey) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if webhookKey.KeyHash == clientKey.KeyHash || r.store.keyHashTaken(webhookKey.KeyHash) || r.store.keyHashTaken(clientKey.KeyHash) {
+		return fmt.Errorf("duplicate key hash")
+	}
+	if webhookKey.ID == uuid.Nil {
+		webhookKey.ID = uuid.New()
+	}
+	if clientKey.ID == uuid.Nil {
+		clientKey.ID = uuid.New()
+	}
+
+	now := time.Now()
+	pairID := webhookKey.ID
+	wk := &keyRow{ID: webhookKey.ID, KeyHash: webhookKey.KeyHash, KeyPrefix: webhookKey.KeyPrefix, KeyType: models.KeyTypeWebhook, PairID: &pairID, IsActive: true, CreatedAt: now}
+	ck := &keyRow{ID: clientKey.ID, KeyHash: clientKey.KeyHash, KeyPrefix: clientKey.KeyPrefix, KeyType: models.KeyTypeClient, PairID: &pairID, IsActive: true, CreatedAt: now}
+	_ = r.insertKey(wk)
+	_ = r.insertKey(ck)
+
+	webhookValue, clientValue := webhookKey.KeyValue, clientKey.KeyValue
+	*webhookKey = *toWebhookKey(wk)
+	*clientKey = *toClientKey(ck)
+	webhookKey.KeyValue, clientKey.KeyValue = webhookValue, clientValue
+	return nil
+}
+
+// CreateUserKeyPair inserts a user's webhook+client key pair atomically
+func (r *KeyRepository) CreateUserKeyPair(ctx context.Context, userID uuid.UUID, webhookKey *models.WebhookKey, clientKey *models.ClientKey) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.users[userID]; !ok {
+		return fmt.Errorf("unknown user: %s", userID)
+	}
+	if webhookKey.KeyHash == clientKey.KeyHash || r.store.keyHashTaken(webhookKey.KeyHash) || r.store.keyHashTaken(clientKey.KeyHash) {
+		return fmt.Errorf("duplicate key hash")
+	}
+
+	now := time.Now()
+	pairID := uuid.New()
+	for _, k := range []*keyRow{
+		{ID: pairID, KeyHash: webhookKey.KeyHash, KeyPrefix: webhookKey.KeyPrefix, KeyType: models.KeyTypeWebhook},
+		{ID: uuid.New(), KeyHash: clientKey.KeyHash, KeyPrefix: clientKey.KeyPrefix, KeyType: models.KeyTypeClient},
+	} {
+		k.PairID = &pairID
+		k.IsActive = true
+		k.CreatedAt = now
+		k.UserID = &userID
+		_ = r.insertKey(k)
+	}
+	return nil
+}
+
+// CountUserKeys returns the count of active keys of a specific type for a user
+func (r *KeyRepository) CountUserKeys(ctx context.Context, userEmail string, keyType models.KeyType) (int, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	count := 0
+	for _, k := range r.store.keys {
+		if r.store.ownedBy(k, userEmail) && k.KeyType == keyType && k.IsActive {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ListUserKeyPairs returns all key pairs for a user, ordered newest first
+func (r *KeyRepository) ListUserKeyPairs(ctx context.Context, userEmail string) ([]models.KeyPair, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var pairs []models.KeyPair
+	now := time.Now()
+	for _, k := range r.store.sortedKeys(func(k *keyRow) bool {
+		return r.store.ownedBy(k, userEmail) && k.KeyType == models.KeyTypeWebhook && k.isPrimary()
+	}) {
+		p := models.KeyPair{
+			PairID:           k.ID.String(),
+			WebhookKeyPrefix: k.KeyPrefix,
+			IsActive:         k.IsActive,
+			CreatedAt:        k.CreatedAt,
+			LastUsed:         k.LastUsed,
+			UsageCount:       k.UsageCount,
+			Retention:        models.RetentionSettings{EventTTLDays: copyInt(k.EventTTLDays), ProcessedTTLDays: copyInt(k.ProcessedTTLDays)},
+		}
+		if k.PreviousKeyExpiresAt != nil && k.PreviousKeyExpiresAt.After(now) {
+			p.PreviousWebhookKeyExpiresAt = timePtr(*k.PreviousKeyExpiresAt)
+		}
+		if ck := r.pairedClient(k.ID); ck != nil {
+			p.ClientKeyPrefix = ck.KeyPrefix
+			if ck.PreviousKeyExpiresAt != nil && ck.PreviousKeyExpiresAt.After(now) {
+				p.PreviousClientKeyExpiresAt = timePtr(*ck.PreviousKeyExpiresAt)
+			}
+		}
+		p.WebhookKeys = append(p.WebhookKeys, *toWebhookKey(k))
+		named := r.store.sortedKeys(func(n *keyRow) bool {
+			return n.KeyType == models.KeyTypeWebhook && !n.isPrimary() && *n.PairID == k.ID
+		})
+		for i := len(named) - 1; i >= 0; i-- { // oldest first
+			p.WebhookKeys = append(p.WebhookKeys, *toWebhookKey(named[i]))
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, nil
+}
+
+// DeactivateUserKeyPair deactivates both keys of a pair, scoped to its owner
+func (r *KeyRepository) DeactivateUserKeyPair(ctx context.Context, pairID uuid.UUID, userEmail string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	updated := 0
+	for _, k := range r.store.keys {
+		if k.PairID != nil && *k.PairID == pairID && r.store.ownedBy(k, userEmail) && k.IsActive {
+			k.IsActive = false
+			updated++
+		}
+	}
+	if updated == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// CreatePairWebhookKey adds a named webhook key to one of the user's active pairs
+func (r *KeyRepository) CreatePairWebhookKey(ctx context.Context, pairID uuid.UUID, userEmail string, key *models.WebhookKey) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	primary, ok := r.store.keys[pairID]
+	if !ok || primary.KeyType != models.KeyTypeWebhook || !primary.isPrimary() || !r.store.ownedBy(primary, userEmail) || !primary.IsActive {
+		return repositories.ErrNotFound
+	}
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	row := &keyRow{
+		ID:        key.ID,
+		KeyHash:   key.KeyHash,
+		KeyPrefix: key.KeyPrefix,
+		KeyType:   models.KeyTypeWebhook,
+		PairID:    &pairID,
+		IsActive:  true,
+		CreatedAt: time.Now(),
+		UserID:    primary.UserID,
+		Name:      key.Name,
+		Settings:  copyWebhookKeySettings(key.Settings),
+	}
+	if err := r.insertKey(row); err != nil {
+		return err
+	}
+	value := key.KeyValue
+	*key = *toWebhookKey(row)
+	key.KeyValue = value
+	return nil
+}
+
+// UpdateWebhookKeySettings replaces the name and settings of one of the user's webhook keys
+func (r *KeyRepository) UpdateWebhookKeySettings(ctx context.Context, keyID uuid.UUID, userEmail, name string, settings models.WebhookKeySettings) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.keys[keyID]
+	if !ok || k.KeyType != models.KeyTypeWebhook || !r.store.ownedBy(k, userEmail) {
+		return repositories.ErrNotFound
+	}
+	k.Name = name
+	k.Settings = copyWebhookKeySettings(settings)
+	return nil
+}
+
+// SetWebhookKeyPathRules replaces the path allowlist and forced prefix of any webhook key
+func (r *KeyRepository) SetWebhookKeyPathRules(ctx context.Context, keyID uuid.UUID, allowedPaths []string, forcedPrefix *string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.keys[keyID]
+	if !ok || k.KeyType != models.KeyTypeWebhook {
+		return repositories.ErrNotFound
+	}
+	rules := copyWebhookKeySettings(models.WebhookKeySettings{AllowedPaths: allowedPaths, ForcedPathPrefix: forcedPrefix})
+	k.Settings.AllowedPaths = rules.AllowedPaths
+	k.Settings.ForcedPathPrefix = rules.ForcedPathPrefix
+	return nil
+}
+
+// DeactivateUserWebhookKey revokes one of the user's named webhook keys
+func (r *KeyRepository) DeactivateUserWebhookKey(ctx context.Context, keyID uuid.UUID, userEmail string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.keys[keyID]
+	if !ok || k.KeyType != models.KeyTypeWebhook || k.isPrimary() || !r.store.ownedBy(k, userEmail) || !k.IsActive {
+		return repositories.ErrNotFound
+	}
+	k.IsActive = false
+	return nil
+}
+
+// RotateKey gives the pair's original webhook key or newest active client key a new hash. The old
+// hash keeps resolving until previousExpiresAt; nil retires it at once.
+func (r *KeyRepository) RotateKey(ctx context.Context, pairID uuid.UUID, keyType models.KeyType, newHash, newPrefix string, previousExpiresAt *time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	rows := r.store.sortedKeys(func(k *keyRow) bool {
+		return k.PairID != nil && *k.PairID == pairID && k.KeyType == keyType && k.IsActive && k.isPrimary()
+	})
+	if len(rows) == 0 {
+		return repositories.ErrNotFound
+	}
+	if r.store.keyHashTaken(newHash) {
+		return fmt.Errorf("duplicate key hash: %s", newHash)
+	}
+	k := rows[0]
+	k.PreviousKeyHash, k.PreviousKeyExpiresAt = "", nil
+	if previousExpiresAt != nil {
+		k.PreviousKeyHash, k.PreviousKeyExpiresAt = k.KeyHash, timePtr(*previousExpiresAt)
+	}
+	k.KeyHash, k.KeyPrefix = newHash, newPrefix
+	return nil
+}
+
+// ClearExpiredRotations forgets up to limit previous key hashes that expired before cutoff
+func (r *KeyRepository) ClearExpiredRotations(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var cleared int64
+	for _, k := range r.store.keys {
+		if cleared >= int64(limit) {
+			break
+		}
+		if k.PreviousKeyHash != "" && k.PreviousKeyExpiresAt != nil && k.PreviousKeyExpiresAt.Before(cutoff) {
+			k.PreviousKeyHash, k.PreviousKeyExpiresAt = "", nil
+			cleared++
+		}
+	}
+	return cleared, nil
+}
+
+// ListUnhashedKeys returns up to limit keys still stored in the clear. Keys
+// created through the repository always have a prefix, so only rows added
+// without one, as a migrated database would have them, are returned.
+func (r *KeyRepository) ListUnhashedKeys(ctx context.Context, limit int) ([]models.LegacyKey, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var keys []models.LegacyKey
+	for _, k := range r.store.sortedKeys(func(k *keyRow) bool { return k.KeyPrefix == "" }) {
+		if len(keys) >= limit {
+			break
+		}
+		legacy := models.LegacyKey{ID: k.ID, KeyValue: k.KeyHash}
+		if k.PreviousKeyHash != "" {
+			previous := k.PreviousKeyHash
+			legacy.PreviousKeyValue = &previous
+		}
+		keys = append(keys, legacy)
+	}
+	return keys, nil
+}
+
+// StoreKeyHash replaces an unhashed key's stored values with their hashes
+func (r *KeyRepository) StoreKeyHash(ctx context.Context, keyID uuid.UUID, keyHash, keyPrefix string, previousKeyHash *string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.keys[keyID]
+	if !ok || k.KeyPrefix != "" {
+		return repositories.ErrNotFound
+	}
+	k.KeyHash, k.KeyPrefix, k.PreviousKeyHash = keyHash, keyPrefix, ""
+	if previousKeyHash != nil {
+		k.PreviousKeyHash = *previousKeyHash
+	}
+	return nil
+}
+
+// GetRetention returns the retention overrides of a webhook key
+func (r *KeyRepository) GetRetention(ctx context.Context, webhookKeyID uuid.UUID) (*models.RetentionSettings, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	k, ok := r.store.keys[webhookKeyID]
+	if !ok || k.KeyType != models.KeyTypeWebhook {
+		return nil, repositories.ErrNotFound
+	}
+	return &models.RetentionSettings{EventTTLDays: copyInt(k.EventTTLDays), ProcessedTTLDays: copyInt(k.ProcessedTTLDays)}, nil
+}
+
+// SetRetention replaces the retention overrides of a webhook key
+func (r *KeyRepository) SetRetention(ctx context.Context, webhookKeyID uuid.UUID, settings models.RetentionSettings) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.keys[webhookKeyID]
+	if !ok || k.KeyType != models.KeyTypeWebhook {
+		return repositories.ErrNotFound
+	}
+	k.EventTTLDays = copyInt(settings.EventTTLDays)
+	k.ProcessedTTLDays = copyInt(settings.ProcessedTTLDays)
+	return nil
+}
+
+// SetDeliveryAlertedAt records when the stuck delivery monitor last alerted about a webhook key
+func (r *KeyRepository) SetDeliveryAlertedAt(ctx context.Context, webhookKeyID uuid.UUID, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.keys[webhookKeyID]
+	if !ok || k.KeyType != models.KeyTypeWebhook {
+		return repositories.ErrNotFound
+	}
+	k.DeliveryAlertedAt = timePtr(at)
+	return nil
+}
+
+// SetE2EPublicKey stores or clears the end-to-end public key of a client key
+func (r *KeyRepository) SetE2EPublicKey(ctx context.Context, clientKeyID uuid.UUID, publicKey *string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.keys[clientKeyID]
+	if !ok || k.KeyType != models.KeyTypeClient {
+		return repositories.ErrNotFound
+	}
+	if publicKey != nil {
+		value := *publicKey
+		publicKey = &value
+	}
+	k.E2EPublicKey = publicKey
+	return nil
+}
+
+// GetE2EPublicKey returns the newest public key registered on an active client key of the pair
+func (r *KeyRepository) GetE2EPublicKey(ctx context.Context, webhookKeyID uuid.UUID) (string, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	rows := r.store.sortedKeys(func(k *keyRow) bool {
+		return k.KeyType == models.KeyTypeClient && k.IsActive && k.E2EPublicKey != nil &&
+			k.PairID != nil && *k.PairID == webhookKeyID
+	})
+	if len(rows) == 0 {
+		return "", repositories.ErrNotFound
+	}
+	return *rows[0].E2EPublicKey, nil
+}
+
+// copyInt returns a copy of an optional int so callers can't alias stored rows
+func copyInt(v *int) *int {
+	if v == nil {
+		return nil
+	}
+	c := *v
+	return &c
+}
+
+// DeleteInactiveKeyPairs deletes up to limit fully inactive pairs idle since idleSince
+func (r *KeyRepository) DeleteInactiveKeyPairs(ctx context.Context, idleSince time.Time, limit int) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	type pairState struct {
+		ids        map[uuid.UUID]bool
+		active     bool
+		lastActive time.Time
+	}
+	pairs := make(map[uuid.UUID]*pairState)
+	var order []uuid.UUID
+	for _, k := range r.store.sortedKeys(func(*keyRow) bool { return true }) {
+		pairID := k.ID
+		if k.PairID != nil {
+			pairID = *k.PairID
+		}
+		p, ok := pairs[pairID]
+		if !ok {
+			p = &pairState{ids: make(map[uuid.UUID]bool)}
+			pairs[pairID] = p
+			order = append(order, pairID)
+		}
+		p.ids[k.ID] = true
+		p.active = p.active || k.IsActive
+		lastActive := k.CreatedAt
+		if k.LastUsed != nil {
+			lastActive = *k.LastUsed
+		}
+		if lastActive.After(p.lastActive) {
+			p.lastActive = lastActive
+		}
+	}
+
+	ids := make(map[uuid.UUID]bool)
+	matched := 0
+	for _, pairID := range order {
+		p := pairs[pairID]
+		if p.active || !p.lastActive.Before(idleSince) || matched >= limit {
+			continue
+		}
+		for id := range p.ids {
+			ids[id] = true
+		}
+		matched++
+	}
+	r.store.deleteKeys(ids)
+	return int64(len(ids)), nil
+}
+
+var _ repositories.KeyRepository = (*KeyRepository)(nil)
@@ -0,0 +1,166 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// sessionRow is a stored session and its insertion order
+type sessionRow struct {
+	seq     int64
+	session models.Session
+}
+
+// SessionRepository is an in-memory repositories.SessionRepository
+type SessionRepository struct {
+	store *Store
+}
+
+// Create stores a new session
+func (r *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.sessions[session.ID]; ok {
+		return fmt.Errorf("duplicate session: %s", session.ID)
+	}
+	r.store.sessions[session.ID] = &sessionRow{seq: r.store.nextSeq(), session: *session}
+	return nil
+}
+
+// Get looks up a session by ID
+func (r *SessionRepository) Get(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	s, ok := r.store.sessions[id]
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+	c := s.session
+	return &c, nil
+}
+
+// ListActiveByUser returns a user's active sessions, most recently seen first
+func (r *SessionRepository) ListActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	now := time.Now()
+	var rows []*sessionRow
+	for _, s := range r.store.sessions {
+		if s.session.UserID != nil && *s.session.UserID == userID && s.session.IsActive(now) {
+			rows = append(rows, s)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i].session, rows[j].session
+		if !a.LastSeenAt.Equal(b.LastSeenAt) {
+			return a.LastSeenAt.After(b.LastSeenAt)
+		}
+		return rows[i].seq > rows[j].seq
+	})
+
+	sessions := make([]models.Session, 0, len(rows))
+	for _, s := range rows {
+		sessions = append(sessions, s.session)
+	}
+	return sessions, nil
+}
+
+// TouchLastSeen records when a session was last used
+func (r *SessionRepository) TouchLastSeen(ctx context.Context, id uuid.UUID, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	s, ok := r.store.sessions[id]
+	if !ok {
+		return repositories.ErrNotFound
+	}
+	s.session.LastSeenAt = at
+	return nil
+}
+
+// Revoke marks a session revoked
+func (r *SessionRepository) Revoke(ctx context.Context, id uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	s, ok := r.store.sessions[id]
+	if !ok || s.session.RevokedAt != nil {
+		return repositories.ErrNotFound
+	}
+	s.session.RevokedAt = timePtr(time.Now())
+	return nil
+}
+
+// RevokeUserSession marks one of a user's sessions revoked
+func (r *SessionRepository) RevokeUserSession(ctx context.Context, id, userID uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	s, ok := r.store.sessions[id]
+	if !ok || s.session.UserID == nil || *s.session.UserID != userID || s.session.RevokedAt != nil {
+		return repositories.ErrNotFound
+	}
+	s.session.RevokedAt = timePtr(time.Now())
+	return nil
+}
+
+// RevokeAllByUser revokes every active session of a user
+func (r *SessionRepository) RevokeAllByUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	now := time.Now()
+	var revoked int64
+	for _, s := range r.store.sessions {
+		if s.session.UserID != nil && *s.session.UserID == userID && s.session.IsActive(now) {
+			s.session.RevokedAt = timePtr(now)
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// RevokeAllByAdmin revokes every active session of an admin
+func (r *SessionRepository) RevokeAllByAdmin(ctx context.Context, adminID uuid.UUID) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	now := time.Now()
+	var revoked int64
+	for _, s := range r.store.sessions {
+		if s.session.AdminID != nil && *s.session.AdminID == adminID && s.session.IsActive(now) {
+			s.session.RevokedAt = timePtr(now)
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// DeleteExpired deletes up to limit sessions that expired or were revoked before cutoff
+func (r *SessionRepository) DeleteExpired(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var deleted int64
+	for id, s := range r.store.sessions {
+		if deleted >= int64(limit) {
+			break
+		}
+		expired := s.session.ExpiresAt.Before(cutoff)
+		revoked := s.session.RevokedAt != nil && s.session.RevokedAt.Before(cutoff)
+		if expired || revoked {
+			delete(r.store.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
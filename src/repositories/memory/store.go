@@ -0,0 +1,296 @@
+// Package memory implements the repository interfaces in process memory.
+// It backs unit tests and mirrors the PostgreSQL semantics the services rely on,
+// including the cascading deletes between keys, events and webhook logs.
+package memory
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// keyRow mirrors a row of the api_keys table
+type keyRow struct {
+	seq        int64
+	ID         uuid.UUID
+	KeyHash    string
+	KeyPrefix  string // empty while KeyHash still holds a key stored in the clear
+	KeyType    models.KeyType
+	PairID     *uuid.UUID
+	IsActive   bool
+	CreatedAt  time.Time
+	LastUsed   *time.Time
+	UsageCount int
+
+	UserID *uuid.UUID
+
+	EventTTLDays     *int
+	ProcessedTTLDays *int
+
+	E2EPublicKey *string
+
+	PreviousKeyHash      string
+	PreviousKeyExpiresAt *time.Time
+
+	Name     string
+	Settings models.WebhookKeySettings
+
+	DeliveryAlertedAt *time.Time
+}
+
+// isPrimary reports whether the row is a pair's original webhook key or a client key
+func (k *keyRow) isPrimary() bool {
+	return k.KeyType != models.KeyTypeWebhook || k.PairID == nil || *k.PairID == k.ID
+}
+
+// userRow mirrors a row of the users table
+type userRow struct {
+	seq       int64
+	profile   models.UserProfile
+	CreatedAt time.Time
+
+	MagicLinkToken     string
+	MagicLinkExpiresAt *time.Time
+	MagicLinkUsedAt    *time.Time
+}
+
+// eventRow wraps an event with its insertion order
+type eventRow struct {
+	seq   int64
+	event models.Event
+}
+
+// logRow wraps a webhook log with its insertion order
+type logRow struct {
+	seq int64
+	log models.WebhookLog
+}
+
+// Store holds all tables; every repository created by New shares one Store
+type Store struct {
+	mu     sync.RWMutex
+	seq    int64
+	keys   map[uuid.UUID]*keyRow
+	users  map[uuid.UUID]*userRow
+	events map[uuid.UUID]*eventRow
+	logs   []*logRow
+	admins map[uuid.UUID]*models.AdminUser
+
+	// recoveryCodes maps an admin to its recovery code hashes and whether each was used
+	recoveryCodes map[uuid.UUID]map[string]bool
+	// totpSteps maps an admin to the last TOTP time step they used
+	totpSteps map[uuid.UUID]int64
+
+	dataKeys  map[uuid.UUID]*models.DataKey
+	apiTokens map[uuid.UUID]*apiTokenRow
+	sessions  map[uuid.UUID]*sessionRow
+
+	// audit is the audit chain; event i has Seq i+1
+	audit []models.AuditEvent
+}
+
+// NewStore creates an empty in-memory store
+func NewStore() *Store {
+	return &Store{
+		keys:   make(map[uuid.UUID]*keyRow),
+		users:  make(map[uuid.UUID]*userRow),
+		events: make(map[uuid.UUID]*eventRow),
+		admins: make(map[uuid.UUID]*models.AdminUser),
+
+		recoveryCodes: make(map[uuid.UUID]map[string]bool),
+		totpSteps:     make(map[uuid.UUID]int64),
+
+		dataKeys:  make(map[uuid.UUID]*models.DataKey),
+		apiTokens: make(map[uuid.UUID]*apiTokenRow),
+		sessions:  make(map[uuid.UUID]*sessionRow),
+	}
+}
+
+// New creates in-memory repositories backed by a fresh store
+func New() *repositories.Repositories {
+	return NewStore().Repositories()
+}
+
+// Repositories returns repositories backed by this store
+func (s *Store) Repositories() *repositories.Repositories {
+	return &repositories.Repositories{
+		Keys:        &KeyRepository{store: s},
+		Users:       &UserRepository{store: s},
+		Events:      &EventRepository{store: s},
+		WebhookLogs: &WebhookLogRepository{store: s},
+		Admins:      &AdminRepository{store: s},
+		AuthTokens:  &AuthTokenRepository{store: s},
+		DataKeys:    &DataKeyRepository{store: s},
+		APITokens:   &APITokenRepository{store: s},
+		Sessions:    &SessionRepository{store: s},
+		Audit:       &AuditRepository{store: s},
+	}
+}
+
+// nextSeq returns a monotonically increasing insertion counter (caller holds the lock)
+func (s *Store) nextSeq() int64 {
+	s.seq++
+	return s.seq
+}
+
+// findKey returns the key with the given hash and type (caller holds the lock)
+func (s *Store) findKey(keyHash string, keyType models.KeyType) *keyRow {
+	for _, k := range s.keys {
+		if k.KeyHash == keyHash && k.KeyType == keyType {
+			return k
+		}
+	}
+	return nil
+}
+
+// resolveKey returns the key with the given hash and type, also matching the
+// hash a rotated key replaced until its overlap ends (caller holds the lock)
+func (s *Store) resolveKey(keyHash string, keyType models.KeyType) *keyRow {
+	if k := s.findKey(keyHash, keyType); k != nil {
+		return k
+	}
+	now := time.Now()
+	for _, k := range s.keys {
+		if k.KeyType == keyType && k.PreviousKeyHash == keyHash && k.PreviousKeyExpiresAt != nil && k.PreviousKeyExpiresAt.After(now) {
+			return k
+		}
+	}
+	return nil
+}
+
+// keyHashTaken reports whether a key hash is already used (caller holds the lock)
+func (s *Store) keyHashTaken(keyHash string) bool {
+	for _, k := range s.keys {
+		if k.KeyHash == keyHash || k.PreviousKeyHash == keyHash {
+			return true
+		}
+	}
+	return false
+}
+
+// userByEmail returns the user with the given email (caller holds the lock)
+func (s *Store) userByEmail(email string) *userRow {
+	for _, u := range s.users {
+		if u.profile.Email == email {
+			return u
+		}
+	}
+	return nil
+}
+
+// ownedBy reports whether a key belongs to the user with the given email (caller holds the lock)
+func (s *Store) ownedBy(k *keyRow, email string) bool {
+	if k.UserID == nil {
+		return false
+	}
+	u, ok := s.users[*k.UserID]
+	return ok && u.profile.Email == email
+}
+
+// userWebhookKeyIDs returns the IDs of a user's webhook keys (caller holds the lock)
+func (s *Store) userWebhookKeyIDs(userEmail string) map[uuid.UUID]bool {
+	ids := make(map[uuid.UUID]bool)
+	for _, k := range s.keys {
+		if s.ownedBy(k, userEmail) && k.KeyType == models.KeyTypeWebhook {
+			ids[k.ID] = true
+		}
+	}
+	return ids
+}
+
+// deleteKeys removes keys and cascades to their events and logs (caller holds the lock)
+func (s *Store) deleteKeys(ids map[uuid.UUID]bool) {
+	for id := range ids {
+		delete(s.keys, id)
+		delete(s.dataKeys, id)
+	}
+	for id, e := range s.events {
+		if ids[e.event.WebhookKeyID] {
+			s.deleteEvent(id)
+		}
+	}
+
+	// webhook_logs.webhook_key_id is ON DELETE CASCADE, client_key_id is ON DELETE SET NULL
+	kept := s.logs[:0]
+	for _, l := range s.logs {
+		if keyID, err := uuid.Parse(l.log.WebhookKeyID); err == nil && ids[keyID] {
+			continue
+		}
+		if l.log.ClientKeyID != nil {
+			if clientID, err := uuid.Parse(*l.log.ClientKeyID); err == nil && ids[clientID] {
+				l.log.ClientKeyID = nil
+			}
+		}
+		kept = append(kept, l)
+	}
+	s.logs = kept
+}
+
+// deleteEvent removes an event and its logs (caller holds the lock)
+func (s *Store) deleteEvent(eventID uuid.UUID) {
+	delete(s.events, eventID)
+	id := eventID.String()
+	kept := s.logs[:0]
+	for _, l := range s.logs {
+		if l.log.EventID != id {
+			kept = append(kept, l)
+		}
+	}
+	s.logs = kept
+}
+
+// sortedKeys returns keys matching filter, newest first
+func (s *Store) sortedKeys(filter func(*keyRow) bool) []*keyRow {
+	var rows []*keyRow
+	for _, k := range s.keys {
+		if filter(k) {
+			rows = append(rows, k)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].CreatedAt.Equal(rows[j].CreatedAt) {
+			return rows[i].CreatedAt.After(rows[j].CreatedAt)
+		}
+		return rows[i].seq > rows[j].seq
+	})
+	return rows
+}
+
+// sortedUsers returns all users, newest first (caller holds the lock)
+func (s *Store) sortedUsers() []*userRow {
+	rows := make([]*userRow, 0, len(s.users))
+	for _, u := range s.users {
+		rows = append(rows, u)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].CreatedAt.Equal(rows[j].CreatedAt) {
+			return rows[i].CreatedAt.After(rows[j].CreatedAt)
+		}
+		return rows[i].seq > rows[j].seq
+	})
+	return rows
+}
+
+// copyEvent returns a copy of the event that does not alias stored data
+func copyEvent(e *models.Event) models.Event {
+	c := *e
+	c.Data = append([]byte(nil), e.Data...)
+	return c
+}
+
+// statusFromBool converts is_active to the status string used by key models
+func statusFromBool(isActive bool) string {
+	if isActive {
+		return string(models.KeyStatusActive)
+	}
+	return string(models.KeyStatusInactive)
+}
+
+// timePtr returns a pointer to t
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
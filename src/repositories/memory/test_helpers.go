@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/keyhash"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// TestStore wraps an in-memory store configured for testing.
+// It offers the same fixture helpers as database.TestDB so tests can switch freely.
+type TestStore struct {
+	Store *Store
+	Repos *repositories.Repositories
+	// KeyHasher hashes the keys CreateTestKeyPair stores; pass it to services under test
+	KeyHasher *keyhash.Hasher
+	t         *testing.T
+}
+
+// testKeyPepper matches database.TestKeyPepper so fixtures hash keys the same way
+const testKeyPepper = "test-pepper-0123456789abcdef0123456789abcdef"
+
+// NewTestStore creates an empty in-memory store for a test
+func NewTestStore(t *testing.T) *TestStore {
+	t.Helper()
+
+	hasher, err := keyhash.New(testKeyPepper)
+	if err != nil {
+		t.Fatalf("could not create key hasher: %v", err)
+	}
+
+	store := NewStore()
+	return &TestStore{Store: store, Repos: store.Repositories(), KeyHasher: hasher, t: t}
+}
+
+// randomSuffix returns a short random hex string for test key values
+func randomSuffix() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// CreateTestKeyPair creates a test webhook+client key pair
+// Returns webhookKeyID, clientKeyID, webhookKeyValue, clientKeyValue
+// Parameters are kept for parity with database.TestDB and ignored
+func (ts *TestStore) CreateTestKeyPair(legacyID int64, legacyName string) (webhookKeyID, clientKeyID, webhookKeyValue, clientKeyValue string, err error) {
+	webhookKeyValue = "wh_test_" + randomSuffix()
+	clientKeyValue = "ck_test_" + randomSuffix()
+	webhookKey := &models.WebhookKey{KeyHash: ts.KeyHasher.Hash(webhookKeyValue), KeyPrefix: keyhash.Prefix(webhookKeyValue)}
+	clientKey := &models.ClientKey{KeyHash: ts.KeyHasher.Hash(clientKeyValue), KeyPrefix: keyhash.Prefix(clientKeyValue)}
+
+	if err = ts.Repos.Keys.CreateKeyPair(context.Background(), webhookKey, clientKey); err != nil {
+		return
+	}
+	return webhookKey.ID.String(), clientKey.ID.String(), webhookKeyValue, clientKeyValue, nil
+}
+
+// CreateTestAdmin creates a test admin user
+// Returns adminID, username
+func (ts *TestStore) CreateTestAdmin(username, passwordHash string) (adminID, returnedUsername string, err error) {
+	admin := &models.AdminUser{
+		ID:           uuid.New(),
+		Username:     username,
+		PasswordHash: passwordHash,
+		CreatedAt:    time.Now(),
+		IsActive:     true,
+		Role:         models.AdminRoleOwner,
+	}
+	if err = ts.Repos.Admins.Create(context.Background(), admin); err != nil {
+		return
+	}
+	return admin.ID.String(), admin.Username, nil
+}
+
+// CreateTestEvent creates a test event for a webhook key
+// Returns eventID
+func (ts *TestStore) CreateTestEvent(webhookKeyID, path string, data []byte) (eventID string, err error) {
+	keyID, err := uuid.Parse(webhookKeyID)
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook key ID: %w", err)
+	}
+
+	now := time.Now()
+	event := &models.Event{
+		ID:           uuid.New(),
+		WebhookKeyID: keyID,
+		Path:         path,
+		Data:         data,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(24 * time.Hour),
+	}
+	if err = ts.Repos.Events.Create(context.Background(), event); err != nil {
+		return
+	}
+	return event.ID.String(), nil
+}
+
+// WithTestStore is a helper for tests that need repositories
+// Usage:
+//
+//	func TestSomething(t *testing.T) {
+//	    memory.WithTestStore(t, func(ts *memory.TestStore) {
+//	        // Use ts.Repos to build services
+//	    })
+//	}
+func WithTestStore(t *testing.T, fn func(ts *TestStore)) {
+	t.Helper()
+
+	fn(NewTestStore(t))
+}
@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// UserRepository is an in-memory repositories.UserRepository
+type UserRepository struct {
+	store *Store
+}
+
+// Upsert creates a user or updates the name and language of the existing one
+func (r *UserRepository) Upsert(ctx context.Context, profile *models.UserProfile) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	u := r.store.userByEmail(profile.Email)
+	if u == nil {
+		u = &userRow{seq: r.store.nextSeq(), CreatedAt: time.Now()}
+		u.profile.ID = uuid.New()
+		u.profile.Email = profile.Email
+		u.profile.DeliveryAlerts = true
+		r.store.users[u.profile.ID] = u
+	}
+	u.profile.Name = profile.Name
+	u.profile.Language = profile.Language
+	u.profile.EmailVerified = u.profile.EmailVerified || profile.EmailVerified
+
+	profile.ID = u.profile.ID
+	profile.EmailVerified = u.profile.EmailVerified
+	profile.DeliveryAlerts = u.profile.DeliveryAlerts
+	return nil
+}
+
+// GetByEmail returns a user's profile
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.UserProfile, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	u := r.store.userByEmail(email)
+	if u == nil {
+		return nil, repositories.ErrNotFound
+	}
+	profile := u.profile
+	return &profile, nil
+}
+
+// List returns all users with totals over their keys, ordered by created_at DESC
+func (r *UserRepository) List(ctx context.Context) ([]models.User, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	users := make([]models.User, 0, len(r.store.users))
+	for _, row := range r.store.sortedUsers() {
+		u := models.User{ID: row.profile.ID, UserEmail: row.profile.Email, UserName: row.profile.Name, CreatedAt: row.CreatedAt}
+		for _, k := range r.store.keys {
+			if k.UserID == nil || *k.UserID != u.ID {
+				continue
+			}
+			u.IsActive = u.IsActive || k.IsActive
+			if k.LastUsed != nil && (u.LastUsed == nil || k.LastUsed.After(*u.LastUsed)) {
+				u.LastUsed = k.LastUsed
+			}
+			u.UsageCount += k.UsageCount
+			switch k.KeyType {
+			case models.KeyTypeWebhook:
+				u.WebhookKeyCount++
+			case models.KeyTypeClient:
+				u.ClientKeyCount++
+			}
+		}
+		// The newest original webhook key and client key, as shown in the admin panel
+		for _, k := range r.store.sortedKeys(func(k *keyRow) bool { return k.UserID != nil && *k.UserID == u.ID && k.isPrimary() }) {
+			id := k.ID
+			if k.KeyType == models.KeyTypeWebhook && u.WebhookKeyID == nil {
+				u.WebhookKeyPrefix, u.WebhookKeyID = k.KeyPrefix, &id
+			}
+			if k.KeyType == models.KeyTypeClient && u.ClientKeyID == nil {
+				u.ClientKeyPrefix, u.ClientKeyID = k.KeyPrefix, &id
+			}
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// Get returns a user's creation time and most recent active keys
+func (r *UserRepository) Get(ctx context.Context, email string) (*models.User, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	row := r.store.userByEmail(email)
+	if row == nil {
+		return nil, repositories.ErrNotFound
+	}
+
+	user := &models.User{ID: row.profile.ID, UserEmail: email, UserName: row.profile.Name, CreatedAt: row.CreatedAt}
+	for _, k := range r.store.sortedKeys(func(k *keyRow) bool { return k.UserID != nil && *k.UserID == user.ID }) {
+		if !k.IsActive || !k.isPrimary() {
+			continue
+		}
+		if k.KeyType == models.KeyTypeWebhook && user.WebhookKeyPrefix == "" {
+			user.WebhookKeyPrefix = k.KeyPrefix
+		}
+		if k.KeyType == models.KeyTypeClient && user.ClientKeyPrefix == "" {
+			user.ClientKeyPrefix = k.KeyPrefix
+		}
+	}
+	return user, nil
+}
+
+// Delete removes a user with their keys, API tokens and sessions; deleting
+// the keys cascades to their events, webhook logs and data keys
+func (r *UserRepository) Delete(ctx context.Context, email string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	u := r.store.userByEmail(email)
+	if u == nil {
+		return repositories.ErrNotFound
+	}
+
+	ids := make(map[uuid.UUID]bool)
+	for _, k := range r.store.keys {
+		if k.UserID != nil && *k.UserID == u.profile.ID {
+			ids[k.ID] = true
+		}
+	}
+	r.store.deleteKeys(ids)
+
+	for id, t := range r.store.apiTokens {
+		if t.token.UserID == u.profile.ID {
+			delete(r.store.apiTokens, id)
+		}
+	}
+	for id, s := range r.store.sessions {
+		if s.session.UserID != nil && *s.session.UserID == u.profile.ID {
+			delete(r.store.sessions, id)
+		}
+	}
+	delete(r.store.users, u.profile.ID)
+	return nil
+}
+
+// SetDeliveryAlerts turns a user's stuck delivery emails on or off
+func (r *UserRepository) SetDeliveryAlerts(ctx context.Context, email string, enabled bool) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	u := r.store.userByEmail(email)
+	if u == nil {
+		return repositories.ErrNotFound
+	}
+	u.profile.DeliveryAlerts = enabled
+	return nil
+}
+
+var _ repositories.UserRepository = (*UserRepository)(nil)
@@ -0,0 +1,161 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// WebhookLogRepository is an in-memory repositories.WebhookLogRepository
+type WebhookLogRepository struct {
+	store *Store
+}
+
+// Create inserts a pending log entry for a new event
+func (r *WebhookLogRepository) Create(ctx context.Context, eventID, webhookKeyID uuid.UUID, statusCode int) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	now := time.Now()
+	r.store.logs = append(r.store.logs, &logRow{
+		seq: r.store.nextSeq(),
+		log: models.WebhookLog{
+			ID:             uuid.New().String(),
+			EventID:        eventID.String(),
+			WebhookKeyID:   webhookKeyID.String(),
+			DeliveryStatus: models.DeliveryStatusPending,
+			StatusCode:     &statusCode,
+			AttemptedAt:    now,
+			CreatedAt:      now,
+		},
+	})
+	return nil
+}
+
+// MarkDelivered moves a pending log entry to delivered
+func (r *WebhookLogRepository) MarkDelivered(ctx context.Context, eventID, webhookKeyID, clientKeyID uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	clientID := clientKeyID.String()
+	for _, l := range r.store.logs {
+		if l.log.EventID == eventID.String() && l.log.WebhookKeyID == webhookKeyID.String() && l.log.DeliveryStatus == models.DeliveryStatusPending {
+			l.log.DeliveryStatus = models.DeliveryStatusDelivered
+			l.log.DeliveredAt = timePtr(time.Now())
+			l.log.ClientKeyID = &clientID
+		}
+	}
+	return nil
+}
+
+// MarkAcked moves pending or delivered log entries to acked
+func (r *WebhookLogRepository) MarkAcked(ctx context.Context, eventID uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, l := range r.store.logs {
+		if l.log.EventID == eventID.String() &&
+			(l.log.DeliveryStatus == models.DeliveryStatusPending || l.log.DeliveryStatus == models.DeliveryStatusDelivered) {
+			l.log.DeliveryStatus = models.DeliveryStatusAcked
+			l.log.AckedAt = timePtr(time.Now())
+		}
+	}
+	return nil
+}
+
+// GetLatestStatus returns the delivery status of the most recent log entry for an event
+func (r *WebhookLogRepository) GetLatestStatus(ctx context.Context, eventID uuid.UUID) (string, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var latest *logRow
+	for _, l := range r.store.logs {
+		if l.log.EventID == eventID.String() && (latest == nil || !l.log.AttemptedAt.Before(latest.log.AttemptedAt)) {
+			latest = l
+		}
+	}
+	if latest == nil {
+		return "", repositories.ErrNotFound
+	}
+	return latest.log.DeliveryStatus, nil
+}
+
+// userLogs returns a user's log entries, newest attempt first (caller holds the lock)
+func (r *WebhookLogRepository) userLogs(userEmail string) []*logRow {
+	ids := make(map[string]bool)
+	for id := range r.store.userWebhookKeyIDs(userEmail) {
+		ids[id.String()] = true
+	}
+
+	var rows []*logRow
+	for _, l := range r.store.logs {
+		if ids[l.log.WebhookKeyID] {
+			rows = append(rows, l)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].log.AttemptedAt.Equal(rows[j].log.AttemptedAt) {
+			return rows[i].log.AttemptedAt.After(rows[j].log.AttemptedAt)
+		}
+		return rows[i].seq > rows[j].seq
+	})
+	return rows
+}
+
+// CountByUserEmail counts log entries across all of a user's webhook keys
+func (r *WebhookLogRepository) CountByUserEmail(ctx context.Context, userEmail string) (int, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	return len(r.userLogs(userEmail)), nil
+}
+
+// ListByUserEmail returns a user's log entries, newest attempt first
+func (r *WebhookLogRepository) ListByUserEmail(ctx context.Context, userEmail string, limit, offset int) ([]models.WebhookLog, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	rows := r.userLogs(userEmail)
+	if offset >= len(rows) {
+		return nil, nil
+	}
+	rows = rows[offset:]
+	if limit >= 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	logs := make([]models.WebhookLog, 0, len(rows))
+	for _, l := range rows {
+		logs = append(logs, l.log)
+	}
+	return logs, nil
+}
+
+// DeleteOrphaned deletes up to limit log entries whose event or webhook key is gone.
+// The store cascades deletes itself, so this only matters for rows added out of band.
+func (r *WebhookLogRepository) DeleteOrphaned(ctx context.Context, limit int) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var deleted int64
+	kept := r.store.logs[:0]
+	for _, l := range r.store.logs {
+		eventID, _ := uuid.Parse(l.log.EventID)
+		keyID, _ := uuid.Parse(l.log.WebhookKeyID)
+		_, hasEvent := r.store.events[eventID]
+		_, hasKey := r.store.keys[keyID]
+		if (!hasEvent || !hasKey) && deleted < int64(limit) {
+			deleted++
+			continue
+		}
+		kept = append(kept, l)
+	}
+	r.store.logs = kept
+	return deleted, nil
+}
+
+var _ repositories.WebhookLogRepository = (*WebhookLogRepository)(nil)
@@ -11,9 +11,21 @@ import (
 // AdminRepository is a mock implementation of repositories.AdminRepository
 type AdminRepository struct {
 	// Function stubs that can be overridden in tests
-	CreateFunc          func(ctx context.Context, admin *models.AdminUser) error
-	GetByUsernameFunc   func(ctx context.Context, username string) (*models.AdminUser, error)
-	UpdateLastLoginFunc func(ctx context.Context, adminID uuid.UUID) error
+	CreateFunc               func(ctx context.Context, admin *models.AdminUser) error
+	GetByUsernameFunc        func(ctx context.Context, username string) (*models.AdminUser, error)
+	GetByIDFunc              func(ctx context.Context, adminID uuid.UUID) (*models.AdminUser, error)
+	UpdateLastLoginFunc      func(ctx context.Context, adminID uuid.UUID) error
+	CountFunc                func(ctx context.Context) (int, error)
+	SetTOTPSecretFunc        func(ctx context.Context, adminID uuid.UUID, secret []byte) error
+	EnableTOTPFunc           func(ctx context.Context, adminID uuid.UUID, recoveryCodeHashes []string) error
+	DisableTOTPFunc          func(ctx context.Context, adminID uuid.UUID) error
+	UseRecoveryCodeFunc      func(ctx context.Context, adminID uuid.UUID, codeHash string) error
+	UseTOTPStepFunc          func(ctx context.Context, adminID uuid.UUID, step int64) error
+	ListFunc                 func(ctx context.Context) ([]models.AdminUser, error)
+	GetByInviteTokenHashFunc func(ctx context.Context, tokenHash string) (*models.AdminUser, error)
+	AcceptInviteFunc         func(ctx context.Context, adminID uuid.UUID, passwordHash string) error
+	UpdateRoleFunc           func(ctx context.Context, adminID uuid.UUID, role models.AdminRole) error
+	SetActiveFunc            func(ctx context.Context, adminID uuid.UUID, active bool) error
 
 	// Call tracking
 	Calls map[string][]interface{}
@@ -42,6 +54,14 @@ func (m *AdminRepository) GetByUsername(ctx context.Context, username string) (*
 	return nil, nil
 }
 
+func (m *AdminRepository) GetByID(ctx context.Context, adminID uuid.UUID) (*models.AdminUser, error) {
+	m.Calls["GetByID"] = append(m.Calls["GetByID"], adminID)
+	if m.GetByIDFunc != nil {
+		return m.GetByIDFunc(ctx, adminID)
+	}
+	return nil, nil
+}
+
 func (m *AdminRepository) UpdateLastLogin(ctx context.Context, adminID uuid.UUID) error {
 	m.Calls["UpdateLastLogin"] = append(m.Calls["UpdateLastLogin"], adminID)
 	if m.UpdateLastLoginFunc != nil {
@@ -50,5 +70,93 @@ func (m *AdminRepository) UpdateLastLogin(ctx context.Context, adminID uuid.UUID
 	return nil
 }
 
+func (m *AdminRepository) Count(ctx context.Context) (int, error) {
+	m.Calls["Count"] = append(m.Calls["Count"], nil)
+	if m.CountFunc != nil {
+		return m.CountFunc(ctx)
+	}
+	return 0, nil
+}
+
+func (m *AdminRepository) SetTOTPSecret(ctx context.Context, adminID uuid.UUID, secret []byte) error {
+	m.Calls["SetTOTPSecret"] = append(m.Calls["SetTOTPSecret"], adminID)
+	if m.SetTOTPSecretFunc != nil {
+		return m.SetTOTPSecretFunc(ctx, adminID, secret)
+	}
+	return nil
+}
+
+func (m *AdminRepository) EnableTOTP(ctx context.Context, adminID uuid.UUID, recoveryCodeHashes []string) error {
+	m.Calls["EnableTOTP"] = append(m.Calls["EnableTOTP"], adminID)
+	if m.EnableTOTPFunc != nil {
+		return m.EnableTOTPFunc(ctx, adminID, recoveryCodeHashes)
+	}
+	return nil
+}
+
+func (m *AdminRepository) DisableTOTP(ctx context.Context, adminID uuid.UUID) error {
+	m.Calls["DisableTOTP"] = append(m.Calls["DisableTOTP"], adminID)
+	if m.DisableTOTPFunc != nil {
+		return m.DisableTOTPFunc(ctx, adminID)
+	}
+	return nil
+}
+
+func (m *AdminRepository) UseRecoveryCode(ctx context.Context, adminID uuid.UUID, codeHash string) error {
+	m.Calls["UseRecoveryCode"] = append(m.Calls["UseRecoveryCode"], codeHash)
+	if m.UseRecoveryCodeFunc != nil {
+		return m.UseRecoveryCodeFunc(ctx, adminID, codeHash)
+	}
+	return nil
+}
+
+func (m *AdminRepository) UseTOTPStep(ctx context.Context, adminID uuid.UUID, step int64) error {
+	m.Calls["UseTOTPStep"] = append(m.Calls["UseTOTPStep"], step)
+	if m.UseTOTPStepFunc != nil {
+		return m.UseTOTPStepFunc(ctx, adminID, step)
+	}
+	return nil
+}
+
+func (m *AdminRepository) List(ctx context.Context) ([]models.AdminUser, error) {
+	m.Calls["List"] = append(m.Calls["List"], nil)
+	if m.ListFunc != nil {
+		return m.ListFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (m *AdminRepository) GetByInviteTokenHash(ctx context.Context, tokenHash string) (*models.AdminUser, error) {
+	m.Calls["GetByInviteTokenHash"] = append(m.Calls["GetByInviteTokenHash"], tokenHash)
+	if m.GetByInviteTokenHashFunc != nil {
+		return m.GetByInviteTokenHashFunc(ctx, tokenHash)
+	}
+	return nil, nil
+}
+
+func (m *AdminRepository) AcceptInvite(ctx context.Context, adminID uuid.UUID, passwordHash string) error {
+	m.Calls["AcceptInvite"] = append(m.Calls["AcceptInvite"], adminID)
+	if m.AcceptInviteFunc != nil {
+		return m.AcceptInviteFunc(ctx, adminID, passwordHash)
+	}
+	return nil
+}
+
+func (m *AdminRepository) UpdateRole(ctx context.Context, adminID uuid.UUID, role models.AdminRole) error {
+	m.Calls["UpdateRole"] = append(m.Calls["UpdateRole"], role)
+	if m.UpdateRoleFunc != nil {
+		return m.UpdateRoleFunc(ctx, adminID, role)
+	}
+	return nil
+}
+
+func (m *AdminRepository) SetActive(ctx context.Context, adminID uuid.UUID, active bool) error {
+	m.Calls["SetActive"] = append(m.Calls["SetActive"], active)
+	if m.SetActiveFunc != nil {
+		return m.SetActiveFunc(ctx, adminID, active)
+	}
+	return nil
+}
+
 // Ensure AdminRepository implements the interface
 var _ repositories.AdminRepository = (*AdminRepository)(nil)
@@ -2,6 +2,7 @@ package mock
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
@@ -11,13 +12,27 @@ import (
 // EventRepository is a mock implementation of repositories.EventRepository
 type EventRepository struct {
 	// Function stubs that can be overridden in tests
-	CreateFunc          func(ctx context.Context, event *models.Event) error
-	GetByIDFunc         func(ctx context.Context, eventID uuid.UUID) (*models.Event, error)
-	GetUnprocessedFunc  func(ctx context.Context, webhookKeyID uuid.UUID) ([]models.Event, error)
-	GetByWebhookKeyFunc func(ctx context.Context, webhookKeyID uuid.UUID, limit int) ([]models.Event, error)
-	MarkAsProcessedFunc func(ctx context.Context, eventID uuid.UUID) error
-	DeleteFunc          func(ctx context.Context, eventID uuid.UUID) error
-	DeleteExpiredFunc   func(ctx context.Context) (int64, error)
+	CreateFunc                  func(ctx context.Context, event *models.Event) error
+	GetByIDFunc                 func(ctx context.Context, eventID uuid.UUID) (*models.Event, error)
+	GetUnprocessedFunc          func(ctx context.Context, webhookKeyID uuid.UUID) ([]models.Event, error)
+	GetByWebhookKeyFunc         func(ctx context.Context, webhookKeyID uuid.UUID, limit int) ([]models.Event, error)
+	MarkAsProcessedFunc         func(ctx context.Context, eventID uuid.UUID, processedTTL time.Duration) error
+	DeleteFunc                  func(ctx context.Context, eventID uuid.UUID) error
+	DeleteExpiredFunc           func(ctx context.Context) (int64, error)
+	DeleteExpiredBatchFunc      func(ctx context.Context, limit int) (int64, error)
+	DeleteExpiredByKeyBatchFunc func(ctx context.Context, webhookKeyID uuid.UUID, limit int) (int64, error)
+	GetScheduledDueFunc         func(ctx context.Context, from, to time.Time) ([]models.Event, error)
+	ApplyRetentionFunc          func(ctx context.Context, webhookKeyID uuid.UUID, unprocessedTTL, processedTTL time.Duration) (int64, error)
+	ListAfterFunc               func(ctx context.Context, afterID uuid.UUID, limit int) ([]models.Event, error)
+	UpdateDataFunc              func(ctx context.Context, eventID uuid.UUID, data []byte) error
+	ReferencedBlobsFunc         func(ctx context.Context, refs []string) (map[string]bool, error)
+
+	CountUndeliveredFunc  func(ctx context.Context, olderThan time.Duration) (int, error)
+	CountByWebhookKeyFunc func(ctx context.Context, webhookKeyID uuid.UUID) (int, error)
+	CountByUserEmailFunc  func(ctx context.Context, userEmail string) (int, error)
+	StorageStatsFunc      func(ctx context.Context) (*models.EventStorageStats, error)
+
+	ListStuckDeliveriesFunc func(ctx context.Context, dueBefore time.Time, minCount int) ([]models.StuckDelivery, error)
 
 	// Call tracking
 	Calls map[string][]interface{}
@@ -62,10 +77,10 @@ func (m *EventRepository) GetByWebhookKey(ctx context.Context, webhookKeyID uuid
 	return nil, nil
 }
 
-func (m *EventRepository) MarkAsProcessed(ctx context.Context, eventID uuid.UUID) error {
+func (m *EventRepository) MarkAsProcessed(ctx context.Context, eventID uuid.UUID, processedTTL time.Duration) error {
 	m.Calls["MarkAsProcessed"] = append(m.Calls["MarkAsProcessed"], eventID)
 	if m.MarkAsProcessedFunc != nil {
-		return m.MarkAsProcessedFunc(ctx, eventID)
+		return m.MarkAsProcessedFunc(ctx, eventID, processedTTL)
 	}
 	return nil
 }
@@ -86,5 +101,101 @@ func (m *EventRepository) DeleteExpired(ctx context.Context) (int64, error) {
 	return 0, nil
 }
 
+func (m *EventRepository) DeleteExpiredBatch(ctx context.Context, limit int) (int64, error) {
+	m.Calls["DeleteExpiredBatch"] = append(m.Calls["DeleteExpiredBatch"], limit)
+	if m.DeleteExpiredBatchFunc != nil {
+		return m.DeleteExpiredBatchFunc(ctx, limit)
+	}
+	return 0, nil
+}
+
+func (m *EventRepository) DeleteExpiredByKeyBatch(ctx context.Context, webhookKeyID uuid.UUID, limit int) (int64, error) {
+	m.Calls["DeleteExpiredByKeyBatch"] = append(m.Calls["DeleteExpiredByKeyBatch"], []interface{}{webhookKeyID, limit})
+	if m.DeleteExpiredByKeyBatchFunc != nil {
+		return m.DeleteExpiredByKeyBatchFunc(ctx, webhookKeyID, limit)
+	}
+	return 0, nil
+}
+
+func (m *EventRepository) GetScheduledDue(ctx context.Context, from, to time.Time) ([]models.Event, error) {
+	m.Calls["GetScheduledDue"] = append(m.Calls["GetScheduledDue"], []interface{}{from, to})
+	if m.GetScheduledDueFunc != nil {
+		return m.GetScheduledDueFunc(ctx, from, to)
+	}
+	return nil, nil
+}
+
+func (m *EventRepository) ApplyRetention(ctx context.Context, webhookKeyID uuid.UUID, unprocessedTTL, processedTTL time.Duration) (int64, error) {
+	m.Calls["ApplyRetention"] = append(m.Calls["ApplyRetention"], webhookKeyID)
+	if m.ApplyRetentionFunc != nil {
+		return m.ApplyRetentionFunc(ctx, webhookKeyID, unprocessedTTL, processedTTL)
+	}
+	return 0, nil
+}
+
+func (m *EventRepository) ListAfter(ctx context.Context, afterID uuid.UUID, limit int) ([]models.Event, error) {
+	m.Calls["ListAfter"] = append(m.Calls["ListAfter"], []interface{}{afterID, limit})
+	if m.ListAfterFunc != nil {
+		return m.ListAfterFunc(ctx, afterID, limit)
+	}
+	return nil, nil
+}
+
+func (m *EventRepository) UpdateData(ctx context.Context, eventID uuid.UUID, data []byte) error {
+	m.Calls["UpdateData"] = append(m.Calls["UpdateData"], eventID)
+	if m.UpdateDataFunc != nil {
+		return m.UpdateDataFunc(ctx, eventID, data)
+	}
+	return nil
+}
+
+func (m *EventRepository) ReferencedBlobs(ctx context.Context, refs []string) (map[string]bool, error) {
+	m.Calls["ReferencedBlobs"] = append(m.Calls["ReferencedBlobs"], refs)
+	if m.ReferencedBlobsFunc != nil {
+		return m.ReferencedBlobsFunc(ctx, refs)
+	}
+	return map[string]bool{}, nil
+}
+
+func (m *EventRepository) CountUndelivered(ctx context.Context, olderThan time.Duration) (int, error) {
+	m.Calls["CountUndelivered"] = append(m.Calls["CountUndelivered"], olderThan)
+	if m.CountUndeliveredFunc != nil {
+		return m.CountUndeliveredFunc(ctx, olderThan)
+	}
+	return 0, nil
+}
+
+func (m *EventRepository) CountByWebhookKey(ctx context.Context, webhookKeyID uuid.UUID) (int, error) {
+	m.Calls["CountByWebhookKey"] = append(m.Calls["CountByWebhookKey"], webhookKeyID)
+	if m.CountByWebhookKeyFunc != nil {
+		return m.CountByWebhookKeyFunc(ctx, webhookKeyID)
+	}
+	return 0, nil
+}
+
+func (m *EventRepository) CountByUserEmail(ctx context.Context, userEmail string) (int, error) {
+	m.Calls["CountByUserEmail"] = append(m.Calls["CountByUserEmail"], userEmail)
+	if m.CountByUserEmailFunc != nil {
+		return m.CountByUserEmailFunc(ctx, userEmail)
+	}
+	return 0, nil
+}
+
+func (m *EventRepository) ListStuckDeliveries(ctx context.Context, dueBefore time.Time, minCount int) ([]models.StuckDelivery, error) {
+	m.Calls["ListStuckDeliveries"] = append(m.Calls["ListStuckDeliveries"], dueBefore, minCount)
+	if m.ListStuckDeliveriesFunc != nil {
+		return m.ListStuckDeliveriesFunc(ctx, dueBefore, minCount)
+	}
+	return nil, nil
+}
+
+func (m *EventRepository) StorageStats(ctx context.Context) (*models.EventStorageStats, error) {
+	m.Calls["StorageStats"] = append(m.Calls["StorageStats"], nil)
+	if m.StorageStatsFunc != nil {
+		return m.StorageStatsFunc(ctx)
+	}
+	return &models.EventStorageStats{}, nil
+}
+
 // Ensure EventRepository implements the interface
 var _ repositories.EventRepository = (*EventRepository)(nil)
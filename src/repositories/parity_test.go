@@ -0,0 +1,1739 @@
+package repositories_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/database"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/postgres"
+)
+
+// backends lists every storage backend the parity suite runs against.
+// PostgreSQL is skipped when no test database is available.
+var backends = []struct {
+	name string
+	with func(t *testing.T, fn func(repos *repositories.Repositories))
+}{
+	{"memory", func(t *testing.T, fn func(repos *repositories.Repositories)) {
+		fn(memory.New())
+	}},
+	{"sqlite", func(t *testing.T, fn func(repos *repositories.Repositories)) {
+		db, err := database.New(context.Background(), "sqlite://"+filepath.Join(t.TempDir(), "webhooks.db"))
+		if err != nil {
+			t.Fatalf("Failed to open SQLite database: %v", err)
+		}
+		defer db.Close()
+		fn(db.Repositories())
+	}},
+	{"postgres", func(t *testing.T, fn func(repos *repositories.Repositories)) {
+		database.WithTestDB(t, func(tdb *database.TestDB) {
+			fn(postgres.New(tdb.Pool))
+		})
+	}},
+}
+
+// runParity runs a test body once per backend as a subtest
+func runParity(t *testing.T, fn func(t *testing.T, repos *repositories.Repositories)) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			b.with(t, func(repos *repositories.Repositories) {
+				fn(t, repos)
+			})
+		})
+	}
+}
+
+// newKeyHash returns a unique stand-in for a stored key hash; repositories never
+// see key values, so any unique string works
+func newKeyHash(prefix string) string {
+	return prefix + uuid.NewString()
+}
+
+// createPair creates a standalone key pair with unique key hashes
+func createPair(t *testing.T, repos *repositories.Repositories) (*models.WebhookKey, *models.ClientKey) {
+	t.Helper()
+	wk := &models.WebhookKey{KeyHash: newKeyHash("wh_parity_"), KeyPrefix: "wh_parity"}
+	ck := &models.ClientKey{KeyHash: newKeyHash("ck_parity_"), KeyPrefix: "ck_parity"}
+	if err := repos.Keys.CreateKeyPair(context.Background(), wk, ck); err != nil {
+		t.Fatalf("CreateKeyPair failed: %v", err)
+	}
+	return wk, ck
+}
+
+// createUser creates a user key pair and returns the user's email and both keys
+func createUser(t *testing.T, repos *repositories.Repositories) (string, *models.WebhookKey, *models.ClientKey) {
+	t.Helper()
+	ctx := context.Background()
+	email := "parity_" + uuid.NewString() + "@example.com"
+	profile := &models.UserProfile{Email: email, Name: "Parity", Language: "ru", EmailVerified: true}
+	if err := repos.Users.Upsert(ctx, profile); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	ck := &models.ClientKey{KeyHash: newKeyHash("ck_user_"), KeyPrefix: "ck_user"}
+	created := &models.WebhookKey{KeyHash: newKeyHash("wh_user_"), KeyPrefix: "wh_user"}
+	if err := repos.Keys.CreateUserKeyPair(ctx, profile.ID, created, ck); err != nil {
+		t.Fatalf("CreateUserKeyPair failed: %v", err)
+	}
+	wk, err := repos.Keys.GetWebhookKeyByHash(ctx, created.KeyHash)
+	if err != nil {
+		t.Fatalf("GetWebhookKeyByHash failed: %v", err)
+	}
+	return email, wk, ck
+}
+
+// newEvent builds an unprocessed event for a webhook key
+func newEvent(webhookKeyID uuid.UUID, expiresAt time.Time, deliverAfter *time.Time) *models.Event {
+	return &models.Event{
+		ID:           uuid.New(),
+		WebhookKeyID: webhookKeyID,
+		Path:         "notes/parity.md",
+		Data:         []byte("parity data"),
+		CreatedAt:    time.Now(),
+		ExpiresAt:    expiresAt,
+		DeliverAfter: deliverAfter,
+	}
+}
+
+// TestParity_KeyPairs verifies key pair creation, lookup and status changes
+func TestParity_KeyPairs(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		wk, ck := createPair(t, repos)
+
+		if wk.Status != string(models.KeyStatusActive) || ck.Status != string(models.KeyStatusActive) {
+			t.Errorf("Expected new keys to be active, got %q and %q", wk.Status, ck.Status)
+		}
+
+		gotCK, err := repos.Keys.GetClientKeyByHash(ctx, ck.KeyHash)
+		if err != nil {
+			t.Fatalf("GetClientKeyByHash failed: %v", err)
+		}
+		if gotCK.WebhookKeyID != wk.ID {
+			t.Errorf("Expected client key paired with %s, got %s", wk.ID, gotCK.WebhookKeyID)
+		}
+
+		info, err := repos.Keys.GetKeyByID(ctx, ck.ID)
+		if err != nil {
+			t.Fatalf("GetKeyByID failed: %v", err)
+		}
+		if info.KeyPrefix != ck.KeyPrefix || info.KeyType != string(models.KeyTypeClient) || info.UserEmail != "" {
+			t.Errorf("Unexpected key info: %+v", info)
+		}
+
+		if err := repos.Keys.UpdateKeyStatus(ctx, wk.KeyHash, models.KeyTypeWebhook, false); err != nil {
+			t.Fatalf("UpdateKeyStatus failed: %v", err)
+		}
+		valid, err := repos.Keys.ValidateWebhookKey(ctx, wk.KeyHash)
+		if err != nil {
+			t.Fatalf("ValidateWebhookKey failed: %v", err)
+		}
+		if valid {
+			t.Error("Expected deactivated webhook key to be invalid")
+		}
+
+		if _, err := repos.Keys.ValidateClientKey(ctx, "ck_missing_"+uuid.NewString()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown key, got %v", err)
+		}
+		if err := repos.Keys.UpdateKeyStatus(ctx, "wh_missing_"+uuid.NewString(), models.KeyTypeWebhook, true); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound when updating unknown key, got %v", err)
+		}
+
+		if err := repos.Keys.IncrementUsage(ctx, wk.ID); err != nil {
+			t.Fatalf("IncrementUsage failed: %v", err)
+		}
+		gotWK, err := repos.Keys.GetWebhookKeyByHash(ctx, wk.KeyHash)
+		if err != nil {
+			t.Fatalf("GetWebhookKeyByHash failed: %v", err)
+		}
+		if gotWK.EventsCount != 1 || gotWK.LastUsed == nil {
+			t.Errorf("Expected usage to be recorded, got count=%d last_used=%v", gotWK.EventsCount, gotWK.LastUsed)
+		}
+	})
+}
+
+// TestParity_KeysByID verifies status changes by key ID and that hashed keys
+// are left alone by the legacy hashing pass
+func TestParity_KeysByID(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		wk, ck := createPair(t, repos)
+
+		if err := repos.Keys.UpdateKeyStatusByID(ctx, ck.ID, models.KeyTypeClient, false); err != nil {
+			t.Fatalf("UpdateKeyStatusByID failed: %v", err)
+		}
+		if active, err := repos.Keys.ValidateClientKey(ctx, ck.KeyHash); err != nil || active {
+			t.Errorf("Expected the client key to be inactive: %v, %v", active, err)
+		}
+		if err := repos.Keys.UpdateKeyStatusByID(ctx, ck.ID, models.KeyTypeWebhook, true); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for the wrong key type, got %v", err)
+		}
+		if err := repos.Keys.UpdateKeyStatusByID(ctx, uuid.New(), models.KeyTypeWebhook, true); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown key, got %v", err)
+		}
+
+		if err := repos.Keys.StoreKeyHash(ctx, wk.ID, newKeyHash("wh_rehashed_"), "wh_rehash", nil); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound rehashing a hashed key, got %v", err)
+		}
+		unhashed, err := repos.Keys.ListUnhashedKeys(ctx, 10)
+		if err != nil || len(unhashed) != 0 {
+			t.Errorf("Expected no unhashed keys, got %+v (%v)", unhashed, err)
+		}
+	})
+}
+
+// TestParity_Users verifies the user views derived from keys
+func TestParity_Users(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		email, wk, _ := createUser(t, repos)
+
+		profile, err := repos.Users.GetByEmail(ctx, email)
+		if err != nil {
+			t.Fatalf("GetByEmail failed: %v", err)
+		}
+		if profile.ID == uuid.Nil || profile.Name != "Parity" || profile.Language != "ru" || !profile.EmailVerified {
+			t.Errorf("Unexpected profile: %+v", profile)
+		}
+
+		// A second upsert updates the profile but keeps the user and its verification
+		again := &models.UserProfile{Email: email, Name: "Renamed", Language: "en"}
+		if err := repos.Users.Upsert(ctx, again); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+		if again.ID != profile.ID || !again.EmailVerified {
+			t.Errorf("Expected the existing verified user, got %+v", again)
+		}
+		if got, _ := repos.Users.GetByEmail(ctx, email); got == nil || got.Name != "Renamed" || got.Language != "en" {
+			t.Errorf("Expected updated profile, got %+v", got)
+		}
+		if got, err := repos.Keys.GetEmailByWebhookKeyHash(ctx, wk.KeyHash); err != nil || got != email {
+			t.Errorf("Expected %s for the webhook key, got %q (%v)", email, got, err)
+		}
+		if info, err := repos.Keys.GetKeyByID(ctx, wk.ID); err != nil || info.UserEmail != email {
+			t.Errorf("Expected the key's owner to be %s, got %+v (%v)", email, info, err)
+		}
+
+		user, err := repos.Users.Get(ctx, email)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if user.ID != profile.ID || user.WebhookKeyPrefix != wk.KeyPrefix || user.CreatedAt.IsZero() {
+			t.Errorf("Unexpected user: %+v", user)
+		}
+
+		// Users without keys are listed too
+		keyless := &models.UserProfile{Email: "keyless_" + uuid.NewString() + "@example.com", Language: "en"}
+		if err := repos.Users.Upsert(ctx, keyless); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+
+		users, err := repos.Users.List(ctx)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		var listed, listedKeyless *models.User
+		for i := range users {
+			switch users[i].UserEmail {
+			case email:
+				listed = &users[i]
+			case keyless.Email:
+				listedKeyless = &users[i]
+			}
+		}
+		if listedKeyless == nil || listedKeyless.IsActive || listedKeyless.WebhookKeyCount != 0 || listedKeyless.WebhookKeyPrefix != "" || listedKeyless.WebhookKeyID != nil {
+			t.Errorf("Unexpected keyless user: %+v", listedKeyless)
+		}
+		if listed == nil {
+			t.Fatalf("Expected %s in List", email)
+		}
+		if listed.ID != profile.ID || listed.UserName != "Renamed" || !listed.IsActive || listed.WebhookKeyCount != 1 || listed.ClientKeyCount != 1 || listed.CreatedAt.IsZero() ||
+			listed.WebhookKeyID == nil || *listed.WebhookKeyID != wk.ID || listed.ClientKeyPrefix != "ck_user" {
+			t.Errorf("Unexpected listed user: %+v", listed)
+		}
+
+		pairs, err := repos.Keys.ListUserKeyPairs(ctx, email)
+		if err != nil {
+			t.Fatalf("ListUserKeyPairs failed: %v", err)
+		}
+		if len(pairs) != 1 || pairs[0].WebhookKeyPrefix != wk.KeyPrefix || pairs[0].ClientKeyPrefix != "ck_user" {
+			t.Fatalf("Unexpected key pairs: %+v", pairs)
+		}
+
+		if err := repos.Keys.DeactivateUserKeyPair(ctx, wk.ID, email); err != nil {
+			t.Fatalf("DeactivateUserKeyPair failed: %v", err)
+		}
+		if err := repos.Keys.DeactivateUserKeyPair(ctx, wk.ID, email); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for already revoked pair, got %v", err)
+		}
+		count, err := repos.Keys.CountUserKeys(ctx, email, models.KeyTypeWebhook)
+		if err != nil {
+			t.Fatalf("CountUserKeys failed: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("Expected 0 active webhook keys, got %d", count)
+		}
+
+		unknown := "nobody_" + uuid.NewString() + "@example.com"
+		if _, err := repos.Users.Get(ctx, unknown); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound from Get, got %v", err)
+		}
+		if _, err := repos.Users.GetByEmail(ctx, unknown); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound from GetByEmail, got %v", err)
+		}
+	})
+}
+
+// TestParity_Events verifies delivery ordering, scheduling, expiry and cascades
+func TestParity_Events(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		wk, _ := createPair(t, repos)
+		now := time.Now()
+
+		immediate := newEvent(wk.ID, now.Add(time.Hour), nil)
+		immediate.Mode = models.WriteModeAppend
+		future := now.Add(time.Hour)
+		scheduled := newEvent(wk.ID, now.Add(2*time.Hour), &future)
+		expired := newEvent(wk.ID, now.Add(-time.Minute), nil)
+		for _, e := range []*models.Event{immediate, scheduled, expired} {
+			if err := repos.Events.Create(ctx, e); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+
+		got, err := repos.Events.GetByID(ctx, immediate.ID)
+		if err != nil {
+			t.Fatalf("GetByID failed: %v", err)
+		}
+		if got.Path != immediate.Path || string(got.Data) != string(immediate.Data) || got.Processed || got.Mode != models.WriteModeAppend {
+			t.Errorf("Unexpected event: %+v", got)
+		}
+
+		due, err := repos.Events.GetScheduledDue(ctx, now, now.Add(90*time.Minute))
+		if err != nil {
+			t.Fatalf("GetScheduledDue failed: %v", err)
+		}
+		if len(due) != 1 || due[0].ID != scheduled.ID {
+			t.Errorf("Expected only the scheduled event to be due, got %d events", len(due))
+		}
+
+		deleted, err := repos.Events.DeleteExpired(ctx)
+		if err != nil {
+			t.Fatalf("DeleteExpired failed: %v", err)
+		}
+		if deleted < 1 {
+			t.Errorf("Expected the expired event to be deleted, got %d", deleted)
+		}
+
+		pending, err := repos.Events.GetUnprocessed(ctx, wk.ID)
+		if err != nil {
+			t.Fatalf("GetUnprocessed failed: %v", err)
+		}
+		if len(pending) != 1 || pending[0].ID != immediate.ID {
+			t.Fatalf("Expected only the immediate event to be pending, got %d events", len(pending))
+		}
+
+		if err := repos.Events.MarkAsProcessed(ctx, immediate.ID, 3*time.Hour); err != nil {
+			t.Fatalf("MarkAsProcessed failed: %v", err)
+		}
+		got, err = repos.Events.GetByID(ctx, immediate.ID)
+		if err != nil {
+			t.Fatalf("GetByID failed: %v", err)
+		}
+		if !got.Processed || got.ProcessedAt == nil {
+			t.Fatalf("Expected event to be processed with a timestamp, got %+v", got)
+		}
+		if d := got.ExpiresAt.Sub(*got.ProcessedAt); d < 3*time.Hour-time.Second || d > 3*time.Hour+time.Second {
+			t.Errorf("Expected processed event to expire 3h after processing, got %v", d)
+		}
+
+		count, err := repos.Events.CountByWebhookKey(ctx, wk.ID)
+		if err != nil {
+			t.Fatalf("CountByWebhookKey failed: %v", err)
+		}
+		if count != 2 {
+			t.Errorf("Expected 2 events, got %d", count)
+		}
+
+		if err := repos.Events.Delete(ctx, uuid.New()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound deleting unknown event, got %v", err)
+		}
+
+		if err := repos.Keys.DeleteKeyPair(ctx, wk.KeyHash); err != nil {
+			t.Fatalf("DeleteKeyPair failed: %v", err)
+		}
+		if _, err := repos.Events.GetByID(ctx, scheduled.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected events to be deleted with their key, got %v", err)
+		}
+	})
+}
+
+// TestParity_EventPaging verifies ID-ordered paging and in-place data updates
+func TestParity_EventPaging(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		wk, _ := createPair(t, repos)
+
+		var ids []string
+		for i := 0; i < 5; i++ {
+			e := newEvent(wk.ID, time.Now().Add(time.Hour), nil)
+			if err := repos.Events.Create(ctx, e); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			ids = append(ids, e.ID.String())
+		}
+		sort.Strings(ids)
+
+		var seen []string
+		after := uuid.Nil
+		for {
+			page, err := repos.Events.ListAfter(ctx, after, 2)
+			if err != nil {
+				t.Fatalf("ListAfter failed: %v", err)
+			}
+			for _, e := range page {
+				seen = append(seen, e.ID.String())
+			}
+			if len(page) < 2 {
+				break
+			}
+			after = page[len(page)-1].ID
+		}
+		if strings.Join(seen, ",") != strings.Join(ids, ",") {
+			t.Errorf("Expected events in ID order %v, got %v", ids, seen)
+		}
+
+		id := uuid.MustParse(ids[0])
+		if err := repos.Events.UpdateData(ctx, id, []byte("rewritten")); err != nil {
+			t.Fatalf("UpdateData failed: %v", err)
+		}
+		got, err := repos.Events.GetByID(ctx, id)
+		if err != nil {
+			t.Fatalf("GetByID failed: %v", err)
+		}
+		if string(got.Data) != "rewritten" {
+			t.Errorf("Expected updated data, got %q", got.Data)
+		}
+		if err := repos.Events.UpdateData(ctx, uuid.New(), nil); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound updating unknown event, got %v", err)
+		}
+	})
+}
+
+// TestParity_E2E verifies client public keys and the sealed event flag
+func TestParity_E2E(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		wk, ck := createPair(t, repos)
+
+		if _, err := repos.Keys.GetE2EPublicKey(ctx, wk.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Fatalf("Expected ErrNotFound before a key is registered, got %v", err)
+		}
+
+		publicKey := "cHVibGljLWtleQ=="
+		if err := repos.Keys.SetE2EPublicKey(ctx, ck.ID, &publicKey); err != nil {
+			t.Fatalf("SetE2EPublicKey failed: %v", err)
+		}
+		if got, err := repos.Keys.GetE2EPublicKey(ctx, wk.ID); err != nil || got != publicKey {
+			t.Errorf("Expected %q, got %q (%v)", publicKey, got, err)
+		}
+		if err := repos.Keys.SetE2EPublicKey(ctx, wk.ID, &publicKey); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound setting a key on a webhook key, got %v", err)
+		}
+
+		// Revoked client keys no longer receive sealed events
+		if err := repos.Keys.UpdateKeyStatus(ctx, ck.KeyHash, models.KeyTypeClient, false); err != nil {
+			t.Fatalf("UpdateKeyStatus failed: %v", err)
+		}
+		if _, err := repos.Keys.GetE2EPublicKey(ctx, wk.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a revoked client key, got %v", err)
+		}
+		if err := repos.Keys.UpdateKeyStatus(ctx, ck.KeyHash, models.KeyTypeClient, true); err != nil {
+			t.Fatalf("UpdateKeyStatus failed: %v", err)
+		}
+
+		if err := repos.Keys.SetE2EPublicKey(ctx, ck.ID, nil); err != nil {
+			t.Fatalf("SetE2EPublicKey(nil) failed: %v", err)
+		}
+		if _, err := repos.Keys.GetE2EPublicKey(ctx, wk.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound after clearing the key, got %v", err)
+		}
+
+		event := newEvent(wk.ID, time.Now().Add(time.Hour), nil)
+		event.Sealed = true
+		if err := repos.Events.Create(ctx, event); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		got, err := repos.Events.GetByID(ctx, event.ID)
+		if err != nil {
+			t.Fatalf("GetByID failed: %v", err)
+		}
+		if !got.Sealed {
+			t.Error("Expected the sealed flag to round-trip")
+		}
+	})
+}
+
+// TestParity_DataKeys verifies wrapped data key storage, conditional rewrap and cascade deletion
+func TestParity_DataKeys(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		wk, _ := createPair(t, repos)
+
+		if _, err := repos.DataKeys.Get(ctx, wk.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Fatalf("Expected ErrNotFound before a key is created, got %v", err)
+		}
+
+		key := &models.DataKey{WebhookKeyID: wk.ID, KeyID: "dek-" + uuid.NewString()[:12], WrappedKey: []byte("wrapped-1"), CreatedAt: time.Now()}
+		if err := repos.DataKeys.Create(ctx, key); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		// A second key for the same pair is ignored; the first one wins
+		loser := &models.DataKey{WebhookKeyID: wk.ID, KeyID: "dek-" + uuid.NewString()[:12], WrappedKey: []byte("wrapped-2"), CreatedAt: time.Now()}
+		if err := repos.DataKeys.Create(ctx, loser); err != nil {
+			t.Fatalf("Create for an existing pair failed: %v", err)
+		}
+		got, err := repos.DataKeys.Get(ctx, wk.ID)
+		if err != nil || got.KeyID != key.KeyID || string(got.WrappedKey) != "wrapped-1" {
+			t.Fatalf("Expected the first key, got %+v (%v)", got, err)
+		}
+
+		if err := repos.DataKeys.UpdateWrappedKey(ctx, wk.ID, loser.KeyID, []byte("stale")); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound rewrapping with the wrong key ID, got %v", err)
+		}
+		if err := repos.DataKeys.UpdateWrappedKey(ctx, wk.ID, key.KeyID, []byte("rewrapped")); err != nil {
+			t.Fatalf("UpdateWrappedKey failed: %v", err)
+		}
+		if got, _ := repos.DataKeys.Get(ctx, wk.ID); string(got.WrappedKey) != "rewrapped" {
+			t.Errorf("Expected rewrapped key, got %q", got.WrappedKey)
+		}
+
+		other, _ := createPair(t, repos)
+		if err := repos.DataKeys.Create(ctx, &models.DataKey{WebhookKeyID: other.ID, KeyID: "dek-" + uuid.NewString()[:12], WrappedKey: []byte("x"), CreatedAt: time.Now()}); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		var listed []uuid.UUID
+		for after := uuid.Nil; ; {
+			page, err := repos.DataKeys.ListAfter(ctx, after, 1)
+			if err != nil {
+				t.Fatalf("ListAfter failed: %v", err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			listed = append(listed, page[0].WebhookKeyID)
+			after = page[0].WebhookKeyID
+		}
+		if len(listed) != 2 || strings.Compare(listed[0].String(), listed[1].String()) >= 0 {
+			t.Errorf("Expected both keys in ID order, got %v", listed)
+		}
+
+		if err := repos.DataKeys.Delete(ctx, wk.ID); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if err := repos.DataKeys.Delete(ctx, wk.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+		}
+
+		// Deleting the key pair removes its data key
+		if err := repos.Keys.DeleteKeyPair(ctx, other.KeyHash); err != nil {
+			t.Fatalf("DeleteKeyPair failed: %v", err)
+		}
+		if _, err := repos.DataKeys.Get(ctx, other.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected the data key to be deleted with its pair, got %v", err)
+		}
+	})
+}
+
+// TestParity_EventBlobs verifies blob references round-trip and are found for garbage collection
+func TestParity_EventBlobs(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		wk, _ := createPair(t, repos)
+
+		ref := strings.Repeat("ab", 32)
+		event := newEvent(wk.ID, time.Now().Add(time.Hour), nil)
+		event.Data = []byte{}
+		event.BlobRef = ref
+		if err := repos.Events.Create(ctx, event); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		inline := newEvent(wk.ID, time.Now().Add(time.Hour), nil)
+		if err := repos.Events.Create(ctx, inline); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		got, err := repos.Events.GetByID(ctx, event.ID)
+		if err != nil || got.BlobRef != ref {
+			t.Fatalf("Expected blob ref %q, got %+v (%v)", ref, got, err)
+		}
+		if got, _ := repos.Events.GetByID(ctx, inline.ID); got.BlobRef != "" {
+			t.Errorf("Expected no blob ref for an inline event, got %q", got.BlobRef)
+		}
+
+		orphan := strings.Repeat("cd", 32)
+		referenced, err := repos.Events.ReferencedBlobs(ctx, []string{ref, orphan})
+		if err != nil {
+			t.Fatalf("ReferencedBlobs failed: %v", err)
+		}
+		if !referenced[ref] || referenced[orphan] || len(referenced) != 1 {
+			t.Errorf("Unexpected references: %v", referenced)
+		}
+		if referenced, err := repos.Events.ReferencedBlobs(ctx, nil); err != nil || len(referenced) != 0 {
+			t.Errorf("Expected no references for an empty list, got %v (%v)", referenced, err)
+		}
+
+		if err := repos.Events.Delete(ctx, event.ID); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if referenced, _ := repos.Events.ReferencedBlobs(ctx, []string{ref}); referenced[ref] {
+			t.Error("Expected the blob to be unreferenced after its event was deleted")
+		}
+	})
+}
+
+// TestParity_StorageStats verifies size accounting totals
+func TestParity_StorageStats(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		wk, _ := createPair(t, repos)
+
+		compressed := newEvent(wk.ID, time.Now().Add(time.Hour), nil)
+		compressed.PayloadSize, compressed.StoredSize, compressed.Compressed = 1000, 200, true
+		plain := newEvent(wk.ID, time.Now().Add(time.Hour), nil)
+		plain.PayloadSize, plain.StoredSize = 50, 78
+		for _, e := range []*models.Event{compressed, plain} {
+			if err := repos.Events.Create(ctx, e); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+		if err := repos.Events.UpdateData(ctx, plain.ID, make([]byte, 80)); err != nil {
+			t.Fatalf("UpdateData failed: %v", err)
+		}
+
+		stats, err := repos.Events.StorageStats(ctx)
+		if err != nil {
+			t.Fatalf("StorageStats failed: %v", err)
+		}
+		want := models.EventStorageStats{Events: 2, MeasuredEvents: 2, CompressedEvents: 1, PayloadBytes: 1050, StoredBytes: 280}
+		if *stats != want {
+			t.Errorf("Expected %+v, got %+v", want, *stats)
+		}
+	})
+}
+
+// TestParity_StuckDeliveries verifies the stuck delivery listing and the
+// alert settings it reports
+func TestParity_StuckDeliveries(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		email, wk, _ := createUser(t, repos)
+		legacy, _ := createPair(t, repos)
+		now := time.Now()
+
+		old := newEvent(wk.ID, now.Add(time.Hour), nil)
+		old.CreatedAt = now.Add(-3 * time.Hour)
+		recent := newEvent(wk.ID, now.Add(time.Hour), nil)
+		later := now.Add(time.Hour)
+		scheduled := newEvent(wk.ID, now.Add(2*time.Hour), &later)
+		events := []*models.Event{old, recent, scheduled}
+		for i := 0; i < 3; i++ {
+			events = append(events, newEvent(legacy.ID, now.Add(time.Hour), nil))
+		}
+		for _, e := range events {
+			if err := repos.Events.Create(ctx, e); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+
+		stuck, err := repos.Events.ListStuckDeliveries(ctx, now.Add(-2*time.Hour), 0)
+		if err != nil {
+			t.Fatalf("ListStuckDeliveries failed: %v", err)
+		}
+		if len(stuck) != 1 {
+			t.Fatalf("Expected only the key with an old event, got %+v", stuck)
+		}
+		got := stuck[0]
+		if got.WebhookKeyID != wk.ID || got.KeyPrefix != wk.KeyPrefix || got.UserEmail != email || !got.AlertsEnabled || got.AlertedAt != nil {
+			t.Errorf("Unexpected stuck delivery: %+v", got)
+		}
+		if got.Undelivered != 2 || got.OldestDueAt.Sub(old.CreatedAt).Abs() > time.Second {
+			t.Errorf("Expected 2 due events since %v, got %d since %v", old.CreatedAt, got.Undelivered, got.OldestDueAt)
+		}
+
+		// A count threshold adds the key with many recent events, which has no owner
+		stuck, _ = repos.Events.ListStuckDeliveries(ctx, now.Add(-2*time.Hour), 3)
+		if len(stuck) != 2 || stuck[0].WebhookKeyID != wk.ID || stuck[1].WebhookKeyID != legacy.ID {
+			t.Fatalf("Expected both keys, oldest first, got %+v", stuck)
+		}
+		if stuck[1].Undelivered != 3 || stuck[1].UserEmail != "" || stuck[1].AlertsEnabled {
+			t.Errorf("Unexpected stuck delivery without an owner: %+v", stuck[1])
+		}
+
+		if err := repos.Keys.SetDeliveryAlertedAt(ctx, wk.ID, now); err != nil {
+			t.Fatalf("SetDeliveryAlertedAt failed: %v", err)
+		}
+		if err := repos.Keys.SetDeliveryAlertedAt(ctx, uuid.New(), now); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a missing key, got %v", err)
+		}
+		if err := repos.Users.SetDeliveryAlerts(ctx, email, false); err != nil {
+			t.Fatalf("SetDeliveryAlerts failed: %v", err)
+		}
+		if err := repos.Users.SetDeliveryAlerts(ctx, "missing@example.com", false); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a missing user, got %v", err)
+		}
+		// Upserting the profile keeps the opt-out
+		if err := repos.Users.Upsert(ctx, &models.UserProfile{Email: email, Name: "Parity", Language: "en"}); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+		if profile, err := repos.Users.GetByEmail(ctx, email); err != nil || profile.DeliveryAlerts {
+			t.Errorf("Expected delivery alerts off, got %+v (%v)", profile, err)
+		}
+
+		stuck, _ = repos.Events.ListStuckDeliveries(ctx, now.Add(-2*time.Hour), 0)
+		if len(stuck) != 1 || stuck[0].AlertsEnabled || stuck[0].AlertedAt == nil || stuck[0].AlertedAt.Sub(now).Abs() > time.Second {
+			t.Errorf("Expected the alert time and opt-out reported, got %+v", stuck)
+		}
+
+		// Delivered events and inactive keys are not stuck
+		for _, e := range []*models.Event{old, recent} {
+			if err := repos.Events.MarkAsProcessed(ctx, e.ID, time.Hour); err != nil {
+				t.Fatalf("MarkAsProcessed failed: %v", err)
+			}
+		}
+		if err := repos.Keys.UpdateKeyStatusByID(ctx, legacy.ID, models.KeyTypeWebhook, false); err != nil {
+			t.Fatalf("UpdateKeyStatusByID failed: %v", err)
+		}
+		if stuck, _ = repos.Events.ListStuckDeliveries(ctx, now, 1); len(stuck) != 0 {
+			t.Errorf("Expected nothing stuck, got %+v", stuck)
+		}
+	})
+}
+
+// TestParity_Retention verifies per-key retention overrides and expiry recomputation
+func TestParity_Retention(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		email, wk, _ := createUser(t, repos)
+
+		settings, err := repos.Keys.GetRetention(ctx, wk.ID)
+		if err != nil {
+			t.Fatalf("GetRetention failed: %v", err)
+		}
+		if settings.EventTTLDays != nil || settings.ProcessedTTLDays != nil {
+			t.Errorf("Expected no overrides on a new key, got %+v", settings)
+		}
+
+		days := 7
+		if err := repos.Keys.SetRetention(ctx, wk.ID, models.RetentionSettings{EventTTLDays: &days}); err != nil {
+			t.Fatalf("SetRetention failed: %v", err)
+		}
+		pairs, err := repos.Keys.ListUserKeyPairs(ctx, email)
+		if err != nil {
+			t.Fatalf("ListUserKeyPairs failed: %v", err)
+		}
+		if len(pairs) != 1 || pairs[0].Retention.EventTTLDays == nil || *pairs[0].Retention.EventTTLDays != 7 || pairs[0].Retention.ProcessedTTLDays != nil {
+			t.Errorf("Expected retention {7, nil} on the key pair, got %+v", pairs)
+		}
+
+		if err := repos.Keys.SetRetention(ctx, uuid.New(), models.RetentionSettings{}); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown key, got %v", err)
+		}
+
+		pending := newEvent(wk.ID, time.Now().Add(24*time.Hour), nil)
+		processed := newEvent(wk.ID, time.Now().Add(24*time.Hour), nil)
+		for _, e := range []*models.Event{pending, processed} {
+			if err := repos.Events.Create(ctx, e); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+		if err := repos.Events.MarkAsProcessed(ctx, processed.ID, 24*time.Hour); err != nil {
+			t.Fatalf("MarkAsProcessed failed: %v", err)
+		}
+
+		// Processed events expire immediately, pending ones get ten days
+		updated, err := repos.Events.ApplyRetention(ctx, wk.ID, 10*24*time.Hour, -time.Minute)
+		if err != nil {
+			t.Fatalf("ApplyRetention failed: %v", err)
+		}
+		if updated != 2 {
+			t.Errorf("Expected 2 events updated, got %d", updated)
+		}
+
+		got, err := repos.Events.GetByID(ctx, pending.ID)
+		if err != nil {
+			t.Fatalf("GetByID failed: %v", err)
+		}
+		if d := got.ExpiresAt.Sub(got.CreatedAt); d < 10*24*time.Hour-time.Second || d > 10*24*time.Hour+time.Second {
+			t.Errorf("Expected pending event to expire 10 days after creation, got %v", d)
+		}
+
+		deleted, err := repos.Events.DeleteExpired(ctx)
+		if err != nil {
+			t.Fatalf("DeleteExpired failed: %v", err)
+		}
+		if deleted != 1 {
+			t.Errorf("Expected only the processed event to be deleted, got %d", deleted)
+		}
+		if _, err := repos.Events.GetByID(ctx, processed.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected processed event to be deleted, got %v", err)
+		}
+	})
+}
+
+// TestParity_Cleanup verifies the batched deletes used by the cleanup tasks
+func TestParity_Cleanup(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		email, wk, _ := createUser(t, repos)
+
+		for i := 0; i < 3; i++ {
+			if err := repos.Events.Create(ctx, newEvent(wk.ID, time.Now().Add(-time.Minute), nil)); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+		live := newEvent(wk.ID, time.Now().Add(time.Hour), nil)
+		if err := repos.Events.Create(ctx, live); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		_, other, _ := createUser(t, repos)
+		otherExpired := newEvent(other.ID, time.Now().Add(-time.Minute), nil)
+		if err := repos.Events.Create(ctx, otherExpired); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if n, err := repos.Events.DeleteExpiredByKeyBatch(ctx, other.ID, 10); err != nil || n != 1 {
+			t.Errorf("Expected the other key's expired event deleted, got %d (err: %v)", n, err)
+		}
+		if n, err := repos.Events.DeleteExpiredByKeyBatch(ctx, other.ID, 10); err != nil || n != 0 {
+			t.Errorf("Expected nothing left for the other key, got %d (err: %v)", n, err)
+		}
+		if n, err := repos.Events.DeleteExpiredBatch(ctx, 2); err != nil || n != 2 {
+			t.Errorf("Expected first batch to delete 2, got %d (err: %v)", n, err)
+		}
+		if n, err := repos.Events.DeleteExpiredBatch(ctx, 2); err != nil || n != 1 {
+			t.Errorf("Expected second batch to delete 1, got %d (err: %v)", n, err)
+		}
+
+		if err := repos.WebhookLogs.Create(ctx, live.ID, wk.ID, 200); err != nil {
+			t.Fatalf("Create log failed: %v", err)
+		}
+		if n, err := repos.WebhookLogs.DeleteOrphaned(ctx, 10); err != nil || n != 0 {
+			t.Errorf("Expected no orphaned logs, got %d (err: %v)", n, err)
+		}
+
+		if err := repos.AuthTokens.StoreMagicLinkToken(ctx, email, "expired-"+uuid.NewString(), time.Now().Add(-time.Minute)); err != nil {
+			t.Fatalf("StoreMagicLinkToken failed: %v", err)
+		}
+		if n, err := repos.AuthTokens.ClearExpiredMagicLinkTokens(ctx, 10); err != nil || n != 1 {
+			t.Errorf("Expected 1 expired token cleared, got %d (err: %v)", n, err)
+		}
+
+		revoked, revokedClient := createPair(t, repos)
+		if err := repos.Keys.UpdateKeyStatus(ctx, revoked.KeyHash, models.KeyTypeWebhook, false); err != nil {
+			t.Fatalf("UpdateKeyStatus failed: %v", err)
+		}
+		// Half-revoked pairs are kept
+		if n, err := repos.Keys.DeleteInactiveKeyPairs(ctx, time.Now().Add(time.Minute), 10); err != nil || n != 0 {
+			t.Errorf("Expected no pairs deleted while the client key is active, got %d (err: %v)", n, err)
+		}
+		if err := repos.Keys.UpdateKeyStatus(ctx, revokedClient.KeyHash, models.KeyTypeClient, false); err != nil {
+			t.Fatalf("UpdateKeyStatus failed: %v", err)
+		}
+		if n, err := repos.Keys.DeleteInactiveKeyPairs(ctx, time.Now().Add(-time.Hour), 10); err != nil || n != 0 {
+			t.Errorf("Expected recently used pairs to be kept, got %d (err: %v)", n, err)
+		}
+		if n, err := repos.Keys.DeleteInactiveKeyPairs(ctx, time.Now().Add(time.Minute), 10); err != nil || n != 2 {
+			t.Errorf("Expected the revoked pair's 2 keys deleted, got %d (err: %v)", n, err)
+		}
+		if _, err := repos.Keys.GetWebhookKeyByHash(ctx, wk.KeyHash); err != nil {
+			t.Errorf("Expected the active user key to remain, got %v", err)
+		}
+	})
+}
+
+// TestParity_WebhookLogs verifies the delivery status lifecycle
+func TestParity_WebhookLogs(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		email, wk, _ := createUser(t, repos)
+		ck := &models.ClientKey{KeyHash: newKeyHash("ck_log_"), KeyPrefix: "ck_log_", WebhookKeyID: wk.ID}
+		if err := repos.Keys.CreateClientKey(ctx, ck); err != nil {
+			t.Fatalf("CreateClientKey failed: %v", err)
+		}
+
+		event := newEvent(wk.ID, time.Now().Add(time.Hour), nil)
+		if err := repos.Events.Create(ctx, event); err != nil {
+			t.Fatalf("Create event failed: %v", err)
+		}
+		if err := repos.WebhookLogs.Create(ctx, event.ID, wk.ID, 200); err != nil {
+			t.Fatalf("Create log failed: %v", err)
+		}
+
+		for _, step := range []struct {
+			apply func() error
+			want  string
+		}{
+			{func() error { return nil }, "pending"},
+			{func() error { return repos.WebhookLogs.MarkDelivered(ctx, event.ID, wk.ID, ck.ID) }, "delivered"},
+			{func() error { return repos.WebhookLogs.MarkAcked(ctx, event.ID) }, "acked"},
+		} {
+			if err := step.apply(); err != nil {
+				t.Fatalf("Transition to %s failed: %v", step.want, err)
+			}
+			status, err := repos.WebhookLogs.GetLatestStatus(ctx, event.ID)
+			if err != nil {
+				t.Fatalf("GetLatestStatus failed: %v", err)
+			}
+			if status != step.want {
+				t.Errorf("Expected status %s, got %s", step.want, status)
+			}
+		}
+
+		logs, err := repos.WebhookLogs.ListByUserEmail(ctx, email, 10, 0)
+		if err != nil {
+			t.Fatalf("ListByUserEmail failed: %v", err)
+		}
+		if len(logs) != 1 || logs[0].ClientKeyID == nil || *logs[0].ClientKeyID != ck.ID.String() || logs[0].AckedAt == nil {
+			t.Errorf("Unexpected logs: %+v", logs)
+		}
+
+		if _, err := repos.WebhookLogs.GetLatestStatus(ctx, uuid.New()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown event, got %v", err)
+		}
+	})
+}
+
+// TestParity_Admins verifies admin account storage
+func TestParity_Admins(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		before, err := repos.Admins.Count(ctx)
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+
+		admin := &models.AdminUser{
+			ID:           uuid.New(),
+			Username:     "parity_" + uuid.NewString(),
+			PasswordHash: "hash",
+			CreatedAt:    time.Now(),
+			IsActive:     true,
+		}
+		if err := repos.Admins.Create(ctx, admin); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if err := repos.Admins.UpdateLastLogin(ctx, admin.ID); err != nil {
+			t.Fatalf("UpdateLastLogin failed: %v", err)
+		}
+
+		got, err := repos.Admins.GetByUsername(ctx, admin.Username)
+		if err != nil {
+			t.Fatalf("GetByUsername failed: %v", err)
+		}
+		if got.ID != admin.ID || !got.IsActive || got.LastLogin == nil {
+			t.Errorf("Unexpected admin: %+v", got)
+		}
+
+		after, err := repos.Admins.Count(ctx)
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		if after != before+1 {
+			t.Errorf("Expected count %d, got %d", before+1, after)
+		}
+
+		if _, err := repos.Admins.GetByUsername(ctx, "missing_"+uuid.NewString()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown admin, got %v", err)
+		}
+	})
+}
+
+// TestParity_AdminManagement verifies roles, invites and deactivation
+func TestParity_AdminManagement(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+		tokenHash := newKeyHash("invite_")
+		invited := &models.AdminUser{
+			ID:              uuid.New(),
+			Username:        "parity_" + uuid.NewString(),
+			CreatedAt:       time.Now(),
+			IsActive:        true,
+			Role:            models.AdminRoleSupport,
+			InviteTokenHash: tokenHash,
+			InviteExpiresAt: &expiresAt,
+		}
+		if err := repos.Admins.Create(ctx, invited); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		// Admins without an invite must not collide on the invite index
+		for i := 0; i < 2; i++ {
+			if err := repos.Admins.Create(ctx, &models.AdminUser{
+				ID: uuid.New(), Username: "parity_" + uuid.NewString(), PasswordHash: "hash",
+				CreatedAt: time.Now(), IsActive: true, Role: models.AdminRoleOwner,
+			}); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+
+		got, err := repos.Admins.GetByInviteTokenHash(ctx, tokenHash)
+		if err != nil {
+			t.Fatalf("GetByInviteTokenHash failed: %v", err)
+		}
+		if got.ID != invited.ID || got.Role != models.AdminRoleSupport || !got.InvitePending() ||
+			got.InviteExpiresAt == nil || !got.InviteExpiresAt.Equal(expiresAt) {
+			t.Errorf("Unexpected invited admin: %+v", got)
+		}
+		if _, err := repos.Admins.GetByInviteTokenHash(ctx, newKeyHash("invite_")); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown invite, got %v", err)
+		}
+
+		if err := repos.Admins.AcceptInvite(ctx, invited.ID, "hash"); err != nil {
+			t.Fatalf("AcceptInvite failed: %v", err)
+		}
+		if err := repos.Admins.AcceptInvite(ctx, invited.ID, "hash"); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound accepting twice, got %v", err)
+		}
+		if _, err := repos.Admins.GetByInviteTokenHash(ctx, tokenHash); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected the invite to be gone, got %v", err)
+		}
+
+		if err := repos.Admins.UpdateRole(ctx, invited.ID, models.AdminRoleOperator); err != nil {
+			t.Fatalf("UpdateRole failed: %v", err)
+		}
+		if err := repos.Admins.SetActive(ctx, invited.ID, false); err != nil {
+			t.Fatalf("SetActive failed: %v", err)
+		}
+		got, err = repos.Admins.GetByID(ctx, invited.ID)
+		if err != nil {
+			t.Fatalf("GetByID failed: %v", err)
+		}
+		if got.PasswordHash != "hash" || got.InvitePending() || got.InviteExpiresAt != nil ||
+			got.Role != models.AdminRoleOperator || got.IsActive {
+			t.Errorf("Unexpected admin after changes: %+v", got)
+		}
+
+		for name, err := range map[string]error{
+			"UpdateRole": repos.Admins.UpdateRole(ctx, uuid.New(), models.AdminRoleOwner),
+			"SetActive":  repos.Admins.SetActive(ctx, uuid.New(), true),
+		} {
+			if !errors.Is(err, repositories.ErrNotFound) {
+				t.Errorf("%s: expected ErrNotFound for unknown admin, got %v", name, err)
+			}
+		}
+
+		admins, err := repos.Admins.List(ctx)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		found := false
+		for _, a := range admins {
+			found = found || a.ID == invited.ID
+		}
+		if len(admins) < 3 || !found {
+			t.Errorf("Expected List to include the new admins, got %d admins", len(admins))
+		}
+	})
+}
+
+// TestParity_AdminTOTP verifies two-factor secrets and recovery codes
+func TestParity_AdminTOTP(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		admin := &models.AdminUser{
+			ID:           uuid.New(),
+			Username:     "parity_" + uuid.NewString(),
+			PasswordHash: "hash",
+			CreatedAt:    time.Now(),
+			IsActive:     true,
+		}
+		if err := repos.Admins.Create(ctx, admin); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		if err := repos.Admins.EnableTOTP(ctx, admin.ID, []string{"a"}); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound enabling without a secret, got %v", err)
+		}
+		if err := repos.Admins.SetTOTPSecret(ctx, uuid.New(), []byte("secret")); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown admin, got %v", err)
+		}
+		if err := repos.Admins.SetTOTPSecret(ctx, admin.ID, []byte("secret")); err != nil {
+			t.Fatalf("SetTOTPSecret failed: %v", err)
+		}
+		if err := repos.Admins.EnableTOTP(ctx, admin.ID, []string{"a", "b"}); err != nil {
+			t.Fatalf("EnableTOTP failed: %v", err)
+		}
+
+		got, err := repos.Admins.GetByID(ctx, admin.ID)
+		if err != nil {
+			t.Fatalf("GetByID failed: %v", err)
+		}
+		if string(got.TOTPSecret) != "secret" || got.TOTPEnabledAt == nil || got.RecoveryCodesLeft != 2 {
+			t.Errorf("Unexpected admin after enabling: %+v", got)
+		}
+
+		if err := repos.Admins.UseRecoveryCode(ctx, admin.ID, "a"); err != nil {
+			t.Fatalf("UseRecoveryCode failed: %v", err)
+		}
+		for _, code := range []string{"a", "missing"} {
+			if err := repos.Admins.UseRecoveryCode(ctx, admin.ID, code); !errors.Is(err, repositories.ErrNotFound) {
+				t.Errorf("UseRecoveryCode(%q): expected ErrNotFound, got %v", code, err)
+			}
+		}
+
+		// Each TOTP step is used once; a new secret starts over
+		if err := repos.Admins.UseTOTPStep(ctx, admin.ID, 100); err != nil {
+			t.Fatalf("UseTOTPStep failed: %v", err)
+		}
+		for _, step := range []int64{100, 99} {
+			if err := repos.Admins.UseTOTPStep(ctx, admin.ID, step); !errors.Is(err, repositories.ErrNotFound) {
+				t.Errorf("UseTOTPStep(%d): expected ErrNotFound, got %v", step, err)
+			}
+		}
+		if err := repos.Admins.UseTOTPStep(ctx, admin.ID, 101); err != nil {
+			t.Errorf("UseTOTPStep with a later step failed: %v", err)
+		}
+		if err := repos.Admins.UseTOTPStep(ctx, uuid.New(), 1); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown admin, got %v", err)
+		}
+
+		// Regenerating codes replaces the unused ones and keeps the enable time
+		if err := repos.Admins.EnableTOTP(ctx, admin.ID, []string{"c", "d", "e"}); err != nil {
+			t.Fatalf("EnableTOTP failed: %v", err)
+		}
+		again, err := repos.Admins.GetByUsername(ctx, admin.Username)
+		if err != nil {
+			t.Fatalf("GetByUsername failed: %v", err)
+		}
+		if again.RecoveryCodesLeft != 3 || again.TOTPEnabledAt == nil || !again.TOTPEnabledAt.Equal(*got.TOTPEnabledAt) {
+			t.Errorf("Unexpected admin after regenerating codes: %+v", again)
+		}
+		if err := repos.Admins.UseRecoveryCode(ctx, admin.ID, "b"); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected an old code to be gone, got %v", err)
+		}
+
+		if err := repos.Admins.DisableTOTP(ctx, admin.ID); err != nil {
+			t.Fatalf("DisableTOTP failed: %v", err)
+		}
+		got, err = repos.Admins.GetByID(ctx, admin.ID)
+		if err != nil {
+			t.Fatalf("GetByID failed: %v", err)
+		}
+		if got.TOTPSecret != nil || got.TOTPEnabledAt != nil || got.RecoveryCodesLeft != 0 {
+			t.Errorf("Unexpected admin after disabling: %+v", got)
+		}
+		if err := repos.Admins.SetTOTPSecret(ctx, admin.ID, []byte("other")); err != nil {
+			t.Fatalf("SetTOTPSecret failed: %v", err)
+		}
+		if err := repos.Admins.UseTOTPStep(ctx, admin.ID, 50); err != nil {
+			t.Errorf("Expected a new secret to reset the last step, got %v", err)
+		}
+		if _, err := repos.Admins.GetByID(ctx, uuid.New()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown admin, got %v", err)
+		}
+	})
+}
+
+// TestParity_AuthTokens verifies magic link token storage
+func TestParity_AuthTokens(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		email, _, _ := createUser(t, repos)
+		token := "token_" + uuid.NewString()
+
+		if err := repos.AuthTokens.StoreMagicLinkToken(ctx, "nobody_"+uuid.NewString()+"@example.com", token, time.Now()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown email, got %v", err)
+		}
+		if err := repos.AuthTokens.StoreMagicLinkToken(ctx, email, token, time.Now().Add(15*time.Minute)); err != nil {
+			t.Fatalf("StoreMagicLinkToken failed: %v", err)
+		}
+
+		got, err := repos.AuthTokens.GetMagicLinkToken(ctx, token)
+		if err != nil {
+			t.Fatalf("GetMagicLinkToken failed: %v", err)
+		}
+		if got.Email != email || got.ExpiresAt == nil || got.UsedAt != nil {
+			t.Errorf("Unexpected token: %+v", got)
+		}
+
+		if err := repos.AuthTokens.MarkMagicLinkTokenUsed(ctx, token); err != nil {
+			t.Fatalf("MarkMagicLinkTokenUsed failed: %v", err)
+		}
+		got, err = repos.AuthTokens.GetMagicLinkToken(ctx, token)
+		if err != nil {
+			t.Fatalf("GetMagicLinkToken failed: %v", err)
+		}
+		if got.UsedAt == nil {
+			t.Error("Expected token to be marked as used")
+		}
+
+		if _, err := repos.AuthTokens.GetMagicLinkToken(ctx, "token_"+uuid.NewString()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown token, got %v", err)
+		}
+	})
+}
+
+func TestParity_APITokens(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		email := "parity_" + uuid.NewString() + "@example.com"
+		profile := &models.UserProfile{Email: email, Name: "Parity"}
+		if err := repos.Users.Upsert(ctx, profile); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+		newToken := func(name string, expiresAt time.Time) *models.APIToken {
+			t.Helper()
+			token := &models.APIToken{
+				ID:        uuid.New(),
+				UserID:    profile.ID,
+				Name:      name,
+				Prefix:    "owpat_abc",
+				TokenHash: "hash_" + uuid.NewString(),
+				Scopes:    []string{models.ScopeKeysRead, models.ScopeLogsRead},
+				ExpiresAt: &expiresAt,
+				CreatedAt: time.Now().UTC().Truncate(time.Second),
+			}
+			if err := repos.APITokens.Create(ctx, token); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			return token
+		}
+
+		old := newToken("old", time.Now().Add(-2*time.Hour))
+		ci := newToken("ci", time.Now().Add(time.Hour))
+
+		got, err := repos.APITokens.GetByHash(ctx, ci.TokenHash)
+		if err != nil {
+			t.Fatalf("GetByHash failed: %v", err)
+		}
+		if got.ID != ci.ID || got.UserID != profile.ID || got.UserEmail != email || strings.Join(got.Scopes, ",") != "keys:read,logs:read" || got.LastUsedAt != nil {
+			t.Errorf("Unexpected token: %+v", got)
+		}
+		if _, err := repos.APITokens.GetByHash(ctx, "hash_missing"); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown hash, got %v", err)
+		}
+
+		if err := repos.APITokens.TouchLastUsed(ctx, ci.ID, time.Now()); err != nil {
+			t.Fatalf("TouchLastUsed failed: %v", err)
+		}
+		if got, _ := repos.APITokens.GetByHash(ctx, ci.TokenHash); got.LastUsedAt == nil {
+			t.Error("Expected last use to be recorded")
+		}
+
+		tokens, err := repos.APITokens.ListByUser(ctx, profile.ID)
+		if err != nil {
+			t.Fatalf("ListByUser failed: %v", err)
+		}
+		if len(tokens) != 2 || tokens[0].ID != ci.ID || tokens[1].ID != old.ID {
+			t.Errorf("Expected tokens newest first, got %+v", tokens)
+		}
+
+		if err := repos.APITokens.Revoke(ctx, ci.ID, uuid.New()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound revoking another user's token, got %v", err)
+		}
+		if err := repos.APITokens.Revoke(ctx, ci.ID, profile.ID); err != nil {
+			t.Fatalf("Revoke failed: %v", err)
+		}
+		if err := repos.APITokens.Revoke(ctx, ci.ID, profile.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound revoking twice, got %v", err)
+		}
+
+		// Only the token that expired before the cutoff goes; the revoked one is kept for now
+		deleted, err := repos.APITokens.DeleteExpired(ctx, time.Now().Add(-time.Hour), 10)
+		if err != nil || deleted != 1 {
+			t.Fatalf("Expected 1 deleted token, got %d (%v)", deleted, err)
+		}
+		deleted, err = repos.APITokens.DeleteExpired(ctx, time.Now().Add(time.Minute), 10)
+		if err != nil || deleted != 1 {
+			t.Fatalf("Expected the revoked token to be deleted, got %d (%v)", deleted, err)
+		}
+		if tokens, _ := repos.APITokens.ListByUser(ctx, profile.ID); len(tokens) != 0 {
+			t.Errorf("Expected no tokens left, got %d", len(tokens))
+		}
+	})
+}
+
+func TestParity_Sessions(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		email, _, _ := createUser(t, repos)
+		user, err := repos.Users.GetByEmail(ctx, email)
+		if err != nil {
+			t.Fatalf("GetByEmail failed: %v", err)
+		}
+		admin := &models.AdminUser{ID: uuid.New(), Username: "parity_" + uuid.NewString(), PasswordHash: "hash", CreatedAt: time.Now(), IsActive: true}
+		if err := repos.Admins.Create(ctx, admin); err != nil {
+			t.Fatalf("Create admin failed: %v", err)
+		}
+
+		newSession := func(userID, adminID *uuid.UUID, lastSeen, expiresAt time.Time) *models.Session {
+			t.Helper()
+			session := &models.Session{
+				ID:         uuid.New(),
+				UserID:     userID,
+				AdminID:    adminID,
+				IP:         "192.0.2.1",
+				UserAgent:  "Firefox",
+				CreatedAt:  time.Now().UTC().Truncate(time.Second),
+				LastSeenAt: lastSeen.UTC().Truncate(time.Second),
+				ExpiresAt:  expiresAt.UTC().Truncate(time.Second),
+			}
+			if err := repos.Sessions.Create(ctx, session); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			return session
+		}
+
+		now := time.Now()
+		laptop := newSession(&user.ID, nil, now.Add(-time.Hour), now.Add(time.Hour))
+		phone := newSession(&user.ID, nil, now, now.Add(time.Hour))
+		expired := newSession(&user.ID, nil, now.Add(-3*time.Hour), now.Add(-2*time.Hour))
+		adminSession := newSession(nil, &admin.ID, now, now.Add(time.Hour))
+
+		got, err := repos.Sessions.Get(ctx, adminSession.ID)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got.UserID != nil || got.AdminID == nil || *got.AdminID != admin.ID || got.UserAgent != "Firefox" || got.RevokedAt != nil {
+			t.Errorf("Unexpected session: %+v", got)
+		}
+		if _, err := repos.Sessions.Get(ctx, uuid.New()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for unknown session, got %v", err)
+		}
+
+		sessions, err := repos.Sessions.ListActiveByUser(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("ListActiveByUser failed: %v", err)
+		}
+		if len(sessions) != 2 || sessions[0].ID != phone.ID || sessions[1].ID != laptop.ID {
+			t.Errorf("Expected active sessions most recently seen first, got %+v", sessions)
+		}
+
+		if err := repos.Sessions.TouchLastSeen(ctx, laptop.ID, now.Add(time.Minute)); err != nil {
+			t.Fatalf("TouchLastSeen failed: %v", err)
+		}
+		if sessions, _ := repos.Sessions.ListActiveByUser(ctx, user.ID); len(sessions) != 2 || sessions[0].ID != laptop.ID {
+			t.Errorf("Expected the touched session first, got %+v", sessions)
+		}
+
+		if err := repos.Sessions.RevokeUserSession(ctx, laptop.ID, uuid.New()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound revoking another user's session, got %v", err)
+		}
+		if err := repos.Sessions.RevokeUserSession(ctx, adminSession.ID, user.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound revoking an admin session as a user, got %v", err)
+		}
+		if err := repos.Sessions.RevokeUserSession(ctx, laptop.ID, user.ID); err != nil {
+			t.Fatalf("RevokeUserSession failed: %v", err)
+		}
+		if got, _ := repos.Sessions.Get(ctx, laptop.ID); got.RevokedAt == nil {
+			t.Error("Expected the session to be revoked")
+		}
+
+		// Only the phone is still active; the expired session is not counted
+		revoked, err := repos.Sessions.RevokeAllByUser(ctx, user.ID)
+		if err != nil || revoked != 1 {
+			t.Fatalf("Expected 1 revoked session, got %d (%v)", revoked, err)
+		}
+		if sessions, _ := repos.Sessions.ListActiveByUser(ctx, user.ID); len(sessions) != 0 {
+			t.Errorf("Expected no active sessions, got %d", len(sessions))
+		}
+
+		if err := repos.Sessions.Revoke(ctx, adminSession.ID); err != nil {
+			t.Fatalf("Revoke failed: %v", err)
+		}
+		if err := repos.Sessions.Revoke(ctx, adminSession.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound revoking twice, got %v", err)
+		}
+
+		// Signing an admin out everywhere leaves user sessions alone
+		otherAdminSession := newSession(nil, &admin.ID, now, now.Add(time.Hour))
+		revoked, err = repos.Sessions.RevokeAllByAdmin(ctx, admin.ID)
+		if err != nil || revoked != 1 {
+			t.Fatalf("Expected 1 revoked admin session, got %d (%v)", revoked, err)
+		}
+		if got, _ := repos.Sessions.Get(ctx, otherAdminSession.ID); got.RevokedAt == nil {
+			t.Error("Expected the admin session to be revoked")
+		}
+
+		// Only the session that expired before the cutoff goes; revoked ones are kept for now
+		deleted, err := repos.Sessions.DeleteExpired(ctx, now.Add(-time.Hour), 10)
+		if err != nil || deleted != 1 {
+			t.Fatalf("Expected 1 deleted session, got %d (%v)", deleted, err)
+		}
+		if _, err := repos.Sessions.Get(ctx, expired.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected the expired session to be deleted, got %v", err)
+		}
+		deleted, err = repos.Sessions.DeleteExpired(ctx, time.Now().Add(time.Minute), 10)
+		if err != nil || deleted != 4 {
+			t.Fatalf("Expected the revoked sessions to be deleted, got %d (%v)", deleted, err)
+		}
+	})
+}
+
+func TestParity_KeyRotation(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		email, wk, ck := createUser(t, repos)
+		pairID := wk.ID
+		oldClient := ck.KeyHash
+
+		if err := repos.Keys.RotateKey(ctx, uuid.New(), models.KeyTypeWebhook, newKeyHash("wh_rotated_"), "wh_rotat", nil); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown pair, got %v", err)
+		}
+
+		// Old webhook value keeps resolving during the overlap
+		newWebhook := newKeyHash("wh_rotated_")
+		until := time.Now().Add(time.Hour)
+		if err := repos.Keys.RotateKey(ctx, pairID, models.KeyTypeWebhook, newWebhook, "wh_rotat", &until); err != nil {
+			t.Fatalf("RotateKey failed: %v", err)
+		}
+		for _, value := range []string{wk.KeyHash, newWebhook} {
+			got, err := repos.Keys.GetWebhookKeyByHash(ctx, value)
+			if err != nil || got.ID != pairID || got.KeyHash != newWebhook || got.KeyPrefix != "wh_rotat" || !got.IsActive() {
+				t.Errorf("GetWebhookKeyByHash(%q): %+v, %v", value, got, err)
+			}
+			if active, err := repos.Keys.ValidateWebhookKey(ctx, value); err != nil || !active {
+				t.Errorf("ValidateWebhookKey(%q): %v, %v", value, active, err)
+			}
+			if got, err := repos.Keys.GetEmailByWebhookKeyHash(ctx, value); err != nil || got != email {
+				t.Errorf("GetEmailByWebhookKeyHash(%q): %q, %v", value, got, err)
+			}
+		}
+
+		// Client side retired at once
+		newClient := newKeyHash("ck_rotated_")
+		if err := repos.Keys.RotateKey(ctx, pairID, models.KeyTypeClient, newClient, "ck_rotat", nil); err != nil {
+			t.Fatalf("RotateKey failed: %v", err)
+		}
+		if _, err := repos.Keys.GetClientKeyByHash(ctx, oldClient); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected the old client key to be gone, got %v", err)
+		}
+		if ck, err := repos.Keys.GetClientKeyByHash(ctx, newClient); err != nil || ck.WebhookKeyID != pairID {
+			t.Errorf("GetClientKeyByHash: %+v, %v", ck, err)
+		}
+
+		pairs, err := repos.Keys.ListUserKeyPairs(ctx, email)
+		if err != nil || len(pairs) != 1 {
+			t.Fatalf("ListUserKeyPairs: %d pairs, %v", len(pairs), err)
+		}
+		p := pairs[0]
+		if p.WebhookKeyPrefix != "wh_rotat" || p.ClientKeyPrefix != "ck_rotat" || p.PreviousWebhookKeyExpiresAt == nil || p.PreviousClientKeyExpiresAt != nil {
+			t.Errorf("Unexpected pair after rotation: %+v", p)
+		}
+
+		// Once the overlap has passed the old value no longer resolves
+		past := time.Now().Add(-time.Minute)
+		newerWebhook := newKeyHash("wh_rotated_")
+		if err := repos.Keys.RotateKey(ctx, pairID, models.KeyTypeWebhook, newerWebhook, "wh_rotat", &past); err != nil {
+			t.Fatalf("RotateKey failed: %v", err)
+		}
+		if _, err := repos.Keys.GetWebhookKeyByHash(ctx, newWebhook); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected the expired value to be rejected, got %v", err)
+		}
+		if _, err := repos.Keys.GetWebhookKeyByHash(ctx, wk.KeyHash); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected the value from two rotations ago to be rejected, got %v", err)
+		}
+		if pairs, _ := repos.Keys.ListUserKeyPairs(ctx, email); pairs[0].PreviousWebhookKeyExpiresAt != nil {
+			t.Errorf("Expected no overlap to be listed, got %v", pairs[0].PreviousWebhookKeyExpiresAt)
+		}
+
+		cleared, err := repos.Keys.ClearExpiredRotations(ctx, time.Now(), 10)
+		if err != nil || cleared != 1 {
+			t.Errorf("Expected 1 cleared rotation, got %d (%v)", cleared, err)
+		}
+	})
+}
+
+func TestParity_NamedWebhookKeys(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		email, wk, _ := createUser(t, repos)
+		pairID := wk.ID
+
+		limit, forced, secret := 5, "Inbox/zapier", "whsec_test"
+		allowed := []string{"Inbox/**", "Daily/*.md"}
+		named := &models.WebhookKey{
+			Name:      "zapier",
+			KeyHash:   newKeyHash("wh_named_"),
+			KeyPrefix: "wh_named_",
+			Settings:  models.WebhookKeySettings{RateLimitPerMinute: &limit, SigningSecret: &secret, AllowedPaths: allowed, ForcedPathPrefix: &forced},
+		}
+		if err := repos.Keys.CreatePairWebhookKey(ctx, pairID, "other@example.com", named); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for another user's pair, got %v", err)
+		}
+		if err := repos.Keys.CreatePairWebhookKey(ctx, pairID, email, named); err != nil {
+			t.Fatalf("CreatePairWebhookKey failed: %v", err)
+		}
+		if named.ID == pairID || named.PairID != pairID || !named.IsActive() || named.IsPrimary() {
+			t.Errorf("Unexpected named key: %+v", named)
+		}
+
+		got, err := repos.Keys.GetWebhookKeyByHash(ctx, named.KeyHash)
+		if err != nil || got.ID != named.ID || got.PairID != pairID || got.Name != "zapier" {
+			t.Fatalf("GetWebhookKeyByHash: %+v, %v", got, err)
+		}
+		s := got.Settings
+		if s.RateLimitPerMinute == nil || *s.RateLimitPerMinute != 5 || s.SigningSecret == nil || *s.SigningSecret != secret ||
+			len(s.AllowedPaths) != 2 || s.AllowedPaths[1] != "Daily/*.md" || s.ForcedPathPrefix == nil || *s.ForcedPathPrefix != forced {
+			t.Errorf("Unexpected settings: %+v", s)
+		}
+		if got, err := repos.Keys.GetEmailByWebhookKeyHash(ctx, named.KeyHash); err != nil || got != email {
+			t.Errorf("GetEmailByWebhookKeyHash: %q, %v", got, err)
+		}
+		if primary, _ := repos.Keys.GetWebhookKeyByHash(ctx, wk.KeyHash); !primary.IsPrimary() || primary.PairID != pairID {
+			t.Errorf("Expected the original key to be primary, got %+v", primary)
+		}
+
+		// Usage is counted per key; the pair lists every key, the original first
+		if err := repos.Keys.IncrementUsage(ctx, named.ID); err != nil {
+			t.Fatalf("IncrementUsage failed: %v", err)
+		}
+		pairs, err := repos.Keys.ListUserKeyPairs(ctx, email)
+		if err != nil || len(pairs) != 1 {
+			t.Fatalf("Expected the named key not to add a pair, got %d (%v)", len(pairs), err)
+		}
+		keys := pairs[0].WebhookKeys
+		if len(keys) != 2 || keys[0].ID != pairID || keys[1].ID != named.ID || keys[1].EventsCount != 1 || keys[0].EventsCount != 0 {
+			t.Fatalf("Unexpected webhook keys: %+v", keys)
+		}
+		if pairs[0].WebhookKeyPrefix != wk.KeyPrefix {
+			t.Errorf("Expected the pair to show its original webhook key, got %q", pairs[0].WebhookKeyPrefix)
+		}
+
+		// Settings are replaced as a whole
+		if err := repos.Keys.UpdateWebhookKeySettings(ctx, named.ID, email, "ifttt", models.WebhookKeySettings{}); err != nil {
+			t.Fatalf("UpdateWebhookKeySettings failed: %v", err)
+		}
+		got, _ = repos.Keys.GetWebhookKeyByHash(ctx, named.KeyHash)
+		if s := got.Settings; got.Name != "ifttt" || s.RateLimitPerMinute != nil || s.SigningSecret != nil || s.AllowedPaths != nil || s.ForcedPathPrefix != nil {
+			t.Errorf("Unexpected key after update: %+v", got)
+		}
+		if err := repos.Keys.UpdateWebhookKeySettings(ctx, named.ID, "other@example.com", "x", models.WebhookKeySettings{}); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound updating another user's key, got %v", err)
+		}
+
+		// Rotation leaves named keys alone
+		if err := repos.Keys.RotateKey(ctx, pairID, models.KeyTypeWebhook, newKeyHash("wh_rotated_"), "wh_rotat", nil); err != nil {
+			t.Fatalf("RotateKey failed: %v", err)
+		}
+		if got, err := repos.Keys.GetWebhookKeyByHash(ctx, named.KeyHash); err != nil || got.KeyHash != named.KeyHash {
+			t.Errorf("Expected the named key to survive rotation: %+v, %v", got, err)
+		}
+
+		// Only named keys are revoked on their own
+		if err := repos.Keys.DeactivateUserWebhookKey(ctx, pairID, email); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound revoking the original key, got %v", err)
+		}
+		if err := repos.Keys.DeactivateUserWebhookKey(ctx, named.ID, email); err != nil {
+			t.Fatalf("DeactivateUserWebhookKey failed: %v", err)
+		}
+		if active, err := repos.Keys.ValidateWebhookKey(ctx, named.KeyHash); err != nil || active {
+			t.Errorf("Expected the named key to be inactive: %v, %v", active, err)
+		}
+		if err := repos.Keys.DeactivateUserWebhookKey(ctx, named.ID, email); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected revoking twice to fail, got %v", err)
+		}
+	})
+}
+
+func TestParity_Audit(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		actor := "parity_" + uuid.NewString()
+		email := actor + "@example.com"
+		start := time.Now().UTC().Truncate(time.Microsecond)
+
+		// The seal sees the chain position before the event is stored
+		seal := func(e *models.AuditEvent) { e.Hash = fmt.Sprintf("%d|%s", e.Seq, e.PrevHash) }
+		actions := []string{models.AuditActionKeyActivate, models.AuditActionKeyDeactivate, models.AuditActionAPITokenCreate, models.AuditActionKeyRotate}
+		var appended []models.AuditEvent
+		for i, action := range actions {
+			event := models.AuditEvent{
+				ID:         uuid.New(),
+				CreatedAt:  start.Add(time.Duration(i) * time.Minute),
+				ActorType:  models.AuditActorAdmin,
+				ActorID:    actor,
+				ActorName:  "root",
+				Action:     action,
+				TargetType: models.AuditTargetKeyPair,
+				TargetID:   fmt.Sprintf("target-%d", i),
+				UserEmail:  email,
+				IP:         "192.0.2.1",
+				RequestID:  "req-" + uuid.NewString(),
+			}
+			if i > 0 {
+				event.Before = json.RawMessage(`{"active": true,"n":1}`)
+				event.After = json.RawMessage(`{"active":false}`)
+			}
+			if err := repos.Audit.Append(ctx, &event, seal); err != nil {
+				t.Fatalf("Append failed: %v", err)
+			}
+			appended = append(appended, event)
+		}
+		for i := 1; i < len(appended); i++ {
+			prev, e := appended[i-1], appended[i]
+			if e.Seq != prev.Seq+1 || e.PrevHash != prev.Hash || e.Hash != fmt.Sprintf("%d|%s", e.Seq, e.PrevHash) {
+				t.Errorf("Event %d not chained to the one before: %+v", i, e)
+			}
+		}
+
+		// Everything round-trips as appended, including state bytes and empty states
+		listed, err := repos.Audit.List(ctx, models.AuditFilter{ActorID: actor, Limit: 10})
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(listed) != len(appended) {
+			t.Fatalf("Expected %d events, got %d", len(appended), len(listed))
+		}
+		for i, got := range listed {
+			want := appended[len(appended)-1-i]
+			if got.Seq != want.Seq || got.ID != want.ID || !got.CreatedAt.Equal(want.CreatedAt) || got.ActorType != want.ActorType ||
+				got.ActorName != want.ActorName || got.Action != want.Action || got.TargetType != want.TargetType || got.TargetID != want.TargetID ||
+				got.UserEmail != want.UserEmail || got.IP != want.IP || got.RequestID != want.RequestID ||
+				string(got.Before) != string(want.Before) || string(got.After) != string(want.After) ||
+				got.PrevHash != want.PrevHash || got.Hash != want.Hash {
+				t.Errorf("Event %d did not round-trip:\n got  %+v\n want %+v", want.Seq, got, want)
+			}
+		}
+		if listed[len(listed)-1].Before != nil {
+			t.Errorf("Expected an empty state to stay empty, got %q", listed[len(listed)-1].Before)
+		}
+
+		since, until := start.Add(time.Minute), start.Add(3*time.Minute)
+		filterTests := []struct {
+			name   string
+			filter models.AuditFilter
+			want   []int
+		}{
+			{"user", models.AuditFilter{UserEmail: email}, []int{3, 2, 1, 0}},
+			{"exact action", models.AuditFilter{UserEmail: email, Action: models.AuditActionKeyDeactivate}, []int{1}},
+			{"action prefix", models.AuditFilter{UserEmail: email, Action: "key."}, []int{3, 1, 0}},
+			{"action without dot is exact", models.AuditFilter{UserEmail: email, Action: "key"}, nil},
+			{"target", models.AuditFilter{UserEmail: email, TargetID: "target-2"}, []int{2}},
+			{"time range", models.AuditFilter{UserEmail: email, Since: &since, Until: &until}, []int{2, 1}},
+			{"before", models.AuditFilter{UserEmail: email, BeforeSeq: appended[2].Seq}, []int{1, 0}},
+			{"limit", models.AuditFilter{UserEmail: email, Limit: 2}, []int{3, 2}},
+		}
+		for _, tt := range filterTests {
+			if tt.filter.Limit == 0 {
+				tt.filter.Limit = 10
+			}
+			events, err := repos.Audit.List(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("%s: List failed: %v", tt.name, err)
+			}
+			var got []int
+			for _, e := range events {
+				got = append(got, int(e.Seq-appended[0].Seq))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%s: expected events %v, got %v", tt.name, tt.want, got)
+			}
+		}
+
+		after, err := repos.Audit.ListAfter(ctx, appended[0].Seq, 2)
+		if err != nil {
+			t.Fatalf("ListAfter failed: %v", err)
+		}
+		if len(after) != 2 || after[0].Seq != appended[1].Seq || after[1].Seq != appended[2].Seq {
+			t.Errorf("Expected the two events after the first in chain order, got %+v", after)
+		}
+	})
+}
+
+func TestParity_DeleteUser(t *testing.T) {
+	runParity(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		email, wk, ck := createUser(t, repos)
+		otherEmail, otherWK, _ := createUser(t, repos)
+		profile, err := repos.Users.GetByEmail(ctx, email)
+		if err != nil {
+			t.Fatalf("GetByEmail failed: %v", err)
+		}
+
+		// Everything the user owns, and a bit of the same for someone else
+		for _, key := range []*models.WebhookKey{wk, otherWK} {
+			event := newEvent(key.ID, time.Now().Add(time.Hour), nil)
+			if err := repos.Events.Create(ctx, event); err != nil {
+				t.Fatalf("Create event failed: %v", err)
+			}
+			if err := repos.WebhookLogs.Create(ctx, event.ID, key.ID, 200); err != nil {
+				t.Fatalf("Create log failed: %v", err)
+			}
+			if err := repos.DataKeys.Create(ctx, &models.DataKey{WebhookKeyID: key.ID, KeyID: "dek-" + uuid.NewString()[:12], WrappedKey: []byte("x"), CreatedAt: time.Now()}); err != nil {
+				t.Fatalf("Create data key failed: %v", err)
+			}
+		}
+		named := &models.WebhookKey{KeyHash: newKeyHash("wh_named_"), KeyPrefix: "wh_named", Name: "zapier"}
+		if err := repos.Keys.CreatePairWebhookKey(ctx, wk.ID, email, named); err != nil {
+			t.Fatalf("CreatePairWebhookKey failed: %v", err)
+		}
+		token := &models.APIToken{ID: uuid.New(), UserID: profile.ID, Name: "cli", Prefix: "owpat_del", TokenHash: "hash_" + uuid.NewString(),
+			Scopes: []string{models.ScopeKeysRead}, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+		if err := repos.APITokens.Create(ctx, token); err != nil {
+			t.Fatalf("Create token failed: %v", err)
+		}
+		session := &models.Session{ID: uuid.New(), UserID: &profile.ID, CreatedAt: time.Now().UTC().Truncate(time.Second),
+			LastSeenAt: time.Now().UTC().Truncate(time.Second), ExpiresAt: time.Now().Add(time.Hour).UTC().Truncate(time.Second)}
+		if err := repos.Sessions.Create(ctx, session); err != nil {
+			t.Fatalf("Create session failed: %v", err)
+		}
+
+		if err := repos.Users.Delete(ctx, email); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		if _, err := repos.Users.GetByEmail(ctx, email); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected the user to be gone, got %v", err)
+		}
+		for _, hash := range []string{wk.KeyHash, named.KeyHash} {
+			if _, err := repos.Keys.GetWebhookKeyByHash(ctx, hash); !errors.Is(err, repositories.ErrNotFound) {
+				t.Errorf("Expected the webhook key to be gone, got %v", err)
+			}
+		}
+		if _, err := repos.Keys.GetClientKeyByHash(ctx, ck.KeyHash); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected the client key to be gone, got %v", err)
+		}
+		if n, err := repos.Events.CountByWebhookKey(ctx, wk.ID); err != nil || n != 0 {
+			t.Errorf("Expected the user's events to be gone, got %d (%v)", n, err)
+		}
+		if _, err := repos.DataKeys.Get(ctx, wk.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected the data key to be gone, got %v", err)
+		}
+		if n, err := repos.WebhookLogs.DeleteOrphaned(ctx, 100); err != nil || n != 0 {
+			t.Errorf("Expected no webhook logs left behind, got %d (%v)", n, err)
+		}
+		if _, err := repos.APITokens.GetByHash(ctx, token.TokenHash); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected the API token to be gone, got %v", err)
+		}
+		if _, err := repos.Sessions.Get(ctx, session.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected the session to be gone, got %v", err)
+		}
+
+		// The other user is untouched
+		if _, err := repos.Keys.GetWebhookKeyByHash(ctx, otherWK.KeyHash); err != nil {
+			t.Errorf("Expected the other user's key to survive: %v", err)
+		}
+		if n, err := repos.WebhookLogs.CountByUserEmail(ctx, otherEmail); err != nil || n != 1 {
+			t.Errorf("Expected the other user's log to survive, got %d (%v)", n, err)
+		}
+		if _, err := repos.DataKeys.Get(ctx, otherWK.ID); err != nil {
+			t.Errorf("Expected the other user's data key to survive: %v", err)
+		}
+
+		if err := repos.Users.Delete(ctx, email); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+		}
+	})
+}
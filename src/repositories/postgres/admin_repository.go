@@ -0,0 +1,233 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// AdminRepository stores admin accounts in the admin_users table
+type AdminRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewAdminRepository creates a new PostgreSQL admin repository
+func NewAdminRepository(pool *pgxpool.Pool) *AdminRepository {
+	return &AdminRepository{pool: pool}
+}
+
+// Create inserts a new admin user
+func (r *AdminRepository) Create(ctx context.Context, admin *models.AdminUser) error {
+	var inviteTokenHash *string // NULL keeps the unique index to pending invites
+	if admin.InviteTokenHash != "" {
+		inviteTokenHash = &admin.InviteTokenHash
+	}
+	return r.pool.QueryRow(ctx, `
+		INSERT INTO admin_users (id, username, password_hash, created_at, is_active, role, invite_token_hash, invite_expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING last_login
+	`, admin.ID, admin.Username, admin.PasswordHash, admin.CreatedAt, admin.IsActive, string(admin.Role),
+		inviteTokenHash, admin.InviteExpiresAt).Scan(&admin.LastLogin)
+}
+
+// adminColumns is the admin_users projection scanned by scanAdmin
+const adminColumns = `id, username, password_hash, created_at, last_login, is_active, role,
+	COALESCE(invite_token_hash, ''), invite_expires_at, totp_secret, totp_enabled_at,
+	(SELECT COUNT(*) FROM admin_recovery_codes rc WHERE rc.admin_id = admin_users.id AND rc.used_at IS NULL)`
+
+// scanAdmin scans a row selected with adminColumns
+func scanAdmin(row pgx.Row) (*models.AdminUser, error) {
+	admin := &models.AdminUser{}
+	err := row.Scan(&admin.ID, &admin.Username, &admin.PasswordHash, &admin.CreatedAt, &admin.LastLogin, &admin.IsActive,
+		&admin.Role, &admin.InviteTokenHash, &admin.InviteExpiresAt, &admin.TOTPSecret, &admin.TOTPEnabledAt, &admin.RecoveryCodesLeft)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return admin, nil
+}
+
+// GetByUsername retrieves an admin user by username, active or not
+func (r *AdminRepository) GetByUsername(ctx context.Context, username string) (*models.AdminUser, error) {
+	return scanAdmin(r.pool.QueryRow(ctx, "SELECT "+adminColumns+" FROM admin_users WHERE username = $1", username))
+}
+
+// GetByID retrieves an admin user by ID, active or not
+func (r *AdminRepository) GetByID(ctx context.Context, adminID uuid.UUID) (*models.AdminUser, error) {
+	return scanAdmin(r.pool.QueryRow(ctx, "SELECT "+adminColumns+" FROM admin_users WHERE id = $1", adminID))
+}
+
+// UpdateLastLogin sets last_login to now
+func (r *AdminRepository) UpdateLastLogin(ctx context.Context, adminID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `UPDATE admin_users SET last_login = NOW() WHERE id = $1`, adminID)
+	return err
+}
+
+// Count returns the number of admin users
+func (r *AdminRepository) Count(ctx context.Context) (int, error) {
+	var count int
+	err := r.pool.QueryRow(ctx, "SELECT COUNT(*) FROM admin_users").Scan(&count)
+	return count, err
+}
+
+// List returns every admin user, oldest first
+func (r *AdminRepository) List(ctx context.Context) ([]models.AdminUser, error) {
+	rows, err := r.pool.Query(ctx, "SELECT "+adminColumns+" FROM admin_users ORDER BY created_at, username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var admins []models.AdminUser
+	for rows.Next() {
+		admin, err := scanAdmin(rows)
+		if err != nil {
+			return nil, err
+		}
+		admins = append(admins, *admin)
+	}
+	return admins, rows.Err()
+}
+
+// GetByInviteTokenHash returns the admin whose pending invite has tokenHash
+func (r *AdminRepository) GetByInviteTokenHash(ctx context.Context, tokenHash string) (*models.AdminUser, error) {
+	return scanAdmin(r.pool.QueryRow(ctx, "SELECT "+adminColumns+" FROM admin_users WHERE invite_token_hash = $1", tokenHash))
+}
+
+// AcceptInvite sets an invited admin's password and clears the invite
+func (r *AdminRepository) AcceptInvite(ctx context.Context, adminID uuid.UUID, passwordHash string) error {
+	return r.updateOne(ctx, `
+		UPDATE admin_users SET password_hash = $2, invite_token_hash = NULL, invite_expires_at = NULL
+		WHERE id = $1 AND invite_token_hash IS NOT NULL
+	`, adminID, passwordHash)
+}
+
+// UpdateRole changes an admin's role
+func (r *AdminRepository) UpdateRole(ctx context.Context, adminID uuid.UUID, role models.AdminRole) error {
+	return r.updateOne(ctx, "UPDATE admin_users SET role = $2 WHERE id = $1", adminID, string(role))
+}
+
+// SetActive activates or deactivates an admin
+func (r *AdminRepository) SetActive(ctx context.Context, adminID uuid.UUID, active bool) error {
+	return r.updateOne(ctx, "UPDATE admin_users SET is_active = $2 WHERE id = $1", adminID, active)
+}
+
+// updateOne runs an update of one admin, mapping no affected rows to ErrNotFound
+func (r *AdminRepository) updateOne(ctx context.Context, query string, args ...any) error {
+	result, err := r.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// SetTOTPSecret stores a secret awaiting confirmation and drops recovery codes
+func (r *AdminRepository) SetTOTPSecret(ctx context.Context, adminID uuid.UUID, secret []byte) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	result, err := tx.Exec(ctx, "UPDATE admin_users SET totp_secret = $2, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = $1", adminID, secret)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM admin_recovery_codes WHERE admin_id = $1", adminID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// EnableTOTP confirms the stored secret and replaces the recovery codes
+func (r *AdminRepository) EnableTOTP(ctx context.Context, adminID uuid.UUID, recoveryCodeHashes []string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	result, err := tx.Exec(ctx, `
+		UPDATE admin_users SET totp_enabled_at = COALESCE(totp_enabled_at, NOW())
+		WHERE id = $1 AND totp_secret IS NOT NULL
+	`, adminID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM admin_recovery_codes WHERE admin_id = $1", adminID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO admin_recovery_codes (admin_id, code_hash)
+		SELECT $1, unnest($2::text[])
+	`, adminID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DisableTOTP removes the secret and recovery codes
+func (r *AdminRepository) DisableTOTP(ctx context.Context, adminID uuid.UUID) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	result, err := tx.Exec(ctx, "UPDATE admin_users SET totp_secret = NULL, totp_enabled_at = NULL WHERE id = $1", adminID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM admin_recovery_codes WHERE admin_id = $1", adminID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UseRecoveryCode marks an unused recovery code used
+func (r *AdminRepository) UseRecoveryCode(ctx context.Context, adminID uuid.UUID, codeHash string) error {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE admin_recovery_codes SET used_at = NOW()
+		WHERE admin_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, adminID, codeHash)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// UseTOTPStep records the last TOTP time step the admin used
+func (r *AdminRepository) UseTOTPStep(ctx context.Context, adminID uuid.UUID, step int64) error {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE admin_users SET totp_last_step = $2
+		WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)
+	`, adminID, step)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+var _ repositories.AdminRepository = (*AdminRepository)(nil)
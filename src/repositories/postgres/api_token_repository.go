@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// APITokenRepository stores personal access tokens in the api_tokens table
+type APITokenRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewAPITokenRepository creates a new PostgreSQL API token repository
+func NewAPITokenRepository(pool *pgxpool.Pool) *APITokenRepository {
+	return &APITokenRepository{pool: pool}
+}
+
+// apiTokenColumns is the column list scanned by scanAPIToken, selected from
+// apiTokenTables so the owner's current email comes with each token
+const apiTokenColumns = `t.id, t.user_id, u.email, t.name, t.prefix, t.token_hash, t.scopes, t.expires_at, t.last_used_at, t.revoked_at, t.created_at`
+
+const apiTokenTables = `api_tokens t JOIN users u ON u.id = t.user_id`
+
+// scanAPIToken scans a row selected with apiTokenColumns
+func scanAPIToken(row pgx.Row) (models.APIToken, error) {
+	var t models.APIToken
+	var scopes string
+	err := row.Scan(&t.ID, &t.UserID, &t.UserEmail, &t.Name, &t.Prefix, &t.TokenHash, &scopes, &t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt, &t.CreatedAt)
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	return t, err
+}
+
+// Create stores a new token
+func (r *APITokenRepository) Create(ctx context.Context, token *models.APIToken) error {
+	_, err := r.pool.Exec(ctx,
+		`INSERT INTO api_tokens (id, user_id, name, prefix, token_hash, scopes, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		token.ID, token.UserID, token.Name, token.Prefix, token.TokenHash,
+		strings.Join(token.Scopes, ","), token.ExpiresAt, token.CreatedAt,
+	)
+	return err
+}
+
+// GetByHash looks up a token by the hash of its value
+func (r *APITokenRepository) GetByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	t, err := scanAPIToken(r.pool.QueryRow(ctx,
+		`SELECT `+apiTokenColumns+` FROM `+apiTokenTables+` WHERE t.token_hash = $1`,
+		tokenHash,
+	))
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return &t, nil
+}
+
+// ListByUser returns a user's tokens, newest first
+func (r *APITokenRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.APIToken, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT `+apiTokenColumns+` FROM `+apiTokenTables+` WHERE t.user_id = $1 ORDER BY t.created_at DESC, t.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// Revoke marks one of a user's tokens revoked
+func (r *APITokenRepository) Revoke(ctx context.Context, tokenID, userID uuid.UUID) error {
+	result, err := r.pool.Exec(ctx,
+		`UPDATE api_tokens SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		tokenID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// TouchLastUsed records when a token was last used
+func (r *APITokenRepository) TouchLastUsed(ctx context.Context, tokenID uuid.UUID, at time.Time) error {
+	result, err := r.pool.Exec(ctx, `UPDATE api_tokens SET last_used_at = $1 WHERE id = $2`, at, tokenID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteExpired deletes up to limit tokens that expired or were revoked before cutoff
+func (r *APITokenRepository) DeleteExpired(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	result, err := r.pool.Exec(ctx,
+		`DELETE FROM api_tokens WHERE id IN (
+			SELECT id FROM api_tokens WHERE expires_at < $1 OR revoked_at < $1 LIMIT $2
+		)`,
+		cutoff, limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// auditChainLock is the advisory lock key serializing appends to the audit chain
+const auditChainLock = 0x61756469 // "audi"
+
+// AuditRepository stores the audit log in the audit_events table
+type AuditRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewAuditRepository creates a new PostgreSQL audit repository
+func NewAuditRepository(pool *pgxpool.Pool) *AuditRepository {
+	return &AuditRepository{pool: pool}
+}
+
+// auditColumns is the column list scanned by scanAuditEvent
+const auditColumns = `seq, id, created_at, actor_type, actor_id, actor_name, action, target_type, target_id,
+	user_email, ip, request_id, before_state, after_state, prev_hash, hash`
+
+// scanAuditEvent scans a row selected with auditColumns
+func scanAuditEvent(row pgx.Row) (models.AuditEvent, error) {
+	var e models.AuditEvent
+	var before, after *string
+	err := row.Scan(&e.Seq, &e.ID, &e.CreatedAt, &e.ActorType, &e.ActorID, &e.ActorName, &e.Action, &e.TargetType, &e.TargetID,
+		&e.UserEmail, &e.IP, &e.RequestID, &before, &after, &e.PrevHash, &e.Hash)
+	if before != nil {
+		e.Before = json.RawMessage(*before)
+	}
+	if after != nil {
+		e.After = json.RawMessage(*after)
+	}
+	return e, err
+}
+
+// auditState stores an empty before or after state as NULL. States are kept
+// as text, not JSONB, which would reformat them and break their hashes.
+func auditState(state json.RawMessage) *string {
+	if len(state) == 0 {
+		return nil
+	}
+	s := string(state)
+	return &s
+}
+
+// Append adds an event to the end of the chain. A transaction-scoped advisory
+// lock holds the chain: locking the last row would not stop two appends from
+// both reading it.
+func (r *AuditRepository) Append(ctx context.Context, event *models.AuditEvent, seal func(*models.AuditEvent)) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", auditChainLock); err != nil {
+		return fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+
+	var lastSeq int64
+	var lastHash string
+	err = tx.QueryRow(ctx, "SELECT seq, hash FROM audit_events ORDER BY seq DESC LIMIT 1").Scan(&lastSeq, &lastHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	event.Seq = lastSeq + 1
+	event.PrevHash = lastHash
+	seal(event)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO audit_events (`+auditColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+	`, event.Seq, event.ID, event.CreatedAt.UTC(), string(event.ActorType), event.ActorID, event.ActorName, event.Action,
+		event.TargetType, event.TargetID, event.UserEmail, event.IP, event.RequestID,
+		auditState(event.Before), auditState(event.After), event.PrevHash, event.Hash)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// List returns events matching filter, newest first
+func (r *AuditRepository) List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	var conds []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.ActorID != "" {
+		conds = append(conds, "actor_id = "+arg(filter.ActorID))
+	}
+	if strings.HasSuffix(filter.Action, ".") {
+		conds = append(conds, fmt.Sprintf("left(action, %s) = %s", arg(len(filter.Action)), arg(filter.Action)))
+	} else if filter.Action != "" {
+		conds = append(conds, "action = "+arg(filter.Action))
+	}
+	if filter.TargetID != "" {
+		conds = append(conds, "target_id = "+arg(filter.TargetID))
+	}
+	if filter.UserEmail != "" {
+		conds = append(conds, "user_email = "+arg(filter.UserEmail))
+	}
+	if filter.Since != nil {
+		conds = append(conds, "created_at >= "+arg(filter.Since.UTC()))
+	}
+	if filter.Until != nil {
+		conds = append(conds, "created_at < "+arg(filter.Until.UTC()))
+	}
+	if filter.BeforeSeq > 0 {
+		conds = append(conds, "seq < "+arg(filter.BeforeSeq))
+	}
+
+	query := "SELECT " + auditColumns + " FROM audit_events"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY seq DESC LIMIT " + arg(filter.Limit)
+
+	return r.query(ctx, query, args...)
+}
+
+// ListAfter returns up to limit events after afterSeq, in chain order
+func (r *AuditRepository) ListAfter(ctx context.Context, afterSeq int64, limit int) ([]models.AuditEvent, error) {
+	return r.query(ctx, "SELECT "+auditColumns+" FROM audit_events WHERE seq > $1 ORDER BY seq LIMIT $2", afterSeq, limit)
+}
+
+// query runs a select of auditColumns
+func (r *AuditRepository) query(ctx context.Context, query string, args ...any) ([]models.AuditEvent, error) {
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+var _ repositories.AuditRepository = (*AuditRepository)(nil)
@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// AuthTokenRepository stores magic link tokens on the user's row
+type AuthTokenRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewAuthTokenRepository creates a new PostgreSQL auth token repository
+func NewAuthTokenRepository(pool *pgxpool.Pool) *AuthTokenRepository {
+	return &AuthTokenRepository{pool: pool}
+}
+
+// StoreMagicLinkToken replaces the user's magic link token
+func (r *AuthTokenRepository) StoreMagicLinkToken(ctx context.Context, userEmail, token string, expiresAt time.Time) error {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE users
+		SET magic_link_token = $1,
+		    magic_link_expires_at = $2,
+		    magic_link_used_at = NULL
+		WHERE email = $3
+	`, token, expiresAt, userEmail)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// GetMagicLinkToken looks up a magic link token
+func (r *AuthTokenRepository) GetMagicLinkToken(ctx context.Context, token string) (*models.MagicLinkToken, error) {
+	t := &models.MagicLinkToken{Token: token}
+	err := r.pool.QueryRow(ctx, `
+		SELECT email, magic_link_expires_at, magic_link_used_at
+		FROM users
+		WHERE magic_link_token = $1
+	`, token).Scan(&t.Email, &t.ExpiresAt, &t.UsedAt)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return t, nil
+}
+
+// MarkMagicLinkTokenUsed records that a token has been consumed
+func (r *AuthTokenRepository) MarkMagicLinkTokenUsed(ctx context.Context, token string) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE users
+		SET magic_link_used_at = NOW()
+		WHERE magic_link_token = $1
+	`, token)
+	return err
+}
+
+// ClearExpiredMagicLinkTokens clears up to limit expired magic link tokens
+func (r *AuthTokenRepository) ClearExpiredMagicLinkTokens(ctx context.Context, limit int) (int64, error) {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE users
+		SET magic_link_token = NULL,
+		    magic_link_expires_at = NULL,
+		    magic_link_used_at = NULL
+		WHERE id IN (
+			SELECT id FROM users
+			WHERE magic_link_token IS NOT NULL AND magic_link_expires_at < NOW()
+			LIMIT $1
+		)
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+var _ repositories.AuthTokenRepository = (*AuthTokenRepository)(nil)
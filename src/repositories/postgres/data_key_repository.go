@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// DataKeyRepository stores wrapped data encryption keys in the data_keys table
+type DataKeyRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewDataKeyRepository creates a new PostgreSQL data key repository
+func NewDataKeyRepository(pool *pgxpool.Pool) *DataKeyRepository {
+	return &DataKeyRepository{pool: pool}
+}
+
+// Get retrieves a key pair's data key
+func (r *DataKeyRepository) Get(ctx context.Context, webhookKeyID uuid.UUID) (*models.DataKey, error) {
+	var k models.DataKey
+	err := r.pool.QueryRow(ctx,
+		`SELECT webhook_key_id, key_id, wrapped_key, created_at, updated_at FROM data_keys WHERE webhook_key_id = $1`,
+		webhookKeyID,
+	).Scan(&k.WebhookKeyID, &k.KeyID, &k.WrappedKey, &k.CreatedAt, &k.UpdatedAt)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return &k, nil
+}
+
+// Create stores a data key unless the pair already has one
+func (r *DataKeyRepository) Create(ctx context.Context, key *models.DataKey) error {
+	_, err := r.pool.Exec(ctx,
+		`INSERT INTO data_keys (webhook_key_id, key_id, wrapped_key, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $4)
+		 ON CONFLICT (webhook_key_id) DO NOTHING`,
+		key.WebhookKeyID, key.KeyID, key.WrappedKey, key.CreatedAt,
+	)
+	return err
+}
+
+// Delete removes a key pair's data key
+func (r *DataKeyRepository) Delete(ctx context.Context, webhookKeyID uuid.UUID) error {
+	result, err := r.pool.Exec(ctx, `DELETE FROM data_keys WHERE webhook_key_id = $1`, webhookKeyID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// ListAfter returns up to limit data keys after afterID in webhook key ID order
+func (r *DataKeyRepository) ListAfter(ctx context.Context, afterID uuid.UUID, limit int) ([]models.DataKey, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT webhook_key_id, key_id, wrapped_key, created_at, updated_at
+		 FROM data_keys
+		 WHERE webhook_key_id > $1
+		 ORDER BY webhook_key_id ASC
+		 LIMIT $2`,
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.DataKey
+	for rows.Next() {
+		var k models.DataKey
+		if err := rows.Scan(&k.WebhookKeyID, &k.KeyID, &k.WrappedKey, &k.CreatedAt, &k.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan data key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// UpdateWrappedKey replaces the wrapping of a data key that is still keyID
+func (r *DataKeyRepository) UpdateWrappedKey(ctx context.Context, webhookKeyID uuid.UUID, keyID string, wrappedKey []byte) error {
+	result, err := r.pool.Exec(ctx,
+		`UPDATE data_keys SET wrapped_key = $1, updated_at = NOW() WHERE webhook_key_id = $2 AND key_id = $3`,
+		wrappedKey, webhookKeyID, keyID,
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// eventColumns is the column list scanned by scanEvent
+const eventColumns = `id, webhook_key_id, path, data, processed, processed_at, created_at, expires_at, deliver_after, sealed, blob_ref, write_mode`
+
+// EventRepository stores webhook events in the events table
+type EventRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewEventRepository creates a new PostgreSQL event repository
+func NewEventRepository(pool *pgxpool.Pool) *EventRepository {
+	return &EventRepository{pool: pool}
+}
+
+// scanEvent scans a row selected with eventColumns
+func scanEvent(row pgx.Row) (models.Event, error) {
+	var e models.Event
+	var blobRef *string
+	err := row.Scan(&e.ID, &e.WebhookKeyID, &e.Path, &e.Data, &e.Processed, &e.ProcessedAt, &e.CreatedAt, &e.ExpiresAt, &e.DeliverAfter, &e.Sealed, &blobRef, &e.Mode)
+	if blobRef != nil {
+		e.BlobRef = *blobRef
+	}
+	return e, err
+}
+
+// queryEvents runs a query selecting eventColumns and collects the rows
+func (r *EventRepository) queryEvents(ctx context.Context, sql string, args ...interface{}) ([]models.Event, error) {
+	rows, err := r.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Create inserts a new event
+func (r *EventRepository) Create(ctx context.Context, event *models.Event) error {
+	_, err := r.pool.Exec(ctx,
+		`INSERT INTO events (id, webhook_key_id, path, data, processed, created_at, expires_at, deliver_after, sealed, blob_ref, payload_size, stored_size, compressed, write_mode)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		event.ID, event.WebhookKeyID, event.Path, event.Data, event.Processed, event.CreatedAt, event.ExpiresAt, event.DeliverAfter, event.Sealed, blobRefValue(event.BlobRef),
+		event.PayloadSize, event.StoredSize, event.Compressed, event.Mode,
+	)
+	return err
+}
+
+// GetByID retrieves an event by ID
+func (r *EventRepository) GetByID(ctx context.Context, eventID uuid.UUID) (*models.Event, error) {
+	e, err := scanEvent(r.pool.QueryRow(ctx,
+		`SELECT `+eventColumns+` FROM events WHERE id = $1`,
+		eventID,
+	))
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return &e, nil
+}
+
+// GetUnprocessed returns due, unprocessed events for a webhook key in delivery order
+func (r *EventRepository) GetUnprocessed(ctx context.Context, webhookKeyID uuid.UUID) ([]models.Event, error) {
+	return r.queryEvents(ctx,
+		`SELECT `+eventColumns+`
+		 FROM events
+		 WHERE webhook_key_id = $1 AND processed = false
+		   AND (deliver_after IS NULL OR deliver_after <= NOW())
+		 ORDER BY COALESCE(deliver_after, created_at) ASC`,
+		webhookKeyID,
+	)
+}
+
+// GetByWebhookKey returns the newest events for a webhook key
+func (r *EventRepository) GetByWebhookKey(ctx context.Context, webhookKeyID uuid.UUID, limit int) ([]models.Event, error) {
+	return r.queryEvents(ctx,
+		`SELECT `+eventColumns+`
+		 FROM events
+		 WHERE webhook_key_id = $1
+		 ORDER BY created_at DESC
+		 LIMIT $2`,
+		webhookKeyID, limit,
+	)
+}
+
+// GetScheduledDue returns unprocessed events whose deliver_after falls in (from, to]
+func (r *EventRepository) GetScheduledDue(ctx context.Context, from, to time.Time) ([]models.Event, error) {
+	return r.queryEvents(ctx,
+		`SELECT `+eventColumns+`
+		 FROM events
+		 WHERE processed = false AND deliver_after > $1 AND deliver_after <= $2
+		 ORDER BY deliver_after ASC`,
+		from, to,
+	)
+}
+
+// ListAfter returns up to limit events with an ID greater than afterID in ID order
+func (r *EventRepository) ListAfter(ctx context.Context, afterID uuid.UUID, limit int) ([]models.Event, error) {
+	return r.queryEvents(ctx,
+		`SELECT `+eventColumns+`
+		 FROM events
+		 WHERE id > $1
+		 ORDER BY id ASC
+		 LIMIT $2`,
+		afterID, limit,
+	)
+}
+
+// UpdateData replaces an event's stored data, keeping a recorded stored size current
+func (r *EventRepository) UpdateData(ctx context.Context, eventID uuid.UUID, data []byte) error {
+	result, err := r.pool.Exec(ctx,
+		`UPDATE events SET data = $1, stored_size = CASE WHEN stored_size IS NULL THEN NULL ELSE $2::BIGINT END WHERE id = $3`,
+		data, len(data), eventID,
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// ReferencedBlobs reports which of refs are referenced by an event
+func (r *EventRepository) ReferencedBlobs(ctx context.Context, refs []string) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	if len(refs) == 0 {
+		return referenced, nil
+	}
+	rows, err := r.pool.Query(ctx, `SELECT DISTINCT blob_ref FROM events WHERE blob_ref = ANY($1)`, refs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ref string
+		if err := rows.Scan(&ref); err != nil {
+			return nil, err
+		}
+		referenced[ref] = true
+	}
+	return referenced, rows.Err()
+}
+
+// MarkAsProcessed marks an event as processed and restarts its expiry from now
+func (r *EventRepository) MarkAsProcessed(ctx context.Context, eventID uuid.UUID, processedTTL time.Duration) error {
+	now := time.Now()
+	result, err := r.pool.Exec(ctx,
+		`UPDATE events SET processed = true, processed_at = $1, expires_at = $2 WHERE id = $3`,
+		now, now.Add(processedTTL), eventID,
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// Delete deletes an event
+func (r *EventRepository) Delete(ctx context.Context, eventID uuid.UUID) error {
+	result, err := r.pool.Exec(ctx, "DELETE FROM events WHERE id = $1", eventID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteExpired deletes events past their expires_at
+func (r *EventRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := r.pool.Exec(ctx, "DELETE FROM events WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// DeleteExpiredBatch deletes at most limit events past their expires_at
+func (r *EventRepository) DeleteExpiredBatch(ctx context.Context, limit int) (int64, error) {
+	result, err := r.pool.Exec(ctx, `
+		DELETE FROM events
+		WHERE id IN (SELECT id FROM events WHERE expires_at < NOW() LIMIT $1)
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// DeleteExpiredByKeyBatch deletes at most limit of a webhook key's expired events
+func (r *EventRepository) DeleteExpiredByKeyBatch(ctx context.Context, webhookKeyID uuid.UUID, limit int) (int64, error) {
+	result, err := r.pool.Exec(ctx, `
+		DELETE FROM events
+		WHERE id IN (SELECT id FROM events WHERE webhook_key_id = $1 AND expires_at < NOW() LIMIT $2)
+	`, webhookKeyID, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// ApplyRetention recomputes expires_at for all of a webhook key's events
+func (r *EventRepository) ApplyRetention(ctx context.Context, webhookKeyID uuid.UUID, unprocessedTTL, processedTTL time.Duration) (int64, error) {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE events
+		SET expires_at = CASE
+			WHEN processed THEN COALESCE(processed_at, NOW()) + make_interval(secs => $2)
+			ELSE COALESCE(deliver_after, created_at) + make_interval(secs => $3)
+		END
+		WHERE webhook_key_id = $1
+	`, webhookKeyID, processedTTL.Seconds(), unprocessedTTL.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// CountUndelivered counts unprocessed events older than the given duration
+func (r *EventRepository) CountUndelivered(ctx context.Context, olderThan time.Duration) (int, error) {
+	var count int
+	err := r.pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM events WHERE processed = false AND created_at < NOW() - make_interval(secs => $1)`,
+		int(olderThan.Seconds()),
+	).Scan(&count)
+	return count, err
+}
+
+// ListStuckDeliveries returns active webhook keys whose due, unprocessed
+// events are old or many enough, oldest first
+func (r *EventRepository) ListStuckDeliveries(ctx context.Context, dueBefore time.Time, minCount int) ([]models.StuckDelivery, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT k.id, COALESCE(k.key_prefix, ''), COALESCE(k.name, ''), COALESCE(u.email, ''), COALESCE(u.delivery_alerts, false),
+		       COUNT(*), MIN(COALESCE(e.deliver_after, e.created_at)) AS oldest_due_at, k.delivery_alerted_at
+		FROM events e
+		JOIN api_keys k ON k.id = e.webhook_key_id
+		LEFT JOIN users u ON u.id = k.user_id
+		WHERE e.processed = false AND COALESCE(e.deliver_after, e.created_at) <= NOW()
+		  AND k.key_type = 'webhook' AND k.is_active = true
+		GROUP BY k.id, u.email, u.delivery_alerts
+		HAVING MIN(COALESCE(e.deliver_after, e.created_at)) <= $1 OR ($2::int > 0 AND COUNT(*) >= $2::int)
+		ORDER BY oldest_due_at ASC, k.id
+	`, dueBefore, minCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stuck []models.StuckDelivery
+	for rows.Next() {
+		var s models.StuckDelivery
+		if err := rows.Scan(&s.WebhookKeyID, &s.KeyPrefix, &s.KeyName, &s.UserEmail, &s.AlertsEnabled,
+			&s.Undelivered, &s.OldestDueAt, &s.AlertedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan stuck delivery: %w", err)
+		}
+		stuck = append(stuck, s)
+	}
+	return stuck, rows.Err()
+}
+
+// CountByWebhookKey counts all events for a webhook key
+func (r *EventRepository) CountByWebhookKey(ctx context.Context, webhookKeyID uuid.UUID) (int, error) {
+	var count int
+	err := r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM events WHERE webhook_key_id = $1`, webhookKeyID).Scan(&count)
+	return count, err
+}
+
+// CountByUserEmail counts events across all of a user's webhook keys
+func (r *EventRepository) CountByUserEmail(ctx context.Context, userEmail string) (int, error) {
+	var count int
+	err := r.pool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM events
+		WHERE webhook_key_id IN (
+			SELECT id FROM api_keys WHERE user_id = `+userIDByEmail(1)+` AND key_type = 'webhook'
+		)
+	`, userEmail).Scan(&count)
+	return count, err
+}
+
+var _ repositories.EventRepository = (*EventRepository)(nil)
+
+// StorageStats sums recorded sizes; events from before sizes were recorded only count towards Events
+func (r *EventRepository) StorageStats(ctx context.Context) (*models.EventStorageStats, error) {
+	var stats models.EventStorageStats
+	err := r.pool.QueryRow(ctx, `
+		SELECT COUNT(*), COUNT(payload_size), COUNT(*) FILTER (WHERE compressed),
+		       COALESCE(SUM(payload_size), 0), COALESCE(SUM(stored_size), 0)
+		FROM events
+	`).Scan(&stats.Events, &stats.MeasuredEvents, &stats.CompressedEvents, &stats.PayloadBytes, &stats.StoredBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,757 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// KeyRepository stores webhook and client keys in the api_keys table
+type KeyRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewKeyRepository creates a new PostgreSQL key repository
+func NewKeyRepository(pool *pgxpool.Pool) *KeyRepository {
+	return &KeyRepository{pool: pool}
+}
+
+// statusFromBool converts is_active to the status string used by key models
+func statusFromBool(isActive bool) string {
+	if isActive {
+		return string(models.KeyStatusActive)
+	}
+	return string(models.KeyStatusInactive)
+}
+
+// keyHashMatch matches a key by its hash, or by the hash it replaced while
+// the rotation overlap lasts. The key hash is always $1.
+const keyHashMatch = `(key_hash = $1 OR (previous_key_hash = $1 AND previous_key_expires_at > NOW()))`
+
+// webhookKeyColumns are the api_keys columns read by scanWebhookKey
+const webhookKeyColumns = `id, COALESCE(pair_id, id), COALESCE(name, ''), key_hash, COALESCE(key_prefix, ''), is_active, created_at, last_used, usage_count,
+	rate_limit_per_minute, signing_secret, allowed_paths, forced_path_prefix`
+
+// scanWebhookKey scans a row selected with webhookKeyColumns
+func scanWebhookKey(row pgx.Row) (*models.WebhookKey, error) {
+	var wk models.WebhookKey
+	var isActive bool
+	var allowedPaths *string
+	err := row.Scan(
+		&wk.ID, &wk.PairID, &wk.Name, &wk.KeyHash, &wk.KeyPrefix, &isActive, &wk.CreatedAt, &wk.LastUsed, &wk.EventsCount,
+		&wk.Settings.RateLimitPerMinute, &wk.Settings.SigningSecret, &allowedPaths, &wk.Settings.ForcedPathPrefix,
+	)
+	if err != nil {
+		return nil, err
+	}
+	wk.Status = statusFromBool(isActive)
+	wk.Settings.AllowedPaths = splitPathGlobs(allowedPaths)
+	return &wk, nil
+}
+
+// joinPathGlobs stores path globs one per line, NULL when there are none
+func joinPathGlobs(globs []string) *string {
+	if len(globs) == 0 {
+		return nil
+	}
+	joined := strings.Join(globs, "\n")
+	return &joined
+}
+
+// splitPathGlobs reverses joinPathGlobs
+func splitPathGlobs(stored *string) []string {
+	if stored == nil || *stored == "" {
+		return nil
+	}
+	return strings.Split(*stored, "\n")
+}
+
+// validateKey returns the is_active flag of a key of the given type
+func (r *KeyRepository) validateKey(ctx context.Context, keyHash string, keyType models.KeyType) (bool, error) {
+	var isActive bool
+	err := r.pool.QueryRow(ctx,
+		`SELECT is_active FROM api_keys WHERE `+keyHashMatch+` AND key_type = $2`,
+		keyHash, string(keyType),
+	).Scan(&isActive)
+	if err != nil {
+		return false, mapNoRows(err)
+	}
+	return isActive, nil
+}
+
+// ValidateWebhookKey returns whether the webhook key is active
+func (r *KeyRepository) ValidateWebhookKey(ctx context.Context, keyHash string) (bool, error) {
+	return r.validateKey(ctx, keyHash, models.KeyTypeWebhook)
+}
+
+// ValidateClientKey returns whether the client key is active
+func (r *KeyRepository) ValidateClientKey(ctx context.Context, keyHash string) (bool, error) {
+	return r.validateKey(ctx, keyHash, models.KeyTypeClient)
+}
+
+// GetWebhookKeyByHash retrieves a webhook key by its hash
+func (r *KeyRepository) GetWebhookKeyByHash(ctx context.Context, keyHash string) (*models.WebhookKey, error) {
+	wk, err := scanWebhookKey(r.pool.QueryRow(ctx,
+		"SELECT "+webhookKeyColumns+" FROM api_keys WHERE "+keyHashMatch+" AND key_type = 'webhook'",
+		keyHash,
+	))
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return wk, nil
+}
+
+// GetClientKeyByHash retrieves a client key by its hash
+func (r *KeyRepository) GetClientKeyByHash(ctx context.Context, keyHash string) (*models.ClientKey, error) {
+	var ck models.ClientKey
+	var isActive bool
+	var pairID *uuid.UUID
+	err := r.pool.QueryRow(ctx,
+		"SELECT id, key_hash, COALESCE(key_prefix, ''), pair_id, is_active, created_at, last_used, usage_count FROM api_keys WHERE "+keyHashMatch+" AND key_type = 'client'",
+		keyHash,
+	).Scan(&ck.ID, &ck.KeyHash, &ck.KeyPrefix, &pairID, &isActive, &ck.CreatedAt, &ck.LastConnected, &ck.EventsDelivered)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	if pairID != nil {
+		ck.WebhookKeyID = *pairID
+	}
+	ck.Status = statusFromBool(isActive)
+	return &ck, nil
+}
+
+// GetKeyByID retrieves basic key information by ID
+func (r *KeyRepository) GetKeyByID(ctx context.Context, keyID uuid.UUID) (*models.KeyInfo, error) {
+	var info models.KeyInfo
+	err := r.pool.QueryRow(ctx,
+		`SELECT id, COALESCE(key_prefix, ''), key_type, is_active,
+		        COALESCE((SELECT email FROM users WHERE users.id = api_keys.user_id), '')
+		 FROM api_keys WHERE id = $1`,
+		keyID,
+	).Scan(&info.ID, &info.KeyPrefix, &info.KeyType, &info.IsActive, &info.UserEmail)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return &info, nil
+}
+
+// GetEmailByWebhookKeyHash returns the user email associated with a webhook key hash
+func (r *KeyRepository) GetEmailByWebhookKeyHash(ctx context.Context, keyHash string) (string, error) {
+	var email string
+	err := r.pool.QueryRow(ctx,
+		"SELECT COALESCE((SELECT email FROM users WHERE users.id = api_keys.user_id), '') FROM api_keys WHERE "+keyHashMatch+" AND key_type = 'webhook'",
+		keyHash,
+	).Scan(&email)
+	if err != nil {
+		return "", mapNoRows(err)
+	}
+	return email, nil
+}
+
+// CreateWebhookKey inserts a standalone webhook key and fills in generated fields
+func (r *KeyRepository) CreateWebhookKey(ctx context.Context, key *models.WebhookKey) error {
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	var isActive bool
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO api_keys (id, key_hash, key_prefix, key_type, pair_id, is_active)
+		VALUES ($1, $2, $3, 'webhook', $1, true)
+		RETURNING is_active, created_at, usage_count
+	`, key.ID, key.KeyHash, key.KeyPrefix).Scan(&isActive, &key.CreatedAt, &key.EventsCount)
+	if err != nil {
+		return err
+	}
+	key.Status = statusFromBool(isActive)
+	return nil
+}
+
+// CreateClientKey inserts a client key paired with key.WebhookKeyID
+func (r *KeyRepository) CreateClientKey(ctx context.Context, key *models.ClientKey) error {
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	var isActive bool
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO api_keys (id, key_hash, key_prefix, key_type, pair_id, is_active)
+		VALUES ($1, $2, $3, 'client', $4, true)
+		RETURNING is_active, created_at, usage_count
+	`, key.ID, key.KeyHash, key.KeyPrefix, key.WebhookKeyID).Scan(&isActive, &key.CreatedAt, &key.EventsDelivered)
+	if err != nil {
+		return err
+	}
+	key.Status = statusFromBool(isActive)
+	return nil
+}
+
+// UpdateKeyStatus sets is_active for a key of the given type
+func (r *KeyRepository) UpdateKeyStatus(ctx context.Context, keyHash string, keyType models.KeyType, isActive bool) error {
+	result, err := r.pool.Exec(ctx,
+		"UPDATE api_keys SET is_active = $1 WHERE key_hash = $2 AND key_type = $3",
+		isActive, keyHash, string(keyType),
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// UpdateKeyStatusByID sets is_active for the key of the given type with this ID
+func (r *KeyRepository) UpdateKeyStatusByID(ctx context.Context, keyID uuid.UUID, keyType models.KeyType, isActive bool) error {
+	result, err := r.pool.Exec(ctx,
+		"UPDATE api_keys SET is_active = $1 WHERE id = $2 AND key_type = $3",
+		isActive, keyID, string(keyType),
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// DeactivateKeyByID deactivates a single key by ID
+func (r *KeyRepository) DeactivateKeyByID(ctx context.Context, keyID uuid.UUID) error {
+	result, err := r.pool.Exec(ctx, "UPDATE api_keys SET is_active = false WHERE id = $1", keyID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteKeyPair deletes a webhook key and every key sharing its pair_id
+func (r *KeyRepository) DeleteKeyPair(ctx context.Context, webhookKeyHash string) error {
+	var pairID *uuid.UUID
+	err := r.pool.QueryRow(ctx,
+		"SELECT pair_id FROM api_keys WHERE key_hash = $1 AND key_type = 'webhook'",
+		webhookKeyHash,
+	).Scan(&pairID)
+	if err != nil {
+		return mapNoRows(err)
+	}
+
+	// Legacy keys without pair_id are deleted on their own
+	if pairID == nil {
+		_, err = r.pool.Exec(ctx, "DELETE FROM api_keys WHERE key_hash = $1", webhookKeyHash)
+		return err
+	}
+
+	_, err = r.pool.Exec(ctx, "DELETE FROM api_keys WHERE pair_id = $1", *pairID)
+	return err
+}
+
+// IncrementUsage updates last_used and increments usage_count for a key
+func (r *KeyRepository) IncrementUsage(ctx context.Context, keyID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx,
+		"UPDATE api_keys SET last_used = NOW(), usage_count = usage_count + 1 WHERE id = $1",
+		keyID,
+	)
+	return err
+}
+
+// GetWebhookKeys returns all webhook keys with their paired client keys
+func (r *KeyRepository) GetWebhookKeys(ctx context.Context) ([]*models.WebhookKey, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT
+			wh.id,
+			wh.key_hash,
+			COALESCE(wh.key_prefix, ''),
+			CASE WHEN wh.is_active THEN 'active' ELSE 'inactive' END as status,
+			wh.created_at,
+			wh.last_used,
+			(SELECT COUNT(*) FROM events WHERE webhook_key_id = wh.id) as events_count,
+			COALESCE(ck.key_prefix, '') as client_key_prefix,
+			COALESCE(wh.pair_id, wh.id),
+			COALESCE(wh.name, ''),
+			wh.rate_limit_per_minute,
+			wh.signing_secret,
+			wh.allowed_paths,
+			wh.forced_path_prefix
+		FROM api_keys wh
+		LEFT JOIN api_keys ck ON ck.pair_id = COALESCE(wh.pair_id, wh.id) AND ck.key_type = 'client'
+		WHERE wh.key_type = 'webhook'
+		ORDER BY wh.created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.WebhookKey
+	for rows.Next() {
+		wk := &models.WebhookKey{}
+		var allowedPaths *string
+		if err := rows.Scan(
+			&wk.ID, &wk.KeyHash, &wk.KeyPrefix, &wk.Status, &wk.CreatedAt, &wk.LastUsed, &wk.EventsCount, &wk.ClientKeyPrefix,
+			&wk.PairID, &wk.Name, &wk.Settings.RateLimitPerMinute, &wk.Settings.SigningSecret, &allowedPaths, &wk.Settings.ForcedPathPrefix,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook key: %w", err)
+		}
+		wk.Settings.AllowedPaths = splitPathGlobs(allowedPaths)
+		keys = append(keys, wk)
+	}
+	return keys, rows.Err()
+}
+
+// GetClientKeys returns all client keys
+func (r *KeyRepository) GetClientKeys(ctx context.Context) ([]*models.ClientKey, error) {
+	rows, err := r.pool.Query(ctx,
+		"SELECT id, key_hash, COALESCE(key_prefix, ''), webhook_key_id, status, created_at, last_connected, events_delivered FROM client_keys ORDER BY created_at DESC",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.ClientKey
+	for rows.Next() {
+		ck := &models.ClientKey{}
+		if err := rows.Scan(&ck.ID, &ck.KeyHash, &ck.KeyPrefix, &ck.WebhookKeyID, &ck.Status, &ck.CreatedAt, &ck.LastConnected, &ck.EventsDelivered); err != nil {
+			return nil, fmt.Errorf("failed to scan client key: %w", err)
+		}
+		keys = append(keys, ck)
+	}
+	return keys, rows.Err()
+}
+
+// CreateKeyPair inserts a webhook key and its client key in a single transaction.
+// The client key's pair_id references the webhook key's id.
+func (r *KeyRepository) CreateKeyPair(ctx context.Context, webhookKey *models.WebhookKey, clientKey *models.ClientKey) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if webhookKey.ID == uuid.Nil {
+		webhookKey.ID = uuid.New()
+	}
+	if clientKey.ID == uuid.Nil {
+		clientKey.ID = uuid.New()
+	}
+
+	var isActive bool
+	err = tx.QueryRow(ctx, `
+		INSERT INTO api_keys (
+			id, key_hash, key_prefix, key_type, pair_id, is_active, activated_at,
+			created_at, usage_count
+		)
+		VALUES ($1, $2, $3, 'webhook', $1, true, NOW(), NOW(), 0)
+		RETURNING is_active, created_at, last_used, usage_count
+	`, webhookKey.ID, webhookKey.KeyHash, webhookKey.KeyPrefix).Scan(&isActive, &webhookKey.CreatedAt, &webhookKey.LastUsed, &webhookKey.EventsCount)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook key: %w", err)
+	}
+	webhookKey.Status = statusFromBool(isActive)
+
+	clientKey.WebhookKeyID = webhookKey.ID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO api_keys (
+			id, key_hash, key_prefix, key_type, pair_id, is_active, activated_at,
+			created_at, usage_count
+		)
+		VALUES ($1, $2, $3, 'client', $4, true, $5, $5, 0)
+		RETURNING is_active, last_used, usage_count
+	`, clientKey.ID, clientKey.KeyHash, clientKey.KeyPrefix, webhookKey.ID, webhookKey.CreatedAt).Scan(&isActive, &clientKey.LastConnected, &clientKey.EventsDelivered)
+	if err != nil {
+		return fmt.Errorf("failed to create client key: %w", err)
+	}
+	clientKey.CreatedAt = webhookKey.CreatedAt
+	clientKey.Status = statusFromBool(isActive)
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// CreateUserKeyPair inserts a webhook+client key pair owned by a user in a single transaction
+func (r *KeyRepository) CreateUserKeyPair(ctx context.Context, userID uuid.UUID, webhookKey *models.WebhookKey, clientKey *models.ClientKey) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	webhookKeyID := uuid.New()
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO api_keys (id, key_hash, key_prefix, key_type, pair_id, user_id)
+		VALUES ($1, $2, $3, 'webhook', $1, $4)
+	`, webhookKeyID, webhookKey.KeyHash, webhookKey.KeyPrefix, userID); err != nil {
+		return fmt.Errorf("failed to insert webhook key: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO api_keys (key_hash, key_prefix, key_type, pair_id, user_id)
+		VALUES ($1, $2, 'client', $3, $4)
+	`, clientKey.KeyHash, clientKey.KeyPrefix, webhookKeyID, userID); err != nil {
+		return fmt.Errorf("failed to insert client key: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// userIDByEmail selects the ID of the user whose email is $N
+func userIDByEmail(n int) string {
+	return fmt.Sprintf("(SELECT id FROM users WHERE email = $%d)", n)
+}
+
+// CountUserKeys returns the count of active keys of a specific type for a user
+func (r *KeyRepository) CountUserKeys(ctx context.Context, userEmail string, keyType models.KeyType) (int, error) {
+	var count int
+	err := r.pool.QueryRow(ctx,
+		"SELECT COUNT(*) FROM api_keys WHERE user_id = "+userIDByEmail(1)+" AND key_type = $2 AND is_active = true",
+		userEmail, string(keyType),
+	).Scan(&count)
+	return count, err
+}
+
+// ListUserKeyPairs returns all key pairs for a user, ordered newest first
+func (r *KeyRepository) ListUserKeyPairs(ctx context.Context, userEmail string) ([]models.KeyPair, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT
+			wk.id,
+			COALESCE(wk.key_prefix, ''),
+			COALESCE(ck.key_prefix, ''),
+			wk.is_active,
+			wk.created_at,
+			wk.last_used,
+			wk.usage_count,
+			wk.event_ttl_days,
+			wk.processed_ttl_days,
+			CASE WHEN wk.previous_key_expires_at > NOW() THEN wk.previous_key_expires_at END,
+			CASE WHEN ck.previous_key_expires_at > NOW() THEN ck.previous_key_expires_at END
+		FROM api_keys wk
+		LEFT JOIN api_keys ck ON ck.pair_id = wk.id AND ck.key_type = 'client'
+		WHERE wk.user_id = `+userIDByEmail(1)+` AND wk.key_type = 'webhook' AND (wk.pair_id IS NULL OR wk.pair_id = wk.id)
+		ORDER BY wk.created_at DESC
+	`, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pairs []models.KeyPair
+	for rows.Next() {
+		var p models.KeyPair
+		if err := rows.Scan(
+			&p.PairID, &p.WebhookKeyPrefix, &p.ClientKeyPrefix, &p.IsActive, &p.CreatedAt, &p.LastUsed, &p.UsageCount,
+			&p.Retention.EventTTLDays, &p.Retention.ProcessedTTLDays,
+			&p.PreviousWebhookKeyExpiresAt, &p.PreviousClientKeyExpiresAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan key pair: %w", err)
+		}
+		pairs = append(pairs, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return pairs, r.attachWebhookKeys(ctx, userEmail, pairs)
+}
+
+// attachWebhookKeys fills in every webhook key of the user's pairs, the original first
+func (r *KeyRepository) attachWebhookKeys(ctx context.Context, userEmail string, pairs []models.KeyPair) error {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+webhookKeyColumns+` FROM api_keys
+		WHERE user_id = `+userIDByEmail(1)+` AND key_type = 'webhook'
+		ORDER BY id = COALESCE(pair_id, id) DESC, created_at, id
+	`, userEmail)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byPair := make(map[string]*models.KeyPair, len(pairs))
+	for i := range pairs {
+		byPair[pairs[i].PairID] = &pairs[i]
+	}
+	for rows.Next() {
+		wk, err := scanWebhookKey(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan webhook key: %w", err)
+		}
+		if p, ok := byPair[wk.PairID.String()]; ok {
+			p.WebhookKeys = append(p.WebhookKeys, *wk)
+		}
+	}
+	return rows.Err()
+}
+
+// DeactivateUserKeyPair deactivates both keys of a pair, scoped to its owner
+func (r *KeyRepository) DeactivateUserKeyPair(ctx context.Context, pairID uuid.UUID, userEmail string) error {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE api_keys SET is_active = false
+		WHERE pair_id = $1 AND user_id = `+userIDByEmail(2)+` AND is_active = true
+	`, pairID, userEmail)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// CreatePairWebhookKey adds a named webhook key to one of the user's active pairs
+func (r *KeyRepository) CreatePairWebhookKey(ctx context.Context, pairID uuid.UUID, userEmail string, key *models.WebhookKey) error {
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	var isActive bool
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO api_keys (
+			id, key_hash, key_prefix, key_type, pair_id, user_id,
+			name, rate_limit_per_minute, signing_secret, allowed_paths, forced_path_prefix
+		)
+		SELECT $1, $2, $3, 'webhook', id, user_id, $4, $5, $6, $7, $8
+		FROM api_keys
+		WHERE id = $9 AND COALESCE(pair_id, id) = id AND key_type = 'webhook' AND user_id = `+userIDByEmail(10)+` AND is_active = true
+		RETURNING is_active, created_at, usage_count
+	`, key.ID, key.KeyHash, key.KeyPrefix, key.Name, key.Settings.RateLimitPerMinute, key.Settings.SigningSecret,
+		joinPathGlobs(key.Settings.AllowedPaths), key.Settings.ForcedPathPrefix,
+		pairID, userEmail,
+	).Scan(&isActive, &key.CreatedAt, &key.EventsCount)
+	if err != nil {
+		return mapNoRows(err)
+	}
+	key.PairID = pairID
+	key.Status = statusFromBool(isActive)
+	return nil
+}
+
+// UpdateWebhookKeySettings replaces the name and settings of one of the user's webhook keys
+func (r *KeyRepository) UpdateWebhookKeySettings(ctx context.Context, keyID uuid.UUID, userEmail, name string, settings models.WebhookKeySettings) error {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE api_keys
+		SET name = NULLIF($1, ''), rate_limit_per_minute = $2, signing_secret = $3, allowed_paths = $4, forced_path_prefix = $5
+		WHERE id = $6 AND user_id = `+userIDByEmail(7)+` AND key_type = 'webhook'
+	`, name, settings.RateLimitPerMinute, settings.SigningSecret, joinPathGlobs(settings.AllowedPaths), settings.ForcedPathPrefix, keyID, userEmail)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// SetWebhookKeyPathRules replaces the path allowlist and forced prefix of any webhook key
+func (r *KeyRepository) SetWebhookKeyPathRules(ctx context.Context, keyID uuid.UUID, allowedPaths []string, forcedPrefix *string) error {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE api_keys SET allowed_paths = $1, forced_path_prefix = $2
+		WHERE id = $3 AND key_type = 'webhook'
+	`, joinPathGlobs(allowedPaths), forcedPrefix, keyID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// DeactivateUserWebhookKey revokes one of the user's named webhook keys
+func (r *KeyRepository) DeactivateUserWebhookKey(ctx context.Context, keyID uuid.UUID, userEmail string) error {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE api_keys SET is_active = false
+		WHERE id = $1 AND user_id = `+userIDByEmail(2)+` AND key_type = 'webhook' AND pair_id <> id AND is_active = true
+	`, keyID, userEmail)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// RotateKey gives the pair's original webhook key or newest active client key a new hash. The old
+// hash keeps resolving until previousExpiresAt; nil retires it at once.
+func (r *KeyRepository) RotateKey(ctx context.Context, pairID uuid.UUID, keyType models.KeyType, newHash, newPrefix string, previousExpiresAt *time.Time) error {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE api_keys
+		SET previous_key_hash = CASE WHEN $4::TIMESTAMP IS NULL THEN NULL ELSE key_hash END,
+			previous_key_expires_at = $4,
+			key_hash = $3,
+			key_prefix = $5
+		WHERE id = (
+			SELECT id FROM api_keys
+			WHERE pair_id = $1 AND key_type = $2 AND is_active = true
+			  AND (key_type = 'client' OR id = pair_id)
+			ORDER BY created_at DESC
+			LIMIT 1
+		)
+	`, pairID, string(keyType), newHash, previousExpiresAt, newPrefix)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// ClearExpiredRotations forgets up to limit previous key hashes that expired before cutoff
+func (r *KeyRepository) ClearExpiredRotations(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE api_keys SET previous_key_hash = NULL, previous_key_expires_at = NULL
+		WHERE id IN (
+			SELECT id FROM api_keys
+			WHERE previous_key_hash IS NOT NULL AND previous_key_expires_at < $1
+			LIMIT $2
+		)
+	`, cutoff, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// ListUnhashedKeys returns up to limit keys still stored in the clear, which
+// the key hashing migration left without a prefix
+func (r *KeyRepository) ListUnhashedKeys(ctx context.Context, limit int) ([]models.LegacyKey, error) {
+	rows, err := r.pool.Query(ctx,
+		"SELECT id, key_hash, previous_key_hash FROM api_keys WHERE key_prefix IS NULL ORDER BY id LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.LegacyKey
+	for rows.Next() {
+		var k models.LegacyKey
+		if err := rows.Scan(&k.ID, &k.KeyValue, &k.PreviousKeyValue); err != nil {
+			return nil, fmt.Errorf("failed to scan unhashed key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// StoreKeyHash replaces an unhashed key's stored values with their hashes
+func (r *KeyRepository) StoreKeyHash(ctx context.Context, keyID uuid.UUID, keyHash, keyPrefix string, previousKeyHash *string) error {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE api_keys SET key_hash = $2, key_prefix = $3, previous_key_hash = $4
+		WHERE id = $1 AND key_prefix IS NULL
+	`, keyID, keyHash, keyPrefix, previousKeyHash)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// GetRetention returns the retention overrides of a webhook key
+func (r *KeyRepository) GetRetention(ctx context.Context, webhookKeyID uuid.UUID) (*models.RetentionSettings, error) {
+	settings := &models.RetentionSettings{}
+	err := r.pool.QueryRow(ctx,
+		"SELECT event_ttl_days, processed_ttl_days FROM api_keys WHERE id = $1 AND key_type = 'webhook'",
+		webhookKeyID,
+	).Scan(&settings.EventTTLDays, &settings.ProcessedTTLDays)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return settings, nil
+}
+
+// SetRetention replaces the retention overrides of a webhook key
+func (r *KeyRepository) SetRetention(ctx context.Context, webhookKeyID uuid.UUID, settings models.RetentionSettings) error {
+	result, err := r.pool.Exec(ctx,
+		"UPDATE api_keys SET event_ttl_days = $1, processed_ttl_days = $2 WHERE id = $3 AND key_type = 'webhook'",
+		settings.EventTTLDays, settings.ProcessedTTLDays, webhookKeyID,
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// SetDeliveryAlertedAt records when the stuck delivery monitor last alerted about a webhook key
+func (r *KeyRepository) SetDeliveryAlertedAt(ctx context.Context, webhookKeyID uuid.UUID, at time.Time) error {
+	result, err := r.pool.Exec(ctx,
+		"UPDATE api_keys SET delivery_alerted_at = $1 WHERE id = $2 AND key_type = 'webhook'",
+		at, webhookKeyID,
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// SetE2EPublicKey stores or clears the end-to-end public key of a client key
+func (r *KeyRepository) SetE2EPublicKey(ctx context.Context, clientKeyID uuid.UUID, publicKey *string) error {
+	result, err := r.pool.Exec(ctx,
+		"UPDATE api_keys SET e2e_public_key = $1 WHERE id = $2 AND key_type = 'client'",
+		publicKey, clientKeyID,
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// GetE2EPublicKey returns the newest public key registered on an active client key of the pair
+func (r *KeyRepository) GetE2EPublicKey(ctx context.Context, webhookKeyID uuid.UUID) (string, error) {
+	var publicKey string
+	err := r.pool.QueryRow(ctx,
+		`SELECT e2e_public_key FROM api_keys
+		 WHERE pair_id = $1 AND key_type = 'client' AND is_active = true AND e2e_public_key IS NOT NULL
+		 ORDER BY created_at DESC
+		 LIMIT 1`,
+		webhookKeyID,
+	).Scan(&publicKey)
+	if err != nil {
+		return "", mapNoRows(err)
+	}
+	return publicKey, nil
+}
+
+// DeleteInactiveKeyPairs deletes up to limit fully inactive pairs idle since idleSince.
+// Their events and logs go with them through ON DELETE CASCADE.
+func (r *KeyRepository) DeleteInactiveKeyPairs(ctx context.Context, idleSince time.Time, limit int) (int64, error) {
+	result, err := r.pool.Exec(ctx, `
+		DELETE FROM api_keys
+		WHERE COALESCE(pair_id, id) IN (
+			SELECT COALESCE(pair_id, id) FROM api_keys
+			GROUP BY COALESCE(pair_id, id)
+			HAVING NOT bool_or(is_active) AND MAX(COALESCE(last_used, created_at)) < $1
+			LIMIT $2
+		)
+	`, idleSince, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+var _ repositories.KeyRepository = (*KeyRepository)(nil)
@@ -0,0 +1,65 @@
+package postgres_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/database"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/postgres"
+)
+
+// TestCreateKeyPair_BackwardCompatibilityViews verifies that keys created in api_keys
+// are accessible via the backward compatibility views webhook_keys and client_keys
+func TestCreateKeyPair_BackwardCompatibilityViews(t *testing.T) {
+	database.WithTestDB(t, func(tdb *database.TestDB) {
+		ctx := context.Background()
+		repo := postgres.NewKeyRepository(tdb.Pool)
+
+		// Create key pair (inserts into api_keys table)
+		wk := &models.WebhookKey{KeyHash: tdb.KeyHasher.Hash("wh_views_" + uuid.NewString()), KeyPrefix: "wh_views_"}
+		ck := &models.ClientKey{KeyHash: tdb.KeyHasher.Hash("ck_views_" + uuid.NewString()), KeyPrefix: "ck_views_"}
+		if err := repo.CreateKeyPair(ctx, wk, ck); err != nil {
+			t.Fatalf("CreateKeyPair failed: %v", err)
+		}
+
+		// Test webhook key is accessible via webhook_keys view
+		isValid, err := repo.ValidateWebhookKey(ctx, wk.KeyHash)
+		if err != nil {
+			t.Fatalf("ValidateWebhookKey failed: %v", err)
+		}
+		if !isValid {
+			t.Errorf("Expected webhook key to be valid via webhook_keys view")
+		}
+
+		// Test client key is accessible via client_keys view
+		isValid, err = repo.ValidateClientKey(ctx, ck.KeyHash)
+		if err != nil {
+			t.Fatalf("ValidateClientKey failed: %v", err)
+		}
+		if !isValid {
+			t.Errorf("Expected client key to be valid via client_keys view")
+		}
+
+		// Test field mapping: is_active (true) → status ('active')
+		var status string
+		err = tdb.Pool.QueryRow(ctx, "SELECT status FROM webhook_keys WHERE key_hash = $1", wk.KeyHash).Scan(&status)
+		if err != nil {
+			t.Fatalf("Failed to query webhook_keys view: %v", err)
+		}
+		if status != string(models.KeyStatusActive) {
+			t.Errorf("Expected status '%s', got '%s'", models.KeyStatusActive, status)
+		}
+
+		// Test pair_id mapping: pair_id → webhook_key_id
+		var webhookKeyID uuid.UUID
+		err = tdb.Pool.QueryRow(ctx, "SELECT webhook_key_id FROM client_keys WHERE key_hash = $1", ck.KeyHash).Scan(&webhookKeyID)
+		if err != nil {
+			t.Fatalf("Failed to query client_keys view: %v", err)
+		}
+		if webhookKeyID != wk.ID {
+			t.Errorf("Expected webhook_key_id to match webhook key ID")
+		}
+	})
+}
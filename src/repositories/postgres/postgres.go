@@ -0,0 +1,42 @@
+// Package postgres implements the repository interfaces on top of a pgx connection pool.
+package postgres
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// New creates PostgreSQL-backed repositories sharing one connection pool
+func New(pool *pgxpool.Pool) *repositories.Repositories {
+	return &repositories.Repositories{
+		Keys:        NewKeyRepository(pool),
+		Users:       NewUserRepository(pool),
+		Events:      NewEventRepository(pool),
+		WebhookLogs: NewWebhookLogRepository(pool),
+		Admins:      NewAdminRepository(pool),
+		AuthTokens:  NewAuthTokenRepository(pool),
+		DataKeys:    NewDataKeyRepository(pool),
+		APITokens:   NewAPITokenRepository(pool),
+		Sessions:    NewSessionRepository(pool),
+		Audit:       NewAuditRepository(pool),
+	}
+}
+
+// blobRefValue stores an empty blob reference as NULL
+func blobRefValue(ref string) *string {
+	if ref == "" {
+		return nil
+	}
+	return &ref
+}
+
+// mapNoRows converts pgx.ErrNoRows into repositories.ErrNotFound
+func mapNoRows(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repositories.ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// SessionRepository stores dashboard and admin sessions in the sessions table
+type SessionRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewSessionRepository creates a new PostgreSQL session repository
+func NewSessionRepository(pool *pgxpool.Pool) *SessionRepository {
+	return &SessionRepository{pool: pool}
+}
+
+// sessionColumns is the column list scanned by scanSession
+const sessionColumns = `id, user_id, admin_id, ip, user_agent, created_at, last_seen_at, expires_at, revoked_at`
+
+// scanSession scans a row selected with sessionColumns
+func scanSession(row pgx.Row) (models.Session, error) {
+	var s models.Session
+	err := row.Scan(&s.ID, &s.UserID, &s.AdminID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.RevokedAt)
+	return s, err
+}
+
+// Create stores a new session
+func (r *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	_, err := r.pool.Exec(ctx,
+		`INSERT INTO sessions (id, user_id, admin_id, ip, user_agent, created_at, last_seen_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		session.ID, session.UserID, session.AdminID, session.IP, session.UserAgent,
+		session.CreatedAt, session.LastSeenAt, session.ExpiresAt,
+	)
+	return err
+}
+
+// Get looks up a session by ID
+func (r *SessionRepository) Get(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	s, err := scanSession(r.pool.QueryRow(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = $1`, id))
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return &s, nil
+}
+
+// ListActiveByUser returns a user's active sessions, most recently seen first
+func (r *SessionRepository) ListActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT `+sessionColumns+` FROM sessions
+		 WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		 ORDER BY last_seen_at DESC, created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchLastSeen records when a session was last used
+func (r *SessionRepository) TouchLastSeen(ctx context.Context, id uuid.UUID, at time.Time) error {
+	result, err := r.pool.Exec(ctx, `UPDATE sessions SET last_seen_at = $1 WHERE id = $2`, at, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// Revoke marks a session revoked
+func (r *SessionRepository) Revoke(ctx context.Context, id uuid.UUID) error {
+	result, err := r.pool.Exec(ctx,
+		`UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// RevokeUserSession marks one of a user's sessions revoked
+func (r *SessionRepository) RevokeUserSession(ctx context.Context, id, userID uuid.UUID) error {
+	result, err := r.pool.Exec(ctx,
+		`UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// RevokeAllByUser revokes every active session of a user
+func (r *SessionRepository) RevokeAllByUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	result, err := r.pool.Exec(ctx,
+		`UPDATE sessions SET revoked_at = NOW()
+		 WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`,
+		userID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// RevokeAllByAdmin revokes every active session of an admin
+func (r *SessionRepository) RevokeAllByAdmin(ctx context.Context, adminID uuid.UUID) (int64, error) {
+	result, err := r.pool.Exec(ctx,
+		`UPDATE sessions SET revoked_at = NOW()
+		 WHERE admin_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`,
+		adminID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// DeleteExpired deletes up to limit sessions that expired or were revoked before cutoff
+func (r *SessionRepository) DeleteExpired(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	result, err := r.pool.Exec(ctx,
+		`DELETE FROM sessions WHERE id IN (
+			SELECT id FROM sessions WHERE expires_at < $1 OR revoked_at < $1 LIMIT $2
+		)`,
+		cutoff, limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
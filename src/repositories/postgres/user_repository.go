@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// UserRepository stores user accounts in the users table
+type UserRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewUserRepository creates a new PostgreSQL user repository
+func NewUserRepository(pool *pgxpool.Pool) *UserRepository {
+	return &UserRepository{pool: pool}
+}
+
+// Upsert creates a user or updates the name and language of the existing one
+func (r *UserRepository) Upsert(ctx context.Context, profile *models.UserProfile) error {
+	return r.pool.QueryRow(ctx, `
+		INSERT INTO users (email, name, preferred_language, email_verified)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (email) DO UPDATE
+		SET name = EXCLUDED.name,
+		    preferred_language = EXCLUDED.preferred_language,
+		    email_verified = users.email_verified OR EXCLUDED.email_verified
+		RETURNING id, email_verified, delivery_alerts
+	`, profile.Email, profile.Name, profile.Language, profile.EmailVerified).Scan(&profile.ID, &profile.EmailVerified, &profile.DeliveryAlerts)
+}
+
+// GetByEmail returns a user's profile
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.UserProfile, error) {
+	profile := &models.UserProfile{Email: email}
+	err := r.pool.QueryRow(ctx, `
+		SELECT id, name, preferred_language, email_verified, delivery_alerts FROM users WHERE email = $1
+	`, email).Scan(&profile.ID, &profile.Name, &profile.Language, &profile.EmailVerified, &profile.DeliveryAlerts)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return profile, nil
+}
+
+// newestUserKey selects a column of the newest original webhook key or client
+// key of the user u, the pair the admin panel shows and suspends
+func newestUserKey(column string, keyType models.KeyType) string {
+	return `(SELECT nk.` + column + ` FROM api_keys nk
+		WHERE nk.user_id = u.id AND nk.key_type = '` + string(keyType) + `' AND (nk.key_type = 'client' OR nk.pair_id IS NULL OR nk.pair_id = nk.id)
+		ORDER BY nk.created_at DESC, nk.id DESC LIMIT 1)`
+}
+
+// List returns all users with totals over their keys, ordered by created_at DESC
+func (r *UserRepository) List(ctx context.Context) ([]models.User, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT
+			u.id,
+			u.email,
+			u.name,
+			COALESCE(BOOL_OR(k.is_active), false) as is_active,
+			u.created_at,
+			MAX(k.last_used) as last_used,
+			COALESCE(SUM(k.usage_count), 0) as usage_count,
+			COUNT(k.id) FILTER (WHERE k.key_type = 'webhook') as webhook_key_count,
+			COUNT(k.id) FILTER (WHERE k.key_type = 'client') as client_key_count,
+			COALESCE(`+newestUserKey("key_prefix", models.KeyTypeWebhook)+`, '') as webhook_key_prefix,
+			COALESCE(`+newestUserKey("key_prefix", models.KeyTypeClient)+`, '') as client_key_prefix,
+			`+newestUserKey("id", models.KeyTypeWebhook)+` as webhook_key_id,
+			`+newestUserKey("id", models.KeyTypeClient)+` as client_key_id
+		FROM users u
+		LEFT JOIN api_keys k ON k.user_id = u.id
+		GROUP BY u.id
+		ORDER BY u.created_at DESC, u.email
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(
+			&u.ID, &u.UserEmail, &u.UserName, &u.IsActive, &u.CreatedAt, &u.LastUsed, &u.UsageCount,
+			&u.WebhookKeyCount, &u.ClientKeyCount, &u.WebhookKeyPrefix, &u.ClientKeyPrefix, &u.WebhookKeyID, &u.ClientKeyID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// Get returns a user's creation time and most recent active keys
+func (r *UserRepository) Get(ctx context.Context, email string) (*models.User, error) {
+	user := &models.User{UserEmail: email}
+	if err := r.pool.QueryRow(ctx,
+		"SELECT id, name, created_at FROM users WHERE email = $1",
+		email,
+	).Scan(&user.ID, &user.UserName, &user.CreatedAt); err != nil {
+		return nil, mapNoRows(err)
+	}
+
+	latestActive := `
+		SELECT COALESCE(key_prefix, '') FROM api_keys
+		WHERE user_id = $1 AND key_type = $2 AND is_active = true
+		  AND (key_type = 'client' OR pair_id IS NULL OR pair_id = id)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	_ = r.pool.QueryRow(ctx, latestActive, user.ID, string(models.KeyTypeWebhook)).Scan(&user.WebhookKeyPrefix)
+	_ = r.pool.QueryRow(ctx, latestActive, user.ID, string(models.KeyTypeClient)).Scan(&user.ClientKeyPrefix)
+
+	return user, nil
+}
+
+// Delete removes a user. Their keys, API tokens and sessions go with the
+// users row, and with the keys their events, webhook logs and data keys.
+// ErrNotFound if there is no such user.
+func (r *UserRepository) Delete(ctx context.Context, email string) error {
+	tag, err := r.pool.Exec(ctx, "DELETE FROM users WHERE email = $1", email)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// SetDeliveryAlerts turns a user's stuck delivery emails on or off
+func (r *UserRepository) SetDeliveryAlerts(ctx context.Context, email string, enabled bool) error {
+	result, err := r.pool.Exec(ctx, "UPDATE users SET delivery_alerts = $1 WHERE email = $2", enabled, email)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+var _ repositories.UserRepository = (*UserRepository)(nil)
@@ -0,0 +1,200 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// AdminRepository stores admin accounts in the admin_users table
+type AdminRepository struct {
+	db *sql.DB
+}
+
+// NewAdminRepository creates a new SQLite admin repository
+func NewAdminRepository(db *sql.DB) *AdminRepository {
+	return &AdminRepository{db: db}
+}
+
+// Create inserts a new admin user
+func (r *AdminRepository) Create(ctx context.Context, admin *models.AdminUser) error {
+	var inviteTokenHash *string // NULL keeps the unique index to pending invites
+	if admin.InviteTokenHash != "" {
+		inviteTokenHash = &admin.InviteTokenHash
+	}
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO admin_users (id, username, password_hash, created_at, is_active, role, invite_token_hash, invite_expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, admin.ID, admin.Username, admin.PasswordHash, admin.CreatedAt.UTC(), admin.IsActive, admin.Role,
+		inviteTokenHash, utc(admin.InviteExpiresAt))
+	if err != nil {
+		return err
+	}
+	admin.LastLogin = nil
+	return nil
+}
+
+// adminColumns is the admin_users projection scanned by scanAdmin
+const adminColumns = `id, username, password_hash, created_at, last_login, is_active, role,
+	COALESCE(invite_token_hash, ''), invite_expires_at, totp_secret, totp_enabled_at,
+	(SELECT COUNT(*) FROM admin_recovery_codes rc WHERE rc.admin_id = admin_users.id AND rc.used_at IS NULL)`
+
+// scanAdmin scans a row selected with adminColumns
+func scanAdmin(row rowScanner) (*models.AdminUser, error) {
+	admin := &models.AdminUser{}
+	err := row.Scan(&admin.ID, &admin.Username, &admin.PasswordHash, &admin.CreatedAt, &admin.LastLogin, &admin.IsActive,
+		&admin.Role, &admin.InviteTokenHash, &admin.InviteExpiresAt, &admin.TOTPSecret, &admin.TOTPEnabledAt, &admin.RecoveryCodesLeft)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return admin, nil
+}
+
+// GetByUsername retrieves an admin user by username, active or not
+func (r *AdminRepository) GetByUsername(ctx context.Context, username string) (*models.AdminUser, error) {
+	return scanAdmin(r.db.QueryRowContext(ctx, "SELECT "+adminColumns+" FROM admin_users WHERE username = ?", username))
+}
+
+// GetByID retrieves an admin user by ID, active or not
+func (r *AdminRepository) GetByID(ctx context.Context, adminID uuid.UUID) (*models.AdminUser, error) {
+	return scanAdmin(r.db.QueryRowContext(ctx, "SELECT "+adminColumns+" FROM admin_users WHERE id = ?", adminID))
+}
+
+// UpdateLastLogin sets last_login to now
+func (r *AdminRepository) UpdateLastLogin(ctx context.Context, adminID uuid.UUID) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE admin_users SET last_login = ? WHERE id = ?`, now(), adminID)
+	return err
+}
+
+// Count returns the number of admin users
+func (r *AdminRepository) Count(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM admin_users").Scan(&count)
+	return count, err
+}
+
+// List returns every admin user, oldest first
+func (r *AdminRepository) List(ctx context.Context) ([]models.AdminUser, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+adminColumns+" FROM admin_users ORDER BY created_at, username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var admins []models.AdminUser
+	for rows.Next() {
+		admin, err := scanAdmin(rows)
+		if err != nil {
+			return nil, err
+		}
+		admins = append(admins, *admin)
+	}
+	return admins, rows.Err()
+}
+
+// GetByInviteTokenHash returns the admin whose pending invite has tokenHash
+func (r *AdminRepository) GetByInviteTokenHash(ctx context.Context, tokenHash string) (*models.AdminUser, error) {
+	return scanAdmin(r.db.QueryRowContext(ctx, "SELECT "+adminColumns+" FROM admin_users WHERE invite_token_hash = ?", tokenHash))
+}
+
+// AcceptInvite sets an invited admin's password and clears the invite
+func (r *AdminRepository) AcceptInvite(ctx context.Context, adminID uuid.UUID, passwordHash string) error {
+	return requireRows(r.db.ExecContext(ctx, `
+		UPDATE admin_users SET password_hash = ?, invite_token_hash = NULL, invite_expires_at = NULL
+		WHERE id = ? AND invite_token_hash IS NOT NULL
+	`, passwordHash, adminID))
+}
+
+// UpdateRole changes an admin's role
+func (r *AdminRepository) UpdateRole(ctx context.Context, adminID uuid.UUID, role models.AdminRole) error {
+	return requireRows(r.db.ExecContext(ctx, "UPDATE admin_users SET role = ? WHERE id = ?", role, adminID))
+}
+
+// SetActive activates or deactivates an admin
+func (r *AdminRepository) SetActive(ctx context.Context, adminID uuid.UUID, active bool) error {
+	return requireRows(r.db.ExecContext(ctx, "UPDATE admin_users SET is_active = ? WHERE id = ?", active, adminID))
+}
+
+// SetTOTPSecret stores a secret awaiting confirmation and drops recovery codes
+func (r *AdminRepository) SetTOTPSecret(ctx context.Context, adminID uuid.UUID, secret []byte) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := requireRows(tx.ExecContext(ctx,
+		"UPDATE admin_users SET totp_secret = ?, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = ?", secret, adminID,
+	)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM admin_recovery_codes WHERE admin_id = ?", adminID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EnableTOTP confirms the stored secret and replaces the recovery codes
+func (r *AdminRepository) EnableTOTP(ctx context.Context, adminID uuid.UUID, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := requireRows(tx.ExecContext(ctx,
+		"UPDATE admin_users SET totp_enabled_at = COALESCE(totp_enabled_at, ?) WHERE id = ? AND totp_secret IS NOT NULL", now(), adminID,
+	)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM admin_recovery_codes WHERE admin_id = ?", adminID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO admin_recovery_codes (admin_id, code_hash) VALUES (?, ?)", adminID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP removes the secret and recovery codes
+func (r *AdminRepository) DisableTOTP(ctx context.Context, adminID uuid.UUID) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := requireRows(tx.ExecContext(ctx,
+		"UPDATE admin_users SET totp_secret = NULL, totp_enabled_at = NULL WHERE id = ?", adminID,
+	)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM admin_recovery_codes WHERE admin_id = ?", adminID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code used
+func (r *AdminRepository) UseRecoveryCode(ctx context.Context, adminID uuid.UUID, codeHash string) error {
+	return requireRows(r.db.ExecContext(ctx,
+		"UPDATE admin_recovery_codes SET used_at = ? WHERE admin_id = ? AND code_hash = ? AND used_at IS NULL",
+		now(), adminID, codeHash,
+	))
+}
+
+// UseTOTPStep records the last TOTP time step the admin used
+func (r *AdminRepository) UseTOTPStep(ctx context.Context, adminID uuid.UUID, step int64) error {
+	return requireRows(r.db.ExecContext(ctx,
+		"UPDATE admin_users SET totp_last_step = ? WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)",
+		step, adminID, step,
+	))
+}
+
+var _ repositories.AdminRepository = (*AdminRepository)(nil)
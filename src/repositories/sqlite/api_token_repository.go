@@ -0,0 +1,113 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+)
+
+// APITokenRepository stores personal access tokens in the api_tokens table
+type APITokenRepository struct {
+	db *sql.DB
+}
+
+// NewAPITokenRepository creates a new SQLite API token repository
+func NewAPITokenRepository(db *sql.DB) *APITokenRepository {
+	return &APITokenRepository{db: db}
+}
+
+// apiTokenColumns is the column list scanned by scanAPIToken, selected from
+// apiTokenTables so the owner's current email comes with each token
+const apiTokenColumns = `t.id, t.user_id, u.email, t.name, t.prefix, t.token_hash, t.scopes, t.expires_at, t.last_used_at, t.revoked_at, t.created_at`
+
+const apiTokenTables = `api_tokens t JOIN users u ON u.id = t.user_id`
+
+// scanAPIToken scans a row selected with apiTokenColumns
+func scanAPIToken(row rowScanner) (models.APIToken, error) {
+	var t models.APIToken
+	var scopes string
+	err := row.Scan(&t.ID, &t.UserID, &t.UserEmail, &t.Name, &t.Prefix, &t.TokenHash, &scopes, &t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt, &t.CreatedAt)
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	return t, err
+}
+
+// Create stores a new token
+func (r *APITokenRepository) Create(ctx context.Context, token *models.APIToken) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO api_tokens (id, user_id, name, prefix, token_hash, scopes, expires_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		token.ID, token.UserID, token.Name, token.Prefix, token.TokenHash,
+		strings.Join(token.Scopes, ","), utc(token.ExpiresAt), token.CreatedAt.UTC(),
+	)
+	return err
+}
+
+// GetByHash looks up a token by the hash of its value
+func (r *APITokenRepository) GetByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	t, err := scanAPIToken(r.db.QueryRowContext(ctx,
+		`SELECT `+apiTokenColumns+` FROM `+apiTokenTables+` WHERE t.token_hash = ?`,
+		tokenHash,
+	))
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return &t, nil
+}
+
+// ListByUser returns a user's tokens, newest first
+func (r *APITokenRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.APIToken, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+apiTokenColumns+` FROM `+apiTokenTables+` WHERE t.user_id = ? ORDER BY t.created_at DESC, t.rowid DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// Revoke marks one of a user's tokens revoked
+func (r *APITokenRepository) Revoke(ctx context.Context, tokenID, userID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`,
+		now(), tokenID, userID,
+	)
+	return requireRows(result, err)
+}
+
+// TouchLastUsed records when a token was last used
+func (r *APITokenRepository) TouchLastUsed(ctx context.Context, tokenID uuid.UUID, at time.Time) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, at.UTC(), tokenID)
+	return requireRows(result, err)
+}
+
+// DeleteExpired deletes up to limit tokens that expired or were revoked before cutoff
+func (r *APITokenRepository) DeleteExpired(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM api_tokens WHERE id IN (
+			SELECT id FROM api_tokens WHERE expires_at < ? OR revoked_at < ? LIMIT ?
+		)`,
+		cutoff.UTC(), cutoff.UTC(), limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
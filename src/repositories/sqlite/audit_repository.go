@@ -0,0 +1,147 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// AuditRepository stores the audit log in the audit_events table
+type AuditRepository struct {
+	db *sql.DB
+}
+
+// NewAuditRepository creates a new SQLite audit repository
+func NewAuditRepository(db *sql.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// auditColumns is the column list scanned by scanAuditEvent
+const auditColumns = `seq, id, created_at, actor_type, actor_id, actor_name, action, target_type, target_id,
+	user_email, ip, request_id, before_state, after_state, prev_hash, hash`
+
+// scanAuditEvent scans a row selected with auditColumns
+func scanAuditEvent(row rowScanner) (models.AuditEvent, error) {
+	var e models.AuditEvent
+	var before, after *string
+	err := row.Scan(&e.Seq, &e.ID, &e.CreatedAt, &e.ActorType, &e.ActorID, &e.ActorName, &e.Action, &e.TargetType, &e.TargetID,
+		&e.UserEmail, &e.IP, &e.RequestID, &before, &after, &e.PrevHash, &e.Hash)
+	if before != nil {
+		e.Before = json.RawMessage(*before)
+	}
+	if after != nil {
+		e.After = json.RawMessage(*after)
+	}
+	return e, err
+}
+
+// auditState stores an empty before or after state as NULL
+func auditState(state json.RawMessage) *string {
+	if len(state) == 0 {
+		return nil
+	}
+	s := string(state)
+	return &s
+}
+
+// Append adds an event to the end of the chain. The database has a single
+// connection, so the transaction holds the chain; the seq primary key would
+// reject a fork regardless.
+func (r *AuditRepository) Append(ctx context.Context, event *models.AuditEvent, seal func(*models.AuditEvent)) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var lastSeq int64
+	var lastHash string
+	err = tx.QueryRowContext(ctx, "SELECT seq, hash FROM audit_events ORDER BY seq DESC LIMIT 1").Scan(&lastSeq, &lastHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	event.Seq = lastSeq + 1
+	event.PrevHash = lastHash
+	seal(event)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO audit_events (`+auditColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, event.Seq, event.ID, event.CreatedAt.UTC(), event.ActorType, event.ActorID, event.ActorName, event.Action,
+		event.TargetType, event.TargetID, event.UserEmail, event.IP, event.RequestID,
+		auditState(event.Before), auditState(event.After), event.PrevHash, event.Hash)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// List returns events matching filter, newest first
+func (r *AuditRepository) List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	var conds []string
+	var args []any
+	if filter.ActorID != "" {
+		conds, args = append(conds, "actor_id = ?"), append(args, filter.ActorID)
+	}
+	if strings.HasSuffix(filter.Action, ".") {
+		conds, args = append(conds, "substr(action, 1, ?) = ?"), append(args, len(filter.Action), filter.Action)
+	} else if filter.Action != "" {
+		conds, args = append(conds, "action = ?"), append(args, filter.Action)
+	}
+	if filter.TargetID != "" {
+		conds, args = append(conds, "target_id = ?"), append(args, filter.TargetID)
+	}
+	if filter.UserEmail != "" {
+		conds, args = append(conds, "user_email = ?"), append(args, filter.UserEmail)
+	}
+	if filter.Since != nil {
+		conds, args = append(conds, "created_at >= ?"), append(args, filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		conds, args = append(conds, "created_at < ?"), append(args, filter.Until.UTC())
+	}
+	if filter.BeforeSeq > 0 {
+		conds, args = append(conds, "seq < ?"), append(args, filter.BeforeSeq)
+	}
+
+	query := "SELECT " + auditColumns + " FROM audit_events"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY seq DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	return r.query(ctx, query, args...)
+}
+
+// ListAfter returns up to limit events after afterSeq, in chain order
+func (r *AuditRepository) ListAfter(ctx context.Context, afterSeq int64, limit int) ([]models.AuditEvent, error) {
+	return r.query(ctx, "SELECT "+auditColumns+" FROM audit_events WHERE seq > ? ORDER BY seq LIMIT ?", afterSeq, limit)
+}
+
+// query runs a select of auditColumns
+func (r *AuditRepository) query(ctx context.Context, query string, args ...any) ([]models.AuditEvent, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+var _ repositories.AuditRepository = (*AuditRepository)(nil)
@@ -0,0 +1,77 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// AuthTokenRepository stores magic link tokens on the user's row
+type AuthTokenRepository struct {
+	db *sql.DB
+}
+
+// NewAuthTokenRepository creates a new SQLite auth token repository
+func NewAuthTokenRepository(db *sql.DB) *AuthTokenRepository {
+	return &AuthTokenRepository{db: db}
+}
+
+// StoreMagicLinkToken replaces the user's magic link token
+func (r *AuthTokenRepository) StoreMagicLinkToken(ctx context.Context, userEmail, token string, expiresAt time.Time) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE users
+		SET magic_link_token = ?,
+		    magic_link_expires_at = ?,
+		    magic_link_used_at = NULL
+		WHERE email = ?
+	`, token, expiresAt.UTC(), userEmail)
+	return requireRows(result, err)
+}
+
+// GetMagicLinkToken looks up a magic link token
+func (r *AuthTokenRepository) GetMagicLinkToken(ctx context.Context, token string) (*models.MagicLinkToken, error) {
+	t := &models.MagicLinkToken{Token: token}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT email, magic_link_expires_at, magic_link_used_at
+		FROM users
+		WHERE magic_link_token = ?
+	`, token).Scan(&t.Email, &t.ExpiresAt, &t.UsedAt)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return t, nil
+}
+
+// MarkMagicLinkTokenUsed records that a token has been consumed
+func (r *AuthTokenRepository) MarkMagicLinkTokenUsed(ctx context.Context, token string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE users
+		SET magic_link_used_at = ?
+		WHERE magic_link_token = ?
+	`, now(), token)
+	return err
+}
+
+// ClearExpiredMagicLinkTokens clears up to limit expired magic link tokens
+func (r *AuthTokenRepository) ClearExpiredMagicLinkTokens(ctx context.Context, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE users
+		SET magic_link_token = NULL,
+		    magic_link_expires_at = NULL,
+		    magic_link_used_at = NULL
+		WHERE id IN (
+			SELECT id FROM users
+			WHERE magic_link_token IS NOT NULL AND magic_link_expires_at < ?
+			LIMIT ?
+		)
+	`, now(), limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+var _ repositories.AuthTokenRepository = (*AuthTokenRepository)(nil)
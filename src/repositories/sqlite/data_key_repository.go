@@ -0,0 +1,94 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+)
+
+// DataKeyRepository stores wrapped data encryption keys in the data_keys table
+type DataKeyRepository struct {
+	db *sql.DB
+}
+
+// NewDataKeyRepository creates a new SQLite data key repository
+func NewDataKeyRepository(db *sql.DB) *DataKeyRepository {
+	return &DataKeyRepository{db: db}
+}
+
+// dataKeyColumns is the column list scanned by scanDataKey
+const dataKeyColumns = `webhook_key_id, key_id, wrapped_key, created_at, updated_at`
+
+// scanDataKey scans a row selected with dataKeyColumns
+func scanDataKey(row rowScanner) (models.DataKey, error) {
+	var k models.DataKey
+	err := row.Scan(&k.WebhookKeyID, &k.KeyID, &k.WrappedKey, &k.CreatedAt, &k.UpdatedAt)
+	return k, err
+}
+
+// Get retrieves a key pair's data key
+func (r *DataKeyRepository) Get(ctx context.Context, webhookKeyID uuid.UUID) (*models.DataKey, error) {
+	k, err := scanDataKey(r.db.QueryRowContext(ctx,
+		`SELECT `+dataKeyColumns+` FROM data_keys WHERE webhook_key_id = ?`,
+		webhookKeyID,
+	))
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return &k, nil
+}
+
+// Create stores a data key unless the pair already has one
+func (r *DataKeyRepository) Create(ctx context.Context, key *models.DataKey) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO data_keys (webhook_key_id, key_id, wrapped_key, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT (webhook_key_id) DO NOTHING`,
+		key.WebhookKeyID, key.KeyID, key.WrappedKey, key.CreatedAt.UTC(), key.CreatedAt.UTC(),
+	)
+	return err
+}
+
+// Delete removes a key pair's data key
+func (r *DataKeyRepository) Delete(ctx context.Context, webhookKeyID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM data_keys WHERE webhook_key_id = ?`, webhookKeyID)
+	return requireRows(result, err)
+}
+
+// ListAfter returns up to limit data keys after afterID in webhook key ID order
+func (r *DataKeyRepository) ListAfter(ctx context.Context, afterID uuid.UUID, limit int) ([]models.DataKey, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+dataKeyColumns+`
+		 FROM data_keys
+		 WHERE webhook_key_id > ?
+		 ORDER BY webhook_key_id ASC
+		 LIMIT ?`,
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.DataKey
+	for rows.Next() {
+		k, err := scanDataKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan data key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// UpdateWrappedKey replaces the wrapping of a data key that is still keyID
+func (r *DataKeyRepository) UpdateWrappedKey(ctx context.Context, webhookKeyID uuid.UUID, keyID string, wrappedKey []byte) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE data_keys SET wrapped_key = ?, updated_at = ? WHERE webhook_key_id = ? AND key_id = ?`,
+		wrappedKey, now(), webhookKeyID, keyID,
+	)
+	return requireRows(result, err)
+}
@@ -0,0 +1,353 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// eventColumns is the column list scanned by scanEvent
+const eventColumns = `id, webhook_key_id, path, data, processed, processed_at, created_at, expires_at, deliver_after, sealed, blob_ref, write_mode`
+
+// EventRepository stores webhook events in the events table
+type EventRepository struct {
+	db *sql.DB
+}
+
+// NewEventRepository creates a new SQLite event repository
+func NewEventRepository(db *sql.DB) *EventRepository {
+	return &EventRepository{db: db}
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanEvent scans a row selected with eventColumns
+func scanEvent(row rowScanner) (models.Event, error) {
+	var e models.Event
+	var blobRef *string
+	err := row.Scan(&e.ID, &e.WebhookKeyID, &e.Path, &e.Data, &e.Processed, &e.ProcessedAt, &e.CreatedAt, &e.ExpiresAt, &e.DeliverAfter, &e.Sealed, &blobRef, &e.Mode)
+	if blobRef != nil {
+		e.BlobRef = *blobRef
+	}
+	return e, err
+}
+
+// queryEvents runs a query selecting eventColumns and collects the rows
+func (r *EventRepository) queryEvents(ctx context.Context, query string, args ...interface{}) ([]models.Event, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Create inserts a new event
+func (r *EventRepository) Create(ctx context.Context, event *models.Event) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO events (id, webhook_key_id, path, data, processed, created_at, expires_at, deliver_after, sealed, blob_ref, payload_size, stored_size, compressed, write_mode)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.ID, event.WebhookKeyID, event.Path, event.Data, event.Processed,
+		event.CreatedAt.UTC(), event.ExpiresAt.UTC(), utc(event.DeliverAfter), event.Sealed, blobRefValue(event.BlobRef),
+		event.PayloadSize, event.StoredSize, event.Compressed, event.Mode,
+	)
+	return err
+}
+
+// GetByID retrieves an event by ID
+func (r *EventRepository) GetByID(ctx context.Context, eventID uuid.UUID) (*models.Event, error) {
+	e, err := scanEvent(r.db.QueryRowContext(ctx,
+		`SELECT `+eventColumns+` FROM events WHERE id = ?`,
+		eventID,
+	))
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return &e, nil
+}
+
+// GetUnprocessed returns due, unprocessed events for a webhook key in delivery order
+func (r *EventRepository) GetUnprocessed(ctx context.Context, webhookKeyID uuid.UUID) ([]models.Event, error) {
+	return r.queryEvents(ctx,
+		`SELECT `+eventColumns+`
+		 FROM events
+		 WHERE webhook_key_id = ? AND processed = 0
+		   AND (deliver_after IS NULL OR deliver_after <= ?)
+		 ORDER BY COALESCE(deliver_after, created_at) ASC`,
+		webhookKeyID, now(),
+	)
+}
+
+// GetByWebhookKey returns the newest events for a webhook key
+func (r *EventRepository) GetByWebhookKey(ctx context.Context, webhookKeyID uuid.UUID, limit int) ([]models.Event, error) {
+	return r.queryEvents(ctx,
+		`SELECT `+eventColumns+`
+		 FROM events
+		 WHERE webhook_key_id = ?
+		 ORDER BY created_at DESC
+		 LIMIT ?`,
+		webhookKeyID, limit,
+	)
+}
+
+// GetScheduledDue returns unprocessed events whose deliver_after falls in (from, to]
+func (r *EventRepository) GetScheduledDue(ctx context.Context, from, to time.Time) ([]models.Event, error) {
+	return r.queryEvents(ctx,
+		`SELECT `+eventColumns+`
+		 FROM events
+		 WHERE processed = 0 AND deliver_after > ? AND deliver_after <= ?
+		 ORDER BY deliver_after ASC`,
+		from.UTC(), to.UTC(),
+	)
+}
+
+// ListAfter returns up to limit events with an ID greater than afterID in ID order.
+// IDs are stored as canonical lowercase text, which sorts like the UUID bytes.
+func (r *EventRepository) ListAfter(ctx context.Context, afterID uuid.UUID, limit int) ([]models.Event, error) {
+	return r.queryEvents(ctx,
+		`SELECT `+eventColumns+`
+		 FROM events
+		 WHERE id > ?
+		 ORDER BY id ASC
+		 LIMIT ?`,
+		afterID, limit,
+	)
+}
+
+// UpdateData replaces an event's stored data, keeping a recorded stored size current
+func (r *EventRepository) UpdateData(ctx context.Context, eventID uuid.UUID, data []byte) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE events SET data = ?, stored_size = CASE WHEN stored_size IS NULL THEN NULL ELSE ? END WHERE id = ?`,
+		data, len(data), eventID,
+	)
+	return requireRows(result, err)
+}
+
+// ReferencedBlobs reports which of refs are referenced by an event
+func (r *EventRepository) ReferencedBlobs(ctx context.Context, refs []string) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	if len(refs) == 0 {
+		return referenced, nil
+	}
+	args := make([]interface{}, len(refs))
+	for i, ref := range refs {
+		args[i] = ref
+	}
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT DISTINCT blob_ref FROM events WHERE blob_ref IN (?`+strings.Repeat(", ?", len(refs)-1)+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ref string
+		if err := rows.Scan(&ref); err != nil {
+			return nil, err
+		}
+		referenced[ref] = true
+	}
+	return referenced, rows.Err()
+}
+
+// MarkAsProcessed marks an event as processed and restarts its expiry from now
+func (r *EventRepository) MarkAsProcessed(ctx context.Context, eventID uuid.UUID, processedTTL time.Duration) error {
+	ts := now()
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE events SET processed = 1, processed_at = ?, expires_at = ? WHERE id = ?`,
+		ts, ts.Add(processedTTL), eventID,
+	)
+	return requireRows(result, err)
+}
+
+// Delete deletes an event
+func (r *EventRepository) Delete(ctx context.Context, eventID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM events WHERE id = ?", eventID)
+	return requireRows(result, err)
+}
+
+// DeleteExpired deletes events past their expires_at
+func (r *EventRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM events WHERE expires_at < ?", now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpiredBatch deletes at most limit events past their expires_at
+func (r *EventRepository) DeleteExpiredBatch(ctx context.Context, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM events
+		WHERE id IN (SELECT id FROM events WHERE expires_at < ? LIMIT ?)
+	`, now(), limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpiredByKeyBatch deletes at most limit of a webhook key's expired events
+func (r *EventRepository) DeleteExpiredByKeyBatch(ctx context.Context, webhookKeyID uuid.UUID, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM events
+		WHERE id IN (SELECT id FROM events WHERE webhook_key_id = ? AND expires_at < ? LIMIT ?)
+	`, webhookKeyID, now(), limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ApplyRetention recomputes expires_at for all of a webhook key's events.
+// Stored times are text, so the new expiry is computed in Go row by row.
+func (r *EventRepository) ApplyRetention(ctx context.Context, webhookKeyID uuid.UUID, unprocessedTTL, processedTTL time.Duration) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, processed_at, created_at, deliver_after FROM events WHERE webhook_key_id = ?`,
+		webhookKeyID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	expiry := make(map[uuid.UUID]time.Time)
+	for rows.Next() {
+		var id uuid.UUID
+		var processedAt, deliverAfter *time.Time
+		var createdAt time.Time
+		if err := rows.Scan(&id, &processedAt, &createdAt, &deliverAfter); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("failed to scan event: %w", err)
+		}
+		switch {
+		case processedAt != nil:
+			expiry[id] = processedAt.Add(processedTTL)
+		case deliverAfter != nil:
+			expiry[id] = deliverAfter.Add(unprocessedTTL)
+		default:
+			expiry[id] = createdAt.Add(unprocessedTTL)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, expiresAt := range expiry {
+		if _, err := tx.ExecContext(ctx, `UPDATE events SET expires_at = ? WHERE id = ?`, expiresAt.UTC(), id); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return int64(len(expiry)), nil
+}
+
+// CountUndelivered counts unprocessed events older than the given duration
+func (r *EventRepository) CountUndelivered(ctx context.Context, olderThan time.Duration) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM events WHERE processed = 0 AND created_at < ?`,
+		now().Add(-olderThan),
+	).Scan(&count)
+	return count, err
+}
+
+// ListStuckDeliveries returns active webhook keys whose due, unprocessed
+// events are old or many enough, oldest first
+func (r *EventRepository) ListStuckDeliveries(ctx context.Context, dueBefore time.Time, minCount int) ([]models.StuckDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT k.id, COALESCE(k.key_prefix, ''), COALESCE(k.name, ''), COALESCE(u.email, ''), COALESCE(u.delivery_alerts, 0),
+		       COUNT(*), MIN(COALESCE(e.deliver_after, e.created_at)) AS oldest_due_at, k.delivery_alerted_at
+		FROM events e
+		JOIN api_keys k ON k.id = e.webhook_key_id
+		LEFT JOIN users u ON u.id = k.user_id
+		WHERE e.processed = 0 AND COALESCE(e.deliver_after, e.created_at) <= ?
+		  AND k.key_type = 'webhook' AND k.is_active = 1
+		GROUP BY k.id
+		HAVING MIN(COALESCE(e.deliver_after, e.created_at)) <= ? OR (? > 0 AND COUNT(*) >= ?)
+		ORDER BY oldest_due_at ASC, k.id
+	`, now(), dueBefore.UTC(), minCount, minCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stuck []models.StuckDelivery
+	for rows.Next() {
+		var s models.StuckDelivery
+		var oldest, alertedAt nullTime
+		if err := rows.Scan(&s.WebhookKeyID, &s.KeyPrefix, &s.KeyName, &s.UserEmail, &s.AlertsEnabled,
+			&s.Undelivered, &oldest, &alertedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan stuck delivery: %w", err)
+		}
+		s.OldestDueAt, s.AlertedAt = oldest.Time, alertedAt.Ptr()
+		stuck = append(stuck, s)
+	}
+	return stuck, rows.Err()
+}
+
+// CountByWebhookKey counts all events for a webhook key
+func (r *EventRepository) CountByWebhookKey(ctx context.Context, webhookKeyID uuid.UUID) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM events WHERE webhook_key_id = ?`, webhookKeyID).Scan(&count)
+	return count, err
+}
+
+// CountByUserEmail counts events across all of a user's webhook keys
+func (r *EventRepository) CountByUserEmail(ctx context.Context, userEmail string) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM events
+		WHERE webhook_key_id IN (
+			SELECT id FROM api_keys WHERE user_id = `+userIDByEmail+` AND key_type = 'webhook'
+		)
+	`, userEmail).Scan(&count)
+	return count, err
+}
+
+var _ repositories.EventRepository = (*EventRepository)(nil)
+
+// StorageStats sums recorded sizes; events from before sizes were recorded only count towards Events
+func (r *EventRepository) StorageStats(ctx context.Context) (*models.EventStorageStats, error) {
+	var stats models.EventStorageStats
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(payload_size), COALESCE(SUM(compressed), 0),
+		       COALESCE(SUM(payload_size), 0), COALESCE(SUM(stored_size), 0)
+		FROM events
+	`).Scan(&stats.Events, &stats.MeasuredEvents, &stats.CompressedEvents, &stats.PayloadBytes, &stats.StoredBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
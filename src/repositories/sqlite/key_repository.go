@@ -0,0 +1,718 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// KeyRepository stores webhook and client keys in the api_keys table
+type KeyRepository struct {
+	db *sql.DB
+}
+
+// NewKeyRepository creates a new SQLite key repository
+func NewKeyRepository(db *sql.DB) *KeyRepository {
+	return &KeyRepository{db: db}
+}
+
+// webhookKeyColumns are the api_keys columns read by scanWebhookKey
+const webhookKeyColumns = `id, COALESCE(pair_id, id), COALESCE(name, ''), key_hash, COALESCE(key_prefix, ''), is_active, created_at, last_used, usage_count,
+	rate_limit_per_minute, signing_secret, allowed_paths, forced_path_prefix`
+
+// scanWebhookKey scans a row selected with webhookKeyColumns
+func scanWebhookKey(row rowScanner) (*models.WebhookKey, error) {
+	var wk models.WebhookKey
+	var isActive bool
+	var allowedPaths *string
+	err := row.Scan(
+		&wk.ID, &wk.PairID, &wk.Name, &wk.KeyHash, &wk.KeyPrefix, &isActive, &wk.CreatedAt, &wk.LastUsed, &wk.EventsCount,
+		&wk.Settings.RateLimitPerMinute, &wk.Settings.SigningSecret, &allowedPaths, &wk.Settings.ForcedPathPrefix,
+	)
+	if err != nil {
+		return nil, err
+	}
+	wk.Status = statusFromBool(isActive)
+	wk.Settings.AllowedPaths = splitPathGlobs(allowedPaths)
+	return &wk, nil
+}
+
+// joinPathGlobs stores path globs one per line, NULL when there are none
+func joinPathGlobs(globs []string) *string {
+	if len(globs) == 0 {
+		return nil
+	}
+	joined := strings.Join(globs, "\n")
+	return &joined
+}
+
+// splitPathGlobs reverses joinPathGlobs
+func splitPathGlobs(stored *string) []string {
+	if stored == nil || *stored == "" {
+		return nil
+	}
+	return strings.Split(*stored, "\n")
+}
+
+// keyHashMatch matches a key by its hash, or by the hash it replaced while
+// the rotation overlap lasts. Its arguments come from keyHashArgs.
+const keyHashMatch = `(key_hash = ? OR (previous_key_hash = ? AND previous_key_expires_at > ?))`
+
+// keyHashArgs returns the arguments of keyHashMatch followed by extra
+func keyHashArgs(keyHash string, extra ...interface{}) []interface{} {
+	return append([]interface{}{keyHash, keyHash, now()}, extra...)
+}
+
+// validateKey returns the is_active flag of a key of the given type
+func (r *KeyRepository) validateKey(ctx context.Context, keyHash string, keyType models.KeyType) (bool, error) {
+	var isActive bool
+	err := r.db.QueryRowContext(ctx,
+		`SELECT is_active FROM api_keys WHERE `+keyHashMatch+` AND key_type = ?`,
+		keyHashArgs(keyHash, string(keyType))...,
+	).Scan(&isActive)
+	if err != nil {
+		return false, mapNoRows(err)
+	}
+	return isActive, nil
+}
+
+// ValidateWebhookKey returns whether the webhook key is active
+func (r *KeyRepository) ValidateWebhookKey(ctx context.Context, keyHash string) (bool, error) {
+	return r.validateKey(ctx, keyHash, models.KeyTypeWebhook)
+}
+
+// ValidateClientKey returns whether the client key is active
+func (r *KeyRepository) ValidateClientKey(ctx context.Context, keyHash string) (bool, error) {
+	return r.validateKey(ctx, keyHash, models.KeyTypeClient)
+}
+
+// GetWebhookKeyByHash retrieves a webhook key by its hash
+func (r *KeyRepository) GetWebhookKeyByHash(ctx context.Context, keyHash string) (*models.WebhookKey, error) {
+	wk, err := scanWebhookKey(r.db.QueryRowContext(ctx,
+		"SELECT "+webhookKeyColumns+" FROM api_keys WHERE "+keyHashMatch+" AND key_type = 'webhook'",
+		keyHashArgs(keyHash)...,
+	))
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return wk, nil
+}
+
+// GetClientKeyByHash retrieves a client key by its hash
+func (r *KeyRepository) GetClientKeyByHash(ctx context.Context, keyHash string) (*models.ClientKey, error) {
+	var ck models.ClientKey
+	var isActive bool
+	var pairID *uuid.UUID
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, key_hash, COALESCE(key_prefix, ''), pair_id, is_active, created_at, last_used, usage_count FROM api_keys WHERE "+keyHashMatch+" AND key_type = 'client'",
+		keyHashArgs(keyHash)...,
+	).Scan(&ck.ID, &ck.KeyHash, &ck.KeyPrefix, &pairID, &isActive, &ck.CreatedAt, &ck.LastConnected, &ck.EventsDelivered)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	if pairID != nil {
+		ck.WebhookKeyID = *pairID
+	}
+	ck.Status = statusFromBool(isActive)
+	return &ck, nil
+}
+
+// GetKeyByID retrieves basic key information by ID
+func (r *KeyRepository) GetKeyByID(ctx context.Context, keyID uuid.UUID) (*models.KeyInfo, error) {
+	var info models.KeyInfo
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, COALESCE(key_prefix, ''), key_type, is_active,
+		        COALESCE((SELECT email FROM users WHERE users.id = api_keys.user_id), '')
+		 FROM api_keys WHERE id = ?`,
+		keyID,
+	).Scan(&info.ID, &info.KeyPrefix, &info.KeyType, &info.IsActive, &info.UserEmail)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return &info, nil
+}
+
+// GetEmailByWebhookKeyHash returns the user email associated with a webhook key hash
+func (r *KeyRepository) GetEmailByWebhookKeyHash(ctx context.Context, keyHash string) (string, error) {
+	var email string
+	err := r.db.QueryRowContext(ctx,
+		"SELECT COALESCE((SELECT email FROM users WHERE users.id = api_keys.user_id), '') FROM api_keys WHERE "+keyHashMatch+" AND key_type = 'webhook'",
+		keyHashArgs(keyHash)...,
+	).Scan(&email)
+	if err != nil {
+		return "", mapNoRows(err)
+	}
+	return email, nil
+}
+
+// insertKey inserts a bare api_keys row (no user information)
+func insertKey(ctx context.Context, exec interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+}, id uuid.UUID, keyHash, keyPrefix string, keyType models.KeyType, pairID uuid.UUID) error {
+	ts := now()
+	_, err := exec.ExecContext(ctx, `
+		INSERT INTO api_keys (id, key_hash, key_prefix, key_type, pair_id, is_active, activated_at, created_at, usage_count, event_ttl_days)
+		VALUES (?, ?, ?, ?, ?, 1, ?, ?, 0, NULL)
+	`, id, keyHash, keyPrefix, string(keyType), pairID, ts, ts)
+	return err
+}
+
+// CreateWebhookKey inserts a standalone webhook key and fills in generated fields
+func (r *KeyRepository) CreateWebhookKey(ctx context.Context, key *models.WebhookKey) error {
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	if err := insertKey(ctx, r.db, key.ID, key.KeyHash, key.KeyPrefix, models.KeyTypeWebhook, key.ID); err != nil {
+		return err
+	}
+	stored, err := r.GetWebhookKeyByHash(ctx, key.KeyHash)
+	if err != nil {
+		return err
+	}
+	stored.KeyValue = key.KeyValue
+	*key = *stored
+	return nil
+}
+
+// CreateClientKey inserts a client key paired with key.WebhookKeyID
+func (r *KeyRepository) CreateClientKey(ctx context.Context, key *models.ClientKey) error {
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	if err := insertKey(ctx, r.db, key.ID, key.KeyHash, key.KeyPrefix, models.KeyTypeClient, key.WebhookKeyID); err != nil {
+		return err
+	}
+	stored, err := r.GetClientKeyByHash(ctx, key.KeyHash)
+	if err != nil {
+		return err
+	}
+	stored.KeyValue = key.KeyValue
+	*key = *stored
+	return nil
+}
+
+// UpdateKeyStatus sets is_active for a key of the given type
+func (r *KeyRepository) UpdateKeyStatus(ctx context.Context, keyHash string, keyType models.KeyType, isActive bool) error {
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE api_keys SET is_active = ? WHERE key_hash = ? AND key_type = ?",
+		isActive, keyHash, string(keyType),
+	)
+	return requireRows(result, err)
+}
+
+// UpdateKeyStatusByID sets is_active for the key of the given type with this ID
+func (r *KeyRepository) UpdateKeyStatusByID(ctx context.Context, keyID uuid.UUID, keyType models.KeyType, isActive bool) error {
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE api_keys SET is_active = ? WHERE id = ? AND key_type = ?",
+		isActive, keyID, string(keyType),
+	)
+	return requireRows(result, err)
+}
+
+// DeactivateKeyByID deactivates a single key by ID
+func (r *KeyRepository) DeactivateKeyByID(ctx context.Context, keyID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE api_keys SET is_active = 0 WHERE id = ?", keyID)
+	return requireRows(result, err)
+}
+
+// requireRows returns ErrNotFound when a statement affected no rows
+func requireRows(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteKeyPair deletes a webhook key and every key sharing its pair_id
+func (r *KeyRepository) DeleteKeyPair(ctx context.Context, webhookKeyHash string) error {
+	var pairID *uuid.UUID
+	err := r.db.QueryRowContext(ctx,
+		"SELECT pair_id FROM api_keys WHERE key_hash = ? AND key_type = 'webhook'",
+		webhookKeyHash,
+	).Scan(&pairID)
+	if err != nil {
+		return mapNoRows(err)
+	}
+
+	// Legacy keys without pair_id are deleted on their own
+	if pairID == nil {
+		_, err = r.db.ExecContext(ctx, "DELETE FROM api_keys WHERE key_hash = ?", webhookKeyHash)
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, "DELETE FROM api_keys WHERE pair_id = ?", *pairID)
+	return err
+}
+
+// IncrementUsage updates last_used and increments usage_count for a key
+func (r *KeyRepository) IncrementUsage(ctx context.Context, keyID uuid.UUID) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE api_keys SET last_used = ?, usage_count = usage_count + 1 WHERE id = ?",
+		now(), keyID,
+	)
+	return err
+}
+
+// GetWebhookKeys returns all webhook keys with their paired client keys
+func (r *KeyRepository) GetWebhookKeys(ctx context.Context) ([]*models.WebhookKey, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT
+			wh.id,
+			wh.key_hash,
+			COALESCE(wh.key_prefix, ''),
+			wh.is_active,
+			wh.created_at,
+			wh.last_used,
+			(SELECT COUNT(*) FROM events WHERE webhook_key_id = wh.id) as events_count,
+			COALESCE(ck.key_prefix, '') as client_key_prefix,
+			COALESCE(wh.pair_id, wh.id),
+			COALESCE(wh.name, ''),
+			wh.rate_limit_per_minute,
+			wh.signing_secret,
+			wh.allowed_paths,
+			wh.forced_path_prefix
+		FROM api_keys wh
+		LEFT JOIN api_keys ck ON ck.pair_id = COALESCE(wh.pair_id, wh.id) AND ck.key_type = 'client'
+		WHERE wh.key_type = 'webhook'
+		ORDER BY wh.created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.WebhookKey
+	for rows.Next() {
+		wk := &models.WebhookKey{}
+		var isActive bool
+		var allowedPaths *string
+		if err := rows.Scan(
+			&wk.ID, &wk.KeyHash, &wk.KeyPrefix, &isActive, &wk.CreatedAt, &wk.LastUsed, &wk.EventsCount, &wk.ClientKeyPrefix,
+			&wk.PairID, &wk.Name, &wk.Settings.RateLimitPerMinute, &wk.Settings.SigningSecret, &allowedPaths, &wk.Settings.ForcedPathPrefix,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook key: %w", err)
+		}
+		wk.Status = statusFromBool(isActive)
+		wk.Settings.AllowedPaths = splitPathGlobs(allowedPaths)
+		keys = append(keys, wk)
+	}
+	return keys, rows.Err()
+}
+
+// GetClientKeys returns all client keys
+func (r *KeyRepository) GetClientKeys(ctx context.Context) ([]*models.ClientKey, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, key_hash, COALESCE(key_prefix, ''), pair_id, is_active, created_at, last_used, usage_count
+		FROM api_keys
+		WHERE key_type = 'client'
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.ClientKey
+	for rows.Next() {
+		ck := &models.ClientKey{}
+		var isActive bool
+		var pairID *uuid.UUID
+		if err := rows.Scan(&ck.ID, &ck.KeyHash, &ck.KeyPrefix, &pairID, &isActive, &ck.CreatedAt, &ck.LastConnected, &ck.EventsDelivered); err != nil {
+			return nil, fmt.Errorf("failed to scan client key: %w", err)
+		}
+		if pairID != nil {
+			ck.WebhookKeyID = *pairID
+		}
+		ck.Status = statusFromBool(isActive)
+		keys = append(keys, ck)
+	}
+	return keys, rows.Err()
+}
+
+// CreateKeyPair inserts a webhook key and its client key in a single transaction.
+// The client key's pair_id references the webhook key's id.
+func (r *KeyRepository) CreateKeyPair(ctx context.Context, webhookKey *models.WebhookKey, clientKey *models.ClientKey) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if webhookKey.ID == uuid.Nil {
+		webhookKey.ID = uuid.New()
+	}
+	if clientKey.ID == uuid.Nil {
+		clientKey.ID = uuid.New()
+	}
+
+	if err := insertKey(ctx, tx, webhookKey.ID, webhookKey.KeyHash, webhookKey.KeyPrefix, models.KeyTypeWebhook, webhookKey.ID); err != nil {
+		return fmt.Errorf("failed to create webhook key: %w", err)
+	}
+	if err := insertKey(ctx, tx, clientKey.ID, clientKey.KeyHash, clientKey.KeyPrefix, models.KeyTypeClient, webhookKey.ID); err != nil {
+		return fmt.Errorf("failed to create client key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	stored, err := r.GetWebhookKeyByHash(ctx, webhookKey.KeyHash)
+	if err != nil {
+		return err
+	}
+	stored.KeyValue = webhookKey.KeyValue
+	*webhookKey = *stored
+
+	storedClient, err := r.GetClientKeyByHash(ctx, clientKey.KeyHash)
+	if err != nil {
+		return err
+	}
+	storedClient.KeyValue = clientKey.KeyValue
+	*clientKey = *storedClient
+	return nil
+}
+
+// CreateUserKeyPair inserts a webhook+client key pair owned by a user in a single transaction
+func (r *KeyRepository) CreateUserKeyPair(ctx context.Context, userID uuid.UUID, webhookKey *models.WebhookKey, clientKey *models.ClientKey) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	insert := `
+		INSERT INTO api_keys (id, key_hash, key_prefix, key_type, pair_id, user_id, activated_at, created_at, event_ttl_days)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULL)
+	`
+	ts := now()
+	webhookKeyID := uuid.New()
+	if _, err := tx.ExecContext(ctx, insert,
+		webhookKeyID, webhookKey.KeyHash, webhookKey.KeyPrefix, string(models.KeyTypeWebhook), webhookKeyID, userID, ts, ts,
+	); err != nil {
+		return fmt.Errorf("failed to insert webhook key: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, insert,
+		uuid.New(), clientKey.KeyHash, clientKey.KeyPrefix, string(models.KeyTypeClient), webhookKeyID, userID, ts, ts,
+	); err != nil {
+		return fmt.Errorf("failed to insert client key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// userIDByEmail selects the ID of the user whose email is the bound parameter
+const userIDByEmail = "(SELECT id FROM users WHERE email = ?)"
+
+// CountUserKeys returns the count of active keys of a specific type for a user
+func (r *KeyRepository) CountUserKeys(ctx context.Context, userEmail string, keyType models.KeyType) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM api_keys WHERE user_id = "+userIDByEmail+" AND key_type = ? AND is_active = 1",
+		userEmail, string(keyType),
+	).Scan(&count)
+	return count, err
+}
+
+// ListUserKeyPairs returns all key pairs for a user, ordered newest first
+func (r *KeyRepository) ListUserKeyPairs(ctx context.Context, userEmail string) ([]models.KeyPair, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT
+			wk.id,
+			COALESCE(wk.key_prefix, ''),
+			COALESCE(ck.key_prefix, ''),
+			wk.is_active,
+			wk.created_at,
+			wk.last_used,
+			wk.usage_count,
+			wk.event_ttl_days,
+			wk.processed_ttl_days,
+			wk.previous_key_expires_at,
+			ck.previous_key_expires_at
+		FROM api_keys wk
+		LEFT JOIN api_keys ck ON ck.pair_id = wk.id AND ck.key_type = 'client'
+		WHERE wk.user_id = `+userIDByEmail+` AND wk.key_type = 'webhook' AND (wk.pair_id IS NULL OR wk.pair_id = wk.id)
+		ORDER BY wk.created_at DESC
+	`, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pairs []models.KeyPair
+	ts := now()
+	for rows.Next() {
+		var p models.KeyPair
+		var previousWebhook, previousClient nullTime
+		if err := rows.Scan(
+			&p.PairID, &p.WebhookKeyPrefix, &p.ClientKeyPrefix, &p.IsActive, &p.CreatedAt, &p.LastUsed, &p.UsageCount,
+			&p.Retention.EventTTLDays, &p.Retention.ProcessedTTLDays, &previousWebhook, &previousClient,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan key pair: %w", err)
+		}
+		// Expired values linger until cleanup clears them
+		if previousWebhook.Valid && previousWebhook.Time.After(ts) {
+			p.PreviousWebhookKeyExpiresAt = previousWebhook.Ptr()
+		}
+		if previousClient.Valid && previousClient.Time.After(ts) {
+			p.PreviousClientKeyExpiresAt = previousClient.Ptr()
+		}
+		pairs = append(pairs, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return pairs, r.attachWebhookKeys(ctx, userEmail, pairs)
+}
+
+// attachWebhookKeys fills in every webhook key of the user's pairs, the original first
+func (r *KeyRepository) attachWebhookKeys(ctx context.Context, userEmail string, pairs []models.KeyPair) error {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+webhookKeyColumns+` FROM api_keys
+		WHERE user_id = `+userIDByEmail+` AND key_type = 'webhook'
+		ORDER BY id = COALESCE(pair_id, id) DESC, created_at, id
+	`, userEmail)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byPair := make(map[string]*models.KeyPair, len(pairs))
+	for i := range pairs {
+		byPair[pairs[i].PairID] = &pairs[i]
+	}
+	for rows.Next() {
+		wk, err := scanWebhookKey(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan webhook key: %w", err)
+		}
+		if p, ok := byPair[wk.PairID.String()]; ok {
+			p.WebhookKeys = append(p.WebhookKeys, *wk)
+		}
+	}
+	return rows.Err()
+}
+
+// DeactivateUserKeyPair deactivates both keys of a pair, scoped to its owner
+func (r *KeyRepository) DeactivateUserKeyPair(ctx context.Context, pairID uuid.UUID, userEmail string) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_keys SET is_active = 0
+		WHERE pair_id = ? AND user_id = `+userIDByEmail+` AND is_active = 1
+	`, pairID, userEmail)
+	return requireRows(result, err)
+}
+
+// CreatePairWebhookKey adds a named webhook key to one of the user's active pairs
+func (r *KeyRepository) CreatePairWebhookKey(ctx context.Context, pairID uuid.UUID, userEmail string, key *models.WebhookKey) error {
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	ts := now()
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO api_keys (
+			id, key_hash, key_prefix, key_type, pair_id, is_active, activated_at, created_at, usage_count, event_ttl_days, user_id,
+			name, rate_limit_per_minute, signing_secret, allowed_paths, forced_path_prefix
+		)
+		SELECT ?, ?, ?, 'webhook', id, 1, ?, ?, 0, NULL, user_id, ?, ?, ?, ?, ?
+		FROM api_keys
+		WHERE id = ? AND COALESCE(pair_id, id) = id AND key_type = 'webhook' AND user_id = `+userIDByEmail+` AND is_active = 1
+	`, key.ID, key.KeyHash, key.KeyPrefix, ts, ts, key.Name, key.Settings.RateLimitPerMinute, key.Settings.SigningSecret,
+		joinPathGlobs(key.Settings.AllowedPaths), key.Settings.ForcedPathPrefix,
+		pairID, userEmail)
+	if err := requireRows(result, err); err != nil {
+		return err
+	}
+	key.PairID = pairID
+	key.Status = statusFromBool(true)
+	key.CreatedAt = ts
+	key.EventsCount = 0
+	return nil
+}
+
+// UpdateWebhookKeySettings replaces the name and settings of one of the user's webhook keys
+func (r *KeyRepository) UpdateWebhookKeySettings(ctx context.Context, keyID uuid.UUID, userEmail, name string, settings models.WebhookKeySettings) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_keys
+		SET name = NULLIF(?, ''), rate_limit_per_minute = ?, signing_secret = ?, allowed_paths = ?, forced_path_prefix = ?
+		WHERE id = ? AND user_id = `+userIDByEmail+` AND key_type = 'webhook'
+	`, name, settings.RateLimitPerMinute, settings.SigningSecret, joinPathGlobs(settings.AllowedPaths), settings.ForcedPathPrefix, keyID, userEmail)
+	return requireRows(result, err)
+}
+
+// SetWebhookKeyPathRules replaces the path allowlist and forced prefix of any webhook key
+func (r *KeyRepository) SetWebhookKeyPathRules(ctx context.Context, keyID uuid.UUID, allowedPaths []string, forcedPrefix *string) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_keys SET allowed_paths = ?, forced_path_prefix = ?
+		WHERE id = ? AND key_type = 'webhook'
+	`, joinPathGlobs(allowedPaths), forcedPrefix, keyID)
+	return requireRows(result, err)
+}
+
+// DeactivateUserWebhookKey revokes one of the user's named webhook keys
+func (r *KeyRepository) DeactivateUserWebhookKey(ctx context.Context, keyID uuid.UUID, userEmail string) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_keys SET is_active = 0
+		WHERE id = ? AND user_id = `+userIDByEmail+` AND key_type = 'webhook' AND pair_id <> id AND is_active = 1
+	`, keyID, userEmail)
+	return requireRows(result, err)
+}
+
+// RotateKey gives the pair's original webhook key or newest active client key a new hash. The old
+// hash keeps resolving until previousExpiresAt; nil retires it at once.
+func (r *KeyRepository) RotateKey(ctx context.Context, pairID uuid.UUID, keyType models.KeyType, newHash, newPrefix string, previousExpiresAt *time.Time) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_keys
+		SET previous_key_hash = CASE WHEN ? IS NULL THEN NULL ELSE key_hash END,
+			previous_key_expires_at = ?,
+			key_hash = ?,
+			key_prefix = ?
+		WHERE id = (
+			SELECT id FROM api_keys
+			WHERE pair_id = ? AND key_type = ? AND is_active = 1
+			  AND (key_type = 'client' OR id = pair_id)
+			ORDER BY created_at DESC
+			LIMIT 1
+		)
+	`, utc(previousExpiresAt), utc(previousExpiresAt), newHash, newPrefix, pairID, string(keyType))
+	return requireRows(result, err)
+}
+
+// ClearExpiredRotations forgets up to limit previous key hashes that expired before cutoff
+func (r *KeyRepository) ClearExpiredRotations(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_keys SET previous_key_hash = NULL, previous_key_expires_at = NULL
+		WHERE id IN (
+			SELECT id FROM api_keys
+			WHERE previous_key_hash IS NOT NULL AND previous_key_expires_at < ?
+			LIMIT ?
+		)
+	`, cutoff.UTC(), limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ListUnhashedKeys returns up to limit keys still stored in the clear, which
+// the key hashing migration left without a prefix
+func (r *KeyRepository) ListUnhashedKeys(ctx context.Context, limit int) ([]models.LegacyKey, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, key_hash, previous_key_hash FROM api_keys WHERE key_prefix IS NULL ORDER BY id LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.LegacyKey
+	for rows.Next() {
+		var k models.LegacyKey
+		if err := rows.Scan(&k.ID, &k.KeyValue, &k.PreviousKeyValue); err != nil {
+			return nil, fmt.Errorf("failed to scan unhashed key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// StoreKeyHash replaces an unhashed key's stored values with their hashes
+func (r *KeyRepository) StoreKeyHash(ctx context.Context, keyID uuid.UUID, keyHash, keyPrefix string, previousKeyHash *string) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_keys SET key_hash = ?, key_prefix = ?, previous_key_hash = ?
+		WHERE id = ? AND key_prefix IS NULL
+	`, keyHash, keyPrefix, previousKeyHash, keyID)
+	return requireRows(result, err)
+}
+
+// GetRetention returns the retention overrides of a webhook key
+func (r *KeyRepository) GetRetention(ctx context.Context, webhookKeyID uuid.UUID) (*models.RetentionSettings, error) {
+	settings := &models.RetentionSettings{}
+	err := r.db.QueryRowContext(ctx,
+		"SELECT event_ttl_days, processed_ttl_days FROM api_keys WHERE id = ? AND key_type = 'webhook'",
+		webhookKeyID,
+	).Scan(&settings.EventTTLDays, &settings.ProcessedTTLDays)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return settings, nil
+}
+
+// SetRetention replaces the retention overrides of a webhook key
+func (r *KeyRepository) SetRetention(ctx context.Context, webhookKeyID uuid.UUID, settings models.RetentionSettings) error {
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE api_keys SET event_ttl_days = ?, processed_ttl_days = ? WHERE id = ? AND key_type = 'webhook'",
+		settings.EventTTLDays, settings.ProcessedTTLDays, webhookKeyID,
+	)
+	return requireRows(result, err)
+}
+
+// SetDeliveryAlertedAt records when the stuck delivery monitor last alerted about a webhook key
+func (r *KeyRepository) SetDeliveryAlertedAt(ctx context.Context, webhookKeyID uuid.UUID, at time.Time) error {
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE api_keys SET delivery_alerted_at = ? WHERE id = ? AND key_type = 'webhook'",
+		at.UTC(), webhookKeyID,
+	)
+	return requireRows(result, err)
+}
+
+// SetE2EPublicKey stores or clears the end-to-end public key of a client key
+func (r *KeyRepository) SetE2EPublicKey(ctx context.Context, clientKeyID uuid.UUID, publicKey *string) error {
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE api_keys SET e2e_public_key = ? WHERE id = ? AND key_type = 'client'",
+		publicKey, clientKeyID,
+	)
+	return requireRows(result, err)
+}
+
+// GetE2EPublicKey returns the newest public key registered on an active client key of the pair
+func (r *KeyRepository) GetE2EPublicKey(ctx context.Context, webhookKeyID uuid.UUID) (string, error) {
+	var publicKey string
+	err := r.db.QueryRowContext(ctx,
+		`SELECT e2e_public_key FROM api_keys
+		 WHERE pair_id = ? AND key_type = 'client' AND is_active = 1 AND e2e_public_key IS NOT NULL
+		 ORDER BY created_at DESC
+		 LIMIT 1`,
+		webhookKeyID,
+	).Scan(&publicKey)
+	if err != nil {
+		return "", mapNoRows(err)
+	}
+	return publicKey, nil
+}
+
+// DeleteInactiveKeyPairs deletes up to limit fully inactive pairs idle since idleSince.
+// Their events and logs go with them through ON DELETE CASCADE.
+func (r *KeyRepository) DeleteInactiveKeyPairs(ctx context.Context, idleSince time.Time, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM api_keys
+		WHERE COALESCE(pair_id, id) IN (
+			SELECT COALESCE(pair_id, id) FROM api_keys
+			GROUP BY COALESCE(pair_id, id)
+			HAVING MAX(is_active) = 0 AND MAX(COALESCE(last_used, created_at)) < ?
+			LIMIT ?
+		)
+	`, idleSince.UTC(), limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+var _ repositories.KeyRepository = (*KeyRepository)(nil)
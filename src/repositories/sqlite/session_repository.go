@@ -0,0 +1,141 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+)
+
+// SessionRepository stores dashboard and admin sessions in the sessions table
+type SessionRepository struct {
+	db *sql.DB
+}
+
+// NewSessionRepository creates a new SQLite session repository
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+// sessionColumns is the column list scanned by scanSession
+const sessionColumns = `id, user_id, admin_id, ip, user_agent, created_at, last_seen_at, expires_at, revoked_at`
+
+// scanSession scans a row selected with sessionColumns
+func scanSession(row rowScanner) (models.Session, error) {
+	var s models.Session
+	err := row.Scan(&s.ID, &s.UserID, &s.AdminID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.RevokedAt)
+	return s, err
+}
+
+// Create stores a new session
+func (r *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO sessions (id, user_id, admin_id, ip, user_agent, created_at, last_seen_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.AdminID, session.IP, session.UserAgent,
+		session.CreatedAt.UTC(), session.LastSeenAt.UTC(), session.ExpiresAt.UTC(),
+	)
+	return err
+}
+
+// Get looks up a session by ID
+func (r *SessionRepository) Get(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	s, err := scanSession(r.db.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return &s, nil
+}
+
+// ListActiveByUser returns a user's active sessions, most recently seen first
+func (r *SessionRepository) ListActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+sessionColumns+` FROM sessions
+		 WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		 ORDER BY last_seen_at DESC, created_at DESC, rowid DESC`,
+		userID, now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchLastSeen records when a session was last used
+func (r *SessionRepository) TouchLastSeen(ctx context.Context, id uuid.UUID, at time.Time) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE sessions SET last_seen_at = ? WHERE id = ?`, at.UTC(), id)
+	return requireRows(result, err)
+}
+
+// Revoke marks a session revoked
+func (r *SessionRepository) Revoke(ctx context.Context, id uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`,
+		now(), id,
+	)
+	return requireRows(result, err)
+}
+
+// RevokeUserSession marks one of a user's sessions revoked
+func (r *SessionRepository) RevokeUserSession(ctx context.Context, id, userID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`,
+		now(), id, userID,
+	)
+	return requireRows(result, err)
+}
+
+// RevokeAllByUser revokes every active session of a user
+func (r *SessionRepository) RevokeAllByUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	at := now()
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = ?
+		 WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?`,
+		at, userID, at,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RevokeAllByAdmin revokes every active session of an admin
+func (r *SessionRepository) RevokeAllByAdmin(ctx context.Context, adminID uuid.UUID) (int64, error) {
+	at := now()
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = ?
+		 WHERE admin_id = ? AND revoked_at IS NULL AND expires_at > ?`,
+		at, adminID, at,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpired deletes up to limit sessions that expired or were revoked before cutoff
+func (r *SessionRepository) DeleteExpired(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM sessions WHERE id IN (
+			SELECT id FROM sessions WHERE expires_at < ? OR revoked_at < ? LIMIT ?
+		)`,
+		cutoff.UTC(), cutoff.UTC(), limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
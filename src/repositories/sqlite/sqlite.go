@@ -0,0 +1,119 @@
+// Package sqlite implements the repository interfaces on top of database/sql
+// and a SQLite database, for single-user deployments without PostgreSQL.
+//
+// All timestamps are written as UTC so that their text form sorts
+// chronologically; the connection must be opened with _time_format=sqlite.
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// timeLayout matches the text written by the driver with _time_format=sqlite
+const timeLayout = "2006-01-02 15:04:05.999999999-07:00"
+
+// New creates SQLite-backed repositories sharing one database handle
+func New(db *sql.DB) *repositories.Repositories {
+	return &repositories.Repositories{
+		Keys:        NewKeyRepository(db),
+		Users:       NewUserRepository(db),
+		Events:      NewEventRepository(db),
+		WebhookLogs: NewWebhookLogRepository(db),
+		Admins:      NewAdminRepository(db),
+		AuthTokens:  NewAuthTokenRepository(db),
+		DataKeys:    NewDataKeyRepository(db),
+		APITokens:   NewAPITokenRepository(db),
+		Sessions:    NewSessionRepository(db),
+		Audit:       NewAuditRepository(db),
+	}
+}
+
+// blobRefValue stores an empty blob reference as NULL
+func blobRefValue(ref string) *string {
+	if ref == "" {
+		return nil
+	}
+	return &ref
+}
+
+// mapNoRows converts sql.ErrNoRows into repositories.ErrNotFound
+func mapNoRows(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.ErrNotFound
+	}
+	return err
+}
+
+// now returns the current time in UTC, the only zone stored in the database
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// utc converts an optional time to UTC for storage
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// nullTime scans aggregate results (MIN/MAX), which SQLite returns as untyped text
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+// Scan implements sql.Scanner
+func (nt *nullTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		nt.Valid = false
+		return nil
+	case time.Time:
+		nt.Time, nt.Valid = v, true
+		return nil
+	case string:
+		return nt.parse(v)
+	case []byte:
+		return nt.parse(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into time", value)
+	}
+}
+
+// parse reads the stored text form, tolerating the "T" separator and a missing zone
+func (nt *nullTime) parse(s string) error {
+	s = strings.Replace(s, "T", " ", 1)
+	for _, layout := range []string{timeLayout, "2006-01-02 15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			nt.Time, nt.Valid = t, true
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot parse time %q", s)
+}
+
+// Ptr returns the time as a pointer, nil when NULL
+func (nt nullTime) Ptr() *time.Time {
+	if !nt.Valid {
+		return nil
+	}
+	t := nt.Time
+	return &t
+}
+
+// statusFromBool converts is_active to the status string used by key models
+func statusFromBool(isActive bool) string {
+	if isActive {
+		return string(models.KeyStatusActive)
+	}
+	return string(models.KeyStatusInactive)
+}
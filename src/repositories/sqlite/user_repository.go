@@ -0,0 +1,153 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// UserRepository stores user accounts in the users table
+type UserRepository struct {
+	db *sql.DB
+}
+
+// NewUserRepository creates a new SQLite user repository
+func NewUserRepository(db *sql.DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+// Upsert creates a user or updates the name and language of the existing one
+func (r *UserRepository) Upsert(ctx context.Context, profile *models.UserProfile) error {
+	return r.db.QueryRowContext(ctx, `
+		INSERT INTO users (id, email, name, preferred_language, email_verified, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (email) DO UPDATE
+		SET name = excluded.name,
+		    preferred_language = excluded.preferred_language,
+		    email_verified = MAX(users.email_verified, excluded.email_verified)
+		RETURNING id, email_verified, delivery_alerts
+	`, uuid.New(), profile.Email, profile.Name, profile.Language, profile.EmailVerified, now(),
+	).Scan(&profile.ID, &profile.EmailVerified, &profile.DeliveryAlerts)
+}
+
+// GetByEmail returns a user's profile
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.UserProfile, error) {
+	profile := &models.UserProfile{Email: email}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id, name, preferred_language, email_verified, delivery_alerts FROM users WHERE email = ?
+	`, email).Scan(&profile.ID, &profile.Name, &profile.Language, &profile.EmailVerified, &profile.DeliveryAlerts)
+	if err != nil {
+		return nil, mapNoRows(err)
+	}
+	return profile, nil
+}
+
+// newestUserKey selects a column of the newest original webhook key or client
+// key of the user u, the pair the admin panel shows and suspends
+func newestUserKey(column string, keyType models.KeyType) string {
+	return `(SELECT nk.` + column + ` FROM api_keys nk
+		WHERE nk.user_id = u.id AND nk.key_type = '` + string(keyType) + `' AND (nk.key_type = 'client' OR nk.pair_id IS NULL OR nk.pair_id = nk.id)
+		ORDER BY nk.created_at DESC, nk.id DESC LIMIT 1)`
+}
+
+// List returns all users with totals over their keys, ordered by created_at DESC
+func (r *UserRepository) List(ctx context.Context) ([]models.User, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT
+			u.id,
+			u.email,
+			u.name,
+			COALESCE(MAX(k.is_active), 0) as is_active,
+			u.created_at,
+			MAX(k.last_used) as last_used,
+			COALESCE(SUM(k.usage_count), 0) as usage_count,
+			COUNT(k.id) FILTER (WHERE k.key_type = 'webhook') as webhook_key_count,
+			COUNT(k.id) FILTER (WHERE k.key_type = 'client') as client_key_count,
+			COALESCE(`+newestUserKey("key_prefix", models.KeyTypeWebhook)+`, '') as webhook_key_prefix,
+			COALESCE(`+newestUserKey("key_prefix", models.KeyTypeClient)+`, '') as client_key_prefix,
+			`+newestUserKey("id", models.KeyTypeWebhook)+` as webhook_key_id,
+			`+newestUserKey("id", models.KeyTypeClient)+` as client_key_id
+		FROM users u
+		LEFT JOIN api_keys k ON k.user_id = u.id
+		GROUP BY u.id
+		ORDER BY u.created_at DESC, u.email
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		var lastUsed nullTime
+		if err := rows.Scan(
+			&u.ID, &u.UserEmail, &u.UserName, &u.IsActive, &u.CreatedAt, &lastUsed, &u.UsageCount,
+			&u.WebhookKeyCount, &u.ClientKeyCount, &u.WebhookKeyPrefix, &u.ClientKeyPrefix, &u.WebhookKeyID, &u.ClientKeyID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		u.LastUsed = lastUsed.Ptr()
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// Get returns a user's creation time and most recent active keys
+func (r *UserRepository) Get(ctx context.Context, email string) (*models.User, error) {
+	user := &models.User{UserEmail: email}
+	if err := r.db.QueryRowContext(ctx,
+		"SELECT id, name, created_at FROM users WHERE email = ?",
+		email,
+	).Scan(&user.ID, &user.UserName, &user.CreatedAt); err != nil {
+		return nil, mapNoRows(err)
+	}
+
+	latestActive := `
+		SELECT COALESCE(key_prefix, '') FROM api_keys
+		WHERE user_id = ? AND key_type = ? AND is_active = 1
+		  AND (key_type = 'client' OR pair_id IS NULL OR pair_id = id)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	_ = r.db.QueryRowContext(ctx, latestActive, user.ID, string(models.KeyTypeWebhook)).Scan(&user.WebhookKeyPrefix)
+	_ = r.db.QueryRowContext(ctx, latestActive, user.ID, string(models.KeyTypeClient)).Scan(&user.ClientKeyPrefix)
+
+	return user, nil
+}
+
+// Delete removes a user with their keys; the keys' events, webhook logs and
+// data keys and the user's API tokens and sessions cascade. ErrNotFound if
+// there is no such user.
+func (r *UserRepository) Delete(ctx context.Context, email string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var userID uuid.UUID
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE email = ?", email).Scan(&userID); err != nil {
+		return mapNoRows(err)
+	}
+	// api_keys.user_id has no foreign key here (see migration 0011)
+	if _, err := tx.ExecContext(ctx, "DELETE FROM api_keys WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to delete keys: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetDeliveryAlerts turns a user's stuck delivery emails on or off
+func (r *UserRepository) SetDeliveryAlerts(ctx context.Context, email string, enabled bool) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE users SET delivery_alerts = ? WHERE email = ?", enabled, email)
+	return requireRows(result, err)
+}
+
+var _ repositories.UserRepository = (*UserRepository)(nil)
@@ -0,0 +1,140 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// WebhookLogRepository stores delivery logs in the webhook_logs table
+type WebhookLogRepository struct {
+	db *sql.DB
+}
+
+// NewWebhookLogRepository creates a new SQLite webhook log repository
+func NewWebhookLogRepository(db *sql.DB) *WebhookLogRepository {
+	return &WebhookLogRepository{db: db}
+}
+
+// Create inserts a pending log entry for a new event
+func (r *WebhookLogRepository) Create(ctx context.Context, eventID, webhookKeyID uuid.UUID, statusCode int) error {
+	ts := now()
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO webhook_logs (id, event_id, webhook_key_id, delivery_status, status_code, attempted_at, created_at)
+		VALUES (?, ?, ?, 'pending', ?, ?, ?)
+	`, uuid.New(), eventID, webhookKeyID, statusCode, ts, ts)
+	return err
+}
+
+// MarkDelivered moves a pending log entry to delivered
+func (r *WebhookLogRepository) MarkDelivered(ctx context.Context, eventID, webhookKeyID, clientKeyID uuid.UUID) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE webhook_logs
+		SET delivery_status = 'delivered', delivered_at = ?, client_key_id = ?
+		WHERE event_id = ? AND webhook_key_id = ? AND delivery_status = 'pending'
+	`, now(), clientKeyID, eventID, webhookKeyID)
+	return err
+}
+
+// MarkAcked moves pending or delivered log entries to acked
+func (r *WebhookLogRepository) MarkAcked(ctx context.Context, eventID uuid.UUID) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE webhook_logs
+		SET delivery_status = 'acked', acked_at = ?
+		WHERE event_id = ? AND delivery_status IN ('pending', 'delivered')
+	`, now(), eventID)
+	return err
+}
+
+// GetLatestStatus returns the delivery status of the most recent log entry for an event
+func (r *WebhookLogRepository) GetLatestStatus(ctx context.Context, eventID uuid.UUID) (string, error) {
+	var status string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT delivery_status FROM webhook_logs
+		WHERE event_id = ?
+		ORDER BY attempted_at DESC
+		LIMIT 1
+	`, eventID).Scan(&status)
+	if err != nil {
+		return "", mapNoRows(err)
+	}
+	return status, nil
+}
+
+// CountByUserEmail counts log entries across all of a user's webhook keys
+func (r *WebhookLogRepository) CountByUserEmail(ctx context.Context, userEmail string) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM webhook_logs
+		WHERE webhook_key_id IN (
+			SELECT id FROM api_keys WHERE user_id = `+userIDByEmail+` AND key_type = 'webhook'
+		)
+	`, userEmail).Scan(&count)
+	return count, err
+}
+
+// ListByUserEmail returns a user's log entries, newest attempt first
+func (r *WebhookLogRepository) ListByUserEmail(ctx context.Context, userEmail string, limit, offset int) ([]models.WebhookLog, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT
+			wl.id,
+			wl.event_id,
+			wl.webhook_key_id,
+			wl.client_key_id,
+			wl.delivery_status,
+			wl.status_code,
+			wl.error_message,
+			wl.attempted_at,
+			wl.delivered_at,
+			wl.acked_at,
+			wl.client_ip,
+			wl.created_at
+		FROM webhook_logs wl
+		WHERE wl.webhook_key_id IN (
+			SELECT id FROM api_keys WHERE user_id = `+userIDByEmail+` AND key_type = 'webhook'
+		)
+		ORDER BY wl.attempted_at DESC
+		LIMIT ? OFFSET ?
+	`, userEmail, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []models.WebhookLog
+	for rows.Next() {
+		var wl models.WebhookLog
+		if err := rows.Scan(
+			&wl.ID, &wl.EventID, &wl.WebhookKeyID, &wl.ClientKeyID, &wl.DeliveryStatus, &wl.StatusCode,
+			&wl.ErrorMessage, &wl.AttemptedAt, &wl.DeliveredAt, &wl.AckedAt, &wl.ClientIP, &wl.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook log: %w", err)
+		}
+		logs = append(logs, wl)
+	}
+	return logs, rows.Err()
+}
+
+// DeleteOrphaned deletes up to limit log entries whose event or webhook key is gone
+func (r *WebhookLogRepository) DeleteOrphaned(ctx context.Context, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM webhook_logs
+		WHERE id IN (
+			SELECT wl.id FROM webhook_logs wl
+			WHERE NOT EXISTS (SELECT 1 FROM events e WHERE e.id = wl.event_id)
+			   OR NOT EXISTS (SELECT 1 FROM api_keys k WHERE k.id = wl.webhook_key_id)
+			LIMIT ?
+		)
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+var _ repositories.WebhookLogRepository = (*WebhookLogRepository)(nil)
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// accountLogPageSize is how many webhook log entries Export reads at a time
+const accountLogPageSize = 500
+
+// AccountService lets users download everything stored about them and
+// delete their account
+type AccountService struct {
+	users     repositories.UserRepository
+	keys      *KeyService
+	events    *EventService
+	apiTokens *APITokenService
+	sessions  *SessionService
+	audit     *AuditService
+}
+
+// NewAccountService creates a new account service
+func NewAccountService(users repositories.UserRepository, keys *KeyService, events *EventService) *AccountService {
+	return &AccountService{users: users, keys: keys, events: events}
+}
+
+// SetAPITokens includes the user's API tokens in exports
+func (s *AccountService) SetAPITokens(apiTokens *APITokenService) {
+	s.apiTokens = apiTokens
+}
+
+// SetSessions includes the user's sessions in exports and drops them from
+// the validation cache on deletion, so the deleted account is signed out at once
+func (s *AccountService) SetSessions(sessions *SessionService) {
+	s.sessions = sessions
+}
+
+// SetAudit includes the audit events about the user in exports
+func (s *AccountService) SetAudit(audit *AuditService) {
+	s.audit = audit
+}
+
+// accountArchive is account.json in an export
+type accountArchive struct {
+	ExportedAt time.Time          `json:"exported_at"`
+	Profile    models.UserProfile `json:"profile"`
+	KeyPairs   []models.KeyPair   `json:"key_pairs"`
+	APITokens  []models.APIToken  `json:"api_tokens"`
+	Sessions   []models.Session   `json:"sessions"`
+}
+
+// exportedEvent is one line of events.ndjson. Text payloads are written as
+// is; binary and sealed ones in base64.
+type exportedEvent struct {
+	ID           uuid.UUID  `json:"id"`
+	PairID       uuid.UUID  `json:"pair_id"`
+	Path         string     `json:"path"`
+	Data         *string    `json:"data,omitempty"`
+	DataBase64   []byte     `json:"data_base64,omitempty"`
+	Sealed       bool       `json:"sealed"`
+	Processed    bool       `json:"processed"`
+	ProcessedAt  *time.Time `json:"processed_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	DeliverAfter *time.Time `json:"deliver_after,omitempty"`
+}
+
+// Export writes a zip archive of the user's data to w: account.json with the
+// profile, key pairs (prefixes only), API tokens and active sessions, and one
+// JSON object per line in events.ndjson (decrypted), webhook_logs.ndjson and
+// audit.ndjson
+func (s *AccountService) Export(ctx context.Context, email string, w io.Writer) error {
+	profile, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	archive := accountArchive{ExportedAt: time.Now().UTC(), Profile: *profile}
+	if archive.KeyPairs, err = s.keys.GetUserKeyPairs(ctx, email); err != nil {
+		return err
+	}
+	if s.apiTokens != nil {
+		if archive.APITokens, err = s.apiTokens.List(ctx, email); err != nil {
+			return err
+		}
+	}
+	if s.sessions != nil {
+		if archive.Sessions, err = s.sessions.ListUserSessions(ctx, email); err != nil {
+			return err
+		}
+	}
+
+	zw := zip.NewWriter(w)
+	if err := writeArchiveFile(zw, "account.json", func(f io.Writer) error {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(archive)
+	}); err != nil {
+		return err
+	}
+
+	if err := writeArchiveFile(zw, "events.ndjson", func(f io.Writer) error {
+		enc := json.NewEncoder(f)
+		for _, pair := range archive.KeyPairs {
+			pairID, err := uuid.Parse(pair.PairID)
+			if err != nil {
+				return fmt.Errorf("invalid pair ID %q: %w", pair.PairID, err)
+			}
+			err = s.events.ExportEvents(ctx, pairID, func(e *models.Event) error {
+				return enc.Encode(exportEvent(e))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := writeArchiveFile(zw, "webhook_logs.ndjson", func(f io.Writer) error {
+		enc := json.NewEncoder(f)
+		for offset := 0; ; offset += accountLogPageSize {
+			entries, _, err := s.keys.GetUserWebhookLogEntries(ctx, email, accountLogPageSize, offset)
+			if err != nil {
+				return err
+			}
+			for i := range entries {
+				if err := enc.Encode(entries[i]); err != nil {
+					return err
+				}
+			}
+			if len(entries) < accountLogPageSize {
+				return nil
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	if s.audit != nil {
+		if err := writeArchiveFile(zw, "audit.ndjson", func(f io.Writer) error {
+			enc := json.NewEncoder(f)
+			return s.audit.Export(ctx, models.AuditFilter{UserEmail: email}, func(e *models.AuditEvent) error {
+				return enc.Encode(e.ForUser())
+			})
+		}); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// writeArchiveFile adds a file to zw with the content write produces
+func writeArchiveFile(zw *zip.Writer, name string, write func(io.Writer) error) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	if err := write(f); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// exportEvent converts a decrypted event for events.ndjson
+func exportEvent(e *models.Event) exportedEvent {
+	out := exportedEvent{
+		ID:           e.ID,
+		PairID:       e.WebhookKeyID,
+		Path:         e.Path,
+		Sealed:       e.Sealed,
+		Processed:    e.Processed,
+		ProcessedAt:  e.ProcessedAt,
+		CreatedAt:    e.CreatedAt,
+		ExpiresAt:    e.ExpiresAt,
+		DeliverAfter: e.DeliverAfter,
+	}
+	if !e.Sealed && utf8.Valid(e.Data) {
+		data := string(e.Data)
+		out.Data = &data
+	} else {
+		out.DataBase64 = e.Data
+	}
+	return out
+}
+
+// AccountDeletion summarises what deleting an account removed
+type AccountDeletion struct {
+	KeyPairs int `json:"key_pairs"`
+	Events   int `json:"events"`
+}
+
+// Delete removes the user and everything they own: key pairs with their
+// events, webhook logs and data keys, API tokens and sessions. The audit
+// log keeps its entries about the user, as it is append-only.
+func (s *AccountService) Delete(ctx context.Context, email string) (*AccountDeletion, error) {
+	if _, err := s.users.GetByEmail(ctx, email); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	pairs, err := s.keys.GetUserKeyPairs(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.keys.GetUserEventCount(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	// Revoked first: once the user is gone their sessions can no longer be
+	// found to drop from the cache
+	if s.sessions != nil {
+		if _, err := s.sessions.RevokeAllUserSessions(ctx, email); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.users.Delete(ctx, email)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete user: %w", err)
+	}
+	return &AccountDeletion{KeyPairs: len(pairs), Events: events}, nil
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+// setupAccountService creates an account service over an in-memory store
+// with encrypted events, and a key pair for each of emails
+func setupAccountService(t *testing.T, emails ...string) (*AccountService, *memory.TestStore) {
+	t.Helper()
+	ts := memory.NewTestStore(t)
+	master, _ := NewEncryptor(validHexKey())
+	keys := NewKeyService(ts.Repos.Keys, ts.Repos.Users, ts.Repos.Events, ts.Repos.WebhookLogs, ts.KeyHasher)
+	accounts := NewAccountService(ts.Repos.Users, keys, NewEventServiceWithEncryption(ts.Repos.Events, master))
+	accounts.SetAPITokens(NewAPITokenService(ts.Repos.APITokens, ts.Repos.Users))
+	accounts.SetSessions(NewSessionService(ts.Repos.Sessions, ts.Repos.Users))
+	accounts.SetAudit(NewAuditService(ts.Repos.Audit))
+
+	auth := NewAuthService(ts.Repos.Users, ts.Repos.Keys, ts.Repos.AuthTokens, ts.KeyHasher, "secret", 900, "http://localhost")
+	for _, email := range emails {
+		if _, _, err := auth.CreateUserKeyPair(context.Background(), email, "User", "en"); err != nil {
+			t.Fatalf("CreateUserKeyPair failed: %v", err)
+		}
+	}
+	return accounts, ts
+}
+
+// userPairID returns the ID of the user's only key pair
+func userPairID(t *testing.T, keys *KeyService, email string) uuid.UUID {
+	t.Helper()
+	pairs, err := keys.GetUserKeyPairs(context.Background(), email)
+	if err != nil || len(pairs) != 1 {
+		t.Fatalf("expected one key pair for %s, got %d (%v)", email, len(pairs), err)
+	}
+	return uuid.MustParse(pairs[0].PairID)
+}
+
+// readArchiveFile returns the lines of a file in a zip archive
+func readArchiveFile(t *testing.T, archive *zip.Reader, name string) []string {
+	t.Helper()
+	f, err := archive.Open(name)
+	if err != nil {
+		t.Fatalf("archive has no %s: %v", name, err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestAccountService_Export(t *testing.T) {
+	accounts, _ := setupAccountService(t, "alice@example.com", "bob@example.com")
+	ctx := context.Background()
+	pairID := userPairID(t, accounts.keys, "alice@example.com")
+
+	text, err := accounts.events.CreateEvent(ctx, pairID, "Inbox/note.md", []byte("# Hello ✓"), time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	if _, err := accounts.events.CreateEvent(ctx, pairID, "Inbox/blob.bin", []byte{0xff, 0x00, 0xfe}, time.Hour); err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	if _, err := accounts.events.CreateEvent(ctx, userPairID(t, accounts.keys, "bob@example.com"), "Inbox/bob.md", []byte("bob's"), time.Hour); err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	if err := accounts.keys.CreateWebhookLog(ctx, text.ID, pairID, 200); err != nil {
+		t.Fatalf("CreateWebhookLog failed: %v", err)
+	}
+	if _, _, err := accounts.apiTokens.Create(ctx, "alice@example.com", "cli", []string{models.ScopeKeysRead}, time.Hour); err != nil {
+		t.Fatalf("Create token failed: %v", err)
+	}
+	if _, err := accounts.sessions.StartUserSession(ctx, "alice@example.com", "192.0.2.1", "Firefox"); err != nil {
+		t.Fatalf("StartUserSession failed: %v", err)
+	}
+	if err := accounts.audit.Record(ctx, &models.AuditEvent{ActorType: models.AuditActorAdmin, ActorName: "root", IP: "198.51.100.7",
+		Action: models.AuditActionKeyDeactivate, UserEmail: "alice@example.com"}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := accounts.Export(ctx, "alice@example.com", &buf); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("export is not a zip archive: %v", err)
+	}
+
+	var account accountArchive
+	f, err := archive.Open("account.json")
+	if err != nil {
+		t.Fatalf("archive has no account.json: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	if err := json.Unmarshal(data, &account); err != nil {
+		t.Fatalf("failed to parse account.json: %v", err)
+	}
+	if account.Profile.Email != "alice@example.com" || len(account.KeyPairs) != 1 || len(account.APITokens) != 1 || len(account.Sessions) != 1 {
+		t.Errorf("unexpected account.json: %s", data)
+	}
+	if bytes.Contains(data, []byte("token_hash")) || bytes.Contains(data, []byte("key_hash")) {
+		t.Errorf("expected no hashes in account.json: %s", data)
+	}
+
+	events := readArchiveFile(t, archive, "events.ndjson")
+	if len(events) != 2 {
+		t.Fatalf("expected alice's 2 events, got %v", events)
+	}
+	byPath := make(map[string]exportedEvent)
+	for _, line := range events {
+		var e exportedEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("failed to parse event %s: %v", line, err)
+		}
+		byPath[e.Path] = e
+	}
+	if e := byPath["Inbox/note.md"]; e.Data == nil || *e.Data != "# Hello ✓" || e.DataBase64 != nil {
+		t.Errorf("expected the text event decrypted as text, got %+v", e)
+	}
+	if e := byPath["Inbox/blob.bin"]; e.Data != nil || !bytes.Equal(e.DataBase64, []byte{0xff, 0x00, 0xfe}) {
+		t.Errorf("expected the binary event in base64, got %+v", e)
+	}
+
+	if logs := readArchiveFile(t, archive, "webhook_logs.ndjson"); len(logs) != 1 {
+		t.Errorf("expected 1 webhook log, got %v", logs)
+	}
+	audit := readArchiveFile(t, archive, "audit.ndjson")
+	if len(audit) != 1 || bytes.Contains([]byte(audit[0]), []byte("198.51.100.7")) || bytes.Contains([]byte(audit[0]), []byte(`"hash"`)) {
+		t.Errorf("expected one audit event without the admin's details, got %v", audit)
+	}
+
+	if err := accounts.Export(ctx, "nobody@example.com", io.Discard); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for an unknown user, got %v", err)
+	}
+}
+
+func TestAccountService_Delete(t *testing.T) {
+	accounts, ts := setupAccountService(t, "alice@example.com", "bob@example.com")
+	ctx := context.Background()
+	pairID := userPairID(t, accounts.keys, "alice@example.com")
+
+	for _, path := range []string{"a.md", "b.md"} {
+		if _, err := accounts.events.CreateEvent(ctx, pairID, path, []byte("x"), time.Hour); err != nil {
+			t.Fatalf("CreateEvent failed: %v", err)
+		}
+	}
+	raw, _, err := accounts.apiTokens.Create(ctx, "alice@example.com", "cli", []string{models.ScopeKeysRead}, time.Hour)
+	if err != nil {
+		t.Fatalf("Create token failed: %v", err)
+	}
+	session, err := accounts.sessions.StartUserSession(ctx, "alice@example.com", "", "")
+	if err != nil {
+		t.Fatalf("StartUserSession failed: %v", err)
+	}
+	if _, err := accounts.sessions.Validate(ctx, session.ID); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	deleted, err := accounts.Delete(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if deleted.KeyPairs != 1 || deleted.Events != 2 {
+		t.Errorf("unexpected deletion summary: %+v", deleted)
+	}
+
+	// The cached session is dropped, not left valid until it goes stale
+	if _, err := accounts.sessions.Validate(ctx, session.ID); !errors.Is(err, ErrSessionInvalid) {
+		t.Errorf("expected the session to end at once, got %v", err)
+	}
+	if _, err := accounts.apiTokens.Authenticate(ctx, raw); err == nil {
+		t.Error("expected the API token to stop working")
+	}
+	if n, err := ts.Repos.Events.CountByWebhookKey(ctx, pairID); err != nil || n != 0 {
+		t.Errorf("expected the events to be gone, got %d (%v)", n, err)
+	}
+	if _, err := accounts.keys.GetUserProfile(ctx, "alice@example.com"); err == nil {
+		t.Error("expected the profile to be gone")
+	}
+	userPairID(t, accounts.keys, "bob@example.com")
+
+	if _, err := accounts.Delete(ctx, "alice@example.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound deleting twice, got %v", err)
+	}
+}
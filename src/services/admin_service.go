@@ -2,171 +2,327 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// AdminInviteTTL is how long an invited admin has to set their password
+const AdminInviteTTL = 7 * 24 * time.Hour
+
 // AdminService handles admin user operations
 type AdminService struct {
-	pool *pgxpool.Pool
-	repo repositories.AdminRepository
+	repo     repositories.AdminRepository
+	sessions *SessionService // signs admins out when their role or status changes; may be nil
+
+	// Two-factor authentication; see admin_totp.go
+	encryptor    *Encryptor // encrypts TOTP secrets; nil stores them as-is
+	totpRequired bool
 }
 
 // NewAdminService creates a new admin service
-func NewAdminService(pool *pgxpool.Pool) *AdminService {
-	return &AdminService{pool: pool}
+func NewAdminService(repo repositories.AdminRepository) *AdminService {
+	return &AdminService{repo: repo}
 }
 
-// NewAdminServiceWithRepo creates a new admin service with repository (for testing)
-func NewAdminServiceWithRepo(repo repositories.AdminRepository) *AdminService {
-	return &AdminService{repo: repo}
+// SetSessions lets role changes and deactivation end the admin's sessions,
+// so their old token, which carries the old role, stops working at once
+func (as *AdminService) SetSessions(sessions *SessionService) {
+	as.sessions = sessions
 }
 
-// CreateAdminUser creates a new admin user with hashed password
+// CreateAdminUser creates a new owner with hashed password, as the first-run seed does
 func (as *AdminService) CreateAdminUser(ctx context.Context, username, password string) (*models.AdminUser, error) {
 	// Validate input
-	if len(username) < 1 || len(username) > 255 {
-		return nil, errors.New("username must be between 1 and 255 characters")
-	}
-	if len(password) < 8 {
-		return nil, errors.New("password must be at least 8 characters")
+	if err := validateAdminUsername(username); err != nil {
+		return nil, err
 	}
-
-	// Hash password with bcrypt
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := hashAdminPassword(password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		return nil, err
 	}
 
 	admin := &models.AdminUser{
 		ID:           uuid.New(),
 		Username:     username,
-		PasswordHash: string(hash),
+		PasswordHash: hash,
 		CreatedAt:    time.Now(),
 		IsActive:     true,
+		Role:         models.AdminRoleOwner,
 	}
 
-	// Use repository if available (for testing)
-	if as.repo != nil {
-		if err := as.repo.Create(ctx, admin); err != nil {
-			return nil, fmt.Errorf("failed to create admin user: %w", err)
-		}
-		return admin, nil
+	if err := as.repo.Create(ctx, admin); err != nil {
+		return nil, fmt.Errorf("failed to create admin user: %w", err)
+	}
+
+	return admin, nil
+}
+
+// validateAdminUsername checks a username's length
+func validateAdminUsername(username string) error {
+	if len(username) < 1 || len(username) > 255 {
+		return fmt.Errorf("%w: username must be between 1 and 255 characters", ErrInvalidAdminUser)
 	}
+	return nil
+}
 
-	// Fallback to direct pool access (for backward compatibility)
-	query := `
-		INSERT INTO admin_users (id, username, password_hash, created_at, is_active)
-		VALUES ($1, $2, $3, $4, true)
-		RETURNING id, username, password_hash, created_at, last_login, is_active
-	`
+// hashAdminPassword checks a password's length and hashes it with bcrypt
+func hashAdminPassword(password string) (string, error) {
+	if len(password) < 8 {
+		return "", fmt.Errorf("%w: password must be at least 8 characters", ErrInvalidAdminUser)
+	}
+	// bcrypt ignores everything past 72 bytes
+	if len(password) > 72 {
+		return "", fmt.Errorf("%w: password must be at most 72 bytes", ErrInvalidAdminUser)
+	}
 
-	err = as.pool.QueryRow(ctx, query, admin.ID, username, string(hash), admin.CreatedAt).Scan(
-		&admin.ID, &admin.Username, &admin.PasswordHash, &admin.CreatedAt, &admin.LastLogin, &admin.IsActive,
-	)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create admin user: %w", err)
+		return "", fmt.Errorf("failed to hash password: %w", err)
 	}
-
-	return admin, nil
+	return string(hash), nil
 }
 
 // HasAdmins checks if any admin users exist in the database
 func (as *AdminService) HasAdmins(ctx context.Context) (bool, error) {
-	var count int
-	err := as.pool.QueryRow(ctx, "SELECT COUNT(*) FROM admin_users").Scan(&count)
+	count, err := as.repo.Count(ctx)
 	if err != nil {
 		return false, fmt.Errorf("failed to check admin users: %w", err)
 	}
 	return count > 0, nil
 }
 
-// AuthenticateAdmin verifies username and password
+// AuthenticateAdmin verifies username and password and records the login
 func (as *AdminService) AuthenticateAdmin(ctx context.Context, username, password string) (*models.AdminUser, error) {
-	var admin *models.AdminUser
-	var err error
-
-	// Use repository if available (for testing)
-	if as.repo != nil {
-		admin, err = as.repo.GetByUsername(ctx, username)
-		if err != nil {
-			return nil, errors.New("invalid credentials")
-		}
-	} else {
-		// Fallback to direct pool access (for backward compatibility)
-		query := `
-			SELECT id, username, password_hash, created_at, last_login, is_active
-			FROM admin_users
-			WHERE username = $1 AND is_active = true
-		`
-
-		admin = &models.AdminUser{}
-		err = as.pool.QueryRow(ctx, query, username).Scan(
-			&admin.ID, &admin.Username, &admin.PasswordHash, &admin.CreatedAt, &admin.LastLogin, &admin.IsActive,
-		)
-		if err != nil {
-			return nil, errors.New("invalid credentials")
-		}
+	admin, err := as.VerifyPassword(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	as.RecordLogin(ctx, admin)
+	return admin, nil
+}
+
+// VerifyPassword checks an active admin's username and password without
+// recording a login, for sign-ins that still need a second factor
+func (as *AdminService) VerifyPassword(ctx context.Context, username, password string) (*models.AdminUser, error) {
+	admin, err := as.repo.GetByUsername(ctx, username)
+	if err != nil || !admin.IsActive || admin.InvitePending() {
+		return nil, ErrInvalidCredentials
 	}
 
 	// Compare password hash
 	err = bcrypt.CompareHashAndPassword([]byte(admin.PasswordHash), []byte(password))
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
 	}
+	return admin, nil
+}
 
-	// Update last_login timestamp
-	now := time.Now()
-	if as.repo != nil {
-		err = as.repo.UpdateLastLogin(ctx, admin.ID)
-		if err != nil {
-			log.Printf("Failed to update last_login for admin %s: %v", admin.Username, err)
-		}
-	} else {
-		updateQuery := `UPDATE admin_users SET last_login = $1 WHERE id = $2`
-		_, err = as.pool.Exec(ctx, updateQuery, now, admin.ID)
-		if err != nil {
-			log.Printf("Failed to update last_login for admin %s: %v", admin.Username, err)
-		}
+// RecordLogin updates the admin's last_login timestamp once sign-in is complete
+func (as *AdminService) RecordLogin(ctx context.Context, admin *models.AdminUser) {
+	if err := as.repo.UpdateLastLogin(ctx, admin.ID); err != nil {
+		log.Printf("Failed to update last_login for admin %s: %v", admin.Username, err)
 	}
 
+	now := time.Now()
 	admin.LastLogin = &now
-	return admin, nil
 }
 
 // GetAdminByUsername retrieves admin user by username
 func (as *AdminService) GetAdminByUsername(ctx context.Context, username string) (*models.AdminUser, error) {
-	// Use repository if available (for testing)
-	if as.repo != nil {
-		admin, err := as.repo.GetByUsername(ctx, username)
-		if err != nil {
-			return nil, fmt.Errorf("admin user not found: %w", err)
-		}
+	admin, err := as.repo.GetByUsername(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("admin user not found: %w", err)
+	}
+	return admin, nil
+}
+
+// ListAdmins returns every admin user, oldest first
+func (as *AdminService) ListAdmins(ctx context.Context) ([]models.AdminUser, error) {
+	admins, err := as.repo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list admin users: %w", err)
+	}
+	return admins, nil
+}
+
+// InviteAdmin creates an admin with role who signs in once they set a
+// password with the returned invite token. Only the token's hash is stored.
+func (as *AdminService) InviteAdmin(ctx context.Context, username string, role models.AdminRole) (*models.AdminUser, string, error) {
+	if err := validateAdminUsername(username); err != nil {
+		return nil, "", err
+	}
+	if !role.Valid() {
+		return nil, "", fmt.Errorf("%w: unknown role %q", ErrInvalidAdminUser, role)
+	}
+	if _, err := as.repo.GetByUsername(ctx, username); err == nil {
+		return nil, "", ErrAdminExists
+	} else if !errors.Is(err, repositories.ErrNotFound) {
+		return nil, "", fmt.Errorf("failed to look up admin user: %w", err)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("failed to generate invite token: %w", err)
+	}
+	token := hex.EncodeToString(raw)
+	expiresAt := time.Now().Add(AdminInviteTTL)
+
+	admin := &models.AdminUser{
+		ID:              uuid.New(),
+		Username:        username,
+		CreatedAt:       time.Now(),
+		IsActive:        true,
+		Role:            role,
+		InviteTokenHash: hashInviteToken(token),
+		InviteExpiresAt: &expiresAt,
+	}
+	if err := as.repo.Create(ctx, admin); err != nil {
+		return nil, "", fmt.Errorf("failed to create admin user: %w", err)
+	}
+	return admin, token, nil
+}
+
+// AcceptAdminInvite sets the password of an invited admin, who can then sign in
+func (as *AdminService) AcceptAdminInvite(ctx context.Context, token, password string) (*models.AdminUser, error) {
+	admin, err := as.repo.GetByInviteTokenHash(ctx, hashInviteToken(token))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrInvalidInvite
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up invite: %w", err)
+	}
+	if !admin.IsActive || admin.InviteExpiresAt == nil || time.Now().After(*admin.InviteExpiresAt) {
+		return nil, ErrInvalidInvite
+	}
+
+	hash, err := hashAdminPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	err = as.repo.AcceptInvite(ctx, admin.ID, hash)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrInvalidInvite
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept invite: %w", err)
+	}
+
+	admin.PasswordHash = hash
+	admin.InviteTokenHash = ""
+	admin.InviteExpiresAt = nil
+	return admin, nil
+}
+
+// ChangeAdminRole gives another admin a new role and signs them out
+func (as *AdminService) ChangeAdminRole(ctx context.Context, actorID, adminID uuid.UUID, role models.AdminRole) (*models.AdminUser, error) {
+	if !role.Valid() {
+		return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidAdminUser, role)
+	}
+	admin, err := as.manageableAdmin(ctx, actorID, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if admin.Role == role {
 		return admin, nil
 	}
+	if err := as.keepAnOwner(ctx, admin); err != nil {
+		return nil, err
+	}
 
-	// Fallback to direct pool access (for backward compatibility)
-	query := `
-		SELECT id, username, password_hash, created_at, last_login, is_active
-		FROM admin_users
-		WHERE username = $1
-	`
+	// Store the role first, so a sign-in racing the sign-out gets the new one
+	if err := as.repo.UpdateRole(ctx, adminID, role); err != nil {
+		return nil, fmt.Errorf("failed to change role: %w", err)
+	}
+	admin.Role = role
+	if err := as.endSessions(ctx, admin); err != nil {
+		return nil, err
+	}
+	return admin, nil
+}
 
-	admin := &models.AdminUser{}
-	err := as.pool.QueryRow(ctx, query, username).Scan(
-		&admin.ID, &admin.Username, &admin.PasswordHash, &admin.CreatedAt, &admin.LastLogin, &admin.IsActive,
-	)
+// SetAdminActive deactivates another admin, signing them out, or reactivates them
+func (as *AdminService) SetAdminActive(ctx context.Context, actorID, adminID uuid.UUID, active bool) (*models.AdminUser, error) {
+	admin, err := as.manageableAdmin(ctx, actorID, adminID)
 	if err != nil {
-		return nil, fmt.Errorf("admin user not found: %w", err)
+		return nil, err
+	}
+	if admin.IsActive == active {
+		return admin, nil
+	}
+	if !active {
+		if err := as.keepAnOwner(ctx, admin); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := as.repo.SetActive(ctx, adminID, active); err != nil {
+		return nil, fmt.Errorf("failed to update admin user: %w", err)
 	}
+	admin.IsActive = active
+	if !active {
+		if err := as.endSessions(ctx, admin); err != nil {
+			return nil, err
+		}
+	}
+	return admin, nil
+}
 
+// manageableAdmin loads the admin that actorID wants to change
+func (as *AdminService) manageableAdmin(ctx context.Context, actorID, adminID uuid.UUID) (*models.AdminUser, error) {
+	if actorID == adminID {
+		return nil, ErrAdminSelfChange
+	}
+	admin, err := as.repo.GetByID(ctx, adminID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrAdminNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up admin user: %w", err)
+	}
 	return admin, nil
 }
+
+// keepAnOwner refuses to demote or deactivate admin if they are the last active owner
+func (as *AdminService) keepAnOwner(ctx context.Context, admin *models.AdminUser) error {
+	if admin.Role != models.AdminRoleOwner || !admin.IsActive {
+		return nil
+	}
+	admins, err := as.repo.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list admin users: %w", err)
+	}
+	for _, other := range admins {
+		if other.ID != admin.ID && other.Role == models.AdminRoleOwner && other.IsActive && !other.InvitePending() {
+			return nil
+		}
+	}
+	return ErrLastOwner
+}
+
+// endSessions signs an admin out everywhere before their role or status
+// changes, so no token with the old role outlives the change
+func (as *AdminService) endSessions(ctx context.Context, admin *models.AdminUser) error {
+	if as.sessions == nil {
+		return nil
+	}
+	if _, err := as.sessions.RevokeAllAdminSessions(ctx, admin.ID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// hashInviteToken returns the stored form of an admin invite token
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+func newAdminTestService(t *testing.T) (*AdminService, *SessionService, *memory.TestStore) {
+	t.Helper()
+	ts := memory.NewTestStore(t)
+	sessions := NewSessionService(ts.Repos.Sessions, ts.Repos.Users)
+	as := NewAdminService(ts.Repos.Admins)
+	as.SetSessions(sessions)
+	return as, sessions, ts
+}
+
+func TestAdminService_InviteAndAccept(t *testing.T) {
+	as, _, ts := newAdminTestService(t)
+	ctx := context.Background()
+
+	if _, _, err := as.InviteAdmin(ctx, "helpdesk", "superuser"); !errors.Is(err, ErrInvalidAdminUser) {
+		t.Errorf("expected ErrInvalidAdminUser for an unknown role, got %v", err)
+	}
+	invited, token, err := as.InviteAdmin(ctx, "helpdesk", models.AdminRoleSupport)
+	if err != nil {
+		t.Fatalf("InviteAdmin failed: %v", err)
+	}
+	if _, _, err := as.InviteAdmin(ctx, "helpdesk", models.AdminRoleSupport); !errors.Is(err, ErrAdminExists) {
+		t.Errorf("expected ErrAdminExists for a taken username, got %v", err)
+	}
+
+	stored, _ := ts.Repos.Admins.GetByID(ctx, invited.ID)
+	if stored.InviteTokenHash == token || !stored.InvitePending() {
+		t.Errorf("expected only the invite token's hash to be stored, got %+v", stored)
+	}
+	if _, err := as.VerifyPassword(ctx, "helpdesk", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected an invited admin to be unable to sign in, got %v", err)
+	}
+
+	if _, err := as.AcceptAdminInvite(ctx, "not-a-token", "correct horse battery"); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("expected ErrInvalidInvite for an unknown token, got %v", err)
+	}
+	if _, err := as.AcceptAdminInvite(ctx, token, "short"); !errors.Is(err, ErrInvalidAdminUser) {
+		t.Errorf("expected ErrInvalidAdminUser for a short password, got %v", err)
+	}
+	if _, err := as.AcceptAdminInvite(ctx, token, "correct horse battery"); err != nil {
+		t.Fatalf("AcceptAdminInvite failed: %v", err)
+	}
+	if _, err := as.AcceptAdminInvite(ctx, token, "correct horse battery"); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("expected an accepted invite to stop working, got %v", err)
+	}
+
+	admin, err := as.VerifyPassword(ctx, "helpdesk", "correct horse battery")
+	if err != nil {
+		t.Fatalf("VerifyPassword failed: %v", err)
+	}
+	if admin.Role != models.AdminRoleSupport {
+		t.Errorf("expected role support, got %q", admin.Role)
+	}
+}
+
+func TestAdminService_InviteExpires(t *testing.T) {
+	as, _, ts := newAdminTestService(t)
+	ctx := context.Background()
+
+	expired := time.Now().Add(-time.Minute)
+	if err := ts.Repos.Admins.Create(ctx, &models.AdminUser{
+		Username:        "late",
+		CreatedAt:       time.Now().Add(-AdminInviteTTL),
+		IsActive:        true,
+		Role:            models.AdminRoleOperator,
+		InviteTokenHash: hashInviteToken("late-token"),
+		InviteExpiresAt: &expired,
+	}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := as.AcceptAdminInvite(ctx, "late-token", "correct horse battery"); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("expected ErrInvalidInvite for an expired invite, got %v", err)
+	}
+}
+
+func TestAdminService_ChangeRoleEndsSessions(t *testing.T) {
+	as, sessions, _ := newAdminTestService(t)
+	ctx := context.Background()
+
+	owner, err := as.CreateAdminUser(ctx, "root", "correct horse battery")
+	if err != nil {
+		t.Fatalf("CreateAdminUser failed: %v", err)
+	}
+	operator, _, err := as.InviteAdmin(ctx, "ops", models.AdminRoleOperator)
+	if err != nil {
+		t.Fatalf("InviteAdmin failed: %v", err)
+	}
+	session, err := sessions.StartAdminSession(ctx, operator.ID, "192.0.2.1", "Firefox")
+	if err != nil {
+		t.Fatalf("StartAdminSession failed: %v", err)
+	}
+	if _, err := sessions.Validate(ctx, session.ID); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	changed, err := as.ChangeAdminRole(ctx, owner.ID, operator.ID, models.AdminRoleSupport)
+	if err != nil {
+		t.Fatalf("ChangeAdminRole failed: %v", err)
+	}
+	if changed.Role != models.AdminRoleSupport {
+		t.Errorf("expected role support, got %q", changed.Role)
+	}
+	if _, err := sessions.Validate(ctx, session.ID); !errors.Is(err, ErrSessionInvalid) {
+		t.Errorf("expected the demoted admin's session to end, got %v", err)
+	}
+}
+
+// signOutHookRepo runs beforeRevoke when an admin's sessions are revoked,
+// standing in for a sign-in that lands while the admin is being signed out
+type signOutHookRepo struct {
+	repositories.SessionRepository
+	beforeRevoke func()
+}
+
+func (r *signOutHookRepo) RevokeAllByAdmin(ctx context.Context, adminID uuid.UUID) (int64, error) {
+	if r.beforeRevoke != nil {
+		r.beforeRevoke()
+	}
+	return r.SessionRepository.RevokeAllByAdmin(ctx, adminID)
+}
+
+// TestAdminService_SignInDuringChange verifies a sign-in that races the
+// sign-out sees the admin's new role and status, not the old ones
+func TestAdminService_SignInDuringChange(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+	repo := &signOutHookRepo{SessionRepository: ts.Repos.Sessions}
+	as := NewAdminService(ts.Repos.Admins)
+	as.SetSessions(NewSessionService(repo, ts.Repos.Users))
+
+	root, err := as.CreateAdminUser(ctx, "root", "correct horse battery")
+	if err != nil {
+		t.Fatalf("CreateAdminUser failed: %v", err)
+	}
+	second, err := as.CreateAdminUser(ctx, "second", "correct horse battery")
+	if err != nil {
+		t.Fatalf("CreateAdminUser failed: %v", err)
+	}
+
+	var signedIn *models.AdminUser
+	var signInErr error
+	repo.beforeRevoke = func() {
+		signedIn, signInErr = as.VerifyPassword(ctx, "second", "correct horse battery")
+	}
+	if _, err := as.ChangeAdminRole(ctx, root.ID, second.ID, models.AdminRoleSupport); err != nil {
+		t.Fatalf("ChangeAdminRole failed: %v", err)
+	}
+	if signInErr != nil || signedIn == nil || signedIn.Role != models.AdminRoleSupport {
+		t.Errorf("expected a sign-in during the change to get role support, got %+v (%v)", signedIn, signInErr)
+	}
+
+	if _, err := as.SetAdminActive(ctx, root.ID, second.ID, false); err != nil {
+		t.Fatalf("SetAdminActive failed: %v", err)
+	}
+	if !errors.Is(signInErr, ErrInvalidCredentials) {
+		t.Errorf("expected a sign-in during deactivation to fail, got %+v (%v)", signedIn, signInErr)
+	}
+}
+
+func TestAdminService_KeepsAnOwner(t *testing.T) {
+	as, _, _ := newAdminTestService(t)
+	ctx := context.Background()
+
+	root, err := as.CreateAdminUser(ctx, "root", "correct horse battery")
+	if err != nil {
+		t.Fatalf("CreateAdminUser failed: %v", err)
+	}
+	second, err := as.CreateAdminUser(ctx, "second", "correct horse battery")
+	if err != nil {
+		t.Fatalf("CreateAdminUser failed: %v", err)
+	}
+
+	if _, err := as.SetAdminActive(ctx, root.ID, root.ID, false); !errors.Is(err, ErrAdminSelfChange) {
+		t.Errorf("expected ErrAdminSelfChange deactivating oneself, got %v", err)
+	}
+	if _, err := as.ChangeAdminRole(ctx, second.ID, root.ID, models.AdminRoleSupport); err != nil {
+		t.Fatalf("ChangeAdminRole failed: %v", err)
+	}
+
+	// second is now the only owner; a pending invite does not count as one
+	if _, _, err := as.InviteAdmin(ctx, "newowner", models.AdminRoleOwner); err != nil {
+		t.Fatalf("InviteAdmin failed: %v", err)
+	}
+	if _, err := as.ChangeAdminRole(ctx, root.ID, second.ID, models.AdminRoleOperator); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner demoting the last owner, got %v", err)
+	}
+	if _, err := as.SetAdminActive(ctx, root.ID, second.ID, false); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner deactivating the last owner, got %v", err)
+	}
+
+	// Support staff can be deactivated and reactivated
+	if _, err := as.SetAdminActive(ctx, second.ID, root.ID, false); err != nil {
+		t.Fatalf("SetAdminActive failed: %v", err)
+	}
+	if _, err := as.VerifyPassword(ctx, "root", "correct horse battery"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected a deactivated admin to be unable to sign in, got %v", err)
+	}
+	if _, err := as.SetAdminActive(ctx, second.ID, root.ID, true); err != nil {
+		t.Fatalf("SetAdminActive failed: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/totp"
+)
+
+const (
+	// TOTPIssuer names the service in authenticator apps
+	TOTPIssuer = "Obsidian Webhooks"
+
+	// RecoveryCodeCount is how many recovery codes an admin gets at a time
+	RecoveryCodeCount = 10
+)
+
+// recoveryCodeEncoding spells recovery codes in lowercase base32
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// TOTPEnrollment is what an admin scans or types into an authenticator app
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// SetEncryptor encrypts TOTP secrets at rest; nil (encryption off) stores them as-is
+func (as *AdminService) SetEncryptor(encryptor *Encryptor) {
+	as.encryptor = encryptor
+}
+
+// RequireTOTP makes every admin enroll in two-factor authentication before
+// their first full sign-in, and stops them from turning it off
+func (as *AdminService) RequireTOTP(required bool) {
+	as.totpRequired = required
+}
+
+// TOTPRequired reports whether every admin must use two-factor authentication
+func (as *AdminService) TOTPRequired() bool {
+	return as.totpRequired
+}
+
+// GetAdminByID retrieves an admin user by ID
+func (as *AdminService) GetAdminByID(ctx context.Context, adminID uuid.UUID) (*models.AdminUser, error) {
+	admin, err := as.repo.GetByID(ctx, adminID)
+	if err != nil {
+		return nil, fmt.Errorf("admin user not found: %w", err)
+	}
+	return admin, nil
+}
+
+// BeginTOTPEnrollment generates a new secret for an admin without two-factor
+// authentication. It takes effect once ConfirmTOTPEnrollment sees a code from it.
+func (as *AdminService) BeginTOTPEnrollment(ctx context.Context, adminID uuid.UUID) (*TOTPEnrollment, error) {
+	admin, err := as.GetAdminByID(ctx, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if admin.TOTPEnabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := as.encryptor.Encrypt(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+	if err := as.repo.SetTOTPSecret(ctx, adminID, sealed); err != nil {
+		return nil, fmt.Errorf("failed to store secret: %w", err)
+	}
+
+	return &TOTPEnrollment{
+		Secret:          totp.EncodeSecret(secret),
+		ProvisioningURI: totp.ProvisioningURI(TOTPIssuer, admin.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment turns two-factor authentication on once the admin
+// proves their app works, and returns recovery codes to show once
+func (as *AdminService) ConfirmTOTPEnrollment(ctx context.Context, adminID uuid.UUID, code string) ([]string, error) {
+	admin, err := as.GetAdminByID(ctx, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if admin.TOTPEnabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if admin.TOTPSecret == nil {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if err := as.checkCode(ctx, admin, code); err != nil {
+		return nil, err
+	}
+	return as.issueRecoveryCodes(ctx, adminID)
+}
+
+// VerifyTOTP checks the second factor of an admin's sign-in: a current code
+// from their app, or an unused recovery code, which is then used up
+func (as *AdminService) VerifyTOTP(ctx context.Context, adminID uuid.UUID, code string) error {
+	admin, err := as.GetAdminByID(ctx, adminID)
+	if err != nil {
+		return err
+	}
+	if !admin.TOTPEnabled() {
+		return ErrTOTPNotEnrolled
+	}
+	if err := as.checkCode(ctx, admin, code); err == nil || !errors.Is(err, ErrInvalidTOTPCode) {
+		return err
+	}
+
+	err = as.repo.UseRecoveryCode(ctx, adminID, hashRecoveryCode(code))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrInvalidTOTPCode
+	}
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	log.Printf("Admin %s signed in with a recovery code", admin.Username)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces an admin's recovery codes after checking
+// a code from their app
+func (as *AdminService) RegenerateRecoveryCodes(ctx context.Context, adminID uuid.UUID, code string) ([]string, error) {
+	admin, err := as.GetAdminByID(ctx, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if !admin.TOTPEnabled() {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if err := as.checkCode(ctx, admin, code); err != nil {
+		return nil, err
+	}
+	return as.issueRecoveryCodes(ctx, adminID)
+}
+
+// DisableTOTP turns two-factor authentication off after checking a code from
+// the admin's app or a recovery code
+func (as *AdminService) DisableTOTP(ctx context.Context, adminID uuid.UUID, code string) error {
+	if as.totpRequired {
+		return ErrTOTPRequired
+	}
+	if err := as.VerifyTOTP(ctx, adminID, code); err != nil {
+		return err
+	}
+	if err := as.repo.DisableTOTP(ctx, adminID); err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+	return nil
+}
+
+// checkCode validates a code against the admin's stored secret and uses up
+// its time step, so the same code is refused if it is entered again
+func (as *AdminService) checkCode(ctx context.Context, admin *models.AdminUser, code string) error {
+	secret, err := as.encryptor.Decrypt(admin.TOTPSecret)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	step, ok := totp.Match(secret, code, time.Now())
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+	err = as.repo.UseTOTPStep(ctx, admin.ID, step)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrInvalidTOTPCode
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record code: %w", err)
+	}
+	return nil
+}
+
+// issueRecoveryCodes stores hashes of fresh recovery codes and returns the codes
+func (as *AdminService) issueRecoveryCodes(ctx context.Context, adminID uuid.UUID) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		encoded := recoveryCodeEncoding.EncodeToString(raw)
+		codes[i] = encoded[:4] + "-" + encoded[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if err := as.repo.EnableTOTP(ctx, adminID, hashes); err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode returns the stored form of a recovery code, ignoring case,
+// spaces and dashes
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/totp"
+)
+
+// enrollTOTP starts an enrollment and returns the raw secret the admin's app would hold
+func enrollTOTP(t *testing.T, as *AdminService, ctx context.Context, admin string) (*TOTPEnrollment, []byte) {
+	t.Helper()
+	a, err := as.GetAdminByUsername(ctx, admin)
+	if err != nil {
+		t.Fatalf("GetAdminByUsername failed: %v", err)
+	}
+	enrollment, err := as.BeginTOTPEnrollment(ctx, a.ID)
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollment failed: %v", err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("secret is not base32: %v", err)
+	}
+	return enrollment, secret
+}
+
+func TestAdminService_TOTPLifecycle(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+	as := NewAdminService(ts.Repos.Admins)
+	encryptor, err := NewEncryptor(validHexKey())
+	if err != nil {
+		t.Fatalf("NewEncryptor failed: %v", err)
+	}
+	as.SetEncryptor(encryptor)
+
+	admin, err := as.CreateAdminUser(ctx, "root", "correct horse battery")
+	if err != nil {
+		t.Fatalf("CreateAdminUser failed: %v", err)
+	}
+
+	_, secret := enrollTOTP(t, as, ctx, "root")
+	stored, _ := as.GetAdminByID(ctx, admin.ID)
+	if stored.TOTPEnabled() {
+		t.Fatal("expected two-factor to stay off until the enrollment is confirmed")
+	}
+	if bytes.Contains(stored.TOTPSecret, secret) {
+		t.Error("expected the secret to be stored encrypted")
+	}
+
+	if _, err := as.ConfirmTOTPEnrollment(ctx, admin.ID, "12345"); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("expected ErrInvalidTOTPCode for a wrong code, got %v", err)
+	}
+	// Each step's code is accepted once, so each call uses the next step's code
+	codes, err := as.ConfirmTOTPEnrollment(ctx, admin.ID, totp.Code(secret, time.Now().Add(-totp.Period)))
+	if err != nil {
+		t.Fatalf("ConfirmTOTPEnrollment failed: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", RecoveryCodeCount, len(codes))
+	}
+	if _, err := as.BeginTOTPEnrollment(ctx, admin.ID); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Errorf("expected ErrTOTPAlreadyEnabled on a second enrollment, got %v", err)
+	}
+
+	if err := as.VerifyTOTP(ctx, admin.ID, totp.Code(secret, time.Now())); err != nil {
+		t.Errorf("VerifyTOTP with a current code failed: %v", err)
+	}
+
+	// A recovery code works once, in any case and with or without its dash
+	if err := as.VerifyTOTP(ctx, admin.ID, " "+strings.ToUpper(codes[0])+" "); err != nil {
+		t.Errorf("VerifyTOTP with a recovery code failed: %v", err)
+	}
+	if err := as.VerifyTOTP(ctx, admin.ID, codes[0]); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("expected a used recovery code to be rejected, got %v", err)
+	}
+	stored, _ = as.GetAdminByID(ctx, admin.ID)
+	if stored.RecoveryCodesLeft != RecoveryCodeCount-1 {
+		t.Errorf("expected %d recovery codes left, got %d", RecoveryCodeCount-1, stored.RecoveryCodesLeft)
+	}
+
+	// New recovery codes replace the old ones
+	fresh, err := as.RegenerateRecoveryCodes(ctx, admin.ID, totp.Code(secret, time.Now().Add(totp.Period)))
+	if err != nil {
+		t.Fatalf("RegenerateRecoveryCodes failed: %v", err)
+	}
+	if err := as.VerifyTOTP(ctx, admin.ID, codes[1]); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("expected an old recovery code to be rejected, got %v", err)
+	}
+
+	as.RequireTOTP(true)
+	if err := as.DisableTOTP(ctx, admin.ID, fresh[0]); !errors.Is(err, ErrTOTPRequired) {
+		t.Errorf("expected ErrTOTPRequired while two-factor is required, got %v", err)
+	}
+	as.RequireTOTP(false)
+	if err := as.DisableTOTP(ctx, admin.ID, fresh[0]); err != nil {
+		t.Fatalf("DisableTOTP failed: %v", err)
+	}
+	stored, _ = as.GetAdminByID(ctx, admin.ID)
+	if stored.TOTPEnabled() || stored.TOTPSecret != nil || stored.RecoveryCodesLeft != 0 {
+		t.Errorf("expected two-factor state to be cleared, got %+v", stored)
+	}
+	if err := as.VerifyTOTP(ctx, admin.ID, fresh[1]); !errors.Is(err, ErrTOTPNotEnrolled) {
+		t.Errorf("expected ErrTOTPNotEnrolled after disabling, got %v", err)
+	}
+}
+
+func TestAdminService_TOTPCodeReplay(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+	as := NewAdminService(ts.Repos.Admins)
+
+	admin, err := as.CreateAdminUser(ctx, "root", "correct horse battery")
+	if err != nil {
+		t.Fatalf("CreateAdminUser failed: %v", err)
+	}
+	_, secret := enrollTOTP(t, as, ctx, "root")
+	code := totp.Code(secret, time.Now())
+	if _, err := as.ConfirmTOTPEnrollment(ctx, admin.ID, code); err != nil {
+		t.Fatalf("ConfirmTOTPEnrollment failed: %v", err)
+	}
+
+	// The confirming code, and any code from an earlier step, is used up
+	if err := as.VerifyTOTP(ctx, admin.ID, code); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("expected a replayed code to be rejected, got %v", err)
+	}
+	if err := as.VerifyTOTP(ctx, admin.ID, totp.Code(secret, time.Now().Add(-totp.Period))); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("expected a code from an earlier step to be rejected, got %v", err)
+	}
+	next := totp.Code(secret, time.Now().Add(totp.Period))
+	if err := as.VerifyTOTP(ctx, admin.ID, next); err != nil {
+		t.Errorf("VerifyTOTP with the next step's code failed: %v", err)
+	}
+	if err := as.VerifyTOTP(ctx, admin.ID, next); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("expected the next step's code to work only once, got %v", err)
+	}
+}
+
+func TestAdminService_VerifyPasswordDoesNotRecordLogin(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+	as := NewAdminService(ts.Repos.Admins)
+
+	if _, err := as.CreateAdminUser(ctx, "root", "correct horse battery"); err != nil {
+		t.Fatalf("CreateAdminUser failed: %v", err)
+	}
+	if _, err := as.VerifyPassword(ctx, "root", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+	admin, err := as.VerifyPassword(ctx, "root", "correct horse battery")
+	if err != nil {
+		t.Fatalf("VerifyPassword failed: %v", err)
+	}
+	if stored, _ := as.GetAdminByID(ctx, admin.ID); stored.LastLogin != nil {
+		t.Error("expected VerifyPassword to leave last_login alone")
+	}
+
+	as.RecordLogin(ctx, admin)
+	if stored, _ := as.GetAdminByID(ctx, admin.ID); stored.LastLogin == nil {
+		t.Error("expected RecordLogin to set last_login")
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// APITokenPrefix starts every personal access token so leaked tokens are easy
+// to recognise and tokens are not mistaken for session JWTs
+const APITokenPrefix = "owpat_"
+
+// Personal access token lifetime limits
+const (
+	DefaultAPITokenTTL = 90 * 24 * time.Hour
+	MaxAPITokenTTL     = 365 * 24 * time.Hour
+
+	// APITokenKeepFor is how long expired and revoked tokens stay listed
+	// before cleanup deletes them
+	APITokenKeepFor = 30 * 24 * time.Hour
+)
+
+// apiTokenVisibleLen is how much of a token is stored in the clear, prefix included
+const apiTokenVisibleLen = len(APITokenPrefix) + 6
+
+// lastUsedResolution limits last_used_at writes for busy tokens
+const lastUsedResolution = time.Minute
+
+// APITokenService manages personal access tokens for the dashboard API
+type APITokenService struct {
+	repo  repositories.APITokenRepository
+	users repositories.UserRepository
+}
+
+// NewAPITokenService creates a new API token service
+func NewAPITokenService(repo repositories.APITokenRepository, users repositories.UserRepository) *APITokenService {
+	return &APITokenService{repo: repo, users: users}
+}
+
+// hashAPIToken returns the stored form of a token. Tokens carry 256 random
+// bits, so a fast unsalted hash is enough.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create issues a token for a user. The returned string is the only copy of
+// the token; ttl 0 = DefaultAPITokenTTL.
+func (s *APITokenService) Create(ctx context.Context, userEmail, name string, scopes []string, ttl time.Duration) (string, *models.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return "", nil, fmt.Errorf("%w: name must be 1-100 characters", ErrInvalidTokenRequest)
+	}
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	var granted []string
+	for _, scope := range scopes {
+		if !slices.Contains(models.AllScopes, scope) {
+			return "", nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		if !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	if ttl == 0 {
+		ttl = DefaultAPITokenTTL
+	}
+	if ttl < 0 || ttl > MaxAPITokenTTL {
+		return "", nil, fmt.Errorf("%w: expiry must be between 1 and %d days", ErrInvalidTokenRequest, MaxAPITokenTTL/(24*time.Hour))
+	}
+
+	user, err := s.users.GetByEmail(ctx, userEmail)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return "", nil, ErrUserNotFound
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	raw := APITokenPrefix + hex.EncodeToString(secret)
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	token := &models.APIToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		UserEmail: user.Email,
+		Name:      name,
+		Prefix:    raw[:apiTokenVisibleLen],
+		TokenHash: hashAPIToken(raw),
+		Scopes:    granted,
+		ExpiresAt: &expiresAt,
+		CreatedAt: now,
+	}
+	if err := s.repo.Create(ctx, token); err != nil {
+		return "", nil, fmt.Errorf("failed to store token: %w", err)
+	}
+	return raw, token, nil
+}
+
+// Authenticate resolves a presented token. Unknown, revoked and expired
+// tokens all return ErrInvalidAPIToken.
+func (s *APITokenService) Authenticate(ctx context.Context, raw string) (*models.APIToken, error) {
+	if !strings.HasPrefix(raw, APITokenPrefix) {
+		return nil, ErrInvalidAPIToken
+	}
+	token, err := s.repo.GetByHash(ctx, hashAPIToken(raw))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up token: %w", err)
+	}
+
+	now := time.Now()
+	if !token.IsUsable(now) {
+		return nil, ErrInvalidAPIToken
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedResolution {
+		if err := s.repo.TouchLastUsed(ctx, token.ID, now); err != nil {
+			log.Printf("Failed to record api token use for %s: %v", token.ID, err)
+		}
+		token.LastUsedAt = &now
+	}
+	return token, nil
+}
+
+// List returns a user's tokens, newest first
+func (s *APITokenService) List(ctx context.Context, userEmail string) ([]models.APIToken, error) {
+	user, err := s.users.GetByEmail(ctx, userEmail)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	tokens, err := s.repo.ListByUser(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// Revoke revokes one of a user's tokens immediately
+func (s *APITokenService) Revoke(ctx context.Context, userEmail string, tokenID uuid.UUID) error {
+	user, err := s.users.GetByEmail(ctx, userEmail)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrAPITokenNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	err = s.repo.Revoke(ctx, tokenID, user.ID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrAPITokenNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
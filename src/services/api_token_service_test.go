@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+func newAPITokenTestService(t *testing.T, emails ...string) (*APITokenService, *memory.TestStore) {
+	t.Helper()
+	ts := memory.NewTestStore(t)
+	for _, email := range emails {
+		if err := ts.Repos.Users.Upsert(context.Background(), &models.UserProfile{Email: email}); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+	return NewAPITokenService(ts.Repos.APITokens, ts.Repos.Users), ts
+}
+
+func TestAPITokenService_CreateAndAuthenticate(t *testing.T) {
+	s, ts := newAPITokenTestService(t, "alice@example.com")
+	ctx := context.Background()
+
+	raw, token, err := s.Create(ctx, "alice@example.com", " ci ", []string{models.ScopeKeysRead, models.ScopeKeysRead}, 0)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !strings.HasPrefix(raw, APITokenPrefix) || !strings.HasPrefix(raw, token.Prefix) {
+		t.Errorf("unexpected token %q with prefix %q", raw, token.Prefix)
+	}
+	if token.Name != "ci" || len(token.Scopes) != 1 {
+		t.Errorf("expected a trimmed name and deduplicated scopes, got %+v", token)
+	}
+	if token.ExpiresAt == nil || time.Until(*token.ExpiresAt) < DefaultAPITokenTTL-time.Minute {
+		t.Errorf("expected the default expiry, got %v", token.ExpiresAt)
+	}
+
+	stored, err := ts.Repos.APITokens.GetByHash(ctx, hashAPIToken(raw))
+	if err != nil || stored.TokenHash == raw {
+		t.Fatalf("expected only the hash of the token to be stored: %v", err)
+	}
+
+	got, err := s.Authenticate(ctx, raw)
+	if err != nil || got.UserEmail != "alice@example.com" || !got.HasScope(models.ScopeKeysRead) {
+		t.Fatalf("Authenticate: %+v, %v", got, err)
+	}
+	if got.HasScope(models.ScopeKeysWrite) {
+		t.Error("expected keys:write not to be granted")
+	}
+	if stored, _ := ts.Repos.APITokens.GetByHash(ctx, hashAPIToken(raw)); stored.LastUsedAt == nil {
+		t.Error("expected last use to be recorded")
+	}
+
+	for _, bad := range []string{"", raw + "x", strings.TrimPrefix(raw, APITokenPrefix)} {
+		if _, err := s.Authenticate(ctx, bad); !errors.Is(err, ErrInvalidAPIToken) {
+			t.Errorf("Authenticate(%q): expected ErrInvalidAPIToken, got %v", bad, err)
+		}
+	}
+	if _, _, err := s.Create(ctx, "nobody@example.com", "ci", []string{models.ScopeKeysRead}, 0); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for an unknown user, got %v", err)
+	}
+}
+
+func TestAPITokenService_Validation(t *testing.T) {
+	s, _ := newAPITokenTestService(t, "alice@example.com")
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		token  string
+		scopes []string
+		ttl    time.Duration
+		want   error
+	}{
+		{"empty name", " ", []string{models.ScopeLogsRead}, 0, ErrInvalidTokenRequest},
+		{"no scopes", "ci", nil, 0, ErrInvalidScope},
+		{"unknown scope", "ci", []string{"admin"}, 0, ErrInvalidScope},
+		{"expiry too long", "ci", []string{models.ScopeLogsRead}, MaxAPITokenTTL + time.Hour, ErrInvalidTokenRequest},
+		{"negative expiry", "ci", []string{models.ScopeLogsRead}, -time.Hour, ErrInvalidTokenRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := s.Create(ctx, "alice@example.com", tt.token, tt.scopes, tt.ttl); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAPITokenService_ExpiryAndRevocation(t *testing.T) {
+	s, ts := newAPITokenTestService(t, "alice@example.com", "bob@example.com")
+	ctx := context.Background()
+	alice, _ := ts.Repos.Users.GetByEmail(ctx, "alice@example.com")
+
+	// Expired tokens are rejected
+	expiredRaw := APITokenPrefix + "expired"
+	past := time.Now().Add(-time.Minute)
+	err := ts.Repos.APITokens.Create(ctx, &models.APIToken{
+		ID:        uuid.New(),
+		UserID:    alice.ID,
+		Name:      "old",
+		Prefix:    expiredRaw[:apiTokenVisibleLen],
+		TokenHash: hashAPIToken(expiredRaw),
+		Scopes:    []string{models.ScopeLogsRead},
+		ExpiresAt: &past,
+		CreatedAt: past.Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := s.Authenticate(ctx, expiredRaw); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Errorf("expected an expired token to be rejected, got %v", err)
+	}
+
+	// Only the owner can revoke, and only once
+	raw, token, _ := s.Create(ctx, "alice@example.com", "ci", []string{models.ScopeLogsRead}, time.Hour)
+	if err := s.Revoke(ctx, "bob@example.com", token.ID); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Errorf("expected ErrAPITokenNotFound revoking another user's token, got %v", err)
+	}
+	if _, err := s.Authenticate(ctx, raw); err != nil {
+		t.Fatalf("expected the token to still work, got %v", err)
+	}
+	if err := s.Revoke(ctx, "alice@example.com", token.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := s.Authenticate(ctx, raw); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Errorf("expected a revoked token to be rejected, got %v", err)
+	}
+	if err := s.Revoke(ctx, "alice@example.com", token.ID); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Errorf("expected revoking twice to fail, got %v", err)
+	}
+
+	// Revoked and expired tokens stay listed until cleanup removes them
+	active, _, _ := s.Create(ctx, "alice@example.com", "sync", []string{models.ScopeLogsRead}, time.Hour)
+	if tokens, _ := s.List(ctx, "alice@example.com"); len(tokens) != 3 || tokens[0].Name != "sync" {
+		t.Fatalf("expected 3 tokens newest first, got %+v", tokens)
+	}
+	deleted, err := ExpiredAPITokensTask(ts.Repos.APITokens, 0).Run(ctx, 10)
+	if err != nil || deleted != 2 {
+		t.Fatalf("expected the expired and revoked tokens to be deleted, got %d (%v)", deleted, err)
+	}
+	if _, err := s.Authenticate(ctx, active); err != nil {
+		t.Errorf("expected the active token to survive cleanup, got %v", err)
+	}
+}
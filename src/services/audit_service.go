@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// Audit log page sizes
+const (
+	DefaultAuditPageSize = 100
+	MaxAuditPageSize     = 500
+)
+
+// auditBatchSize is how many events Export and Verify read at a time
+const auditBatchSize = 500
+
+// Sizes of the audit_events text columns filled from request data, such as
+// the username of a failed sign-in or the client's X-Request-ID header
+const (
+	maxAuditFieldLen     = 255
+	maxAuditRequestIDLen = 128
+)
+
+// AuditService records security-relevant actions in a hash-chained log
+type AuditService struct {
+	repo repositories.AuditRepository
+}
+
+// NewAuditService creates a new audit service
+func NewAuditService(repo repositories.AuditRepository) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+// Record appends event to the log, setting its ID, time and chain fields.
+// Failures are logged as well as returned, since callers record actions that
+// have already happened and usually carry on.
+func (s *AuditService) Record(ctx context.Context, event *models.AuditEvent) error {
+	event.ID = uuid.New()
+	// Stored timestamps keep microseconds; the hash must survive the round trip
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	event.ActorID = auditField(event.ActorID, maxAuditFieldLen)
+	event.ActorName = auditField(event.ActorName, maxAuditFieldLen)
+	event.TargetID = auditField(event.TargetID, maxAuditFieldLen)
+	event.UserEmail = auditField(event.UserEmail, maxAuditFieldLen)
+	event.RequestID = auditField(event.RequestID, maxAuditRequestIDLen)
+
+	if err := s.repo.Append(ctx, event, func(e *models.AuditEvent) { e.Hash = auditHash(e) }); err != nil {
+		log.Printf("Failed to record audit event %s by %s: %v", event.Action, event.ActorName, err)
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+// List returns one page of events matching filter, newest first. The limit
+// defaults to DefaultAuditPageSize and is capped at MaxAuditPageSize.
+func (s *AuditService) List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultAuditPageSize
+	}
+	filter.Limit = min(filter.Limit, MaxAuditPageSize)
+
+	events, err := s.repo.List(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	return events, nil
+}
+
+// Export calls fn with every event matching filter, newest first, ignoring
+// the filter's limit
+func (s *AuditService) Export(ctx context.Context, filter models.AuditFilter, fn func(*models.AuditEvent) error) error {
+	filter.Limit = auditBatchSize
+	for {
+		events, err := s.repo.List(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("failed to list audit events: %w", err)
+		}
+		for i := range events {
+			if err := fn(&events[i]); err != nil {
+				return err
+			}
+		}
+		if len(events) < auditBatchSize {
+			return nil
+		}
+		filter.BeforeSeq = events[len(events)-1].Seq
+	}
+}
+
+// AuditVerification is the result of checking the audit chain
+type AuditVerification struct {
+	Valid   bool  `json:"valid"`
+	Checked int64 `json:"checked"`
+	// HeadSeq and HeadHash identify the last event. Keeping a copy elsewhere
+	// also makes removing events from the end of the log detectable.
+	HeadSeq  int64  `json:"head_seq"`
+	HeadHash string `json:"head_hash,omitempty"`
+	// BrokenSeq is the first position that fails, with the reason
+	BrokenSeq int64  `json:"broken_seq,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Verify walks the whole chain and reports the first event that was
+// changed, removed or inserted out of order
+func (s *AuditService) Verify(ctx context.Context) (*AuditVerification, error) {
+	result := &AuditVerification{Valid: true}
+	for {
+		events, err := s.repo.ListAfter(ctx, result.HeadSeq, auditBatchSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit events: %w", err)
+		}
+		for i := range events {
+			e := &events[i]
+			reason := ""
+			switch {
+			case e.Seq != result.HeadSeq+1:
+				reason = fmt.Sprintf("events %d to %d are missing", result.HeadSeq+1, e.Seq-1)
+			case e.PrevHash != result.HeadHash:
+				reason = "previous hash does not match the event before it"
+			case e.Hash != auditHash(e):
+				reason = "hash does not match the event's contents"
+			}
+			if reason != "" {
+				result.Valid = false
+				result.BrokenSeq = result.HeadSeq + 1
+				result.Reason = reason
+				return result, nil
+			}
+			result.Checked++
+			result.HeadSeq, result.HeadHash = e.Seq, e.Hash
+		}
+		if len(events) < auditBatchSize {
+			return result, nil
+		}
+	}
+}
+
+// auditField cuts s to at most max bytes of valid UTF-8
+func auditField(s string, max int) string {
+	if len(s) > max {
+		s = s[:max]
+	}
+	return strings.ToValidUTF8(s, "")
+}
+
+// auditHashInput fixes the fields and order an event's hash covers
+type auditHashInput struct {
+	Seq        int64  `json:"seq"`
+	ID         string `json:"id"`
+	CreatedAt  string `json:"created_at"`
+	ActorType  string `json:"actor_type"`
+	ActorID    string `json:"actor_id"`
+	ActorName  string `json:"actor_name"`
+	Action     string `json:"action"`
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+	UserEmail  string `json:"user_email"`
+	IP         string `json:"ip"`
+	RequestID  string `json:"request_id"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	PrevHash   string `json:"prev_hash"`
+}
+
+// auditHash returns the hex SHA-256 of an event's fields and PrevHash
+func auditHash(e *models.AuditEvent) string {
+	input, _ := json.Marshal(auditHashInput{
+		Seq:        e.Seq,
+		ID:         e.ID.String(),
+		CreatedAt:  e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ActorType:  string(e.ActorType),
+		ActorID:    e.ActorID,
+		ActorName:  e.ActorName,
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		UserEmail:  e.UserEmail,
+		IP:         e.IP,
+		RequestID:  e.RequestID,
+		Before:     string(e.Before),
+		After:      string(e.After),
+		PrevHash:   e.PrevHash,
+	})
+	sum := sha256.Sum256(input)
+	return hex.EncodeToString(sum[:])
+}
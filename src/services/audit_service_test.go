@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+// tamperedAuditRepository rewrites what ListAfter reads, standing in for
+// rows edited or deleted directly in the database
+type tamperedAuditRepository struct {
+	repositories.AuditRepository
+	tamper func([]models.AuditEvent) []models.AuditEvent
+}
+
+func (r *tamperedAuditRepository) ListAfter(ctx context.Context, afterSeq int64, limit int) ([]models.AuditEvent, error) {
+	events, err := r.AuditRepository.ListAfter(ctx, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	return r.tamper(events), nil
+}
+
+func recordAuditEvents(t *testing.T, s *AuditService, n int) []models.AuditEvent {
+	t.Helper()
+	events := make([]models.AuditEvent, n)
+	for i := range events {
+		events[i] = models.AuditEvent{
+			ActorType: models.AuditActorUser,
+			ActorID:   "alice@example.com",
+			Action:    models.AuditActionSessionRevoke,
+			TargetID:  fmt.Sprintf("session-%d", i),
+			UserEmail: "alice@example.com",
+			After:     auditTestState(i),
+		}
+		if err := s.Record(context.Background(), &events[i]); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	return events
+}
+
+func auditTestState(i int) []byte {
+	return []byte(fmt.Sprintf(`{"n":%d}`, i))
+}
+
+func TestAuditService_RecordChainsEvents(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	s := NewAuditService(ts.Repos.Audit)
+
+	events := recordAuditEvents(t, s, 3)
+	for i, e := range events {
+		if e.Seq != int64(i+1) {
+			t.Errorf("event %d: expected seq %d, got %d", i, i+1, e.Seq)
+		}
+		if e.Hash == "" || e.Hash != auditHash(&e) {
+			t.Errorf("event %d: hash not set from its contents", i)
+		}
+	}
+	if events[0].PrevHash != "" {
+		t.Errorf("expected the first event to have no previous hash, got %q", events[0].PrevHash)
+	}
+	if events[1].PrevHash != events[0].Hash || events[2].PrevHash != events[1].Hash {
+		t.Error("expected each event to carry the previous event's hash")
+	}
+
+	result, err := s.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !result.Valid || result.Checked != 3 || result.HeadSeq != 3 || result.HeadHash != events[2].Hash {
+		t.Errorf("unexpected verification of an intact chain: %+v", result)
+	}
+}
+
+func TestAuditService_RecordLimitsRequestFields(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	s := NewAuditService(ts.Repos.Audit)
+
+	long := make([]byte, 300)
+	for i := range long {
+		long[i] = 'a'
+	}
+	event := models.AuditEvent{
+		ActorType: models.AuditActorAdmin,
+		ActorName: string(long),
+		Action:    models.AuditActionAdminLoginFailed,
+		RequestID: string(long[:127]) + "\xc3\xa9",
+	}
+	if err := s.Record(context.Background(), &event); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if len(event.ActorName) != maxAuditFieldLen {
+		t.Errorf("expected the actor name cut to %d bytes, got %d", maxAuditFieldLen, len(event.ActorName))
+	}
+	// Cutting at 128 bytes splits the "é"; the broken half is dropped
+	if event.RequestID != string(long[:127]) {
+		t.Errorf("expected the request ID cut to valid UTF-8, got %d bytes", len(event.RequestID))
+	}
+}
+
+func TestAuditService_VerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name      string
+		tamper    func([]models.AuditEvent) []models.AuditEvent
+		brokenSeq int64
+	}{
+		{
+			name: "edited event",
+			tamper: func(events []models.AuditEvent) []models.AuditEvent {
+				for i := range events {
+					if events[i].Seq == 2 {
+						events[i].TargetID = "someone-else"
+					}
+				}
+				return events
+			},
+			brokenSeq: 2,
+		},
+		{
+			name: "edited event with its hash recomputed",
+			tamper: func(events []models.AuditEvent) []models.AuditEvent {
+				for i := range events {
+					if events[i].Seq == 2 {
+						events[i].After = auditTestState(99)
+						events[i].Hash = auditHash(&events[i])
+					}
+				}
+				return events
+			},
+			brokenSeq: 3,
+		},
+		{
+			name: "deleted event",
+			tamper: func(events []models.AuditEvent) []models.AuditEvent {
+				kept := events[:0]
+				for _, e := range events {
+					if e.Seq != 2 {
+						kept = append(kept, e)
+					}
+				}
+				return kept
+			},
+			brokenSeq: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := memory.NewTestStore(t)
+			recordAuditEvents(t, NewAuditService(ts.Repos.Audit), 4)
+
+			s := NewAuditService(&tamperedAuditRepository{AuditRepository: ts.Repos.Audit, tamper: tt.tamper})
+			result, err := s.Verify(context.Background())
+			if err != nil {
+				t.Fatalf("Verify failed: %v", err)
+			}
+			if result.Valid {
+				t.Fatal("expected the tampered chain to fail verification")
+			}
+			if result.BrokenSeq != tt.brokenSeq || result.Checked != tt.brokenSeq-1 || result.Reason == "" {
+				t.Errorf("expected a break at seq %d, got %+v", tt.brokenSeq, result)
+			}
+		})
+	}
+}
+
+func TestAuditService_ListAndExport(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	s := NewAuditService(ts.Repos.Audit)
+	ctx := context.Background()
+
+	total := auditBatchSize + 20
+	recordAuditEvents(t, s, total)
+
+	page, err := s.List(ctx, models.AuditFilter{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(page) != DefaultAuditPageSize || page[0].Seq != int64(total) {
+		t.Errorf("expected the newest %d events, got %d starting at seq %d", DefaultAuditPageSize, len(page), page[0].Seq)
+	}
+	page, err = s.List(ctx, models.AuditFilter{Limit: MaxAuditPageSize + 1})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(page) != MaxAuditPageSize {
+		t.Errorf("expected the limit capped at %d, got %d events", MaxAuditPageSize, len(page))
+	}
+
+	var seqs []int64
+	err = s.Export(ctx, models.AuditFilter{Limit: 1}, func(e *models.AuditEvent) error {
+		seqs = append(seqs, e.Seq)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(seqs) != total {
+		t.Fatalf("expected Export to ignore the limit and return %d events, got %d", total, len(seqs))
+	}
+	for i, seq := range seqs {
+		if seq != int64(total-i) {
+			t.Fatalf("expected the export newest first, got seq %d at position %d", seq, i)
+		}
+	}
+}
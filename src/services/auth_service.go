@@ -10,22 +10,29 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/keyhash"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
 )
 
 // AuthService handles authentication logic and magic link lifecycle
 type AuthService struct {
-	db              *pgxpool.Pool
+	users           repositories.UserRepository
+	keys            repositories.KeyRepository
+	tokens          repositories.AuthTokenRepository
+	hasher          *keyhash.Hasher
 	jwtSecret       string
 	magicLinkExpiry time.Duration
 	baseURL         string
 }
 
 // NewAuthService creates a new authentication service
-func NewAuthService(db *pgxpool.Pool, jwtSecret string, magicLinkExpirySeconds int, baseURL string) *AuthService {
+func NewAuthService(users repositories.UserRepository, keys repositories.KeyRepository, tokens repositories.AuthTokenRepository, hasher *keyhash.Hasher, jwtSecret string, magicLinkExpirySeconds int, baseURL string) *AuthService {
 	return &AuthService{
-		db:              db,
+		users:           users,
+		keys:            keys,
+		tokens:          tokens,
+		hasher:          hasher,
 		jwtSecret:       jwtSecret,
 		magicLinkExpiry: time.Duration(magicLinkExpirySeconds) * time.Second,
 		baseURL:         baseURL,
@@ -54,77 +61,56 @@ func (s *AuthService) GenerateMagicLinkToken() (string, error) {
 func (s *AuthService) StoreMagicLinkToken(ctx context.Context, email string, token string) error {
 	expiresAt := time.Now().Add(s.magicLinkExpiry)
 
-	query := `
-		UPDATE api_keys
-		SET magic_link_token = $1,
-		    magic_link_expires_at = $2,
-		    magic_link_used_at = NULL
-		WHERE user_email = $3 AND key_type = 'webhook'
-	`
-
-	result, err := s.db.Exec(ctx, query, token, expiresAt, email)
+	err := s.tokens.StoreMagicLinkToken(ctx, email, token, expiresAt)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return fmt.Errorf("no user found for email: %s", email)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to store magic link token: %w", err)
 	}
 
-	rowsAffected := result.RowsAffected()
-
-	if rowsAffected == 0 {
-		return fmt.Errorf("no webhook key found for email: %s", email)
-	}
-
 	return nil
 }
 
 // VerifyMagicLinkToken verifies a magic link token and marks it as used
 func (s *AuthService) VerifyMagicLinkToken(ctx context.Context, token string) (email string, err error) {
-	var expiresAt, usedAt *time.Time
-
-	query := `
-		SELECT user_email, magic_link_expires_at, magic_link_used_at
-		FROM api_keys
-		WHERE magic_link_token = $1 AND key_type = 'webhook'
-	`
-
-	err = s.db.QueryRow(ctx, query, token).Scan(&email, &expiresAt, &usedAt)
+	stored, err := s.tokens.GetMagicLinkToken(ctx, token)
 	if err != nil {
-		if err == pgx.ErrNoRows {
+		if errors.Is(err, repositories.ErrNotFound) {
 			return "", ErrTokenInvalid
 		}
 		return "", fmt.Errorf("failed to verify magic link token: %w", err)
 	}
 
 	// Check if already used
-	if usedAt != nil {
+	if stored.UsedAt != nil {
 		return "", ErrTokenUsed
 	}
 
 	// Check if expired
-	if expiresAt == nil || time.Now().After(*expiresAt) {
+	if stored.ExpiresAt == nil || time.Now().After(*stored.ExpiresAt) {
 		return "", ErrTokenExpired
 	}
 
 	// Mark token as used
-	updateQuery := `
-		UPDATE api_keys
-		SET magic_link_used_at = NOW()
-		WHERE magic_link_token = $1
-	`
-
-	if _, err := s.db.Exec(ctx, updateQuery, token); err != nil {
+	if err := s.tokens.MarkMagicLinkTokenUsed(ctx, token); err != nil {
 		return "", fmt.Errorf("failed to mark token as used: %w", err)
 	}
 
-	return email, nil
+	return stored.Email, nil
 }
 
-// GenerateSessionToken generates a JWT session token for authenticated users
-func (s *AuthService) GenerateSessionToken(email string, expiryHours int) (string, error) {
+// GenerateSessionToken generates a JWT session token for authenticated users.
+// sessionID ties the token to a server-side session; uuid.Nil leaves it out.
+func (s *AuthService) GenerateSessionToken(email string, sessionID uuid.UUID, expiryHours int) (string, error) {
 	claims := jwt.MapClaims{
 		"email": email,
 		"exp":   time.Now().Add(time.Duration(expiryHours) * time.Hour).Unix(),
 		"iat":   time.Now().Unix(),
 	}
+	if sessionID != uuid.Nil {
+		claims["sid"] = sessionID.String()
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	signedToken, err := token.SignedString([]byte(s.jwtSecret))
@@ -135,8 +121,9 @@ func (s *AuthService) GenerateSessionToken(email string, expiryHours int) (strin
 	return signedToken, nil
 }
 
-// VerifySessionToken verifies a JWT session token and returns the email
-func (s *AuthService) VerifySessionToken(tokenString string) (email string, err error) {
+// VerifySessionToken verifies a JWT session token and returns the email and
+// session ID; the ID is uuid.Nil for tokens issued without a session
+func (s *AuthService) VerifySessionToken(tokenString string) (email string, sessionID uuid.UUID, err error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		// Verify signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -146,24 +133,31 @@ func (s *AuthService) VerifySessionToken(tokenString string) (email string, err
 	})
 
 	if err != nil {
-		return "", fmt.Errorf("failed to parse JWT token: %w", err)
+		return "", uuid.Nil, fmt.Errorf("failed to parse JWT token: %w", err)
 	}
 
 	if !token.Valid {
-		return "", errors.New("invalid JWT token")
+		return "", uuid.Nil, errors.New("invalid JWT token")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", errors.New("invalid JWT claims")
+		return "", uuid.Nil, errors.New("invalid JWT claims")
 	}
 
 	email, ok = claims["email"].(string)
 	if !ok {
-		return "", errors.New("email not found in JWT claims")
+		return "", uuid.Nil, errors.New("email not found in JWT claims")
+	}
+
+	if sid, ok := claims["sid"].(string); ok {
+		sessionID, err = uuid.Parse(sid)
+		if err != nil {
+			return "", uuid.Nil, errors.New("invalid session ID in JWT claims")
+		}
 	}
 
-	return email, nil
+	return email, sessionID, nil
 }
 
 // GetMagicLinkURL builds the full magic link URL with token
@@ -171,48 +165,31 @@ func (s *AuthService) GetMagicLinkURL(token string) string {
 	return fmt.Sprintf("%s/auth/verify?token=%s", s.baseURL, token)
 }
 
-// CreateUserKeyPair creates a new webhook+client key pair for a user with email
+// CreateUserKeyPair creates a new webhook+client key pair for a user with email,
+// creating the user or updating their name and language first. The key values
+// are returned here only; just their hashes are stored.
 func (s *AuthService) CreateUserKeyPair(ctx context.Context, email, name, language string) (webhookKey, clientKey string, err error) {
 	// Default to English if language not specified
 	if language == "" {
 		language = "en"
 	}
 
-	// Generate webhook key
-	webhookKey = "wh_" + generateRandomKey(24)
-	clientKey = "ck_" + generateRandomKey(24)
-
-	// Start transaction
-	tx, err := s.db.Begin(ctx)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to start transaction: %w", err)
+	profile := &models.UserProfile{
+		Email:         email,
+		Name:          name,
+		Language:      language,
+		EmailVerified: true,
 	}
-	defer tx.Rollback(ctx)
-
-	// Insert webhook key with preferred language
-	webhookKeyID := uuid.New()
-	insertWebhookQuery := `
-		INSERT INTO api_keys (id, key_value, key_type, pair_id, user_email, user_name, email_verified, preferred_language)
-		VALUES ($1, $2, 'webhook', $1, $3, $4, true, $5)
-	`
-
-	if _, err := tx.Exec(ctx, insertWebhookQuery, webhookKeyID, webhookKey, email, name, language); err != nil {
-		return "", "", fmt.Errorf("failed to insert webhook key: %w", err)
+	if err := s.users.Upsert(ctx, profile); err != nil {
+		return "", "", fmt.Errorf("failed to save user: %w", err)
 	}
 
-	// Insert client key with same preferred language
-	insertClientQuery := `
-		INSERT INTO api_keys (key_value, key_type, pair_id, user_email, user_name, email_verified, preferred_language)
-		VALUES ($1, 'client', $2, $3, $4, true, $5)
-	`
-
-	if _, err := tx.Exec(ctx, insertClientQuery, clientKey, webhookKeyID, email, name, language); err != nil {
-		return "", "", fmt.Errorf("failed to insert client key: %w", err)
-	}
-
-	// Commit transaction
-	if err := tx.Commit(ctx); err != nil {
-		return "", "", fmt.Errorf("failed to commit transaction: %w", err)
+	webhookKey = "wh_" + generateRandomKey(24)
+	clientKey = "ck_" + generateRandomKey(24)
+	wk := &models.WebhookKey{KeyHash: s.hasher.Hash(webhookKey), KeyPrefix: keyhash.Prefix(webhookKey)}
+	ck := &models.ClientKey{KeyHash: s.hasher.Hash(clientKey), KeyPrefix: keyhash.Prefix(clientKey)}
+	if err := s.keys.CreateUserKeyPair(ctx, profile.ID, wk, ck); err != nil {
+		return "", "", err
 	}
 
 	return webhookKey, clientKey, nil
@@ -220,44 +197,28 @@ func (s *AuthService) CreateUserKeyPair(ctx context.Context, email, name, langua
 
 // GetUserLanguage retrieves user's preferred language by email
 func (s *AuthService) GetUserLanguage(ctx context.Context, email string) (string, error) {
-	query := `
-		SELECT preferred_language
-		FROM api_keys
-		WHERE user_email = $1 AND key_type = 'webhook'
-		LIMIT 1
-	`
-
-	var language string
-	err := s.db.QueryRow(ctx, query, email).Scan(&language)
+	profile, err := s.users.GetByEmail(ctx, email)
 	if err != nil {
-		if err == pgx.ErrNoRows {
+		if errors.Is(err, repositories.ErrNotFound) {
 			return "en", nil // Default to English if user not found
 		}
 		return "en", fmt.Errorf("failed to get user language: %w", err)
 	}
 
-	return language, nil
+	return profile.Language, nil
 }
 
 // GetUserByEmail retrieves user information by email
 func (s *AuthService) GetUserByEmail(ctx context.Context, email string) (exists bool, emailVerified bool, err error) {
-	query := `
-		SELECT email_verified
-		FROM api_keys
-		WHERE user_email = $1 AND key_type = 'webhook'
-		LIMIT 1
-	`
-
-	var verified bool
-	err = s.db.QueryRow(ctx, query, email).Scan(&verified)
+	profile, err := s.users.GetByEmail(ctx, email)
 	if err != nil {
-		if err == pgx.ErrNoRows {
+		if errors.Is(err, repositories.ErrNotFound) {
 			return false, false, nil
 		}
 		return false, false, fmt.Errorf("failed to query user by email: %w", err)
 	}
 
-	return true, verified, nil
+	return true, profile.EmailVerified, nil
 }
 
 // generateRandomKey generates a random key string for webhook/client keys
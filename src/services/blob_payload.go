@@ -0,0 +1,320 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/blobstore"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// DefaultBlobThreshold is the payload size above which events are offloaded
+// to the blob store
+const DefaultBlobThreshold = 256 * 1024
+
+// DefaultBlobGCGrace is how old an unreferenced blob must be before cleanup
+// deletes it; younger blobs may belong to an event that is still being stored
+const DefaultBlobGCGrace = time.Hour
+
+// ErrBlobCorrupt indicates a stored blob failed verification while reading
+var ErrBlobCorrupt = errors.New("blob is corrupt or truncated")
+
+// Blob layout:
+//
+//	magic (4) | frame*
+//	frame = length (4, big endian) | sealed chunk
+//	chunk = index (8, big endian) | final flag (1) | up to blobChunkSize bytes
+//
+// Each chunk is sealed separately with the event's encryption, so a blob can
+// be decrypted as it streams instead of being held in memory. The index and
+// final flag inside the sealed chunk stop chunks from being reordered or the
+// blob from being cut short, and the blob's name is the SHA-256 of all of it.
+const (
+	blobMagic     = "\x00OWB"
+	blobChunkSize = 64 * 1024
+	blobMaxFrame  = blobChunkSize + 1024 // chunk plus header and encryption overhead
+)
+
+// writeBlob splits data into chunks, seals each one and writes the framed blob
+func writeBlob(w io.Writer, data []byte, seal func([]byte) ([]byte, error)) error {
+	if _, err := io.WriteString(w, blobMagic); err != nil {
+		return err
+	}
+	chunk := make([]byte, 0, 9+blobChunkSize)
+	for index := uint64(0); ; index++ {
+		n := min(len(data), blobChunkSize)
+		final := n == len(data)
+
+		chunk = binary.BigEndian.AppendUint64(chunk[:0], index)
+		if final {
+			chunk = append(chunk, 1)
+		} else {
+			chunk = append(chunk, 0)
+		}
+		chunk = append(chunk, data[:n]...)
+		data = data[n:]
+
+		sealed, err := seal(chunk)
+		if err != nil {
+			return err
+		}
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+		if _, err := w.Write(length[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// blobReader decrypts a blob written by writeBlob chunk by chunk and checks
+// its content hash once the final chunk has been read
+type blobReader struct {
+	src     *bufio.Reader
+	hash    hash.Hash
+	ref     string
+	open    func([]byte) ([]byte, error)
+	started bool
+	index   uint64
+	buf     []byte
+	err     error
+}
+
+// newBlobReader reads the blob named ref from r, opening chunks with open
+func newBlobReader(r io.Reader, ref string, open func([]byte) ([]byte, error)) *blobReader {
+	h := sha256.New()
+	return &blobReader{src: bufio.NewReader(io.TeeReader(r, h)), hash: h, ref: ref, open: open}
+}
+
+// Read implements io.Reader
+func (b *blobReader) Read(p []byte) (int, error) {
+	for len(b.buf) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		b.err = b.next()
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+// next loads the following chunk into buf. It returns io.EOF together with
+// the final chunk once the whole blob has been verified.
+func (b *blobReader) next() error {
+	if !b.started {
+		magic := make([]byte, len(blobMagic))
+		if _, err := io.ReadFull(b.src, magic); err != nil || string(magic) != blobMagic {
+			return fmt.Errorf("%w: bad header", ErrBlobCorrupt)
+		}
+		b.started = true
+	}
+
+	var length [4]byte
+	if _, err := io.ReadFull(b.src, length[:]); err != nil {
+		return b.readErr(err)
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > blobMaxFrame {
+		return fmt.Errorf("%w: frame of %d bytes", ErrBlobCorrupt, size)
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(b.src, sealed); err != nil {
+		return b.readErr(err)
+	}
+
+	chunk, err := b.open(sealed)
+	if err != nil {
+		return err
+	}
+	if len(chunk) < 9 || binary.BigEndian.Uint64(chunk) != b.index {
+		return fmt.Errorf("%w: chunk %d out of order", ErrBlobCorrupt, b.index)
+	}
+	b.index++
+	b.buf = chunk[9:]
+	if chunk[8] == 0 {
+		return nil
+	}
+
+	// Final chunk: nothing may follow and the content must match its name
+	if _, err := b.src.ReadByte(); err != io.EOF {
+		return b.readErr(err)
+	}
+	if hex.EncodeToString(b.hash.Sum(nil)) != b.ref {
+		return fmt.Errorf("%w: content hash mismatch", ErrBlobCorrupt)
+	}
+	return io.EOF
+}
+
+// readErr reports a failed read; running out of data means the blob was truncated
+func (b *blobReader) readErr(err error) error {
+	if err == nil {
+		return fmt.Errorf("%w: data after final chunk", ErrBlobCorrupt)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: unexpected end of data", ErrBlobCorrupt)
+	}
+	return err
+}
+
+// blobReadCloser releases the decompressor and closes the underlying store reader
+type blobReadCloser struct {
+	io.Reader
+	src        io.Closer
+	decompress func()
+}
+
+// Close implements io.Closer
+func (b blobReadCloser) Close() error {
+	b.decompress()
+	return b.src.Close()
+}
+
+// SetBlobStore offloads payloads larger than threshold bytes to store;
+// threshold 0 = DefaultBlobThreshold
+func (es *EventService) SetBlobStore(store blobstore.Store, threshold int) {
+	if threshold <= 0 {
+		threshold = DefaultBlobThreshold
+	}
+	es.blobs = store
+	es.blobThreshold = threshold
+}
+
+// putBlob encrypts data chunk by chunk, stores it under its content hash and
+// returns the hash and the stored size
+func (es *EventService) putBlob(ctx context.Context, event *models.Event, data []byte) (string, int64, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(data) + len(data)/blobChunkSize*64 + 64)
+	err := writeBlob(&buf, data, func(chunk []byte) ([]byte, error) {
+		return es.encryptData(ctx, event.WebhookKeyID, chunk)
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to encrypt event data: %w", err)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	ref := hex.EncodeToString(sum[:])
+	size := int64(buf.Len())
+	if err := es.blobs.Put(ctx, ref, &buf, size); err != nil {
+		return "", 0, fmt.Errorf("failed to store event data: %w", err)
+	}
+	return ref, size, nil
+}
+
+// openBlob returns a reader that decrypts and decompresses an offloaded
+// payload as it streams
+func (es *EventService) openBlob(ctx context.Context, event *models.Event) (io.ReadCloser, error) {
+	if es.blobs == nil {
+		return nil, fmt.Errorf("event %s: payload is in the blob store, which is not configured", event.ID)
+	}
+	rc, err := es.blobs.Open(ctx, event.BlobRef)
+	if err != nil {
+		return nil, fmt.Errorf("event %s: %w", event.ID, err)
+	}
+	reader := newBlobReader(rc, event.BlobRef, func(sealed []byte) ([]byte, error) {
+		return es.decryptData(ctx, event.WebhookKeyID, sealed)
+	})
+	if event.Sealed {
+		return blobReadCloser{Reader: reader, src: rc, decompress: func() {}}, nil
+	}
+	plain, release, err := decompressReader(reader)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("event %s: %w", event.ID, err)
+	}
+	return blobReadCloser{Reader: plain, src: rc, decompress: release}, nil
+}
+
+// StreamUnprocessedEvents calls fn with each unprocessed event for a webhook
+// key and a reader over its payload. Offloaded payloads are streamed from the
+// blob store rather than loaded into memory. An error from fn stops the walk
+// and is returned as-is.
+func (es *EventService) StreamUnprocessedEvents(ctx context.Context, webhookKeyID uuid.UUID, fn func(event *models.Event, data io.Reader) error) error {
+	events, err := es.repo.GetUnprocessed(ctx, webhookKeyID)
+	if err != nil {
+		return fmt.Errorf("failed to query events: %w", err)
+	}
+
+	for i := range events {
+		event := &events[i]
+		if event.BlobRef == "" {
+			if err := es.decryptEventData(ctx, event); err != nil {
+				return fmt.Errorf("failed to decrypt event data: %w", err)
+			}
+			if err := fn(event, bytes.NewReader(event.Data)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := es.openBlob(ctx, event)
+		if err != nil {
+			return fmt.Errorf("failed to open event data: %w", err)
+		}
+		err = fn(event, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OrphanedBlobsTask deletes blobs that no event references any more, such as
+// the payloads of expired or deleted events. Blobs younger than grace are kept.
+func OrphanedBlobsTask(events repositories.EventRepository, store blobstore.Store, grace time.Duration) CleanupTask {
+	return NewCleanupTask("orphaned_blobs", func(ctx context.Context, batchSize int) (int64, error) {
+		cutoff := time.Now().Add(-grace)
+		var deleted int64
+		var batch []string
+
+		flush := func() error {
+			referenced, err := events.ReferencedBlobs(ctx, batch)
+			if err != nil {
+				return err
+			}
+			for _, key := range batch {
+				if referenced[key] {
+					continue
+				}
+				if err := store.Delete(ctx, key); err != nil {
+					return err
+				}
+				deleted++
+			}
+			batch = batch[:0]
+			return nil
+		}
+
+		err := store.Walk(ctx, func(obj blobstore.Object) error {
+			if obj.ModTime.After(cutoff) {
+				return nil
+			}
+			batch = append(batch, obj.Key)
+			if len(batch) >= batchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err == nil && len(batch) > 0 {
+			err = flush()
+		}
+		return deleted, err
+	})
+}
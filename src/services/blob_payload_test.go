@@ -0,0 +1,178 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/blobstore"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+// newBlobTestService returns an event service with envelope encryption and a
+// filesystem blob store that offloads payloads above 1 KB
+func newBlobTestService(t *testing.T) (*EventService, *DataKeyService, *memory.TestStore, string) {
+	t.Helper()
+	ts := memory.NewTestStore(t)
+	master, _ := NewEncryptor(validHexKey())
+	dks := NewDataKeyService(ts.Repos.DataKeys, master)
+	es := NewEventServiceWithEncryption(ts.Repos.Events, master)
+	es.SetDataKeys(dks)
+
+	dir := t.TempDir()
+	store, err := blobstore.NewFSStore(dir)
+	if err != nil {
+		t.Fatalf("NewFSStore failed: %v", err)
+	}
+	es.SetBlobStore(store, 1024)
+	return es, dks, ts, dir
+}
+
+// largePayload returns a payload spanning several blob chunks
+func largePayload() []byte {
+	return bytes.Repeat([]byte("# Attachment\nline of text ✓\n"), 10000)
+}
+
+func TestBlobPayload_RoundTrip(t *testing.T) {
+	es, _, ts, _ := newBlobTestService(t)
+	ctx := context.Background()
+	whID, _, _, _, _ := ts.CreateTestKeyPair(0, "")
+	webhookKeyID := uuid.MustParse(whID)
+	payload := largePayload()
+
+	event, err := es.CreateEvent(ctx, webhookKeyID, "big.md", payload, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	small, err := es.CreateEvent(ctx, webhookKeyID, "small.md", []byte("inline"), time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+
+	stored, _ := ts.Repos.Events.GetByID(ctx, event.ID)
+	if stored.BlobRef == "" || len(stored.Data) != 0 {
+		t.Fatalf("expected the payload offloaded, got ref %q and %d inline bytes", stored.BlobRef, len(stored.Data))
+	}
+	if stored, _ := ts.Repos.Events.GetByID(ctx, small.ID); stored.BlobRef != "" {
+		t.Error("expected a small payload to stay inline")
+	}
+
+	got, err := es.GetEventByID(ctx, event.ID)
+	if err != nil || !bytes.Equal(got.Data, payload) {
+		t.Fatalf("GetEventByID: %d bytes, %v", len(got.Data), err)
+	}
+
+	streamed := map[string][]byte{}
+	err = es.StreamUnprocessedEvents(ctx, webhookKeyID, func(event *models.Event, data io.Reader) error {
+		b, err := io.ReadAll(data)
+		streamed[event.Path] = b
+		return err
+	})
+	if err != nil {
+		t.Fatalf("StreamUnprocessedEvents failed: %v", err)
+	}
+	if !bytes.Equal(streamed["big.md"], payload) || string(streamed["small.md"]) != "inline" {
+		t.Errorf("unexpected streamed payloads: %d and %q", len(streamed["big.md"]), streamed["small.md"])
+	}
+}
+
+func TestBlobPayload_Tampering(t *testing.T) {
+	es, _, ts, dir := newBlobTestService(t)
+	ctx := context.Background()
+	whID, _, _, _, _ := ts.CreateTestKeyPair(0, "")
+	event, err := es.CreateEvent(ctx, uuid.MustParse(whID), "big.md", largePayload(), time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	path := filepath.Join(dir, event.BlobRef[:2], event.BlobRef)
+	original, _ := os.ReadFile(path)
+
+	tampered := map[string][]byte{
+		"truncated":     original[:len(original)/2],
+		"trailing data": append(append([]byte(nil), original...), 'x'),
+		"flipped byte":  append(append([]byte(nil), original[:100]...), append([]byte{original[100] ^ 1}, original[101:]...)...),
+	}
+	for name, data := range tampered {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := es.GetEventByID(ctx, event.ID); err == nil {
+			t.Errorf("%s: expected an error reading a tampered blob", name)
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := es.GetEventByID(ctx, event.ID); !errors.Is(err, blobstore.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing blob, got %v", err)
+	}
+}
+
+func TestBlobPayload_Shred(t *testing.T) {
+	es, dks, ts, _ := newBlobTestService(t)
+	ctx := context.Background()
+	whID, _, _, _, _ := ts.CreateTestKeyPair(0, "")
+	webhookKeyID := uuid.MustParse(whID)
+	event, err := es.CreateEvent(ctx, webhookKeyID, "big.md", largePayload(), time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+
+	if err := dks.Shred(ctx, webhookKeyID); err != nil {
+		t.Fatalf("Shred failed: %v", err)
+	}
+	if _, err := es.GetEventByID(ctx, event.ID); !errors.Is(err, ErrDataKeyNotFound) {
+		t.Errorf("expected the offloaded payload to be unreadable after shredding, got %v", err)
+	}
+}
+
+func TestOrphanedBlobsTask(t *testing.T) {
+	es, _, ts, dir := newBlobTestService(t)
+	ctx := context.Background()
+	whID, _, _, _, _ := ts.CreateTestKeyPair(0, "")
+	webhookKeyID := uuid.MustParse(whID)
+
+	var refs []string
+	for i := 0; i < 3; i++ {
+		event, err := es.CreateEvent(ctx, webhookKeyID, "big.md", largePayload(), time.Hour)
+		if err != nil {
+			t.Fatalf("CreateEvent failed: %v", err)
+		}
+		refs = append(refs, event.BlobRef)
+		if i < 2 {
+			if err := es.DeleteEvent(ctx, event.ID); err != nil {
+				t.Fatalf("DeleteEvent failed: %v", err)
+			}
+		}
+	}
+	// refs[0] is an old orphan, refs[1] a fresh one, refs[2] still referenced
+	old := time.Now().Add(-2 * DefaultBlobGCGrace)
+	for _, ref := range []string{refs[0], refs[2]} {
+		if err := os.Chtimes(filepath.Join(dir, ref[:2], ref), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, _ := blobstore.NewFSStore(dir)
+	deleted, err := OrphanedBlobsTask(ts.Repos.Events, store, DefaultBlobGCGrace).Run(ctx, 1)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 blob deleted, got %d", deleted)
+	}
+	for i, ref := range refs {
+		_, err := os.Stat(filepath.Join(dir, ref[:2], ref))
+		if exists := err == nil; exists != (i != 0) {
+			t.Errorf("blob %d: exists=%v", i, exists)
+		}
+	}
+}
@@ -2,80 +2,376 @@ package services
 
 import (
 	"context"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
 )
 
-// CleanupService handles automatic cleanup of old events
+// ErrCleanupRunning is returned when a cleanup run is requested while one is in progress
+var ErrCleanupRunning = errors.New("cleanup already running")
+
+// DefaultCleanupBatchSize is the number of rows each cleanup statement touches
+const DefaultCleanupBatchSize = 1000
+
+// Cleanup run triggers, as shown in CleanupReport.Trigger
+const (
+	CleanupTriggerStartup   = "startup"
+	CleanupTriggerScheduled = "scheduled"
+	CleanupTriggerManual    = "manual"
+)
+
+// CleanupTask is one housekeeping job run by CleanupService.
+// Run removes at most batchSize rows per statement and returns the total removed.
+type CleanupTask interface {
+	Name() string
+	Run(ctx context.Context, batchSize int) (int64, error)
+}
+
+// cleanupTaskFunc adapts a function to CleanupTask
+type cleanupTaskFunc struct {
+	name string
+	run  func(ctx context.Context, batchSize int) (int64, error)
+}
+
+func (t cleanupTaskFunc) Name() string { return t.name }
+
+func (t cleanupTaskFunc) Run(ctx context.Context, batchSize int) (int64, error) {
+	return t.run(ctx, batchSize)
+}
+
+// NewCleanupTask creates a cleanup task from a function
+func NewCleanupTask(name string, run func(ctx context.Context, batchSize int) (int64, error)) CleanupTask {
+	return cleanupTaskFunc{name: name, run: run}
+}
+
+// deleteInBatches calls deleteBatch until it removes fewer than batchSize rows,
+// so no single statement holds locks on a large table for long
+func deleteInBatches(ctx context.Context, batchSize int, deleteBatch func(ctx context.Context, limit int) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		n, err := deleteBatch(ctx, batchSize)
+		total += n
+		if err != nil || n < int64(batchSize) {
+			return total, err
+		}
+	}
+}
+
+// ExpiredEventsTask deletes events past their expires_at
+func ExpiredEventsTask(events repositories.EventRepository) CleanupTask {
+	return NewCleanupTask("expired_events", func(ctx context.Context, batchSize int) (int64, error) {
+		return deleteInBatches(ctx, batchSize, events.DeleteExpiredBatch)
+	})
+}
+
+// OrphanedWebhookLogsTask deletes delivery logs whose event or key no longer exists
+func OrphanedWebhookLogsTask(logs repositories.WebhookLogRepository) CleanupTask {
+	return NewCleanupTask("orphaned_webhook_logs", func(ctx context.Context, batchSize int) (int64, error) {
+		return deleteInBatches(ctx, batchSize, logs.DeleteOrphaned)
+	})
+}
+
+// ExpiredMagicLinksTask clears magic link tokens past their expiry
+func ExpiredMagicLinksTask(tokens repositories.AuthTokenRepository) CleanupTask {
+	return NewCleanupTask("expired_magic_links", func(ctx context.Context, batchSize int) (int64, error) {
+		return deleteInBatches(ctx, batchSize, tokens.ClearExpiredMagicLinkTokens)
+	})
+}
+
+// InactiveKeysTask deletes revoked key pairs that have not been used for idleFor,
+// together with their remaining events and logs
+func InactiveKeysTask(keys repositories.KeyRepository, idleFor time.Duration) CleanupTask {
+	return NewCleanupTask("inactive_keys", func(ctx context.Context, batchSize int) (int64, error) {
+		idleSince := time.Now().Add(-idleFor)
+		return deleteInBatches(ctx, batchSize, func(ctx context.Context, limit int) (int64, error) {
+			return keys.DeleteInactiveKeyPairs(ctx, idleSince, limit)
+		})
+	})
+}
+
+// ExpiredKeyRotationsTask clears the old values of rotated keys once their
+// overlap has ended. Lookups already ignore them, so this only tidies up.
+func ExpiredKeyRotationsTask(keys repositories.KeyRepository) CleanupTask {
+	return NewCleanupTask("expired_key_rotations", func(ctx context.Context, batchSize int) (int64, error) {
+		now := time.Now()
+		return deleteInBatches(ctx, batchSize, func(ctx context.Context, limit int) (int64, error) {
+			return keys.ClearExpiredRotations(ctx, now, limit)
+		})
+	})
+}
+
+// ExpiredAPITokensTask deletes personal access tokens that expired or were
+// revoked more than keepFor ago; until then they stay listed on the dashboard
+func ExpiredAPITokensTask(tokens repositories.APITokenRepository, keepFor time.Duration) CleanupTask {
+	return NewCleanupTask("expired_api_tokens", func(ctx context.Context, batchSize int) (int64, error) {
+		cutoff := time.Now().Add(-keepFor)
+		return deleteInBatches(ctx, batchSize, func(ctx context.Context, limit int) (int64, error) {
+			return tokens.DeleteExpired(ctx, cutoff, limit)
+		})
+	})
+}
+
+// ExpiredSessionsTask deletes sessions that have expired or been revoked
+func ExpiredSessionsTask(sessions repositories.SessionRepository) CleanupTask {
+	return NewCleanupTask("expired_sessions", func(ctx context.Context, batchSize int) (int64, error) {
+		cutoff := time.Now()
+		return deleteInBatches(ctx, batchSize, func(ctx context.Context, limit int) (int64, error) {
+			return sessions.DeleteExpired(ctx, cutoff, limit)
+		})
+	})
+}
+
+// CleanupConfig configures the cleanup scheduler
+type CleanupConfig struct {
+	Enabled    bool     // run on Schedule; manual runs work either way
+	Schedule   Schedule // nil = every 24h
+	BatchSize  int      // 0 = DefaultCleanupBatchSize
+	RunOnStart bool
+}
+
+// CleanupTaskResult is the outcome of one task in a cleanup run
+type CleanupTaskResult struct {
+	Name       string `json:"name"`
+	Deleted    int64  `json:"deleted"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// CleanupReport describes a completed cleanup run
+type CleanupReport struct {
+	Trigger    string              `json:"trigger"`
+	StartedAt  time.Time           `json:"started_at"`
+	FinishedAt time.Time           `json:"finished_at"`
+	Tasks      []CleanupTaskResult `json:"tasks"`
+}
+
+// CleanupStatus is the scheduler state exposed to admins
+type CleanupStatus struct {
+	Enabled   bool           `json:"enabled"`
+	Schedule  string         `json:"schedule"`
+	BatchSize int            `json:"batch_size"`
+	Tasks     []string       `json:"tasks"`
+	Running   bool           `json:"running"`
+	NextRun   *time.Time     `json:"next_run,omitempty"`
+	LastRun   *CleanupReport `json:"last_run,omitempty"`
+}
+
+// CleanupService runs registered cleanup tasks on a schedule or on demand
 type CleanupService struct {
-	pool     *pgxpool.Pool
-	enabled  bool
-	interval time.Duration
-	done     chan bool
+	cfg CleanupConfig
+
+	mu      sync.Mutex
+	tasks   []CleanupTask
+	running bool
+	nextRun *time.Time
+	last    *CleanupReport
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
 }
 
-// NewCleanupService creates a new cleanup service
-func NewCleanupService(pool *pgxpool.Pool, enabled bool) *CleanupService {
-	return &CleanupService{
-		pool:     pool,
-		enabled:  enabled,
-		interval: 24 * time.Hour, // Run daily
-		done:     make(chan bool),
+// NewCleanupService creates a cleanup service with no tasks registered
+func NewCleanupService(cfg CleanupConfig) *CleanupService {
+	if cfg.Schedule == nil {
+		cfg.Schedule = intervalSchedule(24 * time.Hour)
 	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultCleanupBatchSize
+	}
+	return &CleanupService{cfg: cfg}
+}
+
+// Register adds a task; tasks run in registration order
+func (cs *CleanupService) Register(task CleanupTask) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.tasks = append(cs.tasks, task)
 }
 
-// Start starts the cleanup service
+// Start launches the scheduler in the background. It returns immediately and
+// does nothing when scheduled cleanup is disabled.
 func (cs *CleanupService) Start(ctx context.Context) {
-	if !cs.enabled {
+	if !cs.cfg.Enabled {
 		log.Println("Cleanup service is disabled")
 		return
 	}
 
-	go func() {
-		ticker := time.NewTicker(cs.interval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ctx.Done():
-				log.Println("Cleanup service stopped")
-				return
-			case <-cs.done:
-				log.Println("Cleanup service stopped")
-				return
-			case <-ticker.C:
-				cs.cleanup(ctx)
-			}
+	cs.mu.Lock()
+	if cs.cancel != nil {
+		cs.mu.Unlock()
+		return
+	}
+	cs.ctx, cs.cancel = context.WithCancel(ctx)
+	ctx = cs.ctx
+	cs.mu.Unlock()
+
+	cs.wg.Add(1)
+	go cs.loop(ctx)
+
+	log.Printf("Cleanup service started (%s)", cs.cfg.Schedule)
+}
+
+// loop runs cleanup whenever the schedule fires until ctx is cancelled
+func (cs *CleanupService) loop(ctx context.Context) {
+	defer cs.wg.Done()
+
+	if cs.cfg.RunOnStart {
+		cs.runScheduled(ctx, CleanupTriggerStartup)
+	}
+
+	for {
+		next := cs.cfg.Schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("Cleanup schedule %s never fires again; scheduler stopped", cs.cfg.Schedule)
+			return
 		}
-	}()
+		cs.mu.Lock()
+		cs.nextRun = &next
+		cs.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Println("Cleanup service stopped")
+			return
+		case <-timer.C:
+			cs.runScheduled(ctx, CleanupTriggerScheduled)
+		}
+	}
+}
 
-	log.Println("Cleanup service started")
+// runScheduled runs cleanup unless a manual run is already in progress
+func (cs *CleanupService) runScheduled(ctx context.Context, trigger string) {
+	if _, err := cs.run(ctx, trigger); errors.Is(err, ErrCleanupRunning) {
+		log.Println("Cleanup skipped: previous run still in progress")
+	}
 }
 
-// Stop stops the cleanup service
+// Stop cancels the scheduler and waits for an in-flight run to finish its
+// current batch. It is safe to call when the service was never started.
 func (cs *CleanupService) Stop() {
-	cs.done <- true
+	cs.mu.Lock()
+	cancel := cs.cancel
+	cs.cancel = nil
+	cs.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	cs.wg.Wait()
 }
 
-// cleanup performs the actual cleanup
-func (cs *CleanupService) cleanup(ctx context.Context) {
-	result, err := cs.pool.Exec(ctx, "DELETE FROM events WHERE expires_at < NOW()")
+// RunNow runs every task immediately and returns the report
+func (cs *CleanupService) RunNow(ctx context.Context) (*CleanupReport, error) {
+	return cs.run(ctx, CleanupTriggerManual)
+}
 
-	if err != nil {
-		log.Printf("Cleanup error: %v", err)
-		return
+// Trigger starts a manual run in the background. It returns ErrCleanupRunning
+// if a run is already in progress.
+func (cs *CleanupService) Trigger() error {
+	if !cs.begin() {
+		return ErrCleanupRunning
 	}
 
-	rowsDeleted := result.RowsAffected()
-	if rowsDeleted > 0 {
-		log.Printf("Cleanup completed: deleted %d expired events", rowsDeleted)
+	cs.mu.Lock()
+	ctx := cs.ctx
+	cs.mu.Unlock()
+	if ctx == nil {
+		ctx = context.Background()
 	}
+
+	cs.wg.Add(1)
+	go func() {
+		defer cs.wg.Done()
+		cs.execute(ctx, CleanupTriggerManual)
+	}()
+	return nil
 }
 
-// DeleteOldEvents manually deletes old events (called by cleanup)
-func (cs *CleanupService) DeleteOldEvents(ctx context.Context, ttl time.Duration) (int64, error) {
-	eventService := NewEventService(cs.pool)
-	return eventService.DeleteExpiredEvents(ctx)
+// Status returns the scheduler configuration and the last run report
+func (cs *CleanupService) Status() CleanupStatus {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	status := CleanupStatus{
+		Enabled:   cs.cfg.Enabled,
+		Schedule:  cs.cfg.Schedule.String(),
+		BatchSize: cs.cfg.BatchSize,
+		Tasks:     make([]string, 0, len(cs.tasks)),
+		Running:   cs.running,
+		LastRun:   cs.last,
+	}
+	for _, t := range cs.tasks {
+		status.Tasks = append(status.Tasks, t.Name())
+	}
+	if cs.cfg.Enabled && cs.cancel != nil {
+		status.NextRun = cs.nextRun
+	}
+	return status
+}
+
+// LastReport returns the report of the most recent completed run, or nil
+func (cs *CleanupService) LastReport() *CleanupReport {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.last
+}
+
+// run executes all tasks synchronously unless another run is in progress
+func (cs *CleanupService) run(ctx context.Context, trigger string) (*CleanupReport, error) {
+	if !cs.begin() {
+		return nil, ErrCleanupRunning
+	}
+	return cs.execute(ctx, trigger), nil
+}
+
+// begin marks a run as in progress; it reports false if one already is
+func (cs *CleanupService) begin() bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.running {
+		return false
+	}
+	cs.running = true
+	return true
+}
+
+// execute runs every registered task, records the report and clears the running flag.
+// A failing task is reported and does not stop the ones after it.
+func (cs *CleanupService) execute(ctx context.Context, trigger string) *CleanupReport {
+	cs.mu.Lock()
+	tasks := append([]CleanupTask(nil), cs.tasks...)
+	cs.mu.Unlock()
+
+	report := &CleanupReport{Trigger: trigger, StartedAt: time.Now()}
+	for _, task := range tasks {
+		start := time.Now()
+		deleted, err := task.Run(ctx, cs.cfg.BatchSize)
+		result := CleanupTaskResult{
+			Name:       task.Name(),
+			Deleted:    deleted,
+			DurationMS: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			result.Error = err.Error()
+			log.Printf("Cleanup task %s failed: %v", task.Name(), err)
+		} else if deleted > 0 {
+			log.Printf("Cleanup task %s: removed %d rows", task.Name(), deleted)
+		}
+		report.Tasks = append(report.Tasks, result)
+	}
+	report.FinishedAt = time.Now()
+
+	cs.mu.Lock()
+	cs.last = report
+	cs.running = false
+	cs.mu.Unlock()
+	return report
 }
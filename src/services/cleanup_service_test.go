@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/mock"
+)
+
+// TestCleanupService_StopWithoutStart verifies Stop returns when the scheduler
+// is disabled or was never started
+func TestCleanupService_StopWithoutStart(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cs := NewCleanupService(CleanupConfig{Enabled: enabled})
+		if !enabled {
+			cs.Start(context.Background())
+		}
+
+		done := make(chan struct{})
+		go func() {
+			cs.Stop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Stop blocked (enabled=%v)", enabled)
+		}
+	}
+}
+
+// TestCleanupService_RunOnStart verifies the startup run and that Stop ends the scheduler
+func TestCleanupService_RunOnStart(t *testing.T) {
+	ran := make(chan struct{}, 1)
+	cs := NewCleanupService(CleanupConfig{Enabled: true, RunOnStart: true})
+	cs.Register(NewCleanupTask("probe", func(ctx context.Context, batchSize int) (int64, error) {
+		ran <- struct{}{}
+		return 0, nil
+	}))
+
+	cs.Start(context.Background())
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a cleanup run at startup")
+	}
+	cs.Stop()
+
+	report := cs.LastReport()
+	if report == nil || report.Trigger != CleanupTriggerStartup {
+		t.Errorf("Expected a startup report, got %+v", report)
+	}
+}
+
+// TestCleanupService_RunNow verifies task order, batching and error reporting
+func TestCleanupService_RunNow(t *testing.T) {
+	events := mock.NewEventRepository()
+	remaining := int64(5)
+	events.DeleteExpiredBatchFunc = func(ctx context.Context, limit int) (int64, error) {
+		n := min(remaining, int64(limit))
+		remaining -= n
+		return n, nil
+	}
+
+	cs := NewCleanupService(CleanupConfig{BatchSize: 2})
+	cs.Register(ExpiredEventsTask(events))
+	cs.Register(NewCleanupTask("broken", func(ctx context.Context, batchSize int) (int64, error) {
+		return 0, errors.New("boom")
+	}))
+	cs.Register(NewCleanupTask("after_broken", func(ctx context.Context, batchSize int) (int64, error) {
+		return 1, nil
+	}))
+
+	report, err := cs.RunNow(context.Background())
+	if err != nil {
+		t.Fatalf("RunNow failed: %v", err)
+	}
+
+	if calls := len(events.Calls["DeleteExpiredBatch"]); calls != 3 {
+		t.Errorf("Expected 3 batches for 5 rows of 2, got %d", calls)
+	}
+	if len(report.Tasks) != 3 {
+		t.Fatalf("Expected 3 task results, got %d", len(report.Tasks))
+	}
+	if r := report.Tasks[0]; r.Name != "expired_events" || r.Deleted != 5 || r.Error != "" {
+		t.Errorf("Unexpected expired_events result: %+v", r)
+	}
+	if r := report.Tasks[1]; r.Error != "boom" {
+		t.Errorf("Expected the task error to be reported, got %+v", r)
+	}
+	if r := report.Tasks[2]; r.Deleted != 1 {
+		t.Errorf("Expected tasks after a failure to still run, got %+v", r)
+	}
+
+	status := cs.Status()
+	if status.LastRun != report || status.Running || status.Enabled {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if status.NextRun != nil {
+		t.Errorf("Expected no next run while the scheduler is stopped, got %v", status.NextRun)
+	}
+}
+
+// TestCleanupService_Trigger verifies a manual run is rejected while another is in progress
+func TestCleanupService_Trigger(t *testing.T) {
+	release := make(chan struct{})
+	cs := NewCleanupService(CleanupConfig{})
+	cs.Register(NewCleanupTask("slow", func(ctx context.Context, batchSize int) (int64, error) {
+		<-release
+		return 0, nil
+	}))
+
+	if err := cs.Trigger(); err != nil {
+		t.Fatalf("Trigger failed: %v", err)
+	}
+	if err := cs.Trigger(); !errors.Is(err, ErrCleanupRunning) {
+		t.Errorf("Expected ErrCleanupRunning, got %v", err)
+	}
+	if _, err := cs.RunNow(context.Background()); !errors.Is(err, ErrCleanupRunning) {
+		t.Errorf("Expected ErrCleanupRunning from RunNow, got %v", err)
+	}
+
+	close(release)
+	cs.Stop()
+	if report := cs.LastReport(); report == nil || report.Trigger != CleanupTriggerManual {
+		t.Errorf("Expected a manual report, got %+v", report)
+	}
+}
+
+// TestCleanupService_BuiltinTasks runs the built-in tasks against the in-memory store
+func TestCleanupService_BuiltinTasks(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+
+	// Revoked pair last used long ago, and an active pair that must survive
+	revokedWH, _, revokedValue, revokedClient, err := ts.CreateTestKeyPair(0, "")
+	if err != nil {
+		t.Fatalf("CreateTestKeyPair failed: %v", err)
+	}
+	activeWH, _, _, _, err := ts.CreateTestKeyPair(0, "")
+	if err != nil {
+		t.Fatalf("CreateTestKeyPair failed: %v", err)
+	}
+	_ = ts.Repos.Keys.UpdateKeyStatus(ctx, ts.KeyHasher.Hash(revokedValue), models.KeyTypeWebhook, false)
+	_ = ts.Repos.Keys.UpdateKeyStatus(ctx, ts.KeyHasher.Hash(revokedClient), models.KeyTypeClient, false)
+
+	for i := 0; i < 3; i++ {
+		expired := &models.Event{
+			ID:           uuid.New(),
+			WebhookKeyID: uuid.MustParse(activeWH),
+			Path:         "old.md",
+			Data:         []byte("data"),
+			CreatedAt:    time.Now().Add(-48 * time.Hour),
+			ExpiresAt:    time.Now().Add(-time.Hour),
+		}
+		if err := ts.Repos.Events.Create(ctx, expired); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+	if _, err := ts.CreateTestEvent(activeWH, "fresh.md", []byte("data")); err != nil {
+		t.Fatalf("CreateTestEvent failed: %v", err)
+	}
+
+	cs := NewCleanupService(CleanupConfig{BatchSize: 2})
+	cs.Register(ExpiredEventsTask(ts.Repos.Events))
+	cs.Register(OrphanedWebhookLogsTask(ts.Repos.WebhookLogs))
+	cs.Register(ExpiredMagicLinksTask(ts.Repos.AuthTokens))
+	// A negative idle period treats keys created just now as idle
+	cs.Register(InactiveKeysTask(ts.Repos.Keys, -time.Minute))
+
+	report, err := cs.RunNow(ctx)
+	if err != nil {
+		t.Fatalf("RunNow failed: %v", err)
+	}
+
+	deleted := make(map[string]int64)
+	for _, r := range report.Tasks {
+		if r.Error != "" {
+			t.Errorf("Task %s failed: %s", r.Name, r.Error)
+		}
+		deleted[r.Name] = r.Deleted
+	}
+	if deleted["expired_events"] != 3 {
+		t.Errorf("Expected 3 expired events deleted, got %d", deleted["expired_events"])
+	}
+	if deleted["inactive_keys"] != 2 {
+		t.Errorf("Expected the revoked pair's 2 keys deleted, got %d", deleted["inactive_keys"])
+	}
+
+	if _, err := ts.Repos.Keys.GetKeyByID(ctx, uuid.MustParse(revokedWH)); err == nil {
+		t.Error("Expected the revoked key to be deleted")
+	}
+	if count, _ := ts.Repos.Events.CountByWebhookKey(ctx, uuid.MustParse(activeWH)); count != 1 {
+		t.Errorf("Expected the fresh event to remain, got %d events", count)
+	}
+}
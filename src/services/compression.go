@@ -0,0 +1,118 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed payloads start with compressionHeader, the magic and a format
+// version, followed by a zstd frame. The leading zero byte never starts JSON
+// or text, and data whose header doesn't validate is returned as-is, so rows
+// written before compression existed read back unchanged even if they start
+// with the magic. A payload that starts with the magic is always compressed
+// so it can't be mistaken for a compressed one.
+const (
+	compressionMagic  = "\x00OWZ"
+	compressionHeader = compressionMagic + "\x01" // format version 1
+)
+
+// zstdFrameMagic starts every zstd frame
+const zstdFrameMagic = "\x28\xb5\x2f\xfd"
+
+// minCompressSize is the smallest payload worth compressing; below it the
+// zstd frame overhead outweighs any saving
+const minCompressSize = 128
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec returns the shared encoder and decoder; EncodeAll and DecodeAll
+// are safe for concurrent use
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// isCompressed reports whether data carries a valid compression header: the
+// magic, a known version and the start of a zstd frame
+func isCompressed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(compressionHeader+zstdFrameMagic))
+}
+
+// hasCompressionMagic reports whether data starts like a compressed payload,
+// whether or not the rest of its header is valid
+func hasCompressionMagic(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(compressionMagic))
+}
+
+// compressPayload compresses data with zstd and prefixes the header. Data is
+// returned unchanged, with compressed false, when it is too small or does not
+// shrink.
+func compressPayload(data []byte) (out []byte, compressed bool, err error) {
+	mustCompress := hasCompressionMagic(data)
+	if len(data) < minCompressSize && !mustCompress {
+		return data, false, nil
+	}
+	enc, _, err := zstdCodec()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to initialise compression: %w", err)
+	}
+	out = enc.EncodeAll(data, []byte(compressionHeader))
+	if len(out) >= len(data) && !mustCompress {
+		return data, false, nil
+	}
+	return out, true, nil
+}
+
+// decompressPayload reverses compressPayload; data without a valid header is
+// returned unchanged
+func decompressPayload(data []byte) ([]byte, error) {
+	if !isCompressed(data) {
+		return data, nil
+	}
+	_, dec, err := zstdCodec()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise compression: %w", err)
+	}
+	out, err := dec.DecodeAll(data[len(compressionHeader):], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress event data: %w", err)
+	}
+	return out, nil
+}
+
+// decompressReader streams r through zstd when it starts with a valid header.
+// The returned close function releases the decoder.
+func decompressReader(r io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(compressionHeader) + len(zstdFrameMagic))
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	if !isCompressed(header) {
+		return br, func() {}, nil
+	}
+	if _, err := br.Discard(len(compressionHeader)); err != nil {
+		return nil, nil, err
+	}
+	dec, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decompress event data: %w", err)
+	}
+	return dec, dec.Close, nil
+}
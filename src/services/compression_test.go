@@ -0,0 +1,180 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+// monitoringPayload returns repetitive JSON like a monitoring integration sends
+func monitoringPayload() []byte {
+	var b strings.Builder
+	b.WriteString(`{"alerts":[`)
+	for i := 0; i < 200; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(`{"status":"firing","labels":{"alertname":"HighLatency","severity":"warning","service":"api"},"value":0.93}`)
+	}
+	b.WriteString(`]}`)
+	return []byte(b.String())
+}
+
+func TestCompressPayload(t *testing.T) {
+	payload := monitoringPayload()
+	out, compressed, err := compressPayload(payload)
+	if err != nil || !compressed {
+		t.Fatalf("expected compression, got %v (%v)", compressed, err)
+	}
+	if len(out)*5 > len(payload) {
+		t.Errorf("expected at least 5x compression, got %d -> %d bytes", len(payload), len(out))
+	}
+	if got, err := decompressPayload(out); err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("round trip failed: %v", err)
+	}
+
+	// Too small or incompressible data is stored as-is
+	for _, data := range [][]byte{[]byte(`{"ok":true}`), []byte(strings.Repeat("x", 10))} {
+		if out, compressed, _ := compressPayload(data); compressed || !bytes.Equal(out, data) {
+			t.Errorf("expected %q stored uncompressed", data)
+		}
+	}
+
+	// Data that looks compressed is always compressed so it reads back intact
+	lookalike := []byte(compressionMagic + "not a zstd frame")
+	out, compressed, err = compressPayload(lookalike)
+	if err != nil || !compressed {
+		t.Fatalf("expected a lookalike payload to be compressed, got %v (%v)", compressed, err)
+	}
+	if got, _ := decompressPayload(out); !bytes.Equal(got, lookalike) {
+		t.Errorf("lookalike payload changed: %q", got)
+	}
+
+	// Rows written before compression pass through, even ones that start
+	// with the magic but lack a valid version and zstd frame after it
+	for _, legacy := range []string{
+		"# legacy note",
+		compressionMagic + "plain text",
+		compressionMagic + "\x02" + zstdFrameMagic + "unknown version",
+		compressionHeader + "not a zstd frame",
+	} {
+		if got, err := decompressPayload([]byte(legacy)); err != nil || string(got) != legacy {
+			t.Errorf("legacy data changed: %q (%v)", got, err)
+		}
+		r, release, err := decompressReader(strings.NewReader(legacy))
+		if err != nil {
+			t.Fatalf("decompressReader failed: %v", err)
+		}
+		if got, _ := io.ReadAll(r); string(got) != legacy {
+			t.Errorf("streamed legacy data changed: %q", got)
+		}
+		release()
+	}
+}
+
+func TestEventService_Compression(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+	whID, _, _, _, _ := ts.CreateTestKeyPair(0, "")
+	webhookKeyID := uuid.MustParse(whID)
+
+	master, _ := NewEncryptor(validHexKey())
+	es := NewEventServiceWithEncryption(ts.Repos.Events, master)
+	es.SetDataKeys(NewDataKeyService(ts.Repos.DataKeys, master))
+
+	// Written before compression was turned on
+	legacy, err := es.CreateEvent(ctx, webhookKeyID, "legacy.json", monitoringPayload(), time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+
+	es.SetCompression(true)
+	payload := monitoringPayload()
+	event, err := es.CreateEvent(ctx, webhookKeyID, "alerts.json", payload, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	if !bytes.Equal(event.Data, payload) {
+		t.Error("expected the returned event to carry the original payload")
+	}
+
+	stored, _ := ts.Repos.Events.GetByID(ctx, event.ID)
+	if !stored.Compressed || stored.PayloadSize != int64(len(payload)) || stored.StoredSize != int64(len(stored.Data)) {
+		t.Errorf("unexpected accounting: compressed=%v payload=%d stored=%d (%d bytes)",
+			stored.Compressed, stored.PayloadSize, stored.StoredSize, len(stored.Data))
+	}
+	if len(stored.Data)*5 > len(payload) {
+		t.Errorf("expected the stored row to shrink, got %d of %d bytes", len(stored.Data), len(payload))
+	}
+
+	for _, id := range []uuid.UUID{legacy.ID, event.ID} {
+		got, err := es.GetEventByID(ctx, id)
+		if err != nil || !bytes.Equal(got.Data, payload) {
+			t.Fatalf("GetEventByID(%s): %d bytes, %v", id, len(got.Data), err)
+		}
+	}
+
+	stats, err := es.StorageStats(ctx)
+	if err != nil {
+		t.Fatalf("StorageStats failed: %v", err)
+	}
+	if stats.Events != 2 || stats.CompressedEvents != 1 || stats.PayloadBytes != 2*int64(len(payload)) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.CompressionRatio <= 1.5 {
+		t.Errorf("expected a compression ratio above 1.5, got %.2f", stats.CompressionRatio)
+	}
+}
+
+func TestEventService_CompressionWithBlobs(t *testing.T) {
+	es, _, ts, _ := newBlobTestService(t)
+	es.SetCompression(true)
+	ctx := context.Background()
+	whID, _, _, _, _ := ts.CreateTestKeyPair(0, "")
+	webhookKeyID := uuid.MustParse(whID)
+
+	// Compresses far below the blob threshold and stays inline
+	repetitive := largePayload()
+	inline, err := es.CreateEvent(ctx, webhookKeyID, "notes.md", repetitive, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	if stored, _ := ts.Repos.Events.GetByID(ctx, inline.ID); stored.BlobRef != "" || !stored.Compressed {
+		t.Errorf("expected a compressed inline row, got ref %q compressed=%v", stored.BlobRef, stored.Compressed)
+	}
+
+	// Large enough after compression to be offloaded; streamed back decompressed
+	var b strings.Builder
+	for i := 0; i < 20000; i++ {
+		b.WriteString(uuid.NewString())
+	}
+	large := []byte(b.String())
+	offloaded, err := es.CreateEvent(ctx, webhookKeyID, "ids.txt", large, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	stored, _ := ts.Repos.Events.GetByID(ctx, offloaded.ID)
+	if stored.BlobRef == "" || !stored.Compressed || stored.StoredSize >= int64(len(large)) {
+		t.Fatalf("expected a compressed blob, got ref %q compressed=%v stored=%d", stored.BlobRef, stored.Compressed, stored.StoredSize)
+	}
+
+	streamed := map[string][]byte{}
+	err = es.StreamUnprocessedEvents(ctx, webhookKeyID, func(event *models.Event, data io.Reader) error {
+		b, err := io.ReadAll(data)
+		streamed[event.Path] = b
+		return err
+	})
+	if err != nil {
+		t.Fatalf("StreamUnprocessedEvents failed: %v", err)
+	}
+	if !bytes.Equal(streamed["notes.md"], repetitive) || !bytes.Equal(streamed["ids.txt"], large) {
+		t.Errorf("unexpected streamed payloads: %d and %d bytes", len(streamed["notes.md"]), len(streamed["ids.txt"]))
+	}
+}
@@ -1,18 +1,75 @@
 package services
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"regexp"
+	"strings"
 )
 
-// Encryptor provides AES-256-GCM encryption/decryption for event data.
+// DefaultEncryptionKeyID is the key ID given to the single ENCRYPTION_KEY
+const DefaultEncryptionKeyID = "default"
+
+// Encryption errors returned by Decrypt for data it cannot open
+var (
+	// ErrUnknownEncryptionKey indicates the ciphertext names a key that is not in the keyring
+	ErrUnknownEncryptionKey = errors.New("unknown encryption key")
+
+	// ErrDecryptionFailed indicates the ciphertext failed authentication
+	ErrDecryptionFailed = errors.New("decryption failed")
+
+	// ErrUnversionedData indicates data without a key header while strict mode is on
+	ErrUnversionedData = errors.New("data is not in the versioned encryption format")
+)
+
+// Versioned ciphertext layout:
+//
+//	magic (4) | version (1) | key ID length (1) | key ID | nonce (12) | ciphertext+tag
+//
+// The magic starts with a NUL byte, which never begins text or JSON payloads,
+// so versioned data can be told apart from legacy ciphertext and plaintext.
+// Everything before the nonce is authenticated as additional data. Plaintext
+// that happens to start with the magic is stored, when encryption is off,
+// behind a header with an empty key ID and no nonce, to keep the two apart.
+const (
+	cipherMagic   = "\x00OWE"
+	cipherVersion = 1
+)
+
+// keyIDRe restricts key IDs to short printable names
+var keyIDRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+// EncryptionKey is a named AES-256 key in hex
+type EncryptionKey struct {
+	ID  string
+	Hex string
+}
+
+// EncryptorConfig configures a keyring
+type EncryptorConfig struct {
+	Keys        []EncryptionKey
+	ActiveKeyID string // key used for new data; empty = first key
+	// Strict rejects data without a key header instead of treating it as
+	// legacy ciphertext or plaintext. Enable once re-encryption has finished.
+	Strict bool
+}
+
+// Encryptor provides AES-256-GCM encryption/decryption for event data with a
+// keyring: data is encrypted with the active key and decrypted with whichever
+// key its header names, so keys can be rotated without losing stored data.
 // If nil, all operations are no-ops (pass-through).
 type Encryptor struct {
-	gcm cipher.AEAD
+	keys     map[string]cipher.AEAD
+	order    []string // key IDs in configuration order, for legacy decryption
+	activeID string
+	strict   bool
 }
 
 // NewEncryptor creates an Encryptor from a hex-encoded 32-byte key.
@@ -22,7 +79,52 @@ func NewEncryptor(hexKey string) (*Encryptor, error) {
 	if hexKey == "" {
 		return nil, nil
 	}
+	return NewEncryptorWithKeyring(EncryptorConfig{
+		Keys: []EncryptionKey{{ID: DefaultEncryptionKeyID, Hex: hexKey}},
+	})
+}
+
+// NewEncryptorWithKeyring creates an Encryptor from a keyring.
+// Returns nil if the keyring is empty (encryption disabled).
+func NewEncryptorWithKeyring(cfg EncryptorConfig) (*Encryptor, error) {
+	if len(cfg.Keys) == 0 {
+		if cfg.ActiveKeyID != "" {
+			return nil, fmt.Errorf("active encryption key %q is not configured", cfg.ActiveKeyID)
+		}
+		return nil, nil
+	}
 
+	e := &Encryptor{keys: make(map[string]cipher.AEAD), strict: cfg.Strict}
+	for _, k := range cfg.Keys {
+		if !keyIDRe.MatchString(k.ID) {
+			return nil, fmt.Errorf("invalid encryption key ID %q: use 1-32 letters, digits, '.', '_' or '-'", k.ID)
+		}
+		if strings.HasPrefix(k.ID, DataKeyIDPrefix) {
+			return nil, fmt.Errorf("invalid encryption key ID %q: the %q prefix is reserved for data keys", k.ID, DataKeyIDPrefix)
+		}
+		if _, ok := e.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate encryption key ID %q", k.ID)
+		}
+		gcm, err := newGCM(k.Hex)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", k.ID, err)
+		}
+		e.keys[k.ID] = gcm
+		e.order = append(e.order, k.ID)
+	}
+
+	e.activeID = cfg.ActiveKeyID
+	if e.activeID == "" {
+		e.activeID = e.order[0]
+	}
+	if _, ok := e.keys[e.activeID]; !ok {
+		return nil, fmt.Errorf("active encryption key %q is not configured", e.activeID)
+	}
+	return e, nil
+}
+
+// newGCM creates an AES-256-GCM cipher from a hex-encoded 32-byte key
+func newGCM(hexKey string) (cipher.AEAD, error) {
 	key, err := hex.DecodeString(hexKey)
 	if err != nil {
 		return nil, fmt.Errorf("invalid encryption key: not valid hex: %w", err)
@@ -30,7 +132,11 @@ func NewEncryptor(hexKey string) (*Encryptor, error) {
 	if len(key) != 32 {
 		return nil, fmt.Errorf("invalid encryption key: must be 32 bytes (64 hex chars), got %d bytes", len(key))
 	}
+	return gcmFromKey(key)
+}
 
+// gcmFromKey creates an AES-256-GCM cipher from a raw 32-byte key
+func gcmFromKey(key []byte) (cipher.AEAD, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
@@ -40,48 +146,226 @@ func NewEncryptor(hexKey string) (*Encryptor, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
+	return gcm, nil
+}
+
+// ParseEncryptionKeys parses a keyring spec of the form "id1:hex1,id2:hex2".
+// Entries may also be separated by newlines, and lines starting with '#' are
+// ignored, so the same format works for ENCRYPTION_KEYS_FILE.
+func ParseEncryptionKeys(spec string) ([]EncryptionKey, error) {
+	var keys []EncryptionKey
+	entries := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, hexKey, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid encryption key entry %q: expected id:hex", entry)
+		}
+		keys = append(keys, EncryptionKey{ID: strings.TrimSpace(id), Hex: strings.TrimSpace(hexKey)})
+	}
+	return keys, nil
+}
+
+// EncryptionKeySources lists where the master keyring is read from. Each key
+// can be given inline or as a file path (e.g. a Docker or Kubernetes secret),
+// but not both.
+type EncryptionKeySources struct {
+	Key      string // single hex key, joins the keyring as DefaultEncryptionKeyID
+	KeyFile  string // file holding the single hex key
+	Keys     string // keyring spec, see ParseEncryptionKeys
+	KeysFile string // file holding a keyring spec, one entry per line
+}
+
+// LoadEncryptionKeys assembles the master keyring from inline values and files
+func LoadEncryptionKeys(src EncryptionKeySources) ([]EncryptionKey, error) {
+	keySpec, err := inlineOrFile("ENCRYPTION_KEYS", src.Keys, src.KeysFile)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseEncryptionKeys(keySpec)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Encryptor{gcm: gcm}, nil
+	single, err := inlineOrFile("ENCRYPTION_KEY", src.Key, src.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if single != "" {
+		keys = append(keys, EncryptionKey{ID: DefaultEncryptionKeyID, Hex: single})
+	}
+	return keys, nil
 }
 
-// Encrypt encrypts plaintext using AES-256-GCM.
-// Returns nonce || ciphertext (nonce is 12 bytes prepended).
-// If encryptor is nil, returns plaintext unchanged.
+// inlineOrFile returns value, or the trimmed contents of path when value is empty
+func inlineOrFile(name, value, path string) (string, error) {
+	if path == "" {
+		return strings.TrimSpace(value), nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("set either %s or %s_FILE, not both", name, name)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// ActiveKeyID returns the ID of the key used for new data
+func (e *Encryptor) ActiveKeyID() string {
+	if e == nil {
+		return ""
+	}
+	return e.activeID
+}
+
+// header builds the versioned header for a key ID
+func header(keyID string) []byte {
+	h := make([]byte, 0, len(cipherMagic)+2+len(keyID))
+	h = append(h, cipherMagic...)
+	h = append(h, cipherVersion, byte(len(keyID)))
+	return append(h, keyID...)
+}
+
+// parseHeader splits versioned data into its header, key ID and payload.
+// ok is false when data does not start with the versioned magic.
+func parseHeader(data []byte) (hdr []byte, keyID string, payload []byte, ok bool, err error) {
+	if !bytes.HasPrefix(data, []byte(cipherMagic)) {
+		return nil, "", nil, false, nil
+	}
+	rest := data[len(cipherMagic):]
+	if len(rest) < 2 {
+		return nil, "", nil, true, fmt.Errorf("%w: truncated header", ErrDecryptionFailed)
+	}
+	if rest[0] != cipherVersion {
+		return nil, "", nil, true, fmt.Errorf("%w: unsupported format version %d", ErrDecryptionFailed, rest[0])
+	}
+	idLen := int(rest[1])
+	if len(rest) < 2+idLen {
+		return nil, "", nil, true, fmt.Errorf("%w: truncated header", ErrDecryptionFailed)
+	}
+	end := len(cipherMagic) + 2 + idLen
+	return data[:end], string(data[len(cipherMagic)+2 : end]), data[end:], true, nil
+}
+
+// KeyID returns the key ID named in versioned data, or "" for legacy data
+func KeyID(data []byte) string {
+	_, keyID, _, _, _ := parseHeader(data)
+	return keyID
+}
+
+// Encrypt encrypts plaintext with the active key using AES-256-GCM and
+// prefixes the versioned header. If encryptor is nil, returns plaintext unchanged
+// unless it starts with the versioned magic.
 func (e *Encryptor) Encrypt(plaintext []byte) ([]byte, error) {
 	if e == nil {
+		if bytes.HasPrefix(plaintext, []byte(cipherMagic)) {
+			return append(header(""), plaintext...), nil
+		}
 		return plaintext, nil
 	}
 
-	nonce := make([]byte, e.gcm.NonceSize())
+	gcm := e.keys[e.activeID]
+	hdr := header(e.activeID)
+
+	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
-	// Seal appends ciphertext+tag to nonce
-	return e.gcm.Seal(nonce, nonce, plaintext, nil), nil
+	out := make([]byte, 0, len(hdr)+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, hdr...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, hdr), nil
 }
 
-// Decrypt decrypts AES-256-GCM ciphertext (nonce || ciphertext).
-// If data is too short or decryption fails, returns raw data as-is
-// (backward compatibility with unencrypted events).
-// If encryptor is nil, returns ciphertext unchanged.
-func (e *Encryptor) Decrypt(ciphertext []byte) ([]byte, error) {
-	if e == nil {
-		return ciphertext, nil
+// Decrypt decrypts data produced by Encrypt with the key named in its header.
+// Data without a header predates the keyring: unless strict mode is on it is
+// tried as headerless ciphertext with every key and otherwise returned as-is
+// (unencrypted events stored before encryption was enabled).
+// If encryptor is nil, unversioned data is returned unchanged.
+func (e *Encryptor) Decrypt(data []byte) ([]byte, error) {
+	return e.decrypt(data, e != nil && e.strict)
+}
+
+// decrypt implements Decrypt; strict rejects data without a header
+func (e *Encryptor) decrypt(data []byte, strict bool) ([]byte, error) {
+	hdr, keyID, payload, versioned, err := parseHeader(data)
+	if err != nil {
+		return nil, err
 	}
 
-	nonceSize := e.gcm.NonceSize()
-	// Too short to be encrypted: nonce (12) + tag (16) = 28 bytes minimum
-	if len(ciphertext) < nonceSize+e.gcm.Overhead() {
-		return ciphertext, nil
+	if !versioned {
+		if strict {
+			return nil, ErrUnversionedData
+		}
+		return e.decryptLegacy(data), nil
+	}
+	if keyID == "" {
+		// Plaintext wrapped by Encrypt without a keyring
+		if strict {
+			return nil, ErrUnversionedData
+		}
+		return payload, nil
 	}
 
-	nonce, encrypted := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	plaintext, err := e.gcm.Open(nil, nonce, encrypted, nil)
-	if err != nil {
-		// Decryption failed — likely unencrypted data, return as-is
-		return ciphertext, nil
+	var gcm cipher.AEAD
+	if e != nil {
+		gcm = e.keys[keyID]
+	}
+	if gcm == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEncryptionKey, keyID)
+	}
+	if len(payload) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrDecryptionFailed)
 	}
 
+	nonce, sealed := payload[:gcm.NonceSize()], payload[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, hdr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %q", ErrDecryptionFailed, keyID)
+	}
 	return plaintext, nil
 }
+
+// decryptLegacy opens headerless nonce || ciphertext written before the
+// keyring existed, trying every key; anything that fails is plaintext
+func (e *Encryptor) decryptLegacy(data []byte) []byte {
+	if e == nil {
+		return data
+	}
+	for _, id := range e.order {
+		gcm := e.keys[id]
+		nonceSize := gcm.NonceSize()
+		// Too short to be encrypted: nonce (12) + tag (16) = 28 bytes minimum
+		if len(data) < nonceSize+gcm.Overhead() {
+			return data
+		}
+		if plaintext, err := gcm.Open(nil, data[:nonceSize], data[nonceSize:], nil); err == nil {
+			return plaintext
+		}
+	}
+	return data
+}
+
+// NeedsReencryption reports whether data is not yet encrypted with the active key
+func (e *Encryptor) NeedsReencryption(data []byte) bool {
+	if e == nil {
+		return false
+	}
+	return KeyID(data) != e.activeID
+}
+
+// Reencrypt re-encrypts data under the active key. Legacy data is accepted
+// even in strict mode so stored events can still be migrated.
+func (e *Encryptor) Reencrypt(data []byte) ([]byte, error) {
+	plaintext, err := e.decrypt(data, false)
+	if err != nil {
+		return nil, err
+	}
+	return e.Encrypt(plaintext)
+}
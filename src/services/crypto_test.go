@@ -2,7 +2,13 @@ package services
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -122,6 +128,54 @@ func TestNilEncryptor_Passthrough(t *testing.T) {
 	}
 }
 
+// TestNilEncryptor_MagicPrefixedPlaintext verifies plaintext that starts with
+// the ciphertext magic is not mistaken for ciphertext, with or without a
+// keyring once encryption is turned on
+func TestNilEncryptor_MagicPrefixedPlaintext(t *testing.T) {
+	var enc *Encryptor
+	plaintext := []byte(cipherMagic + "\x01\x07default not really ciphertext")
+
+	stored, err := enc.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("encrypt error: %v", err)
+	}
+	if bytes.Equal(stored, plaintext) {
+		t.Fatal("expected magic-prefixed plaintext to be wrapped")
+	}
+	if decrypted, err := enc.Decrypt(stored); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("nil encryptor decrypt: %q, %v", decrypted, err)
+	}
+
+	keyring, err := NewEncryptor(validHexKey())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decrypted, err := keyring.Decrypt(stored); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("keyring decrypt: %q, %v", decrypted, err)
+	}
+	if !keyring.NeedsReencryption(stored) {
+		t.Error("expected wrapped plaintext to need re-encryption")
+	}
+	reencrypted, err := keyring.Reencrypt(stored)
+	if err != nil {
+		t.Fatalf("reencrypt error: %v", err)
+	}
+	if decrypted, err := keyring.Decrypt(reencrypted); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypt re-encrypted: %q, %v", decrypted, err)
+	}
+
+	strict, err := NewEncryptorWithKeyring(EncryptorConfig{
+		Keys:   []EncryptionKey{{ID: DefaultEncryptionKeyID, Hex: validHexKey()}},
+		Strict: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := strict.Decrypt(stored); !errors.Is(err, ErrUnversionedData) {
+		t.Errorf("expected ErrUnversionedData in strict mode, got %v", err)
+	}
+}
+
 func TestDecrypt_UnencryptedData_Passthrough(t *testing.T) {
 	enc, err := NewEncryptor(validHexKey())
 	if err != nil {
@@ -155,10 +209,10 @@ func TestDecrypt_TooShortData_Passthrough(t *testing.T) {
 	}
 }
 
-func TestDecrypt_WrongKey_Passthrough(t *testing.T) {
+func TestDecrypt_WrongKey_Error(t *testing.T) {
 	enc1, _ := NewEncryptor(validHexKey())
 
-	// Different key
+	// Different key under the same key ID
 	key2 := make([]byte, 32)
 	key2[0] = 0xff
 	enc2, _ := NewEncryptor(hex.EncodeToString(key2))
@@ -166,13 +220,9 @@ func TestDecrypt_WrongKey_Passthrough(t *testing.T) {
 	plaintext := []byte("secret data")
 	ciphertext, _ := enc1.Encrypt(plaintext)
 
-	// Decrypt with wrong key — should return ciphertext as-is (fallback)
-	decrypted, err := enc2.Decrypt(ciphertext)
-	if err != nil {
-		t.Fatalf("decrypt error: %v", err)
-	}
-	if !bytes.Equal(decrypted, ciphertext) {
-		t.Fatal("wrong key decrypt should return ciphertext as-is")
+	// Versioned data that fails authentication is an error, never passed through
+	if _, err := enc2.Decrypt(ciphertext); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("expected ErrDecryptionFailed, got %v", err)
 	}
 }
 
@@ -187,3 +237,204 @@ func TestEncrypt_UniqueNonces(t *testing.T) {
 		t.Fatal("two encryptions of same data should produce different ciphertexts (different nonces)")
 	}
 }
+
+func otherHexKey() string {
+	return "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+}
+
+// legacyEncrypt produces the headerless nonce || ciphertext format written
+// before the keyring existed
+func legacyEncrypt(t *testing.T, hexKey string, plaintext []byte) []byte {
+	t.Helper()
+	key, _ := hex.DecodeString(hexKey)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, _ = rand.Read(nonce)
+	return gcm.Seal(nonce, nonce, plaintext, nil)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	old, err := NewEncryptor(validHexKey())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	oldCiphertext, _ := old.Encrypt([]byte("before rotation"))
+
+	rotated, err := NewEncryptorWithKeyring(EncryptorConfig{
+		Keys: []EncryptionKey{
+			{ID: "2026-10", Hex: otherHexKey()},
+			{ID: DefaultEncryptionKeyID, Hex: validHexKey()},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rotated.ActiveKeyID() != "2026-10" {
+		t.Fatalf("expected the first key to be active, got %q", rotated.ActiveKeyID())
+	}
+
+	// Data under the retired key still decrypts
+	decrypted, err := rotated.Decrypt(oldCiphertext)
+	if err != nil || string(decrypted) != "before rotation" {
+		t.Fatalf("decrypt with retired key: %q, %v", decrypted, err)
+	}
+
+	// New data uses the active key and names it in the header
+	newCiphertext, _ := rotated.Encrypt([]byte("after rotation"))
+	if KeyID(newCiphertext) != "2026-10" {
+		t.Fatalf("expected key ID 2026-10 in header, got %q", KeyID(newCiphertext))
+	}
+	if !rotated.NeedsReencryption(oldCiphertext) || rotated.NeedsReencryption(newCiphertext) {
+		t.Fatal("only data under the retired key should need re-encryption")
+	}
+
+	// The old encryptor does not know the new key
+	if _, err := old.Decrypt(newCiphertext); !errors.Is(err, ErrUnknownEncryptionKey) {
+		t.Fatalf("expected ErrUnknownEncryptionKey, got %v", err)
+	}
+
+	reencrypted, err := rotated.Reencrypt(oldCiphertext)
+	if err != nil {
+		t.Fatalf("reencrypt error: %v", err)
+	}
+	if KeyID(reencrypted) != "2026-10" {
+		t.Fatalf("expected re-encrypted data under 2026-10, got %q", KeyID(reencrypted))
+	}
+}
+
+func TestKeyring_InvalidConfig(t *testing.T) {
+	tests := map[string]EncryptorConfig{
+		"duplicate ID": {Keys: []EncryptionKey{{ID: "a", Hex: validHexKey()}, {ID: "a", Hex: otherHexKey()}}},
+		"bad ID":       {Keys: []EncryptionKey{{ID: "no spaces", Hex: validHexKey()}}},
+		"bad key":      {Keys: []EncryptionKey{{ID: "a", Hex: "abcd"}}},
+		"unknown active": {
+			Keys:        []EncryptionKey{{ID: "a", Hex: validHexKey()}},
+			ActiveKeyID: "b",
+		},
+		"active without keys": {ActiveKeyID: "a"},
+		"reserved prefix":     {Keys: []EncryptionKey{{ID: DataKeyIDPrefix + "a", Hex: validHexKey()}}},
+	}
+	for name, cfg := range tests {
+		if _, err := NewEncryptorWithKeyring(cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseEncryptionKeys(t *testing.T) {
+	keys, err := ParseEncryptionKeys(" new:" + otherHexKey() + ", old:" + validHexKey() + ",")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "new" || keys[1].ID != "old" || keys[1].Hex != validHexKey() {
+		t.Fatalf("unexpected keys: %+v", keys)
+	}
+
+	keys, err = ParseEncryptionKeys("# rotated 2026-10\nnew:" + otherHexKey() + "\n\nold:" + validHexKey() + "\n")
+	if err != nil || len(keys) != 2 || keys[1].ID != "old" {
+		t.Fatalf("unexpected file keys: %+v, %v", keys, err)
+	}
+
+	if _, err := ParseEncryptionKeys("missing-separator"); err == nil {
+		t.Fatal("expected error for entry without id:hex")
+	}
+}
+
+func TestLoadEncryptionKeys(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(validHexKey()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keysFile := filepath.Join(dir, "keys")
+	if err := os.WriteFile(keysFile, []byte("new:"+otherHexKey()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadEncryptionKeys(EncryptionKeySources{KeyFile: keyFile, KeysFile: keysFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "new" || keys[1].ID != DefaultEncryptionKeyID || keys[1].Hex != validHexKey() {
+		t.Fatalf("unexpected keys: %+v", keys)
+	}
+
+	if _, err := LoadEncryptionKeys(EncryptionKeySources{Key: validHexKey(), KeyFile: keyFile}); err == nil {
+		t.Error("expected error when both ENCRYPTION_KEY and ENCRYPTION_KEY_FILE are set")
+	}
+	if _, err := LoadEncryptionKeys(EncryptionKeySources{KeyFile: filepath.Join(dir, "missing")}); err == nil {
+		t.Error("expected error for a missing key file")
+	}
+}
+
+func TestDecrypt_LegacyCiphertext(t *testing.T) {
+	enc, err := NewEncryptorWithKeyring(EncryptorConfig{
+		Keys: []EncryptionKey{
+			{ID: "new", Hex: otherHexKey()},
+			{ID: DefaultEncryptionKeyID, Hex: validHexKey()},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	legacy := legacyEncrypt(t, validHexKey(), []byte("written before keyring"))
+	decrypted, err := enc.Decrypt(legacy)
+	if err != nil || string(decrypted) != "written before keyring" {
+		t.Fatalf("legacy decrypt: %q, %v", decrypted, err)
+	}
+	if !enc.NeedsReencryption(legacy) {
+		t.Fatal("legacy data should need re-encryption")
+	}
+}
+
+func TestDecrypt_Strict(t *testing.T) {
+	enc, err := NewEncryptorWithKeyring(EncryptorConfig{
+		Keys:   []EncryptionKey{{ID: DefaultEncryptionKeyID, Hex: validHexKey()}},
+		Strict: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	legacy := legacyEncrypt(t, validHexKey(), []byte("legacy"))
+	for _, data := range [][]byte{[]byte(`{"title":"Old Event"}`), legacy} {
+		if _, err := enc.Decrypt(data); !errors.Is(err, ErrUnversionedData) {
+			t.Fatalf("expected ErrUnversionedData, got %v", err)
+		}
+	}
+
+	// Re-encryption still migrates legacy data in strict mode
+	reencrypted, err := enc.Reencrypt(legacy)
+	if err != nil {
+		t.Fatalf("reencrypt error: %v", err)
+	}
+	decrypted, err := enc.Decrypt(reencrypted)
+	if err != nil || string(decrypted) != "legacy" {
+		t.Fatalf("decrypt re-encrypted: %q, %v", decrypted, err)
+	}
+}
+
+func TestDecrypt_TamperedHeader(t *testing.T) {
+	enc, _ := NewEncryptorWithKeyring(EncryptorConfig{
+		Keys: []EncryptionKey{{ID: "aa", Hex: validHexKey()}, {ID: "ab", Hex: validHexKey()}},
+	})
+	ciphertext, _ := enc.Encrypt([]byte("data"))
+
+	// Same key material under another ID: the header is authenticated
+	tampered := bytes.Replace(ciphertext, []byte("aa"), []byte("ab"), 1)
+	if _, err := enc.Decrypt(tampered); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("expected ErrDecryptionFailed, got %v", err)
+	}
+
+	if _, err := enc.Decrypt(ciphertext[:8]); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("expected ErrDecryptionFailed for truncated data, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// DataKeyIDPrefix starts the key ID of every per-pair data encryption key, so
+// event data under a data key can be told apart from data under a master key
+const DataKeyIDPrefix = "dek-"
+
+// ErrDataKeyNotFound indicates event data names a data key that no longer
+// exists: the pair's key was shredded and its events cannot be read
+var ErrDataKeyNotFound = errors.New("data key not found")
+
+// DataKeyService implements envelope encryption. Each key pair gets its own
+// random AES-256 data key (DEK); the DEK is stored wrapped by the master
+// keyring and event data is encrypted with the DEK. Rotating the master key
+// therefore only rewraps the DEKs, and deleting a pair's DEK makes every copy
+// of its events unreadable, including those in backups.
+type DataKeyService struct {
+	repo   repositories.DataKeyRepository
+	master *Encryptor
+
+	mu    sync.Mutex
+	cache map[uuid.UUID]*Encryptor // unwrapped DEKs by webhook key ID
+}
+
+// NewDataKeyService creates a data key service wrapping DEKs with master.
+// Returns nil if master is nil (encryption disabled).
+func NewDataKeyService(repo repositories.DataKeyRepository, master *Encryptor) *DataKeyService {
+	if master == nil {
+		return nil
+	}
+	return &DataKeyService{repo: repo, master: master, cache: make(map[uuid.UUID]*Encryptor)}
+}
+
+// Encrypt encrypts event data for a key pair with its DEK, creating the DEK
+// on first use
+func (s *DataKeyService) Encrypt(ctx context.Context, webhookKeyID uuid.UUID, plaintext []byte) ([]byte, error) {
+	dek, err := s.dataKey(ctx, webhookKeyID, true)
+	if err != nil {
+		return nil, err
+	}
+	return dek.Encrypt(plaintext)
+}
+
+// Decrypt decrypts a key pair's event data. Data written before data keys
+// existed is still decrypted with the master keyring.
+func (s *DataKeyService) Decrypt(ctx context.Context, webhookKeyID uuid.UUID, data []byte) ([]byte, error) {
+	keyID := KeyID(data)
+	if !strings.HasPrefix(keyID, DataKeyIDPrefix) {
+		return s.master.Decrypt(data)
+	}
+
+	dek, err := s.dataKey(ctx, webhookKeyID, false)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %q", ErrDataKeyNotFound, keyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dek.ActiveKeyID() != keyID {
+		return nil, fmt.Errorf("%w: %q", ErrDataKeyNotFound, keyID)
+	}
+	return dek.Decrypt(data)
+}
+
+// NeedsReencryption reports whether data is not yet encrypted with a data key
+func (s *DataKeyService) NeedsReencryption(data []byte) bool {
+	return !strings.HasPrefix(KeyID(data), DataKeyIDPrefix)
+}
+
+// Reencrypt moves data encrypted with the master keyring, or stored as
+// plaintext, onto the pair's DEK
+func (s *DataKeyService) Reencrypt(ctx context.Context, webhookKeyID uuid.UUID, data []byte) ([]byte, error) {
+	plaintext, err := s.master.decrypt(data, false)
+	if err != nil {
+		return nil, err
+	}
+	return s.Encrypt(ctx, webhookKeyID, plaintext)
+}
+
+// Shred deletes a key pair's DEK. Its stored events can no longer be
+// decrypted; a new DEK is created if the pair receives events again.
+func (s *DataKeyService) Shred(ctx context.Context, webhookKeyID uuid.UUID) error {
+	s.mu.Lock()
+	delete(s.cache, webhookKeyID)
+	s.mu.Unlock()
+
+	err := s.repo.Delete(ctx, webhookKeyID)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		return fmt.Errorf("failed to delete data key: %w", err)
+	}
+	return nil
+}
+
+// RewrapAll rewraps every DEK that is not wrapped with the active master key.
+// Event rows are not touched. It returns how many keys were rewrapped and how
+// many failed; only read errors and cancellation stop the pass.
+func (s *DataKeyService) RewrapAll(ctx context.Context, batchSize int) (rewrapped, failed int64, err error) {
+	after := uuid.Nil
+	for {
+		if err := ctx.Err(); err != nil {
+			return rewrapped, failed, err
+		}
+
+		keys, err := s.repo.ListAfter(ctx, after, batchSize)
+		if err != nil {
+			return rewrapped, failed, err
+		}
+
+		for _, k := range keys {
+			if !s.master.NeedsReencryption(k.WrappedKey) {
+				continue
+			}
+			wrapped, err := s.master.Reencrypt(k.WrappedKey)
+			if err == nil {
+				err = s.repo.UpdateWrappedKey(ctx, k.WebhookKeyID, k.KeyID, wrapped)
+			}
+			switch {
+			case err == nil:
+				rewrapped++
+			case errors.Is(err, repositories.ErrNotFound):
+				// Shredded since it was read
+			default:
+				failed++
+				log.Printf("Failed to rewrap data key for %s: %v", k.WebhookKeyID, err)
+			}
+		}
+
+		if len(keys) < batchSize {
+			return rewrapped, failed, nil
+		}
+		after = keys[len(keys)-1].WebhookKeyID
+	}
+}
+
+// dataKey returns the unwrapped DEK for a pair, creating one if create is set.
+// Without create a missing DEK is reported as repositories.ErrNotFound.
+func (s *DataKeyService) dataKey(ctx context.Context, webhookKeyID uuid.UUID, create bool) (*Encryptor, error) {
+	s.mu.Lock()
+	dek, ok := s.cache[webhookKeyID]
+	s.mu.Unlock()
+	if ok {
+		return dek, nil
+	}
+
+	stored, err := s.repo.Get(ctx, webhookKeyID)
+	if errors.Is(err, repositories.ErrNotFound) && create {
+		if err := s.createDataKey(ctx, webhookKeyID); err != nil {
+			return nil, err
+		}
+		// Re-read: a concurrent writer may have created the pair's key first
+		stored, err = s.repo.Get(ctx, webhookKeyID)
+	}
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+
+	dek, err = s.unwrap(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[webhookKeyID] = dek
+	s.mu.Unlock()
+	return dek, nil
+}
+
+// createDataKey generates a DEK for a pair and stores it wrapped
+func (s *DataKeyService) createDataKey(ctx context.Context, webhookKeyID uuid.UUID) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+	idBytes := make([]byte, 6)
+	if _, err := rand.Read(idBytes); err != nil {
+		return fmt.Errorf("failed to generate data key ID: %w", err)
+	}
+
+	wrapped, err := s.master.Encrypt(raw)
+	if err != nil {
+		return fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	err = s.repo.Create(ctx, &models.DataKey{
+		WebhookKeyID: webhookKeyID,
+		KeyID:        DataKeyIDPrefix + hex.EncodeToString(idBytes),
+		WrappedKey:   wrapped,
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store data key: %w", err)
+	}
+	return nil
+}
+
+// unwrap decrypts a stored DEK into a single-key Encryptor
+func (s *DataKeyService) unwrap(stored *models.DataKey) (*Encryptor, error) {
+	raw, err := s.master.Decrypt(stored.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key %q: %w", stored.KeyID, err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("failed to unwrap data key %q: got %d bytes", stored.KeyID, len(raw))
+	}
+
+	gcm, err := gcmFromKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Encryptor{
+		keys:     map[string]cipher.AEAD{stored.KeyID: gcm},
+		order:    []string{stored.KeyID},
+		activeID: stored.KeyID,
+		strict:   true,
+	}, nil
+}
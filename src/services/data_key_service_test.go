@@ -0,0 +1,159 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+func TestDataKeyService_EncryptDecrypt(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+	master, _ := NewEncryptor(validHexKey())
+	dks := NewDataKeyService(ts.Repos.DataKeys, master)
+
+	whA, _, _, _, _ := ts.CreateTestKeyPair(0, "")
+	whB, _, _, _, _ := ts.CreateTestKeyPair(0, "")
+	idA, idB := uuid.MustParse(whA), uuid.MustParse(whB)
+
+	ciphertext, err := dks.Encrypt(ctx, idA, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if !strings.HasPrefix(KeyID(ciphertext), DataKeyIDPrefix) {
+		t.Fatalf("expected a data key ID, got %q", KeyID(ciphertext))
+	}
+	plaintext, err := dks.Decrypt(ctx, idA, ciphertext)
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatalf("Decrypt: got %q, %v", plaintext, err)
+	}
+
+	// Each pair has its own key; another pair's key cannot open the data
+	if _, err := dks.Encrypt(ctx, idB, []byte("other")); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if _, err := dks.Decrypt(ctx, idB, ciphertext); !errors.Is(err, ErrDataKeyNotFound) {
+		t.Errorf("expected ErrDataKeyNotFound with another pair's key, got %v", err)
+	}
+
+	// The stored key is wrapped, not raw
+	stored, err := ts.Repos.DataKeys.Get(ctx, idA)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if KeyID(stored.WrappedKey) != DefaultEncryptionKeyID {
+		t.Errorf("expected the data key wrapped by the master key, got key ID %q", KeyID(stored.WrappedKey))
+	}
+
+	// Data written under the master key before data keys still decrypts
+	underMaster, _ := master.Encrypt([]byte("before"))
+	if plaintext, err := dks.Decrypt(ctx, idA, underMaster); err != nil || string(plaintext) != "before" {
+		t.Errorf("master-key data: got %q, %v", plaintext, err)
+	}
+}
+
+func TestDataKeyService_Shred(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+	master, _ := NewEncryptor(validHexKey())
+	dks := NewDataKeyService(ts.Repos.DataKeys, master)
+	es := NewEventServiceWithEncryption(ts.Repos.Events, master)
+	es.SetDataKeys(dks)
+
+	whID, _, _, _, _ := ts.CreateTestKeyPair(0, "")
+	webhookKeyID := uuid.MustParse(whID)
+	event, err := es.CreateEvent(ctx, webhookKeyID, "note.md", []byte("shred me"), time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	if got, err := es.GetEventByID(ctx, event.ID); err != nil || string(got.Data) != "shred me" {
+		t.Fatalf("GetEventByID: got %+v, %v", got, err)
+	}
+
+	if err := dks.Shred(ctx, webhookKeyID); err != nil {
+		t.Fatalf("Shred failed: %v", err)
+	}
+	// A fresh service has no cached copy, as after a restart
+	es.SetDataKeys(NewDataKeyService(ts.Repos.DataKeys, master))
+	for _, svc := range []*DataKeyService{dks, es.dataKeys} {
+		stored, _ := ts.Repos.Events.GetByID(ctx, event.ID)
+		if _, err := svc.Decrypt(ctx, webhookKeyID, stored.Data); !errors.Is(err, ErrDataKeyNotFound) {
+			t.Errorf("expected ErrDataKeyNotFound after shredding, got %v", err)
+		}
+	}
+	if _, err := es.GetEventByID(ctx, event.ID); !errors.Is(err, ErrDataKeyNotFound) {
+		t.Errorf("expected shredded event to be unreadable, got %v", err)
+	}
+
+	// New events get a new key
+	if _, err := es.CreateEvent(ctx, webhookKeyID, "note.md", []byte("again"), time.Hour); err != nil {
+		t.Fatalf("CreateEvent after shred failed: %v", err)
+	}
+	if err := dks.Shred(ctx, uuid.New()); err != nil {
+		t.Errorf("Shred of a pair without a key should succeed, got %v", err)
+	}
+}
+
+func TestDataKeyService_MasterRotation(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+	old, _ := NewEncryptor(validHexKey())
+	es := NewEventServiceWithEncryption(ts.Repos.Events, old)
+	es.SetDataKeys(NewDataKeyService(ts.Repos.DataKeys, old))
+
+	whID, _, _, _, _ := ts.CreateTestKeyPair(0, "")
+	webhookKeyID := uuid.MustParse(whID)
+	event, err := es.CreateEvent(ctx, webhookKeyID, "note.md", []byte("rotate"), time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	legacyID, err := ts.CreateTestEvent(whID, "old.md", legacyEncrypt(t, validHexKey(), []byte("legacy")))
+	if err != nil {
+		t.Fatalf("CreateTestEvent failed: %v", err)
+	}
+	before, _ := ts.Repos.Events.GetByID(ctx, event.ID)
+
+	rotated, _ := NewEncryptorWithKeyring(EncryptorConfig{
+		Keys: []EncryptionKey{
+			{ID: "new", Hex: otherHexKey()},
+			{ID: DefaultEncryptionKeyID, Hex: validHexKey()},
+		},
+	})
+	dks := NewDataKeyService(ts.Repos.DataKeys, rotated)
+	svc := NewReencryptionService(ts.Repos.Events, rotated, 10)
+	svc.SetDataKeys(dks)
+	result, err := svc.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.RewrappedKeys != 1 || result.Reencrypted != 1 || result.Failed != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	// The event under the data key was not rewritten; only its key was rewrapped
+	after, _ := ts.Repos.Events.GetByID(ctx, event.ID)
+	if !bytes.Equal(before.Data, after.Data) {
+		t.Error("event data under a data key should not change on master rotation")
+	}
+	stored, _ := ts.Repos.DataKeys.Get(ctx, webhookKeyID)
+	if KeyID(stored.WrappedKey) != "new" {
+		t.Errorf("expected the data key rewrapped under %q, got %q", "new", KeyID(stored.WrappedKey))
+	}
+
+	// The old master key can now be retired
+	onlyNew, _ := NewEncryptorWithKeyring(EncryptorConfig{Keys: []EncryptionKey{{ID: "new", Hex: otherHexKey()}}, Strict: true})
+	fresh := NewDataKeyService(ts.Repos.DataKeys, onlyNew)
+	for id, want := range map[uuid.UUID]string{event.ID: "rotate", uuid.MustParse(legacyID): "legacy"} {
+		e, _ := ts.Repos.Events.GetByID(ctx, id)
+		plaintext, err := fresh.Decrypt(ctx, webhookKeyID, e.Data)
+		if err != nil || string(plaintext) != want {
+			t.Errorf("event %s: got %q, %v", id, plaintext, err)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/templates"
+)
+
+// Defaults for zero DeliveryAlertConfig fields
+const (
+	DefaultDeliveryAlertInterval = 15 * time.Minute
+	DefaultDeliveryAlertMinAge   = 24 * time.Hour
+	DefaultDeliveryAlertCooldown = 24 * time.Hour
+)
+
+// DeliveryAlertWebhookType is the "type" of the JSON body posted to the admin webhook
+const DeliveryAlertWebhookType = "deliveries.stuck"
+
+// DeliveryAlertConfig configures the stuck delivery monitor
+type DeliveryAlertConfig struct {
+	Enabled  bool
+	Interval time.Duration // between checks
+	MinAge   time.Duration // a key is stuck once its oldest due event has waited this long
+	MinCount int           // or once this many due events wait; 0 = age only
+	Cooldown time.Duration // before alerting about the same key again
+
+	BaseURL     string   // public URL of the server, for links in emails
+	AdminEmails []string // receive a digest of the keys each check alerts about
+	WebhookURL  string   // receives the same digest as a JSON POST
+}
+
+// DeliveryAlertReport describes one check
+type DeliveryAlertReport struct {
+	CheckedAt    time.Time `json:"checked_at"`
+	Stuck        int       `json:"stuck"`         // keys over a threshold
+	Alerted      int       `json:"alerted"`       // keys outside their cooldown that were reported
+	UserEmails   int       `json:"user_emails"`   // owners emailed
+	AdminDigests int       `json:"admin_digests"` // admin emails and webhook posts sent
+	Errors       []string  `json:"errors,omitempty"`
+}
+
+// DeliveryAlertStatus is the monitor configuration and last check exposed to admins
+type DeliveryAlertStatus struct {
+	Enabled     bool                 `json:"enabled"`
+	Interval    string               `json:"interval"`
+	MinAge      string               `json:"min_age"`
+	MinCount    int                  `json:"min_count"`
+	Cooldown    string               `json:"cooldown"`
+	UserEmails  bool                 `json:"user_emails"` // owners are emailed; needs an email transport
+	AdminEmails int                  `json:"admin_emails"`
+	Webhook     bool                 `json:"webhook"`
+	LastCheck   *DeliveryAlertReport `json:"last_check,omitempty"`
+}
+
+// deliveryAlertWebhook is the JSON body posted to DeliveryAlertConfig.WebhookURL
+type deliveryAlertWebhook struct {
+	Type       string                 `json:"type"`
+	CheckedAt  time.Time              `json:"checked_at"`
+	Deliveries []models.StuckDelivery `json:"deliveries"`
+}
+
+// DeliveryAlertService watches for webhook keys whose events no client is
+// fetching and tells their owners and the admins. Each key is reported at
+// most once per cooldown, however long it stays stuck.
+type DeliveryAlertService struct {
+	cfg        DeliveryAlertConfig
+	events     repositories.EventRepository
+	keys       repositories.KeyRepository
+	users      repositories.UserRepository
+	email      *EmailService
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	last   *DeliveryAlertReport
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDeliveryAlertService creates a stuck delivery monitor; zero durations
+// in cfg take their defaults
+func NewDeliveryAlertService(events repositories.EventRepository, keys repositories.KeyRepository, users repositories.UserRepository, cfg DeliveryAlertConfig) *DeliveryAlertService {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultDeliveryAlertInterval
+	}
+	if cfg.MinAge <= 0 {
+		cfg.MinAge = DefaultDeliveryAlertMinAge
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = DefaultDeliveryAlertCooldown
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	return &DeliveryAlertService{
+		cfg:        cfg,
+		events:     events,
+		keys:       keys,
+		users:      users,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SetEmailService emails key owners who have not opted out, and the admin
+// addresses. Without it only the admin webhook is notified.
+func (s *DeliveryAlertService) SetEmailService(email *EmailService) {
+	s.email = email
+}
+
+// Start checks every Interval in the background. It returns immediately and
+// does nothing when the monitor is disabled.
+func (s *DeliveryAlertService) Start(ctx context.Context) {
+	if !s.cfg.Enabled {
+		log.Println("Delivery alerts are disabled")
+		return
+	}
+
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.mu.Unlock()
+		return
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.loop(ctx)
+
+	log.Printf("Delivery alerts started (every %s, after %s or %d events)", s.cfg.Interval, s.cfg.MinAge, s.cfg.MinCount)
+}
+
+// loop runs a check every Interval until ctx is cancelled
+func (s *DeliveryAlertService) loop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Delivery alerts stopped")
+			return
+		case <-ticker.C:
+			if _, err := s.Check(ctx); err != nil {
+				log.Printf("Delivery alert check failed: %v", err)
+			}
+		}
+	}
+}
+
+// Stop cancels the monitor and waits for a check in progress to finish. It
+// is safe to call when the monitor was never started.
+func (s *DeliveryAlertService) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.cancel = nil
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	s.wg.Wait()
+}
+
+// Stuck returns the webhook keys over a threshold right now, cooldown or not
+func (s *DeliveryAlertService) Stuck(ctx context.Context) ([]models.StuckDelivery, error) {
+	stuck, err := s.events.ListStuckDeliveries(ctx, time.Now().Add(-s.cfg.MinAge), s.cfg.MinCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stuck deliveries: %w", err)
+	}
+	return stuck, nil
+}
+
+// Check finds the stuck keys outside their cooldown and notifies each owner
+// about their own keys and the admins about all of them. A key counts as
+// alerted once any notification about it went out; if none did, the next
+// check tries again. Failed notifications are logged and reported, not returned.
+func (s *DeliveryAlertService) Check(ctx context.Context) (*DeliveryAlertReport, error) {
+	stuck, err := s.Stuck(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	report := &DeliveryAlertReport{CheckedAt: now, Stuck: len(stuck)}
+	var due []models.StuckDelivery
+	for _, d := range stuck {
+		if d.AlertedAt == nil || now.Sub(*d.AlertedAt) >= s.cfg.Cooldown {
+			due = append(due, d)
+		}
+	}
+
+	alerted := make(map[uuid.UUID]bool)
+	if len(due) > 0 {
+		s.notifyOwners(ctx, due, now, report, alerted)
+		if s.notifyAdmins(ctx, due, now, report) {
+			for _, d := range due {
+				alerted[d.WebhookKeyID] = true
+			}
+		}
+	}
+
+	for _, d := range due {
+		if !alerted[d.WebhookKeyID] {
+			continue
+		}
+		if err := s.keys.SetDeliveryAlertedAt(ctx, d.WebhookKeyID, now); err != nil {
+			report.fail(fmt.Errorf("failed to record alert for key %s: %w", d.KeyPrefix, err))
+			continue
+		}
+		report.Alerted++
+	}
+
+	s.mu.Lock()
+	s.last = report
+	s.mu.Unlock()
+	return report, nil
+}
+
+// notifyOwners emails each owner who has not opted out one list of their stuck keys
+func (s *DeliveryAlertService) notifyOwners(ctx context.Context, due []models.StuckDelivery, now time.Time, report *DeliveryAlertReport, alerted map[uuid.UUID]bool) {
+	if s.email == nil {
+		return
+	}
+
+	var owners []string
+	byOwner := make(map[string][]models.StuckDelivery)
+	for _, d := range due {
+		if d.UserEmail == "" || !d.AlertsEnabled {
+			continue
+		}
+		if _, ok := byOwner[d.UserEmail]; !ok {
+			owners = append(owners, d.UserEmail)
+		}
+		byOwner[d.UserEmail] = append(byOwner[d.UserEmail], d)
+	}
+
+	for _, owner := range owners {
+		var name string
+		if profile, err := s.users.GetByEmail(ctx, owner); err == nil {
+			name = profile.Name
+		}
+		keys := byOwner[owner]
+		if err := s.email.SendDeliveryAlertEmail(ctx, owner, name, s.cfg.BaseURL+"/dashboard", alertKeys(keys, false, now)); err != nil {
+			report.fail(err)
+			continue
+		}
+		report.UserEmails++
+		for _, d := range keys {
+			alerted[d.WebhookKeyID] = true
+		}
+	}
+}
+
+// notifyAdmins sends the digest to every admin address and the webhook and
+// reports whether any of them received it
+func (s *DeliveryAlertService) notifyAdmins(ctx context.Context, due []models.StuckDelivery, now time.Time, report *DeliveryAlertReport) bool {
+	sent := false
+	if s.email != nil {
+		keys := alertKeys(due, true, now)
+		for _, to := range s.cfg.AdminEmails {
+			if err := s.email.SendDeliveryDigestEmail(ctx, to, s.cfg.BaseURL+"/admin", keys); err != nil {
+				report.fail(err)
+				continue
+			}
+			report.AdminDigests++
+			sent = true
+		}
+	}
+	if s.cfg.WebhookURL != "" {
+		if err := s.postWebhook(ctx, due, now); err != nil {
+			report.fail(fmt.Errorf("failed to post delivery alert webhook: %w", err))
+		} else {
+			report.AdminDigests++
+			sent = true
+		}
+	}
+	return sent
+}
+
+// postWebhook posts the digest as JSON and expects a 2xx answer
+func (s *DeliveryAlertService) postWebhook(ctx context.Context, due []models.StuckDelivery, now time.Time) error {
+	body, err := json.Marshal(deliveryAlertWebhook{Type: DeliveryAlertWebhookType, CheckedAt: now.UTC(), Deliveries: due})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Status returns the monitor configuration and the last check
+func (s *DeliveryAlertService) Status() DeliveryAlertStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return DeliveryAlertStatus{
+		Enabled:     s.cfg.Enabled,
+		Interval:    s.cfg.Interval.String(),
+		MinAge:      s.cfg.MinAge.String(),
+		MinCount:    s.cfg.MinCount,
+		Cooldown:    s.cfg.Cooldown.String(),
+		UserEmails:  s.email != nil,
+		AdminEmails: len(s.cfg.AdminEmails),
+		Webhook:     s.cfg.WebhookURL != "",
+		LastCheck:   s.last,
+	}
+}
+
+// fail records a notification that could not be sent
+func (r *DeliveryAlertReport) fail(err error) {
+	log.Printf("Delivery alert: %v", err)
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// alertKeys lists stuck keys for an alert email, with their owners for admins
+func alertKeys(stuck []models.StuckDelivery, withOwner bool, now time.Time) []templates.DeliveryAlertKey {
+	keys := make([]templates.DeliveryAlertKey, 0, len(stuck))
+	for _, d := range stuck {
+		key := templates.DeliveryAlertKey{Label: d.KeyPrefix, Undelivered: d.Undelivered, Waiting: waitingFor(now.Sub(d.OldestDueAt))}
+		if d.KeyName != "" {
+			key.Label = d.KeyName + " (" + d.KeyPrefix + ")"
+		}
+		if withOwner {
+			key.Owner = d.UserEmail
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// waitingFor describes a wait in whole days, hours or minutes
+func waitingFor(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%d days", int(d/(24*time.Hour)))
+	case d >= 24*time.Hour:
+		return "1 day"
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", int(d/time.Hour))
+	case d >= time.Hour:
+		return "1 hour"
+	case d >= 2*time.Minute:
+		return fmt.Sprintf("%d minutes", int(d/time.Minute))
+	default:
+		return "1 minute"
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+// recordingEmailSender collects sent emails, or fails every send with err
+type recordingEmailSender struct {
+	mu   sync.Mutex
+	sent []*EmailMessage
+	err  error
+}
+
+func (s *recordingEmailSender) Send(ctx context.Context, msg *EmailMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+// to returns the emails sent to address
+func (s *recordingEmailSender) to(address string) []*EmailMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []*EmailMessage
+	for _, msg := range s.sent {
+		if msg.To == address {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// createStuckKey creates a webhook key, owned by email unless it is empty,
+// with count events that have waited since age ago
+func createStuckKey(t *testing.T, repos *repositories.Repositories, email, prefix string, count int, age time.Duration) *models.WebhookKey {
+	t.Helper()
+	ctx := context.Background()
+	wk := &models.WebhookKey{KeyHash: "hash_" + uuid.NewString(), KeyPrefix: prefix}
+	ck := &models.ClientKey{KeyHash: "hash_" + uuid.NewString(), KeyPrefix: "ck_" + prefix}
+	if email == "" {
+		if err := repos.Keys.CreateKeyPair(ctx, wk, ck); err != nil {
+			t.Fatalf("CreateKeyPair failed: %v", err)
+		}
+	} else {
+		profile := &models.UserProfile{Email: email, Name: "Alice"}
+		if err := repos.Users.Upsert(ctx, profile); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+		if err := repos.Keys.CreateUserKeyPair(ctx, profile.ID, wk, ck); err != nil {
+			t.Fatalf("CreateUserKeyPair failed: %v", err)
+		}
+	}
+	wk, err := repos.Keys.GetWebhookKeyByHash(ctx, wk.KeyHash)
+	if err != nil {
+		t.Fatalf("GetWebhookKeyByHash failed: %v", err)
+	}
+	for i := 0; i < count; i++ {
+		event := &models.Event{ID: uuid.New(), WebhookKeyID: wk.ID, Path: "note.md", CreatedAt: time.Now().Add(-age), ExpiresAt: time.Now().Add(time.Hour)}
+		if err := repos.Events.Create(ctx, event); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+	return wk
+}
+
+// TestDeliveryAlertService_Check verifies who is told about which keys, and
+// that the cooldown keeps a key that stays stuck from being reported again
+func TestDeliveryAlertService_Check(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.New()
+	createStuckKey(t, repos, "alice@example.com", "wh_alice", 2, 3*time.Hour)
+	createStuckKey(t, repos, "bob@example.com", "wh_bob", 1, 30*time.Hour)
+	createStuckKey(t, repos, "", "wh_legacy", 5, time.Minute)
+	createStuckKey(t, repos, "carol@example.com", "wh_carol", 1, time.Minute)
+	if err := repos.Users.SetDeliveryAlerts(ctx, "bob@example.com", false); err != nil {
+		t.Fatalf("SetDeliveryAlerts failed: %v", err)
+	}
+
+	var posted []deliveryAlertWebhook
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body deliveryAlertWebhook
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid webhook body: %v", err)
+		}
+		posted = append(posted, body)
+	}))
+	defer hook.Close()
+
+	sender := &recordingEmailSender{}
+	svc := NewDeliveryAlertService(repos.Events, repos.Keys, repos.Users, DeliveryAlertConfig{
+		MinAge:      time.Hour,
+		MinCount:    5,
+		BaseURL:     "https://webhooks.example.com/",
+		AdminEmails: []string{"ops@example.com"},
+		WebhookURL:  hook.URL,
+	})
+	svc.SetEmailService(NewEmailService(sender, "noreply@example.com", "Webhooks"))
+
+	report, err := svc.Check(ctx)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if report.Stuck != 3 || report.Alerted != 3 || report.UserEmails != 1 || report.AdminDigests != 2 || len(report.Errors) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// Bob opted out and the legacy key has no owner: only Alice hears about hers
+	alice := sender.to("alice@example.com")
+	if len(alice) != 1 || !strings.Contains(alice[0].Text, "Hi Alice,") || !strings.Contains(alice[0].Text, "wh_alice") ||
+		!strings.Contains(alice[0].Text, "3 hours") || strings.Contains(alice[0].Text, "wh_legacy") ||
+		!strings.Contains(alice[0].Text, "https://webhooks.example.com/dashboard") {
+		t.Errorf("unexpected owner alert %+v", alice)
+	}
+	if len(sender.to("bob@example.com")) != 0 || len(sender.to("carol@example.com")) != 0 {
+		t.Error("expected no alert for an opted-out owner or a key under the thresholds")
+	}
+	digest := sender.to("ops@example.com")
+	if len(digest) != 1 || !strings.Contains(digest[0].Text, "bob@example.com") || !strings.Contains(digest[0].Text, "wh_legacy") ||
+		!strings.Contains(digest[0].HTML, "https://webhooks.example.com/admin") {
+		t.Errorf("unexpected admin digest %+v", digest)
+	}
+	if len(posted) != 1 || posted[0].Type != DeliveryAlertWebhookType || len(posted[0].Deliveries) != 3 || posted[0].Deliveries[0].KeyPrefix != "wh_bob" {
+		t.Errorf("unexpected webhook posts %+v", posted)
+	}
+
+	report, err = svc.Check(ctx)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if report.Stuck != 3 || report.Alerted != 0 || len(sender.to("alice@example.com")) != 1 || len(posted) != 1 {
+		t.Errorf("expected nothing sent within the cooldown, got %+v", report)
+	}
+	if status := svc.Status(); status.LastCheck != report || status.Cooldown != DefaultDeliveryAlertCooldown.String() || !status.Webhook {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+// TestDeliveryAlertService_RetriesUnsent verifies a key is reported again on
+// the next check when no notification about it went out
+func TestDeliveryAlertService_RetriesUnsent(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.New()
+	createStuckKey(t, repos, "alice@example.com", "wh_alice", 1, 2*time.Hour)
+
+	sender := &recordingEmailSender{err: errors.New("mail server down")}
+	svc := NewDeliveryAlertService(repos.Events, repos.Keys, repos.Users, DeliveryAlertConfig{MinAge: time.Hour})
+	svc.SetEmailService(NewEmailService(sender, "noreply@example.com", "Webhooks"))
+
+	report, err := svc.Check(ctx)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if report.Alerted != 0 || len(report.Errors) != 1 {
+		t.Errorf("expected the failed email reported, got %+v", report)
+	}
+
+	sender.err = nil
+	if report, _ = svc.Check(ctx); report.Alerted != 1 || len(sender.to("alice@example.com")) != 1 {
+		t.Errorf("expected the alert sent on the next check, got %+v", report)
+	}
+}
+
+// TestDeliveryAlertService_StopWithoutStart verifies Stop returns when the
+// monitor is disabled
+func TestDeliveryAlertService_StopWithoutStart(t *testing.T) {
+	svc := NewDeliveryAlertService(nil, nil, nil, DeliveryAlertConfig{})
+	svc.Start(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		svc.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop blocked")
+	}
+}
+
+func TestWaitingFor(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		30 * time.Second:           "1 minute",
+		45 * time.Minute:           "45 minutes",
+		90 * time.Minute:           "1 hour",
+		5 * time.Hour:              "5 hours",
+		30 * time.Hour:             "1 day",
+		3*24*time.Hour + time.Hour: "3 days",
+	} {
+		if got := waitingFor(d); got != want {
+			t.Errorf("waitingFor(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
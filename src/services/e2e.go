@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// E2EAlgorithm names the construction used to seal end-to-end encrypted events:
+// libsodium's crypto_box_seal (ephemeral X25519 + XSalsa20-Poly1305), so the
+// plugin can open them with crypto_box_seal_open from any libsodium binding.
+const E2EAlgorithm = "x25519-xsalsa20poly1305-sealedbox"
+
+// E2EUnavailableFeatures lists the server-side features that need to read the
+// payload and are therefore unavailable for end-to-end encrypted keys
+var E2EUnavailableFeatures = []string{"transform_templates", "search"}
+
+// E2EStatus describes a key pair's end-to-end encryption for API responses
+type E2EStatus struct {
+	Enabled             bool     `json:"enabled"`
+	Algorithm           string   `json:"algorithm,omitempty"`
+	PublicKey           string   `json:"public_key,omitempty"`
+	UnavailableFeatures []string `json:"unavailable_features"`
+}
+
+// ParseE2EPublicKey decodes a base64 (standard or URL, padded or not) X25519 public key
+func ParseE2EPublicKey(encoded string) (*[32]byte, error) {
+	var raw []byte
+	var err error
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if raw, err = enc.DecodeString(encoded); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: not valid base64", ErrInvalidPublicKey)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("%w: must be 32 bytes, got %d", ErrInvalidPublicKey, len(raw))
+	}
+
+	var key [32]byte
+	copy(key[:], raw)
+	if key == [32]byte{} {
+		return nil, fmt.Errorf("%w: all-zero key", ErrInvalidPublicKey)
+	}
+	return &key, nil
+}
+
+// SealForPublicKey seals data so that only the holder of the matching private key can open it
+func SealForPublicKey(publicKey *[32]byte, data []byte) ([]byte, error) {
+	sealed, err := box.SealAnonymous(nil, data, publicKey, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal event data: %w", err)
+	}
+	return sealed, nil
+}
+
+// SetE2EPublicKey registers the plugin's public key on its client key so new
+// events for the pair are sealed to it. An empty key turns the mode off.
+func (ks *KeyService) SetE2EPublicKey(ctx context.Context, clientKeyValue, publicKey string) (*E2EStatus, error) {
+	ck, err := ks.keys.GetClientKeyByHash(ctx, ks.hasher.Hash(clientKeyValue))
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get client key: %w", err)
+	}
+
+	var stored *string
+	if publicKey != "" {
+		key, err := ParseE2EPublicKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		canonical := base64.StdEncoding.EncodeToString(key[:])
+		stored = &canonical
+	}
+
+	if err := ks.keys.SetE2EPublicKey(ctx, ck.ID, stored); err != nil {
+		return nil, fmt.Errorf("failed to store public key: %w", err)
+	}
+	return ks.GetE2EStatus(ctx, ck.WebhookKeyID)
+}
+
+// GetE2EStatus reports whether events for a webhook key are sealed
+func (ks *KeyService) GetE2EStatus(ctx context.Context, webhookKeyID uuid.UUID) (*E2EStatus, error) {
+	status := &E2EStatus{UnavailableFeatures: []string{}}
+	publicKey, err := ks.keys.GetE2EPublicKey(ctx, webhookKeyID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	status.Enabled = true
+	status.Algorithm = E2EAlgorithm
+	status.PublicKey = publicKey
+	status.UnavailableFeatures = E2EUnavailableFeatures
+	return status, nil
+}
+
+// E2EPublicKey returns the key events for a webhook key must be sealed to,
+// or nil when end-to-end encryption is off for the pair
+func (ks *KeyService) E2EPublicKey(ctx context.Context, webhookKeyID uuid.UUID) (*[32]byte, error) {
+	encoded, err := ks.keys.GetE2EPublicKey(ctx, webhookKeyID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+	return ParseE2EPublicKey(encoded)
+}
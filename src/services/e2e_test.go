@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestParseE2EPublicKey(t *testing.T) {
+	publicKey, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	for _, encoded := range []string{
+		base64.StdEncoding.EncodeToString(publicKey[:]),
+		base64.RawURLEncoding.EncodeToString(publicKey[:]),
+	} {
+		parsed, err := ParseE2EPublicKey(encoded)
+		if err != nil || *parsed != *publicKey {
+			t.Errorf("ParseE2EPublicKey(%q) = %v, %v", encoded, parsed, err)
+		}
+	}
+
+	for _, encoded := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short")), base64.StdEncoding.EncodeToString(make([]byte, 32))} {
+		if _, err := ParseE2EPublicKey(encoded); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("Expected ErrInvalidPublicKey for %q, got %v", encoded, err)
+		}
+	}
+}
+
+// TestE2E_SealsNewEvents verifies events are sealed to the registered key and
+// only the private key holder can read them
+func TestE2E_SealsNewEvents(t *testing.T) {
+	ks, ts := newTestKeyService(t)
+	ctx := context.Background()
+
+	webhookKeyID, _, _, clientKey, err := ts.CreateTestKeyPair(0, "")
+	if err != nil {
+		t.Fatalf("CreateTestKeyPair failed: %v", err)
+	}
+	whID := uuid.MustParse(webhookKeyID)
+
+	encryptor, _ := NewEncryptor(validHexKey())
+	es := NewEventServiceWithEncryption(ts.Repos.Events, encryptor)
+	es.SetE2EKeyLookup(ks)
+
+	plain, err := es.CreateEvent(ctx, whID, "before.md", []byte("readable"), time.Hour)
+	if err != nil || plain.Sealed {
+		t.Fatalf("Expected an unsealed event before a key is registered, got %+v, %v", plain, err)
+	}
+
+	publicKey, privateKey, _ := box.GenerateKey(rand.Reader)
+	status, err := ks.SetE2EPublicKey(ctx, clientKey, base64.StdEncoding.EncodeToString(publicKey[:]))
+	if err != nil {
+		t.Fatalf("SetE2EPublicKey failed: %v", err)
+	}
+	if !status.Enabled || status.Algorithm != E2EAlgorithm || len(status.UnavailableFeatures) == 0 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	event, err := es.CreateEvent(ctx, whID, "secret.md", []byte("for the plugin only"), time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	if !event.Sealed {
+		t.Fatal("Expected the event to be sealed")
+	}
+
+	stored, err := es.GetEventByID(ctx, event.ID)
+	if err != nil {
+		t.Fatalf("GetEventByID failed: %v", err)
+	}
+	opened, ok := box.OpenAnonymous(nil, stored.Data, publicKey, privateKey)
+	if !ok || string(opened) != "for the plugin only" {
+		t.Fatalf("Expected the plugin to open the sealed box, got %q (ok=%v)", opened, ok)
+	}
+
+	// Turning the mode off only affects new events
+	if status, err = ks.SetE2EPublicKey(ctx, clientKey, ""); err != nil || status.Enabled {
+		t.Fatalf("Expected E2E to be disabled, got %+v, %v", status, err)
+	}
+	after, _ := es.CreateEvent(ctx, whID, "after.md", []byte("readable"), time.Hour)
+	if after.Sealed {
+		t.Error("Expected new events to be unsealed after the key is removed")
+	}
+
+	if _, err := ks.SetE2EPublicKey(ctx, clientKey, "bogus"); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Errorf("Expected ErrInvalidPublicKey, got %v", err)
+	}
+	if _, err := ks.SetE2EPublicKey(ctx, "unknown", ""); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
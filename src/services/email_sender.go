@@ -0,0 +1,196 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// EmailMessage is a rendered email with plain text and HTML alternatives
+type EmailMessage struct {
+	From    string // "Name <address>"
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// EmailSender delivers rendered emails
+type EmailSender interface {
+	Send(ctx context.Context, msg *EmailMessage) error
+}
+
+// Email transports
+const (
+	EmailTransportMailgun = "mailgun"
+	EmailTransportSMTP    = "smtp"
+	EmailTransportFile    = "file"
+	EmailTransportLog     = "log"
+)
+
+// EmailSenderConfig selects and configures an email transport
+type EmailSenderConfig struct {
+	Transport string // mailgun, smtp, file or log; empty disables email
+
+	MailgunDomain string
+	MailgunAPIKey string
+
+	SMTP SMTPConfig
+
+	Dir string // directory the file transport writes .eml files to
+}
+
+// NewEmailSender creates the configured transport. Returns nil if no
+// transport is configured.
+func NewEmailSender(cfg EmailSenderConfig) (EmailSender, error) {
+	switch cfg.Transport {
+	case "":
+		return nil, nil
+	case EmailTransportMailgun:
+		if cfg.MailgunDomain == "" || cfg.MailgunAPIKey == "" {
+			return nil, fmt.Errorf("mailgun transport needs MAILGUN_DOMAIN and MAILGUN_API_KEY")
+		}
+		return NewMailgunSender(cfg.MailgunDomain, cfg.MailgunAPIKey), nil
+	case EmailTransportSMTP:
+		return NewSMTPSender(cfg.SMTP)
+	case EmailTransportFile:
+		return NewFileSender(cfg.Dir)
+	case EmailTransportLog:
+		return LogSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown email transport %q (use mailgun, smtp, file or log)", cfg.Transport)
+	}
+}
+
+// MailgunSender sends email through Mailgun's EU API
+type MailgunSender struct {
+	mg *mailgun.MailgunImpl
+}
+
+// NewMailgunSender creates a Mailgun transport for domain
+func NewMailgunSender(domain, apiKey string) *MailgunSender {
+	mg := mailgun.NewMailgun(domain, apiKey)
+	mg.SetAPIBase(mailgun.APIBaseEU) // Use EU endpoint for GDPR compliance
+	return &MailgunSender{mg: mg}
+}
+
+// Send sends msg through the Mailgun API
+func (s *MailgunSender) Send(ctx context.Context, msg *EmailMessage) error {
+	message := s.mg.NewMessage(msg.From, msg.Subject, msg.Text, msg.To)
+	message.SetHtml(msg.HTML)
+	_, _, err := s.mg.Send(ctx, message)
+	return err
+}
+
+// FileSender writes every email as an .eml file, for development and for
+// checking what would be sent
+type FileSender struct {
+	dir string
+}
+
+// NewFileSender creates a file transport writing to dir, creating it if needed
+func NewFileSender(dir string) (*FileSender, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file transport needs a directory (EMAIL_FILE_DIR)")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create email directory: %w", err)
+	}
+	return &FileSender{dir: dir}, nil
+}
+
+// Send writes msg to a new file named after the time and a random suffix
+func (s *FileSender) Send(ctx context.Context, msg *EmailMessage) error {
+	data, err := buildMIMEMessage(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := time.Now().UTC().Format("20060102-150405.000000") + "-" + hex.EncodeToString(suffix) + ".eml"
+	return os.WriteFile(filepath.Join(s.dir, name), data, 0o600)
+}
+
+// LogSender logs each email's recipient, subject and plain text, magic
+// links included. Only for development: anyone reading the logs can sign in.
+type LogSender struct{}
+
+// Send logs msg
+func (LogSender) Send(ctx context.Context, msg *EmailMessage) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+// buildMIMEMessage renders msg as a multipart/alternative RFC 5322 message
+func buildMIMEMessage(msg *EmailMessage, date time.Time) ([]byte, error) {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", msg.From, err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+	header := func(name, value string) {
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", "<"+hex.EncodeToString(id)+"@"+domain+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", `multipart/alternative; boundary="`+body.Boundary()+`"`)
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
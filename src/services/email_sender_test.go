@@ -0,0 +1,275 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEmailMessage() *EmailMessage {
+	return &EmailMessage{
+		From:    "Obsidian Webhooks <noreply@example.com>",
+		To:      "alice@example.com",
+		Subject: "Your login link — Obsidian Webhooks",
+		Text:    "Sign in: http://localhost/auth/verify?token=abc",
+		HTML:    `<a href="http://localhost/auth/verify?token=abc">Sign in</a>`,
+	}
+}
+
+// parseMIMEMessage parses a message built by buildMIMEMessage and returns its
+// decoded subject and parts by content type
+func parseMIMEMessage(t *testing.T, data []byte) (*mail.Message, string, map[string]string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("failed to decode subject: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected multipart/alternative, got %q (%v)", mediaType, err)
+	}
+	parts := make(map[string]string)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatalf("failed to decode part: %v", err)
+		}
+		parts[part.Header.Get("Content-Type")] = string(body)
+	}
+	return msg, subject, parts
+}
+
+func TestBuildMIMEMessage(t *testing.T) {
+	data, err := buildMIMEMessage(testEmailMessage(), time.Now())
+	if err != nil {
+		t.Fatalf("buildMIMEMessage failed: %v", err)
+	}
+	msg, subject, parts := parseMIMEMessage(t, data)
+
+	if subject != "Your login link — Obsidian Webhooks" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	if to := msg.Header.Get("To"); to != "<alice@example.com>" {
+		t.Errorf("unexpected To %q", to)
+	}
+	if id := msg.Header.Get("Message-ID"); !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("expected a Message-ID on the sender's domain, got %q", id)
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		t.Errorf("invalid Date header: %v", err)
+	}
+	if parts["text/plain; charset=utf-8"] != testEmailMessage().Text || parts["text/html; charset=utf-8"] != testEmailMessage().HTML {
+		t.Errorf("unexpected parts: %v", parts)
+	}
+
+	bad := testEmailMessage()
+	bad.To = "not an address"
+	if _, err := buildMIMEMessage(bad, time.Now()); err == nil {
+		t.Error("expected an invalid recipient to be rejected")
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	sender, err := NewEmailSender(EmailSenderConfig{Transport: EmailTransportFile, Dir: dir})
+	if err != nil {
+		t.Fatalf("NewEmailSender failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sender.Send(context.Background(), testEmailMessage()); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("expected 2 .eml files, got %v (%v)", files, err)
+	}
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(files[0])
+	if _, _, parts := parseMIMEMessage(t, data); !strings.Contains(parts["text/plain; charset=utf-8"], "token=abc") {
+		t.Errorf("expected the magic link in the message, got %v", parts)
+	}
+}
+
+func TestNewEmailSender(t *testing.T) {
+	if sender, err := NewEmailSender(EmailSenderConfig{}); sender != nil || err != nil {
+		t.Errorf("expected no transport by default, got %v (%v)", sender, err)
+	}
+	for name, cfg := range map[string]EmailSenderConfig{
+		"unknown transport":   {Transport: "pigeon"},
+		"mailgun without key": {Transport: EmailTransportMailgun, MailgunDomain: "mg.example.com"},
+		"smtp without host":   {Transport: EmailTransportSMTP},
+		"unknown smtp tls":    {Transport: EmailTransportSMTP, SMTP: SMTPConfig{Host: "mail.example.com", TLS: "ssl"}},
+		"smtp login in clear": {Transport: EmailTransportSMTP, SMTP: SMTPConfig{Host: "relay.internal", TLS: SMTPTLSNone, Username: "mailer"}},
+		"file without dir":    {Transport: EmailTransportFile},
+	} {
+		if _, err := NewEmailSender(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	sender, err := NewSMTPSender(SMTPConfig{Host: "mail.example.com", Port: 465})
+	if err != nil || sender.cfg.TLS != SMTPTLSImplicit {
+		t.Errorf("expected implicit TLS on port 465, got %+v (%v)", sender, err)
+	}
+	sender, err = NewSMTPSender(SMTPConfig{Host: "mail.example.com"})
+	if err != nil || sender.cfg.TLS != SMTPTLSStartTLS || sender.cfg.Port != 587 {
+		t.Errorf("expected STARTTLS on port 587 by default, got %+v (%v)", sender, err)
+	}
+	if _, err := NewSMTPSender(SMTPConfig{Host: "relay.internal", TLS: SMTPTLSNone}); err != nil {
+		t.Errorf("expected an unencrypted relay without login to be accepted, got %v", err)
+	}
+	if _, err := NewSMTPSender(SMTPConfig{Host: "localhost", TLS: SMTPTLSNone, Username: "mailer"}); err != nil {
+		t.Errorf("expected a login to a relay on localhost to be accepted, got %v", err)
+	}
+}
+
+// fakeSMTPServer accepts one SMTP session on localhost and records it.
+// Without STARTTLS it can only test plain sessions: net/smtp sends PLAIN
+// credentials unencrypted to localhost only.
+type fakeSMTPServer struct {
+	addr     string
+	auth     chan string // decoded AUTH PLAIN credentials
+	envelope chan []string
+	data     chan []byte
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeSMTPServer{addr: ln.Addr().String(), auth: make(chan string, 1), envelope: make(chan []string, 1), data: make(chan []byte, 1)}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		var envelope []string
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch cmd {
+			case "EHLO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				fields := strings.Fields(line)
+				creds, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+				s.auth <- string(creds)
+				reply("235 2.7.0 Authentication successful")
+			case "MAIL", "RCPT":
+				envelope = append(envelope, line)
+				reply("250 OK")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var buf bytes.Buffer
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					buf.WriteString(strings.TrimPrefix(l, "."))
+				}
+				s.envelope <- envelope
+				s.data <- buf.Bytes()
+				reply("250 OK queued")
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) config(t *testing.T) SMTPConfig {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(s.addr)
+	p, _ := strconv.Atoi(port)
+	return SMTPConfig{Host: host, Port: p}
+}
+
+func TestSMTPSender_SendsWithAuth(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	cfg := server.config(t)
+	cfg.TLS, cfg.Username, cfg.Password = SMTPTLSNone, "mailer", "s3cret"
+	sender, err := NewSMTPSender(cfg)
+	if err != nil {
+		t.Fatalf("NewSMTPSender failed: %v", err)
+	}
+	if err := sender.Send(context.Background(), testEmailMessage()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if creds := <-server.auth; creds != "\x00mailer\x00s3cret" {
+		t.Errorf("unexpected credentials %q", creds)
+	}
+	envelope := <-server.envelope
+	if len(envelope) != 2 || envelope[0] != "MAIL FROM:<noreply@example.com>" || envelope[1] != "RCPT TO:<alice@example.com>" {
+		t.Errorf("unexpected envelope %v", envelope)
+	}
+	if _, subject, parts := parseMIMEMessage(t, <-server.data); subject != testEmailMessage().Subject || parts["text/plain; charset=utf-8"] != testEmailMessage().Text {
+		t.Errorf("unexpected message: %q %v", subject, parts)
+	}
+}
+
+func TestSMTPSender_RequiresStartTLS(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	sender, err := NewSMTPSender(server.config(t))
+	if err != nil {
+		t.Fatalf("NewSMTPSender failed: %v", err)
+	}
+	err = sender.Send(context.Background(), testEmailMessage())
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("expected a refusal to send without STARTTLS, got %v", err)
+	}
+}
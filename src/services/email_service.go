@@ -6,30 +6,39 @@ import (
 	"time"
 
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/templates"
-	"github.com/mailgun/mailgun-go/v4"
 )
 
-// EmailService handles transactional email sending via Mailgun
+// EmailService renders transactional emails and hands them to a transport
 type EmailService struct {
-	mg        *mailgun.MailgunImpl
+	sender    EmailSender
 	fromEmail string
 	fromName  string
-	domain    string
 }
 
-// NewEmailService creates a new email service with Mailgun configuration
-func NewEmailService(domain, apiKey, fromEmail, fromName string) *EmailService {
-	mg := mailgun.NewMailgun(domain, apiKey)
-	mg.SetAPIBase(mailgun.APIBaseEU) // Use EU endpoint for GDPR compliance
-
+// NewEmailService creates a new email service sending through sender
+func NewEmailService(sender EmailSender, fromEmail, fromName string) *EmailService {
 	return &EmailService{
-		mg:        mg,
+		sender:    sender,
 		fromEmail: fromEmail,
 		fromName:  fromName,
-		domain:    domain,
 	}
 }
 
+// send delivers a rendered email from the configured sender address
+func (s *EmailService) send(ctx context.Context, toEmail, subject, textBody, htmlBody string) error {
+	// Set timeout for sending
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	return s.sender.Send(ctxWithTimeout, &EmailMessage{
+		From:    fmt.Sprintf("%s <%s>", s.fromName, s.fromEmail),
+		To:      toEmail,
+		Subject: subject,
+		Text:    textBody,
+		HTML:    htmlBody,
+	})
+}
+
 // getDefaultEmailConfig returns default email configuration as fallback
 func getDefaultEmailConfig() *templates.EmailConfig {
 	return &templates.EmailConfig{
@@ -74,11 +83,33 @@ func getDefaultEmailConfig() *templates.EmailConfig {
 			Welcome         string `yaml:"welcome"`
 			PasswordReset   string `yaml:"password_reset"`
 			AccountVerified string `yaml:"account_verified"`
+			DeliveryAlert   string `yaml:"delivery_alert"`
+			DeliveryDigest  string `yaml:"delivery_digest"`
 		}{
 			MagicLink:       "Your login link — Obsidian Webhooks",
 			Welcome:         "Your API keys are ready — Obsidian Webhooks",
 			PasswordReset:   "Reset your password",
 			AccountVerified: "Your account is verified",
+			DeliveryAlert:   "Webhooks are waiting for your vault — Obsidian Webhooks",
+			DeliveryDigest:  "Undelivered webhooks — Obsidian Webhooks",
+		},
+		DeliveryAlert: struct {
+			Greeting         string `yaml:"greeting"`
+			Intro            string `yaml:"intro"`
+			ButtonText       string `yaml:"button_text"`
+			RetentionNote    string `yaml:"retention_note"`
+			OptOutText       string `yaml:"opt_out_text"`
+			DigestGreeting   string `yaml:"digest_greeting"`
+			DigestIntro      string `yaml:"digest_intro"`
+			DigestButtonText string `yaml:"digest_button_text"`
+		}{
+			Intro:            "Events sent to the webhook keys below have not reached Obsidian yet. Open Obsidian with the plugin enabled to receive them.",
+			ButtonText:       "Open Dashboard",
+			RetentionNote:    "Undelivered events are deleted when their retention period ends.",
+			OptOutText:       "Don't want these emails? Turn off delivery alerts in your dashboard.",
+			DigestGreeting:   "Stuck deliveries",
+			DigestIntro:      "These webhook keys have events no client has fetched.",
+			DigestButtonText: "Open Admin Panel",
 		},
 	}
 }
@@ -134,20 +165,7 @@ func (s *EmailService) SendMagicLinkEmail(ctx context.Context, toEmail, toName,
 		textBody = s.getMagicLinkPlainTextTemplate(toName, magicLink, expiryMinutes)
 	}
 
-	message := s.mg.NewMessage(
-		fmt.Sprintf("%s <%s>", s.fromName, s.fromEmail),
-		subject,
-		textBody,
-		toEmail,
-	)
-	message.SetHtml(htmlBody)
-
-	// Set timeout for sending
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, time.Second*30)
-	defer cancel()
-
-	_, _, err = s.mg.Send(ctxWithTimeout, message)
-	if err != nil {
+	if err := s.send(ctx, toEmail, subject, textBody, htmlBody); err != nil {
 		return fmt.Errorf("failed to send magic link email to %s: %w", toEmail, err)
 	}
 
@@ -204,26 +222,89 @@ func (s *EmailService) SendWelcomeEmail(ctx context.Context, toEmail, toName, la
 		textBody = s.getWelcomePlainTextTemplate(toName)
 	}
 
-	message := s.mg.NewMessage(
-		fmt.Sprintf("%s <%s>", s.fromName, s.fromEmail),
-		subject,
-		textBody,
-		toEmail,
-	)
-	message.SetHtml(htmlBody)
+	if err := s.send(ctx, toEmail, subject, textBody, htmlBody); err != nil {
+		return fmt.Errorf("failed to send welcome email to %s: %w", toEmail, err)
+	}
 
-	// Set timeout for sending
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, time.Second*30)
-	defer cancel()
+	return nil
+}
 
-	_, _, err = s.mg.Send(ctxWithTimeout, message)
+// SendDeliveryAlertEmail tells a user that events for their webhook keys
+// are not reaching Obsidian (always English)
+func (s *EmailService) SendDeliveryAlertEmail(ctx context.Context, toEmail, toName, dashboardURL string, keys []templates.DeliveryAlertKey) error {
+	config, err := templates.LoadEmailConfig("en")
 	if err != nil {
-		return fmt.Errorf("failed to send welcome email to %s: %w", toEmail, err)
+		config = getDefaultEmailConfig()
 	}
 
+	displayName := toName
+	if displayName == "" {
+		displayName = "there"
+	}
+
+	data := deliveryAlertData(config, keys, dashboardURL)
+	data.Greeting = fmt.Sprintf("Hi %s,", displayName)
+	data.Intro = config.DeliveryAlert.Intro
+	data.ButtonText = config.DeliveryAlert.ButtonText
+	data.OptOutText = config.DeliveryAlert.OptOutText
+
+	if err := s.sendDeliveryAlert(ctx, toEmail, config.Subjects.DeliveryAlert, data); err != nil {
+		return fmt.Errorf("failed to send delivery alert email to %s: %w", toEmail, err)
+	}
 	return nil
 }
 
+// SendDeliveryDigestEmail sends an admin the stuck deliveries across all
+// users' keys (always English)
+func (s *EmailService) SendDeliveryDigestEmail(ctx context.Context, toEmail, adminURL string, keys []templates.DeliveryAlertKey) error {
+	config, err := templates.LoadEmailConfig("en")
+	if err != nil {
+		config = getDefaultEmailConfig()
+	}
+
+	data := deliveryAlertData(config, keys, adminURL)
+	data.Greeting = config.DeliveryAlert.DigestGreeting
+	data.Intro = config.DeliveryAlert.DigestIntro
+	data.ButtonText = config.DeliveryAlert.DigestButtonText
+
+	if err := s.sendDeliveryAlert(ctx, toEmail, config.Subjects.DeliveryDigest, data); err != nil {
+		return fmt.Errorf("failed to send delivery digest email to %s: %w", toEmail, err)
+	}
+	return nil
+}
+
+// deliveryAlertData fills the parts of a stuck delivery email shared by the
+// user alert and the admin digest
+func deliveryAlertData(config *templates.EmailConfig, keys []templates.DeliveryAlertKey, buttonURL string) templates.DeliveryAlertData {
+	return templates.DeliveryAlertData{
+		Keys:          keys,
+		ButtonURL:     buttonURL,
+		BrandName:     config.Branding.Name,
+		Tagline:       config.Branding.Tagline,
+		Website:       config.Branding.Website,
+		RetentionNote: config.DeliveryAlert.RetentionNote,
+		PrimaryColor:  config.Design.PrimaryColor,
+		TextColor:     config.Design.TextColor,
+		MutedColor:    config.Design.MutedColor,
+		LightBg:       config.Design.LightBg,
+		BorderColor:   config.Design.BorderColor,
+	}
+}
+
+// sendDeliveryAlert renders and sends a stuck delivery email. There is no
+// hardcoded fallback: a template that fails to render is a build error.
+func (s *EmailService) sendDeliveryAlert(ctx context.Context, toEmail, subject string, data templates.DeliveryAlertData) error {
+	htmlBody, err := templates.RenderDeliveryAlertHTML(data)
+	if err != nil {
+		return err
+	}
+	textBody, err := templates.RenderDeliveryAlertText(data)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, toEmail, subject, textBody, htmlBody)
+}
+
 // getMagicLinkHTMLTemplate returns the HTML template for magic link email
 func (s *EmailService) getMagicLinkHTMLTemplate(name, magicLink string, expiryMinutes int) string {
 	displayName := name
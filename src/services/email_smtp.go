@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTP connection security
+const (
+	SMTPTLSStartTLS = "starttls" // upgrade a plain connection; the server must offer it
+	SMTPTLSImplicit = "tls"      // TLS from the first byte, usually port 465
+	SMTPTLSNone     = "none"     // plain text, for a relay on localhost or a private network
+)
+
+// smtpTimeout bounds a whole SMTP exchange when the context has no deadline
+const smtpTimeout = 30 * time.Second
+
+// SMTPConfig configures the SMTP transport
+type SMTPConfig struct {
+	Host     string
+	Port     int    // defaults to 465 for implicit TLS, otherwise 587
+	Username string // empty = no authentication
+	Password string
+	TLS      string // starttls, tls or none; defaults to tls on port 465, otherwise starttls
+
+	// TLSConfig overrides the TLS settings, such as trusted roots in tests
+	TLSConfig *tls.Config
+}
+
+// SMTPSender sends email through an SMTP server
+type SMTPSender struct {
+	cfg SMTPConfig
+}
+
+// isLocalSMTPHost reports whether net/smtp's PlainAuth accepts host without TLS
+func isLocalSMTPHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// NewSMTPSender creates an SMTP transport, filling in the default port and
+// connection security
+func NewSMTPSender(cfg SMTPConfig) (*SMTPSender, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("smtp transport needs SMTP_HOST")
+	}
+	if cfg.TLS == "" {
+		cfg.TLS = SMTPTLSStartTLS
+		if cfg.Port == 465 {
+			cfg.TLS = SMTPTLSImplicit
+		}
+	}
+	if cfg.TLS != SMTPTLSStartTLS && cfg.TLS != SMTPTLSImplicit && cfg.TLS != SMTPTLSNone {
+		return nil, fmt.Errorf("unknown SMTP_TLS %q (use starttls, tls or none)", cfg.TLS)
+	}
+	// net/smtp only sends credentials unencrypted to localhost
+	if cfg.TLS == SMTPTLSNone && cfg.Username != "" && !isLocalSMTPHost(cfg.Host) {
+		return nil, fmt.Errorf("SMTP_USERNAME needs SMTP_TLS=starttls or tls unless SMTP_HOST is localhost")
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+		if cfg.TLS == SMTPTLSImplicit {
+			cfg.Port = 465
+		}
+	}
+	if cfg.TLSConfig == nil {
+		cfg.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	cfg.TLSConfig = cfg.TLSConfig.Clone()
+	if cfg.TLSConfig.ServerName == "" {
+		cfg.TLSConfig.ServerName = cfg.Host
+	}
+	return &SMTPSender{cfg: cfg}, nil
+}
+
+// Send delivers msg over a new connection to the server
+func (s *SMTPSender) Send(ctx context.Context, msg *EmailMessage) error {
+	data, err := buildMIMEMessage(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	from, _ := mail.ParseAddress(msg.From) // checked by buildMIMEMessage
+	to, _ := mail.ParseAddress(msg.To)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	dialer := &net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	if s.cfg.TLS == SMTPTLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.cfg.TLSConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer c.Close()
+
+	if s.cfg.TLS == SMTPTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server does not offer STARTTLS (set SMTP_TLS=none to send unencrypted)")
+		}
+		if err := c.StartTLS(s.cfg.TLSConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if s.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %w", err)
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("SMTP RCPT TO failed: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected the message: %w", err)
+	}
+	return c.Quit()
+}
@@ -19,6 +19,72 @@ var (
 	// ErrInvalidCredentials indicates authentication failed
 	ErrInvalidCredentials = errors.New("invalid credentials")
 
+	// ErrInvalidRetention indicates a retention period outside the allowed range
+	ErrInvalidRetention = errors.New("invalid retention period")
+
+	// ErrInvalidOverlap indicates a key rotation overlap outside the allowed range
+	ErrInvalidOverlap = errors.New("invalid rotation overlap")
+
+	// ErrInvalidPublicKey indicates an end-to-end encryption public key that is not a valid X25519 key
+	ErrInvalidPublicKey = errors.New("invalid public key")
+
+	// ErrInvalidAPIToken indicates a personal access token that is unknown, revoked or expired
+	ErrInvalidAPIToken = errors.New("invalid api token")
+
+	// ErrAPITokenNotFound indicates the user has no such active token
+	ErrAPITokenNotFound = errors.New("api token not found")
+
+	// ErrInvalidScope indicates a token request with a missing or unknown scope
+	ErrInvalidScope = errors.New("invalid scope")
+
+	// ErrInvalidTokenRequest indicates a token name or expiry outside the allowed range
+	ErrInvalidTokenRequest = errors.New("invalid token request")
+
+	// ErrSessionInvalid indicates a session that is unknown, revoked or expired
+	ErrSessionInvalid = errors.New("invalid session")
+
+	// ErrSessionNotFound indicates the user has no such active session
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrInvalidWebhookKey indicates a webhook key name or setting outside the allowed range
+	ErrInvalidWebhookKey = errors.New("invalid webhook key settings")
+
+	// ErrInvalidTOTPCode indicates a two-factor code or recovery code that does not match
+	ErrInvalidTOTPCode = errors.New("invalid two-factor code")
+
+	// ErrTOTPAlreadyEnabled indicates an enrollment for an admin who already uses two-factor authentication
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication already enabled")
+
+	// ErrTOTPNotEnrolled indicates a two-factor operation for an admin without it, or without an enrollment to confirm
+	ErrTOTPNotEnrolled = errors.New("two-factor authentication not enrolled")
+
+	// ErrTOTPRequired indicates an attempt to turn off two-factor authentication while the server requires it
+	ErrTOTPRequired = errors.New("two-factor authentication is required")
+
+	// ErrAdminNotFound indicates the admin user does not exist
+	ErrAdminNotFound = errors.New("admin user not found")
+
+	// ErrAdminExists indicates an invite for a username that is already taken
+	ErrAdminExists = errors.New("admin user already exists")
+
+	// ErrInvalidAdminUser indicates an admin username, password or role outside the allowed values
+	ErrInvalidAdminUser = errors.New("invalid admin user")
+
+	// ErrInvalidInvite indicates an admin invite token that is unknown, used or expired
+	ErrInvalidInvite = errors.New("invalid or expired invite")
+
+	// ErrLastOwner indicates a change that would leave no active owner
+	ErrLastOwner = errors.New("cannot remove the last active owner")
+
+	// ErrAdminSelfChange indicates an admin changing their own role or deactivating themselves
+	ErrAdminSelfChange = errors.New("admins cannot change their own role or deactivate themselves")
+
+	// ErrSSOStateInvalid indicates a single sign-on callback that doesn't match a sign-in started here, or came too late
+	ErrSSOStateInvalid = errors.New("sign-in expired or was not started here")
+
+	// ErrSSOEmailNotAllowed indicates an identity provider answer without a verified email, or with one at a domain not allowed
+	ErrSSOEmailNotAllowed = errors.New("email not allowed for single sign-on")
+
 	// ErrTransactionFailed indicates a database transaction failed
 	ErrTransactionFailed = errors.New("transaction failed")
 )
@@ -2,90 +2,205 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/blobstore"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
 )
 
+// E2EKeyLookup finds the public key a webhook key's events are sealed to;
+// it returns nil when end-to-end encryption is off. KeyService implements it.
+type E2EKeyLookup interface {
+	E2EPublicKey(ctx context.Context, webhookKeyID uuid.UUID) (*[32]byte, error)
+}
+
 // EventService handles event-related operations
 type EventService struct {
-	pool      *pgxpool.Pool
 	repo      repositories.EventRepository
 	encryptor *Encryptor
+	dataKeys  *DataKeyService
+	e2eKeys   E2EKeyLookup
+
+	blobs         blobstore.Store
+	blobThreshold int
+	compress      bool
 }
 
 // NewEventService creates a new event service
-func NewEventService(pool *pgxpool.Pool) *EventService {
-	return &EventService{pool: pool}
+func NewEventService(repo repositories.EventRepository) *EventService {
+	return &EventService{repo: repo}
 }
 
 // NewEventServiceWithEncryption creates a new event service with encryption
-func NewEventServiceWithEncryption(pool *pgxpool.Pool, encryptor *Encryptor) *EventService {
-	return &EventService{pool: pool, encryptor: encryptor}
+func NewEventServiceWithEncryption(repo repositories.EventRepository, encryptor *Encryptor) *EventService {
+	return &EventService{repo: repo, encryptor: encryptor}
 }
 
-// NewEventServiceWithRepo creates a new event service with repository (for testing)
-func NewEventServiceWithRepo(repo repositories.EventRepository) *EventService {
-	return &EventService{repo: repo}
+// SetE2EKeyLookup enables sealing new events for keys with end-to-end encryption
+func (es *EventService) SetE2EKeyLookup(lookup E2EKeyLookup) {
+	es.e2eKeys = lookup
+}
+
+// SetDataKeys switches to envelope encryption: new events are encrypted with
+// their key pair's data key instead of the master key
+func (es *EventService) SetDataKeys(dataKeys *DataKeyService) {
+	es.dataKeys = dataKeys
 }
 
-// decryptEventData decrypts the Data field of an event in-place
-func (es *EventService) decryptEventData(event *models.Event) error {
-	decrypted, err := es.encryptor.Decrypt(event.Data)
+// SetCompression turns zstd compression of new payloads on or off. Stored
+// payloads are decompressed on read either way.
+func (es *EventService) SetCompression(enabled bool) {
+	es.compress = enabled
+}
+
+// encryptData encrypts data for storage with the pair's data key when
+// envelope encryption is on, otherwise with the master keyring
+func (es *EventService) encryptData(ctx context.Context, webhookKeyID uuid.UUID, data []byte) ([]byte, error) {
+	if es.dataKeys != nil {
+		return es.dataKeys.Encrypt(ctx, webhookKeyID, data)
+	}
+	return es.encryptor.Encrypt(data)
+}
+
+// decryptData decrypts stored data for a key pair
+func (es *EventService) decryptData(ctx context.Context, webhookKeyID uuid.UUID, data []byte) ([]byte, error) {
+	if es.dataKeys != nil {
+		return es.dataKeys.Decrypt(ctx, webhookKeyID, data)
+	}
+	return es.encryptor.Decrypt(data)
+}
+
+// decryptEventData decrypts the Data field of an event in-place, loading
+// offloaded payloads from the blob store
+func (es *EventService) decryptEventData(ctx context.Context, event *models.Event) error {
+	if event.BlobRef != "" {
+		rc, err := es.openBlob(ctx, event)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if event.Data, err = io.ReadAll(rc); err != nil {
+			return fmt.Errorf("event %s: %w", event.ID, err)
+		}
+		return nil
+	}
+
+	decrypted, err := es.decryptData(ctx, event.WebhookKeyID, event.Data)
 	if err != nil {
-		return err
+		return fmt.Errorf("event %s: %w", event.ID, err)
 	}
 	event.Data = decrypted
+	if !event.Sealed {
+		if event.Data, err = decompressPayload(decrypted); err != nil {
+			return fmt.Errorf("event %s: %w", event.ID, err)
+		}
+	}
 	return nil
 }
 
+// decryptEvents decrypts the Data field of every event in-place
+func (es *EventService) decryptEvents(ctx context.Context, events []models.Event) ([]models.Event, error) {
+	for i := range events {
+		if err := es.decryptEventData(ctx, &events[i]); err != nil {
+			return nil, fmt.Errorf("failed to decrypt event data: %w", err)
+		}
+	}
+	return events, nil
+}
+
 // CreateEvent creates a new webhook event
 func (es *EventService) CreateEvent(ctx context.Context, webhookKeyID uuid.UUID, path string, data []byte, ttl time.Duration) (*models.Event, error) {
-	eventID := uuid.New()
+	return es.createEvent(ctx, webhookKeyID, path, data, ttl, nil, "")
+}
+
+// CreateAppendEvent creates a webhook event the plugin always appends to its
+// note, whatever the plugin's configured write mode
+func (es *EventService) CreateAppendEvent(ctx context.Context, webhookKeyID uuid.UUID, path string, data []byte, ttl time.Duration) (*models.Event, error) {
+	return es.createEvent(ctx, webhookKeyID, path, data, ttl, nil, models.WriteModeAppend)
+}
+
+// CreateScheduledEvent creates a webhook event that is held back until deliverAfter.
+// The TTL is counted from the delivery time, not from creation.
+func (es *EventService) CreateScheduledEvent(ctx context.Context, webhookKeyID uuid.UUID, path string, data []byte, ttl time.Duration, deliverAfter time.Time) (*models.Event, error) {
+	return es.createEvent(ctx, webhookKeyID, path, data, ttl, &deliverAfter, "")
+}
+
+// createEvent stores an event, optionally scheduled for later delivery or
+// with a write mode that overrides the plugin's
+func (es *EventService) createEvent(ctx context.Context, webhookKeyID uuid.UUID, path string, data []byte, ttl time.Duration, deliverAfter *time.Time, mode models.WriteMode) (*models.Event, error) {
 	now := time.Now()
 	expiresAt := now.Add(ttl)
+	if deliverAfter != nil && deliverAfter.After(now) {
+		expiresAt = deliverAfter.Add(ttl)
+	}
 
-	// Encrypt data before storage
-	storageData, err := es.encryptor.Encrypt(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt event data: %w", err)
+	payloadSize := int64(len(data))
+
+	// Seal to the client's public key first so the server keeps no readable copy.
+	// A failed lookup must not fall back to storing plaintext.
+	sealed := false
+	if es.e2eKeys != nil {
+		publicKey, err := es.e2eKeys.E2EPublicKey(ctx, webhookKeyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve end-to-end key: %w", err)
+		}
+		if publicKey != nil {
+			if data, err = SealForPublicKey(publicKey, data); err != nil {
+				return nil, err
+			}
+			sealed = true
+		}
 	}
 
 	event := &models.Event{
-		ID:           eventID,
+		ID:           uuid.New(),
 		WebhookKeyID: webhookKeyID,
 		Path:         path,
-		Data:         data, // keep plaintext in returned event
+		Data:         data, // keep plaintext (or the sealed box) in returned event
 		Processed:    false,
 		ProcessedAt:  nil,
 		CreatedAt:    now,
 		ExpiresAt:    expiresAt,
+		DeliverAfter: deliverAfter,
+		Sealed:       sealed,
+		Mode:         mode,
 	}
 
-	// Use repository if available (for testing)
-	if es.repo != nil {
-		// Store encrypted data in repo
-		repoEvent := *event
-		repoEvent.Data = storageData
-		if err := es.repo.Create(ctx, &repoEvent); err != nil {
-			return nil, fmt.Errorf("failed to create event: %w", err)
+	// Compress, then encrypt before storage. Sealed boxes are random and
+	// would not shrink. Large payloads go to the blob store and the row keeps
+	// only their content hash.
+	stored := *event
+	stored.PayloadSize = payloadSize
+	storageData := data
+	if (es.compress || hasCompressionMagic(data)) && !sealed {
+		var err error
+		if storageData, stored.Compressed, err = compressPayload(data); err != nil {
+			return nil, err
 		}
-		return event, nil
 	}
-
-	// Fallback to direct pool access (for backward compatibility)
-	err = es.pool.QueryRow(ctx,
-		`INSERT INTO events (id, webhook_key_id, path, data, processed, created_at, expires_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)
-		 RETURNING id, webhook_key_id, path, data, processed, processed_at, created_at, expires_at`,
-		eventID, webhookKeyID, path, storageData, false, now, expiresAt,
-	).Scan(&eventID, &webhookKeyID, &path, &storageData, nil, nil, &now, &expiresAt)
-
-	if err != nil {
+	if es.blobs != nil && len(storageData) > es.blobThreshold {
+		ref, size, err := es.putBlob(ctx, event, storageData)
+		if err != nil {
+			return nil, err
+		}
+		event.BlobRef = ref
+		stored.BlobRef = ref
+		stored.Data = []byte{}
+		stored.StoredSize = size
+	} else {
+		encrypted, err := es.encryptData(ctx, webhookKeyID, storageData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt event data: %w", err)
+		}
+		stored.Data = encrypted
+		stored.StoredSize = int64(len(encrypted))
+	}
+	if err := es.repo.Create(ctx, &stored); err != nil {
 		return nil, fmt.Errorf("failed to create event: %w", err)
 	}
 
@@ -94,198 +209,138 @@ func (es *EventService) CreateEvent(ctx context.Context, webhookKeyID uuid.UUID,
 
 // GetUnprocessedEvents retrieves unprocessed events for a webhook key
 func (es *EventService) GetUnprocessedEvents(ctx context.Context, webhookKeyID uuid.UUID) ([]models.Event, error) {
-	// Use repository if available (for testing)
-	if es.repo != nil {
-		return es.repo.GetUnprocessed(ctx, webhookKeyID)
-	}
-
-	// Fallback to direct pool access (for backward compatibility)
-	rows, err := es.pool.Query(ctx,
-		`SELECT id, webhook_key_id, path, data, processed, processed_at, created_at, expires_at
-		 FROM events
-		 WHERE webhook_key_id = $1 AND processed = false
-		 ORDER BY created_at ASC`,
-		webhookKeyID,
-	)
-
+	events, err := es.repo.GetUnprocessed(ctx, webhookKeyID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query events: %w", err)
 	}
-	defer rows.Close()
-
-	var events []models.Event
-	for rows.Next() {
-		var e models.Event
-		err := rows.Scan(&e.ID, &e.WebhookKeyID, &e.Path, &e.Data, &e.Processed, &e.ProcessedAt, &e.CreatedAt, &e.ExpiresAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan event: %w", err)
-		}
-		if err := es.decryptEventData(&e); err != nil {
-			return nil, fmt.Errorf("failed to decrypt event data: %w", err)
-		}
-		events = append(events, e)
-	}
-
-	return events, rows.Err()
+	return es.decryptEvents(ctx, events)
 }
 
 // GetEventByID retrieves an event by ID
 func (es *EventService) GetEventByID(ctx context.Context, eventID uuid.UUID) (*models.Event, error) {
-	// Use repository if available (for testing)
-	if es.repo != nil {
-		event, err := es.repo.GetByID(ctx, eventID)
-		if err != nil {
-			return nil, fmt.Errorf("event not found: %w", err)
-		}
-		return event, nil
-	}
-
-	// Fallback to direct pool access (for backward compatibility)
-	var e models.Event
-	err := es.pool.QueryRow(ctx,
-		`SELECT id, webhook_key_id, path, data, processed, processed_at, created_at, expires_at
-		 FROM events WHERE id = $1`,
-		eventID,
-	).Scan(&e.ID, &e.WebhookKeyID, &e.Path, &e.Data, &e.Processed, &e.ProcessedAt, &e.CreatedAt, &e.ExpiresAt)
-
+	event, err := es.repo.GetByID(ctx, eventID)
 	if err != nil {
 		return nil, fmt.Errorf("event not found: %w", err)
 	}
 
-	if err := es.decryptEventData(&e); err != nil {
+	if err := es.decryptEventData(ctx, event); err != nil {
 		return nil, fmt.Errorf("failed to decrypt event data: %w", err)
 	}
 
-	return &e, nil
+	return event, nil
 }
 
-// MarkEventAsProcessed marks an event as processed
-func (es *EventService) MarkEventAsProcessed(ctx context.Context, eventID uuid.UUID) error {
-	// Use repository if available (for testing)
-	if es.repo != nil {
-		return es.repo.MarkAsProcessed(ctx, eventID)
+// MarkEventAsProcessed marks an event as processed; it is kept for processedTTL
+// from now before cleanup removes it
+func (es *EventService) MarkEventAsProcessed(ctx context.Context, eventID uuid.UUID, processedTTL time.Duration) error {
+	err := es.repo.MarkAsProcessed(ctx, eventID, processedTTL)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return fmt.Errorf("event not found")
 	}
-
-	// Fallback to direct pool access (for backward compatibility)
-	now := time.Now()
-	result, err := es.pool.Exec(ctx,
-		`UPDATE events SET processed = true, processed_at = $1 WHERE id = $2`,
-		now, eventID,
-	)
-
 	if err != nil {
 		return fmt.Errorf("failed to mark event as processed: %w", err)
 	}
-
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("event not found")
-	}
-
 	return nil
 }
 
 // DeleteEvent deletes an event
 func (es *EventService) DeleteEvent(ctx context.Context, eventID uuid.UUID) error {
-	// Use repository if available (for testing)
-	if es.repo != nil {
-		return es.repo.Delete(ctx, eventID)
+	err := es.repo.Delete(ctx, eventID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return fmt.Errorf("event not found")
 	}
-
-	// Fallback to direct pool access (for backward compatibility)
-	result, err := es.pool.Exec(ctx, "DELETE FROM events WHERE id = $1", eventID)
-
 	if err != nil {
 		return fmt.Errorf("failed to delete event: %w", err)
 	}
-
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("event not found")
-	}
-
 	return nil
 }
 
 // GetEventsByWebhookKey retrieves events for a webhook key with limit
 func (es *EventService) GetEventsByWebhookKey(ctx context.Context, webhookKeyID uuid.UUID, limit int) ([]models.Event, error) {
-	// Use repository if available (for testing)
-	if es.repo != nil {
-		return es.repo.GetByWebhookKey(ctx, webhookKeyID, limit)
-	}
-
-	// Fallback to direct pool access (for backward compatibility)
-	rows, err := es.pool.Query(ctx,
-		`SELECT id, webhook_key_id, path, data, processed, processed_at, created_at, expires_at
-		 FROM events
-		 WHERE webhook_key_id = $1
-		 ORDER BY created_at DESC
-		 LIMIT $2`,
-		webhookKeyID, limit,
-	)
-
+	events, err := es.repo.GetByWebhookKey(ctx, webhookKeyID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query events: %w", err)
 	}
-	defer rows.Close()
+	return es.decryptEvents(ctx, events)
+}
 
-	var events []models.Event
-	for rows.Next() {
-		var e models.Event
-		err := rows.Scan(&e.ID, &e.WebhookKeyID, &e.Path, &e.Data, &e.Processed, &e.ProcessedAt, &e.CreatedAt, &e.ExpiresAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan event: %w", err)
+// ExportEvents calls fn with every event of a webhook key, newest first,
+// decrypting one at a time so that only one payload is held in memory.
+// Sealed events stay sealed; only the client can open them.
+func (es *EventService) ExportEvents(ctx context.Context, webhookKeyID uuid.UUID, fn func(*models.Event) error) error {
+	count, err := es.repo.CountByWebhookKey(ctx, webhookKeyID)
+	if err != nil {
+		return fmt.Errorf("failed to count events: %w", err)
+	}
+	if count == 0 {
+		return nil
+	}
+	events, err := es.repo.GetByWebhookKey(ctx, webhookKeyID, count)
+	if err != nil {
+		return fmt.Errorf("failed to query events: %w", err)
+	}
+	for i := range events {
+		if err := es.decryptEventData(ctx, &events[i]); err != nil {
+			return fmt.Errorf("failed to decrypt event data: %w", err)
 		}
-		if err := es.decryptEventData(&e); err != nil {
-			return nil, fmt.Errorf("failed to decrypt event data: %w", err)
+		if err := fn(&events[i]); err != nil {
+			return err
 		}
-		events = append(events, e)
+		events[i].Data = nil
 	}
+	return nil
+}
 
-	return events, rows.Err()
+// GetScheduledEventsDue retrieves unprocessed scheduled events whose delivery time
+// falls in the window (from, to]. Used to push scheduled events to live SSE clients.
+func (es *EventService) GetScheduledEventsDue(ctx context.Context, from, to time.Time) ([]models.Event, error) {
+	events, err := es.repo.GetScheduledDue(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scheduled events: %w", err)
+	}
+	return es.decryptEvents(ctx, events)
 }
 
 // DeleteExpiredEvents deletes events that have expired
 func (es *EventService) DeleteExpiredEvents(ctx context.Context) (int64, error) {
-	// Use repository if available (for testing)
-	if es.repo != nil {
-		return es.repo.DeleteExpired(ctx)
-	}
-
-	// Fallback to direct pool access (for backward compatibility)
-	result, err := es.pool.Exec(ctx, "DELETE FROM events WHERE expires_at < NOW()")
-
+	deleted, err := es.repo.DeleteExpired(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("failed to delete expired events: %w", err)
 	}
-
-	return result.RowsAffected(), nil
+	return deleted, nil
 }
 
 // CountUndeliveredEvents counts events not processed and older than the given duration
 func (es *EventService) CountUndeliveredEvents(ctx context.Context, olderThan time.Duration) (int, error) {
-	var count int
-	err := es.pool.QueryRow(ctx,
-		`SELECT COUNT(*) FROM events WHERE processed = false AND created_at < NOW() - make_interval(secs => $1)`,
-		int(olderThan.Seconds()),
-	).Scan(&count)
-
+	count, err := es.repo.CountUndelivered(ctx, olderThan)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count undelivered events: %w", err)
 	}
-
 	return count, nil
 }
 
+// StorageStats reports stored event sizes and the overall compression ratio
+func (es *EventService) StorageStats(ctx context.Context) (*models.EventStorageStats, error) {
+	stats, err := es.repo.StorageStats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage stats: %w", err)
+	}
+	if stats.StoredBytes > 0 {
+		stats.CompressionRatio = float64(stats.PayloadBytes) / float64(stats.StoredBytes)
+	}
+	return stats, nil
+}
+
 // CountEventsByWebhookKey counts total events for a webhook key
 func (es *EventService) CountEventsByWebhookKey(ctx context.Context, webhookKeyID string) (int, error) {
-	var count int
-	err := es.pool.QueryRow(ctx,
-		`SELECT COUNT(*) FROM events WHERE webhook_key_id = $1`,
-		webhookKeyID,
-	).Scan(&count)
+	id, err := uuid.Parse(webhookKeyID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid webhook key ID format: %w", err)
+	}
 
+	count, err := es.repo.CountByWebhookKey(ctx, id)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count events: %w", err)
 	}
-
 	return count, nil
 }
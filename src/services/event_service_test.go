@@ -24,7 +24,7 @@ func TestEventService_CreateEvent(t *testing.T) {
 			return nil
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
+		service := NewEventService(mockRepo)
 		event, err := service.CreateEvent(ctx, webhookKeyID, path, data, ttl)
 
 		if err != nil {
@@ -58,7 +58,7 @@ func TestEventService_CreateEvent(t *testing.T) {
 			return errors.New("database error")
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
+		service := NewEventService(mockRepo)
 		_, err := service.CreateEvent(ctx, webhookKeyID, path, data, ttl)
 
 		if err == nil {
@@ -90,7 +90,7 @@ func TestEventService_GetEventByID(t *testing.T) {
 			return nil, errors.New("not found")
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
+		service := NewEventService(mockRepo)
 		event, err := service.GetEventByID(ctx, eventID)
 
 		if err != nil {
@@ -107,7 +107,7 @@ func TestEventService_GetEventByID(t *testing.T) {
 			return nil, errors.New("not found")
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
+		service := NewEventService(mockRepo)
 		_, err := service.GetEventByID(ctx, eventID)
 
 		if err == nil {
@@ -134,7 +134,7 @@ func TestEventService_GetUnprocessedEvents(t *testing.T) {
 			return nil, nil
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
+		service := NewEventService(mockRepo)
 		events, err := service.GetUnprocessedEvents(ctx, webhookKeyID)
 
 		if err != nil {
@@ -151,7 +151,7 @@ func TestEventService_GetUnprocessedEvents(t *testing.T) {
 			return []models.Event{}, nil
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
+		service := NewEventService(mockRepo)
 		events, err := service.GetUnprocessedEvents(ctx, webhookKeyID)
 
 		if err != nil {
@@ -169,12 +169,12 @@ func TestEventService_MarkEventAsProcessed(t *testing.T) {
 
 	t.Run("marks event as processed", func(t *testing.T) {
 		mockRepo := mock.NewEventRepository()
-		mockRepo.MarkAsProcessedFunc = func(ctx context.Context, id uuid.UUID) error {
+		mockRepo.MarkAsProcessedFunc = func(ctx context.Context, id uuid.UUID, ttl time.Duration) error {
 			return nil
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
-		err := service.MarkEventAsProcessed(ctx, eventID)
+		service := NewEventService(mockRepo)
+		err := service.MarkEventAsProcessed(ctx, eventID, time.Hour)
 
 		if err != nil {
 			t.Fatalf("expected no error, got %v", err)
@@ -187,12 +187,12 @@ func TestEventService_MarkEventAsProcessed(t *testing.T) {
 
 	t.Run("returns error when event not found", func(t *testing.T) {
 		mockRepo := mock.NewEventRepository()
-		mockRepo.MarkAsProcessedFunc = func(ctx context.Context, id uuid.UUID) error {
+		mockRepo.MarkAsProcessedFunc = func(ctx context.Context, id uuid.UUID, ttl time.Duration) error {
 			return errors.New("event not found")
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
-		err := service.MarkEventAsProcessed(ctx, eventID)
+		service := NewEventService(mockRepo)
+		err := service.MarkEventAsProcessed(ctx, eventID, time.Hour)
 
 		if err == nil {
 			t.Fatal("expected error, got nil")
@@ -210,7 +210,7 @@ func TestEventService_DeleteEvent(t *testing.T) {
 			return nil
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
+		service := NewEventService(mockRepo)
 		err := service.DeleteEvent(ctx, eventID)
 
 		if err != nil {
@@ -224,7 +224,7 @@ func TestEventService_DeleteEvent(t *testing.T) {
 			return errors.New("delete failed")
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
+		service := NewEventService(mockRepo)
 		err := service.DeleteEvent(ctx, eventID)
 
 		if err == nil {
@@ -242,7 +242,7 @@ func TestEventService_DeleteExpiredEvents(t *testing.T) {
 			return 5, nil
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
+		service := NewEventService(mockRepo)
 		count, err := service.DeleteExpiredEvents(ctx)
 
 		if err != nil {
@@ -259,7 +259,7 @@ func TestEventService_DeleteExpiredEvents(t *testing.T) {
 			return 0, nil
 		}
 
-		service := NewEventServiceWithRepo(mockRepo)
+		service := NewEventService(mockRepo)
 		count, err := service.DeleteExpiredEvents(ctx)
 
 		if err != nil {
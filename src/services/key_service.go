@@ -6,69 +6,76 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/keyhash"
 	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
 )
 
-// User represents a user with their key information
-type User struct {
-	UserEmail       string     `json:"user_email"`
-	UserName        string     `json:"user_name"`
-	IsActive        bool       `json:"is_active"`
-	WebhookKeyCount int        `json:"webhook_key_count"`
-	ClientKeyCount  int        `json:"client_key_count"`
-	WebhookKey      string     `json:"webhook_key"`
-	ClientKey       string     `json:"client_key"`
-	UsageCount      int        `json:"usage_count"`
-	CreatedAt       time.Time  `json:"created_at"`
-	LastUsed        *time.Time `json:"last_used"`
-}
-
-// WebhookLog represents a webhook delivery log entry
-type WebhookLog struct {
-	ID             string
-	EventID        string
-	WebhookKeyID   string
-	ClientKeyID    *string
-	DeliveryStatus string
-	StatusCode     *int
-	ErrorMessage   *string
-	AttemptedAt    time.Time
-	DeliveredAt    *time.Time
-	AckedAt        *time.Time
-	ClientIP       *string
-	CreatedAt      time.Time
-}
-
-// KeyInfo represents basic key information for responses
-type KeyInfo struct {
-	ID       string
-	KeyValue string
-	KeyType  string
-	IsActive bool
+// MaxRetentionDays bounds the per-key retention a user can configure
+const MaxRetentionDays = 3650
+
+// Rotation overlap: how long a rotated key's old value keeps working
+const (
+	DefaultRotationOverlap = 24 * time.Hour
+	MaxRotationOverlap     = 30 * 24 * time.Hour
+)
+
+// RetentionPolicy is how long events are kept before cleanup removes them
+type RetentionPolicy struct {
+	Unprocessed time.Duration // from creation, or from deliver_after for scheduled events
+	Processed   time.Duration // from acknowledgement
+}
+
+// DefaultRetentionPolicy applies until SetRetentionDefaults is called
+var DefaultRetentionPolicy = RetentionPolicy{
+	Unprocessed: 30 * 24 * time.Hour,
+	Processed:   30 * 24 * time.Hour,
 }
 
 // KeyService handles key-related operations
 type KeyService struct {
-	pool *pgxpool.Pool
+	keys      repositories.KeyRepository
+	users     repositories.UserRepository
+	events    repositories.EventRepository
+	logs      repositories.WebhookLogRepository
+	hasher    *keyhash.Hasher
+	retention RetentionPolicy
 }
 
-// NewKeyService creates a new key service
-func NewKeyService(pool *pgxpool.Pool) *KeyService {
-	return &KeyService{pool: pool}
+// NewKeyService creates a new key service. Keys are stored and looked up by
+// their hash under hasher; its methods take and return key values.
+func NewKeyService(keys repositories.KeyRepository, users repositories.UserRepository, events repositories.EventRepository, logs repositories.WebhookLogRepository, hasher *keyhash.Hasher) *KeyService {
+	return &KeyService{keys: keys, users: users, events: events, logs: logs, hasher: hasher, retention: DefaultRetentionPolicy}
+}
+
+// SetRetentionDefaults sets the server-wide retention used by keys without overrides
+func (ks *KeyService) SetRetentionDefaults(policy RetentionPolicy) {
+	ks.retention = policy
+}
+
+// RetentionDefaults returns the server-wide retention
+func (ks *KeyService) RetentionDefaults() RetentionPolicy {
+	return ks.retention
 }
 
 // validateKey checks if a key exists and is active
 // This is a private helper method to avoid duplication between ValidateWebhookKey and ValidateClientKey
 func (ks *KeyService) validateKey(ctx context.Context, keyValue string, keyType models.KeyType) (bool, error) {
-	var isActive bool
-
-	query := `SELECT is_active FROM api_keys WHERE key_value = $1 AND key_type = $2`
-	err := ks.pool.QueryRow(ctx, query, keyValue, string(keyType)).Scan(&isActive)
+	var (
+		isActive bool
+		err      error
+	)
+	if keyType == models.KeyTypeWebhook {
+		isActive, err = ks.keys.ValidateWebhookKey(ctx, ks.hasher.Hash(keyValue))
+	} else {
+		isActive, err = ks.keys.ValidateClientKey(ctx, ks.hasher.Hash(keyValue))
+	}
 
 	if err != nil {
 		return false, ErrKeyNotFound
@@ -89,116 +96,95 @@ func (ks *KeyService) ValidateClientKey(ctx context.Context, keyValue string) (b
 
 // GetWebhookKeyByValue retrieves a webhook key by its value
 func (ks *KeyService) GetWebhookKeyByValue(ctx context.Context, keyValue string) (*models.WebhookKey, error) {
-	var wk models.WebhookKey
-	err := ks.pool.QueryRow(ctx,
-		"SELECT id, key_value, status, created_at, last_used, events_count FROM webhook_keys WHERE key_value = $1",
-		keyValue,
-	).Scan(&wk.ID, &wk.KeyValue, &wk.Status, &wk.CreatedAt, &wk.LastUsed, &wk.EventsCount)
-
+	wk, err := ks.keys.GetWebhookKeyByHash(ctx, ks.hasher.Hash(keyValue))
 	if err != nil {
 		return nil, fmt.Errorf("webhook key not found: %w", err)
 	}
 
-	return &wk, nil
+	return wk, nil
 }
 
 // GetEmailByWebhookKeyValue returns the user email associated with a webhook key value
 func (ks *KeyService) GetEmailByWebhookKeyValue(ctx context.Context, keyValue string) (string, error) {
-	var email string
-	err := ks.pool.QueryRow(ctx,
-		"SELECT COALESCE(user_email, '') FROM api_keys WHERE key_value = $1 AND key_type = 'webhook'",
-		keyValue,
-	).Scan(&email)
-	if err != nil {
-		return "", err
-	}
-	return email, nil
+	return ks.keys.GetEmailByWebhookKeyHash(ctx, ks.hasher.Hash(keyValue))
 }
 
 // GetClientKeyByValue retrieves a client key by its value
 func (ks *KeyService) GetClientKeyByValue(ctx context.Context, keyValue string) (*models.ClientKey, error) {
-	var ck models.ClientKey
-	err := ks.pool.QueryRow(ctx,
-		"SELECT id, key_value, webhook_key_id, status, created_at, last_connected, events_delivered FROM client_keys WHERE key_value = $1",
-		keyValue,
-	).Scan(&ck.ID, &ck.KeyValue, &ck.WebhookKeyID, &ck.Status, &ck.CreatedAt, &ck.LastConnected, &ck.EventsDelivered)
-
+	ck, err := ks.keys.GetClientKeyByHash(ctx, ks.hasher.Hash(keyValue))
 	if err != nil {
 		return nil, fmt.Errorf("client key not found: %w", err)
 	}
 
-	return &ck, nil
+	return ck, nil
 }
 
-// updateKeyStatus updates the active status of a key in the base table
-// This is a private helper method to avoid duplication between activateKeyInTable and deactivateKeyInTable
-func (ks *KeyService) updateKeyStatus(ctx context.Context, keyValue string, table string, isActive bool) error {
-	// Determine key type from table name
-	var keyType models.KeyType
-	switch table {
-	case models.TableWebhookKeys:
-		keyType = models.KeyTypeWebhook
-	case models.TableClientKeys:
+// updateKeyStatus updates the active status of a key
+// This is a private helper method to avoid duplication between ActivateKey and DeactivateKey
+func (ks *KeyService) updateKeyStatus(ctx context.Context, keyValue string, isWebhookKey bool, isActive bool) error {
+	keyType := models.KeyTypeWebhook
+	if !isWebhookKey {
 		keyType = models.KeyTypeClient
-	default:
-		return fmt.Errorf("invalid table name: %s", table)
 	}
 
-	// Views are read-only, update base table instead
-	query := "UPDATE api_keys SET is_active = $1 WHERE key_value = $2 AND key_type = $3"
-	result, err := ks.pool.Exec(ctx, query, isActive, keyValue, string(keyType))
+	err := ks.keys.UpdateKeyStatus(ctx, ks.hasher.Hash(keyValue), keyType, isActive)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return fmt.Errorf("key not found: %s", keyhash.Prefix(keyValue))
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update key status: %w", err)
 	}
 
-	// Check if key was actually updated
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return fmt.Errorf("key not found: %s", keyValue)
+	return nil
+}
+
+// updateKeyStatusByID is updateKeyStatus for a key given by ID
+func (ks *KeyService) updateKeyStatusByID(ctx context.Context, keyID string, isWebhookKey bool, isActive bool) error {
+	id, err := uuid.Parse(keyID)
+	if err != nil {
+		return fmt.Errorf("invalid key ID format: %w", err)
+	}
+	keyType := models.KeyTypeWebhook
+	if !isWebhookKey {
+		keyType = models.KeyTypeClient
+	}
+
+	err = ks.keys.UpdateKeyStatusByID(ctx, id, keyType, isActive)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return fmt.Errorf("key not found: %s", keyID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update key status: %w", err)
 	}
 
 	return nil
 }
 
-// activateKeyInTable activates a key in the specified table
-func (ks *KeyService) activateKeyInTable(ctx context.Context, keyValue string, table string) error {
-	return ks.updateKeyStatus(ctx, keyValue, table, true)
+// ActivateKeyWithID activates a webhook or client key given by ID
+func (ks *KeyService) ActivateKeyWithID(ctx context.Context, keyID string, isWebhookKey bool) error {
+	return ks.updateKeyStatusByID(ctx, keyID, isWebhookKey, true)
 }
 
-// ActivateKey activates a webhook or client key
-func (ks *KeyService) ActivateKey(ctx context.Context, keyValue string, isWebhookKey bool) error {
-	table := models.TableWebhookKeys
-	if !isWebhookKey {
-		table = models.TableClientKeys
-	}
-
-	return ks.activateKeyInTable(ctx, keyValue, table)
+// DeactivateKeyWithID deactivates a webhook or client key given by ID
+func (ks *KeyService) DeactivateKeyWithID(ctx context.Context, keyID string, isWebhookKey bool) error {
+	return ks.updateKeyStatusByID(ctx, keyID, isWebhookKey, false)
 }
 
-// deactivateKeyInTable deactivates a key in the specified table
-func (ks *KeyService) deactivateKeyInTable(ctx context.Context, keyValue string, table string) error {
-	return ks.updateKeyStatus(ctx, keyValue, table, false)
+// ActivateKey activates a webhook or client key
+func (ks *KeyService) ActivateKey(ctx context.Context, keyValue string, isWebhookKey bool) error {
+	return ks.updateKeyStatus(ctx, keyValue, isWebhookKey, true)
 }
 
 // DeactivateKey deactivates a webhook or client key
 func (ks *KeyService) DeactivateKey(ctx context.Context, keyValue string, isWebhookKey bool) error {
-	table := models.TableWebhookKeys
-	if !isWebhookKey {
-		table = models.TableClientKeys
-	}
-
-	return ks.deactivateKeyInTable(ctx, keyValue, table)
+	return ks.updateKeyStatus(ctx, keyValue, isWebhookKey, false)
 }
 
 // CreateWebhookKey creates a new webhook key
 func (ks *KeyService) CreateWebhookKey(ctx context.Context, keyValue string) (*models.WebhookKey, error) {
 	wk := &models.WebhookKey{}
-	err := ks.pool.QueryRow(ctx,
-		"INSERT INTO webhook_keys (key_value, status, created_at) VALUES ($1, 'active', NOW()) RETURNING id, key_value, status, created_at, events_count",
-		keyValue,
-	).Scan(&wk.ID, &wk.KeyValue, &wk.Status, &wk.CreatedAt, &wk.EventsCount)
-
-	if err != nil {
+	wk.KeyHash, wk.KeyPrefix, wk.KeyValue = ks.hashKey(keyValue)
+	if err := ks.keys.CreateWebhookKey(ctx, wk); err != nil {
 		return nil, fmt.Errorf("failed to create webhook key: %w", err)
 	}
 
@@ -207,13 +193,14 @@ func (ks *KeyService) CreateWebhookKey(ctx context.Context, keyValue string) (*m
 
 // CreateClientKey creates a new client key linked to a webhook key
 func (ks *KeyService) CreateClientKey(ctx context.Context, keyValue string, webhookKeyID string) (*models.ClientKey, error) {
-	ck := &models.ClientKey{}
-	err := ks.pool.QueryRow(ctx,
-		"INSERT INTO client_keys (key_value, webhook_key_id, status, created_at) VALUES ($1, $2, 'active', NOW()) RETURNING id, key_value, webhook_key_id, status, created_at, events_delivered",
-		keyValue, webhookKeyID,
-	).Scan(&ck.ID, &ck.KeyValue, &ck.WebhookKeyID, &ck.Status, &ck.CreatedAt, &ck.EventsDelivered)
-
+	webhookID, err := uuid.Parse(webhookKeyID)
 	if err != nil {
+		return nil, fmt.Errorf("invalid webhook key ID format: %w", err)
+	}
+
+	ck := &models.ClientKey{WebhookKeyID: webhookID}
+	ck.KeyHash, ck.KeyPrefix, ck.KeyValue = ks.hashKey(keyValue)
+	if err := ks.keys.CreateClientKey(ctx, ck); err != nil {
 		return nil, fmt.Errorf("failed to create client key: %w", err)
 	}
 
@@ -222,68 +209,19 @@ func (ks *KeyService) CreateClientKey(ctx context.Context, keyValue string, webh
 
 // GetWebhookKeys returns all webhook keys with their paired client keys
 func (ks *KeyService) GetWebhookKeys(ctx context.Context) ([]*models.WebhookKey, error) {
-	rows, err := ks.pool.Query(ctx, `
-		SELECT
-			wh.id,
-			wh.key_value,
-			CASE WHEN wh.is_active THEN 'active' ELSE 'inactive' END as status,
-			wh.created_at,
-			wh.last_used,
-			(SELECT COUNT(*) FROM events WHERE webhook_key_id = wh.id) as events_count,
-			COALESCE(ck.key_value, '') as client_key_value
-		FROM api_keys wh
-		LEFT JOIN api_keys ck ON ck.pair_id = wh.id AND ck.key_type = 'client'
-		WHERE wh.key_type = 'webhook'
-		ORDER BY wh.created_at DESC
-	`)
+	keys, err := ks.keys.GetWebhookKeys(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query webhook keys: %w", err)
 	}
-	defer rows.Close()
-
-	var keys []*models.WebhookKey
-	for rows.Next() {
-		wk := &models.WebhookKey{}
-		var clientKeyValue string
-
-		if err := rows.Scan(
-			&wk.ID,
-			&wk.KeyValue,
-			&wk.Status,
-			&wk.CreatedAt,
-			&wk.LastUsed,
-			&wk.EventsCount,
-			&clientKeyValue,
-		); err != nil {
-			return nil, fmt.Errorf("failed to scan webhook key: %w", err)
-		}
-
-		wk.ClientKeyValue = clientKeyValue
-		keys = append(keys, wk)
-	}
-
 	return keys, nil
 }
 
 // GetClientKeys returns all client keys
 func (ks *KeyService) GetClientKeys(ctx context.Context) ([]*models.ClientKey, error) {
-	rows, err := ks.pool.Query(ctx,
-		"SELECT id, key_value, webhook_key_id, status, created_at, last_connected, events_delivered FROM client_keys ORDER BY created_at DESC",
-	)
+	keys, err := ks.keys.GetClientKeys(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query client keys: %w", err)
 	}
-	defer rows.Close()
-
-	var keys []*models.ClientKey
-	for rows.Next() {
-		ck := &models.ClientKey{}
-		if err := rows.Scan(&ck.ID, &ck.KeyValue, &ck.WebhookKeyID, &ck.Status, &ck.CreatedAt, &ck.LastConnected, &ck.EventsDelivered); err != nil {
-			return nil, fmt.Errorf("failed to scan client key: %w", err)
-		}
-		keys = append(keys, ck)
-	}
-
 	return keys, nil
 }
 
@@ -296,6 +234,11 @@ func (ks *KeyService) generateKeyValue(prefix string) (string, error) {
 	return prefix + hex.EncodeToString(keyBytes), nil
 }
 
+// hashKey returns the stored hash, the display prefix and the value of a key
+func (ks *KeyService) hashKey(value string) (hash, prefix, keyValue string) {
+	return ks.hasher.Hash(value), keyhash.Prefix(value), value
+}
+
 // generateKeyPair generates both webhook and client keys
 func (ks *KeyService) generateKeyPair() (webhookKey string, clientKey string, err error) {
 	webhookKey, err = ks.generateKeyValue("wh_")
@@ -311,317 +254,114 @@ func (ks *KeyService) generateKeyPair() (webhookKey string, clientKey string, er
 	return webhookKey, clientKey, nil
 }
 
-// mapBoolToStatus converts is_active boolean to status string
-func (ks *KeyService) mapBoolToStatus(isActive bool) string {
-	if isActive {
-		return string(models.KeyStatusActive)
-	}
-	return string(models.KeyStatusInactive)
-}
-
 // CreateKeyPair creates a webhook key and client key together in a single transaction.
 // The client key's pair_id references the webhook key's id, establishing the relationship.
 func (ks *KeyService) CreateKeyPair(ctx context.Context) (*models.WebhookKey, *models.ClientKey, error) {
-	// Generate keys
 	webhookKeyValue, clientKeyValue, err := ks.generateKeyPair()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Begin transaction
-	tx, err := ks.pool.Begin(ctx)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to start transaction: %w", err)
-	}
-	defer func() { _ = tx.Rollback(ctx) }()
-
-	// Insert webhook key
-	webhookID := uuid.New()
-	now := time.Now()
-	var wk models.WebhookKey
-	var isActive bool
-	err = tx.QueryRow(ctx, `
-		INSERT INTO api_keys (
-			id, key_value, key_type, pair_id, is_active, activated_at,
-			created_at, usage_count
-		)
-		VALUES ($1, $2, 'webhook', $1, true, $3, $3, 0)
-		RETURNING id, key_value, is_active, created_at, last_used, usage_count
-	`, webhookID, webhookKeyValue, now).Scan(&wk.ID, &wk.KeyValue, &isActive, &wk.CreatedAt, &wk.LastUsed, &wk.EventsCount)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create webhook key: %w", err)
-	}
-	wk.Status = ks.mapBoolToStatus(isActive)
-
-	// Insert client key
-	var ck models.ClientKey
-	var clientIsActive bool
-	err = tx.QueryRow(ctx, `
-		INSERT INTO api_keys (
-			id, key_value, key_type, pair_id, is_active, activated_at,
-			created_at, usage_count
-		)
-		VALUES ($1, $2, 'client', $3, true, $4, $4, 0)
-		RETURNING id, key_value, pair_id, is_active, created_at, last_used, usage_count
-	`, uuid.New(), clientKeyValue, webhookID, now).Scan(&ck.ID, &ck.KeyValue, &ck.WebhookKeyID, &clientIsActive, &ck.CreatedAt, &ck.LastConnected, &ck.EventsDelivered)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create client key: %w", err)
-	}
-	ck.Status = ks.mapBoolToStatus(clientIsActive)
-
-	// Commit transaction
-	if err := tx.Commit(ctx); err != nil {
-		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	wk, ck := &models.WebhookKey{}, &models.ClientKey{}
+	wk.KeyHash, wk.KeyPrefix, wk.KeyValue = ks.hashKey(webhookKeyValue)
+	ck.KeyHash, ck.KeyPrefix, ck.KeyValue = ks.hashKey(clientKeyValue)
+	if err := ks.keys.CreateKeyPair(ctx, wk, ck); err != nil {
+		return nil, nil, err
 	}
 
-	return &wk, &ck, nil
+	return wk, ck, nil
 }
 
 // GetUsers returns all users with their key information, ordered by created_at DESC
-func (ks *KeyService) GetUsers(ctx context.Context) ([]User, error) {
-	query := `
-		SELECT
-			user_email,
-			COALESCE(MAX(user_name), '') as user_name,
-			BOOL_OR(is_active) as is_active,
-			MIN(created_at) as created_at,
-			MAX(last_used) as last_used,
-			COALESCE(SUM(usage_count), 0) as usage_count,
-			COUNT(*) FILTER (WHERE key_type = 'webhook') as webhook_key_count,
-			COUNT(*) FILTER (WHERE key_type = 'client') as client_key_count,
-			COALESCE(MAX(key_value) FILTER (WHERE key_type = 'webhook'), '') as webhook_key,
-			COALESCE(MAX(key_value) FILTER (WHERE key_type = 'client'), '') as client_key
-		FROM api_keys
-		WHERE user_email IS NOT NULL AND user_email != ''
-		GROUP BY user_email
-		ORDER BY MIN(created_at) DESC
-	`
-
-	rows, err := ks.pool.Query(ctx, query)
+func (ks *KeyService) GetUsers(ctx context.Context) ([]models.User, error) {
+	users, err := ks.users.List(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query users: %w", err)
 	}
-	defer rows.Close()
-
-	var users []User
-	for rows.Next() {
-		var user User
-		if err := rows.Scan(
-			&user.UserEmail,
-			&user.UserName,
-			&user.IsActive,
-			&user.CreatedAt,
-			&user.LastUsed,
-			&user.UsageCount,
-			&user.WebhookKeyCount,
-			&user.ClientKeyCount,
-			&user.WebhookKey,
-			&user.ClientKey,
-		); err != nil {
-			return nil, fmt.Errorf("failed to scan user: %w", err)
-		}
-		users = append(users, user)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating users: %w", err)
-	}
-
 	return users, nil
 }
 
 // GetUserDetails returns detailed information about a specific user by email
-func (ks *KeyService) GetUserDetails(ctx context.Context, userEmail string) (*User, error) {
-	user := &User{UserEmail: userEmail}
-
-	// Get created_at (earliest key)
-	err := ks.pool.QueryRow(ctx, `
-		SELECT MIN(created_at) FROM api_keys
-		WHERE user_email = $1
-	`, userEmail).Scan(&user.CreatedAt)
+func (ks *KeyService) GetUserDetails(ctx context.Context, userEmail string) (*models.User, error) {
+	user, err := ks.users.Get(ctx, userEmail)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
+		if errors.Is(err, repositories.ErrNotFound) {
 			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user details: %w", err)
 	}
+	return user, nil
+}
 
-	// Get the most recent active webhook key value
-	var webhookKey string
-	err = ks.pool.QueryRow(ctx, `
-		SELECT key_value FROM api_keys
-		WHERE user_email = $1 AND key_type = 'webhook' AND is_active = true
-		ORDER BY created_at DESC
-		LIMIT 1
-	`, userEmail).Scan(&webhookKey)
-	if err == nil {
-		user.WebhookKey = webhookKey
+// GetUserProfile returns the name, language and verification state stored for a user
+func (ks *KeyService) GetUserProfile(ctx context.Context, userEmail string) (*models.UserProfile, error) {
+	profile, err := ks.users.GetByEmail(ctx, userEmail)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user profile: %w", err)
 	}
+	return profile, nil
+}
 
-	// Get the most recent active client key value
-	var clientKey string
-	err = ks.pool.QueryRow(ctx, `
-		SELECT key_value FROM api_keys
-		WHERE user_email = $1 AND key_type = 'client' AND is_active = true
-		ORDER BY created_at DESC
-		LIMIT 1
-	`, userEmail).Scan(&clientKey)
-	if err == nil {
-		user.ClientKey = clientKey
+// SetDeliveryAlerts turns the user's stuck delivery emails on or off
+func (ks *KeyService) SetDeliveryAlerts(ctx context.Context, userEmail string, enabled bool) error {
+	err := ks.users.SetDeliveryAlerts(ctx, userEmail, enabled)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrUserNotFound
 	}
-
-	return user, nil
+	if err != nil {
+		return fmt.Errorf("failed to update delivery alerts: %w", err)
+	}
+	return nil
 }
 
 // GetUserKeyCount returns the count of active keys of a specific type for a user
 func (ks *KeyService) GetUserKeyCount(ctx context.Context, userEmail string, keyType string) (int, error) {
-	query := `
-		SELECT COUNT(*)
-		FROM api_keys
-		WHERE user_email = $1 AND key_type = $2 AND is_active = true
-	`
-
-	var count int
-	err := ks.pool.QueryRow(ctx, query, userEmail, keyType).Scan(&count)
+	count, err := ks.keys.CountUserKeys(ctx, userEmail, models.KeyType(keyType))
 	if err != nil {
 		return 0, fmt.Errorf("failed to get user key count: %w", err)
 	}
-
 	return count, nil
 }
 
 // GetUserEventCount returns the count of events for a user
 func (ks *KeyService) GetUserEventCount(ctx context.Context, userEmail string) (int, error) {
-	query := `
-		SELECT COUNT(*)
-		FROM events
-		WHERE webhook_key_id IN (
-			SELECT id FROM api_keys WHERE user_email = $1 AND key_type = 'webhook'
-		)
-	`
-
-	var count int
-	err := ks.pool.QueryRow(ctx, query, userEmail).Scan(&count)
+	count, err := ks.events.CountByUserEmail(ctx, userEmail)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get user event count: %w", err)
 	}
-
 	return count, nil
 }
 
 // GetUserWebhookLogCount returns the count of webhook logs for a user
 func (ks *KeyService) GetUserWebhookLogCount(ctx context.Context, userEmail string) (int, error) {
-	query := `
-		SELECT COUNT(*)
-		FROM webhook_logs
-		WHERE webhook_key_id IN (
-			SELECT id FROM api_keys WHERE user_email = $1 AND key_type = 'webhook'
-		)
-	`
-
-	var count int
-	err := ks.pool.QueryRow(ctx, query, userEmail).Scan(&count)
+	count, err := ks.logs.CountByUserEmail(ctx, userEmail)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get user webhook log count: %w", err)
 	}
-
 	return count, nil
 }
 
 // GetUserWebhookLogs returns webhook delivery logs for a specific user with pagination
-func (ks *KeyService) GetUserWebhookLogs(ctx context.Context, userEmail string, limit int, offset int) ([]WebhookLog, int, error) {
-	// Get total count
-	countQuery := `
-		SELECT COUNT(*)
-		FROM webhook_logs
-		WHERE webhook_key_id IN (
-			SELECT id FROM api_keys WHERE user_email = $1 AND key_type = 'webhook'
-		)
-	`
-
-	var total int
-	err := ks.pool.QueryRow(ctx, countQuery, userEmail).Scan(&total)
+func (ks *KeyService) GetUserWebhookLogs(ctx context.Context, userEmail string, limit int, offset int) ([]models.WebhookLog, int, error) {
+	total, err := ks.logs.CountByUserEmail(ctx, userEmail)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to get webhook log count: %w", err)
 	}
 
-	// Get paginated logs ordered by attempted_at DESC
-	query := `
-		SELECT
-			wl.id,
-			wl.event_id,
-			wl.webhook_key_id,
-			wl.client_key_id,
-			wl.delivery_status,
-			wl.status_code,
-			wl.error_message,
-			wl.attempted_at,
-			wl.delivered_at,
-			wl.acked_at,
-			wl.client_ip,
-			wl.created_at
-		FROM webhook_logs wl
-		WHERE wl.webhook_key_id IN (
-			SELECT id FROM api_keys WHERE user_email = $1 AND key_type = 'webhook'
-		)
-		ORDER BY wl.attempted_at DESC
-		LIMIT $2 OFFSET $3
-	`
-
-	rows, err := ks.pool.Query(ctx, query, userEmail, limit, offset)
+	logs, err := ks.logs.ListByUserEmail(ctx, userEmail, limit, offset)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to query webhook logs: %w", err)
 	}
-	defer rows.Close()
-
-	var logs []WebhookLog
-	for rows.Next() {
-		wl := WebhookLog{}
-		if err := rows.Scan(
-			&wl.ID,
-			&wl.EventID,
-			&wl.WebhookKeyID,
-			&wl.ClientKeyID,
-			&wl.DeliveryStatus,
-			&wl.StatusCode,
-			&wl.ErrorMessage,
-			&wl.AttemptedAt,
-			&wl.DeliveredAt,
-			&wl.AckedAt,
-			&wl.ClientIP,
-			&wl.CreatedAt,
-		); err != nil {
-			return nil, 0, fmt.Errorf("failed to scan webhook log: %w", err)
-		}
-		logs = append(logs, wl)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("error iterating webhook logs: %w", err)
-	}
 
 	return logs, total, nil
 }
 
-// WebhookLogEntry is a simplified webhook log for dashboard API (no path — privacy)
-type WebhookLogEntry struct {
-	ID             string     `json:"id"`
-	EventID        string     `json:"event_id"`
-	DeliveryStatus string     `json:"delivery_status"`
-	StatusCode     *int       `json:"status_code,omitempty"`
-	ErrorMessage   *string    `json:"error_message,omitempty"`
-	AttemptedAt    time.Time  `json:"attempted_at"`
-	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
-	AckedAt        *time.Time `json:"acked_at,omitempty"`
-}
-
 // CreateWebhookLog creates a new webhook log entry with pending status
 func (ks *KeyService) CreateWebhookLog(ctx context.Context, eventID uuid.UUID, webhookKeyID uuid.UUID, statusCode int) error {
-	_, err := ks.pool.Exec(ctx, `
-		INSERT INTO webhook_logs (event_id, webhook_key_id, delivery_status, status_code, attempted_at)
-		VALUES ($1, $2, 'pending', $3, NOW())
-	`, eventID, webhookKeyID, statusCode)
-	if err != nil {
+	if err := ks.logs.Create(ctx, eventID, webhookKeyID, statusCode); err != nil {
 		return fmt.Errorf("failed to create webhook log: %w", err)
 	}
 	return nil
@@ -629,12 +369,7 @@ func (ks *KeyService) CreateWebhookLog(ctx context.Context, eventID uuid.UUID, w
 
 // UpdateWebhookLogDelivered updates a webhook log to delivered status
 func (ks *KeyService) UpdateWebhookLogDelivered(ctx context.Context, eventID uuid.UUID, webhookKeyID uuid.UUID, clientKeyID uuid.UUID) error {
-	_, err := ks.pool.Exec(ctx, `
-		UPDATE webhook_logs
-		SET delivery_status = 'delivered', delivered_at = NOW(), client_key_id = $3
-		WHERE event_id = $1 AND webhook_key_id = $2 AND delivery_status = 'pending'
-	`, eventID, webhookKeyID, clientKeyID)
-	if err != nil {
+	if err := ks.logs.MarkDelivered(ctx, eventID, webhookKeyID, clientKeyID); err != nil {
 		return fmt.Errorf("failed to update webhook log to delivered: %w", err)
 	}
 	return nil
@@ -642,223 +377,565 @@ func (ks *KeyService) UpdateWebhookLogDelivered(ctx context.Context, eventID uui
 
 // UpdateWebhookLogAcked updates a webhook log to acked status
 func (ks *KeyService) UpdateWebhookLogAcked(ctx context.Context, eventID uuid.UUID) error {
-	_, err := ks.pool.Exec(ctx, `
-		UPDATE webhook_logs
-		SET delivery_status = 'acked', acked_at = NOW()
-		WHERE event_id = $1 AND delivery_status IN ('pending', 'delivered')
-	`, eventID)
-	if err != nil {
+	if err := ks.logs.MarkAcked(ctx, eventID); err != nil {
 		return fmt.Errorf("failed to update webhook log to acked: %w", err)
 	}
 	return nil
 }
 
+// GetWebhookLogStatus returns the delivery status of the most recent log entry for an event
+func (ks *KeyService) GetWebhookLogStatus(ctx context.Context, eventID uuid.UUID) (string, error) {
+	status, err := ks.logs.GetLatestStatus(ctx, eventID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get webhook log status: %w", err)
+	}
+	return status, nil
+}
+
 // UpdateKeyUsageStats updates last_used and increments usage_count for a webhook key
 func (ks *KeyService) UpdateKeyUsageStats(ctx context.Context, webhookKeyID uuid.UUID) error {
-	_, err := ks.pool.Exec(ctx, `
-		UPDATE api_keys SET last_used = NOW(), usage_count = usage_count + 1 WHERE id = $1
-	`, webhookKeyID)
-	if err != nil {
+	if err := ks.keys.IncrementUsage(ctx, webhookKeyID); err != nil {
 		return fmt.Errorf("failed to update key usage stats: %w", err)
 	}
 	return nil
 }
 
 // GetUserWebhookLogEntries returns webhook log entries for dashboard API (no path — privacy)
-func (ks *KeyService) GetUserWebhookLogEntries(ctx context.Context, userEmail string, limit int, offset int) ([]WebhookLogEntry, int, error) {
-	var total int
-	err := ks.pool.QueryRow(ctx, `
-		SELECT COUNT(*)
-		FROM webhook_logs
-		WHERE webhook_key_id IN (
-			SELECT id FROM api_keys WHERE user_email = $1 AND key_type = 'webhook'
-		)
-	`, userEmail).Scan(&total)
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to count webhook logs: %w", err)
-	}
-
-	rows, err := ks.pool.Query(ctx, `
-		SELECT id, event_id, delivery_status, status_code, error_message,
-			   attempted_at, delivered_at, acked_at
-		FROM webhook_logs
-		WHERE webhook_key_id IN (
-			SELECT id FROM api_keys WHERE user_email = $1 AND key_type = 'webhook'
-		)
-		ORDER BY attempted_at DESC
-		LIMIT $2 OFFSET $3
-	`, userEmail, limit, offset)
+func (ks *KeyService) GetUserWebhookLogEntries(ctx context.Context, userEmail string, limit int, offset int) ([]models.WebhookLogEntry, int, error) {
+	logs, total, err := ks.GetUserWebhookLogs(ctx, userEmail, limit, offset)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to query webhook logs: %w", err)
+		return nil, 0, err
 	}
-	defer rows.Close()
 
-	var logs []WebhookLogEntry
-	for rows.Next() {
-		var l WebhookLogEntry
-		if err := rows.Scan(
-			&l.ID, &l.EventID, &l.DeliveryStatus, &l.StatusCode,
-			&l.ErrorMessage, &l.AttemptedAt, &l.DeliveredAt, &l.AckedAt,
-		); err != nil {
-			return nil, 0, fmt.Errorf("failed to scan webhook log: %w", err)
-		}
-		logs = append(logs, l)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("error iterating webhook logs: %w", err)
+	var entries []models.WebhookLogEntry
+	for i := range logs {
+		entries = append(entries, logs[i].Entry())
 	}
 
-	return logs, total, nil
-}
-
-// KeyPair represents a webhook+client key pair for the dashboard
-type KeyPair struct {
-	PairID     string     `json:"pair_id"`
-	WebhookKey string     `json:"webhook_key"`
-	ClientKey  string     `json:"client_key"`
-	IsActive   bool       `json:"is_active"`
-	CreatedAt  time.Time  `json:"created_at"`
-	LastUsed   *time.Time `json:"last_used,omitempty"`
-	UsageCount int        `json:"usage_count"`
+	return entries, total, nil
 }
 
 // GetUserKeyPairs returns all key pairs for a user, ordered newest first
-func (ks *KeyService) GetUserKeyPairs(ctx context.Context, userEmail string) ([]KeyPair, error) {
-	rows, err := ks.pool.Query(ctx, `
-		SELECT
-			wk.id,
-			wk.key_value,
-			COALESCE(ck.key_value, ''),
-			wk.is_active,
-			wk.created_at,
-			wk.last_used,
-			wk.usage_count
-		FROM api_keys wk
-		LEFT JOIN api_keys ck ON ck.pair_id = wk.id AND ck.key_type = 'client'
-		WHERE wk.user_email = $1 AND wk.key_type = 'webhook'
-		ORDER BY wk.created_at DESC
-	`, userEmail)
+func (ks *KeyService) GetUserKeyPairs(ctx context.Context, userEmail string) ([]models.KeyPair, error) {
+	pairs, err := ks.keys.ListUserKeyPairs(ctx, userEmail)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query key pairs: %w", err)
 	}
-	defer rows.Close()
-
-	var pairs []KeyPair
-	for rows.Next() {
-		var p KeyPair
-		if err := rows.Scan(&p.PairID, &p.WebhookKey, &p.ClientKey, &p.IsActive, &p.CreatedAt, &p.LastUsed, &p.UsageCount); err != nil {
-			return nil, fmt.Errorf("failed to scan key pair: %w", err)
-		}
-		pairs = append(pairs, p)
-	}
-	return pairs, rows.Err()
+	return pairs, nil
 }
 
 // DeactivateKeyPairByID deactivates a specific key pair, scoped to user email
 func (ks *KeyService) DeactivateKeyPairByID(ctx context.Context, pairID uuid.UUID, userEmail string) error {
-	result, err := ks.pool.Exec(ctx, `
-		UPDATE api_keys SET is_active = false
-		WHERE pair_id = $1 AND user_email = $2 AND is_active = true
-	`, pairID, userEmail)
+	err := ks.keys.DeactivateUserKeyPair(ctx, pairID, userEmail)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return fmt.Errorf("key pair not found or already revoked")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to deactivate key pair: %w", err)
 	}
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("key pair not found or already revoked")
-	}
 	return nil
 }
 
 // DeactivateKeyByID deactivates a key by its UUID ID
 func (ks *KeyService) DeactivateKeyByID(ctx context.Context, keyID string) error {
-	_, err := uuid.Parse(keyID)
+	id, err := uuid.Parse(keyID)
 	if err != nil {
 		return fmt.Errorf("invalid key ID format: %w", err)
 	}
 
-	result, err := ks.pool.Exec(ctx,
-		"UPDATE api_keys SET is_active = false WHERE id = $1",
-		keyID,
-	)
-
+	err = ks.keys.DeactivateKeyByID(ctx, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrKeyNotFound
+	}
 	if err != nil {
 		return fmt.Errorf("failed to deactivate key: %w", err)
 	}
 
-	if result.RowsAffected() == 0 {
-		return ErrKeyNotFound
-	}
-
 	return nil
 }
 
 // GetKeyByID retrieves a key by its ID
-func (ks *KeyService) GetKeyByID(ctx context.Context, keyID string) (*KeyInfo, error) {
-	// Parse keyID as UUID to validate format
-	_, err := uuid.Parse(keyID)
+func (ks *KeyService) GetKeyByID(ctx context.Context, keyID string) (*models.KeyInfo, error) {
+	id, err := uuid.Parse(keyID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid key ID format: %w", err)
 	}
 
-	var keyInfo KeyInfo
-	err = ks.pool.QueryRow(ctx,
-		"SELECT id, key_value, key_type, is_active FROM api_keys WHERE id = $1",
-		keyID,
-	).Scan(&keyInfo.ID, &keyInfo.KeyValue, &keyInfo.KeyType, &keyInfo.IsActive)
-
+	keyInfo, err := ks.keys.GetKeyByID(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("key not found: %w", err)
 	}
 
-	return &keyInfo, nil
+	return keyInfo, nil
+}
+
+// GetKeyInfoByValue retrieves the basic information GetKeyByID returns for a
+// webhook or client key given by value
+func (ks *KeyService) GetKeyInfoByValue(ctx context.Context, keyValue string, isWebhookKey bool) (*models.KeyInfo, error) {
+	var keyID uuid.UUID
+	if isWebhookKey {
+		wk, err := ks.GetWebhookKeyByValue(ctx, keyValue)
+		if err != nil {
+			return nil, err
+		}
+		keyID = wk.ID
+	} else {
+		ck, err := ks.GetClientKeyByValue(ctx, keyValue)
+		if err != nil {
+			return nil, err
+		}
+		keyID = ck.ID
+	}
+	return ks.GetKeyByID(ctx, keyID.String())
 }
 
 // DeleteKeyPair deletes both webhook and client keys by pair_id
 func (ks *KeyService) DeleteKeyPair(ctx context.Context, webhookKeyValue string) error {
-	// First get the pair_id from webhook key
-	var pairID *uuid.UUID
-	err := ks.pool.QueryRow(ctx,
-		"SELECT pair_id FROM api_keys WHERE key_value = $1 AND key_type = 'webhook'",
-		webhookKeyValue,
-	).Scan(&pairID)
-
-	if err != nil {
-		fmt.Printf("ERROR: webhook key not found: %s, error: %v\n", webhookKeyValue, err)
+	err := ks.keys.DeleteKeyPair(ctx, ks.hasher.Hash(webhookKeyValue))
+	if errors.Is(err, repositories.ErrNotFound) {
 		return fmt.Errorf("webhook key not found: %w", err)
 	}
+	if err != nil {
+		return fmt.Errorf("failed to delete key pair: %w", err)
+	}
+	return nil
+}
 
-	// If pair_id is NULL, delete by key_value directly
-	if pairID == nil {
-		fmt.Printf("DEBUG: pair_id is NULL for webhook key %s, deleting by key_value\n", webhookKeyValue)
-		result, err := ks.pool.Exec(ctx,
-			"DELETE FROM api_keys WHERE key_value = $1",
-			webhookKeyValue,
-		)
+// hashBatchSize is how many unhashed keys HashLegacyKeys loads at a time
+const hashBatchSize = 500
+
+// HashLegacyKeys replaces keys stored in the clear before keys were hashed,
+// and the values rotations replaced, with their hashes and prefixes. The keys
+// keep working unchanged. It returns the number of keys hashed.
+func (ks *KeyService) HashLegacyKeys(ctx context.Context) (int, error) {
+	hashed := 0
+	for {
+		keys, err := ks.keys.ListUnhashedKeys(ctx, hashBatchSize)
 		if err != nil {
-			fmt.Printf("ERROR: failed to delete key: %v\n", err)
-			return fmt.Errorf("failed to delete key: %w", err)
+			return hashed, fmt.Errorf("failed to list unhashed keys: %w", err)
+		}
+		if len(keys) == 0 {
+			return hashed, nil
+		}
+		for _, k := range keys {
+			var previous *string
+			if k.PreviousKeyValue != nil {
+				h := ks.hasher.Hash(*k.PreviousKeyValue)
+				previous = &h
+			}
+			if err := ks.keys.StoreKeyHash(ctx, k.ID, ks.hasher.Hash(k.KeyValue), keyhash.Prefix(k.KeyValue), previous); err != nil {
+				return hashed, fmt.Errorf("failed to hash key %s: %w", k.ID, err)
+			}
+			hashed++
 		}
-		rowsAffected := result.RowsAffected()
-		fmt.Printf("DEBUG: Deleted %d rows with key_value %s\n", rowsAffected, webhookKeyValue)
-		return nil
 	}
+}
 
-	fmt.Printf("DEBUG: Found pair_id %s for webhook key %s\n", *pairID, webhookKeyValue)
+// GetRetentionPolicy resolves a webhook key's retention, filling unset values
+// from the server defaults. Lookup failures fall back to the defaults so that
+// event delivery never fails over retention.
+func (ks *KeyService) GetRetentionPolicy(ctx context.Context, webhookKeyID uuid.UUID) RetentionPolicy {
+	settings, err := ks.keys.GetRetention(ctx, webhookKeyID)
+	if err != nil {
+		log.Printf("Failed to load retention for webhook key %s, using defaults: %v", webhookKeyID, err)
+		return ks.retention
+	}
+	return ks.resolveRetention(*settings)
+}
 
-	// Delete all keys with this pair_id (both webhook and client)
-	result, err := ks.pool.Exec(ctx,
-		"DELETE FROM api_keys WHERE pair_id = $1",
-		*pairID,
-	)
+// resolveRetention applies a key's overrides on top of the defaults
+func (ks *KeyService) resolveRetention(settings models.RetentionSettings) RetentionPolicy {
+	policy := ks.retention
+	if settings.EventTTLDays != nil {
+		policy.Unprocessed = time.Duration(*settings.EventTTLDays) * 24 * time.Hour
+	}
+	if settings.ProcessedTTLDays != nil {
+		policy.Processed = time.Duration(*settings.ProcessedTTLDays) * 24 * time.Hour
+	}
+	return policy
+}
 
+// UserOwnsKeyPair reports whether pairID (the webhook key ID) is one of the user's key pairs
+func (ks *KeyService) UserOwnsKeyPair(ctx context.Context, userEmail string, pairID uuid.UUID) (bool, error) {
+	pairs, err := ks.keys.ListUserKeyPairs(ctx, userEmail)
 	if err != nil {
-		fmt.Printf("ERROR: failed to delete key pair: %v\n", err)
-		return fmt.Errorf("failed to delete key pair: %w", err)
+		return false, fmt.Errorf("failed to query key pairs: %w", err)
+	}
+	for _, p := range pairs {
+		if p.PairID == pairID.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// UpdateKeyRetention changes the retention of a user's key pair, recomputes the
+// expiry of its stored events and removes those of its events that are now
+// expired.
+// It returns the number of events removed.
+func (ks *KeyService) UpdateKeyRetention(ctx context.Context, userEmail string, pairID uuid.UUID, settings models.RetentionSettings) (int64, error) {
+	for _, days := range []*int{settings.EventTTLDays, settings.ProcessedTTLDays} {
+		if days != nil && (*days < 1 || *days > MaxRetentionDays) {
+			return 0, fmt.Errorf("%w: must be between 1 and %d days", ErrInvalidRetention, MaxRetentionDays)
+		}
+	}
+
+	owned, err := ks.UserOwnsKeyPair(ctx, userEmail, pairID)
+	if err != nil {
+		return 0, err
+	}
+	if !owned {
+		return 0, ErrKeyNotFound
+	}
+
+	if err := ks.keys.SetRetention(ctx, pairID, settings); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return 0, ErrKeyNotFound
+		}
+		return 0, fmt.Errorf("failed to update retention: %w", err)
+	}
+
+	policy := ks.resolveRetention(settings)
+	if _, err := ks.events.ApplyRetention(ctx, pairID, policy.Unprocessed, policy.Processed); err != nil {
+		return 0, fmt.Errorf("failed to apply retention: %w", err)
+	}
+
+	deleted, err := deleteInBatches(ctx, DefaultCleanupBatchSize, func(ctx context.Context, limit int) (int64, error) {
+		return ks.events.DeleteExpiredByKeyBatch(ctx, pairID, limit)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired events: %w", err)
+	}
+	return deleted, nil
+}
+
+// RotateUserKey gives one side of a user's key pair a new value. The old value
+// keeps working for overlap (0 retires it at once); the other key, the pair
+// and its pending events are untouched. It returns the new value and when the
+// old one stops working, nil if it already has.
+func (ks *KeyService) RotateUserKey(ctx context.Context, userEmail string, pairID uuid.UUID, keyType models.KeyType, overlap time.Duration) (string, *time.Time, error) {
+	var prefix string
+	switch keyType {
+	case models.KeyTypeWebhook:
+		prefix = "wh_"
+	case models.KeyTypeClient:
+		prefix = "ck_"
+	default:
+		return "", nil, ErrInvalidKeyType
+	}
+	if overlap < 0 || overlap > MaxRotationOverlap {
+		return "", nil, fmt.Errorf("%w: must be between 0 and %d hours", ErrInvalidOverlap, MaxRotationOverlap/time.Hour)
+	}
+
+	owned, err := ks.UserOwnsKeyPair(ctx, userEmail, pairID)
+	if err != nil {
+		return "", nil, err
+	}
+	if !owned {
+		return "", nil, ErrKeyNotFound
 	}
 
-	rowsAffected := result.RowsAffected()
-	fmt.Printf("DEBUG: Deleted %d rows with pair_id %s\n", rowsAffected, *pairID)
+	newValue, err := ks.generateKeyValue(prefix)
+	if err != nil {
+		return "", nil, err
+	}
+	var previousExpiresAt *time.Time
+	if overlap > 0 {
+		t := time.Now().Add(overlap)
+		previousExpiresAt = &t
+	}
+
+	err = ks.keys.RotateKey(ctx, pairID, keyType, ks.hasher.Hash(newValue), keyhash.Prefix(newValue), previousExpiresAt)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return "", nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to rotate key: %w", err)
+	}
+	return newValue, previousExpiresAt, nil
+}
+
+// Limits on named webhook keys and their settings
+const (
+	MaxWebhookKeyNameLen      = 50
+	MaxWebhookKeyRateLimit    = 10000
+	MaxWebhookKeyAllowedPaths = 20
+	MaxWebhookKeyPathRuleLen  = 256
+)
 
+// SigningSecretAction says what a webhook key update does to its signing secret
+type SigningSecretAction string
+
+const (
+	SigningSecretKeep SigningSecretAction = "keep"
+	SigningSecretNew  SigningSecretAction = "new"
+	SigningSecretNone SigningSecretAction = "none"
+)
+
+// PathRules restrict where a webhook key's events are written: a forced
+// prefix is prepended first, then the result must match an allowed glob
+type PathRules struct {
+	AllowedPaths     []string `json:"allowed_paths"`
+	ForcedPathPrefix *string  `json:"forced_path_prefix"`
+}
+
+// WebhookKeyOptions are the user-editable fields of a webhook key
+type WebhookKeyOptions struct {
+	Name               string
+	RateLimitPerMinute *int
+	PathRules
+	SigningSecret SigningSecretAction // empty means keep
+}
+
+// ResolveWebhookKey returns a webhook key whether or not it is active, or
+// ErrKeyNotFound if no key has that value
+func (ks *KeyService) ResolveWebhookKey(ctx context.Context, keyValue string) (*models.WebhookKey, error) {
+	wk, err := ks.keys.GetWebhookKeyByHash(ctx, ks.hasher.Hash(keyValue))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up webhook key: %w", err)
+	}
+	return wk, nil
+}
+
+// CreateUserWebhookKey adds a named webhook key to one of the user's pairs.
+// Events sent with it reach the pair's client key like those of the original
+// webhook key. The returned key carries its value and any signing secret,
+// which are only shown once.
+func (ks *KeyService) CreateUserWebhookKey(ctx context.Context, userEmail string, pairID uuid.UUID, opts WebhookKeyOptions) (*models.WebhookKey, error) {
+	pair, err := ks.userKeyPair(ctx, userEmail, pairID)
+	if err != nil {
+		return nil, err
+	}
+	if !pair.IsActive {
+		return nil, ErrKeyNotFound
+	}
+
+	if opts.SigningSecret == SigningSecretKeep || opts.SigningSecret == "" {
+		opts.SigningSecret = SigningSecretNone
+	}
+	name, settings, err := ks.webhookKeySettings(pair, uuid.Nil, opts, models.WebhookKeySettings{})
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidWebhookKey)
+	}
+
+	value, err := ks.generateKeyValue("wh_")
+	if err != nil {
+		return nil, err
+	}
+	wk := &models.WebhookKey{Name: name, Settings: settings}
+	wk.KeyHash, wk.KeyPrefix, wk.KeyValue = ks.hashKey(value)
+	if err := ks.keys.CreatePairWebhookKey(ctx, pairID, userEmail, wk); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to create webhook key: %w", err)
+	}
+	return wk, nil
+}
+
+// UpdateUserWebhookKey replaces the name and settings of one of the user's
+// webhook keys. A nil limit or prefix removes it. The returned settings carry
+// the signing secret only when a new one was generated.
+func (ks *KeyService) UpdateUserWebhookKey(ctx context.Context, userEmail string, keyID uuid.UUID, opts WebhookKeyOptions) (*models.WebhookKey, error) {
+	pair, current, err := ks.userWebhookKey(ctx, userEmail, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if !current.IsActive() {
+		return nil, ErrKeyNotFound
+	}
+
+	name, settings, err := ks.webhookKeySettings(pair, keyID, opts, current.Settings)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" && !current.IsPrimary() {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidWebhookKey)
+	}
+
+	if err := ks.keys.UpdateWebhookKeySettings(ctx, keyID, userEmail, name, settings); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to update webhook key: %w", err)
+	}
+
+	updated := *current
+	updated.Name = name
+	updated.Settings = settings
+	if opts.SigningSecret != SigningSecretNew {
+		updated.Settings.SigningSecret = nil
+	}
+	return &updated, nil
+}
+
+// RevokeUserWebhookKey deactivates one of the user's named webhook keys; the
+// pair's original webhook key is revoked together with the pair
+func (ks *KeyService) RevokeUserWebhookKey(ctx context.Context, userEmail string, keyID uuid.UUID) error {
+	err := ks.keys.DeactivateUserWebhookKey(ctx, keyID, userEmail)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrKeyNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke webhook key: %w", err)
+	}
+	return nil
+}
+
+// userKeyPair returns one of the user's key pairs with its webhook keys
+func (ks *KeyService) userKeyPair(ctx context.Context, userEmail string, pairID uuid.UUID) (*models.KeyPair, error) {
+	pairs, err := ks.keys.ListUserKeyPairs(ctx, userEmail)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query key pairs: %w", err)
+	}
+	for i := range pairs {
+		if pairs[i].PairID == pairID.String() {
+			return &pairs[i], nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// userWebhookKey finds one of the user's webhook keys and the pair it feeds
+func (ks *KeyService) userWebhookKey(ctx context.Context, userEmail string, keyID uuid.UUID) (*models.KeyPair, *models.WebhookKey, error) {
+	pairs, err := ks.keys.ListUserKeyPairs(ctx, userEmail)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query key pairs: %w", err)
+	}
+	for i := range pairs {
+		for j := range pairs[i].WebhookKeys {
+			if pairs[i].WebhookKeys[j].ID == keyID {
+				return &pairs[i], &pairs[i].WebhookKeys[j], nil
+			}
+		}
+	}
+	return nil, nil, ErrKeyNotFound
+}
+
+// webhookKeySettings validates opts for key keyID of pair (uuid.Nil for a new
+// key) and resolves the signing secret against the current one
+func (ks *KeyService) webhookKeySettings(pair *models.KeyPair, keyID uuid.UUID, opts WebhookKeyOptions, current models.WebhookKeySettings) (string, models.WebhookKeySettings, error) {
+	var settings models.WebhookKeySettings
+
+	name := strings.TrimSpace(opts.Name)
+	if utf8.RuneCountInString(name) > MaxWebhookKeyNameLen {
+		return "", settings, fmt.Errorf("%w: name must be at most %d characters", ErrInvalidWebhookKey, MaxWebhookKeyNameLen)
+	}
+	if name != "" {
+		for _, k := range pair.WebhookKeys {
+			if k.ID != keyID && k.IsActive() && strings.EqualFold(k.Name, name) {
+				return "", settings, fmt.Errorf("%w: the key pair already has a webhook key named %q", ErrInvalidWebhookKey, name)
+			}
+		}
+	}
+
+	if limit := opts.RateLimitPerMinute; limit != nil {
+		if *limit < 1 || *limit > MaxWebhookKeyRateLimit {
+			return "", settings, fmt.Errorf("%w: rate limit must be between 1 and %d requests per minute", ErrInvalidWebhookKey, MaxWebhookKeyRateLimit)
+		}
+		v := *limit
+		settings.RateLimitPerMinute = &v
+	}
+
+	rules, err := validatePathRules(opts.PathRules)
+	if err != nil {
+		return "", settings, err
+	}
+	settings.AllowedPaths, settings.ForcedPathPrefix = rules.AllowedPaths, rules.ForcedPathPrefix
+
+	switch opts.SigningSecret {
+	case "", SigningSecretKeep:
+		settings.SigningSecret = current.SigningSecret
+	case SigningSecretNone:
+	case SigningSecretNew:
+		secret, err := ks.generateKeyValue("whsec_")
+		if err != nil {
+			return "", settings, err
+		}
+		settings.SigningSecret = &secret
+	default:
+		return "", settings, fmt.Errorf("%w: signing_secret must be keep, new or none", ErrInvalidWebhookKey)
+	}
+
+	return name, settings, nil
+}
+
+// SetWebhookKeyPathRules replaces the path rules of any webhook key, for
+// admins. It returns the rules as stored.
+func (ks *KeyService) SetWebhookKeyPathRules(ctx context.Context, keyID uuid.UUID, rules PathRules) (PathRules, error) {
+	rules, err := validatePathRules(rules)
+	if err != nil {
+		return PathRules{}, err
+	}
+	err = ks.keys.SetWebhookKeyPathRules(ctx, keyID, rules.AllowedPaths, rules.ForcedPathPrefix)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return PathRules{}, ErrKeyNotFound
+	}
+	if err != nil {
+		return PathRules{}, fmt.Errorf("failed to update path rules: %w", err)
+	}
+	return rules, nil
+}
+
+// validatePathRules trims and checks path rules. Globs and the forced prefix
+// are relative to the vault; the prefix is stored without slashes at either end.
+func validatePathRules(rules PathRules) (PathRules, error) {
+	var clean PathRules
+
+	seen := make(map[string]bool)
+	for _, glob := range rules.AllowedPaths {
+		glob = strings.TrimSpace(glob)
+		if glob == "" || seen[glob] {
+			continue
+		}
+		if err := validatePathRule("allowed path", glob); err != nil {
+			return PathRules{}, err
+		}
+		if strings.HasPrefix(glob, "/") {
+			return PathRules{}, fmt.Errorf("%w: allowed path %q must be relative to the vault", ErrInvalidWebhookKey, glob)
+		}
+		// Compiling now caches the glob for matching events
+		if _, err := models.CompilePathGlob(glob); err != nil {
+			return PathRules{}, fmt.Errorf("%w: %v", ErrInvalidWebhookKey, err)
+		}
+		seen[glob] = true
+		clean.AllowedPaths = append(clean.AllowedPaths, glob)
+	}
+	if len(clean.AllowedPaths) > MaxWebhookKeyAllowedPaths {
+		return PathRules{}, fmt.Errorf("%w: at most %d allowed paths", ErrInvalidWebhookKey, MaxWebhookKeyAllowedPaths)
+	}
+
+	if rules.ForcedPathPrefix != nil {
+		prefix := strings.Trim(strings.TrimSpace(*rules.ForcedPathPrefix), "/")
+		if prefix != "" {
+			if err := validatePathRule("forced path prefix", prefix); err != nil {
+				return PathRules{}, err
+			}
+			if strings.ContainsAny(prefix, "*?") {
+				return PathRules{}, fmt.Errorf("%w: forced path prefix must be a folder, not a pattern", ErrInvalidWebhookKey)
+			}
+			clean.ForcedPathPrefix = &prefix
+		}
+	}
+
+	return clean, nil
+}
+
+// validatePathRule applies the checks shared by allowed paths and the forced prefix
+func validatePathRule(what, rule string) error {
+	switch {
+	case len(rule) > MaxWebhookKeyPathRuleLen:
+		return fmt.Errorf("%w: %s must be at most %d characters", ErrInvalidWebhookKey, what, MaxWebhookKeyPathRuleLen)
+	case strings.Contains(rule, ".."):
+		return fmt.Errorf("%w: %s %q must not contain \"..\"", ErrInvalidWebhookKey, what, rule)
+	case strings.ContainsAny(rule, "\n\r"):
+		return fmt.Errorf("%w: %s must be on one line", ErrInvalidWebhookKey, what)
+	}
 	return nil
 }
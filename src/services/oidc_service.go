@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/oidc"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// Single sign-on flows: who a sign-in started at the provider is for
+const (
+	SSOFlowUser  = "user"
+	SSOFlowAdmin = "admin"
+)
+
+// SSOStateTTL is how long a user has to finish signing in at the provider
+const SSOStateTTL = 10 * time.Minute
+
+// ssoStatePurpose marks state tokens, so no session check accepts them
+const ssoStatePurpose = "oidc_state"
+
+// OIDCService signs users and admins in through an OpenID Connect provider,
+// matching the provider's verified email to existing accounts
+type OIDCService struct {
+	provider  *oidc.Provider
+	auth      *AuthService
+	admins    *AdminService // nil = admins sign in with their password only
+	jwtSecret string
+
+	autoProvision  bool
+	allowedDomains []string
+}
+
+// NewOIDCService creates a single sign-on service; state tokens are signed with jwtSecret
+func NewOIDCService(provider *oidc.Provider, auth *AuthService, jwtSecret string) *OIDCService {
+	return &OIDCService{provider: provider, auth: auth, jwtSecret: jwtSecret}
+}
+
+// SetAdmins lets admins sign in through the provider too
+func (s *OIDCService) SetAdmins(admins *AdminService) {
+	s.admins = admins
+}
+
+// AdminsEnabled reports whether admins can sign in through the provider
+func (s *OIDCService) AdminsEnabled() bool {
+	return s.admins != nil
+}
+
+// SetAutoProvision creates an account with a key pair for a user's first
+// sign-in, instead of turning away emails without one
+func (s *OIDCService) SetAutoProvision(enabled bool) {
+	s.autoProvision = enabled
+}
+
+// SetAllowedDomains limits sign-in to emails at these domains; none allows any
+func (s *OIDCService) SetAllowedDomains(domains []string) {
+	s.allowedDomains = nil
+	for _, d := range domains {
+		if d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@")); d != "" {
+			s.allowedDomains = append(s.allowedDomains, d)
+		}
+	}
+}
+
+// ssoStateClaims is the state cookie: what the callback checks the
+// provider's answer against
+type ssoStateClaims struct {
+	Flow     string `json:"flow"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Purpose  string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+// Begin starts a sign-in for flow. It returns the provider URL to send the
+// browser to, and a signed state token for the browser to bring back.
+func (s *OIDCService) Begin(ctx context.Context, flow string) (authURL, stateToken string, err error) {
+	if flow != SSOFlowUser && (flow != SSOFlowAdmin || s.admins == nil) {
+		return "", "", fmt.Errorf("unknown sign-in flow %q", flow)
+	}
+
+	claims := ssoStateClaims{
+		Flow:    flow,
+		Purpose: ssoStatePurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(SSOStateTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	for _, v := range []*string{&claims.State, &claims.Nonce, &claims.Verifier} {
+		if *v, err = oidc.NewRandomString(); err != nil {
+			return "", "", fmt.Errorf("failed to generate sign-in state: %w", err)
+		}
+	}
+
+	authURL, err = s.provider.AuthCodeURL(ctx, claims.State, claims.Nonce, claims.Verifier)
+	if err != nil {
+		return "", "", err
+	}
+	stateToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.jwtSecret))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to sign sign-in state: %w", err)
+	}
+	return authURL, stateToken, nil
+}
+
+// SSOIdentity is a user the provider vouched for
+type SSOIdentity struct {
+	Flow    string
+	Subject string
+	Email   string
+	Name    string
+}
+
+// Finish completes a sign-in from the provider's callback: it checks state
+// against the state token from Begin, exchanges code for an ID token and
+// verifies it. The email must be verified by the provider and at an allowed domain.
+func (s *OIDCService) Finish(ctx context.Context, stateToken, state, code string) (*SSOIdentity, error) {
+	var claims ssoStateClaims
+	_, err := jwt.ParseWithClaims(stateToken, &claims, func(token *jwt.Token) (any, error) {
+		return []byte(s.jwtSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || claims.Purpose != ssoStatePurpose || state == "" ||
+		subtle.ConstantTimeCompare([]byte(claims.State), []byte(state)) != 1 {
+		return nil, ErrSSOStateInvalid
+	}
+
+	idToken, err := s.provider.Exchange(ctx, code, claims.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	verified, err := s.provider.VerifyIDToken(ctx, idToken, claims.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	email := strings.TrimSpace(verified.Email)
+	if email == "" || !verified.EmailVerified {
+		return nil, fmt.Errorf("%w: the provider did not vouch for an email address", ErrSSOEmailNotAllowed)
+	}
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	if len(s.allowedDomains) > 0 && !slices.Contains(s.allowedDomains, domain) {
+		return nil, fmt.Errorf("%w: %s is not at an allowed domain", ErrSSOEmailNotAllowed, email)
+	}
+
+	return &SSOIdentity{Flow: claims.Flow, Subject: verified.Subject, Email: email, Name: verified.Name}, nil
+}
+
+// ProvisionedKeyPair is the key pair created for a user's first sign-in;
+// like every key, it is only available once
+type ProvisionedKeyPair struct {
+	WebhookKey string
+	ClientKey  string
+}
+
+// SignInUser matches identity to a user account. For an email without an
+// account it creates one with a key pair when auto-provisioning is on, and
+// returns ErrUserNotFound otherwise.
+func (s *OIDCService) SignInUser(ctx context.Context, identity *SSOIdentity) (*ProvisionedKeyPair, error) {
+	exists, _, err := s.auth.GetUserByEmail(ctx, identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, nil
+	}
+	if !s.autoProvision {
+		return nil, ErrUserNotFound
+	}
+
+	webhookKey, clientKey, err := s.auth.CreateUserKeyPair(ctx, identity.Email, identity.Name, "en")
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision account: %w", err)
+	}
+	return &ProvisionedKeyPair{WebhookKey: webhookKey, ClientKey: clientKey}, nil
+}
+
+// SignInAdmin returns the active admin whose username is identity's email.
+// Two-factor authentication, where on, is still up to the caller.
+func (s *OIDCService) SignInAdmin(ctx context.Context, identity *SSOIdentity) (*models.AdminUser, error) {
+	if s.admins == nil {
+		return nil, ErrAdminNotFound
+	}
+	admin, err := s.admins.GetAdminByUsername(ctx, identity.Email)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrAdminNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up admin: %w", err)
+	}
+	if !admin.IsActive || admin.InvitePending() {
+		return nil, ErrAdminNotFound
+	}
+	return admin, nil
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/oidc"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/oidc/oidctest"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+const testSSOSecret = "test-secret-that-is-long-enough-32"
+
+// setupOIDCService creates a single sign-on service that can sign in users
+// and admins, backed by a test provider
+func setupOIDCService(t *testing.T) (*OIDCService, *oidctest.Provider) {
+	t.Helper()
+	ts := memory.NewTestStore(t)
+	idp := oidctest.NewProvider(t, "webhooks", "secret")
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "webhooks",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/oidc/callback",
+	})
+	auth := NewAuthService(ts.Repos.Users, ts.Repos.Keys, ts.Repos.AuthTokens, ts.KeyHasher, testSSOSecret, 3600, "http://localhost")
+	sso := NewOIDCService(provider, auth, testSSOSecret)
+	sso.SetAdmins(NewAdminService(ts.Repos.Admins))
+	return sso, idp
+}
+
+// ssoSignIn runs a sign-in for flow through the provider, as the browser would
+func ssoSignIn(t *testing.T, sso *OIDCService, idp *oidctest.Provider, flow string) (*SSOIdentity, error) {
+	t.Helper()
+	ctx := context.Background()
+	authURL, stateToken, err := sso.Begin(ctx, flow)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	callback := idp.Authorize(t, authURL).Query()
+	return sso.Finish(ctx, stateToken, callback.Get("state"), callback.Get("code"))
+}
+
+func TestOIDCService_SignInUser(t *testing.T) {
+	ctx := context.Background()
+	sso, idp := setupOIDCService(t)
+	idp.SetUser(oidctest.User{Subject: "1", Email: "Alice@Example.com", EmailVerified: true, Name: "Alice"})
+
+	identity, err := ssoSignIn(t, sso, idp, SSOFlowUser)
+	if err != nil {
+		t.Fatalf("sign-in failed: %v", err)
+	}
+	if identity.Flow != SSOFlowUser || identity.Email != "Alice@Example.com" || identity.Subject != "1" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	if _, err := sso.SignInUser(ctx, identity); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound without auto-provisioning, got %v", err)
+	}
+
+	sso.SetAutoProvision(true)
+	keys, err := sso.SignInUser(ctx, identity)
+	if err != nil || keys == nil || keys.WebhookKey == "" || keys.ClientKey == "" {
+		t.Fatalf("expected a provisioned key pair, got %+v (%v)", keys, err)
+	}
+	if exists, _, _ := sso.auth.GetUserByEmail(ctx, identity.Email); !exists {
+		t.Error("expected the account to be created")
+	}
+
+	keys, err = sso.SignInUser(ctx, identity)
+	if err != nil || keys != nil {
+		t.Errorf("expected an existing account to sign in without new keys, got %+v (%v)", keys, err)
+	}
+}
+
+func TestOIDCService_FinishChecksEmail(t *testing.T) {
+	sso, idp := setupOIDCService(t)
+
+	idp.SetUser(oidctest.User{Subject: "1", Email: "alice@example.com", EmailVerified: false})
+	if _, err := ssoSignIn(t, sso, idp, SSOFlowUser); !errors.Is(err, ErrSSOEmailNotAllowed) {
+		t.Errorf("expected an unverified email to be refused, got %v", err)
+	}
+
+	idp.SetUser(oidctest.User{Subject: "1", EmailVerified: true})
+	if _, err := ssoSignIn(t, sso, idp, SSOFlowUser); !errors.Is(err, ErrSSOEmailNotAllowed) {
+		t.Errorf("expected a missing email to be refused, got %v", err)
+	}
+
+	sso.SetAllowedDomains([]string{" @Corp.example ", ""})
+	idp.SetUser(oidctest.User{Subject: "1", Email: "alice@example.com", EmailVerified: true})
+	if _, err := ssoSignIn(t, sso, idp, SSOFlowUser); !errors.Is(err, ErrSSOEmailNotAllowed) {
+		t.Errorf("expected a domain outside the list to be refused, got %v", err)
+	}
+	idp.SetUser(oidctest.User{Subject: "1", Email: "alice@CORP.example", EmailVerified: true})
+	if _, err := ssoSignIn(t, sso, idp, SSOFlowUser); err != nil {
+		t.Errorf("expected an allowed domain to sign in, got %v", err)
+	}
+}
+
+func TestOIDCService_FinishChecksState(t *testing.T) {
+	ctx := context.Background()
+	sso, idp := setupOIDCService(t)
+	authURL, stateToken, err := sso.Begin(ctx, SSOFlowUser)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	callback := idp.Authorize(t, authURL).Query()
+	_, otherToken, _ := sso.Begin(ctx, SSOFlowUser)
+
+	for name, tc := range map[string]struct{ stateToken, state string }{
+		"no state cookie":      {"", callback.Get("state")},
+		"another sign-in":      {otherToken, callback.Get("state")},
+		"no state":             {stateToken, ""},
+		"tampered state token": {stateToken + "x", callback.Get("state")},
+	} {
+		if _, err := sso.Finish(ctx, tc.stateToken, tc.state, callback.Get("code")); !errors.Is(err, ErrSSOStateInvalid) {
+			t.Errorf("%s: expected ErrSSOStateInvalid, got %v", name, err)
+		}
+	}
+
+	// A session token signed with the same secret is not a state token
+	session, _ := sso.auth.GenerateSessionToken("alice@example.com", uuid.Nil, 1)
+	if _, err := sso.Finish(ctx, session, callback.Get("state"), callback.Get("code")); !errors.Is(err, ErrSSOStateInvalid) {
+		t.Errorf("expected a session token to be refused as state, got %v", err)
+	}
+}
+
+func TestOIDCService_SignInAdmin(t *testing.T) {
+	ctx := context.Background()
+	sso, idp := setupOIDCService(t)
+	admin, err := sso.admins.CreateAdminUser(ctx, "alice@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("CreateAdminUser failed: %v", err)
+	}
+	if _, _, err := sso.admins.InviteAdmin(ctx, "bob@example.com", models.AdminRoleSupport); err != nil {
+		t.Fatalf("InviteAdmin failed: %v", err)
+	}
+
+	identity, err := ssoSignIn(t, sso, idp, SSOFlowAdmin)
+	if err != nil {
+		t.Fatalf("sign-in failed: %v", err)
+	}
+	if identity.Flow != SSOFlowAdmin {
+		t.Errorf("expected the admin flow back, got %q", identity.Flow)
+	}
+	got, err := sso.SignInAdmin(ctx, identity)
+	if err != nil || got.ID != admin.ID {
+		t.Fatalf("expected alice's admin account, got %+v (%v)", got, err)
+	}
+
+	for _, email := range []string{"carol@example.com", "bob@example.com"} {
+		if _, err := sso.SignInAdmin(ctx, &SSOIdentity{Flow: SSOFlowAdmin, Email: email}); !errors.Is(err, ErrAdminNotFound) {
+			t.Errorf("%s: expected ErrAdminNotFound, got %v", email, err)
+		}
+	}
+
+	sso.SetAdmins(nil)
+	if _, _, err := sso.Begin(ctx, SSOFlowAdmin); err == nil {
+		t.Error("expected the admin flow to be refused while admins are not enabled")
+	}
+}
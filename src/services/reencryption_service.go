@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// ReencryptionResult summarises a pass over the events table
+type ReencryptionResult struct {
+	Scanned       int64 // events read
+	Reencrypted   int64 // events rewritten under the active key
+	Failed        int64 // events or data keys that could not be decrypted or saved
+	RewrappedKeys int64 // data keys rewrapped under the active master key
+}
+
+// ReencryptionService rewrites stored event data that is not encrypted with the
+// active key: data under a retired key, headerless legacy ciphertext and
+// plaintext stored before encryption was enabled. Once a pass finishes with no
+// failures, retired keys can be removed and ENCRYPTION_STRICT turned on.
+//
+// With data keys set, the pass first rewraps data keys under the active master
+// key and then moves events onto their pair's data key; events already under a
+// data key are left alone, so later master key rotations never rewrite events.
+type ReencryptionService struct {
+	events    repositories.EventRepository
+	encryptor *Encryptor
+	dataKeys  *DataKeyService
+	batchSize int
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewReencryptionService creates a re-encryption job; batchSize 0 = DefaultCleanupBatchSize
+func NewReencryptionService(events repositories.EventRepository, encryptor *Encryptor, batchSize int) *ReencryptionService {
+	if batchSize <= 0 {
+		batchSize = DefaultCleanupBatchSize
+	}
+	return &ReencryptionService{events: events, encryptor: encryptor, batchSize: batchSize}
+}
+
+// SetDataKeys makes the pass rewrap data keys and migrate events onto them
+func (s *ReencryptionService) SetDataKeys(dataKeys *DataKeyService) {
+	s.dataKeys = dataKeys
+}
+
+// Start runs one pass in the background. It returns immediately and does
+// nothing when encryption is disabled.
+func (s *ReencryptionService) Start(ctx context.Context) {
+	if s.encryptor == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.mu.Unlock()
+		return
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		result, err := s.Run(ctx)
+		switch {
+		case err != nil:
+			log.Printf("Re-encryption stopped after %d events (%d re-encrypted, %d failed, %d data keys rewrapped): %v",
+				result.Scanned, result.Reencrypted, result.Failed, result.RewrappedKeys, err)
+		case result.Failed > 0:
+			log.Printf("Re-encryption finished: %d of %d events re-encrypted, %d data keys rewrapped, %d failed",
+				result.Reencrypted, result.Scanned, result.RewrappedKeys, result.Failed)
+		default:
+			log.Printf("Re-encryption finished: %d of %d events re-encrypted, %d data keys rewrapped; all data is under master key %q",
+				result.Reencrypted, result.Scanned, result.RewrappedKeys, s.encryptor.ActiveKeyID())
+		}
+	}()
+}
+
+// Stop cancels a running pass and waits for the current event to be saved.
+// It is safe to call when the service was never started.
+func (s *ReencryptionService) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.cancel = nil
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	s.wg.Wait()
+}
+
+// Run walks every event in ID order and re-encrypts data that needs it.
+// Events that fail are logged and counted; only read errors and
+// cancellation stop the pass.
+func (s *ReencryptionService) Run(ctx context.Context) (ReencryptionResult, error) {
+	var result ReencryptionResult
+	if s.encryptor == nil {
+		return result, nil
+	}
+
+	if s.dataKeys != nil {
+		rewrapped, failed, err := s.dataKeys.RewrapAll(ctx, s.batchSize)
+		result.RewrappedKeys = rewrapped
+		result.Failed += failed
+		if err != nil {
+			return result, err
+		}
+	}
+
+	after := uuid.Nil
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		events, err := s.events.ListAfter(ctx, after, s.batchSize)
+		if err != nil {
+			return result, err
+		}
+
+		for _, event := range events {
+			result.Scanned++
+			// Offloaded payloads are sealed chunk by chunk with the pair's data key
+			if event.BlobRef != "" || !s.needsReencryption(event.Data) {
+				continue
+			}
+
+			data, err := s.reencrypt(ctx, event)
+			if err == nil {
+				err = s.events.UpdateData(ctx, event.ID, data)
+			}
+			switch {
+			case err == nil:
+				result.Reencrypted++
+			case errors.Is(err, repositories.ErrNotFound):
+				// Deleted by cleanup or the user since it was read
+			default:
+				result.Failed++
+				log.Printf("Failed to re-encrypt event %s: %v", event.ID, err)
+			}
+		}
+
+		if len(events) < s.batchSize {
+			return result, nil
+		}
+		after = events[len(events)-1].ID
+	}
+}
+
+// needsReencryption reports whether event data should be rewritten
+func (s *ReencryptionService) needsReencryption(data []byte) bool {
+	if s.dataKeys != nil {
+		return s.dataKeys.NeedsReencryption(data)
+	}
+	return s.encryptor.NeedsReencryption(data)
+}
+
+// reencrypt rewrites event data under the pair's data key or the active master key
+func (s *ReencryptionService) reencrypt(ctx context.Context, event models.Event) ([]byte, error) {
+	if s.dataKeys != nil {
+		return s.dataKeys.Reencrypt(ctx, event.WebhookKeyID, event.Data)
+	}
+	return s.encryptor.Reencrypt(event.Data)
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+func TestReencryptionService_Run(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+
+	webhookKeyID, _, _, _, err := ts.CreateTestKeyPair(0, "")
+	if err != nil {
+		t.Fatalf("CreateTestKeyPair failed: %v", err)
+	}
+
+	old, _ := NewEncryptor(validHexKey())
+	underOldKey, _ := old.Encrypt([]byte("old key"))
+	stored := [][]byte{
+		underOldKey,
+		legacyEncrypt(t, validHexKey(), []byte("legacy")),
+		[]byte(`{"title":"plaintext"}`),
+	}
+	var ids []uuid.UUID
+	for _, data := range stored {
+		eventID, err := ts.CreateTestEvent(webhookKeyID, "note.md", data)
+		if err != nil {
+			t.Fatalf("CreateTestEvent failed: %v", err)
+		}
+		ids = append(ids, uuid.MustParse(eventID))
+	}
+
+	rotated, _ := NewEncryptorWithKeyring(EncryptorConfig{
+		Keys: []EncryptionKey{
+			{ID: "new", Hex: otherHexKey()},
+			{ID: DefaultEncryptionKeyID, Hex: validHexKey()},
+		},
+		Strict: true,
+	})
+	// A batch size of 2 exercises paging
+	result, err := NewReencryptionService(ts.Repos.Events, rotated, 2).Run(ctx)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Scanned != 3 || result.Reencrypted != 3 || result.Failed != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	want := []string{"old key", "legacy", `{"title":"plaintext"}`}
+	for i, id := range ids {
+		event, _ := ts.Repos.Events.GetByID(ctx, id)
+		if KeyID(event.Data) != "new" {
+			t.Errorf("event %d not under the active key", i)
+		}
+		decrypted, err := rotated.Decrypt(event.Data)
+		if err != nil || string(decrypted) != want[i] {
+			t.Errorf("event %d: got %q, %v", i, decrypted, err)
+		}
+	}
+
+	// A second pass has nothing left to do
+	result, _ = NewReencryptionService(ts.Repos.Events, rotated, 2).Run(ctx)
+	if result.Scanned != 3 || result.Reencrypted != 0 {
+		t.Fatalf("unexpected second pass: %+v", result)
+	}
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2026, time.March, 14, 10, 30, 0, 0, time.UTC) // Saturday
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"6h", base.Add(6 * time.Hour)},
+		{"@hourly", time.Date(2026, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2026, time.March, 15, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, time.March, 14, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2026, time.March, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		// Restricted day of month and day of week match on either
+		{"0 0 20 * 1", time.Date(2026, time.March, 16, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseSchedule failed: %v", err)
+			}
+			if got := s.Next(base); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", base, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "soon", "10s", "* * * *", "60 * * * *", "0 0 * 13 *", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestCronSchedule_ImpossibleDate(t *testing.T) {
+	s, err := ParseSchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatalf("ParseSchedule failed: %v", err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Expected no next run for February 31st, got %s", next)
+	}
+}
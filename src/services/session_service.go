@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+)
+
+// Session lifetimes, matching the session cookies
+const (
+	UserSessionTTL  = 30 * 24 * time.Hour
+	AdminSessionTTL = 24 * time.Hour
+)
+
+// SessionCacheTTL is how long a validated session is trusted without going
+// back to the database. Revocations made through this process apply at once;
+// ones made by another replica apply within this window.
+const SessionCacheTTL = 30 * time.Second
+
+// maxCachedSessions bounds the validation cache; it is emptied when full
+const maxCachedSessions = 10000
+
+// maxUserAgentLen matches the sessions.user_agent column
+const maxUserAgentLen = 512
+
+// cachedSession is a validated session and when it was read from the database
+type cachedSession struct {
+	session   models.Session
+	checkedAt time.Time
+}
+
+// SessionService manages server-side sessions for dashboard users and admins
+type SessionService struct {
+	repo  repositories.SessionRepository
+	users repositories.UserRepository
+
+	mu    sync.Mutex
+	cache map[uuid.UUID]cachedSession
+	// revocations counts revocations, so Validate can tell one happened
+	// while it was reading the database and not cache a stale entry
+	revocations uint64
+}
+
+// NewSessionService creates a new session service
+func NewSessionService(repo repositories.SessionRepository, users repositories.UserRepository) *SessionService {
+	return &SessionService{
+		repo:  repo,
+		users: users,
+		cache: make(map[uuid.UUID]cachedSession),
+	}
+}
+
+// StartUserSession records a dashboard sign-in for the user with email
+func (s *SessionService) StartUserSession(ctx context.Context, email, ip, userAgent string) (*models.Session, error) {
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	return s.start(ctx, &user.ID, nil, ip, userAgent, UserSessionTTL)
+}
+
+// StartAdminSession records an admin panel sign-in
+func (s *SessionService) StartAdminSession(ctx context.Context, adminID uuid.UUID, ip, userAgent string) (*models.Session, error) {
+	return s.start(ctx, nil, &adminID, ip, userAgent, AdminSessionTTL)
+}
+
+func (s *SessionService) start(ctx context.Context, userID, adminID *uuid.UUID, ip, userAgent string, ttl time.Duration) (*models.Session, error) {
+	if len(userAgent) > maxUserAgentLen {
+		userAgent = userAgent[:maxUserAgentLen]
+	}
+	now := time.Now()
+	session := &models.Session{
+		ID:         uuid.New(),
+		UserID:     userID,
+		AdminID:    adminID,
+		IP:         ip,
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	if err := s.repo.Create(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+	return session, nil
+}
+
+// Validate returns the session with id if it is still active. Unknown,
+// revoked and expired sessions all return ErrSessionInvalid.
+func (s *SessionService) Validate(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	if id == uuid.Nil {
+		return nil, ErrSessionInvalid
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	cached, ok := s.cache[id]
+	revocations := s.revocations
+	s.mu.Unlock()
+
+	session := cached.session
+	if !ok || now.Sub(cached.checkedAt) >= SessionCacheTTL {
+		stored, err := s.repo.Get(ctx, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			s.forget(id)
+			return nil, ErrSessionInvalid
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up session: %w", err)
+		}
+		session = *stored
+		cached = cachedSession{session: session, checkedAt: now}
+	}
+	if !session.IsActive(now) {
+		s.forget(id)
+		return nil, ErrSessionInvalid
+	}
+
+	if now.Sub(session.LastSeenAt) >= lastUsedResolution {
+		if err := s.repo.TouchLastSeen(ctx, id, now); err != nil {
+			log.Printf("Failed to record session activity for %s: %v", id, err)
+		}
+		session.LastSeenAt = now
+		cached.session = session
+	}
+
+	s.mu.Lock()
+	if s.revocations == revocations {
+		if len(s.cache) >= maxCachedSessions {
+			clear(s.cache)
+		}
+		s.cache[id] = cached
+	}
+	s.mu.Unlock()
+
+	return &session, nil
+}
+
+// End revokes a session on sign-out; a session that is already gone is not an error
+func (s *SessionService) End(ctx context.Context, id uuid.UUID) error {
+	err := s.repo.Revoke(ctx, id)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	s.revoked(id)
+	return nil
+}
+
+// ListUserSessions returns a user's active sessions, most recently seen first
+func (s *SessionService) ListUserSessions(ctx context.Context, email string) ([]models.Session, error) {
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	sessions, err := s.repo.ListActiveByUser(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeUserSession signs one of a user's sessions out
+func (s *SessionService) RevokeUserSession(ctx context.Context, email string, id uuid.UUID) error {
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	err = s.repo.RevokeUserSession(ctx, id, user.ID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	s.revoked(id)
+	return nil
+}
+
+// RevokeAllUserSessions signs a user out everywhere and returns how many
+// sessions were ended
+func (s *SessionService) RevokeAllUserSessions(ctx context.Context, email string) (int64, error) {
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	revoked, err := s.repo.RevokeAllByUser(ctx, user.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	s.mu.Lock()
+	for id, cached := range s.cache {
+		if cached.session.UserID != nil && *cached.session.UserID == user.ID {
+			delete(s.cache, id)
+		}
+	}
+	s.revocations++
+	s.mu.Unlock()
+	return revoked, nil
+}
+
+// RevokeAllAdminSessions signs an admin out everywhere, for example after a
+// role change, and returns how many sessions were ended
+func (s *SessionService) RevokeAllAdminSessions(ctx context.Context, adminID uuid.UUID) (int64, error) {
+	revoked, err := s.repo.RevokeAllByAdmin(ctx, adminID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	s.mu.Lock()
+	for id, cached := range s.cache {
+		if cached.session.AdminID != nil && *cached.session.AdminID == adminID {
+			delete(s.cache, id)
+		}
+	}
+	s.revocations++
+	s.mu.Unlock()
+	return revoked, nil
+}
+
+// forget drops a session that failed validation from the validation cache
+func (s *SessionService) forget(id uuid.UUID) {
+	s.mu.Lock()
+	delete(s.cache, id)
+	s.mu.Unlock()
+}
+
+// revoked drops a revoked session from the validation cache and keeps
+// validations already in flight from caching it again
+func (s *SessionService) revoked(id uuid.UUID) {
+	s.mu.Lock()
+	delete(s.cache, id)
+	s.revocations++
+	s.mu.Unlock()
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/models"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories"
+	"github.com/khabaroff/obsidian-webhooks-selfhosted/src/repositories/memory"
+)
+
+func newSessionTestService(t *testing.T, emails ...string) (*SessionService, *memory.TestStore) {
+	t.Helper()
+	ts := memory.NewTestStore(t)
+	for _, email := range emails {
+		if err := ts.Repos.Users.Upsert(context.Background(), &models.UserProfile{Email: email}); err != nil {
+			t.Fatalf("Upsert failed: %v", err)
+		}
+	}
+	return NewSessionService(ts.Repos.Sessions, ts.Repos.Users), ts
+}
+
+func TestSessionService_ValidateAndCache(t *testing.T) {
+	s, ts := newSessionTestService(t, "alice@example.com")
+	ctx := context.Background()
+
+	session, err := s.StartUserSession(ctx, "alice@example.com", "192.0.2.1", "Firefox")
+	if err != nil {
+		t.Fatalf("StartUserSession failed: %v", err)
+	}
+	if _, err := s.StartUserSession(ctx, "nobody@example.com", "", ""); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for an unknown user, got %v", err)
+	}
+
+	if _, err := s.Validate(ctx, session.ID); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	for _, id := range []uuid.UUID{uuid.Nil, uuid.New()} {
+		if _, err := s.Validate(ctx, id); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("Validate(%s): expected ErrSessionInvalid, got %v", id, err)
+		}
+	}
+
+	// A revocation made elsewhere is picked up once the cache entry is stale
+	if err := ts.Repos.Sessions.Revoke(ctx, session.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := s.Validate(ctx, session.ID); err != nil {
+		t.Errorf("expected the cached session to stay valid, got %v", err)
+	}
+	s.forget(session.ID)
+	if _, err := s.Validate(ctx, session.ID); !errors.Is(err, ErrSessionInvalid) {
+		t.Errorf("expected ErrSessionInvalid after the cache entry is dropped, got %v", err)
+	}
+}
+
+// revokingSessionRepo runs afterGet once a session has been read, standing in
+// for a sign-out that lands while Validate is waiting on the database
+type revokingSessionRepo struct {
+	repositories.SessionRepository
+	afterGet func()
+}
+
+func (r *revokingSessionRepo) Get(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	session, err := r.SessionRepository.Get(ctx, id)
+	if afterGet := r.afterGet; afterGet != nil {
+		r.afterGet = nil
+		afterGet()
+	}
+	return session, err
+}
+
+// TestSessionService_RevokeDuringValidate verifies a revocation made while
+// Validate reads the database is not undone by caching what it read
+func TestSessionService_RevokeDuringValidate(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+	if err := ts.Repos.Users.Upsert(ctx, &models.UserProfile{Email: "alice@example.com"}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	repo := &revokingSessionRepo{SessionRepository: ts.Repos.Sessions}
+	s := NewSessionService(repo, ts.Repos.Users)
+
+	session, err := s.StartUserSession(ctx, "alice@example.com", "192.0.2.1", "Firefox")
+	if err != nil {
+		t.Fatalf("StartUserSession failed: %v", err)
+	}
+	repo.afterGet = func() {
+		if err := s.End(ctx, session.ID); err != nil {
+			t.Errorf("End failed: %v", err)
+		}
+	}
+	if _, err := s.Validate(ctx, session.ID); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if _, err := s.Validate(ctx, session.ID); !errors.Is(err, ErrSessionInvalid) {
+		t.Errorf("expected ErrSessionInvalid after the concurrent sign-out, got %v", err)
+	}
+}
+
+// TestSessionService_InvalidCookiesKeepCaching verifies failed validations,
+// such as requests with made-up session cookies, don't stop valid sessions
+// from being cached
+func TestSessionService_InvalidCookiesKeepCaching(t *testing.T) {
+	ts := memory.NewTestStore(t)
+	ctx := context.Background()
+	if err := ts.Repos.Users.Upsert(ctx, &models.UserProfile{Email: "alice@example.com"}); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	repo := &revokingSessionRepo{SessionRepository: ts.Repos.Sessions}
+	s := NewSessionService(repo, ts.Repos.Users)
+
+	session, err := s.StartUserSession(ctx, "alice@example.com", "192.0.2.1", "Firefox")
+	if err != nil {
+		t.Fatalf("StartUserSession failed: %v", err)
+	}
+	repo.afterGet = func() {
+		if _, err := s.Validate(ctx, uuid.New()); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("expected ErrSessionInvalid for an unknown session, got %v", err)
+		}
+	}
+	if _, err := s.Validate(ctx, session.ID); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	// Served from the cache, so a revocation made elsewhere isn't seen yet
+	if err := ts.Repos.Sessions.Revoke(ctx, session.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := s.Validate(ctx, session.ID); err != nil {
+		t.Errorf("expected the session to have been cached, got %v", err)
+	}
+}
+
+func TestSessionService_RevokeIsImmediate(t *testing.T) {
+	s, _ := newSessionTestService(t, "alice@example.com", "bob@example.com")
+	ctx := context.Background()
+
+	laptop, _ := s.StartUserSession(ctx, "alice@example.com", "192.0.2.1", "Firefox")
+	phone, _ := s.StartUserSession(ctx, "alice@example.com", "192.0.2.2", "Safari")
+	bob, _ := s.StartUserSession(ctx, "bob@example.com", "192.0.2.3", "Chrome")
+	for _, session := range []*models.Session{laptop, phone, bob} {
+		if _, err := s.Validate(ctx, session.ID); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+	}
+
+	if err := s.RevokeUserSession(ctx, "bob@example.com", laptop.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound revoking another user's session, got %v", err)
+	}
+	if err := s.RevokeUserSession(ctx, "alice@example.com", laptop.ID); err != nil {
+		t.Fatalf("RevokeUserSession failed: %v", err)
+	}
+	if _, err := s.Validate(ctx, laptop.ID); !errors.Is(err, ErrSessionInvalid) {
+		t.Errorf("expected the revoked session to be rejected at once, got %v", err)
+	}
+
+	sessions, err := s.ListUserSessions(ctx, "alice@example.com")
+	if err != nil || len(sessions) != 1 || sessions[0].ID != phone.ID {
+		t.Fatalf("expected only the phone to be listed, got %+v (%v)", sessions, err)
+	}
+
+	revoked, err := s.RevokeAllUserSessions(ctx, "alice@example.com")
+	if err != nil || revoked != 1 {
+		t.Fatalf("expected 1 revoked session, got %d (%v)", revoked, err)
+	}
+	if _, err := s.Validate(ctx, phone.ID); !errors.Is(err, ErrSessionInvalid) {
+		t.Errorf("expected ErrSessionInvalid after signing out everywhere, got %v", err)
+	}
+	if _, err := s.Validate(ctx, bob.ID); err != nil {
+		t.Errorf("expected other users' sessions to survive, got %v", err)
+	}
+
+	if err := s.End(ctx, bob.ID); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+	if err := s.End(ctx, bob.ID); err != nil {
+		t.Errorf("expected ending a revoked session to succeed, got %v", err)
+	}
+	if _, err := s.Validate(ctx, bob.ID); !errors.Is(err, ErrSessionInvalid) {
+		t.Errorf("expected ErrSessionInvalid after End, got %v", err)
+	}
+}
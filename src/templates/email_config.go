@@ -41,6 +41,8 @@ type EmailConfig struct {
 		Welcome         string `yaml:"welcome"`
 		PasswordReset   string `yaml:"password_reset"`
 		AccountVerified string `yaml:"account_verified"`
+		DeliveryAlert   string `yaml:"delivery_alert"`
+		DeliveryDigest  string `yaml:"delivery_digest"`
 	} `yaml:"subjects"`
 
 	MagicLink struct {
@@ -63,6 +65,17 @@ type EmailConfig struct {
 		Features         []Feature `yaml:"features"`
 		Steps            []string  `yaml:"steps"`
 	} `yaml:"welcome"`
+
+	DeliveryAlert struct {
+		Greeting         string `yaml:"greeting"`
+		Intro            string `yaml:"intro"`
+		ButtonText       string `yaml:"button_text"`
+		RetentionNote    string `yaml:"retention_note"`
+		OptOutText       string `yaml:"opt_out_text"`
+		DigestGreeting   string `yaml:"digest_greeting"`
+		DigestIntro      string `yaml:"digest_intro"`
+		DigestButtonText string `yaml:"digest_button_text"`
+	} `yaml:"delivery_alert"`
 }
 
 // Feature represents a feature block in welcome email
@@ -148,6 +161,39 @@ type WelcomeData struct {
 	BorderColor  string
 }
 
+// DeliveryAlertData holds data for the stuck delivery email, sent to a key
+// owner or, as a digest over every owner's keys, to admins
+type DeliveryAlertData struct {
+	// Alert data
+	Keys      []DeliveryAlertKey
+	ButtonURL string
+
+	// Config-based data
+	BrandName     string
+	Tagline       string
+	Website       string
+	Greeting      string
+	Intro         string
+	ButtonText    string
+	RetentionNote string
+	OptOutText    string // empty in the admin digest
+
+	// Design colors
+	PrimaryColor string
+	TextColor    string
+	MutedColor   string
+	LightBg      string
+	BorderColor  string
+}
+
+// DeliveryAlertKey is one webhook key listed in a stuck delivery email
+type DeliveryAlertKey struct {
+	Label       string // key name, or its prefix
+	Owner       string // owner's email, shown in the admin digest only
+	Undelivered int
+	Waiting     string // how long the oldest event has waited, e.g. "3 days"
+}
+
 // RenderMagicLinkHTML renders magic link HTML template (always English)
 func RenderMagicLinkHTML(data MagicLinkData, language string) (string, error) {
 	tmplData, err := emailTemplates.ReadFile("emails/magic-link.html")
@@ -232,3 +278,43 @@ func RenderWelcomeText(data WelcomeData, language string) (string, error) {
 
 	return buf.String(), nil
 }
+
+// RenderDeliveryAlertHTML renders the stuck delivery HTML template (always English)
+func RenderDeliveryAlertHTML(data DeliveryAlertData) (string, error) {
+	tmplData, err := emailTemplates.ReadFile("emails/delivery-alert.html")
+	if err != nil {
+		return "", fmt.Errorf("failed to read delivery-alert.html: %w", err)
+	}
+
+	tmpl, err := template.New("delivery-alert").Parse(string(tmplData))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse delivery-alert template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute delivery-alert template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// RenderDeliveryAlertText renders the stuck delivery plain text template (always English)
+func RenderDeliveryAlertText(data DeliveryAlertData) (string, error) {
+	tmplData, err := emailTemplates.ReadFile("emails/delivery-alert.txt")
+	if err != nil {
+		return "", fmt.Errorf("failed to read delivery-alert.txt: %w", err)
+	}
+
+	tmpl, err := textTemplate.New("delivery-alert-text").Parse(string(tmplData))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse delivery-alert text template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute delivery-alert text template: %w", err)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,92 @@
+package totp
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHOTP_RFC6238Vectors checks the test vectors from RFC 6238 appendix B
+func TestHOTP_RFC6238Vectors(t *testing.T) {
+	seed20 := []byte("12345678901234567890")
+	seed32 := []byte("12345678901234567890123456789012")
+	seed64 := []byte("1234567890123456789012345678901234567890123456789012345678901234")
+	algorithms := []struct {
+		name string
+		h    func() hash.Hash
+		seed []byte
+	}{
+		{"SHA1", sha1.New, seed20},
+		{"SHA256", sha256.New, seed32},
+		{"SHA512", sha512.New, seed64},
+	}
+	vectors := []struct {
+		unix  int64
+		codes [3]string
+	}{
+		{59, [3]string{"94287082", "46119246", "90693936"}},
+		{1111111109, [3]string{"07081804", "68084774", "25091201"}},
+		{1111111111, [3]string{"14050471", "67062674", "99943326"}},
+		{1234567890, [3]string{"89005924", "91819424", "93441116"}},
+		{2000000000, [3]string{"69279037", "90698825", "38618901"}},
+		{20000000000, [3]string{"65353130", "77737706", "47863826"}},
+	}
+
+	for _, v := range vectors {
+		for i, alg := range algorithms {
+			got := hotp(alg.h, alg.seed, step(time.Unix(v.unix, 0)), 8)
+			if got != v.codes[i] {
+				t.Errorf("%s at %d: got %s, want %s", alg.name, v.unix, got, v.codes[i])
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+
+	// Six-digit codes are the last six digits of the RFC vector
+	if got := Code(secret, now); got != "081804" {
+		t.Fatalf("Code: got %s, want 081804", got)
+	}
+	if !Validate(secret, "081 804", now) {
+		t.Error("Expected the current code to be valid")
+	}
+	if !Validate(secret, Code(secret, now.Add(-Period)), now) || !Validate(secret, Code(secret, now.Add(Period)), now) {
+		t.Error("Expected codes one step away to be valid")
+	}
+	if Validate(secret, Code(secret, now.Add(-2*Period)), now) {
+		t.Error("Expected a code two steps old to be rejected")
+	}
+	for _, code := range []string{"", "08180", "0818040", "abcdef"} {
+		if Validate(secret, code, now) {
+			t.Errorf("Expected %q to be rejected", code)
+		}
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Obsidian Webhooks", "admin", []byte("12345678901234567890"))
+	want := []string{
+		"otpauth://totp/Obsidian%20Webhooks:admin?",
+		"secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		"issuer=Obsidian+Webhooks",
+		"digits=6",
+		"period=30",
+	}
+	for _, part := range want {
+		if !strings.Contains(uri, part) {
+			t.Errorf("Expected %q in %s", part, uri)
+		}
+	}
+
+	secret, err := NewSecret()
+	if err != nil || len(secret) != SecretLen {
+		t.Fatalf("NewSecret: %d bytes, %v", len(secret), err)
+	}
+}